@@ -0,0 +1,110 @@
+package apikeys
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/tarent/loginsrv/logging"
+	"github.com/tarent/loginsrv/login"
+	"github.com/tarent/loginsrv/model"
+)
+
+// ProviderName const
+const ProviderName = "apikeys"
+
+// DefaultExpiry is the maximum lifetime of tokens issued for api keys
+const DefaultExpiry = 15 * time.Minute
+
+func init() {
+	login.RegisterProvider(
+		&login.ProviderDescription{
+			Name:     ProviderName,
+			HelpText: "Api key login backend for service accounts opts: file=/path/to/keyfile.json,expiry=15m",
+		},
+		BackendFactory)
+}
+
+// BackendFactory creates an api key backend
+func BackendFactory(config map[string]string) (login.Backend, error) {
+	file, exist := config["file"]
+	if !exist || file == "" {
+		return nil, errors.New(`missing parameter "file" for apikeys provider`)
+	}
+
+	expiry := DefaultExpiry
+	if e, exist := config["expiry"]; exist {
+		var err error
+		expiry, err = time.ParseDuration(e)
+		if err != nil {
+			return nil, fmt.Errorf("invalid expiry for apikeys provider: %v", err)
+		}
+		if expiry <= 0 {
+			return nil, errors.New("expiry for apikeys provider has to be positive")
+		}
+	}
+
+	return NewBackend(file, expiry)
+}
+
+// Backend authenticates service accounts by key id and secret.
+// The issued tokens carry the claims of the key, are not refreshable
+// and expire after the configured expiry at the latest.
+type Backend struct {
+	keys   *Keys
+	expiry time.Duration
+}
+
+// NewBackend creates a new Backend and reads the key file.
+func NewBackend(filename string, expiry time.Duration) (*Backend, error) {
+	keys, err := NewKeys(filename)
+	if err != nil {
+		return nil, err
+	}
+	return &Backend{
+		keys:   keys,
+		expiry: expiry,
+	}, nil
+}
+
+// Authenticate the key id and secret
+func (b *Backend) Authenticate(keyID, secret string) (bool, model.UserInfo, error) {
+	if err := b.keys.reloadIfChanged(); err != nil {
+		logging.Logger.WithError(err).Warn("error reloading api key file, keeping the current keys")
+	}
+
+	key, exist := b.keys.Get(keyID)
+	if !exist {
+		// verified anyway, so that the response time does not tell, which key ids exist
+		b.keys.Dummy().Verify(secret)
+		return false, model.UserInfo{}, nil
+	}
+	if !key.Verify(secret) {
+		return false, model.UserInfo{}, nil
+	}
+	if key.Disabled {
+		logging.Logger.WithField("key_id", keyID).Warn("login with disabled api key")
+		return false, model.UserInfo{}, nil
+	}
+
+	logging.Logger.WithField("key_id", keyID).WithField("sub", key.Sub).Info("login with api key")
+	return true, model.UserInfo{
+		Sub:       key.Sub,
+		Groups:    key.Groups,
+		Audience:  key.Audience,
+		Origin:    ProviderName,
+		Expiry:    time.Now().Add(b.expiry).Unix(),
+		NoRefresh: true,
+	}, nil
+}
+
+// AuthenticateClient authenticates the key id and secret as client of the client_credentials grant
+func (b *Backend) AuthenticateClient(ctx context.Context, clientID, clientSecret string) (bool, model.UserInfo, error) {
+	return b.Authenticate(clientID, clientSecret)
+}
+
+// AuthenticateWithContext the key id and secret
+func (b *Backend) AuthenticateWithContext(ctx context.Context, keyID, secret string) (bool, model.UserInfo, error) {
+	return b.Authenticate(keyID, secret)
+}
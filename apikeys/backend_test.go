@@ -0,0 +1,143 @@
+package apikeys
+
+import (
+	. "github.com/stretchr/testify/assert"
+	"github.com/tarent/loginsrv/login"
+	"golang.org/x/crypto/bcrypt"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+// the secret for all keys is 'secret'
+const testfile = `{
+  "deploy-1": {
+    "secret": "$2y$05$Hw6y1sFwh6CdwiPOKFMYj..xVSQWI3wzyQvt5th392ig8RLmeLU.6",
+    "sub": "svc-deploy",
+    "groups": ["deploy"],
+    "audience": "ci"
+  },
+  "old-1": {
+    "secret": "$2y$05$Hw6y1sFwh6CdwiPOKFMYj..xVSQWI3wzyQvt5th392ig8RLmeLU.6",
+    "sub": "svc-old",
+    "disabled": true
+  }
+}`
+
+func TestSetup(t *testing.T) {
+	p, exist := login.GetProvider(ProviderName)
+	True(t, exist)
+
+	file := writeTmpfile(testfile)
+	defer os.Remove(file)
+
+	backend, err := p(map[string]string{"file": file})
+	NoError(t, err)
+	Equal(t, DefaultExpiry, backend.(*Backend).expiry)
+
+	backend, err = p(map[string]string{"file": file, "expiry": "5m"})
+	NoError(t, err)
+	Equal(t, 5*time.Minute, backend.(*Backend).expiry)
+
+	_, err = p(map[string]string{})
+	Error(t, err)
+
+	_, err = p(map[string]string{"file": file, "expiry": "-1m"})
+	Error(t, err)
+}
+
+func TestSetup_InvalidFile(t *testing.T) {
+	_, err := NewBackend("/tmp/foo/bar/nothing", DefaultExpiry)
+	Error(t, err)
+
+	for _, content := range []string{
+		`not json`,
+		`{"k": {"secret": "$2y$05$Hw6y1sFwh6CdwiPOKFMYj..xVSQWI3wzyQvt5th392ig8RLmeLU.6"}}`,
+		`{"k": {"secret": "plaintext", "sub": "svc"}}`,
+	} {
+		file := writeTmpfile(content)
+		_, err := NewBackend(file, DefaultExpiry)
+		Error(t, err, content)
+		os.Remove(file)
+	}
+}
+
+func TestBackend_Authenticate(t *testing.T) {
+	file := writeTmpfile(testfile)
+	defer os.Remove(file)
+
+	backend, err := NewBackend(file, time.Minute)
+	NoError(t, err)
+
+	authenticated, userInfo, err := backend.Authenticate("deploy-1", "secret")
+	NoError(t, err)
+	True(t, authenticated)
+	Equal(t, "svc-deploy", userInfo.Sub)
+	Equal(t, []string{"deploy"}, userInfo.Groups)
+	Equal(t, "ci", userInfo.Audience)
+	Equal(t, ProviderName, userInfo.Origin)
+	True(t, userInfo.NoRefresh)
+	InDelta(t, time.Now().Add(time.Minute).Unix(), userInfo.Expiry, 2)
+
+	authenticated, _, err = backend.Authenticate("deploy-1", "wrong")
+	NoError(t, err)
+	False(t, authenticated)
+
+	authenticated, _, err = backend.Authenticate("unknown", "secret")
+	NoError(t, err)
+	False(t, authenticated)
+
+	// unknown key ids are verified against a dummy of the same cost
+	dummy := backend.keys.Dummy()
+	cost, err := bcrypt.Cost([]byte(dummy.Secret))
+	NoError(t, err)
+	Equal(t, 5, cost)
+	False(t, dummy.Verify("secret"))
+	False(t, dummy.Verify(""))
+
+	// disabled keys are rejected
+	authenticated, _, err = backend.Authenticate("old-1", "secret")
+	NoError(t, err)
+	False(t, authenticated)
+}
+
+func TestBackend_ReloadFile(t *testing.T) {
+	file := writeTmpfile(testfile)
+	defer os.Remove(file)
+
+	backend, err := NewBackend(file, time.Minute)
+	NoError(t, err)
+
+	// disable the key
+	err = ioutil.WriteFile(file, []byte(`{"deploy-1": {"secret": "$2y$05$Hw6y1sFwh6CdwiPOKFMYj..xVSQWI3wzyQvt5th392ig8RLmeLU.6", "sub": "svc-deploy", "disabled": true}}`), 0600)
+	NoError(t, err)
+	os.Chtimes(file, time.Now(), time.Now().Add(time.Second))
+
+	authenticated, _, err := backend.Authenticate("deploy-1", "secret")
+	NoError(t, err)
+	False(t, authenticated)
+
+	// a broken file keeps the current keys
+	err = ioutil.WriteFile(file, []byte(`{"broken`), 0600)
+	NoError(t, err)
+	os.Chtimes(file, time.Now(), time.Now().Add(2*time.Second))
+
+	_, exist := backend.keys.Get("deploy-1")
+	True(t, exist)
+	authenticated, _, err = backend.Authenticate("deploy-1", "secret")
+	NoError(t, err)
+	False(t, authenticated)
+}
+
+func writeTmpfile(content string) string {
+	f, err := ioutil.TempFile("", "loginsrv_apikeystest")
+	if err != nil {
+		panic(err)
+	}
+	defer f.Close()
+	if _, err := f.WriteString(content); err != nil {
+		panic(err)
+	}
+	return f.Name()
+}
@@ -0,0 +1,137 @@
+package apikeys
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Key is the definition of an api key for a service account.
+// The secret is stored as bcrypt hash, the remaining fields are the claims of the issued tokens.
+type Key struct {
+	Secret   string   `json:"secret"`
+	Sub      string   `json:"sub"`
+	Groups   []string `json:"groups,omitempty"`
+	Audience string   `json:"audience,omitempty"`
+	Disabled bool     `json:"disabled,omitempty"`
+}
+
+// Keys holds the api keys of a key file and reloads them, if the file changes.
+type Keys struct {
+	filename string
+	modTime  time.Time
+	keys     map[string]Key
+	// dummy is verified for unknown key ids, with the highest cost of the keys
+	dummy Key
+	mu    sync.RWMutex
+}
+
+// NewKeys reads the key file
+func NewKeys(filename string) (*Keys, error) {
+	k := &Keys{filename: filename}
+	return k, k.parse()
+}
+
+func (k *Keys) parse() error {
+	fileInfo, err := os.Stat(k.filename)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Open(k.filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	keys := map[string]Key{}
+	if err := json.NewDecoder(f).Decode(&keys); err != nil {
+		return fmt.Errorf("api key file in wrong format (%v): %v", k.filename, err)
+	}
+	cost := bcrypt.MinCost
+	for id, key := range keys {
+		if key.Sub == "" {
+			return fmt.Errorf("missing sub for api key %q (%v)", id, k.filename)
+		}
+		if !strings.HasPrefix(key.Secret, "$2y$") && !strings.HasPrefix(key.Secret, "$2b$") && !strings.HasPrefix(key.Secret, "$2a$") {
+			return fmt.Errorf("secret of api key %q is not a bcrypt hash (%v)", id, k.filename)
+		}
+		if c, err := bcrypt.Cost([]byte(key.Secret)); err == nil && c > cost {
+			cost = c
+		}
+	}
+	dummy, err := k.dummyWithCost(cost)
+	if err != nil {
+		return err
+	}
+
+	k.mu.Lock()
+	k.keys = keys
+	k.dummy = dummy
+	k.modTime = fileInfo.ModTime()
+	k.mu.Unlock()
+	return nil
+}
+
+// dummyWithCost returns a key with a random secret, which costs as much to verify as the keys.
+// The current one is kept, if the cost did not change.
+func (k *Keys) dummyWithCost(cost int) (Key, error) {
+	k.mu.RLock()
+	current := k.dummy
+	k.mu.RUnlock()
+	if c, err := bcrypt.Cost([]byte(current.Secret)); err == nil && c == cost {
+		return current, nil
+	}
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return Key{}, err
+	}
+	hash, err := bcrypt.GenerateFromPassword(secret, cost)
+	if err != nil {
+		return Key{}, err
+	}
+	return Key{Secret: string(hash)}, nil
+}
+
+// reloadIfChanged reloads the key file, if it was modified.
+// On errors, the current keys are retained.
+func (k *Keys) reloadIfChanged() error {
+	fileInfo, err := os.Stat(k.filename)
+	if err != nil {
+		return err
+	}
+	k.mu.RLock()
+	changed := !fileInfo.ModTime().Equal(k.modTime)
+	k.mu.RUnlock()
+	if changed {
+		return k.parse()
+	}
+	return nil
+}
+
+// Get returns the key with the supplied id
+func (k *Keys) Get(id string) (Key, bool) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	key, exist := k.keys[id]
+	return key, exist
+}
+
+// Dummy returns a key, which never matches, but takes as long to verify as the keys.
+// It is verified for unknown key ids, so that the response time does not tell, which key ids exist.
+func (k *Keys) Dummy() Key {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	return k.dummy
+}
+
+// Verify checks the secret against the hash of the key
+func (key Key) Verify(secret string) bool {
+	return bcrypt.CompareHashAndPassword([]byte(key.Secret), []byte(secret)) == nil
+}
@@ -0,0 +1,212 @@
+package awskms
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tarent/loginsrv/logging"
+	"github.com/tarent/loginsrv/outboundtls"
+)
+
+// CredentialsSource provides the credentials for each request to aws.
+// Temporary credentials are refreshed by the source before they expire.
+type CredentialsSource interface {
+	Credentials(ctx context.Context) (Credentials, error)
+}
+
+// Credentials are static credentials, which never change.
+func (c Credentials) Credentials(ctx context.Context) (Credentials, error) {
+	return c, nil
+}
+
+// credentialsRefreshMargin is the time before the expiry, in which temporary credentials are refreshed
+var credentialsRefreshMargin = 5 * time.Minute
+
+// containerCredentialsHost is the host of the ecs credentials endpoint for AWS_CONTAINER_CREDENTIALS_RELATIVE_URI
+const containerCredentialsHost = "http://169.254.170.2"
+
+// DefaultCredentials returns the source of the credentials configured by the standard aws environment variables,
+// in the order of the aws sdks:
+// static credentials of AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY,
+// web identity credentials of AWS_WEB_IDENTITY_TOKEN_FILE and AWS_ROLE_ARN, e.g. of eks,
+// and container credentials of AWS_CONTAINER_CREDENTIALS_RELATIVE_URI or AWS_CONTAINER_CREDENTIALS_FULL_URI, e.g. of ecs.
+// The region is used for the sts endpoint of the web identity.
+func DefaultCredentials(region string) (CredentialsSource, error) {
+	if os.Getenv("AWS_ACCESS_KEY_ID") != "" {
+		creds, err := CredentialsFromEnv()
+		if err != nil {
+			return nil, err
+		}
+		if creds.SessionToken != "" {
+			logging.Logger.Warn("the aws session token of the environment can not be refreshed, signing fails after its expiry. Use web identity or container credentials instead")
+		}
+		return creds, nil
+	}
+	if tokenFile := os.Getenv("AWS_WEB_IDENTITY_TOKEN_FILE"); tokenFile != "" {
+		roleARN := os.Getenv("AWS_ROLE_ARN")
+		if roleARN == "" {
+			return nil, errors.New("missing aws credentials: AWS_WEB_IDENTITY_TOKEN_FILE is set, but AWS_ROLE_ARN is missing")
+		}
+		endpoint := "https://sts.amazonaws.com/"
+		if region != "" {
+			endpoint = fmt.Sprintf("https://sts.%v.amazonaws.com/", region)
+		}
+		return NewWebIdentityCredentials(endpoint, roleARN, os.Getenv("AWS_ROLE_SESSION_NAME"), tokenFile), nil
+	}
+	if relative := os.Getenv("AWS_CONTAINER_CREDENTIALS_RELATIVE_URI"); relative != "" {
+		return NewContainerCredentials(containerCredentialsHost+relative, "", ""), nil
+	}
+	if full := os.Getenv("AWS_CONTAINER_CREDENTIALS_FULL_URI"); full != "" {
+		return NewContainerCredentials(full, os.Getenv("AWS_CONTAINER_AUTHORIZATION_TOKEN"), os.Getenv("AWS_CONTAINER_AUTHORIZATION_TOKEN_FILE")), nil
+	}
+	return nil, errors.New("missing aws credentials: set AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY, AWS_WEB_IDENTITY_TOKEN_FILE and AWS_ROLE_ARN or AWS_CONTAINER_CREDENTIALS_RELATIVE_URI")
+}
+
+// refreshingCredentials caches temporary credentials and fetches new ones shortly before they expire.
+// If the refresh fails, the cached credentials are used until their expiry.
+type refreshingCredentials struct {
+	name  string
+	fetch func(ctx context.Context) (Credentials, time.Time, error)
+
+	mu      sync.Mutex
+	creds   Credentials
+	expires time.Time
+	now     func() time.Time
+}
+
+func (r *refreshingCredentials) Credentials(ctx context.Context) (Credentials, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := r.now()
+	if now.Before(r.expires.Add(-credentialsRefreshMargin)) {
+		return r.creds, nil
+	}
+	creds, expires, err := r.fetch(ctx)
+	if err == nil && (creds.AccessKeyID == "" || creds.SecretAccessKey == "") {
+		err = errors.New("the response contains no credentials")
+	}
+	if err != nil {
+		if now.Before(r.expires) {
+			logging.Logger.WithError(err).Warnf("error refreshing the aws %v credentials, using the current ones until their expiry", r.name)
+			return r.creds, nil
+		}
+		return Credentials{}, fmt.Errorf("error getting the aws %v credentials: %v", r.name, err)
+	}
+	r.creds, r.expires = creds, expires
+	return creds, nil
+}
+
+// NewWebIdentityCredentials returns the credentials of the role, which are assumed with the web identity token of the file.
+// The file is read on each refresh, because the token is rotated.
+func NewWebIdentityCredentials(stsEndpoint, roleARN, sessionName, tokenFile string) CredentialsSource {
+	if sessionName == "" {
+		sessionName = "loginsrv"
+	}
+	client := outboundtls.Client(0)
+	return &refreshingCredentials{
+		name: "web identity",
+		now:  time.Now,
+		fetch: func(ctx context.Context) (Credentials, time.Time, error) {
+			token, err := ioutil.ReadFile(tokenFile)
+			if err != nil {
+				return Credentials{}, time.Time{}, err
+			}
+			form := url.Values{
+				"Action":           {"AssumeRoleWithWebIdentity"},
+				"Version":          {"2011-06-15"},
+				"RoleArn":          {roleARN},
+				"RoleSessionName":  {sessionName},
+				"WebIdentityToken": {strings.TrimSpace(string(token))},
+			}
+			r, err := http.NewRequest("POST", stsEndpoint, strings.NewReader(form.Encode()))
+			if err != nil {
+				return Credentials{}, time.Time{}, err
+			}
+			r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			body, err := doCredentialsRequest(ctx, client, r)
+			if err != nil {
+				return Credentials{}, time.Time{}, err
+			}
+			var resp struct {
+				Credentials struct {
+					AccessKeyID     string    `xml:"AccessKeyId"`
+					SecretAccessKey string    `xml:"SecretAccessKey"`
+					SessionToken    string    `xml:"SessionToken"`
+					Expiration      time.Time `xml:"Expiration"`
+				} `xml:"AssumeRoleWithWebIdentityResult>Credentials"`
+			}
+			if err := xml.Unmarshal(body, &resp); err != nil {
+				return Credentials{}, time.Time{}, fmt.Errorf("error parsing the sts response: %v", err)
+			}
+			c := resp.Credentials
+			return Credentials{AccessKeyID: c.AccessKeyID, SecretAccessKey: c.SecretAccessKey, SessionToken: c.SessionToken}, c.Expiration, nil
+		},
+	}
+}
+
+// NewContainerCredentials returns the credentials of the container credentials endpoint, e.g. of ecs.
+// The authorization token is sent, if set, otherwise the content of the token file, if set.
+func NewContainerCredentials(endpoint, authToken, authTokenFile string) CredentialsSource {
+	client := outboundtls.Client(0)
+	return &refreshingCredentials{
+		name: "container",
+		now:  time.Now,
+		fetch: func(ctx context.Context) (Credentials, time.Time, error) {
+			r, err := http.NewRequest("GET", endpoint, nil)
+			if err != nil {
+				return Credentials{}, time.Time{}, err
+			}
+			token := authToken
+			if token == "" && authTokenFile != "" {
+				b, err := ioutil.ReadFile(authTokenFile)
+				if err != nil {
+					return Credentials{}, time.Time{}, err
+				}
+				token = strings.TrimSpace(string(b))
+			}
+			if token != "" {
+				r.Header.Set("Authorization", token)
+			}
+			body, err := doCredentialsRequest(ctx, client, r)
+			if err != nil {
+				return Credentials{}, time.Time{}, err
+			}
+			var resp struct {
+				AccessKeyID     string `json:"AccessKeyId"`
+				SecretAccessKey string
+				Token           string
+				Expiration      time.Time
+			}
+			if err := json.Unmarshal(body, &resp); err != nil {
+				return Credentials{}, time.Time{}, fmt.Errorf("error parsing the container credentials: %v", err)
+			}
+			return Credentials{AccessKeyID: resp.AccessKeyID, SecretAccessKey: resp.SecretAccessKey, SessionToken: resp.Token}, resp.Expiration, nil
+		},
+	}
+}
+
+func doCredentialsRequest(ctx context.Context, client *http.Client, r *http.Request) ([]byte, error) {
+	resp, err := client.Do(r.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != 200 {
+		return nil, fmt.Errorf("http status %v", resp.StatusCode)
+	}
+	return body, nil
+}
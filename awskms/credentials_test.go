@@ -0,0 +1,153 @@
+package awskms
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	. "github.com/stretchr/testify/assert"
+)
+
+func Test_RefreshingCredentials(t *testing.T) {
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	var fetches int
+	var fetchErr error
+	r := &refreshingCredentials{
+		name: "test",
+		now:  func() time.Time { return now },
+		fetch: func(ctx context.Context) (Credentials, time.Time, error) {
+			fetches++
+			if fetchErr != nil {
+				return Credentials{}, time.Time{}, fetchErr
+			}
+			return Credentials{AccessKeyID: fmt.Sprintf("id-%v", fetches), SecretAccessKey: "secret"}, now.Add(time.Hour), nil
+		},
+	}
+
+	creds, err := r.Credentials(context.Background())
+	NoError(t, err)
+	Equal(t, "id-1", creds.AccessKeyID)
+
+	// cached until shortly before the expiry
+	now = now.Add(50 * time.Minute)
+	creds, _ = r.Credentials(context.Background())
+	Equal(t, "id-1", creds.AccessKeyID)
+	now = now.Add(6 * time.Minute)
+	creds, _ = r.Credentials(context.Background())
+	Equal(t, "id-2", creds.AccessKeyID)
+
+	// a failed refresh keeps the current credentials until their expiry
+	fetchErr = errors.New("sts unavailable")
+	now = now.Add(58 * time.Minute)
+	creds, err = r.Credentials(context.Background())
+	NoError(t, err)
+	Equal(t, "id-2", creds.AccessKeyID)
+	now = now.Add(2 * time.Minute)
+	_, err = r.Credentials(context.Background())
+	EqualError(t, err, "error getting the aws test credentials: sts unavailable")
+}
+
+func Test_WebIdentityCredentials(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "awskms")
+	defer os.RemoveAll(dir)
+	tokenFile := filepath.Join(dir, "token")
+	NoError(t, ioutil.WriteFile(tokenFile, []byte("the-web-identity-token\n"), 0600))
+
+	var calls int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&calls, 1)
+		r.ParseForm()
+		Equal(t, "AssumeRoleWithWebIdentity", r.PostForm.Get("Action"))
+		Equal(t, "arn:aws:iam::111122223333:role/loginsrv", r.PostForm.Get("RoleArn"))
+		Equal(t, "loginsrv", r.PostForm.Get("RoleSessionName"))
+		Equal(t, "the-web-identity-token", r.PostForm.Get("WebIdentityToken"))
+		fmt.Fprintf(w, `<AssumeRoleWithWebIdentityResponse xmlns="https://sts.amazonaws.com/doc/2011-06-15/">
+  <AssumeRoleWithWebIdentityResult>
+    <Credentials>
+      <AccessKeyId>ASIAEXAMPLE</AccessKeyId>
+      <SecretAccessKey>secret</SecretAccessKey>
+      <SessionToken>session</SessionToken>
+      <Expiration>%v</Expiration>
+    </Credentials>
+  </AssumeRoleWithWebIdentityResult>
+</AssumeRoleWithWebIdentityResponse>`, time.Now().Add(time.Hour).UTC().Format(time.RFC3339))
+	}))
+	defer server.Close()
+
+	source := NewWebIdentityCredentials(server.URL, "arn:aws:iam::111122223333:role/loginsrv", "", tokenFile)
+	creds, err := source.Credentials(context.Background())
+	NoError(t, err)
+	Equal(t, Credentials{AccessKeyID: "ASIAEXAMPLE", SecretAccessKey: "secret", SessionToken: "session"}, creds)
+	source.Credentials(context.Background())
+	Equal(t, int64(1), atomic.LoadInt64(&calls))
+
+	server.Close()
+	_, err = NewWebIdentityCredentials(server.URL, "arn:aws:iam::111122223333:role/loginsrv", "", tokenFile).Credentials(context.Background())
+	Error(t, err)
+}
+
+func Test_ContainerCredentials(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "the-auth-token" {
+			w.WriteHeader(401)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"AccessKeyId":     "ASIAEXAMPLE",
+			"SecretAccessKey": "secret",
+			"Token":           "session",
+			"Expiration":      time.Now().Add(time.Hour),
+		})
+	}))
+	defer server.Close()
+
+	creds, err := NewContainerCredentials(server.URL, "the-auth-token", "").Credentials(context.Background())
+	NoError(t, err)
+	Equal(t, Credentials{AccessKeyID: "ASIAEXAMPLE", SecretAccessKey: "secret", SessionToken: "session"}, creds)
+
+	_, err = NewContainerCredentials(server.URL, "", "").Credentials(context.Background())
+	EqualError(t, err, "error getting the aws container credentials: http status 401")
+}
+
+func Test_DefaultCredentials(t *testing.T) {
+	for _, name := range []string{"AWS_ACCESS_KEY_ID", "AWS_SECRET_ACCESS_KEY", "AWS_SESSION_TOKEN", "AWS_WEB_IDENTITY_TOKEN_FILE", "AWS_ROLE_ARN", "AWS_CONTAINER_CREDENTIALS_RELATIVE_URI", "AWS_CONTAINER_CREDENTIALS_FULL_URI"} {
+		if value, set := os.LookupEnv(name); set {
+			defer os.Setenv(name, value)
+		} else {
+			defer os.Unsetenv(name)
+		}
+		os.Unsetenv(name)
+	}
+
+	_, err := DefaultCredentials("eu-central-1")
+	Error(t, err)
+
+	os.Setenv("AWS_CONTAINER_CREDENTIALS_RELATIVE_URI", "/v2/credentials/abc")
+	source, err := DefaultCredentials("eu-central-1")
+	NoError(t, err)
+	IsType(t, &refreshingCredentials{}, source)
+	Equal(t, "container", source.(*refreshingCredentials).name)
+
+	os.Setenv("AWS_WEB_IDENTITY_TOKEN_FILE", "/var/run/secrets/token")
+	_, err = DefaultCredentials("eu-central-1")
+	Error(t, err, "the role is missing")
+	os.Setenv("AWS_ROLE_ARN", "arn:aws:iam::111122223333:role/loginsrv")
+	source, err = DefaultCredentials("eu-central-1")
+	NoError(t, err)
+	Equal(t, "web identity", source.(*refreshingCredentials).name)
+
+	os.Setenv("AWS_ACCESS_KEY_ID", "id")
+	os.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+	source, err = DefaultCredentials("eu-central-1")
+	NoError(t, err)
+	Equal(t, Credentials{AccessKeyID: "id", SecretAccessKey: "secret"}, source)
+}
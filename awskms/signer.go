@@ -0,0 +1,239 @@
+package awskms
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/tarent/loginsrv/clockskew"
+	"github.com/tarent/loginsrv/logging"
+	"github.com/tarent/loginsrv/outboundtls"
+)
+
+// Signer signs jwt tokens with an asymmetric aws kms key.
+// The private key never leaves kms, only the digest of the token is sent.
+type Signer struct {
+	keyID     string
+	region    string
+	endpoint  string
+	creds     CredentialsSource
+	timeout   time.Duration
+	client    *http.Client
+	method    jwt.SigningMethod
+	algorithm string
+	publicKey crypto.PublicKey
+
+	signCalls    int64
+	signErrors   int64
+	signDuration int64
+}
+
+// Stats are the counters of the sign calls against kms.
+type Stats struct {
+	Calls         int64
+	Errors        int64
+	TotalDuration time.Duration
+}
+
+// NewSigner creates a signer for the kms key (id or arn) and fetches its public key.
+// The region is taken from the key arn, if the region parameter is empty.
+// An empty endpoint uses the public kms endpoint of the region.
+// The credentials are taken from the source for each call, so that temporary credentials are refreshed.
+func NewSigner(ctx context.Context, keyID, region, endpoint string, creds CredentialsSource, timeout time.Duration) (*Signer, error) {
+	if region == "" {
+		region = regionFromARN(keyID)
+	}
+	if region == "" {
+		return nil, fmt.Errorf("no aws region for kms key %v", keyID)
+	}
+	if endpoint == "" {
+		endpoint = fmt.Sprintf("https://kms.%v.amazonaws.com/", region)
+	}
+
+	s := &Signer{
+		keyID:    keyID,
+		region:   region,
+		endpoint: endpoint,
+		creds:    creds,
+		timeout:  timeout,
+		client:   outboundtls.Client(0),
+	}
+
+	var resp struct {
+		PublicKey []byte
+		KeySpec   string
+		KeyUsage  string
+	}
+	if err := s.call(ctx, "GetPublicKey", map[string]interface{}{"KeyId": keyID}, &resp); err != nil {
+		return nil, err
+	}
+	if resp.KeyUsage != "SIGN_VERIFY" {
+		return nil, fmt.Errorf("kms key %v can not be used for signing (usage %v)", keyID, resp.KeyUsage)
+	}
+
+	publicKey, err := x509.ParsePKIXPublicKey(resp.PublicKey)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing the public key of kms key %v: %v", keyID, err)
+	}
+	switch {
+	case strings.HasPrefix(resp.KeySpec, "RSA_"):
+		s.method, s.algorithm = jwt.SigningMethodRS256, "RSASSA_PKCS1_V1_5_SHA_256"
+	case resp.KeySpec == "ECC_NIST_P256":
+		s.method, s.algorithm = jwt.SigningMethodES256, "ECDSA_SHA_256"
+	default:
+		return nil, fmt.Errorf("unsupported key spec %v of kms key %v", resp.KeySpec, keyID)
+	}
+	s.publicKey = publicKey
+	return s, nil
+}
+
+// SigningMethod of the kms key
+func (s *Signer) SigningMethod() jwt.SigningMethod {
+	return s.method
+}
+
+// VerificationKey is the public key of the kms key
+func (s *Signer) VerificationKey() interface{} {
+	return s.publicKey
+}
+
+// PublicKeys returns the public key of the kms key
+func (s *Signer) PublicKeys() []crypto.PublicKey {
+	return []crypto.PublicKey{s.publicKey}
+}
+
+// Sign the signing input of a token. Only the sha256 digest is sent to kms.
+func (s *Signer) Sign(ctx context.Context, signingInput string) ([]byte, error) {
+	start := time.Now()
+	signature, err := s.sign(ctx, signingInput)
+	duration := time.Since(start)
+
+	atomic.AddInt64(&s.signCalls, 1)
+	atomic.AddInt64(&s.signDuration, int64(duration))
+	if err != nil {
+		atomic.AddInt64(&s.signErrors, 1)
+	}
+	logging.Logger.WithField("duration", int64(duration/time.Millisecond)).Debug("signed token with kms")
+	return signature, err
+}
+
+func (s *Signer) sign(ctx context.Context, signingInput string) ([]byte, error) {
+	digest := sha256.Sum256([]byte(signingInput))
+	var resp struct {
+		Signature []byte
+	}
+	err := s.call(ctx, "Sign", map[string]interface{}{
+		"KeyId":            s.keyID,
+		"Message":          digest[:],
+		"MessageType":      "DIGEST",
+		"SigningAlgorithm": s.algorithm,
+	}, &resp)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, isECDSA := s.publicKey.(*ecdsa.PublicKey); isECDSA {
+		return ecdsaDERToJWS(resp.Signature, 32)
+	}
+	if _, isRSA := s.publicKey.(*rsa.PublicKey); !isRSA {
+		return nil, errors.New("unexpected public key type")
+	}
+	return resp.Signature, nil
+}
+
+// Stats returns the counters of the sign calls
+func (s *Signer) Stats() Stats {
+	return Stats{
+		Calls:         atomic.LoadInt64(&s.signCalls),
+		Errors:        atomic.LoadInt64(&s.signErrors),
+		TotalDuration: time.Duration(atomic.LoadInt64(&s.signDuration)),
+	}
+}
+
+func (s *Signer) call(ctx context.Context, operation string, request, response interface{}) error {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+
+	body, err := json.Marshal(request)
+	if err != nil {
+		return err
+	}
+	r, err := http.NewRequest("POST", s.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	creds, err := s.creds.Credentials(ctx)
+	if err != nil {
+		return fmt.Errorf("error on kms %v: %v", operation, err)
+	}
+	r = r.WithContext(ctx)
+	r.Header.Set("Content-Type", "application/x-amz-json-1.1")
+	r.Header.Set("X-Amz-Target", "TrentService."+operation)
+	signV4(r, body, creds, s.region, "kms", time.Now())
+
+	resp, err := s.client.Do(r)
+	if err != nil {
+		return fmt.Errorf("error on kms %v: %v", operation, err)
+	}
+	defer resp.Body.Close()
+	clockskew.ObserveResponse("awskms", resp)
+
+	respBody, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("error reading kms %v response: %v", operation, err)
+	}
+	if resp.StatusCode != 200 {
+		var kmsErr struct {
+			Type    string `json:"__type"`
+			Message string `json:"message"`
+		}
+		json.Unmarshal(respBody, &kmsErr)
+		return fmt.Errorf("kms %v failed with http status %v: %v %v", operation, resp.StatusCode, kmsErr.Type, kmsErr.Message)
+	}
+	if err := json.Unmarshal(respBody, response); err != nil {
+		return fmt.Errorf("error parsing kms %v response: %v", operation, err)
+	}
+	return nil
+}
+
+// regionFromARN returns the region of an arn like arn:aws:kms:eu-central-1:111122223333:key/...
+func regionFromARN(arn string) string {
+	parts := strings.Split(arn, ":")
+	if len(parts) < 6 || parts[0] != "arn" {
+		return ""
+	}
+	return parts[3]
+}
+
+// ecdsaDERToJWS converts an asn.1 encoded ecdsa signature
+// to the fixed length r || s format of the jws specification.
+func ecdsaDERToJWS(der []byte, size int) ([]byte, error) {
+	var sig struct {
+		R, S *big.Int
+	}
+	if _, err := asn1.Unmarshal(der, &sig); err != nil {
+		return nil, fmt.Errorf("invalid ecdsa signature from kms: %v", err)
+	}
+	if sig.R.BitLen() > size*8 || sig.S.BitLen() > size*8 {
+		return nil, errors.New("invalid ecdsa signature size from kms")
+	}
+	out := make([]byte, 2*size)
+	sig.R.FillBytes(out[:size])
+	sig.S.FillBytes(out[size:])
+	return out, nil
+}
@@ -0,0 +1,125 @@
+package awskms
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	. "github.com/stretchr/testify/assert"
+)
+
+const testKeyARN = "arn:aws:kms:eu-central-1:111122223333:key/1234abcd-12ab-34cd-56ef-1234567890ab"
+
+var testCreds = Credentials{AccessKeyID: "AKIDEXAMPLE", SecretAccessKey: "secret"}
+
+// newKMSServer emulates the kms api for one key
+func newKMSServer(t *testing.T, keySpec string, privateKey crypto.Signer) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		True(t, strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/"))
+		Contains(t, r.Header.Get("Authorization"), "/eu-central-1/kms/aws4_request")
+
+		var req struct {
+			KeyId            string
+			Message          []byte
+			MessageType      string
+			SigningAlgorithm string
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+		Equal(t, testKeyARN, req.KeyId)
+
+		switch r.Header.Get("X-Amz-Target") {
+		case "TrentService.GetPublicKey":
+			der, _ := x509.MarshalPKIXPublicKey(privateKey.Public())
+			json.NewEncoder(w).Encode(map[string]interface{}{"PublicKey": der, "KeySpec": keySpec, "KeyUsage": "SIGN_VERIFY"})
+		case "TrentService.Sign":
+			Equal(t, "DIGEST", req.MessageType)
+			var opts crypto.SignerOpts = crypto.SHA256
+			signature, err := privateKey.Sign(rand.Reader, req.Message, opts)
+			NoError(t, err)
+			json.NewEncoder(w).Encode(map[string]interface{}{"Signature": signature, "SigningAlgorithm": req.SigningAlgorithm})
+		default:
+			w.WriteHeader(400)
+			w.Write([]byte(`{"__type":"UnknownOperationException"}`))
+		}
+	}))
+}
+
+func Test_Signer(t *testing.T) {
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+
+	testCases := []struct {
+		keySpec string
+		key     crypto.Signer
+		method  jwt.SigningMethod
+	}{
+		{"ECC_NIST_P256", ecKey, jwt.SigningMethodES256},
+		{"RSA_2048", rsaKey, jwt.SigningMethodRS256},
+	}
+	for _, test := range testCases {
+		t.Run(test.keySpec, func(t *testing.T) {
+			server := newKMSServer(t, test.keySpec, test.key)
+			defer server.Close()
+
+			s, err := NewSigner(context.Background(), testKeyARN, "", server.URL, testCreds, time.Second)
+			NoError(t, err)
+			Equal(t, test.method, s.SigningMethod())
+			Equal(t, test.key.Public(), s.VerificationKey())
+
+			token := jwt.NewWithClaims(s.SigningMethod(), jwt.MapClaims{"sub": "bob"})
+			signingInput, _ := token.SigningString()
+			signature, err := s.Sign(context.Background(), signingInput)
+			NoError(t, err)
+
+			// the signature can be verified by jwt-go
+			NoError(t, s.SigningMethod().Verify(signingInput, jwt.EncodeSegment(signature), s.VerificationKey()))
+			Equal(t, int64(1), s.Stats().Calls)
+			Equal(t, int64(0), s.Stats().Errors)
+		})
+	}
+}
+
+func Test_Signer_Errors(t *testing.T) {
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+
+	_, err := NewSigner(context.Background(), "alias/no-arn", "", "", testCreds, time.Second)
+	EqualError(t, err, "no aws region for kms key alias/no-arn")
+
+	server := newKMSServer(t, "ECC_SECG_P256K1", ecKey)
+	_, err = NewSigner(context.Background(), testKeyARN, "", server.URL, testCreds, time.Second)
+	Error(t, err)
+	Contains(t, err.Error(), "unsupported key spec")
+	server.Close()
+
+	// timeout of a slow kms
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(100 * time.Millisecond)
+	}))
+	defer slow.Close()
+	_, err = NewSigner(context.Background(), testKeyARN, "", slow.URL, testCreds, 10*time.Millisecond)
+	Error(t, err)
+
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(400)
+		w.Write([]byte(`{"__type":"AccessDeniedException","message":"not allowed"}`))
+	}))
+	defer failing.Close()
+	_, err = NewSigner(context.Background(), testKeyARN, "", failing.URL, testCreds, time.Second)
+	EqualError(t, err, "kms GetPublicKey failed with http status 400: AccessDeniedException not allowed")
+}
+
+func Test_RegionFromARN(t *testing.T) {
+	Equal(t, "eu-central-1", regionFromARN(testKeyARN))
+	Equal(t, "", regionFromARN("1234abcd-12ab-34cd-56ef-1234567890ab"))
+}
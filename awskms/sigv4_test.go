@@ -0,0 +1,50 @@
+package awskms
+
+import (
+	. "github.com/stretchr/testify/assert"
+	"net/http"
+	"os"
+	"testing"
+	"time"
+)
+
+// the example from the aws documentation for signature version 4
+func Test_SignV4(t *testing.T) {
+	r, _ := http.NewRequest("GET", "https://iam.amazonaws.com/?Action=ListUsers&Version=2010-05-08", nil)
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded; charset=utf-8")
+
+	creds := Credentials{AccessKeyID: "AKIDEXAMPLE", SecretAccessKey: "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY"}
+	signV4(r, []byte{}, creds, "us-east-1", "iam", time.Date(2015, 8, 30, 12, 36, 0, 0, time.UTC))
+
+	Equal(t, "20150830T123600Z", r.Header.Get("X-Amz-Date"))
+	Equal(t, "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/iam/aws4_request, "+
+		"SignedHeaders=content-type;host;x-amz-date, "+
+		"Signature=5d672d79c15b13162d9279b0855cfba6789a8edb4c82c400e06b5924a6f2b5d7",
+		r.Header.Get("Authorization"))
+}
+
+func Test_SignV4_SessionToken(t *testing.T) {
+	r, _ := http.NewRequest("POST", "https://kms.eu-central-1.amazonaws.com/", nil)
+	creds := Credentials{AccessKeyID: "AKIDEXAMPLE", SecretAccessKey: "secret", SessionToken: "session"}
+	signV4(r, []byte("{}"), creds, "eu-central-1", "kms", time.Now())
+
+	Equal(t, "session", r.Header.Get("X-Amz-Security-Token"))
+	Contains(t, r.Header.Get("Authorization"), "SignedHeaders=host;x-amz-date;x-amz-security-token,")
+}
+
+func Test_CredentialsFromEnv(t *testing.T) {
+	os.Setenv("AWS_ACCESS_KEY_ID", "")
+	_, err := CredentialsFromEnv()
+	Error(t, err)
+
+	os.Setenv("AWS_ACCESS_KEY_ID", "id")
+	os.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+	os.Setenv("AWS_SESSION_TOKEN", "token")
+	defer os.Unsetenv("AWS_ACCESS_KEY_ID")
+	defer os.Unsetenv("AWS_SECRET_ACCESS_KEY")
+	defer os.Unsetenv("AWS_SESSION_TOKEN")
+
+	creds, err := CredentialsFromEnv()
+	NoError(t, err)
+	Equal(t, Credentials{AccessKeyID: "id", SecretAccessKey: "secret", SessionToken: "token"}, creds)
+}
@@ -4,7 +4,6 @@ import (
 	"github.com/mholt/caddy/caddyhttp/httpserver"
 	"github.com/tarent/loginsrv/login"
 	"net/http"
-	"strings"
 )
 
 // CaddyHandler is the loginsrv handler wrapper for caddy
@@ -32,7 +31,7 @@ func (h *CaddyHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) (int, e
 		repl.Set("user", userInfo.Sub)
 	}
 
-	if strings.HasPrefix(r.URL.Path, h.config.LoginPath) {
+	if h.loginHandler.IsLoginPath(r.URL.Path) {
 		h.loginHandler.ServeHTTP(w, r)
 		return 0, nil
 	}
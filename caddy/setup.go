@@ -1,6 +1,7 @@
 package caddy
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"os"
@@ -14,6 +15,7 @@ import (
 	"github.com/tarent/loginsrv/login"
 
 	// Import all backends, packaged with the caddy plugin
+	_ "github.com/tarent/loginsrv/apikeys"
 	_ "github.com/tarent/loginsrv/htpasswd"
 	_ "github.com/tarent/loginsrv/httpupstream"
 	_ "github.com/tarent/loginsrv/oauth2"
@@ -59,6 +61,15 @@ func setup(c *caddy.Controller) error {
 			return err
 		}
 
+		lifecycle := &login.Lifecycle{}
+		lifecycle.Register(loginHandler.Hooks()...)
+		c.OnStartup(lifecycle.Start)
+		c.OnShutdown(func() error {
+			ctx, cancel := context.WithTimeout(context.Background(), config.GracePeriod)
+			defer cancel()
+			return lifecycle.Stop(ctx)
+		})
+
 		httpserver.GetConfig(c).AddMiddleware(func(next httpserver.Handler) httpserver.Handler {
 			return NewCaddyHandler(next, loginHandler, config)
 		})
@@ -39,8 +39,29 @@ func TestSetup(t *testing.T) {
 						"bob": "secret",
 					},
 				},
-				Oauth:       login.Options{},
-				GracePeriod: 5 * time.Second,
+				Oauth:                 login.Options{},
+				GracePeriod:           5 * time.Second,
+				ConflictPolicy:        login.ConflictPolicyFirstMatch,
+				MaintenanceMessage:    "The login is not available due to maintenance. Please try again later.",
+				ClaimsMaxGroups:       100,
+				ClaimsMaxLength:       1024,
+				StateSnapshotInterval: time.Minute,
+				OriginOverrides:       login.Options{},
+				JwtKMSTimeout:         2 * time.Second,
+				APIVersion:            1,
+				SlowRequestLogLimit:   10,
+				JwtLegacyCookieName:   "jwt_token_legacy",
+				JwtLegacyOutput:       "cookie",
+				ClientRateLimit:       60,
+				ClockSkewThreshold:    30 * time.Second,
+				JwtAlgo:               "HS512",
+				ReadyInterval:         10 * time.Second,
+				ReadyTimeout:          2 * time.Second,
+				ReadyRise:             2,
+				ReadyFall:             3,
+				ContentNegotiation:    "auto",
+				VerifyHeaders:         "sub:X-Auth-User,email:X-Auth-Email,origin:X-Auth-Origin,groups:X-Auth-Groups",
+				ClaimsMaxSize:         4096,
 			}},
 		{
 			input: `login {
@@ -74,8 +95,29 @@ func TestSetup(t *testing.T) {
 						"client_secret": "secret",
 					},
 				},
-				Oauth:       login.Options{},
-				GracePeriod: 5 * time.Second,
+				Oauth:                 login.Options{},
+				GracePeriod:           5 * time.Second,
+				ConflictPolicy:        login.ConflictPolicyFirstMatch,
+				MaintenanceMessage:    "The login is not available due to maintenance. Please try again later.",
+				ClaimsMaxGroups:       100,
+				ClaimsMaxLength:       1024,
+				StateSnapshotInterval: time.Minute,
+				OriginOverrides:       login.Options{},
+				JwtKMSTimeout:         2 * time.Second,
+				APIVersion:            1,
+				SlowRequestLogLimit:   10,
+				JwtLegacyCookieName:   "jwt_token_legacy",
+				JwtLegacyOutput:       "cookie",
+				ClientRateLimit:       60,
+				ClockSkewThreshold:    30 * time.Second,
+				JwtAlgo:               "HS512",
+				ReadyInterval:         10 * time.Second,
+				ReadyTimeout:          2 * time.Second,
+				ReadyRise:             2,
+				ReadyFall:             3,
+				ContentNegotiation:    "auto",
+				VerifyHeaders:         "sub:X-Auth-User,email:X-Auth-Email,origin:X-Auth-Origin,groups:X-Auth-Groups",
+				ClaimsMaxSize:         4096,
 			}},
 		{ // backwards compatibility
 			// * login path as argument
@@ -98,8 +140,29 @@ func TestSetup(t *testing.T) {
 						"bob": "secret",
 					},
 				},
-				Oauth:       login.Options{},
-				GracePeriod: 5 * time.Second,
+				Oauth:                 login.Options{},
+				GracePeriod:           5 * time.Second,
+				ConflictPolicy:        login.ConflictPolicyFirstMatch,
+				MaintenanceMessage:    "The login is not available due to maintenance. Please try again later.",
+				ClaimsMaxGroups:       100,
+				ClaimsMaxLength:       1024,
+				StateSnapshotInterval: time.Minute,
+				OriginOverrides:       login.Options{},
+				JwtKMSTimeout:         2 * time.Second,
+				APIVersion:            1,
+				SlowRequestLogLimit:   10,
+				JwtLegacyCookieName:   "jwt_token_legacy",
+				JwtLegacyOutput:       "cookie",
+				ClientRateLimit:       60,
+				ClockSkewThreshold:    30 * time.Second,
+				JwtAlgo:               "HS512",
+				ReadyInterval:         10 * time.Second,
+				ReadyTimeout:          2 * time.Second,
+				ReadyRise:             2,
+				ReadyFall:             3,
+				ContentNegotiation:    "auto",
+				VerifyHeaders:         "sub:X-Auth-User,email:X-Auth-Email,origin:X-Auth-Origin,groups:X-Auth-Groups",
+				ClaimsMaxSize:         4096,
 			}},
 		{ // backwards compatibility
 			// * login path as argument
@@ -122,8 +185,29 @@ func TestSetup(t *testing.T) {
 						"bob": "secret",
 					},
 				},
-				Oauth:       login.Options{},
-				GracePeriod: 5 * time.Second,
+				Oauth:                 login.Options{},
+				GracePeriod:           5 * time.Second,
+				ConflictPolicy:        login.ConflictPolicyFirstMatch,
+				MaintenanceMessage:    "The login is not available due to maintenance. Please try again later.",
+				ClaimsMaxGroups:       100,
+				ClaimsMaxLength:       1024,
+				StateSnapshotInterval: time.Minute,
+				OriginOverrides:       login.Options{},
+				JwtKMSTimeout:         2 * time.Second,
+				APIVersion:            1,
+				SlowRequestLogLimit:   10,
+				JwtLegacyCookieName:   "jwt_token_legacy",
+				JwtLegacyOutput:       "cookie",
+				ClientRateLimit:       60,
+				ClockSkewThreshold:    30 * time.Second,
+				JwtAlgo:               "HS512",
+				ReadyInterval:         10 * time.Second,
+				ReadyTimeout:          2 * time.Second,
+				ReadyRise:             2,
+				ReadyFall:             3,
+				ContentNegotiation:    "auto",
+				VerifyHeaders:         "sub:X-Auth-User,email:X-Auth-Email,origin:X-Auth-Origin,groups:X-Auth-Groups",
+				ClaimsMaxSize:         4096,
 			}},
 
 		// error cases
@@ -144,8 +228,29 @@ func TestSetup(t *testing.T) {
 						"bob": "secret",
 					},
 				},
-				Oauth:       login.Options{},
-				GracePeriod: 5 * time.Second,
+				Oauth:                 login.Options{},
+				GracePeriod:           5 * time.Second,
+				ConflictPolicy:        login.ConflictPolicyFirstMatch,
+				MaintenanceMessage:    "The login is not available due to maintenance. Please try again later.",
+				ClaimsMaxGroups:       100,
+				ClaimsMaxLength:       1024,
+				StateSnapshotInterval: time.Minute,
+				OriginOverrides:       login.Options{},
+				JwtKMSTimeout:         2 * time.Second,
+				APIVersion:            1,
+				SlowRequestLogLimit:   10,
+				JwtLegacyCookieName:   "jwt_token_legacy",
+				JwtLegacyOutput:       "cookie",
+				ClientRateLimit:       60,
+				ClockSkewThreshold:    30 * time.Second,
+				JwtAlgo:               "HS512",
+				ReadyInterval:         10 * time.Second,
+				ReadyTimeout:          2 * time.Second,
+				ReadyRise:             2,
+				ReadyFall:             3,
+				ContentNegotiation:    "auto",
+				VerifyHeaders:         "sub:X-Auth-User,email:X-Auth-Email,origin:X-Auth-Origin,groups:X-Auth-Groups",
+				ClaimsMaxSize:         4096,
 			}},
 		{input: "login {\n}", shouldErr: true},
 		{input: "login xx yy {\n}", shouldErr: true},
@@ -178,7 +283,8 @@ func TestSetup_RelativeTemplateFile(t *testing.T) {
 	caddyfile := "loginsrv {\n  template myTemplate.tpl\n  simple bob=secret\n}"
 	root, _ := ioutil.TempDir("", "")
 	expectedPath := filepath.FromSlash(root + "/myTemplate.tpl")
-	
+	NoError(t, ioutil.WriteFile(expectedPath, []byte(`{{ template "styles" . }}`), 0644))
+
 	c := caddy.NewTestController("http", caddyfile)
 	c.Key = "RelativeTemplateFileTest"
 	config := httpserver.GetConfig(c)
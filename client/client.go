@@ -0,0 +1,264 @@
+// Package client is a go client for the http api of loginsrv.
+// It speaks version 2 of the json api and falls back to the bare jwt responses of older instances.
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/tarent/loginsrv/model"
+)
+
+const (
+	// DefaultLoginPath is the default path of the login resource
+	DefaultLoginPath = "/login"
+	// DefaultCookieName is the default name of the jwt cookie
+	DefaultCookieName = "jwt_token"
+)
+
+const (
+	apiVersionHeader = "X-Login-API-Version"
+	apiVersion       = "2"
+	// maxBodySize limits the responses, which are read into the memory
+	maxBodySize = 1 << 20
+)
+
+// Token is a jwt issued by loginsrv
+type Token struct {
+	// Value is the encoded jwt
+	Value string
+	// Expiry is the expiry of the token
+	Expiry time.Time
+	// LegacyToken is the token in the format of the previous token service version, if issued
+	LegacyToken string
+	// RefreshToken is the separate refresh token, if issued by the -jwt-refresh-token-expiry of the instance
+	RefreshToken string
+}
+
+// Expired checks, if the token is expired at the given time
+func (t Token) Expired(now time.Time) bool {
+	return !t.Expiry.IsZero() && !now.Before(t.Expiry)
+}
+
+// Client talks to the login resource of a loginsrv instance
+type Client struct {
+	baseURL    string
+	loginPath  string
+	cookieName string
+	httpClient *http.Client
+}
+
+// Option changes a setting of the client
+type Option func(c *Client)
+
+// WithHTTPClient sends the requests with the given http client, e.g. for a custom transport.
+// Redirects are never followed, as the redirects of loginsrv target browsers.
+func WithHTTPClient(httpClient *http.Client) Option {
+	return func(c *Client) {
+		c.httpClient = httpClient
+	}
+}
+
+// WithLoginPath sets the path of the login resource, if not the default /login
+func WithLoginPath(loginPath string) Option {
+	return func(c *Client) {
+		c.loginPath = loginPath
+	}
+}
+
+// WithCookieName sets the name of the jwt cookie, if not the default jwt_token
+func WithCookieName(cookieName string) Option {
+	return func(c *Client) {
+		c.cookieName = cookieName
+	}
+}
+
+// NewClient creates a client for the instance with the base url, e.g. https://sso.example.com.
+// The timeouts of the requests are taken from their contexts.
+func NewClient(baseURL string, options ...Option) *Client {
+	c := &Client{
+		baseURL:    strings.TrimSuffix(baseURL, "/"),
+		loginPath:  DefaultLoginPath,
+		cookieName: DefaultCookieName,
+	}
+	for _, o := range options {
+		o(c)
+	}
+	httpClient := http.DefaultClient
+	if c.httpClient != nil {
+		httpClient = c.httpClient
+	}
+	noRedirects := *httpClient
+	noRedirects.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+	c.httpClient = &noRedirects
+	return c
+}
+
+// Login authenticates the user with the password and returns the issued token
+func (c *Client) Login(ctx context.Context, username, password string) (Token, error) {
+	return c.postToken(ctx, map[string]string{"username": username, "password": password})
+}
+
+// Refresh returns a new token for the token. The instance has to allow refreshes, e.g. by -jwt-refreshes.
+// A separate refresh token is used instead of the token, if issued.
+func (c *Client) Refresh(ctx context.Context, token Token) (Token, error) {
+	if token.RefreshToken != "" {
+		return c.postToken(ctx, map[string]string{"token": token.RefreshToken})
+	}
+	return c.postToken(ctx, map[string]string{"token": token.Value})
+}
+
+// Logout revokes the token, so that it is rejected by the instance before its expiry.
+// Tokens without id can't be revoked, for them only the logout of the instance is called.
+func (c *Client) Logout(ctx context.Context, token Token) error {
+	claims, err := c.claims(token)
+	if err != nil {
+		return err
+	}
+	if claims.ID != "" {
+		query := url.Values{"jti": {claims.ID}}
+		resp, body, err := c.do(ctx, "DELETE", c.loginURL("/revoke?"+query.Encode()), nil, token, nil)
+		if err != nil {
+			return err
+		}
+		if resp.StatusCode != 204 {
+			return newError(resp, body)
+		}
+	}
+	resp, body, err := c.do(ctx, "DELETE", c.loginURL(""), nil, token, nil)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode >= 400 {
+		return newError(resp, body)
+	}
+	return nil
+}
+
+// Verify checks the token at the instance, including its signature and revocation
+func (c *Client) Verify(ctx context.Context, token Token) error {
+	resp, body, err := c.do(ctx, "GET", c.loginURL("/verify"), nil, token, nil)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != 200 {
+		return newError(resp, body)
+	}
+	return nil
+}
+
+// UserInfo verifies the token at the instance and returns its claims
+func (c *Client) UserInfo(ctx context.Context, token Token) (model.UserInfo, error) {
+	if err := c.Verify(ctx, token); err != nil {
+		return model.UserInfo{}, err
+	}
+	return c.claims(token)
+}
+
+// claims decodes the claims of the token. The signature can't be checked
+// without the key of the instance, so the claims are only trusted after a verify.
+func (c *Client) claims(token Token) (model.UserInfo, error) {
+	userInfo := model.UserInfo{}
+	if _, _, err := new(jwt.Parser).ParseUnverified(token.Value, &userInfo); err != nil {
+		return model.UserInfo{}, fmt.Errorf("invalid token: %v", err)
+	}
+	return userInfo, nil
+}
+
+// postToken posts the json body to the login resource and reads the token of the response
+func (c *Client) postToken(ctx context.Context, request map[string]string) (Token, error) {
+	b, err := json.Marshal(request)
+	if err != nil {
+		return Token{}, err
+	}
+	resp, body, err := c.do(ctx, "POST", c.loginURL(""), bytes.NewReader(b), Token{}, map[string]string{
+		"Content-Type": "application/json",
+		"Accept":       "application/json, application/jwt;q=0.5",
+	})
+	if err != nil {
+		return Token{}, err
+	}
+	if resp.StatusCode != 200 {
+		return Token{}, newError(resp, body)
+	}
+	return c.readToken(resp, body)
+}
+
+// readToken reads the json token object or, from instances without version 2, the bare jwt
+func (c *Client) readToken(resp *http.Response, body []byte) (Token, error) {
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if mediaType == "application/json" {
+		tokenResponse := struct {
+			Token        string `json:"token"`
+			ExpiresAt    int64  `json:"expires_at"`
+			LegacyToken  string `json:"legacy_token"`
+			RefreshToken string `json:"refresh_token"`
+		}{}
+		if err := json.Unmarshal(body, &tokenResponse); err != nil {
+			return Token{}, fmt.Errorf("invalid token response: %v", err)
+		}
+		if tokenResponse.Token == "" {
+			return Token{}, errors.New("invalid token response: missing token")
+		}
+		return Token{
+			Value:        tokenResponse.Token,
+			Expiry:       time.Unix(tokenResponse.ExpiresAt, 0),
+			LegacyToken:  tokenResponse.LegacyToken,
+			RefreshToken: tokenResponse.RefreshToken,
+		}, nil
+	}
+
+	token := Token{Value: strings.TrimSpace(string(body))}
+	if token.Value == "" {
+		return Token{}, errors.New("no token in the response")
+	}
+	claims, err := c.claims(token)
+	if err != nil {
+		return Token{}, err
+	}
+	if claims.Expiry != 0 {
+		token.Expiry = time.Unix(claims.Expiry, 0)
+	}
+	return token, nil
+}
+
+func (c *Client) loginURL(subPath string) string {
+	return c.baseURL + "/" + strings.Trim(c.loginPath, "/") + subPath
+}
+
+// do sends the request in version 2 of the api with the token as cookie, if not empty,
+// and reads the body of the response
+func (c *Client) do(ctx context.Context, method, url string, body io.Reader, token Token, header map[string]string) (*http.Response, []byte, error) {
+	req, err := http.NewRequest(method, url, body)
+	if err != nil {
+		return nil, nil, err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set(apiVersionHeader, apiVersion)
+	for k, v := range header {
+		req.Header.Set(k, v)
+	}
+	if token.Value != "" {
+		req.AddCookie(&http.Cookie{Name: c.cookieName, Value: token.Value})
+	}
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+	b, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxBodySize))
+	return resp, b, err
+}
@@ -0,0 +1,238 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	. "github.com/stretchr/testify/assert"
+	"github.com/tarent/loginsrv/login"
+)
+
+func newInstance(t *testing.T, configure func(cfg *login.Config)) *httptest.Server {
+	cfg := login.DefaultConfig()
+	cfg.JwtSecret = "secret"
+	cfg.JwtRefreshes = 10
+	cfg.Backends = login.Options{"simple": {"bob": "secret"}}
+	if configure != nil {
+		configure(cfg)
+	}
+	h, err := login.NewHandler(cfg)
+	NoError(t, err)
+	return httptest.NewServer(h)
+}
+
+func TestClient_RoundTrip(t *testing.T) {
+	server := newInstance(t, nil)
+	defer server.Close()
+	c := NewClient(server.URL)
+	ctx := context.Background()
+
+	token, err := c.Login(ctx, "bob", "secret")
+	NoError(t, err)
+	NotEmpty(t, token.Value)
+	InDelta(t, time.Now().Add(24*time.Hour).Unix(), token.Expiry.Unix(), 2)
+
+	NoError(t, c.Verify(ctx, token))
+	userInfo, err := c.UserInfo(ctx, token)
+	NoError(t, err)
+	Equal(t, "bob", userInfo.Sub)
+	Equal(t, "simple", userInfo.Origin)
+
+	refreshed, err := c.Refresh(ctx, token)
+	NoError(t, err)
+	NotEqual(t, token.Value, refreshed.Value)
+	userInfo, err = c.UserInfo(ctx, refreshed)
+	NoError(t, err)
+	Equal(t, 1, userInfo.Refreshes)
+
+	NoError(t, c.Logout(ctx, refreshed))
+	err = c.Verify(ctx, refreshed)
+	True(t, errors.Is(err, ErrUnauthenticated))
+	NoError(t, c.Verify(ctx, token))
+}
+
+func TestClient_Errors(t *testing.T) {
+	server := newInstance(t, func(cfg *login.Config) {
+		cfg.JwtRefreshes = 0
+	})
+	defer server.Close()
+	c := NewClient(server.URL)
+	ctx := context.Background()
+
+	_, err := c.Login(ctx, "bob", "wrong")
+	True(t, errors.Is(err, ErrWrongCredentials))
+	e, ok := err.(*APIError)
+	True(t, ok)
+	Equal(t, 403, e.Status)
+	Equal(t, "Wrong credentials", e.Detail)
+	Equal(t, "loginsrv: 403 wrong_credentials: Wrong credentials", e.Error())
+	False(t, errors.Is(err, ErrUnauthenticated))
+
+	token, err := c.Login(ctx, "bob", "secret")
+	NoError(t, err)
+	_, err = c.Refresh(ctx, token)
+	True(t, errors.Is(err, ErrMaxRefreshes))
+
+	err = c.Verify(ctx, Token{Value: "invalid"})
+	True(t, errors.Is(err, ErrUnauthenticated))
+
+	// unreachable instance
+	_, err = NewClient("http://127.0.0.1:1").Login(ctx, "bob", "secret")
+	Error(t, err)
+	_, isAPIError := err.(*APIError)
+	False(t, isAPIError)
+}
+
+func TestClient_LoginPathAndCookieName(t *testing.T) {
+	server := newInstance(t, func(cfg *login.Config) {
+		cfg.LoginPath = "/auth/login"
+		cfg.CookieName = "session"
+	})
+	defer server.Close()
+	c := NewClient(server.URL+"/", WithLoginPath("/auth/login"), WithCookieName("session"))
+	ctx := context.Background()
+
+	token, err := c.Login(ctx, "bob", "secret")
+	NoError(t, err)
+	NoError(t, c.Verify(ctx, token))
+
+	err = NewClient(server.URL, WithLoginPath("/auth/login")).Verify(ctx, token)
+	True(t, errors.Is(err, ErrUnauthenticated))
+}
+
+func TestClient_ContextTimeout(t *testing.T) {
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(time.Second):
+		}
+	}))
+	defer slow.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err := NewClient(slow.URL).Login(ctx, "bob", "secret")
+	Error(t, err)
+	True(t, time.Since(start) < 500*time.Millisecond)
+}
+
+func TestClient_FallbackToBareJWT(t *testing.T) {
+	// an instance, which doesn't know version 2 of the api
+	server := newInstance(t, nil)
+	defer server.Close()
+	legacy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.Header.Del("X-Login-API-Version")
+		r.Header.Set("Accept", "application/jwt")
+		req, _ := http.NewRequest(r.Method, server.URL+r.URL.String(), r.Body)
+		req.Header = r.Header
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			w.WriteHeader(502)
+			return
+		}
+		defer resp.Body.Close()
+		w.Header().Set("Content-Type", resp.Header.Get("Content-Type"))
+		w.WriteHeader(resp.StatusCode)
+		buf := make([]byte, 4096)
+		n, _ := resp.Body.Read(buf)
+		w.Write(buf[:n])
+	}))
+	defer legacy.Close()
+	c := NewClient(legacy.URL)
+	ctx := context.Background()
+
+	token, err := c.Login(ctx, "bob", "secret")
+	NoError(t, err)
+	InDelta(t, time.Now().Add(24*time.Hour).Unix(), token.Expiry.Unix(), 2)
+	NoError(t, NewClient(server.URL).Verify(ctx, token))
+
+	// plain text errors have no code
+	_, err = c.Login(ctx, "bob", "wrong")
+	e, ok := err.(*APIError)
+	True(t, ok)
+	Equal(t, 403, e.Status)
+	Equal(t, "", e.Code)
+	Equal(t, "Wrong credentials", e.Detail)
+}
+
+func TestClient_KeepFresh(t *testing.T) {
+	server := newInstance(t, func(cfg *login.Config) {
+		cfg.JwtExpiry = 2 * time.Second
+		cfg.JwtRefreshes = 1
+	})
+	defer server.Close()
+	c := NewClient(server.URL)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	token, err := c.Login(ctx, "bob", "secret")
+	NoError(t, err)
+	source := c.KeepFresh(ctx, token, 0.5)
+
+	// the first refresh succeeds, the second one is refused by the instance
+	select {
+	case <-source.Done():
+	case <-time.After(5 * time.Second):
+		Fail(t, "the refreshes did not stop")
+	}
+	current, err := source.Token()
+	True(t, errors.Is(err, ErrMaxRefreshes), fmt.Sprintf("%v", err))
+	NotEqual(t, token.Value, current.Value)
+	userInfo, err := c.claims(current)
+	NoError(t, err)
+	Equal(t, 1, userInfo.Refreshes)
+}
+
+func TestClient_KeepFresh_StopsOnCancel(t *testing.T) {
+	server := newInstance(t, nil)
+	defer server.Close()
+	c := NewClient(server.URL)
+	ctx, cancel := context.WithCancel(context.Background())
+
+	token, err := c.Login(ctx, "bob", "secret")
+	NoError(t, err)
+	source := c.KeepFresh(ctx, token, 0)
+	cancel()
+	select {
+	case <-source.Done():
+	case <-time.After(time.Second):
+		Fail(t, "the refreshes did not stop")
+	}
+	current, err := source.Token()
+	NoError(t, err)
+	Equal(t, token, current)
+}
+
+func TestRefreshWait(t *testing.T) {
+	now := time.Now()
+	Equal(t, 8*time.Minute, refreshWait(Token{Expiry: now.Add(10 * time.Minute)}, 0.2, now))
+	Equal(t, time.Duration(0), refreshWait(Token{Expiry: now.Add(-time.Minute)}, 0.2, now))
+}
+
+func TestClient_RefreshTokens(t *testing.T) {
+	server := newInstance(t, func(cfg *login.Config) {
+		cfg.JwtRefreshes = 0
+		cfg.JwtRefreshTokenExpiry = time.Hour
+	})
+	defer server.Close()
+	c := NewClient(server.URL)
+	ctx := context.Background()
+
+	token, err := c.Login(ctx, "bob", "secret")
+	NoError(t, err)
+	NotEmpty(t, token.RefreshToken)
+	refreshed, err := c.Refresh(ctx, token)
+	NoError(t, err)
+	NotEqual(t, token.RefreshToken, refreshed.RefreshToken)
+	NoError(t, c.Verify(ctx, refreshed))
+
+	// the rotated refresh token is rejected
+	_, err = c.Refresh(ctx, token)
+	Error(t, err)
+}
@@ -0,0 +1,84 @@
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/http"
+	"strings"
+)
+
+// The codes of the api errors of loginsrv, which are handled by clients most often
+const (
+	CodeWrongCredentials   = "wrong_credentials"
+	CodeUnauthenticated    = "unauthenticated"
+	CodeMaxRefreshes       = "max_refreshes_reached"
+	CodeNotRefreshable     = "not_refreshable"
+	CodeMaxSession         = "max_session_reached"
+	CodeStepUpRequired     = "step_up_required"
+	CodeLoginDenied        = "login_denied"
+	CodeRequirementNotMet  = "requirement_not_met"
+	CodeMaintenance        = "maintenance"
+	CodeUnsupportedVersion = "unsupported_api_version"
+)
+
+// APIError is a failed request to the instance. The fields are taken from the problem+json body,
+// for plain text errors of older instances, the code is empty and the detail is the text.
+type APIError struct {
+	Status int
+	Code   string
+	Title  string
+	Detail string
+}
+
+func (e *APIError) Error() string {
+	detail := e.Detail
+	if detail == "" {
+		detail = e.Title
+	}
+	if e.Code == "" {
+		return fmt.Sprintf("loginsrv: %v %v", e.Status, detail)
+	}
+	return fmt.Sprintf("loginsrv: %v %v: %v", e.Status, e.Code, detail)
+}
+
+// Is lets errors.Is match an error by its code, e.g. errors.Is(err, ErrWrongCredentials)
+func (e *APIError) Is(target error) bool {
+	t, ok := target.(*APIError)
+	return ok && t.Code != "" && t.Code == e.Code
+}
+
+// Errors with the common codes for the use with errors.Is
+var (
+	ErrWrongCredentials = &APIError{Code: CodeWrongCredentials}
+	ErrUnauthenticated  = &APIError{Code: CodeUnauthenticated}
+	ErrMaxRefreshes     = &APIError{Code: CodeMaxRefreshes}
+	ErrNotRefreshable   = &APIError{Code: CodeNotRefreshable}
+	ErrMaxSession       = &APIError{Code: CodeMaxSession}
+	ErrStepUpRequired   = &APIError{Code: CodeStepUpRequired}
+	ErrLoginDenied      = &APIError{Code: CodeLoginDenied}
+)
+
+// newError reads the error of the response
+func newError(resp *http.Response, body []byte) *APIError {
+	e := &APIError{Status: resp.StatusCode}
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if mediaType == "application/problem+json" {
+		problem := struct {
+			Title  string `json:"title"`
+			Detail string `json:"detail"`
+			Code   string `json:"code"`
+		}{}
+		if err := json.Unmarshal(body, &problem); err == nil {
+			e.Code, e.Title, e.Detail = problem.Code, problem.Title, problem.Detail
+			return e
+		}
+	}
+	if mediaType == "text/plain" {
+		e.Detail = strings.TrimSpace(string(body))
+	}
+	if e.Detail == "" {
+		e.Detail = http.StatusText(resp.StatusCode)
+	}
+	return e
+}
@@ -0,0 +1,93 @@
+package client
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// keepFreshRetry is the pause after a failed refresh, before it is tried again
+var keepFreshRetry = 5 * time.Second
+
+// defaultRemaining is the part of the lifetime, which is left at the refresh, if none is given
+const defaultRemaining = 0.2
+
+// TokenSource keeps a token fresh in the background
+type TokenSource struct {
+	mu    sync.Mutex
+	token Token
+	err   error
+	done  chan struct{}
+}
+
+// Token returns the current token. After the refreshes stopped for a final error,
+// e.g. because the maximum number of refreshes is reached, the error is returned with the last token.
+func (s *TokenSource) Token() (Token, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.token, s.err
+}
+
+// Done is closed, when the refreshes stopped
+func (s *TokenSource) Done() <-chan struct{} {
+	return s.done
+}
+
+func (s *TokenSource) set(token Token, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.token, s.err = token, err
+}
+
+// KeepFresh refreshes the token in the background, when the given part of its remaining lifetime is left,
+// e.g. 0.2 refreshes a token, which expires in 10 minutes, after 8 minutes. Values outside of (0, 1) mean 0.2.
+// Failed requests and server errors are retried until the token expires, refusals of the instance stop the refreshes.
+// The refreshes stop, when the context is canceled. Tokens without expiry are not refreshed.
+func (c *Client) KeepFresh(ctx context.Context, token Token, remaining float64) *TokenSource {
+	if remaining <= 0 || remaining >= 1 {
+		remaining = defaultRemaining
+	}
+	s := &TokenSource{token: token, done: make(chan struct{})}
+	go func() {
+		defer close(s.done)
+		retry := false
+		for !token.Expiry.IsZero() {
+			wait := refreshWait(token, remaining, time.Now())
+			if retry {
+				wait = keepFreshRetry
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(wait):
+			}
+
+			refreshed, err := c.Refresh(ctx, token)
+			if err == nil {
+				token = refreshed
+				s.set(token, nil)
+				retry = false
+				continue
+			}
+			if ctx.Err() != nil {
+				return
+			}
+			if e, ok := err.(*APIError); ok && e.Status < 500 || token.Expired(time.Now().Add(keepFreshRetry)) {
+				s.set(token, err)
+				return
+			}
+			retry = true
+		}
+	}()
+	return s
+}
+
+// refreshWait returns the time until the refresh of the token is due
+func refreshWait(token Token, remaining float64, now time.Time) time.Duration {
+	lifetime := token.Expiry.Sub(now)
+	wait := time.Duration(float64(lifetime) * (1 - remaining))
+	if wait < 0 {
+		return 0
+	}
+	return wait
+}
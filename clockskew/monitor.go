@@ -0,0 +1,119 @@
+// Package clockskew detects the difference between the local clock and the clocks
+// of the services loginsrv talks to, by the Date header of their responses.
+//
+// A skewed clock breaks logins in ways which are hard to diagnose, e.g. tokens
+// used before they are issued. The measured skew of each source is logged,
+// once it exceeds the threshold, and shown in the health response.
+package clockskew
+
+import (
+	"math"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/tarent/loginsrv/logging"
+)
+
+// DefaultThreshold is the skew, above which a warning is logged
+const DefaultThreshold = 30 * time.Second
+
+// the interval for repeating the warning about a skewed source
+var warnInterval = 10 * time.Minute
+
+// Measurement is the last measured skew of one source.
+// A positive skew means, that the clock of the source is ahead of the local clock.
+type Measurement struct {
+	Skew     float64   `json:"skew_seconds"`
+	Measured time.Time `json:"measured"`
+	Exceeded bool      `json:"exceeded"`
+
+	warned time.Time
+}
+
+// Monitor keeps the last measured skew per source
+type Monitor struct {
+	mu        sync.Mutex
+	threshold time.Duration
+	sources   map[string]*Measurement
+	now       func() time.Time
+}
+
+// NewMonitor creates a monitor, which warns about skews above the threshold.
+// A threshold of 0 disables the warnings, but the skew is measured anyway.
+func NewMonitor(threshold time.Duration) *Monitor {
+	return &Monitor{threshold: threshold, sources: map[string]*Measurement{}, now: time.Now}
+}
+
+// Default is the monitor used by the oauth providers and backends
+var Default = NewMonitor(DefaultThreshold)
+
+// ObserveResponse measures the skew by the Date header of the response with the default monitor
+func ObserveResponse(source string, resp *http.Response) {
+	Default.ObserveResponse(source, resp)
+}
+
+// SetThreshold changes the threshold for the warnings
+func (m *Monitor) SetThreshold(threshold time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.threshold = threshold
+}
+
+// ObserveResponse measures the skew by the Date header of the response.
+// Responses without a valid Date header are ignored.
+func (m *Monitor) ObserveResponse(source string, resp *http.Response) {
+	if resp == nil {
+		return
+	}
+	remote, err := http.ParseTime(resp.Header.Get("Date"))
+	if err != nil {
+		return
+	}
+	m.Observe(source, remote)
+}
+
+// Observe records the skew between the remote time of the source and the local clock.
+// The Date header has a resolution of one second, so the skew is rounded to seconds.
+func (m *Monitor) Observe(source string, remote time.Time) {
+	now := m.now()
+	skew := remote.Truncate(time.Second).Sub(now.Truncate(time.Second))
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	measurement, exist := m.sources[source]
+	if !exist {
+		measurement = &Measurement{}
+		m.sources[source] = measurement
+	}
+	wasExceeded := measurement.Exceeded
+	measurement.Skew = skew.Seconds()
+	measurement.Measured = now
+	measurement.Exceeded = m.threshold > 0 && math.Abs(skew.Seconds()) > m.threshold.Seconds()
+
+	entry := logging.Logger.
+		WithField("type", "clock_skew").
+		WithField("source", source).
+		WithField("skew_seconds", measurement.Skew)
+	switch {
+	case measurement.Exceeded && (!wasExceeded || now.Sub(measurement.warned) >= warnInterval):
+		measurement.warned = now
+		entry.Warnf("clock skew of %v to %v exceeds the threshold of %v, check the time synchronisation of this host", skew, source, m.threshold)
+	case !measurement.Exceeded && wasExceeded:
+		entry.Infof("clock skew to %v is back within the threshold", source)
+	}
+}
+
+// Status returns a copy of the measurements by source
+func (m *Monitor) Status() map[string]Measurement {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if len(m.sources) == 0 {
+		return nil
+	}
+	result := map[string]Measurement{}
+	for source, measurement := range m.sources {
+		result[source] = Measurement{Skew: measurement.Skew, Measured: measurement.Measured, Exceeded: measurement.Exceeded}
+	}
+	return result
+}
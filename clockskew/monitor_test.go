@@ -0,0 +1,55 @@
+package clockskew
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	. "github.com/stretchr/testify/assert"
+)
+
+func testMonitor(threshold time.Duration, now time.Time) *Monitor {
+	m := NewMonitor(threshold)
+	m.now = func() time.Time { return now }
+	return m
+}
+
+func Test_Monitor_Observe(t *testing.T) {
+	now := time.Date(2026, 10, 16, 9, 0, 0, 600*int(time.Millisecond), time.UTC)
+	m := testMonitor(30*time.Second, now)
+	Nil(t, m.Status())
+
+	m.Observe("oauth:github", now.Add(-5*time.Minute).Truncate(time.Second))
+	m.Observe("httpupstream:example.com", now.Truncate(time.Second).Add(2*time.Second))
+
+	Equal(t, map[string]Measurement{
+		"oauth:github":             {Skew: -300, Measured: now, Exceeded: true},
+		"httpupstream:example.com": {Skew: 2, Measured: now, Exceeded: false},
+	}, m.Status())
+
+	// the last measurement counts
+	m.Observe("oauth:github", now.Truncate(time.Second))
+	Equal(t, Measurement{Skew: 0, Measured: now, Exceeded: false}, m.Status()["oauth:github"])
+
+	// without threshold, the skew is measured, but never exceeded
+	m.SetThreshold(0)
+	m.Observe("oauth:github", now.Add(time.Hour))
+	Equal(t, Measurement{Skew: 3600, Measured: now, Exceeded: false}, m.Status()["oauth:github"])
+}
+
+func Test_Monitor_ObserveResponse(t *testing.T) {
+	now := time.Date(2026, 10, 16, 9, 0, 0, 0, time.UTC)
+	m := testMonitor(30*time.Second, now)
+
+	resp := &http.Response{Header: http.Header{}}
+	resp.Header.Set("Date", now.Add(time.Minute).Format(http.TimeFormat))
+	m.ObserveResponse("kms", resp)
+	Equal(t, Measurement{Skew: 60, Measured: now, Exceeded: true}, m.Status()["kms"])
+
+	// responses without or with an invalid date are ignored
+	m.ObserveResponse("none", &http.Response{Header: http.Header{}})
+	resp.Header.Set("Date", "yesterday")
+	m.ObserveResponse("invalid", resp)
+	m.ObserveResponse("nil", nil)
+	Equal(t, 1, len(m.Status()))
+}
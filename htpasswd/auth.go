@@ -12,6 +12,7 @@ import (
 	"golang.org/x/crypto/bcrypt"
 	"io"
 	"os"
+	"sort"
 	"strings"
 	"sync"
 	"time"
@@ -29,10 +30,24 @@ type Auth struct {
 	filenames  []File
 	userHash   map[string]string
 	muUserHash sync.RWMutex
+
+	// the optional group file and the groups of each user, guarded by muUserHash
+	groupFile  File
+	userGroups map[string][]string
+
+	// rehash upgrades weak hashes on login, if set
+	rehash   *rehashPolicy
+	muRehash sync.Mutex
 }
 
 // NewAuth creates an htpassword authenticater
 func NewAuth(filenames []string) (*Auth, error) {
+	return NewAuthWithGroups(filenames, "")
+}
+
+// NewAuthWithGroups creates an htpassword authenticater, which takes the groups of the users from the group file.
+// The group file is reloaded together with the password files.
+func NewAuthWithGroups(filenames []string, groupFile string) (*Auth, error) {
 	var htpasswdFiles []File
 	for _, file := range filenames {
 		htpasswdFiles = append(htpasswdFiles, File{name: file})
@@ -40,12 +55,14 @@ func NewAuth(filenames []string) (*Auth, error) {
 
 	a := &Auth{
 		filenames: htpasswdFiles,
+		groupFile: File{name: groupFile},
 	}
 	return a, a.parse(htpasswdFiles)
 }
 
 func (a *Auth) parse(filenames []File) error {
 	tmpUserHash := map[string]string{}
+	var tmpUserGroups map[string][]string
 
 	for _, filename := range a.filenames {
 		r, err := os.Open(filename.name)
@@ -82,8 +99,15 @@ func (a *Auth) parse(filenames []File) error {
 			tmpUserHash[record[0]] = record[1]
 		}
 	}
+	if a.groupFile.name != "" {
+		var err error
+		if tmpUserGroups, err = parseGroupFile(a.groupFile.name); err != nil {
+			return err
+		}
+	}
 	a.muUserHash.Lock()
 	a.userHash = tmpUserHash
+	a.userGroups = tmpUserGroups
 	a.muUserHash.Unlock()
 
 	return nil
@@ -92,29 +116,70 @@ func (a *Auth) parse(filenames []File) error {
 // Authenticate the user
 func (a *Auth) Authenticate(username, password string) (bool, error) {
 	reloadIfChanged(a)
+	a.muUserHash.RLock()
+	hash, exist := a.userHash[username]
+	a.muUserHash.RUnlock()
+	if !exist {
+		return false, nil
+	}
+	authenticated, err := compareHash(username, hash, password)
+	if authenticated && err == nil && a.rehash != nil && a.rehash.weak(hash) {
+		a.upgrade(username, password, hash)
+	}
+	return authenticated, err
+}
+
+func compareHash(username, hash, password string) (bool, error) {
+	h := []byte(hash)
+	p := []byte(password)
+	if isBcrypt(hash) {
+		matchErr := bcrypt.CompareHashAndPassword(h, p)
+		return (matchErr == nil), nil
+	}
+	if strings.HasPrefix(hash, "{SHA}") {
+		return compareSha(h, p), nil
+	}
+	if strings.HasPrefix(hash, "$apr1$") {
+		return compareMD5(h, p), nil
+	}
+	return false, fmt.Errorf("unknown algorithm for user %q", username)
+}
+
+// Groups returns the groups of the user from the group file
+func (a *Auth) Groups(username string) []string {
 	a.muUserHash.RLock()
 	defer a.muUserHash.RUnlock()
-	if hash, exist := a.userHash[username]; exist {
-		h := []byte(hash)
-		p := []byte(password)
-		if strings.HasPrefix(hash, "$2y$") || strings.HasPrefix(hash, "$2b$") {
-			matchErr := bcrypt.CompareHashAndPassword(h, p)
-			return (matchErr == nil), nil
-		}
-		if strings.HasPrefix(hash, "{SHA}") {
-			return compareSha(h, p), nil
-		}
-		if strings.HasPrefix(hash, "$apr1$") {
-			return compareMD5(h, p), nil
-		}
-		return false, fmt.Errorf("unknown algorithm for user %q", username)
+	return a.userGroups[username]
+}
+
+// ListUsers returns the usernames of the password files in alphabetical order
+func (a *Auth) ListUsers(offset, limit int) []string {
+	reloadIfChanged(a)
+	a.muUserHash.RLock()
+	usernames := make([]string, 0, len(a.userHash))
+	for u := range a.userHash {
+		usernames = append(usernames, u)
 	}
-	return false, nil
+	a.muUserHash.RUnlock()
+	sort.Strings(usernames)
+
+	if offset >= len(usernames) {
+		return []string{}
+	}
+	end := offset + limit
+	if end > len(usernames) {
+		end = len(usernames)
+	}
+	return usernames[offset:end]
 }
 
 // Reload htpasswd file if it changed during current run
 func reloadIfChanged(a *Auth) {
-	for _, file := range a.filenames {
+	files := a.filenames
+	if a.groupFile.name != "" {
+		files = append(append([]File{}, a.filenames...), a.groupFile)
+	}
+	for _, file := range files {
 		fileInfo, err := os.Stat(file.name)
 		if err != nil {
 			//On error, retain current file
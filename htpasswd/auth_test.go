@@ -165,3 +165,12 @@ func writeTmpfile(contents ...string) []string {
 	}
 	return names
 }
+
+func TestAuth_ListUsers(t *testing.T) {
+	auth, err := NewAuth(writeTmpfile(testfile))
+	NoError(t, err)
+
+	Equal(t, []string{"bob-bcrypt", "bob-foo"}, auth.ListUsers(0, 2))
+	Equal(t, []string{"bob-md5", "bob-sha"}, auth.ListUsers(2, 2))
+	Equal(t, []string{}, auth.ListUsers(4, 2))
+}
@@ -16,7 +16,7 @@ func init() {
 	login.RegisterProvider(
 		&login.ProviderDescription{
 			Name:     ProviderName,
-			HelpText: "Htpasswd login backend opts: files=/path/to/pwdfile,/path/to/additionalfile",
+			HelpText: "Htpasswd login backend opts: files=/path/to/pwdfile,/path/to/additionalfile,group-file=/path/to/groupfile,rehash-on-login=true|dry-run,rehash-cost=12,bootstrap-admin=true,bootstrap-admin-user=admin",
 		},
 		BackendFactory)
 }
@@ -41,7 +41,28 @@ func BackendFactory(config map[string]string) (login.Backend, error) {
 		return nil, errors.New(`missing parameter "file" for htpasswd provider`)
 	}
 
-	return NewBackend(files)
+	if config["bootstrap-admin"] == "true" {
+		username := config["bootstrap-admin-user"]
+		if username == "" {
+			username = defaultBootstrapAdmin
+		}
+		if err := bootstrapAdmin(files[0], username); err != nil {
+			return nil, err
+		}
+	}
+
+	rehash, err := newRehashPolicy(config)
+	if err != nil {
+		return nil, err
+	}
+
+	backend, err := NewBackendWithGroups(files, config["group-file"])
+	if err != nil || rehash == nil {
+		return backend, err
+	}
+	backend.auth.rehash = rehash
+	backend.auth.reportRehash()
+	return backend, nil
 }
 
 // Backend is a htpasswd based authentication backend.
@@ -51,7 +72,12 @@ type Backend struct {
 
 // NewBackend creates a new Backend and verifies the parameters.
 func NewBackend(filenames []string) (*Backend, error) {
-	auth, err := NewAuth(filenames)
+	return NewBackendWithGroups(filenames, "")
+}
+
+// NewBackendWithGroups creates a new Backend, which sets the groups of the group file in the user info.
+func NewBackendWithGroups(filenames []string, groupFile string) (*Backend, error) {
+	auth, err := NewAuthWithGroups(filenames, groupFile)
 	return &Backend{
 		auth,
 	}, err
@@ -61,7 +87,7 @@ func NewBackend(filenames []string) (*Backend, error) {
 func (sb *Backend) Authenticate(username, password string) (bool, model.UserInfo, error) {
 	authenticated, err := sb.auth.Authenticate(username, password)
 	if authenticated && err == nil {
-		return authenticated, model.UserInfo{Sub: username}, err
+		return authenticated, model.UserInfo{Sub: username, Groups: sb.auth.Groups(username)}, err
 	}
 	return false, model.UserInfo{}, err
 }
@@ -70,3 +96,8 @@ func (sb *Backend) Authenticate(username, password string) (bool, model.UserInfo
 func (sb *Backend) AuthenticateWithContext(ctx context.Context, username, password string) (bool, model.UserInfo, error) {
 	return sb.Authenticate(username, password)
 }
+
+// ListUsers returns the usernames known by the backend
+func (sb *Backend) ListUsers(offset, limit int) ([]string, error) {
+	return sb.auth.ListUsers(offset, limit), nil
+}
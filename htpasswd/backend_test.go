@@ -96,3 +96,21 @@ func TestSimpleBackend_Authenticate(t *testing.T) {
 	Equal(t, "", userInfo.Sub)
 	NoError(t, err)
 }
+
+func TestSimpleBackend_Authenticate_Groups(t *testing.T) {
+	backend, err := BackendFactory(map[string]string{
+		"file":       writeTmpfile(testfile)[0],
+		"group-file": writeTmpfile(testGroupFile)[0],
+	})
+	NoError(t, err)
+
+	authenticated, userInfo, err := backend.Authenticate("bob-bcrypt", "secret")
+	NoError(t, err)
+	True(t, authenticated)
+	Equal(t, []string{"admins", "dev"}, userInfo.Groups)
+
+	authenticated, userInfo, err = backend.Authenticate("bob-sha", "secret")
+	NoError(t, err)
+	True(t, authenticated)
+	Empty(t, userInfo.Groups)
+}
@@ -0,0 +1,110 @@
+package htpasswd
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/tarent/loginsrv/logging"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// the username of the bootstrapped admin, if no bootstrap-admin-user is configured
+const defaultBootstrapAdmin = "admin"
+
+// bootstrapOutput is where the generated password is printed
+var bootstrapOutput io.Writer = os.Stdout
+
+// bootstrapAdmin creates the password file with an admin user and a random password,
+// if the file does not exist or is empty. Files with content are never touched,
+// so the password is generated and printed only once.
+func bootstrapAdmin(filename, username string) error {
+	if info, err := os.Stat(filename); err == nil && info.Size() > 0 {
+		return nil
+	} else if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if username == "" || strings.ContainsAny(username, ":\n") {
+		return fmt.Errorf("invalid bootstrap admin username %q", username)
+	}
+
+	password, err := randomPassword()
+	if err != nil {
+		return err
+	}
+	entry, err := HashEntry(username, password)
+	if err != nil {
+		return err
+	}
+
+	if err := writeFileAtomic(filename, []byte(entry+"\n"), 0600); err != nil {
+		return fmt.Errorf("error writing the bootstrap admin to %v: %v", filename, err)
+	}
+
+	logging.Logger.WithField("username", username).WithField("file", filename).
+		Warn("created the initial admin user, the password is printed on stdout")
+	fmt.Fprintf(bootstrapOutput, "\n"+
+		"################################################################\n"+
+		"#  INITIAL ADMIN USER CREATED - THIS IS SHOWN ONLY ONCE\n"+
+		"#\n"+
+		"#  username: %v\n"+
+		"#  password: %v\n"+
+		"#\n"+
+		"#  file:     %v\n"+
+		"################################################################\n\n",
+		username, password, filename)
+	return nil
+}
+
+// HashEntry returns the line of the password file for the user with the bcrypt hash of the password
+func HashEntry(username, password string) (string, error) {
+	return hashEntryWithCost(username, password, bcrypt.DefaultCost)
+}
+
+func hashEntryWithCost(username, password string, cost int) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), cost)
+	if err != nil {
+		return "", err
+	}
+	// use the prefix written by the apache htpasswd tool
+	return username + ":$2y$" + strings.TrimPrefix(string(hash), "$2a$"), nil
+}
+
+func randomPassword() (string, error) {
+	b := make([]byte, 18)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// writeFileAtomic writes the file by renaming a temporary file in the same directory,
+// so that a crash never leaves a partly written password file.
+func writeFileAtomic(filename string, content []byte, mode os.FileMode) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(filename), "."+filepath.Base(filename)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(content); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), mode); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), filename)
+}
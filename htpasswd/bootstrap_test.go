@@ -0,0 +1,78 @@
+package htpasswd
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+
+	. "github.com/stretchr/testify/assert"
+)
+
+func TestBootstrapAdmin(t *testing.T) {
+	out := bytes.NewBuffer(nil)
+	bootstrapOutput = out
+	defer func() { bootstrapOutput = os.Stdout }()
+
+	dir, _ := ioutil.TempDir("", "loginsrv_bootstrap")
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "users")
+
+	backend, err := BackendFactory(map[string]string{"file": filename, "bootstrap-admin": "true", "bootstrap-admin-user": "root"})
+	NoError(t, err)
+
+	match := regexp.MustCompile(`password: (\S+)`).FindStringSubmatch(out.String())
+	NotNil(t, match)
+	Contains(t, out.String(), "username: root")
+
+	authenticated, _, err := backend.Authenticate("root", match[1])
+	NoError(t, err)
+	True(t, authenticated)
+
+	info, err := os.Stat(filename)
+	NoError(t, err)
+	Equal(t, os.FileMode(0600), info.Mode().Perm())
+	content, _ := ioutil.ReadFile(filename)
+
+	// the next start does neither regenerate nor print the password
+	out.Reset()
+	_, err = BackendFactory(map[string]string{"file": filename, "bootstrap-admin": "true", "bootstrap-admin-user": "root"})
+	NoError(t, err)
+	Equal(t, "", out.String())
+	newContent, _ := ioutil.ReadFile(filename)
+	Equal(t, content, newContent)
+
+	// no temp files are left
+	files, _ := ioutil.ReadDir(dir)
+	Equal(t, 1, len(files))
+}
+
+func TestBootstrapAdmin_EmptyFile(t *testing.T) {
+	out := bytes.NewBuffer(nil)
+	bootstrapOutput = out
+	defer func() { bootstrapOutput = os.Stdout }()
+
+	filename := writeTmpfile("")[0]
+	defer os.Remove(filename)
+
+	backend, err := BackendFactory(map[string]string{"file": filename, "bootstrap-admin": "true"})
+	NoError(t, err)
+	Contains(t, out.String(), "username: admin")
+	users, _ := backend.(*Backend).ListUsers(0, 10)
+	Equal(t, []string{"admin"}, users)
+}
+
+func TestBootstrapAdmin_Disabled(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "loginsrv_bootstrap")
+	defer os.RemoveAll(dir)
+
+	_, err := BackendFactory(map[string]string{"file": filepath.Join(dir, "users")})
+	Error(t, err)
+	_, err = os.Stat(filepath.Join(dir, "users"))
+	True(t, os.IsNotExist(err))
+
+	err = bootstrapAdmin(filepath.Join(dir, "users"), "a:b")
+	Error(t, err)
+}
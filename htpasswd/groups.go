@@ -0,0 +1,52 @@
+package htpasswd
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// parseGroupFile reads a group file in the format of the apache AuthGroupFile:
+// one group per line with its members separated by spaces, e.g. `admins: alice bob`.
+// It returns the groups of each user in the order of the file.
+// Members, which are not in the password files, are not an error.
+func parseGroupFile(filename string) (map[string][]string, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	userGroups := map[string][]string{}
+	scanner := bufio.NewScanner(f)
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		parts := strings.SplitN(line, ":", 2)
+		group := strings.TrimSpace(parts[0])
+		if len(parts) != 2 || group == "" || strings.ContainsAny(group, " \t") {
+			return nil, fmt.Errorf("group file %v, line %v: expected `group: user1 user2 ..`", filename, lineNumber)
+		}
+		for _, user := range strings.Fields(parts[1]) {
+			if !containsString(userGroups[user], group) {
+				userGroups[user] = append(userGroups[user], group)
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("group file %v: %v", filename, err)
+	}
+	return userGroups, nil
+}
+
+func containsString(list []string, s string) bool {
+	for _, entry := range list {
+		if entry == s {
+			return true
+		}
+	}
+	return false
+}
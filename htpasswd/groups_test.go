@@ -0,0 +1,55 @@
+package htpasswd
+
+import (
+	"io/ioutil"
+	"testing"
+
+	. "github.com/stretchr/testify/assert"
+)
+
+const testGroupFile = `# groups of the test users
+admins: bob-bcrypt
+dev:    bob-bcrypt  bob-md5 ghost
+
+ops:
+`
+
+func TestParseGroupFile(t *testing.T) {
+	userGroups, err := parseGroupFile(writeTmpfile(testGroupFile)[0])
+	NoError(t, err)
+	Equal(t, map[string][]string{
+		"bob-bcrypt": {"admins", "dev"},
+		"bob-md5":    {"dev"},
+		"ghost":      {"dev"},
+	}, userGroups)
+}
+
+func TestParseGroupFile_Errors(t *testing.T) {
+	_, err := parseGroupFile(writeTmpfile("admins: bob\n\nbob alice\n")[0])
+	Contains(t, err.Error(), ", line 3: expected `group: user1 user2 ..`")
+
+	_, err = parseGroupFile(writeTmpfile(": bob")[0])
+	Contains(t, err.Error(), ", line 1: ")
+
+	_, err = parseGroupFile("/does/not/exist")
+	Error(t, err)
+}
+
+func TestAuth_Groups(t *testing.T) {
+	groupFile := writeTmpfile(testGroupFile)[0]
+	auth, err := NewAuthWithGroups(writeTmpfile(testfile), groupFile)
+	NoError(t, err)
+	Equal(t, []string{"admins", "dev"}, auth.Groups("bob-bcrypt"))
+	Nil(t, auth.Groups("bob-sha"))
+
+	// the group file is reloaded together with the password file
+	NoError(t, ioutil.WriteFile(groupFile, []byte("ops: bob-sha\n"), 0644))
+	authenticated, err := auth.Authenticate("bob-sha", "secret")
+	NoError(t, err)
+	True(t, authenticated)
+	Equal(t, []string{"ops"}, auth.Groups("bob-sha"))
+	Nil(t, auth.Groups("bob-bcrypt"))
+
+	_, err = NewAuthWithGroups(writeTmpfile(testfile), writeTmpfile("no separator")[0])
+	Error(t, err)
+}
@@ -0,0 +1,210 @@
+package htpasswd
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tarent/loginsrv/logging"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// the values of the rehash-on-login option
+const (
+	rehashEnabled = "true"
+	rehashDryRun  = "dry-run"
+)
+
+// rehashLockTimeout is the maximum time to wait for the lock of a password file
+var rehashLockTimeout = 5 * time.Second
+
+// staleLockAge is the age, after which a lock file is considered as left by a crashed process
+var staleLockAge = time.Minute
+
+// rehashPolicy is the target of the hash upgrades on login
+type rehashPolicy struct {
+	cost   int
+	dryRun bool
+}
+
+// newRehashPolicy reads the rehash-on-login and rehash-cost options.
+// It returns nil, if the hashes are not upgraded.
+func newRehashPolicy(config map[string]string) (*rehashPolicy, error) {
+	mode := config["rehash-on-login"]
+	if mode == "" || mode == "false" {
+		return nil, nil
+	}
+	if mode != rehashEnabled && mode != rehashDryRun {
+		return nil, fmt.Errorf("invalid rehash-on-login %q, allowed are true, false and dry-run", mode)
+	}
+	p := &rehashPolicy{cost: bcrypt.DefaultCost, dryRun: mode == rehashDryRun}
+	if c, exist := config["rehash-cost"]; exist {
+		cost, err := strconv.Atoi(c)
+		if err != nil || cost < bcrypt.MinCost || cost > bcrypt.MaxCost {
+			return nil, fmt.Errorf("invalid rehash-cost %q, has to be between %v and %v", c, bcrypt.MinCost, bcrypt.MaxCost)
+		}
+		p.cost = cost
+	}
+	return p, nil
+}
+
+// weak checks, if the hash is weaker than the target: md5, sha1 or bcrypt with a lower cost
+func (p *rehashPolicy) weak(hash string) bool {
+	if isBcrypt(hash) {
+		cost, err := bcrypt.Cost([]byte(hash))
+		return err == nil && cost < p.cost
+	}
+	return strings.HasPrefix(hash, "{SHA}") || strings.HasPrefix(hash, "$apr1$")
+}
+
+func isBcrypt(hash string) bool {
+	return strings.HasPrefix(hash, "$2y$") || strings.HasPrefix(hash, "$2b$")
+}
+
+// reportRehash logs, how many entries of the password files are weaker than the target,
+// e.g. for planning the migration with the dry run.
+func (a *Auth) reportRehash() (weak, total int) {
+	a.muUserHash.RLock()
+	for _, hash := range a.userHash {
+		if a.rehash.weak(hash) {
+			weak++
+		}
+	}
+	total = len(a.userHash)
+	a.muUserHash.RUnlock()
+
+	message := "password hashes will be upgraded on login"
+	if a.rehash.dryRun {
+		message = "rehash dry run: password hashes would be upgraded on login"
+	}
+	logging.Logger.
+		WithField("weak_entries", weak).
+		WithField("total_entries", total).
+		WithField("bcrypt_cost", a.rehash.cost).
+		Info(message)
+	return weak, total
+}
+
+// upgrade replaces the weak hash of the user in the password file by a bcrypt hash of the verified password.
+// Failures are logged only, the login succeeds anyway.
+func (a *Auth) upgrade(username, password, oldHash string) {
+	log := logging.Logger.WithField("username", username)
+	if a.rehash.dryRun {
+		log.Info("rehash dry run: the password hash of the user would be upgraded")
+		return
+	}
+
+	a.muRehash.Lock()
+	defer a.muRehash.Unlock()
+	entry, err := hashEntryWithCost(username, password, a.rehash.cost)
+	if err != nil {
+		log.WithError(err).Error("could not upgrade the password hash")
+		return
+	}
+	filename, err := a.replaceEntry(username, oldHash, entry)
+	if err != nil {
+		log.WithError(err).Error("could not upgrade the password hash")
+		return
+	}
+	if filename == "" {
+		// the entry was changed meanwhile, e.g. by a concurrent login
+		return
+	}
+
+	newHash := strings.TrimPrefix(entry, username+":")
+	a.muUserHash.Lock()
+	if a.userHash[username] == oldHash {
+		a.userHash[username] = newHash
+	}
+	a.muUserHash.Unlock()
+	log.WithField("file", filename).Info("upgraded the password hash of the user to bcrypt")
+}
+
+// replaceEntry replaces the entry of the user in the password file, which the user is taken from,
+// if it still has the old hash. It returns the name of the changed file or an empty string, if nothing was changed.
+// The file is locked and read again, so that concurrent upgrades never overwrite each other.
+func (a *Auth) replaceEntry(username, oldHash, entry string) (string, error) {
+	// the entries of the last file win, like on parsing
+	for i := len(a.filenames) - 1; i >= 0; i-- {
+		filename := a.filenames[i].name
+		changed, found, err := replaceEntryInFile(filename, username, oldHash, entry)
+		if err != nil {
+			return "", err
+		}
+		if found {
+			if changed {
+				return filename, nil
+			}
+			return "", nil
+		}
+	}
+	return "", nil
+}
+
+func replaceEntryInFile(filename, username, oldHash, entry string) (changed, found bool, err error) {
+	unlock, err := lockFile(filename)
+	if err != nil {
+		return false, false, err
+	}
+	defer unlock()
+
+	info, err := os.Stat(filename)
+	if err != nil {
+		return false, false, err
+	}
+	content, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return false, false, err
+	}
+	lines := strings.Split(string(content), "\n")
+	index := -1
+	for i, line := range lines {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "#") {
+			continue
+		}
+		if parts := strings.SplitN(line, ":", 2); len(parts) == 2 && parts[0] == username {
+			index = i
+		}
+	}
+	if index == -1 {
+		return false, false, nil
+	}
+	if hash := strings.SplitN(strings.TrimSpace(lines[index]), ":", 2)[1]; strings.TrimSpace(hash) != oldHash {
+		return false, true, nil
+	}
+	lines[index] = entry
+	if err := writeFileAtomic(filename, []byte(strings.Join(lines, "\n")), info.Mode().Perm()); err != nil {
+		return false, true, err
+	}
+	return true, true, nil
+}
+
+// lockFile creates the lock file of the password file exclusively, so that upgrades of concurrent logins,
+// also of other loginsrv processes, are done one after the other.
+// Lock files older than the staleLockAge are left by crashed processes and removed.
+func lockFile(filename string) (unlock func(), err error) {
+	lock := filename + ".lock"
+	deadline := time.Now().Add(rehashLockTimeout)
+	for {
+		f, err := os.OpenFile(lock, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+		if err == nil {
+			f.Close()
+			return func() { os.Remove(lock) }, nil
+		}
+		if !os.IsExist(err) {
+			return nil, err
+		}
+		if info, statErr := os.Stat(lock); statErr == nil && time.Since(info.ModTime()) > staleLockAge {
+			os.Remove(lock)
+			continue
+		}
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("timeout waiting for the lock %v", lock)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
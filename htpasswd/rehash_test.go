@@ -0,0 +1,153 @@
+package htpasswd
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	. "github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestNewRehashPolicy(t *testing.T) {
+	tests := []struct {
+		config map[string]string
+		policy *rehashPolicy
+		err    bool
+	}{
+		{map[string]string{}, nil, false},
+		{map[string]string{"rehash-on-login": "false", "rehash-cost": "12"}, nil, false},
+		{map[string]string{"rehash-on-login": "true"}, &rehashPolicy{cost: bcrypt.DefaultCost}, false},
+		{map[string]string{"rehash-on-login": "dry-run", "rehash-cost": "12"}, &rehashPolicy{cost: 12, dryRun: true}, false},
+		{map[string]string{"rehash-on-login": "yes"}, nil, true},
+		{map[string]string{"rehash-on-login": "true", "rehash-cost": "3"}, nil, true},
+		{map[string]string{"rehash-on-login": "true", "rehash-cost": "high"}, nil, true},
+	}
+	for _, test := range tests {
+		policy, err := newRehashPolicy(test.config)
+		Equal(t, test.err, err != nil, "%v", test.config)
+		Equal(t, test.policy, policy, "%v", test.config)
+	}
+}
+
+func TestRehashPolicy_Weak(t *testing.T) {
+	p := &rehashPolicy{cost: 6}
+	True(t, p.weak("$apr1$IDZSCL/o$N68zaFDDRivjour94OVeB."))
+	True(t, p.weak("{SHA}5en6G6MezRroT3XKqkdPOmY/BfQ="))
+	True(t, p.weak("$2y$05$Hw6y1sFwh6CdwiPOKFMYj..xVSQWI3wzyQvt5th392ig8RLmeLU.6"))
+	False(t, p.weak("$2y$06$Hw6y1sFwh6CdwiPOKFMYj..xVSQWI3wzyQvt5th392ig8RLmeLU.6"))
+	False(t, p.weak("{fooo}sdcsdcsdc/BfQ="))
+}
+
+func TestBackend_RehashOnLogin(t *testing.T) {
+	filename := writeTmpfile(testfile)[0]
+	NoError(t, os.Chmod(filename, 0640))
+	backend, err := BackendFactory(map[string]string{"file": filename, "rehash-on-login": "true", "rehash-cost": "6"})
+	NoError(t, err)
+
+	authenticated, _, err := backend.Authenticate("bob-md5", "wrong")
+	NoError(t, err)
+	False(t, authenticated)
+	content, _ := ioutil.ReadFile(filename)
+	Equal(t, testfile, string(content))
+
+	authenticated, _, err = backend.Authenticate("bob-md5", "secret")
+	NoError(t, err)
+	True(t, authenticated)
+
+	// only the entry of the user is replaced
+	content, _ = ioutil.ReadFile(filename)
+	lines := strings.Split(string(content), "\n")
+	True(t, strings.HasPrefix(lines[0], "bob-md5:$2y$06$"))
+	Equal(t, strings.Split(testfile, "\n")[1:], lines[1:])
+	info, err := os.Stat(filename)
+	NoError(t, err)
+	Equal(t, os.FileMode(0640), info.Mode().Perm())
+	_, err = os.Stat(filename + ".lock")
+	True(t, os.IsNotExist(err))
+
+	authenticated, _, err = backend.Authenticate("bob-md5", "secret")
+	NoError(t, err)
+	True(t, authenticated)
+}
+
+func TestBackend_RehashOnLogin_Concurrent(t *testing.T) {
+	filename := writeTmpfile(testfile)[0]
+	backend, err := BackendFactory(map[string]string{"file": filename, "rehash-on-login": "true", "rehash-cost": "6"})
+	NoError(t, err)
+
+	wg := sync.WaitGroup{}
+	for i := 0; i < 10; i++ {
+		for _, username := range []string{"bob-md5", "bob-sha", "bob-bcrypt"} {
+			wg.Add(1)
+			go func(username string) {
+				defer wg.Done()
+				authenticated, _, err := backend.Authenticate(username, "secret")
+				NoError(t, err)
+				True(t, authenticated)
+			}(username)
+		}
+	}
+	wg.Wait()
+
+	auth, err := NewAuth([]string{filename})
+	NoError(t, err)
+	for _, username := range []string{"bob-md5", "bob-sha", "bob-bcrypt"} {
+		True(t, strings.HasPrefix(auth.userHash[username], "$2y$06$"), username)
+		authenticated, err := auth.Authenticate(username, "secret")
+		NoError(t, err)
+		True(t, authenticated)
+	}
+	Equal(t, "{fooo}sdcsdcsdc/BfQ=", auth.userHash["bob-foo"])
+}
+
+func TestBackend_RehashOnLogin_DryRun(t *testing.T) {
+	filename := writeTmpfile(testfile)[0]
+	backend, err := BackendFactory(map[string]string{"file": filename, "rehash-on-login": "dry-run", "rehash-cost": "6"})
+	NoError(t, err)
+
+	weak, total := backend.(*Backend).auth.reportRehash()
+	Equal(t, 3, weak)
+	Equal(t, 4, total)
+
+	authenticated, _, err := backend.Authenticate("bob-md5", "secret")
+	NoError(t, err)
+	True(t, authenticated)
+	content, _ := ioutil.ReadFile(filename)
+	Equal(t, testfile, string(content))
+}
+
+func TestReplaceEntryInFile(t *testing.T) {
+	filename := writeTmpfile("alice:{SHA}old\nbob:{SHA}changed\n")[0]
+
+	// the entry was changed meanwhile
+	changed, found, err := replaceEntryInFile(filename, "bob", "{SHA}old", "bob:$2y$new")
+	NoError(t, err)
+	True(t, found)
+	False(t, changed)
+
+	_, found, err = replaceEntryInFile(filename, "carol", "{SHA}old", "carol:$2y$new")
+	NoError(t, err)
+	False(t, found)
+
+	// a stale lock of a crashed process is removed
+	NoError(t, ioutil.WriteFile(filename+".lock", nil, 0600))
+	old := time.Now().Add(-2 * staleLockAge)
+	NoError(t, os.Chtimes(filename+".lock", old, old))
+	changed, _, err = replaceEntryInFile(filename, "alice", "{SHA}old", "alice:$2y$new")
+	NoError(t, err)
+	True(t, changed)
+	content, _ := ioutil.ReadFile(filename)
+	Equal(t, "alice:$2y$new\nbob:{SHA}changed\n", string(content))
+
+	// a lock of another process is waited for
+	defer func(timeout time.Duration) { rehashLockTimeout = timeout }(rehashLockTimeout)
+	rehashLockTimeout = 50 * time.Millisecond
+	NoError(t, ioutil.WriteFile(filename+".lock", nil, 0600))
+	defer os.Remove(filename + ".lock")
+	_, _, err = replaceEntryInFile(filename, "bob", "{SHA}changed", "bob:$2y$new")
+	Error(t, err)
+}
@@ -2,7 +2,7 @@ package httpupstream
 
 import (
 	"context"
-	"crypto/tls"
+	"fmt"
 	"net/http"
 	"net/url"
 	"time"
@@ -10,6 +10,9 @@ import (
 	"github.com/opentracing-contrib/go-stdlib/nethttp"
 	opentracing "github.com/opentracing/opentracing-go"
 	"github.com/opentracing/opentracing-go/ext"
+	"github.com/tarent/loginsrv/clockskew"
+	"github.com/tarent/loginsrv/login"
+	"github.com/tarent/loginsrv/outboundtls"
 )
 
 // Auth is the httpupstream authenticater
@@ -17,6 +20,23 @@ type Auth struct {
 	upstream   *url.URL
 	skipverify bool
 	timeout    time.Duration
+	forward    forwarding
+
+	// cache is the optional cache of successful probes
+	cache *probeCache
+}
+
+// forwarding defines the information of the login request,
+// which is sent to the upstream as headers.
+type forwarding struct {
+	// send the client ip as X-Forwarded-For
+	clientIP bool
+
+	// send the correlation id as X-Request-Id
+	requestID bool
+
+	// static headers from the configuration
+	headers map[string]string
 }
 
 // NewAuth creates an httpupstream authenticater
@@ -32,37 +52,54 @@ func NewAuth(upstream *url.URL, timeout time.Duration, skipverify bool) (*Auth,
 
 // Authenticate the user
 func (a *Auth) Authenticate(username, password string) (bool, error) {
-	c := &http.Client{
-		Timeout: a.timeout,
-	}
+	return a.cached(context.Background(), username, password, func(ctx context.Context) (bool, error) {
+		return a.authenticate(ctx, username, password)
+	})
+}
 
-	if a.upstream.Scheme == "https" && a.skipverify {
-		c.Transport = &http.Transport{
-			TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
-		}
-	}
+//AuthenticateWithContext traced authentication
+func (a *Auth) AuthenticateWithContext(ctx context.Context, username, password string) (bool, error) {
+	return a.cached(ctx, username, password, func(ctx context.Context) (bool, error) {
+		return a.authenticateWithContext(ctx, username, password)
+	})
+}
 
-	req, err := http.NewRequest("GET", a.upstream.String(), nil)
-	if err != nil {
-		return false, err
-	}
-	req.SetBasicAuth(username, password)
-
-	resp, err := c.Do(req)
-	if err != nil {
-		return false, err
+// cached calls the probe through the cache, if it is enabled.
+// The probe of the cache is shared by all callers with the same credentials, so it gets a detached context
+// with the values of the request, e.g. for the forwarded headers and the tracing, but its own timeout.
+func (a *Auth) cached(ctx context.Context, username, password string, probe func(ctx context.Context) (bool, error)) (bool, error) {
+	if a.cache == nil {
+		return probe(ctx)
 	}
+	return a.cache.do(ctx, username, password, func() (bool, error) {
+		probeCtx, cancel := a.detach(ctx)
+		defer cancel()
+		return probe(probeCtx)
+	})
+}
 
-	if resp.StatusCode != 200 {
-		return false, nil
+// detach returns a context with the values of the context, which is only canceled by the timeout of the upstream
+func (a *Auth) detach(ctx context.Context) (context.Context, context.CancelFunc) {
+	if a.timeout > 0 {
+		return context.WithTimeout(detachedContext{ctx}, a.timeout)
 	}
+	return context.WithCancel(detachedContext{ctx})
+}
 
-	return true, nil
+// detachedContext keeps the values of the parent, but not its deadline and cancellation
+type detachedContext struct {
+	context.Context
 }
 
-//AuthenticateWithContext traced authentication
-func (a *Auth) AuthenticateWithContext(ctx context.Context, username, password string) (bool, error) {
+func (detachedContext) Deadline() (time.Time, bool) { return time.Time{}, false }
+func (detachedContext) Done() <-chan struct{}       { return nil }
+func (detachedContext) Err() error                  { return nil }
+
+func (a *Auth) authenticateWithContext(ctx context.Context, username, password string) (bool, error) {
 	parentSpan := opentracing.SpanFromContext(ctx)
+	if parentSpan == nil {
+		return a.authenticate(ctx, username, password)
+	}
 	tracer := parentSpan.Tracer()
 	span := tracer.StartSpan("HTTP Upstream", opentracing.ChildOf(parentSpan.Context()))
 	ext.SpanKind.Set(span, "client")
@@ -71,21 +108,19 @@ func (a *Auth) AuthenticateWithContext(ctx context.Context, username, password s
 	span.SetTag("http.url", a.upstream.String())
 	defer span.Finish()
 	req, _ := http.NewRequest("GET", a.upstream.String(), nil)
+	req = req.WithContext(ctx)
 	req.SetBasicAuth(username, password)
+	a.setForwardHeaders(ctx, req)
 	tracer.Inject(span.Context(), opentracing.HTTPHeaders, opentracing.HTTPHeadersCarrier(req.Header))
 
-	client := &http.Client{Transport: &nethttp.Transport{}}
-	if a.upstream.Scheme == "https" && a.skipverify {
-		client.Transport = &http.Transport{
-			TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
-		}
-	}
+	client := &http.Client{Timeout: a.timeout, Transport: &nethttp.Transport{RoundTripper: a.transport()}}
 	rsp, err := client.Do(req)
-	span.SetTag("http.status_code", rsp.StatusCode)
 	if err != nil {
 		span.SetTag("error", true)
 		return false, err
 	}
+	span.SetTag("http.status_code", rsp.StatusCode)
+	clockskew.ObserveResponse(a.skewSource(), rsp)
 
 	if rsp.StatusCode != 200 {
 		span.SetTag("error", true)
@@ -94,3 +129,81 @@ func (a *Auth) AuthenticateWithContext(ctx context.Context, username, password s
 
 	return true, nil
 }
+
+func (a *Auth) authenticate(ctx context.Context, username, password string) (bool, error) {
+	c := &http.Client{
+		Timeout:   a.timeout,
+		Transport: a.transport(),
+	}
+
+	req, err := http.NewRequest("GET", a.upstream.String(), nil)
+	if err != nil {
+		return false, err
+	}
+	req = req.WithContext(ctx)
+	req.SetBasicAuth(username, password)
+	a.setForwardHeaders(ctx, req)
+
+	resp, err := c.Do(req)
+	if err != nil {
+		return false, err
+	}
+	clockskew.ObserveResponse(a.skewSource(), resp)
+
+	if resp.StatusCode != 200 {
+		return false, nil
+	}
+
+	return true, nil
+}
+
+// checkReady requests the upstream without credentials.
+// Any answer below 500, e.g. the expected 401, shows that the upstream is available.
+func (a *Auth) checkReady(ctx context.Context) error {
+	c := &http.Client{Transport: a.transport()}
+	req, err := http.NewRequest("GET", a.upstream.String(), nil)
+	if err != nil {
+		return err
+	}
+	resp, err := c.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 500 {
+		return fmt.Errorf("upstream answered with http status %v", resp.StatusCode)
+	}
+	return nil
+}
+
+// transport uses the outbound tls settings, the skipverify option disables the verification on top of them
+func (a *Auth) transport() http.RoundTripper {
+	if a.skipverify {
+		return outboundtls.InsecureTransport
+	}
+	return outboundtls.Transport
+}
+
+// skewSource is the name of the upstream for the clock skew measurement
+func (a *Auth) skewSource() string {
+	return "httpupstream:" + a.upstream.Host
+}
+
+// setForwardHeaders adds the configured headers to the upstream request.
+// Only the explicitly enabled information is sent, incoming headers are never copied.
+func (a *Auth) setForwardHeaders(ctx context.Context, req *http.Request) {
+	for name, value := range a.forward.headers {
+		req.Header.Set(name, value)
+	}
+
+	info, ok := login.RequestInfoFromContext(ctx)
+	if !ok {
+		return
+	}
+	if a.forward.clientIP && info.ClientIP != "" {
+		req.Header.Set("X-Forwarded-For", info.ClientIP)
+	}
+	if a.forward.requestID && info.CorrelationID != "" {
+		req.Header.Set("X-Request-Id", info.CorrelationID)
+	}
+}
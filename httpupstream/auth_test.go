@@ -1,11 +1,16 @@
 package httpupstream
 
 import (
+	"context"
+	"net/http"
+	"net/http/httptest"
 	"net/url"
 	"testing"
 	"time"
 
 	. "github.com/stretchr/testify/assert"
+	"github.com/tarent/loginsrv/clockskew"
+	"github.com/tarent/loginsrv/login"
 )
 
 func TestAuth_UnknownUser(t *testing.T) {
@@ -45,6 +50,11 @@ func TestAuth_ValidCredentials(t *testing.T) {
 	authenticated, err := auth.Authenticate("bob-bcrypt", "secret")
 	NoError(t, err)
 	True(t, authenticated)
+
+	// the clock of the upstream is measured by the date header
+	skew, measured := clockskew.Default.Status()["httpupstream:"+u.Host]
+	True(t, measured)
+	InDelta(t, 0, skew.Skew, 1)
 }
 
 func TestAuth_InvalidUrl(t *testing.T) {
@@ -66,3 +76,57 @@ func TestAuth_InvalidHost(t *testing.T) {
 	_, err = auth.Authenticate("foo", "bar")
 	Error(t, err)
 }
+
+func TestAuth_ForwardHeaders(t *testing.T) {
+	var received http.Header
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = r.Header
+	}))
+	defer ts.Close()
+	u, _ := url.Parse(ts.URL)
+
+	auth, err := NewAuth(u, time.Second, false)
+	NoError(t, err)
+	auth.forward = forwarding{clientIP: true, requestID: true, headers: map[string]string{"X-Api-Key": "the-key"}}
+
+	ctx := login.WithRequestInfo(context.Background(), login.RequestInfo{ClientIP: "10.1.2.3", CorrelationID: "abc"})
+	authenticated, err := auth.AuthenticateWithContext(ctx, "bob", "secret")
+	NoError(t, err)
+	True(t, authenticated)
+	Equal(t, "10.1.2.3", received.Get("X-Forwarded-For"))
+	Equal(t, "abc", received.Get("X-Request-Id"))
+	Equal(t, "the-key", received.Get("X-Api-Key"))
+
+	// without request info, only the static headers are sent
+	authenticated, err = auth.Authenticate("bob", "secret")
+	NoError(t, err)
+	True(t, authenticated)
+	Equal(t, "", received.Get("X-Forwarded-For"))
+	Equal(t, "", received.Get("X-Request-Id"))
+	Equal(t, "the-key", received.Get("X-Api-Key"))
+
+	// nothing is forwarded by default
+	auth.forward = forwarding{}
+	_, err = auth.AuthenticateWithContext(ctx, "bob", "secret")
+	NoError(t, err)
+	Equal(t, "", received.Get("X-Forwarded-For"))
+	Equal(t, "", received.Get("X-Request-Id"))
+}
+
+func TestAuth_CheckReady(t *testing.T) {
+	status := 401
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _, hasCredentials := r.BasicAuth()
+		False(t, hasCredentials)
+		w.WriteHeader(status)
+	}))
+	defer server.Close()
+
+	upstream, _ := url.Parse(server.URL)
+	backend, err := NewBackend(upstream, time.Second, false)
+	NoError(t, err)
+	NoError(t, backend.CheckReady(context.Background()))
+
+	status = 502
+	EqualError(t, backend.CheckReady(context.Background()), "upstream answered with http status 502")
+}
@@ -4,10 +4,14 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"net/http"
 	"net/url"
+	"regexp"
 	"strconv"
+	"strings"
 	"time"
 
+	"github.com/tarent/loginsrv/logging"
 	"github.com/tarent/loginsrv/login"
 	"github.com/tarent/loginsrv/model"
 )
@@ -17,11 +21,14 @@ const ProviderName = "httpupstream"
 
 const defaultTimeout = time.Minute
 
+// maxCacheTTL limits the cache of successful probes to a short window
+const maxCacheTTL = time.Minute
+
 func init() {
 	login.RegisterProvider(
 		&login.ProviderDescription{
 			Name:     ProviderName,
-			HelpText: "Httpupstream login backend opts: upstream=...,skipverify=...,timeout=...",
+			HelpText: "Httpupstream login backend opts: upstream=...,skipverify=...,timeout=...[,forward_client_ip=true][,forward_request_id=true][,header_<Name>=...][,cache_ttl=5s]",
 		},
 		BackendFactory)
 }
@@ -56,9 +63,78 @@ func BackendFactory(config map[string]string) (login.Backend, error) {
 		if err != nil {
 			return nil, fmt.Errorf(`invalid parameter value "%s" in "skipverify" httpupstream provider: %v`, ts, err)
 		}
+		logging.Logger.Warnf("DEPRECATED: 'skipverify' of the httpupstream backend. Please use -outbound-tls-host=host=%v,skip-verify=true", u.Hostname())
+	}
+
+	fwd, err := parseForwarding(config)
+	if err != nil {
+		return nil, err
 	}
 
-	return NewBackend(u, t, v)
+	cacheTTL := time.Duration(0)
+	if cs, ce := config["cache_ttl"]; ce {
+		cacheTTL, err = time.ParseDuration(cs)
+		if err != nil {
+			return nil, fmt.Errorf(`invalid parameter value "%s" in "cache_ttl" httpupstream provider: %v`, cs, err)
+		}
+		if cacheTTL < 0 || cacheTTL > maxCacheTTL {
+			return nil, fmt.Errorf(`parameter "cache_ttl" of httpupstream provider has to be between 0 and %v`, maxCacheTTL)
+		}
+	}
+
+	b, err := NewBackend(u, t, v)
+	if err != nil {
+		return nil, err
+	}
+	b.auth.forward = fwd
+	if cacheTTL > 0 {
+		b.auth.cache = newProbeCache(cacheTTL)
+	}
+	return b, nil
+}
+
+const headerOptionPrefix = "header_"
+
+var validHeaderName = regexp.MustCompile("^[A-Za-z0-9-]+$")
+
+// headers, which must not be overwritten by the configuration
+var reservedHeaders = []string{"Authorization", "Host", "Content-Length", "Transfer-Encoding", "Connection"}
+
+func parseForwarding(config map[string]string) (forwarding, error) {
+	fwd := forwarding{headers: map[string]string{}}
+
+	for _, opt := range []struct {
+		name   string
+		target *bool
+	}{
+		{"forward_client_ip", &fwd.clientIP},
+		{"forward_request_id", &fwd.requestID},
+	} {
+		if s, exist := config[opt.name]; exist {
+			v, err := strconv.ParseBool(s)
+			if err != nil {
+				return forwarding{}, fmt.Errorf(`invalid parameter value "%s" in "%s" httpupstream provider: %v`, s, opt.name, err)
+			}
+			*opt.target = v
+		}
+	}
+
+	for k, v := range config {
+		if !strings.HasPrefix(k, headerOptionPrefix) {
+			continue
+		}
+		name := http.CanonicalHeaderKey(strings.TrimPrefix(k, headerOptionPrefix))
+		if !validHeaderName.MatchString(name) {
+			return forwarding{}, fmt.Errorf(`invalid header name "%s" in "%s" httpupstream provider`, name, k)
+		}
+		for _, reserved := range reservedHeaders {
+			if name == reserved {
+				return forwarding{}, fmt.Errorf(`header "%s" can not be set in httpupstream provider`, name)
+			}
+		}
+		fwd.headers[name] = v
+	}
+	return fwd, nil
 }
 
 // Backend is a httpupstream based authentication backend.
@@ -83,6 +159,26 @@ func (sb *Backend) Authenticate(username, password string) (bool, model.UserInfo
 	return false, model.UserInfo{}, err
 }
 
+// SetKey sets the key for the hmac of the credentials in the cache
+func (sb *Backend) SetKey(key []byte) {
+	if sb.auth.cache != nil {
+		sb.auth.cache.setKey(key)
+	}
+}
+
+// CheckReady checks, that the upstream is available
+func (sb *Backend) CheckReady(ctx context.Context) error {
+	return sb.auth.checkReady(ctx)
+}
+
+// Stats returns the counters of the cache, or nil if it is disabled
+func (sb *Backend) Stats() map[string]int64 {
+	if sb.auth.cache == nil {
+		return nil
+	}
+	return sb.auth.cache.stats()
+}
+
 // AuthenticateWithContext the user
 func (sb *Backend) AuthenticateWithContext(ctx context.Context, username, password string) (bool, model.UserInfo, error) {
 	authenticated, err := sb.auth.AuthenticateWithContext(ctx, username, password)
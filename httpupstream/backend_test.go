@@ -71,6 +71,51 @@ func TestSetup_Error(t *testing.T) {
 
 	_, err = p(map[string]string{"upstream": "http://example.com", "skipverify": "some-string"})
 	Error(t, err)
+
+	_, err = p(map[string]string{"upstream": "http://example.com", "cache_ttl": "some-string"})
+	Error(t, err)
+
+	_, err = p(map[string]string{"upstream": "http://example.com", "cache_ttl": "1h"})
+	Error(t, err)
+}
+
+func TestSetup_Cache(t *testing.T) {
+	p, _ := login.GetProvider(ProviderName)
+
+	backend, err := p(map[string]string{"upstream": "http://example.com"})
+	NoError(t, err)
+	Nil(t, backend.(*Backend).auth.cache)
+	Nil(t, backend.(*Backend).Stats())
+
+	backend, err = p(map[string]string{"upstream": "http://example.com", "cache_ttl": "5s"})
+	NoError(t, err)
+	Equal(t, 5*time.Second, backend.(*Backend).auth.cache.ttl)
+	Equal(t, int64(0), backend.(*Backend).Stats()["cache_hits"])
+}
+
+func TestSetup_Forwarding(t *testing.T) {
+	p, exist := login.GetProvider(ProviderName)
+	True(t, exist)
+
+	backend, err := p(map[string]string{
+		"upstream":           "https://google.com",
+		"forward_client_ip":  "true",
+		"forward_request_id": "true",
+		"header_x-api-key":   "the-key",
+	})
+	NoError(t, err)
+	Equal(t,
+		forwarding{clientIP: true, requestID: true, headers: map[string]string{"X-Api-Key": "the-key"}},
+		backend.(*Backend).auth.forward)
+
+	_, err = p(map[string]string{"upstream": "http://example.com", "forward_client_ip": "some-string"})
+	Error(t, err)
+
+	_, err = p(map[string]string{"upstream": "http://example.com", "header_authorization": "Basic Zm9vOmJhcg=="})
+	Error(t, err)
+
+	_, err = p(map[string]string{"upstream": "http://example.com", "header_x api": "foo"})
+	Error(t, err)
 }
 
 func TestSimpleBackend_Authenticate(t *testing.T) {
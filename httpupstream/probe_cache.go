@@ -0,0 +1,146 @@
+package httpupstream
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// the number of cached entries, from which on expired entries are removed
+const probeCacheSweepSize = 1024
+
+// probeCache remembers successful upstream probes for a short time
+// and collapses concurrent identical probes into one upstream request.
+// The credentials are only stored as hmac.
+// The collapsed probe runs on its own, so a caller giving up does not fail the other callers waiting for it.
+type probeCache struct {
+	ttl time.Duration
+
+	mu      sync.Mutex
+	key     []byte
+	entries map[string]probeEntry
+	flights map[string]*probeFlight
+
+	hits      int64
+	misses    int64
+	collapsed int64
+}
+
+// probeEntry is the successful probe of a user
+type probeEntry struct {
+	credentials string
+	expires     time.Time
+}
+
+type probeFlight struct {
+	done          chan struct{}
+	authenticated bool
+	err           error
+}
+
+func newProbeCache(ttl time.Duration) *probeCache {
+	key := make([]byte, 32)
+	rand.Read(key)
+	return &probeCache{
+		ttl:     ttl,
+		key:     key,
+		entries: map[string]probeEntry{},
+		flights: map[string]*probeFlight{},
+	}
+}
+
+func (c *probeCache) setKey(key []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.key = key
+	c.entries = map[string]probeEntry{}
+}
+
+func (c *probeCache) mac(parts ...string) string {
+	mac := hmac.New(sha256.New, c.key)
+	for _, p := range parts {
+		// length prefixed, so that the split of username and password is unambiguous
+		fmt.Fprintf(mac, "%d:%s", len(p), p)
+	}
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// do returns the cached result for the credentials or calls probe once for all concurrent identical calls.
+// The probe is not canceled with the context, only the wait of this caller ends with the context error.
+// Only successful results are cached. A failed probe removes the cached result of the user only,
+// if the upstream rejected the cached credentials themselves, so a wrong password does not evict the cached success.
+func (c *probeCache) do(ctx context.Context, username, password string, probe func() (bool, error)) (bool, error) {
+	c.mu.Lock()
+	user := c.mac(username)
+	credentials := c.mac(username, password)
+
+	if e, exist := c.entries[user]; exist && e.credentials == credentials && time.Now().Before(e.expires) {
+		c.hits++
+		c.mu.Unlock()
+		return true, nil
+	}
+	f, exist := c.flights[credentials]
+	if exist {
+		c.collapsed++
+	} else {
+		c.misses++
+		f = &probeFlight{done: make(chan struct{})}
+		c.flights[credentials] = f
+		go c.run(f, user, credentials, probe)
+	}
+	c.mu.Unlock()
+
+	select {
+	case <-f.done:
+		return f.authenticated, f.err
+	case <-ctx.Done():
+		return false, ctx.Err()
+	}
+}
+
+// run calls the probe of the flight and caches its result
+func (c *probeCache) run(f *probeFlight, user, credentials string, probe func() (bool, error)) {
+	f.authenticated, f.err = probe()
+
+	c.mu.Lock()
+	delete(c.flights, credentials)
+	switch {
+	case f.authenticated && f.err == nil:
+		now := time.Now()
+		c.sweep(now)
+		c.entries[user] = probeEntry{credentials: credentials, expires: now.Add(c.ttl)}
+	case f.err == nil:
+		if e, exist := c.entries[user]; exist && e.credentials == credentials {
+			delete(c.entries, user)
+		}
+	}
+	c.mu.Unlock()
+	close(f.done)
+}
+
+// sweep removes the expired entries of a large cache
+func (c *probeCache) sweep(now time.Time) {
+	if len(c.entries) < probeCacheSweepSize {
+		return
+	}
+	for user, e := range c.entries {
+		if !now.Before(e.expires) {
+			delete(c.entries, user)
+		}
+	}
+}
+
+func (c *probeCache) stats() map[string]int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return map[string]int64{
+		"cache_hits":      c.hits,
+		"cache_misses":    c.misses,
+		"cache_collapsed": c.collapsed,
+	}
+}
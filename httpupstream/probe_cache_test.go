@@ -0,0 +1,195 @@
+package httpupstream
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	. "github.com/stretchr/testify/assert"
+)
+
+// newCountingServer accepts bob:secret and counts the requests.
+// The requests wait for the release channel, if it is not nil.
+func newCountingServer(calls *int64, release chan struct{}) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(calls, 1)
+		if release != nil {
+			<-release
+		}
+		if username, password, _ := r.BasicAuth(); username != "bob" || password != "secret" {
+			w.WriteHeader(401)
+		}
+	}))
+}
+
+func cachedAuth(t *testing.T, serverURL string, ttl time.Duration) *Auth {
+	u, _ := url.Parse(serverURL)
+	auth, err := NewAuth(u, time.Second, false)
+	NoError(t, err)
+	auth.cache = newProbeCache(ttl)
+	auth.cache.setKey([]byte("key"))
+	return auth
+}
+
+func TestProbeCache(t *testing.T) {
+	var calls int64
+	ts := newCountingServer(&calls, nil)
+	defer ts.Close()
+	auth := cachedAuth(t, ts.URL, time.Minute)
+
+	for i := 0; i < 3; i++ {
+		authenticated, err := auth.Authenticate("bob", "secret")
+		NoError(t, err)
+		True(t, authenticated)
+	}
+	Equal(t, int64(1), atomic.LoadInt64(&calls))
+	Equal(t, map[string]int64{"cache_hits": 2, "cache_misses": 1, "cache_collapsed": 0}, auth.cache.stats())
+
+	// failures are not cached, but a wrong password keeps the cached success of the user
+	authenticated, _ := auth.Authenticate("bob", "wrong")
+	False(t, authenticated)
+	authenticated, _ = auth.Authenticate("bob", "wrong")
+	False(t, authenticated)
+	Equal(t, int64(3), atomic.LoadInt64(&calls))
+	authenticated, _ = auth.AuthenticateWithContext(context.Background(), "bob", "secret")
+	True(t, authenticated)
+	Equal(t, int64(3), atomic.LoadInt64(&calls))
+
+	// the credentials are only stored as hmac
+	for user, e := range auth.cache.entries {
+		NotContains(t, user, "bob")
+		NotContains(t, e.credentials, "secret")
+	}
+}
+
+func TestProbeCache_Expiry(t *testing.T) {
+	var calls int64
+	ts := newCountingServer(&calls, nil)
+	defer ts.Close()
+	auth := cachedAuth(t, ts.URL, 10*time.Millisecond)
+
+	auth.Authenticate("bob", "secret")
+	time.Sleep(20 * time.Millisecond)
+	auth.Authenticate("bob", "secret")
+	Equal(t, int64(2), atomic.LoadInt64(&calls))
+}
+
+func TestProbeCache_Errors(t *testing.T) {
+	auth := cachedAuth(t, "http://0.0.0.0.0", time.Minute)
+	_, err := auth.Authenticate("bob", "secret")
+	Error(t, err)
+	_, err = auth.Authenticate("bob", "secret")
+	Error(t, err)
+	Equal(t, int64(2), auth.cache.stats()["cache_misses"])
+}
+
+func TestProbeCache_Collapse(t *testing.T) {
+	var calls int64
+	release := make(chan struct{})
+	ts := newCountingServer(&calls, release)
+	defer ts.Close()
+	auth := cachedAuth(t, ts.URL, time.Minute)
+
+	const clients = 5
+	var wg sync.WaitGroup
+	for i := 0; i < clients; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			authenticated, err := auth.Authenticate("bob", "secret")
+			NoError(t, err)
+			True(t, authenticated)
+		}()
+	}
+
+	// release the upstream, when all clients wait for the probe
+	for {
+		stats := auth.cache.stats()
+		if stats["cache_misses"]+stats["cache_collapsed"] == clients {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	close(release)
+	wg.Wait()
+
+	Equal(t, int64(1), atomic.LoadInt64(&calls))
+	Equal(t, int64(clients-1), auth.cache.stats()["cache_collapsed"])
+}
+
+func TestProbeCache_RejectedCredentials(t *testing.T) {
+	c := newProbeCache(time.Minute)
+	c.do(context.Background(), "bob", "secret", func() (bool, error) { return true, nil })
+	Len(t, c.entries, 1)
+
+	// the upstream rejects the cached credentials, e.g. after a password change
+	c.entries[c.mac("bob")] = probeEntry{credentials: c.mac("bob", "secret"), expires: time.Now().Add(-time.Second)}
+	authenticated, err := c.do(context.Background(), "bob", "secret", func() (bool, error) { return false, nil })
+	NoError(t, err)
+	False(t, authenticated)
+	Len(t, c.entries, 0)
+}
+
+func TestProbeCache_CanceledCaller(t *testing.T) {
+	var calls int64
+	release := make(chan struct{})
+	ts := newCountingServer(&calls, release)
+	defer ts.Close()
+	auth := cachedAuth(t, ts.URL, time.Minute)
+
+	// the first caller gives up, while the second one waits for the same probe
+	ctx, cancel := context.WithCancel(context.Background())
+	first := make(chan error)
+	go func() {
+		_, err := auth.AuthenticateWithContext(ctx, "bob", "secret")
+		first <- err
+	}()
+	second := make(chan bool)
+	go func() {
+		for auth.cache.stats()["cache_misses"] == 0 {
+			time.Sleep(time.Millisecond)
+		}
+		authenticated, err := auth.AuthenticateWithContext(context.Background(), "bob", "secret")
+		NoError(t, err)
+		second <- authenticated
+	}()
+	for auth.cache.stats()["cache_collapsed"] == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	cancel()
+	Equal(t, context.Canceled, <-first)
+
+	close(release)
+	True(t, <-second)
+	Equal(t, int64(1), atomic.LoadInt64(&calls))
+}
+
+func TestProbeCache_Timeout(t *testing.T) {
+	release := make(chan struct{})
+	var calls int64
+	ts := newCountingServer(&calls, release)
+	defer ts.Close()
+	defer close(release)
+	auth := cachedAuth(t, ts.URL, time.Minute)
+	auth.timeout = 20 * time.Millisecond
+
+	// the shared probe has its own timeout
+	_, err := auth.AuthenticateWithContext(context.Background(), "bob", "secret")
+	Error(t, err)
+}
+
+func TestProbeCache_Key(t *testing.T) {
+	c := newProbeCache(time.Minute)
+	c.setKey([]byte("key1"))
+	mac1 := c.mac("bob", "secret")
+	NotEqual(t, c.mac("bobs", "ecret"), mac1)
+	c.setKey([]byte("key2"))
+	NotEqual(t, c.mac("bob", "secret"), mac1)
+	False(t, strings.Contains(mac1, "secret"))
+}
@@ -1,9 +1,13 @@
 package logging
 
 import (
+	"bufio"
+	"errors"
 	"fmt"
+	"net"
 	"net/http"
 	"runtime"
+	"runtime/debug"
 	"strings"
 	"time"
 )
@@ -21,17 +25,31 @@ func NewLogMiddleware(next http.Handler) *LogMiddleware {
 func (mw *LogMiddleware) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	EnsureCorrelationId(r)
 	start := time.Now()
+	lrw := &logResponseWriter{ResponseWriter: w}
 
 	defer func() {
 		if rec := recover(); rec != nil {
-			AccessError(r, start, fmt.Errorf("PANIC (%v): %v", identifyLogOrigin(), rec))
+			if rec == http.ErrAbortHandler {
+				panic(rec)
+			}
+			accessPanic(r, start, fmt.Errorf("PANIC (%v): %v", identifyLogOrigin(), rec), lrw.statusCode, lrw.size, debug.Stack())
+
+			// a partial response can not be turned into an error any more,
+			// so the connection is aborted to not let it look complete
+			if lrw.statusCode != 0 {
+				panic(http.ErrAbortHandler)
+			}
+			http.Error(lrw, "Internal Server Error", http.StatusInternalServerError)
 		}
 	}()
 
-	lrw := &logResponseWriter{ResponseWriter: w}
 	mw.Next.ServeHTTP(lrw, r)
 
-	Access(r, start, lrw.statusCode)
+	if lrw.statusCode == 0 {
+		// net/http responds with 200, if the handler writes nothing
+		lrw.statusCode = http.StatusOK
+	}
+	AccessResponse(r, start, lrw.statusCode, lrw.size)
 }
 
 // identifyLogOrigin returns the location, where a panic was raised
@@ -64,19 +82,47 @@ func identifyLogOrigin() string {
 	return fmt.Sprintf("pc:%x", pc)
 }
 
+// logResponseWriter records the status code and the number of bytes of the response
 type logResponseWriter struct {
 	http.ResponseWriter
 	statusCode int
+	size       int64
 }
 
 func (lrw *logResponseWriter) Write(b []byte) (int, error) {
 	if lrw.statusCode == 0 {
 		lrw.statusCode = 200
 	}
-	return lrw.ResponseWriter.Write(b)
+	n, err := lrw.ResponseWriter.Write(b)
+	lrw.size += int64(n)
+	return n, err
 }
 
 func (lrw *logResponseWriter) WriteHeader(statusCode int) {
-	lrw.statusCode = statusCode
+	if lrw.statusCode == 0 {
+		lrw.statusCode = statusCode
+	}
 	lrw.ResponseWriter.WriteHeader(statusCode)
 }
+
+// Flush implements http.Flusher, if the wrapped writer supports it
+func (lrw *logResponseWriter) Flush() {
+	if f, ok := lrw.ResponseWriter.(http.Flusher); ok {
+		if lrw.statusCode == 0 {
+			lrw.statusCode = 200
+		}
+		f.Flush()
+	}
+}
+
+// Hijack implements http.Hijacker, if the wrapped writer supports it
+func (lrw *logResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := lrw.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("the response writer does not support hijacking")
+	}
+	if lrw.statusCode == 0 {
+		lrw.statusCode = http.StatusSwitchingProtocols
+	}
+	return h.Hijack()
+}
@@ -3,6 +3,7 @@ package logging
 import (
 	"bytes"
 	"github.com/stretchr/testify/assert"
+	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
 	"testing"
@@ -22,14 +23,138 @@ func Test_LogMiddleware_Panic(t *testing.T) {
 	}))
 
 	r, _ := http.NewRequest("GET", "http://www.example.org/foo", nil)
+	r.Header.Set(CorrelationIdHeader, "correlation-123")
 
-	lm.ServeHTTP(httptest.NewRecorder(), r)
+	w := httptest.NewRecorder()
+	lm.ServeHTTP(w, r)
 
 	data := logRecordFromBuffer(b)
 	a.Contains(data.Error, "logging.Test_LogMiddleware_Panic.func1")
 	a.Contains(data.Error, "runtime error: index out of range")
 	a.Contains(data.Message, "ERROR ->GET /foo")
 	a.Equal(data.Level, "error")
+	a.Equal("correlation-123", data.CorrelationId)
+	a.Contains(data.Stack, "log_middleware_test.go")
+
+	// and the client gets a clean 500
+	a.Equal(500, w.Code)
+	a.Equal("Internal Server Error\n", w.Body.String())
+}
+
+func Test_LogMiddleware_Panic_AfterPartialBody(t *testing.T) {
+	a := assert.New(t)
+
+	// given: a logger
+	b := bytes.NewBuffer(nil)
+	Logger.Out = b
+
+	// and a handler which raises a panic after writing a part of the body
+	lm := NewLogMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("partial"))
+		panic("oops")
+	}))
+
+	r, _ := http.NewRequest("GET", "http://www.example.org/foo", nil)
+
+	// the connection is aborted, because the status can not be changed any more
+	var rec interface{}
+	func() {
+		defer func() { rec = recover() }()
+		lm.ServeHTTP(httptest.NewRecorder(), r)
+	}()
+	a.Equal(http.ErrAbortHandler, rec)
+
+	data := logRecordFromBuffer(b)
+	a.Contains(data.Error, "oops")
+	a.Equal(200, data.ResponseStatus)
+	a.Equal(int64(7), data.ResponseSize)
+	a.NotEmpty(data.Stack)
+	a.Equal("error", data.Level)
+}
+
+func Test_LogMiddleware_Panic_ServedAbort(t *testing.T) {
+	a := assert.New(t)
+
+	b := bytes.NewBuffer(nil)
+	Logger.Out = b
+
+	// given: a server with a handler which panics after writing a part of the body
+	server := httptest.NewServer(NewLogMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Length", "100")
+		w.Write([]byte("partial"))
+		panic("oops")
+	})))
+	defer server.Close()
+
+	// then: the client does not get a complete response
+	resp, err := http.Get(server.URL)
+	if err == nil {
+		_, err = ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+	}
+	a.Error(err)
+}
+
+func Test_LogMiddleware_Log_Size(t *testing.T) {
+	a := assert.New(t)
+
+	b := bytes.NewBuffer(nil)
+	Logger.Out = b
+
+	lm := NewLogMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(201)
+		w.Write([]byte("hello"))
+		w.Write([]byte(" world"))
+	}))
+
+	r, _ := http.NewRequest("GET", "http://www.example.org/foo", nil)
+	lm.ServeHTTP(httptest.NewRecorder(), r)
+
+	data := logRecordFromBuffer(b)
+	a.Equal(201, data.ResponseStatus)
+	a.Equal(int64(11), data.ResponseSize)
+}
+
+func Test_LogMiddleware_Log_NoWrite(t *testing.T) {
+	a := assert.New(t)
+
+	b := bytes.NewBuffer(nil)
+	Logger.Out = b
+
+	lm := NewLogMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	r, _ := http.NewRequest("GET", "http://www.example.org/foo", nil)
+	lm.ServeHTTP(httptest.NewRecorder(), r)
+
+	data := logRecordFromBuffer(b)
+	a.Equal("200 ->GET /foo", data.Message)
+	a.Equal(200, data.ResponseStatus)
+	a.Equal(int64(0), data.ResponseSize)
+}
+
+func Test_LogMiddleware_Flusher_Hijacker(t *testing.T) {
+	a := assert.New(t)
+
+	b := bytes.NewBuffer(nil)
+	Logger.Out = b
+
+	lm := NewLogMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		f, ok := w.(http.Flusher)
+		a.True(ok)
+		f.Flush()
+
+		// the recorder does not support hijacking
+		_, _, err := w.(http.Hijacker).Hijack()
+		a.Error(err)
+	}))
+
+	r, _ := http.NewRequest("GET", "http://www.example.org/foo", nil)
+	w := httptest.NewRecorder()
+	lm.ServeHTTP(w, r)
+
+	a.True(w.Flushed)
+	data := logRecordFromBuffer(b)
+	a.Equal(200, data.ResponseStatus)
 }
 
 func Test_LogMiddleware_Log_implicit200(t *testing.T) {
@@ -41,8 +41,15 @@ func Set(level string, textLogging bool) error {
 
 // Access logs an access entry with call duration and status code
 func Access(r *http.Request, start time.Time, statusCode int) {
-	e := access(r, start, statusCode, nil)
+	logAccess(access(r, start, statusCode, nil), r, statusCode)
+}
+
+// AccessResponse logs an access entry with call duration, status code and size of the response body
+func AccessResponse(r *http.Request, start time.Time, statusCode int, size int64) {
+	logAccess(access(r, start, statusCode, nil).WithField("response_size", size), r, statusCode)
+}
 
+func logAccess(e *logrus.Entry, r *http.Request, statusCode int) {
 	var msg string
 	if len(r.URL.RawQuery) == 0 {
 		msg = fmt.Sprintf("%v ->%v %v", statusCode, r.Method, r.URL.Path)
@@ -65,6 +72,16 @@ func AccessError(r *http.Request, start time.Time, err error) {
 	e.Errorf("ERROR ->%v %v", r.Method, r.URL.Path)
 }
 
+// accessPanic logs a panic while accessing with the stack
+// and the status and size of the response written so far
+func accessPanic(r *http.Request, start time.Time, err error, statusCode int, size int64, stack []byte) {
+	e := access(r, start, statusCode, err).WithField("stack", string(stack))
+	if statusCode != 0 {
+		e = e.WithField("response_size", size)
+	}
+	e.Errorf("ERROR ->%v %v", r.Method, r.URL.Path)
+}
+
 func access(r *http.Request, start time.Time, statusCode int, err error) *logrus.Entry {
 	url := r.URL.Path
 	if r.URL.RawQuery != "" {
@@ -175,43 +192,56 @@ func Application(h http.Header) *logrus.Entry {
 	return Logger.WithFields(fields)
 }
 
-// LifecycleStart logs the start of an application
-// with the configuration struct or map as paramter.
-func LifecycleStart(appName string, args interface{}) {
-	fields := logrus.Fields{}
+// StartInfo are the machine readable fields of the start event of an application
+type StartInfo struct {
+	Version        string
+	ConfigHash     string
+	ListenAddr     string
+	Backends       []string
+	OauthProviders []string
+	// Config is the configuration struct or map, which is logged as config object
+	Config interface{}
+}
+
+// ShutdownInfo are the machine readable fields of the end of the graceful shutdown of an application
+type ShutdownInfo struct {
+	Signal   os.Signal
+	Duration time.Duration
+	// GracePeriodExhausted is set, if the shutdown was cut off at the end of the grace period
+	GracePeriodExhausted bool
+	Err                  error
+}
 
-	jsonString, err := json.Marshal(args)
+// LifecycleStart logs the start of an application.
+// The fields are always set, so that they can be relied on by alerts, e.g. on a changed config_hash.
+func LifecycleStart(appName string, info StartInfo) {
+	fields := lifecycleFields(appName, "start")
+	fields["version"] = info.Version
+	fields["config_hash"] = info.ConfigHash
+	fields["listen_addr"] = info.ListenAddr
+	fields["backends"] = nonNil(info.Backends)
+	fields["oauth_providers"] = nonNil(info.OauthProviders)
+
+	config := map[string]interface{}{}
+	jsonString, err := json.Marshal(info.Config)
 	if err == nil {
-		err := json.Unmarshal(jsonString, &fields)
-		if err != nil {
-			fields["parse_error"] = err.Error()
-		}
+		err = json.Unmarshal(jsonString, &config)
 	}
-	fields["type"] = "lifecycle"
-	fields["event"] = "start"
-	for _, env := range LifecycleEnvVars {
-		if os.Getenv(env) != "" {
-			fields[strings.ToLower(env)] = os.Getenv(env)
-		}
+	if err != nil {
+		fields["parse_error"] = err.Error()
 	}
+	fields["config"] = config
 
 	Logger.WithFields(fields).Infof("starting application: %v", appName)
 }
 
 // LifecycleStop logs the stop of an application
 func LifecycleStop(appName string, signal os.Signal, err error) {
-	fields := logrus.Fields{
-		"type":  "lifecycle",
-		"event": "stop",
-	}
+	fields := lifecycleFields(appName, "stop")
 	if signal != nil {
 		fields["signal"] = signal.String()
 	}
 
-	if os.Getenv("BUILD_NUMBER") != "" {
-		fields["build_number"] = os.Getenv("BUILD_NUMBER")
-	}
-
 	if err != nil {
 		Logger.WithFields(fields).
 			WithError(err).
@@ -221,18 +251,73 @@ func LifecycleStop(appName string, signal os.Signal, err error) {
 	}
 }
 
-// LifecycleStop logs the stop of an application
+// LifecycleShutdown logs the end of the graceful shutdown of an application with its duration in milliseconds
+func LifecycleShutdown(appName string, info ShutdownInfo) {
+	fields := lifecycleFields(appName, "shutdown")
+	fields["signal"] = ""
+	if info.Signal != nil {
+		fields["signal"] = info.Signal.String()
+	}
+	fields["duration"] = int64(info.Duration / time.Millisecond)
+	fields["grace_period_exhausted"] = info.GracePeriodExhausted
+
+	entry := Logger.WithFields(fields)
+	if info.Err != nil {
+		entry = entry.WithError(info.Err)
+	}
+	switch {
+	case info.GracePeriodExhausted:
+		entry.Warnf("shutdown of application cut off by the grace period: %v", appName)
+	case info.Err != nil:
+		entry.Errorf("shutdown of application failed: %v (%v)", appName, info.Err)
+	default:
+		entry.Infof("shutdown of application completed: %v", appName)
+	}
+}
+
+// ServerClosed logs the close of the http server of an application
 func ServerClosed(appName string) {
+	Logger.WithFields(lifecycleFields(appName, "server_closed")).Infof("http server was closed: %v", appName)
+}
+
+// lifecycleFields returns the fields common to all lifecycle events, including the build information of the environment
+func lifecycleFields(appName, event string) logrus.Fields {
 	fields := logrus.Fields{
-		"type":  "application",
-		"event": "stop",
+		"type":  "lifecycle",
+		"event": event,
+		"app":   appName,
 	}
+	for _, env := range LifecycleEnvVars {
+		if os.Getenv(env) != "" {
+			fields[strings.ToLower(env)] = os.Getenv(env)
+		}
+	}
+	return fields
+}
+
+func nonNil(list []string) []string {
+	if list == nil {
+		return []string{}
+	}
+	return list
+}
 
-	if os.Getenv("BUILD_NUMBER") != "" {
-		fields["build_number"] = os.Getenv("BUILD_NUMBER")
+// ComponentStopped logs the shutdown of a component with background goroutines
+func ComponentStopped(name string, duration time.Duration, err error) {
+	fields := logrus.Fields{
+		"type":      "lifecycle",
+		"event":     "component_stop",
+		"component": name,
+		"duration":  int64(duration / time.Millisecond),
 	}
 
-	Logger.WithFields(fields).Infof("http server was closed: %v", appName)
+	if err != nil {
+		Logger.WithFields(fields).
+			WithError(err).
+			Warnf("stopping component failed: %v (%v)", name, err)
+	} else {
+		Logger.WithFields(fields).Infof("stopped component: %v", name)
+	}
 }
 
 func getRemoteIp(r *http.Request) string {
@@ -23,6 +23,8 @@ type logReccord struct {
 	Proto             string            `json:"proto"`
 	Duration          int               `json:"duration"`
 	ResponseStatus    int               `json:"response_status"`
+	ResponseSize      int64             `json:"response_size"`
+	Stack             string            `json:"stack"`
 	UserCorrelationId string            `json:"user_correlation_id"`
 	Cookies           map[string]string `json:"cookies"`
 	Error             string            `json:"error"`
@@ -212,6 +214,15 @@ func Test_Logger_Application(t *testing.T) {
 	a.Equal("user-id-xyz", entry.Data["user_correlation_id"])
 }
 
+// assertFields checks, that the record has exactly the fields of the schema
+func assertFields(t *testing.T, data map[string]interface{}, fields ...string) {
+	keys := []string{}
+	for key := range data {
+		keys = append(keys, key)
+	}
+	assert.ElementsMatch(t, append(fields, "@timestamp", "@version", "level", "message"), keys)
+}
+
 func Test_Logger_LifecycleStart(t *testing.T) {
 	a := assert.New(t)
 
@@ -232,16 +243,39 @@ func Test_Logger_LifecycleStart(t *testing.T) {
 	os.Setenv("BUILD_NUMBER", "b666")
 
 	// when a LifecycleStart is logged
-	LifecycleStart("my-app", someArguments)
+	LifecycleStart("my-app", StartInfo{
+		Version:        "v1.2.0",
+		ConfigHash:     "0123456789abcdef",
+		ListenAddr:     ":6789",
+		Backends:       []string{"htpasswd", "simple"},
+		OauthProviders: []string{"github"},
+		Config:         someArguments,
+	})
 
 	// then: it is logged
 	data := mapFromBuffer(b)
+	assertFields(t, data, "type", "event", "app", "version", "config_hash", "listen_addr", "backends", "oauth_providers", "config", "build_number")
 	a.Equal("info", data["level"])
+	a.Equal("starting application: my-app", data["message"])
 	a.Equal("lifecycle", data["type"])
 	a.Equal("start", data["event"])
-	a.Equal("bar", data["Foo"])
-	a.Equal(42.0, data["Number"])
+	a.Equal("my-app", data["app"])
+	a.Equal("v1.2.0", data["version"])
+	a.Equal("0123456789abcdef", data["config_hash"])
+	a.Equal(":6789", data["listen_addr"])
+	a.Equal([]interface{}{"htpasswd", "simple"}, data["backends"])
+	a.Equal([]interface{}{"github"}, data["oauth_providers"])
+	// the configuration does not mix with the fields of the event
+	a.Equal(map[string]interface{}{"Foo": "bar", "Number": 42.0}, data["config"])
 	a.Equal("b666", data["build_number"])
+
+	// the fields are kept without values
+	b.Reset()
+	LifecycleStart("my-app", StartInfo{})
+	data = mapFromBuffer(b)
+	assertFields(t, data, "type", "event", "app", "version", "config_hash", "listen_addr", "backends", "oauth_providers", "config", "build_number")
+	a.Equal([]interface{}{}, data["backends"])
+	a.Equal([]interface{}{}, data["oauth_providers"])
 }
 
 func Test_Logger_LifecycleStop_ByInterrupt(t *testing.T) {
@@ -259,6 +293,7 @@ func Test_Logger_LifecycleStop_ByInterrupt(t *testing.T) {
 
 	// then: it is logged
 	data := mapFromBuffer(b)
+	assertFields(t, data, "type", "event", "app", "signal", "build_number")
 	a.Equal("info", data["level"])
 	a.Equal("stopping application: my-app (interrupt)", data["message"])
 	a.Equal("lifecycle", data["type"])
@@ -282,14 +317,63 @@ func Test_Logger_LifecycleStop_ByError(t *testing.T) {
 
 	// then: it is logged
 	data := mapFromBuffer(b)
+	assertFields(t, data, "type", "event", "app", "error", "build_number")
 	a.Equal("error", data["level"])
 	a.Equal("stopping application: my-app (error)", data["message"])
 	a.Equal("lifecycle", data["type"])
 	a.Equal("stop", data["event"])
 	a.Equal(nil, data["signal"])
+	a.Equal("error", data["error"])
 	a.Equal("b666", data["build_number"])
 }
 
+func Test_Logger_LifecycleShutdown(t *testing.T) {
+	a := assert.New(t)
+
+	// given a logger
+	b := bytes.NewBuffer(nil)
+	Logger.Out = b
+	os.Setenv("BUILD_NUMBER", "b666")
+
+	// when a completed shutdown is logged
+	LifecycleShutdown("my-app", ShutdownInfo{Signal: os.Interrupt, Duration: 1500 * time.Millisecond})
+
+	// then: it is logged
+	data := mapFromBuffer(b)
+	assertFields(t, data, "type", "event", "app", "signal", "duration", "grace_period_exhausted", "build_number")
+	a.Equal("info", data["level"])
+	a.Equal("shutdown of application completed: my-app", data["message"])
+	a.Equal("lifecycle", data["type"])
+	a.Equal("shutdown", data["event"])
+	a.Equal("interrupt", data["signal"])
+	a.Equal(float64(1500), data["duration"])
+	a.Equal(false, data["grace_period_exhausted"])
+
+	// when the grace period is exhausted
+	b.Reset()
+	LifecycleShutdown("my-app", ShutdownInfo{Duration: 5 * time.Second, GracePeriodExhausted: true, Err: errors.New("context deadline exceeded")})
+
+	// then: it is logged as warning with the error
+	data = mapFromBuffer(b)
+	assertFields(t, data, "type", "event", "app", "signal", "duration", "grace_period_exhausted", "error", "build_number")
+	a.Equal("warning", data["level"])
+	a.Equal("shutdown of application cut off by the grace period: my-app", data["message"])
+	a.Equal("", data["signal"])
+	a.Equal(true, data["grace_period_exhausted"])
+	a.Equal("context deadline exceeded", data["error"])
+
+	// when a component failed to stop
+	b.Reset()
+	LifecycleShutdown("my-app", ShutdownInfo{Signal: os.Interrupt, Err: errors.New("error stopping: state-file")})
+
+	// then: it is logged as error
+	data = mapFromBuffer(b)
+	a.Equal("error", data["level"])
+	a.Equal("shutdown of application failed: my-app (error stopping: state-file)", data["message"])
+	a.Equal(false, data["grace_period_exhausted"])
+	a.Equal("error stopping: state-file", data["error"])
+}
+
 func Test_Logger_ServerClosed(t *testing.T) {
 	a := assert.New(t)
 
@@ -305,13 +389,44 @@ func Test_Logger_ServerClosed(t *testing.T) {
 
 	// then: it is logged
 	data := mapFromBuffer(b)
+	assertFields(t, data, "type", "event", "app", "build_number")
 	a.Equal("info", data["level"])
 	a.Equal("http server was closed: my-app", data["message"])
-	a.Equal("application", data["type"])
-	a.Equal("stop", data["event"])
+	a.Equal("lifecycle", data["type"])
+	a.Equal("server_closed", data["event"])
 	a.Equal("b666", data["build_number"])
 }
 
+func Test_Logger_ComponentStopped(t *testing.T) {
+	a := assert.New(t)
+
+	// given a logger
+	b := bytes.NewBuffer(nil)
+	Logger.Out = b
+
+	// when a component stop is logged
+	ComponentStopped("my-component", 1500*time.Millisecond, nil)
+
+	// then: it is logged
+	data := mapFromBuffer(b)
+	a.Equal("info", data["level"])
+	a.Equal("stopped component: my-component", data["message"])
+	a.Equal("lifecycle", data["type"])
+	a.Equal("component_stop", data["event"])
+	a.Equal("my-component", data["component"])
+	a.Equal(float64(1500), data["duration"])
+
+	// when a failed component stop is logged
+	b.Reset()
+	ComponentStopped("my-component", time.Second, errors.New("timeout"))
+
+	// then: it is logged as warning
+	data = mapFromBuffer(b)
+	a.Equal("warning", data["level"])
+	a.Equal("stopping component failed: my-component (timeout)", data["message"])
+	a.Equal("timeout", data["error"])
+}
+
 func Test_Logger_Cacheinfo(t *testing.T) {
 	a := assert.New(t)
 
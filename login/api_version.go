@@ -0,0 +1,209 @@
+package login
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// apiVersionHeader is used for requesting a version of the json api
+// and is set on all responses with the version used for rendering.
+const apiVersionHeader = "X-Login-API-Version"
+
+const contentTypeProblemJSON = "application/problem+json; charset=utf-8"
+
+// The versions of the api contract for non html clients:
+// Version 1 responds with the bare jwt and plain text errors,
+// version 2 with a json token object and problem+json (RFC 7807) errors.
+const (
+	apiVersion1 = 1
+	apiVersion2 = 2
+)
+
+var supportedAPIVersions = []int{apiVersion1, apiVersion2}
+
+// apiError is the result of a failed request,
+// which is rendered depending on the api version.
+type apiError struct {
+	status  int
+	code    string
+	message string
+}
+
+var (
+	errAPIBadRequest          = apiError{400, "bad_request", "Bad Request: Method or content-type not supported"}
+	errAPINotFound            = apiError{404, "not_found", "Not Found: The requested page does not exist"}
+	errAPIWrongCredentials    = apiError{403, "wrong_credentials", "Wrong credentials"}
+	errAPIMaxRefreshes        = apiError{403, "max_refreshes_reached", "Max JWT refreshes reached"}
+	errAPINotRefreshable      = apiError{403, "not_refreshable", "JWT is not refreshable"}
+	errAPIMaxSession          = apiError{403, "max_session_reached", "Max JWT session lifetime reached"}
+	errAPIInternal            = apiError{500, "internal_error", "Internal Server Error"}
+	errAPIClientClosedRequest = apiError{statusClientClosedRequest, "client_closed_request", "Client Closed Request"}
+)
+
+// problem is the version 2 error body
+type problem struct {
+	Type   string `json:"type"`
+	Title  string `json:"title"`
+	Status int    `json:"status"`
+	Detail string `json:"detail"`
+	Code   string `json:"code"`
+}
+
+// tokenResponse is the version 2 body of a successful login or refresh
+type tokenResponse struct {
+	Token     string `json:"token"`
+	TokenType string `json:"token_type"`
+	ExpiresAt int64  `json:"expires_at"`
+	Sub       string `json:"sub,omitempty"`
+
+	LegacyToken string `json:"legacy_token,omitempty"`
+	// RefreshToken is the separate refresh token of the -jwt-refresh-token-expiry
+	RefreshToken     string `json:"refresh_token,omitempty"`
+	RefreshExpiresAt int64  `json:"refresh_expires_at,omitempty"`
+}
+
+func validAPIVersion(version int) bool {
+	for _, v := range supportedAPIVersions {
+		if v == version {
+			return true
+		}
+	}
+	return false
+}
+
+// defaultAPIVersion is the version for clients, which don't request one
+func (h *Handler) defaultAPIVersion() int {
+	if h.config.APIVersion == 0 {
+		return apiVersion1
+	}
+	return h.config.APIVersion
+}
+
+// negotiateAPIVersion returns the api version requested by the X-Login-API-Version header
+// or the version parameter of an application/json accept header, e.g. `application/json; version=2`.
+// The header takes precedence. Without a requested version, the configured default is used.
+func (h *Handler) negotiateAPIVersion(r *http.Request) (int, error) {
+	requested := r.Header.Get(apiVersionHeader)
+	if requested == "" {
+		requested = acceptedAPIVersion(r.Header.Get("Accept"))
+	}
+	if requested == "" {
+		return h.defaultAPIVersion(), nil
+	}
+
+	version, err := strconv.Atoi(strings.TrimSpace(requested))
+	if err != nil || !validAPIVersion(version) {
+		return 0, fmt.Errorf("Unsupported API version %q, supported versions: %v", requested, supportedAPIVersionList())
+	}
+	return version, nil
+}
+
+// isAPIRequest checks, if the request is answered by the json api, which rejects unsupported api versions.
+// Html clients, e.g. the login form, and the oauth redirects and callbacks of browsers ignore the requested version.
+func (h *Handler) isAPIRequest(r *http.Request) bool {
+	if h.wantHTML(r) {
+		return false
+	}
+	_, err := h.oauth.GetConfigFromRequest(r)
+	return err != nil
+}
+
+// apiVersion is the version, the response to the request is rendered with.
+// Unsupported versions are rejected in ServeHTTP for api requests, otherwise they fall back to the default.
+func (h *Handler) apiVersion(r *http.Request) int {
+	version, err := h.negotiateAPIVersion(r)
+	if err != nil {
+		return h.defaultAPIVersion()
+	}
+	return version
+}
+
+// acceptedAPIVersion returns the version parameter of the json media type with the highest quality in the accept header.
+// Ranges with the quality 0 are not acceptable, so their version is ignored.
+func acceptedAPIVersion(accept string) string {
+	version, q := "", 0.0
+	for _, m := range parseAccept(accept) {
+		if m.mediaType != "application/json" && m.mediaType != "application/problem+json" {
+			continue
+		}
+		if v, exist := m.params["version"]; exist && m.q > q {
+			version, q = v, m.q
+		}
+	}
+	return version
+}
+
+func supportedAPIVersionList() string {
+	versions := make([]string, len(supportedAPIVersions))
+	for i, v := range supportedAPIVersions {
+		versions[i] = strconv.Itoa(v)
+	}
+	return strings.Join(versions, ", ")
+}
+
+// respondUnsupportedAPIVersion rejects requests for unknown api versions.
+// The response is rendered in the default version.
+func (h *Handler) respondUnsupportedAPIVersion(w http.ResponseWriter, r *http.Request, err error) {
+	writeAPIError(w, h.defaultAPIVersion(), apiError{400, "unsupported_api_version", err.Error()})
+}
+
+// respondAPIError renders the error in the api version of the request
+func (h *Handler) respondAPIError(w http.ResponseWriter, r *http.Request, e apiError) {
+	writeAPIError(w, h.apiVersion(r), e)
+}
+
+func writeAPIError(w http.ResponseWriter, version int, e apiError) {
+	if version == apiVersion1 {
+		writeText(w, e.status, e.message)
+		return
+	}
+
+	title := http.StatusText(e.status)
+	if title == "" {
+		title = e.message
+	}
+	body, err := json.Marshal(problem{
+		Type:   "about:blank",
+		Title:  title,
+		Status: e.status,
+		Detail: e.message,
+		Code:   e.code,
+	})
+	if err != nil {
+		respondInternalError(w)
+		return
+	}
+	w.Header().Set("Content-Type", contentTypeProblemJSON)
+	w.WriteHeader(e.status)
+	w.Write(body)
+}
+
+// respondToken renders the token of a successful login or refresh in the api version of the request.
+// The optional legacy token is added during a rollover, the optional refresh token with -jwt-refresh-token-expiry.
+// Version 1 returns both in headers, except for clients preferring json, which get the json token object of version 2.
+func (h *Handler) respondToken(w http.ResponseWriter, r *http.Request, response tokenResponse) {
+	if h.apiVersion(r) == apiVersion1 && !negotiateJSON(r.Header.Get("Accept")) {
+		if response.LegacyToken != "" {
+			w.Header().Set(legacyTokenHeader, response.LegacyToken)
+		}
+		if response.RefreshToken != "" {
+			w.Header().Set(refreshTokenHeader, response.RefreshToken)
+		}
+		w.Header().Set("Content-Type", contentTypeJWT)
+		w.WriteHeader(200)
+		fmt.Fprintf(w, "%s", response.Token)
+		return
+	}
+
+	body, err := json.Marshal(response)
+	if err != nil {
+		respondInternalError(w)
+		return
+	}
+	w.Header().Set("Content-Type", contentTypeJSON)
+	w.WriteHeader(200)
+	w.Write(body)
+}
@@ -0,0 +1,228 @@
+package login
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	. "github.com/stretchr/testify/assert"
+	"github.com/tarent/loginsrv/model"
+	"github.com/tarent/loginsrv/oauth2"
+)
+
+func TestAPIVersion_Negotiation(t *testing.T) {
+	h := testHandler()
+
+	testCases := []struct {
+		name    string
+		headers []string
+		version int
+		valid   bool
+	}{
+		{"default", nil, 1, true},
+		{"header", []string{"X-Login-API-Version: 2"}, 2, true},
+		{"accept", []string{"Accept: application/json; version=2"}, 2, true},
+		{"accept with other types", []string{"Accept: text/plain, application/json;version=2;q=0.9"}, 2, true},
+		{"header before accept", []string{"X-Login-API-Version: 1", "Accept: application/json; version=2"}, 1, true},
+		{"accept without version", []string{"Accept: application/json"}, 1, true},
+		{"unsupported", []string{"X-Login-API-Version: 3"}, 0, false},
+		{"invalid", []string{"Accept: application/json; version=latest"}, 0, false},
+	}
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			version, err := h.negotiateAPIVersion(req("GET", "/context/login", "", test.headers...))
+			if test.valid {
+				NoError(t, err)
+				Equal(t, test.version, version)
+			} else {
+				Error(t, err)
+			}
+		})
+	}
+}
+
+func TestAPIVersion_ConfiguredDefault(t *testing.T) {
+	h := testHandler()
+	h.config.APIVersion = 2
+
+	recorder := call(req("POST", "/context/login", "username=bob&password=secret", TypeForm, AcceptJwt))
+
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("POST", "/context/login", "username=bob&password=secret", TypeForm))
+	Equal(t, 200, recorder.Code)
+	Equal(t, "2", recorder.Header().Get(apiVersionHeader))
+	Equal(t, contentTypeJSON, recorder.Header().Get("Content-Type"))
+
+	// old clients can still request version 1
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("POST", "/context/login", "username=bob&password=secret", TypeForm, "X-Login-API-Version: 1"))
+	Equal(t, contentTypeJWT, recorder.Header().Get("Content-Type"))
+
+	cfg := testConfig()
+	cfg.Backends = Options{"simple": {"bob": "secret"}}
+	cfg.APIVersion = 3
+	_, err := NewHandler(cfg)
+	EqualError(t, err, "No such api version: 3, supported versions: 1, 2")
+}
+
+func TestAPIVersion_Header(t *testing.T) {
+	// the header is set on all responses, also html ones
+	recorder := call(req("GET", "/context/login", "", AcceptHTML))
+
+	recorder = call(req("GET", "/context/login", "", AcceptHTML, "X-Login-API-Version: 2"))
+	Equal(t, "2", recorder.Header().Get(apiVersionHeader))
+
+	recorder = call(req("GET", "/context/login/health", "", "X-Login-API-Version: 2"))
+	Equal(t, "2", recorder.Header().Get(apiVersionHeader))
+}
+
+func TestAPIVersion_Unsupported(t *testing.T) {
+	recorder := call(req("POST", "/context/login", "username=bob&password=secret", TypeForm, "X-Login-API-Version: 3"))
+	Equal(t, 400, recorder.Code)
+	Equal(t, contentTypePlain, recorder.Header().Get("Content-Type"))
+	Equal(t, `Unsupported API version "3", supported versions: 1, 2`, recorder.Body.String())
+
+	h := testHandler()
+	h.config.APIVersion = 2
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("POST", "/context/login", "username=bob&password=secret", TypeForm, "Accept: application/json; version=0"))
+	Equal(t, 400, recorder.Code)
+	Equal(t, "2", recorder.Header().Get(apiVersionHeader))
+	Equal(t, contentTypeProblemJSON, recorder.Header().Get("Content-Type"))
+	p := problem{}
+	NoError(t, json.Unmarshal(recorder.Body.Bytes(), &p))
+	Equal(t, "unsupported_api_version", p.Code)
+}
+
+func TestAPIVersion_UnsupportedIgnoredOutsideAPI(t *testing.T) {
+	// the html login form
+	recorder := call(req("GET", "/context/login", "", AcceptHTML, "X-Login-API-Version: 3"))
+	Equal(t, 200, recorder.Code)
+	Equal(t, "1", recorder.Header().Get(apiVersionHeader))
+
+	// paths outside of the login path
+	recorder = call(req("GET", "/other", "", "X-Login-API-Version: 3"))
+	Equal(t, 404, recorder.Code)
+	Equal(t, "1", recorder.Header().Get(apiVersionHeader))
+
+	// oauth redirects and callbacks
+	h := testHandler()
+	h.oauth = &oauth2ManagerMock{
+		_GetConfigFromRequest: func(r *http.Request) (oauth2.Config, error) {
+			return oauth2.Config{}, nil
+		},
+		_Handle: func(w http.ResponseWriter, r *http.Request) (bool, bool, model.UserInfo, error) {
+			w.Header().Set("Location", "https://github.com/login/oauth/authorize")
+			w.WriteHeader(303)
+			return true, false, model.UserInfo{}, nil
+		},
+	}
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("GET", "/context/login/github", "", "X-Login-API-Version: 3"))
+	Equal(t, 303, recorder.Code)
+
+	// the json api still rejects it
+	recorder = call(req("GET", "/context/login/health", "", "X-Login-API-Version: 3"))
+	Equal(t, 400, recorder.Code)
+}
+
+func TestAPIVersion_Token(t *testing.T) {
+	h := testHandler()
+	v1 := req("POST", "/context/login", "", AcceptJwt)
+	v2 := req("POST", "/context/login", "", AcceptJwt, "X-Login-API-Version: 2")
+	expiry := time.Now().Add(time.Hour).Unix()
+
+	recorder := httptest.NewRecorder()
+	h.respondAuthenticated(recorder, v1, model.UserInfo{Sub: "bob", Expiry: expiry})
+	Equal(t, 200, recorder.Code)
+	Equal(t, contentTypeJWT, recorder.Header().Get("Content-Type"))
+	userInfo, valid := h.GetToken(v1, recorder.Body.String())
+	True(t, valid)
+	Equal(t, "bob", userInfo.Sub)
+
+	recorder = httptest.NewRecorder()
+	h.respondAuthenticated(recorder, v2, model.UserInfo{Sub: "bob", Expiry: expiry})
+	Equal(t, 200, recorder.Code)
+	Equal(t, contentTypeJSON, recorder.Header().Get("Content-Type"))
+	body := tokenResponse{}
+	NoError(t, json.Unmarshal(recorder.Body.Bytes(), &body))
+	Equal(t, "Bearer", body.TokenType)
+	Equal(t, expiry, body.ExpiresAt)
+	Equal(t, "bob", body.Sub)
+	userInfo, valid = h.GetToken(v2, body.Token)
+	True(t, valid)
+	Equal(t, "bob", userInfo.Sub)
+}
+
+func TestAPIVersion_Token_AcceptJSON(t *testing.T) {
+	h := testHandler()
+	expiry := time.Now().Add(time.Hour).Unix()
+
+	// version 1 clients asking for json get the json token object
+	recorder := httptest.NewRecorder()
+	h.respondAuthenticated(recorder, req("POST", "/context/login", "", "Accept: application/json"), model.UserInfo{Sub: "bob", Expiry: expiry})
+	Equal(t, 200, recorder.Code)
+	Equal(t, contentTypeJSON, recorder.Header().Get("Content-Type"))
+	body := tokenResponse{}
+	NoError(t, json.Unmarshal(recorder.Body.Bytes(), &body))
+	Equal(t, "bob", body.Sub)
+	Equal(t, expiry, body.ExpiresAt)
+	userInfo, valid := h.GetToken(req("GET", "/", ""), body.Token)
+	True(t, valid)
+	Equal(t, "bob", userInfo.Sub)
+
+	// the bare jwt stays the default
+	for _, accept := range []string{"", "*/*", "application/jwt", "application/jwt, application/json", "application/json;q=0.5, application/jwt"} {
+		recorder = httptest.NewRecorder()
+		h.respondAuthenticated(recorder, req("POST", "/context/login", "", "Accept: "+accept), model.UserInfo{Sub: "bob", Expiry: expiry})
+		Equal(t, contentTypeJWT, recorder.Header().Get("Content-Type"), accept)
+	}
+}
+
+// TestAPIVersion_Errors renders every error response path in both versions
+func TestAPIVersion_Errors(t *testing.T) {
+	h := testHandler()
+
+	testCases := []struct {
+		name    string
+		respond func(w http.ResponseWriter, r *http.Request)
+		code    int
+		errCode string
+		message string
+	}{
+		{"error", h.respondError, 500, "internal_error", "Internal Server Error"},
+		{"bad request", h.respondBadRequest, 400, "bad_request", "Bad Request: Method or content-type not supported"},
+		{"not found", h.respondNotFound, 404, "not_found", "Not Found: The requested page does not exist"},
+		{"max refreshes", h.respondMaxRefreshesReached, 403, "max_refreshes_reached", "Max JWT refreshes reached"},
+		{"not refreshable", h.respondNotRefreshable, 403, "not_refreshable", "JWT is not refreshable"},
+		{"auth failure", h.respondAuthFailure, 403, "wrong_credentials", "Wrong credentials"},
+		{"maintenance", h.respondMaintenance, 503, "maintenance", h.config.MaintenanceMessage},
+		{"client closed request", func(w http.ResponseWriter, r *http.Request) { h.respondClientClosedRequest(w, r, "bob") },
+			499, "client_closed_request", "Client Closed Request"},
+	}
+	for _, test := range testCases {
+		t.Run(test.name+" v1", func(t *testing.T) {
+			recorder := httptest.NewRecorder()
+			test.respond(recorder, req("POST", "/context/login", ""))
+			Equal(t, test.code, recorder.Code)
+			Equal(t, contentTypePlain, recorder.Header().Get("Content-Type"))
+			Equal(t, test.message, recorder.Body.String())
+		})
+		t.Run(test.name+" v2", func(t *testing.T) {
+			recorder := httptest.NewRecorder()
+			test.respond(recorder, req("POST", "/context/login", "", "Accept: application/json; version=2"))
+			Equal(t, test.code, recorder.Code)
+			Equal(t, contentTypeProblemJSON, recorder.Header().Get("Content-Type"))
+
+			p := problem{}
+			NoError(t, json.Unmarshal(recorder.Body.Bytes(), &p))
+			Equal(t, test.code, p.Status)
+			Equal(t, test.errCode, p.Code)
+			Equal(t, test.message, p.Detail)
+			Equal(t, "about:blank", p.Type)
+			NotEqual(t, "", p.Title)
+		})
+	}
+}
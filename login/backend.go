@@ -2,6 +2,8 @@ package login
 
 import (
 	"context"
+	"crypto/hmac"
+	"crypto/sha256"
 
 	"github.com/tarent/loginsrv/model"
 )
@@ -15,3 +17,38 @@ type Backend interface {
 	Authenticate(username, password string) (bool, model.UserInfo, error)
 	AuthenticateWithContext(ctx context.Context, username, password string) (bool, model.UserInfo, error)
 }
+
+// UserLookup is an optional extension for backends,
+// which are able to list their users without authenticating them.
+// It is used to detect usernames, which are known by more than one backend.
+type UserLookup interface {
+	// ListUsers returns up to limit usernames in a stable order, starting at offset.
+	// An empty result signals, that there are no more users.
+	ListUsers(offset, limit int) ([]string, error)
+}
+
+// KeyedBackend is an optional extension for backends, which need a secret key,
+// e.g. for keying a cache of credentials by an hmac. The key is derived from the jwt secret,
+// distinct for each backend, and set once after the creation of the backend.
+type KeyedBackend interface {
+	SetKey(key []byte)
+}
+
+// BackendStats is an optional extension for backends with counters,
+// which are shown in the health response.
+type BackendStats interface {
+	Stats() map[string]int64
+}
+
+// ReadinessChecker is an optional extension for backends, which depend on a remote service.
+// CheckReady is called periodically in the background and has to return, when the context is done.
+type ReadinessChecker interface {
+	CheckReady(ctx context.Context) error
+}
+
+// backendKey derives the key for the named backend from the jwt secret
+func backendKey(jwtSecret, backendName string) []byte {
+	mac := hmac.New(sha256.New, []byte(jwtSecret))
+	mac.Write([]byte("loginsrv backend key: " + backendName))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,134 @@
+package login
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/tarent/loginsrv/logging"
+	"github.com/tarent/loginsrv/model"
+	"github.com/tarent/loginsrv/outboundtls"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// breakGlassOrigin is the origin of the tokens of break-glass logins
+const breakGlassOrigin = "break-glass"
+
+// breakGlassMinCost is the minimum bcrypt cost of the break-glass accounts
+const breakGlassMinCost = 10
+
+// the timeout for the delivery of the break-glass webhook
+var breakGlassWebhookTimeout = 5 * time.Second
+
+// breakGlass are local emergency accounts for the operators, which are only accepted,
+// if every backend failed with an error, e.g. because the user directory is down.
+// They are never consulted, while at least one backend gives a regular answer.
+type breakGlass struct {
+	users map[string][]byte
+}
+
+// loadBreakGlass reads the accounts from a file with one `username:bcrypt-hash` per line.
+// Other hash algorithms and bcrypt hashes of a low cost are rejected.
+func loadBreakGlass(file string) (*breakGlass, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	b := &breakGlass{users: map[string][]byte{}}
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		entry := strings.TrimSpace(scanner.Text())
+		if entry == "" || strings.HasPrefix(entry, "#") {
+			continue
+		}
+		parts := strings.SplitN(entry, ":", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return nil, fmt.Errorf("%v:%v: expected username:bcrypt-hash", file, line)
+		}
+		hash := []byte(parts[1])
+		cost, err := bcrypt.Cost(hash)
+		if err != nil {
+			return nil, fmt.Errorf("%v:%v: the password of %v has to be a bcrypt hash", file, line, parts[0])
+		}
+		if cost < breakGlassMinCost {
+			return nil, fmt.Errorf("%v:%v: the bcrypt cost of %v has to be at least %v, but was %v", file, line, parts[0], breakGlassMinCost, cost)
+		}
+		b.users[parts[0]] = hash
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(b.users) == 0 {
+		return nil, fmt.Errorf("no break-glass accounts in %v", file)
+	}
+	return b, nil
+}
+
+// authenticate checks the password of the break-glass account
+func (b *breakGlass) authenticate(username, password string) (bool, model.UserInfo) {
+	hash, exist := b.users[username]
+	if !exist || bcrypt.CompareHashAndPassword(hash, []byte(password)) != nil {
+		return false, model.UserInfo{}
+	}
+	return true, model.UserInfo{Sub: username, Origin: breakGlassOrigin}
+}
+
+// breakGlassLogin is the fallback after every backend failed with the error backendErr.
+// If the credentials are no valid break-glass account, the login fails with the backend error.
+func (h *Handler) breakGlassLogin(username, password string, backendErr error) (bool, model.UserInfo, error) {
+	authenticated, userInfo := h.breakGlass.authenticate(username, password)
+	if !authenticated {
+		return false, model.UserInfo{}, backendErr
+	}
+	logging.Logger.WithError(backendErr).WithField("username", username).
+		Warn("every login backend failed, accepted a break-glass account")
+	return true, userInfo, nil
+}
+
+// breakGlassWebhook returns an async subscriber, which posts the break-glass logins to the url
+func breakGlassWebhook(url string) Subscriber {
+	client := outboundtls.Client(breakGlassWebhookTimeout)
+	return Subscriber{
+		Name:  "break-glass-webhook",
+		Async: true,
+		Handle: func(e Event) {
+			if e.Type != EventBreakGlassLogin {
+				return
+			}
+			if err := postBreakGlassAlert(client, url, e); err != nil {
+				logging.Logger.WithError(err).Error("could not deliver the break-glass alert")
+			}
+		},
+	}
+}
+
+// breakGlassAlert is the body of the webhook
+type breakGlassAlert struct {
+	Event    EventType `json:"event"`
+	Time     time.Time `json:"time"`
+	Username string    `json:"username"`
+	ClientIP string    `json:"client_ip"`
+}
+
+func postBreakGlassAlert(client *http.Client, url string, e Event) error {
+	body, err := json.Marshal(breakGlassAlert{Event: e.Type, Time: e.Time, Username: e.Username, ClientIP: e.ClientIP})
+	if err != nil {
+		return err
+	}
+	resp, err := client.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook responded with http status %v", resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,131 @@
+package login
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	. "github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func writeBreakGlassFile(t *testing.T, content string) string {
+	file := filepath.Join(tmpDir(t), "break-glass")
+	NoError(t, ioutil.WriteFile(file, []byte(content), 0600))
+	return file
+}
+
+func breakGlassTestHandler(t *testing.T, backends ...Backend) *Handler {
+	hash, err := bcrypt.GenerateFromPassword([]byte("emergency"), breakGlassMinCost)
+	NoError(t, err)
+	b, err := loadBreakGlass(writeBreakGlassFile(t, "# operators\nadmin:"+string(hash)+"\n"))
+	NoError(t, err)
+
+	h := testHandler()
+	h.backends = backends
+	h.backendNames = nil
+	for i := range backends {
+		h.backendNames = append(h.backendNames, fmt.Sprintf("backend%v", i))
+	}
+	h.breakGlass = b
+	return h
+}
+
+func breakGlassLogin(h *Handler, username, password string) *httptest.ResponseRecorder {
+	recorder := httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("POST", "/context/login", fmt.Sprintf(`{"username": %q, "password": %q}`, username, password), TypeJSON, AcceptJwt))
+	return recorder
+}
+
+func TestBreakGlass_RemoteDown(t *testing.T) {
+	h := breakGlassTestHandler(t, errorTestBackend("ldap down"), errorTestBackend("osiam down"))
+	rec := &eventRecorder{}
+	h.Subscribe(Subscriber{Name: "test", Handle: rec.handle})
+
+	recorder := breakGlassLogin(h, "admin", "emergency")
+	Equal(t, 200, recorder.Code)
+	claims, err := tokenAsMap(recorder.Body.String())
+	NoError(t, err)
+	Equal(t, "admin", claims["sub"])
+	Equal(t, breakGlassOrigin, claims["origin"])
+	Equal(t, []EventType{EventLoginSucceeded, EventBreakGlassLogin}, rec.types())
+
+	// a wrong password or an unknown account fails with the backend error
+	Equal(t, 500, breakGlassLogin(h, "admin", "wrong").Code)
+	Equal(t, 500, breakGlassLogin(h, "bob", "emergency").Code)
+}
+
+func TestBreakGlass_RemoteUp(t *testing.T) {
+	// a clean rejection of the remote backend never consults the break-glass accounts
+	h := breakGlassTestHandler(t, NewSimpleBackend(map[string]string{"bob": "secret"}))
+	Equal(t, 403, breakGlassLogin(h, "admin", "emergency").Code)
+	Equal(t, 200, breakGlassLogin(h, "bob", "secret").Code)
+
+	// neither does a regular answer of any backend in the chain
+	h = breakGlassTestHandler(t, errorTestBackend("ldap down"), NewSimpleBackend(map[string]string{"bob": "secret"}))
+	Equal(t, 500, breakGlassLogin(h, "admin", "emergency").Code)
+	h = breakGlassTestHandler(t, NewSimpleBackend(map[string]string{"bob": "secret"}), errorTestBackend("ldap down"))
+	Equal(t, 500, breakGlassLogin(h, "admin", "emergency").Code)
+
+	// a failed backend still fails the login, like without break-glass accounts
+	Equal(t, 500, breakGlassLogin(h, "bob", "wrong").Code)
+	h = breakGlassTestHandler(t, errorTestBackend("ldap down"), NewSimpleBackend(map[string]string{"bob": "secret"}))
+	Equal(t, 500, breakGlassLogin(h, "bob", "secret").Code)
+}
+
+func TestBreakGlass_InvalidFile(t *testing.T) {
+	_, err := loadBreakGlass(filepath.Join(tmpDir(t), "missing"))
+	Error(t, err)
+
+	file := writeBreakGlassFile(t, "admin:{SHA}W6ph5Mm5Pz8GgiULbPgzG37mj9g=\n")
+	_, err = loadBreakGlass(file)
+	EqualError(t, err, file+":1: the password of admin has to be a bcrypt hash")
+
+	weak, _ := bcrypt.GenerateFromPassword([]byte("emergency"), bcrypt.MinCost)
+	file = writeBreakGlassFile(t, "\nadmin:"+string(weak))
+	_, err = loadBreakGlass(file)
+	EqualError(t, err, file+":2: the bcrypt cost of admin has to be at least 10, but was 4")
+
+	file = writeBreakGlassFile(t, "# no accounts\n")
+	_, err = loadBreakGlass(file)
+	EqualError(t, err, "no break-glass accounts in "+file)
+
+	cfg := DefaultConfig()
+	cfg.Backends = Options{"simple": {"bob": "secret"}}
+	cfg.BreakGlassWebhook = "http://alerts.example.com"
+	_, err = NewHandler(cfg)
+	EqualError(t, err, "A break-glass webhook requires a -break-glass-file")
+}
+
+func TestBreakGlass_Webhook(t *testing.T) {
+	alerts := make(chan breakGlassAlert, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		alert := breakGlassAlert{}
+		json.NewDecoder(r.Body).Decode(&alert)
+		alerts <- alert
+	}))
+	defer server.Close()
+
+	h := breakGlassTestHandler(t, errorTestBackend("ldap down"))
+	h.Subscribe(breakGlassWebhook(server.URL))
+	hooks := h.Hooks()
+	hook := hooks[len(hooks)-1]
+	Equal(t, "event-delivery", hook.Name)
+	NoError(t, hook.Start())
+	defer hook.Stop(context.Background())
+
+	Equal(t, 200, breakGlassLogin(h, "admin", "emergency").Code)
+	select {
+	case alert := <-alerts:
+		Equal(t, EventBreakGlassLogin, alert.Event)
+		Equal(t, "admin", alert.Username)
+	case <-time.After(time.Second):
+		Fail(t, "no break-glass alert")
+	}
+}
@@ -0,0 +1,81 @@
+package login
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+)
+
+// cachedResponse serves the rendered bytes of a mostly static resource,
+// e.g. public keys or metrics snapshots. The content is rendered once
+// and re-rendered only after invalidate was called.
+// Reading a rendered response is lock free, so that concurrent requests
+// do not contend with each other or with the login traffic.
+type cachedResponse struct {
+	contentType string
+	render      func() ([]byte, error)
+
+	current  atomic.Value // *renderedResponse
+	muRender sync.Mutex
+}
+
+type renderedResponse struct {
+	body []byte
+	etag string
+}
+
+func newCachedResponse(contentType string, render func() ([]byte, error)) *cachedResponse {
+	return &cachedResponse{contentType: contentType, render: render}
+}
+
+// invalidate drops the rendered content, e.g. after a key rotation or config reload.
+func (c *cachedResponse) invalidate() {
+	c.current.Store((*renderedResponse)(nil))
+}
+
+func (c *cachedResponse) get() (*renderedResponse, error) {
+	if r, _ := c.current.Load().(*renderedResponse); r != nil {
+		return r, nil
+	}
+
+	c.muRender.Lock()
+	defer c.muRender.Unlock()
+	if r, _ := c.current.Load().(*renderedResponse); r != nil {
+		return r, nil
+	}
+
+	body, err := c.render()
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(body)
+	r := &renderedResponse{body: body, etag: `"` + hex.EncodeToString(sum[:16]) + `"`}
+	c.current.Store(r)
+	return r, nil
+}
+
+// ServeHTTP writes the rendered content with Content-Length and ETag
+// and answers conditional requests with 304 Not Modified.
+func (c *cachedResponse) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	rendered, err := c.get()
+	if err != nil {
+		respondInternalError(w)
+		return
+	}
+
+	w.Header().Set("ETag", rendered.etag)
+	if r.Header.Get("If-None-Match") == rendered.etag {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	w.Header().Set("Content-Type", c.contentType)
+	w.Header().Set("Content-Length", strconv.Itoa(len(rendered.body)))
+	w.WriteHeader(200)
+	if r.Method != "HEAD" {
+		w.Write(rendered.body)
+	}
+}
@@ -0,0 +1,97 @@
+package login
+
+import (
+	"errors"
+	. "github.com/stretchr/testify/assert"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestCachedResponse(t *testing.T) {
+	renders := 0
+	content := `{"keys":[]}`
+	c := newCachedResponse(contentTypeJSON, func() ([]byte, error) {
+		renders++
+		return []byte(content), nil
+	})
+
+	recorder := httptest.NewRecorder()
+	c.ServeHTTP(recorder, req("GET", "/jwks", ""))
+	Equal(t, 200, recorder.Code)
+	Equal(t, contentTypeJSON, recorder.Header().Get("Content-Type"))
+	Equal(t, "11", recorder.Header().Get("Content-Length"))
+	Equal(t, content, recorder.Body.String())
+	etag := recorder.Header().Get("ETag")
+	NotEqual(t, "", etag)
+
+	// the content is rendered only once
+	recorder = httptest.NewRecorder()
+	c.ServeHTTP(recorder, req("GET", "/jwks", ""))
+	Equal(t, content, recorder.Body.String())
+	Equal(t, 1, renders)
+
+	// conditional request
+	recorder = httptest.NewRecorder()
+	c.ServeHTTP(recorder, req("GET", "/jwks", "", "If-None-Match: "+etag))
+	Equal(t, 304, recorder.Code)
+	Equal(t, 0, recorder.Body.Len())
+
+	// HEAD
+	recorder = httptest.NewRecorder()
+	c.ServeHTTP(recorder, req("HEAD", "/jwks", ""))
+	Equal(t, 200, recorder.Code)
+	Equal(t, "11", recorder.Header().Get("Content-Length"))
+	Equal(t, 0, recorder.Body.Len())
+
+	// invalidation renders the new content with a new etag
+	content = `{"keys":[{}]}`
+	c.invalidate()
+	recorder = httptest.NewRecorder()
+	c.ServeHTTP(recorder, req("GET", "/jwks", "", "If-None-Match: "+etag))
+	Equal(t, 200, recorder.Code)
+	Equal(t, content, recorder.Body.String())
+	NotEqual(t, etag, recorder.Header().Get("ETag"))
+	Equal(t, 2, renders)
+}
+
+func TestCachedResponse_RenderError(t *testing.T) {
+	c := newCachedResponse(contentTypeJSON, func() ([]byte, error) {
+		return nil, errors.New("render error")
+	})
+
+	recorder := httptest.NewRecorder()
+	c.ServeHTTP(recorder, req("GET", "/jwks", ""))
+	Equal(t, 500, recorder.Code)
+	Equal(t, contentTypePlain, recorder.Header().Get("Content-Type"))
+}
+
+// BenchmarkCachedResponse_Parallel serves a large response under concurrent requests,
+// while logins are processed at the same time.
+func BenchmarkCachedResponse_Parallel(b *testing.B) {
+	content := []byte(strings.Repeat("x", 64*1024))
+	c := newCachedResponse(contentTypePlain, func() ([]byte, error) {
+		return content, nil
+	})
+	h := testHandler()
+
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-done:
+				return
+			default:
+				h.ServeHTTP(httptest.NewRecorder(), req("POST", "/context/login", "username=bob&password=secret", TypeForm, AcceptJwt))
+			}
+		}
+	}()
+	defer close(done)
+
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			c.ServeHTTP(httptest.NewRecorder(), req("GET", "/metrics", ""))
+		}
+	})
+}
@@ -0,0 +1,85 @@
+package login
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/tarent/loginsrv/model"
+)
+
+// ErrCertificateBinding is returned for tokens, which are bound
+// to another client certificate than the one of the connection.
+var ErrCertificateBinding = errors.New("token is bound to another client certificate")
+
+// certThumbprint returns the base64url encoded sha256 thumbprint
+// of the verified client certificate of the connection.
+func certThumbprint(r *http.Request) (string, bool) {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+		return "", false
+	}
+	sum := sha256.Sum256(r.TLS.VerifiedChains[0][0].Raw)
+	return base64.RawURLEncoding.EncodeToString(sum[:]), true
+}
+
+// VerifyCertificateBinding checks, that a token with a cnf claim is presented
+// over a connection with the same verified client certificate.
+// Tokens without a cnf claim are not bound and always pass.
+func VerifyCertificateBinding(r *http.Request, userInfo model.UserInfo) error {
+	if userInfo.Confirmation == nil || userInfo.Confirmation.X5tS256 == "" {
+		return nil
+	}
+	thumbprint, ok := certThumbprint(r)
+	if !ok || thumbprint != userInfo.Confirmation.X5tS256 {
+		return ErrCertificateBinding
+	}
+	return nil
+}
+
+// bindToClientCert adds the cnf claim for the client certificate of the connection,
+// if the binding is enabled and the client presented a verified certificate.
+func (h *Handler) bindToClientCert(r *http.Request, userInfo model.UserInfo) model.UserInfo {
+	if !h.config.BindClientCert {
+		return userInfo
+	}
+	if thumbprint, ok := certThumbprint(r); ok {
+		userInfo.Confirmation = &model.Confirmation{X5tS256: thumbprint}
+	}
+	return userInfo
+}
+
+// checkCertBinding rejects -bind-client-cert without the tls client authentication,
+// because the tokens would silently stay unbound.
+func checkCertBinding(config *Config) error {
+	if config.BindClientCert && (config.TLSCert == "" || config.TLSClientCA == "") {
+		return errors.New("Binding the tokens to client certificates requires tls client authentication, set -tls-cert and -tls-client-ca")
+	}
+	return nil
+}
+
+// TLSConfig returns the tls configuration for the listener.
+// If a client ca is configured, client certificates are requested and verified,
+// but not required, so that browsers without certificates can still login.
+func (c *Config) TLSConfig() (*tls.Config, error) {
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+	if c.TLSClientCA == "" {
+		return tlsConfig, nil
+	}
+
+	pem, err := ioutil.ReadFile(c.TLSClientCA)
+	if err != nil {
+		return nil, fmt.Errorf("error reading the client ca: %v", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificates found in client ca file %v", c.TLSClientCA)
+	}
+	tlsConfig.ClientCAs = pool
+	tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+	return tlsConfig, nil
+}
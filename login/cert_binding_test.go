@@ -0,0 +1,143 @@
+package login
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	. "github.com/stretchr/testify/assert"
+)
+
+func testCertificate(cn string) *x509.Certificate {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: cn},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+	}
+	der, _ := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	cert, _ := x509.ParseCertificate(der)
+	return cert
+}
+
+func withClientCert(r *http.Request, cert *x509.Certificate) *http.Request {
+	r.TLS = &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{cert}}}
+	return r
+}
+
+func TestHandler_BindClientCert(t *testing.T) {
+	cert := testCertificate("workload-a")
+	h := testHandler()
+	h.config.BindClientCert = true
+
+	recorder := httptest.NewRecorder()
+	h.ServeHTTP(recorder, withClientCert(req("POST", "/context/login", "username=bob&password=secret", TypeForm, AcceptJwt), cert))
+	Equal(t, 200, recorder.Code)
+	token := recorder.Body.String()
+
+	claims, err := tokenAsMap(token)
+	NoError(t, err)
+	thumbprint, _ := certThumbprint(withClientCert(req("GET", "/", ""), cert))
+	Equal(t, map[string]interface{}{"x5t#S256": thumbprint}, claims["cnf"])
+
+	// the same certificate
+	_, valid := h.GetToken(withClientCert(req("GET", "/context/login", ""), cert), token)
+	True(t, valid)
+
+	// another certificate
+	_, valid = h.GetToken(withClientCert(req("GET", "/context/login", ""), testCertificate("workload-b")), token)
+	False(t, valid)
+
+	// no certificate
+	_, valid = h.GetToken(req("GET", "/context/login", ""), token)
+	False(t, valid)
+
+	// a refresh keeps the binding
+	h.config.JwtRefreshes = 1
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, withClientCert(req("POST", "/context/login", "", AcceptJwt, "Cookie: "+h.config.CookieName+"="+token), cert))
+	Equal(t, 200, recorder.Code)
+	refreshed, err := tokenAsMap(recorder.Body.String())
+	NoError(t, err)
+	Equal(t, claims["cnf"], refreshed["cnf"])
+}
+
+func TestHandler_BindClientCert_WithoutCert(t *testing.T) {
+	// browser flows without client certificate are not affected
+	h := testHandler()
+	h.config.BindClientCert = true
+
+	recorder := httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("POST", "/context/login", "username=bob&password=secret", TypeForm, AcceptJwt))
+	Equal(t, 200, recorder.Code)
+	claims, err := tokenAsMap(recorder.Body.String())
+	NoError(t, err)
+	Nil(t, claims["cnf"])
+
+	_, valid := h.GetToken(req("GET", "/context/login", ""), recorder.Body.String())
+	True(t, valid)
+}
+
+func TestHandler_BindClientCert_Disabled(t *testing.T) {
+	h := testHandler()
+
+	recorder := httptest.NewRecorder()
+	h.ServeHTTP(recorder, withClientCert(req("POST", "/context/login", "username=bob&password=secret", TypeForm, AcceptJwt), testCertificate("workload-a")))
+	Equal(t, 200, recorder.Code)
+	claims, err := tokenAsMap(recorder.Body.String())
+	NoError(t, err)
+	Nil(t, claims["cnf"])
+}
+
+func TestConfig_TLSConfig(t *testing.T) {
+	cfg := DefaultConfig()
+	tlsConfig, err := cfg.TLSConfig()
+	NoError(t, err)
+	Equal(t, tls.NoClientCert, tlsConfig.ClientAuth)
+
+	f, _ := ioutil.TempFile("", "loginsrv_ca")
+	defer os.Remove(f.Name())
+	pem.Encode(f, &pem.Block{Type: "CERTIFICATE", Bytes: testCertificate("ca").Raw})
+	f.Close()
+
+	cfg.TLSClientCA = f.Name()
+	tlsConfig, err = cfg.TLSConfig()
+	NoError(t, err)
+	Equal(t, tls.VerifyClientCertIfGiven, tlsConfig.ClientAuth)
+	NotNil(t, tlsConfig.ClientCAs)
+
+	cfg.TLSClientCA = "/no/such/file"
+	_, err = cfg.TLSConfig()
+	Error(t, err)
+}
+
+func TestHandler_BindClientCert_RequiresTLSClientAuth(t *testing.T) {
+	cfg := testConfig()
+	cfg.Backends = Options{"simple": {"bob": "secret"}}
+	cfg.BindClientCert = true
+	_, err := NewHandler(cfg)
+	EqualError(t, err, "Binding the tokens to client certificates requires tls client authentication, set -tls-cert and -tls-client-ca")
+
+	cfg.TLSCert = "/etc/loginsrv/cert.pem"
+	_, err = NewHandler(cfg)
+	Error(t, err)
+
+	cfg.TLSClientCA = "/etc/loginsrv/client-ca.pem"
+	_, err = NewHandler(cfg)
+	NoError(t, err)
+}
@@ -0,0 +1,126 @@
+package login
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/tarent/loginsrv/model"
+)
+
+// claimMap renames the claims of the user info in the tokens, e.g. sub to preferred_username,
+// for downstream systems expecting other claim names.
+// The keys are the claim names of model.UserInfo, the values the names in the tokens.
+type claimMap map[string]string
+
+// unmappableClaims are the time claims, which the jwt libraries of the downstream systems check by their registered names
+var unmappableClaims = map[string]bool{"exp": true, "iat": true, "nbf": true}
+
+// userInfoClaims returns the claim names of model.UserInfo by their json tags
+func userInfoClaims() map[string]bool {
+	claims := map[string]bool{}
+	t := reflect.TypeOf(model.UserInfo{})
+	for i := 0; i < t.NumField(); i++ {
+		if name := strings.Split(t.Field(i).Tag.Get("json"), ",")[0]; name != "" && name != "-" {
+			claims[name] = true
+		}
+	}
+	return claims
+}
+
+// parseClaimMap reads the -jwt-claim-map, e.g. sub:preferred_username,origin:idp.
+// Unknown claims, claims mapped twice and names taken by other claims are rejected.
+func parseClaimMap(list string) (claimMap, error) {
+	if strings.TrimSpace(list) == "" {
+		return nil, nil
+	}
+	known := userInfoClaims()
+	m := claimMap{}
+	mappedFrom := map[string]string{}
+	for _, entry := range strings.Split(list, ",") {
+		pair := strings.SplitN(entry, ":", 2)
+		if len(pair) != 2 || strings.TrimSpace(pair[0]) == "" || strings.TrimSpace(pair[1]) == "" {
+			return nil, fmt.Errorf("Invalid jwt claim map entry %q, it has to be claim:name", entry)
+		}
+		from, to := strings.TrimSpace(pair[0]), strings.TrimSpace(pair[1])
+		if !known[from] {
+			return nil, fmt.Errorf("Unknown claim %q in the jwt claim map", from)
+		}
+		if unmappableClaims[from] {
+			return nil, fmt.Errorf("The claim %q can not be mapped", from)
+		}
+		if _, mapped := m[from]; mapped {
+			return nil, fmt.Errorf("The claim %q is mapped twice", from)
+		}
+		if other, taken := mappedFrom[to]; taken {
+			return nil, fmt.Errorf("The claims %q and %q are both mapped to %q", other, from, to)
+		}
+		m[from] = to
+		mappedFrom[to] = from
+	}
+	for to, from := range mappedFrom {
+		if _, mappedAway := m[to]; known[to] && !mappedAway {
+			return nil, fmt.Errorf("The claim %q can not be mapped to %q, which is a claim of its own", from, to)
+		}
+	}
+	return m, nil
+}
+
+// nameOf returns the name of the claim in the tokens
+func (m claimMap) nameOf(claim string) string {
+	if name, mapped := m[claim]; mapped {
+		return name
+	}
+	return claim
+}
+
+// apply renames the claims of the user info to their names in the tokens
+func (m claimMap) apply(claims map[string]json.RawMessage) map[string]json.RawMessage {
+	renamed := make(map[string]json.RawMessage, len(claims))
+	for name, value := range claims {
+		renamed[m.nameOf(name)] = value
+	}
+	return renamed
+}
+
+// revert renames the claims of a token back to the names of the user info.
+// Claims with the names of mapped claims are dropped, so that they can't override the mapped ones.
+func (m claimMap) revert(claims map[string]json.RawMessage) map[string]json.RawMessage {
+	reverse := make(map[string]string, len(m))
+	for from, to := range m {
+		reverse[to] = from
+	}
+	reverted := make(map[string]json.RawMessage, len(claims))
+	for name, value := range claims {
+		if from, mapped := reverse[name]; mapped {
+			reverted[from] = value
+		} else if _, mappedAway := m[name]; !mappedAway {
+			reverted[name] = value
+		}
+	}
+	return reverted
+}
+
+// tokenClaims are the claims of the user info with the names of the claim map and the static extra claims
+type tokenClaims struct {
+	model.UserInfo
+	claimMap claimMap
+	extra    extraClaims
+}
+
+func (c tokenClaims) MarshalJSON() ([]byte, error) {
+	b, err := json.Marshal(c.UserInfo)
+	if err != nil {
+		return nil, err
+	}
+	claims := map[string]json.RawMessage{}
+	if err := json.Unmarshal(b, &claims); err != nil {
+		return nil, err
+	}
+	claims = c.claimMap.apply(claims)
+	for name, value := range c.extra {
+		claims[name] = value
+	}
+	return json.Marshal(claims)
+}
@@ -0,0 +1,105 @@
+package login
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	. "github.com/stretchr/testify/assert"
+	"github.com/tarent/loginsrv/model"
+)
+
+func TestParseClaimMap(t *testing.T) {
+	m, err := parseClaimMap("")
+	NoError(t, err)
+	Nil(t, m)
+
+	m, err = parseClaimMap("sub:preferred_username, origin:idp")
+	NoError(t, err)
+	Equal(t, claimMap{"sub": "preferred_username", "origin": "idp"}, m)
+
+	// claims may swap their names
+	_, err = parseClaimMap("sub:email,email:sub")
+	NoError(t, err)
+
+	for list, message := range map[string]string{
+		"sub":                     `Invalid jwt claim map entry "sub", it has to be claim:name`,
+		"sub:":                    `Invalid jwt claim map entry "sub:", it has to be claim:name`,
+		"username:preferred":      `Unknown claim "username" in the jwt claim map`,
+		"exp:expires":             `The claim "exp" can not be mapped`,
+		"sub:a,sub:b":             `The claim "sub" is mapped twice`,
+		"sub:user,origin:user":    `The claims "sub" and "origin" are both mapped to "user"`,
+		"sub:email":               `The claim "sub" can not be mapped to "email", which is a claim of its own`,
+		"sub:preferred_username,": `Invalid jwt claim map entry "", it has to be claim:name`,
+	} {
+		_, err := parseClaimMap(list)
+		EqualError(t, err, message, list)
+	}
+}
+
+func TestClaimMap_Revert(t *testing.T) {
+	m := claimMap{"sub": "preferred_username"}
+	claims := m.revert(map[string]json.RawMessage{
+		"preferred_username": json.RawMessage(`"bob"`),
+		"sub":                json.RawMessage(`"mallory"`),
+		"email":              json.RawMessage(`"bob@example.com"`),
+	})
+	Equal(t, map[string]json.RawMessage{"sub": json.RawMessage(`"bob"`), "email": json.RawMessage(`"bob@example.com"`)}, claims)
+}
+
+func TestHandler_ClaimMap(t *testing.T) {
+	cfg := testConfig()
+	cfg.Backends = Options{"simple": {"bob": "secret"}}
+	cfg.JwtClaimMap = "sub:preferred_username,origin:idp"
+	h, err := NewHandler(cfg)
+	NoError(t, err)
+
+	recorder := callHandler(h, req("POST", "/context/login", "username=bob&password=secret", TypeForm, AcceptJwt))
+	Equal(t, 200, recorder.Code)
+	token := recorder.Body.String()
+	claims, err := tokenAsMap(token)
+	NoError(t, err)
+	Equal(t, "bob", claims["preferred_username"])
+	Equal(t, "simple", claims["idp"])
+	Nil(t, claims["sub"])
+	Nil(t, claims["origin"])
+	NotNil(t, claims["exp"])
+
+	userInfo, valid := h.GetToken(req("GET", "/context/login", ""), token)
+	True(t, valid)
+	Equal(t, "bob", userInfo.Sub)
+	Equal(t, "simple", userInfo.Origin)
+
+	// the refresh reads the mapped claims
+	recorder = callHandler(h, req("POST", "/context/login", "", AcceptJwt, "Authorization: Bearer "+token))
+	Equal(t, 200, recorder.Code)
+	claims, err = tokenAsMap(recorder.Body.String())
+	NoError(t, err)
+	Equal(t, "bob", claims["preferred_username"])
+	Equal(t, float64(1), claims["refs"])
+
+	// the verification bundle names the mapped claims
+	Equal(t, "preferred_username", h.tokenService().verificationClaims()["subject"])
+	Equal(t, "email", h.tokenService().verificationClaims()["email"])
+
+	// other services verify the tokens with the same claim map
+	tokens, err := NewTokenService(cfg)
+	NoError(t, err)
+	userInfo, err = tokens.Verify(token)
+	NoError(t, err)
+	Equal(t, "bob", userInfo.Sub)
+
+	cfg.JwtClaimMap = "username:preferred_username"
+	_, err = NewHandler(cfg)
+	EqualError(t, err, `Unknown claim "username" in the jwt claim map`)
+}
+
+func TestTokenService_ClaimMap_Unmapped(t *testing.T) {
+	// without a claim map, the claims keep the names of the user info
+	h := testHandler()
+	token, err := h.createToken(model.UserInfo{Sub: "bob", Expiry: time.Now().Add(time.Minute).Unix()})
+	NoError(t, err)
+	claims, err := tokenAsMap(token)
+	NoError(t, err)
+	Equal(t, "bob", claims["sub"])
+}
@@ -0,0 +1,100 @@
+package login
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// claimsLimits protect the jwt parsers of downstream services from oversized or deeply nested claims,
+// e.g. of a misbehaving user file or oauth provider. With -claims-strict, every token is checked before it is signed.
+// The claim values have to be strings, numbers, booleans or lists of strings, objects only up to the max depth.
+type claimsLimits struct {
+	maxSize  int
+	maxDepth int
+
+	// structured are the object claims of loginsrv itself, e.g. the cnf, which are not checked for their structure
+	structured map[string]bool
+}
+
+// newClaimsLimits returns the limits of the -claims-strict mode or nil, if it is not enabled
+func newClaimsLimits(config *Config, m claimMap) (*claimsLimits, error) {
+	if !config.ClaimsStrict {
+		return nil, nil
+	}
+	if config.ClaimsMaxSize < 0 {
+		return nil, errors.New("The claims max size must not be negative")
+	}
+	if config.ClaimsMaxDepth < 0 {
+		return nil, errors.New("The claims max depth must not be negative")
+	}
+	return &claimsLimits{
+		maxSize:    config.ClaimsMaxSize,
+		maxDepth:   config.ClaimsMaxDepth,
+		structured: map[string]bool{m.nameOf("cnf"): true, m.nameOf("idp_session"): true},
+	}, nil
+}
+
+// check returns an error naming the offending claim, if the claims exceed the limits
+func (l *claimsLimits) check(claims interface{}) error {
+	if l == nil {
+		return nil
+	}
+	b, err := json.Marshal(claims)
+	if err != nil {
+		return err
+	}
+	if l.maxSize > 0 && len(b) > l.maxSize {
+		return fmt.Errorf("the claims have %v bytes, only %v are allowed by -claims-max-size", len(b), l.maxSize)
+	}
+	values := map[string]interface{}{}
+	d := json.NewDecoder(bytes.NewReader(b))
+	d.UseNumber()
+	if err := d.Decode(&values); err != nil {
+		return err
+	}
+	for _, name := range sortedClaimNames(values) {
+		if !l.structured[name] {
+			if err := l.checkValue(name, values[name], 0); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (l *claimsLimits) checkValue(name string, value interface{}, depth int) error {
+	switch v := value.(type) {
+	case string, json.Number, bool:
+		return nil
+	case []interface{}:
+		for _, element := range v {
+			if _, ok := element.(string); !ok {
+				return fmt.Errorf("the claim %q has to be a list of strings", name)
+			}
+		}
+		return nil
+	case map[string]interface{}:
+		if depth >= l.maxDepth {
+			return fmt.Errorf("the claim %q is nested deeper than %v levels, which are allowed by -claims-max-depth", name, l.maxDepth)
+		}
+		for _, key := range sortedClaimNames(v) {
+			if err := l.checkValue(name+"."+key, v[key], depth+1); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return fmt.Errorf("the claim %q has to be a string, number, boolean or list of strings, but is null", name)
+}
+
+func sortedClaimNames(claims map[string]interface{}) []string {
+	names := make([]string, 0, len(claims))
+	for name := range claims {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
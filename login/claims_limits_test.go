@@ -0,0 +1,114 @@
+package login
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/stretchr/testify/assert"
+	"github.com/tarent/loginsrv/model"
+	"github.com/tarent/loginsrv/oauth2"
+)
+
+func TestClaimsLimits_PathologicalClaims(t *testing.T) {
+	cfg := testConfig()
+	cfg.ClaimsStrict = true
+	cfg.UserFile = "testdata/pathological_claims.yml"
+	s, err := NewTokenService(cfg)
+	NoError(t, err)
+
+	for _, test := range []struct {
+		sub string
+		err string
+	}{
+		{"deep", `the claim "profile" is nested deeper than 0 levels, which are allowed by -claims-max-depth`},
+		{"objects", `the claim "roles" has to be a list of strings`},
+		{"mixed", `the claim "ids" has to be a list of strings`},
+		{"nulls", `the claim "manager" has to be a string, number, boolean or list of strings, but is null`},
+		{"flat", ""},
+	} {
+		_, err := s.Issue(model.UserInfo{Sub: test.sub})
+		if test.err == "" {
+			NoError(t, err, test.sub)
+			continue
+		}
+		EqualError(t, err, "rejected the token claims of "+test.sub+": "+test.err)
+	}
+
+	// deeper objects can be allowed
+	cfg.ClaimsMaxDepth = 3
+	s, err = NewTokenService(cfg)
+	NoError(t, err)
+	_, err = s.Issue(model.UserInfo{Sub: "deep"})
+	EqualError(t, err, `rejected the token claims of deep: the claim "profile.address.geo.location" is nested deeper than 3 levels, which are allowed by -claims-max-depth`)
+	cfg.ClaimsMaxDepth = 4
+	s, err = NewTokenService(cfg)
+	NoError(t, err)
+	_, err = s.Issue(model.UserInfo{Sub: "deep"})
+	NoError(t, err)
+
+	// without the strict mode, the claims are issued as they are
+	cfg.ClaimsStrict = false
+	s, err = NewTokenService(cfg)
+	NoError(t, err)
+	_, err = s.Issue(model.UserInfo{Sub: "nulls"})
+	NoError(t, err)
+}
+
+func TestClaimsLimits_Size(t *testing.T) {
+	cfg := testConfig()
+	cfg.ClaimsStrict = true
+	cfg.ClaimsMaxSize = 200
+	s, err := NewTokenService(cfg)
+	NoError(t, err)
+
+	_, err = s.Issue(model.UserInfo{Sub: "bob", Groups: []string{"admins"}})
+	NoError(t, err)
+	_, err = s.Issue(model.UserInfo{Sub: "bob", Name: strings.Repeat("x", 200)})
+	Error(t, err)
+	Contains(t, err.Error(), "only 200 are allowed by -claims-max-size")
+
+	// the structured claims of loginsrv are no violation
+	_, err = s.Issue(model.UserInfo{Sub: "bob", IdPSession: &model.IdPSession{Issuer: "https://idp", ID: "s1"}, Confirmation: &model.Confirmation{X5tS256: "abc"}})
+	NoError(t, err)
+
+	for _, invalid := range []func(*Config){
+		func(c *Config) { c.ClaimsMaxSize = -1 },
+		func(c *Config) { c.ClaimsMaxDepth = -1 },
+	} {
+		cfg := testConfig()
+		cfg.Backends = Options{"simple": {"bob": "secret"}}
+		cfg.ClaimsStrict = true
+		invalid(cfg)
+		_, err := NewHandler(cfg)
+		Error(t, err)
+	}
+}
+
+func TestHandler_ClaimsLimits_Oauth(t *testing.T) {
+	cfg := testConfig()
+	cfg.Backends = Options{"simple": {"bob": "secret"}}
+	cfg.ClaimsStrict = true
+	h, err := NewHandler(cfg)
+	NoError(t, err)
+
+	// within the -claims-max-groups, but exceeding the -claims-max-size
+	groups := make([]string, 100)
+	for i := range groups {
+		groups[i] = fmt.Sprintf("%v-%v", strings.Repeat("g", 50), i)
+	}
+	h.oauth = &oauth2ManagerMock{
+		_GetConfigFromRequest: func(r *http.Request) (oauth2.Config, error) {
+			return oauth2.Config{}, nil
+		},
+		_Handle: func(w http.ResponseWriter, r *http.Request) (bool, bool, model.UserInfo, error) {
+			return false, true, model.UserInfo{Sub: "marvin", Origin: "github", Groups: groups, Expiry: time.Now().Add(time.Hour).Unix()}, nil
+		},
+	}
+	recorder := httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("GET", "/context/login/github", ""))
+	Equal(t, 500, recorder.Code)
+}
@@ -0,0 +1,192 @@
+package login
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"path"
+	"time"
+
+	"github.com/tarent/loginsrv/model"
+)
+
+const (
+	clientRateNamespace        = "client_rate"
+	clientRateNamespaceVersion = 2
+)
+
+// the window of the rate limit of the failed requests of the token endpoint
+var clientRateWindow = time.Minute
+
+// ClientCredentialsBackend is an optional extension for backends of service accounts.
+// They authenticate the clients of the token endpoint with the client_credentials grant.
+// The token endpoint is only served, if at least one backend implements it.
+type ClientCredentialsBackend interface {
+	AuthenticateClient(ctx context.Context, clientID, clientSecret string) (bool, model.UserInfo, error)
+}
+
+// oauthTokenResponse is the successful response of the token endpoint (RFC 6749, section 5.1)
+type oauthTokenResponse struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+	ExpiresIn   int64  `json:"expires_in"`
+}
+
+// oauthErrorResponse is the error response of the token endpoint (RFC 6749, section 5.2)
+type oauthErrorResponse struct {
+	Error       string `json:"error"`
+	Description string `json:"error_description,omitempty"`
+}
+
+func (h *Handler) isTokenPath(r *http.Request) bool {
+	return r.URL.Path == path.Join(h.config.LoginPath, "token")
+}
+
+// clientBackends returns the backends, which authenticate clients
+func (h *Handler) clientBackends() ([]ClientCredentialsBackend, []string) {
+	var backends []ClientCredentialsBackend
+	var names []string
+	for i, b := range h.backends {
+		if c, ok := b.(ClientCredentialsBackend); ok {
+			backends = append(backends, c)
+			names = append(names, backendName(h.backendNames, i))
+		}
+	}
+	return backends, names
+}
+
+// handleTokenGrant issues tokens for service accounts with the oauth client_credentials grant.
+// The client authenticates by basic auth or the client_id and client_secret form parameters.
+func (h *Handler) handleTokenGrant(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" || !hasMediaType(r, "application/x-www-form-urlencoded") {
+		writeOauthError(w, 400, "invalid_request", "POST with application/x-www-form-urlencoded is required")
+		return
+	}
+	if h.inMaintenance() {
+		writeOauthError(w, 503, "temporarily_unavailable", h.config.MaintenanceMessage)
+		return
+	}
+	r.ParseForm()
+
+	grantType := r.PostForm.Get("grant_type")
+	if grantType == "" {
+		writeOauthError(w, 400, "invalid_request", "missing grant_type")
+		return
+	}
+	if grantType != "client_credentials" {
+		writeOauthError(w, 400, "unsupported_grant_type", fmt.Sprintf("grant type %q is not supported", grantType))
+		return
+	}
+
+	clientID, clientSecret, basicAuth := r.BasicAuth()
+	if !basicAuth {
+		clientID, clientSecret = r.PostForm.Get("client_id"), r.PostForm.Get("client_secret")
+	}
+	if clientID == "" || clientSecret == "" {
+		writeOauthError(w, 400, "invalid_request", "missing client credentials")
+		return
+	}
+
+	// failed attempts are limited, so that secrets can't be guessed at a high rate
+	rateKey := clientRateKey(r, h.trustedProxies, clientID)
+	if retryAfter, limited := h.reserveClientAttempt(rateKey); limited {
+		h.emit(r, EventClientRateLimited, clientID, "")
+		w.Header().Set("Retry-After", fmt.Sprintf("%d", int(math.Ceil(retryAfter.Seconds()))))
+		writeOauthError(w, 429, "rate_limited", "too many token requests of the client")
+		return
+	}
+
+	authenticated, userInfo, err := h.authenticateClient(r.Context(), clientID, clientSecret)
+	if err != nil && requestTimedOut(r) {
+		h.countRequestTimeout(r, clientID)
+		w.Header().Set("Retry-After", fmt.Sprintf("%d", int(math.Ceil(h.config.MaxRequestDuration.Seconds()))))
+		writeOauthError(w, 503, "temporarily_unavailable", errAPIRequestTimeout.message)
+		return
+	}
+	if err != nil {
+		h.emit(r, EventClientAuthFailed, clientID, "")
+		writeOauthError(w, 500, "server_error", "")
+		return
+	}
+	if !authenticated {
+		h.emit(r, EventClientAuthFailed, clientID, "")
+		if basicAuth {
+			w.Header().Set("WWW-Authenticate", `Basic realm="loginsrv"`)
+		}
+		writeOauthError(w, 401, "invalid_client", "client authentication failed")
+		return
+	}
+
+	h.store.delete(clientRateNamespace, rateKey)
+
+	userInfo = h.normalizeUserInfo(userInfo)
+	expiry := time.Now().Add(h.sessionSettingsFor(userInfo.Origin).JwtExpiry).Unix()
+	if userInfo.Expiry == 0 || userInfo.Expiry > expiry {
+		userInfo.Expiry = expiry
+	}
+	userInfo.NoRefresh = true
+	token, err := h.createTokenWithContext(r.Context(), userInfo)
+	if err != nil {
+		writeOauthError(w, 500, "server_error", "")
+		return
+	}
+	h.emit(r, EventClientTokenIssued, clientID, userInfo.Origin)
+
+	writeOauthJSON(w, 200, oauthTokenResponse{
+		AccessToken: token,
+		TokenType:   "Bearer",
+		ExpiresIn:   userInfo.Expiry - time.Now().Unix(),
+	})
+}
+
+func (h *Handler) authenticateClient(ctx context.Context, clientID, clientSecret string) (bool, model.UserInfo, error) {
+	backends, names := h.clientBackends()
+	for i, b := range backends {
+		authenticated, userInfo, err := b.AuthenticateClient(ctx, clientID, clientSecret)
+		if err != nil || authenticated {
+			if authenticated && userInfo.Origin == "" {
+				userInfo.Origin = names[i]
+			}
+			return authenticated, userInfo, err
+		}
+	}
+	return false, model.UserInfo{}, nil
+}
+
+// clientRateKey identifies the failed requests of a client by the client id and the ip of the caller.
+// The key is not the client id alone on purpose: everyone, who knows the id of a client,
+// could lock it out by failed requests otherwise. A caller still gets only -client-rate-limit guesses
+// per client and minute, more guesses need more addresses.
+func clientRateKey(r *http.Request, trustedProxies []*net.IPNet, clientID string) string {
+	return clientIP(r, trustedProxies) + " " + clientID
+}
+
+// reserveClientAttempt counts the attempt of the key before the client is authenticated
+// and returns true with the time to wait, if the attempts of the window exceed the limit.
+// The count is incremented and compared at once, so that concurrent attempts can't pass the limit together.
+// A successful authentication resets the count, so only the failed attempts stay counted.
+func (h *Handler) reserveClientAttempt(rateKey string) (time.Duration, bool) {
+	if h.config.ClientRateLimit <= 0 {
+		return 0, false
+	}
+	count, reset := h.store.increment(clientRateNamespace, rateKey, clientRateWindow)
+	if count > h.config.ClientRateLimit {
+		return time.Until(reset), true
+	}
+	return 0, false
+}
+
+func writeOauthError(w http.ResponseWriter, status int, code, description string) {
+	writeOauthJSON(w, status, oauthErrorResponse{Error: code, Description: description})
+}
+
+func writeOauthJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", contentTypeJSON)
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Pragma", "no-cache")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
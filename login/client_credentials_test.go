@@ -0,0 +1,210 @@
+package login
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	. "github.com/stretchr/testify/assert"
+	"github.com/tarent/loginsrv/model"
+)
+
+// clientTestBackend knows the client svc with the secret 'secret'
+type clientTestBackend struct {
+	*SimpleBackend
+}
+
+func (b clientTestBackend) AuthenticateClient(ctx context.Context, clientID, clientSecret string) (bool, model.UserInfo, error) {
+	if clientID != "svc" || clientSecret != "secret" {
+		return false, model.UserInfo{}, nil
+	}
+	return true, model.UserInfo{Sub: "svc-deploy", Groups: []string{"deploy"}, Expiry: time.Now().Add(time.Hour).Unix()}, nil
+}
+
+func clientTestHandler() *Handler {
+	h := testHandler()
+	h.backends = append(h.backends, clientTestBackend{NewSimpleBackend(map[string]string{})})
+	h.backendNames = []string{"simple", "clients"}
+	h.config.JwtExpiry = 15 * time.Minute
+	return h
+}
+
+func postToken(h *Handler, body string, header ...string) *httptest.ResponseRecorder {
+	recorder := httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("POST", "/context/login/token", body, append(header, TypeForm)...))
+	return recorder
+}
+
+func oauthError(recorder *httptest.ResponseRecorder) string {
+	body := oauthErrorResponse{}
+	json.Unmarshal(recorder.Body.Bytes(), &body)
+	return body.Error
+}
+
+func TestClientCredentials(t *testing.T) {
+	h := clientTestHandler()
+	rec := &eventRecorder{}
+	h.Subscribe(Subscriber{Name: "test", Handle: rec.handle})
+
+	// basic auth
+	recorder := postToken(h, "grant_type=client_credentials", "Authorization: Basic c3ZjOnNlY3JldA==")
+	Equal(t, 200, recorder.Code)
+	Equal(t, contentTypeJSON, recorder.Header().Get("Content-Type"))
+	Equal(t, "no-store", recorder.Header().Get("Cache-Control"))
+	body := oauthTokenResponse{}
+	NoError(t, json.Unmarshal(recorder.Body.Bytes(), &body))
+	Equal(t, "Bearer", body.TokenType)
+	InDelta(t, int64(15*60), body.ExpiresIn, 2)
+
+	// the access token is a loginsrv token with the claims of the service account, capped to the jwt expiry
+	userInfo, valid := h.GetToken(req("GET", "/context/login", ""), body.AccessToken)
+	True(t, valid)
+	Equal(t, "svc-deploy", userInfo.Sub)
+	Equal(t, []string{"deploy"}, userInfo.Groups)
+	Equal(t, "clients", userInfo.Origin)
+	True(t, userInfo.NoRefresh)
+	InDelta(t, time.Now().Add(15*time.Minute).Unix(), userInfo.Expiry, 2)
+
+	// form parameters
+	recorder = postToken(h, "grant_type=client_credentials&client_id=svc&client_secret=secret")
+	Equal(t, 200, recorder.Code)
+
+	// wrong secret
+	recorder = postToken(h, "grant_type=client_credentials", "Authorization: Basic c3ZjOndyb25n")
+	Equal(t, 401, recorder.Code)
+	Equal(t, "invalid_client", oauthError(recorder))
+	Equal(t, `Basic realm="loginsrv"`, recorder.Header().Get("WWW-Authenticate"))
+
+	recorder = postToken(h, "grant_type=client_credentials&client_id=svc&client_secret=wrong")
+	Equal(t, 401, recorder.Code)
+	Equal(t, "", recorder.Header().Get("WWW-Authenticate"))
+
+	// the user credentials of the other backends are no client credentials
+	recorder = postToken(h, "grant_type=client_credentials&client_id=bob&client_secret=secret")
+	Equal(t, 401, recorder.Code)
+
+	Equal(t, []EventType{EventClientTokenIssued, EventClientTokenIssued, EventClientAuthFailed, EventClientAuthFailed, EventClientAuthFailed}, rec.types())
+	Equal(t, "svc", rec.events[0].Username)
+}
+
+func TestClientCredentials_InvalidRequests(t *testing.T) {
+	h := clientTestHandler()
+
+	recorder := postToken(h, "grant_type=password&username=bob&password=secret")
+	Equal(t, 400, recorder.Code)
+	Equal(t, "unsupported_grant_type", oauthError(recorder))
+
+	recorder = postToken(h, "client_id=svc&client_secret=secret")
+	Equal(t, 400, recorder.Code)
+	Equal(t, "invalid_request", oauthError(recorder))
+
+	recorder = postToken(h, "grant_type=client_credentials")
+	Equal(t, 400, recorder.Code)
+	Equal(t, "invalid_request", oauthError(recorder))
+
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("GET", "/context/login/token?grant_type=client_credentials&client_id=svc&client_secret=secret", ""))
+	Equal(t, 400, recorder.Code)
+	Equal(t, "invalid_request", oauthError(recorder))
+
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("POST", "/context/login/token", `{"grant_type": "client_credentials"}`, TypeJSON))
+	Equal(t, 400, recorder.Code)
+
+	h.SetMaintenance(true)
+	recorder = postToken(h, "grant_type=client_credentials&client_id=svc&client_secret=secret")
+	Equal(t, 503, recorder.Code)
+	Equal(t, "temporarily_unavailable", oauthError(recorder))
+}
+
+func TestClientCredentials_Disabled(t *testing.T) {
+	recorder := httptest.NewRecorder()
+	testHandler().ServeHTTP(recorder, req("POST", "/context/login/token", "grant_type=client_credentials&client_id=svc&client_secret=secret", TypeForm))
+	Equal(t, 404, recorder.Code)
+}
+
+func TestClientCredentials_RateLimit(t *testing.T) {
+	h := clientTestHandler()
+	h.config.ClientRateLimit = 2
+	rec := &eventRecorder{}
+	h.Subscribe(Subscriber{Name: "test", Handle: rec.handle})
+	postTokenFrom := func(remoteAddr, body string) *httptest.ResponseRecorder {
+		r := req("POST", "/context/login/token", body, TypeForm)
+		r.RemoteAddr = remoteAddr
+		recorder := httptest.NewRecorder()
+		h.ServeHTTP(recorder, r)
+		return recorder
+	}
+
+	// successful requests are not limited
+	for i := 0; i < 3; i++ {
+		Equal(t, 200, postTokenFrom("192.0.2.1:1234", "grant_type=client_credentials&client_id=svc&client_secret=secret").Code)
+	}
+
+	// only the failed attempts are counted
+	Equal(t, 401, postTokenFrom("192.0.2.66:1234", "grant_type=client_credentials&client_id=svc&client_secret=wrong").Code)
+	Equal(t, 401, postTokenFrom("192.0.2.66:1234", "grant_type=client_credentials&client_id=svc&client_secret=wrong").Code)
+	recorder := postTokenFrom("192.0.2.66:1234", "grant_type=client_credentials&client_id=svc&client_secret=secret")
+	Equal(t, 429, recorder.Code)
+	Equal(t, "rate_limited", oauthError(recorder))
+	Equal(t, "60", recorder.Header().Get("Retry-After"))
+	Equal(t, EventClientRateLimited, rec.types()[len(rec.types())-1])
+
+	// the limit is per caller ip, so the client is not locked out by others
+	Equal(t, 200, postTokenFrom("192.0.2.1:1234", "grant_type=client_credentials&client_id=svc&client_secret=secret").Code)
+
+	// and per client
+	Equal(t, 401, postTokenFrom("192.0.2.66:1234", "grant_type=client_credentials&client_id=other&client_secret=secret").Code)
+
+	// and resets after the window
+	h.store.now = func() time.Time { return time.Now().Add(clientRateWindow) }
+	Equal(t, 200, postTokenFrom("192.0.2.66:1234", "grant_type=client_credentials&client_id=svc&client_secret=secret").Code)
+}
+
+func TestClientCredentials_RateLimit_ResetOnSuccess(t *testing.T) {
+	h := clientTestHandler()
+	h.config.ClientRateLimit = 2
+
+	Equal(t, 401, postToken(h, "grant_type=client_credentials&client_id=svc&client_secret=wrong").Code)
+	Equal(t, 200, postToken(h, "grant_type=client_credentials&client_id=svc&client_secret=secret").Code)
+	Equal(t, 401, postToken(h, "grant_type=client_credentials&client_id=svc&client_secret=wrong").Code)
+	Equal(t, 200, postToken(h, "grant_type=client_credentials&client_id=svc&client_secret=secret").Code)
+}
+
+// slowClientBackend answers after a delay, so that concurrent requests overlap
+type slowClientBackend struct {
+	clientTestBackend
+}
+
+func (b slowClientBackend) AuthenticateClient(ctx context.Context, clientID, clientSecret string) (bool, model.UserInfo, error) {
+	time.Sleep(50 * time.Millisecond)
+	return b.clientTestBackend.AuthenticateClient(ctx, clientID, clientSecret)
+}
+
+func TestClientCredentials_RateLimit_Concurrent(t *testing.T) {
+	h := testHandler()
+	h.backends = append(h.backends, slowClientBackend{clientTestBackend{NewSimpleBackend(map[string]string{})}})
+	h.backendNames = []string{"simple", "clients"}
+	h.config.ClientRateLimit = 3
+
+	codes := make(chan int, 20)
+	wg := sync.WaitGroup{}
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			codes <- postToken(h, "grant_type=client_credentials&client_id=svc&client_secret=wrong").Code
+		}()
+	}
+	wg.Wait()
+	close(codes)
+
+	counts := map[int]int{}
+	for code := range codes {
+		counts[code]++
+	}
+	Equal(t, map[int]int{401: 3, 429: 17}, counts)
+}
@@ -1,23 +1,30 @@
 package login
 
 import (
+	"crypto/rand"
+	"encoding/base64"
 	"errors"
 	"flag"
 	"fmt"
-	"math/rand"
 	"os"
+	"sort"
 	"strings"
 	"time"
 
+	"github.com/tarent/loginsrv/clockskew"
 	"github.com/tarent/loginsrv/logging"
 	"github.com/tarent/loginsrv/oauth2"
 )
 
+// jwtDefaultSecret is the random secret used without a -jwt-secret, so that a restart invalidates all sessions
 var jwtDefaultSecret string
 
 func init() {
-	rand.Seed(time.Now().UTC().UnixNano())
-	jwtDefaultSecret = randStringBytes(32)
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		panic(fmt.Sprintf("could not generate the default jwt secret: %v", err))
+	}
+	jwtDefaultSecret = base64.RawURLEncoding.EncodeToString(b)
 }
 
 // DefaultConfig for the loginsrv handler
@@ -31,12 +38,44 @@ func DefaultConfig() *Config {
 		JwtRefreshes:   0,
 		SuccessURL:     "/",
 		LogoutURL:      "",
+		VerifyHeaders:  defaultVerifyHeaders,
 		LoginPath:      "/login",
 		CookieName:     "jwt_token",
 		CookieHTTPOnly: true,
 		Backends:       Options{},
 		Oauth:          Options{},
 		GracePeriod:    5 * time.Second,
+		ConflictPolicy: ConflictPolicyFirstMatch,
+
+		MaintenanceMessage: "The login is not available due to maintenance. Please try again later.",
+
+		ClaimsMaxGroups: 100,
+		ClaimsMaxLength: 1024,
+		ClaimsMaxSize:   4096,
+
+		StateSnapshotInterval: time.Minute,
+
+		OriginOverrides: Options{},
+
+		JwtKMSTimeout: 2 * time.Second,
+
+		APIVersion: apiVersion1,
+
+		SlowRequestLogLimit: 10,
+
+		JwtLegacyCookieName: "jwt_token_legacy",
+		JwtLegacyOutput:     "cookie",
+
+		ReadyInterval: 10 * time.Second,
+		ReadyTimeout:  2 * time.Second,
+		ReadyRise:     2,
+		ReadyFall:     3,
+
+		ClientRateLimit: 60,
+
+		ClockSkewThreshold: clockskew.DefaultThreshold,
+
+		ContentNegotiation: negotiationAuto,
 	}
 }
 
@@ -62,12 +101,166 @@ type Config struct {
 	Backends       Options
 	Oauth          Options
 	GracePeriod    time.Duration
+	StrictStartup  bool
+	ValidateOnly   bool
+
+	ConflictPolicy        string
+	ConflictCheckInterval time.Duration
+
+	Maintenance         bool
+	MaintenanceDuration time.Duration
+	MaintenanceMessage  string
+
+	ClaimsMaxGroups int
+	ClaimsMaxLength int
+
+	InstanceID  string
+	JwtAudience string
+
+	DumpConfig bool
+
+	TrustedProxies string
+
+	StateFile             string
+	StateSnapshotInterval time.Duration
+
+	DebugTokenPage    bool
+	IKnowThisIsUnsafe bool
+
+	OriginOverrides         Options
+	AllowLongerOriginExpiry bool
+
+	LoginPathAliases string
+
+	JwtKMSKey      string
+	JwtKMSEndpoint string
+	JwtKMSTimeout  time.Duration
+
+	APIVersion int
+
+	SlowRequestThreshold time.Duration
+	SlowRequestLogLimit  int
+
+	TLSCert        string
+	TLSKey         string
+	TLSClientCA    string
+	BindClientCert bool
+
+	JwtLegacySecret     string
+	JwtRolloverStart    string
+	JwtRolloverEnd      string
+	JwtLegacyCookieName string
+	JwtLegacyOutput     string
+
+	RedirectWhitelist string
+
+	AllowIPs string
+	DenyIPs  string
+
+	SetCookieForAPI bool
+
+	ClientRateLimit int
+
+	ClockSkewThreshold time.Duration
+
+	BreakGlassFile    string
+	BreakGlassWebhook string
+
+	JwtPrivateKey string
+
+	CookieSecure      bool
+	CookieSameSite    string
+	CookiePartitioned bool
+	EmbeddedParam     string
+
+	JwtAlgo string
+
+	JwtSecretFallback string
+
+	JwtLeeway    time.Duration
+	JwtNotBefore bool
+
+	OutboundTLSCA         string
+	OutboundTLSMinVersion string
+	OutboundTLSClientCert string
+	OutboundTLSClientKey  string
+	OutboundTLSSkipVerify bool
+	OutboundTLSHosts      Options
+
+	JwtSecretFile string
+
+	ReadyInterval time.Duration
+	ReadyTimeout  time.Duration
+	ReadyRise     int
+	ReadyFall     int
+	ReadyChecks   Options
+
+	JwtSecretBase64 bool
+
+	ContentNegotiation string
+
+	JwtRefreshGrace time.Duration
+
+	JwtMaxSession time.Duration
+
+	ContentSecurityPolicy string
+
+	JwtSlidingExpiry bool
+
+	JwtRefreshTokenExpiry time.Duration
+
+	TokenHeader      string
+	TokenHeaderOnGet bool
+
+	JweKey            string
+	JwePlainAPITokens bool
+
+	MaxRequestDuration time.Duration
+
+	JwtClaimMap string
+
+	FrontChannelLogout bool
+
+	JwtClaims string
+
+	UserFile string
+
+	IntrospectionSecret string
+
+	VerifyHeaders string
+
+	AdminGroup string
+
+	ClaimsStrict   bool
+	ClaimsMaxSize  int
+	ClaimsMaxDepth int
+
+	JwtSecretMinLength int
+
+	StoreFile string
+
+	AdminAPIKey string
 }
 
 // Options is the configuration structure for oauth and backend provider
 // key is the providername, value is a options map.
 type Options map[string]map[string]string
 
+// sortedOptionNames returns the provider names of the options in alphabetical order
+func sortedOptionNames(o Options) []string {
+	names := make([]string, 0, len(o))
+	for name := range o {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Names returns the provider names of the options in alphabetical order
+func (o Options) Names() []string {
+	return sortedOptionNames(o)
+}
+
 // addOauthOpts adds the options for a provider in the form of key=value,key=value,..
 func (c *Config) addOauthOpts(providerName, optsKvList string) error {
 	opts, err := parseOptions(optsKvList)
@@ -96,18 +289,120 @@ func (c *Config) ConfigureFlagSet(f *flag.FlagSet) {
 	f.StringVar(&c.Port, "port", c.Port, "The port to listen on")
 	f.StringVar(&c.LogLevel, "log-level", c.LogLevel, "The log level")
 	f.BoolVar(&c.TextLogging, "text-logging", c.TextLogging, "Log in text format instead of json")
-	f.StringVar(&c.JwtSecret, "jwt-secret", c.JwtSecret, "The secret to sign the jwt token")
+	f.StringVar(&c.JwtSecret, "jwt-secret", c.JwtSecret, "The secret to sign the jwt token. random generates a random secret on startup, so a restart invalidates all sessions")
 	f.DurationVar(&c.JwtExpiry, "jwt-expiry", c.JwtExpiry, "The expiry duration for the jwt token, e.g. 2h or 3h30m")
+	f.StringVar(&c.JwtKMSKey, "jwt-kms-key", c.JwtKMSKey, "Sign the tokens with an asymmetric aws kms key (key id or arn) instead of the jwt secret. The credentials are taken from the environment")
+	f.StringVar(&c.JwtSecretFallback, "jwt-secret-fallback", c.JwtSecretFallback, "Comma separated previous jwt secrets. Their tokens are still accepted, refreshed tokens are signed with the jwt secret")
+	f.StringVar(&c.JwtAlgo, "jwt-algo", c.JwtAlgo, "The algorithm to sign the tokens with, e.g. HS256, RS384 or ES256. Only this algorithm is accepted on verification. Default is the algorithm of the key: HS512 for the jwt secret")
+	f.StringVar(&c.JwtPrivateKey, "jwt-private-key", c.JwtPrivateKey, "Sign the tokens with the private key of this pem file instead of the jwt secret: RS256 with an rsa key, ES256, ES384 or ES512 with an ecdsa key, EdDSA with an ed25519 key")
+	f.StringVar(&c.JwtKMSEndpoint, "jwt-kms-endpoint", c.JwtKMSEndpoint, "Alternative aws kms endpoint, e.g. for a vpc endpoint")
+	f.DurationVar(&c.JwtKMSTimeout, "jwt-kms-timeout", c.JwtKMSTimeout, "Timeout for the calls to aws kms")
+	f.StringVar(&c.JwtLegacySecret, "jwt-legacy-secret", c.JwtLegacySecret, "The previous hs512 secret. Within the rollover window, a second token is signed with it and its tokens are still accepted")
+	f.StringVar(&c.JwtRolloverStart, "jwt-rollover-start", c.JwtRolloverStart, "Start of the rollover window to the new jwt secret or key (RFC 3339)")
+	f.StringVar(&c.JwtRolloverEnd, "jwt-rollover-end", c.JwtRolloverEnd, "End of the rollover window to the new jwt secret or key (RFC 3339)")
+	f.StringVar(&c.JwtLegacyCookieName, "jwt-legacy-cookie-name", c.JwtLegacyCookieName, "The name of the cookie for the legacy token")
+	f.StringVar(&c.JwtLegacyOutput, "jwt-legacy-output", c.JwtLegacyOutput, "Where to put the legacy token: cookie, field (json api) or cookie,field")
 	f.IntVar(&c.JwtRefreshes, "jwt-refreshes", c.JwtRefreshes, "The maximum amount of jwt refreshes. 0 by Default")
 	f.StringVar(&c.CookieName, "cookie-name", c.CookieName, "The name of the jwt cookie")
 	f.BoolVar(&c.CookieHTTPOnly, "cookie-http-only", c.CookieHTTPOnly, "Set the cookie with the http only flag")
+	f.BoolVar(&c.SetCookieForAPI, "set-cookie-for-api", c.SetCookieForAPI, "Set the cookie also on logins of non html clients, in addition to the token in the body")
 	f.DurationVar(&c.CookieExpiry, "cookie-expiry", c.CookieExpiry, "The expiry duration for the cookie, e.g. 2h or 3h30m. Default is browser session")
 	f.StringVar(&c.CookieDomain, "cookie-domain", c.CookieDomain, "The optional domain parameter for the cookie")
+	f.BoolVar(&c.CookieSecure, "cookie-secure", c.CookieSecure, "Set the cookie with the secure flag, so that it is only sent over https")
+	f.StringVar(&c.CookieSameSite, "cookie-same-site", c.CookieSameSite, "The optional same site attribute of the cookie: lax, strict or none. None requires -cookie-secure")
+	f.BoolVar(&c.CookiePartitioned, "cookie-partitioned", c.CookiePartitioned, "Set the cookie partitioned (CHIPS), for logins embedded on other sites. Requires -cookie-secure and -cookie-same-site none")
+	f.StringVar(&c.EmbeddedParam, "embedded-param", c.EmbeddedParam, "Name of the parameter, which marks logins from an embedded context, e.g. an iframe. These logins get the token in the response body in addition to the cookie")
 	f.StringVar(&c.SuccessURL, "success-url", c.SuccessURL, "The url to redirect after login")
+	f.StringVar(&c.RedirectWhitelist, "redirect-whitelist", c.RedirectWhitelist, "Comma separated list of hosts, the backTo parameter of the provider deep links may redirect to. Relative paths are always allowed")
 	f.StringVar(&c.LogoutURL, "logout-url", c.LogoutURL, "The url or path to redirect after logout")
 	f.StringVar(&c.Template, "template", c.Template, "An alternative template for the login form")
 	f.StringVar(&c.LoginPath, "login-path", c.LoginPath, "The path of the login resource")
+	f.StringVar(&c.LoginPathAliases, "login-path-aliases", c.LoginPathAliases, "Comma separated list of additional paths, the login resource is served on")
 	f.DurationVar(&c.GracePeriod, "grace-period", c.GracePeriod, "Graceful shutdown grace period")
+	f.StringVar(&c.AllowIPs, "allow-ips", c.AllowIPs, "Comma separated list of networks (CIDR), logins are allowed from. Empty allows all networks")
+	f.StringVar(&c.DenyIPs, "deny-ips", c.DenyIPs, "Comma separated list of networks (CIDR), logins are denied from. Takes precedence over -allow-ips")
+	f.IntVar(&c.ClientRateLimit, "client-rate-limit", c.ClientRateLimit, "The maximum number of failed client_credentials token requests per client, caller ip and minute. 0 for no limit")
+	f.DurationVar(&c.ClockSkewThreshold, "clock-skew-threshold", c.ClockSkewThreshold, "Log a warning, if the clock of an oauth provider or backend differs more than this from the local clock. 0 disables the warning")
+	f.StringVar(&c.BreakGlassFile, "break-glass-file", c.BreakGlassFile, "File with emergency accounts (username:bcrypt-hash per line), which are only accepted if every login backend fails with an error")
+	f.StringVar(&c.BreakGlassWebhook, "break-glass-webhook", c.BreakGlassWebhook, "Url to post an alert to on each break-glass login")
+	f.StringVar(&c.TrustedProxies, "trusted-proxies", c.TrustedProxies, "Comma separated list of proxy networks (CIDR), which are trusted to set the X-Forwarded-For header")
+	f.BoolVar(&c.StrictStartup, "strict-startup", c.StrictStartup, "Fail on startup, if the validation of the oauth providers fails")
+	f.BoolVar(&c.DumpConfig, "dump-config", c.DumpConfig, "Print the effective configuration and the registered providers as json and exit")
+	f.BoolVar(&c.ValidateOnly, "validate", c.ValidateOnly, "Validate the configuration and the oauth providers and exit")
+	f.StringVar(&c.ConflictPolicy, "conflict-policy", c.ConflictPolicy, "Handling of usernames known by multiple backends: first-match, deny or require-realm")
+	f.DurationVar(&c.ConflictCheckInterval, "conflict-check-interval", c.ConflictCheckInterval, "Interval to repeat the check for usernames known by multiple backends. 0 checks only on startup")
+	f.BoolVar(&c.Maintenance, "maintenance", c.Maintenance, "Start in maintenance mode: no new logins, but existing tokens stay valid. Toggle at runtime with SIGUSR1")
+	f.DurationVar(&c.MaintenanceDuration, "maintenance-duration", c.MaintenanceDuration, "Time box for the maintenance mode, e.g. 30m. 0 means until switched off")
+	f.StringVar(&c.MaintenanceMessage, "maintenance-message", c.MaintenanceMessage, "The message shown on the login form during maintenance")
+	f.IntVar(&c.ClaimsMaxGroups, "claims-max-groups", c.ClaimsMaxGroups, "The maximum number of groups taken from a backend into the token. 0 for no limit")
+	f.IntVar(&c.ClaimsMaxLength, "claims-max-length", c.ClaimsMaxLength, "The maximum length of each value taken from a backend into the token. 0 for no limit")
+	f.StringVar(&c.StateFile, "state-file", c.StateFile, "File to persist in memory state like lockouts and revocations across restarts. Empty disables persistence")
+	f.DurationVar(&c.StateSnapshotInterval, "state-snapshot-interval", c.StateSnapshotInterval, "Interval for writing the state file. It is always written on shutdown")
+	f.BoolVar(&c.DebugTokenPage, "debug-token-page", c.DebugTokenPage, "For development only: Show the claims of the issued token after html logins, instead of redirecting. Only allowed on localhost")
+	f.BoolVar(&c.IKnowThisIsUnsafe, "i-know-this-is-unsafe", c.IKnowThisIsUnsafe, "Allow the debug token page on hosts other than localhost")
+	f.IntVar(&c.APIVersion, "api-version", c.APIVersion, "The default version of the json api for clients, which don't request one: 1 (bare jwt, plain text errors) or 2 (json bodies)")
+	f.DurationVar(&c.SlowRequestThreshold, "slow-request-threshold", c.SlowRequestThreshold, "Log the phase timings of requests taking longer than this, e.g. 500ms. 0 disables the slow request log")
+	f.IntVar(&c.SlowRequestLogLimit, "slow-request-log-limit", c.SlowRequestLogLimit, "The maximum number of slow request log entries per minute. 0 for no limit")
+	f.StringVar(&c.TLSCert, "tls-cert", c.TLSCert, "Certificate file for serving https. Plain http is served, if empty")
+	f.StringVar(&c.TLSKey, "tls-key", c.TLSKey, "Private key file for serving https")
+	f.StringVar(&c.TLSClientCA, "tls-client-ca", c.TLSClientCA, "CA file for verifying client certificates. Client certificates are optional")
+	f.BoolVar(&c.BindClientCert, "bind-client-cert", c.BindClientCert, "Bind the tokens to the verified client certificate of the connection by the cnf claim (RFC 8705)")
+	f.StringVar(&c.InstanceID, "instance-id", c.InstanceID, "Identifier of this loginsrv instance. If set, it is written to the iss claim and tokens of other instances are rejected")
+	f.StringVar(&c.InstanceID, "jwt-issuer", c.InstanceID, "Alias of -instance-id: the iss claim of the issued tokens. If set, tokens of other issuers are rejected")
+	f.DurationVar(&c.JwtLeeway, "jwt-leeway", c.JwtLeeway, "Tolerated clock skew for the expiry and the not before time of tokens, e.g. 30s")
+	f.BoolVar(&c.JwtNotBefore, "jwt-not-before", c.JwtNotBefore, "Set the nbf claim of issued tokens to the time of issue")
+	f.StringVar(&c.JwtAudience, "jwt-audience", c.JwtAudience, "Comma separated audiences. The first one is written to the aud claim, tokens for other audiences are rejected")
+
+	f.StringVar(&c.OutboundTLSCA, "outbound-tls-ca", c.OutboundTLSCA, "PEM file or directory of PEM files with the CA certificates for the connections to oauth providers, backends, webhooks and the kms. The system roots are used, if empty")
+	f.StringVar(&c.OutboundTLSMinVersion, "outbound-tls-min-version", c.OutboundTLSMinVersion, "The minimum tls version of the outbound connections: 1.0, 1.1, 1.2 or 1.3")
+	f.StringVar(&c.OutboundTLSClientCert, "outbound-tls-client-cert", c.OutboundTLSClientCert, "PEM file of the client certificate for the outbound connections")
+	f.StringVar(&c.OutboundTLSClientKey, "outbound-tls-client-key", c.OutboundTLSClientKey, "PEM file of the key of the outbound client certificate")
+	f.BoolVar(&c.OutboundTLSSkipVerify, "outbound-tls-skip-verify", c.OutboundTLSSkipVerify, "Do not verify the server certificates of the outbound connections. For testing only")
+	f.Var(setFunc(c.addOutboundTLSHost), "outbound-tls-host", "Outbound tls settings for one host: host=..,ca=..,min-version=..,client-cert=..,client-key=..,skip-verify=.. (repeatable)")
+
+	f.StringVar(&c.JwtSecretFile, "jwt-secret-file", c.JwtSecretFile, "File containing the secret to sign the jwt token. Takes precedence over -jwt-secret")
+
+	f.DurationVar(&c.ReadyInterval, "ready-interval", c.ReadyInterval, "Interval of the background readiness checks of the backends")
+	f.DurationVar(&c.ReadyTimeout, "ready-timeout", c.ReadyTimeout, "Timeout of each readiness check")
+	f.IntVar(&c.ReadyRise, "ready-rise", c.ReadyRise, "Number of successful checks in a row, after which a failed backend is ready again")
+	f.IntVar(&c.ReadyFall, "ready-fall", c.ReadyFall, "Number of failed checks in a row, after which a backend is not ready")
+	f.Var(setFunc(c.addReadyCheck), "ready-check", "Readiness check settings for one backend: name=..,interval=..,timeout=..,rise=..,fall=.. (repeatable)")
+
+	f.BoolVar(&c.JwtSecretBase64, "jwt-secret-base64", c.JwtSecretBase64, "The jwt secret and the jwt secret fallbacks are base64 encoded, the tokens are signed with the decoded bytes")
+
+	f.StringVar(&c.ContentNegotiation, "content-negotiation", c.ContentNegotiation, "The responses by the accept header: auto, api to always answer like for api clients or html to always answer like for browsers")
+
+	f.DurationVar(&c.JwtRefreshGrace, "jwt-refresh-grace", c.JwtRefreshGrace, "Accept tokens for refreshes, which expired within this duration, e.g. 5m. Other requests still reject them")
+
+	f.DurationVar(&c.JwtMaxSession, "jwt-max-session", c.JwtMaxSession, "The absolute lifetime of a session across all refreshes, e.g. 24h. 0 for no limit")
+
+	f.StringVar(&c.ContentSecurityPolicy, "content-security-policy", c.ContentSecurityPolicy, "The Content-Security-Policy of the login form, {nonce} is replaced by the nonce of the request. Default is a strict policy for the built-in template and none for custom templates, off disables it")
+
+	f.BoolVar(&c.JwtSlidingExpiry, "jwt-sliding-expiry", c.JwtSlidingExpiry, "Renew the token cookie of active users on every authenticated GET of the login resource and on refreshes, without counting the refreshes. Requires -jwt-max-session")
+
+	f.DurationVar(&c.JwtRefreshTokenExpiry, "jwt-refresh-token-expiry", c.JwtRefreshTokenExpiry, "Issue a separate refresh token with this expiry, e.g. 720h, next to the access token. Refreshes only accept the refresh token and rotate it. 0 disables refresh tokens")
+
+	f.StringVar(&c.TokenHeader, "token-header", c.TokenHeader, "Set the jwt of the html login additionally in this response header, e.g. X-Auth-Token for a gateway. Empty disables it")
+	f.BoolVar(&c.TokenHeaderOnGet, "token-header-on-get", c.TokenHeaderOnGet, "Set the -token-header also on every authenticated GET of the login resource")
+	f.StringVar(&c.JweKey, "jwe-key", c.JweKey, "Base64 encoded 256 bit key to encrypt the tokens as jwe (A256GCM), so that their claims are not readable by the browser")
+	f.BoolVar(&c.JwePlainAPITokens, "jwe-plain-api-tokens", c.JwePlainAPITokens, "Return the plain signed tokens to api clients, only the cookies are encrypted by the -jwe-key")
+	f.DurationVar(&c.MaxRequestDuration, "max-request-duration", c.MaxRequestDuration, "Hard limit for the processing of a login request with all backends, oauth calls and webhooks, e.g. 5s. Exceeding requests are answered with 503. 0 for no limit")
+	f.StringVar(&c.JwtClaimMap, "jwt-claim-map", c.JwtClaimMap, "Comma separated claim:name pairs to rename the claims in the tokens, e.g. sub:preferred_username,origin:idp")
+	f.BoolVar(&c.FrontChannelLogout, "frontchannel-logout", c.FrontChannelLogout, "Serve the OpenID Connect front-channel logout at <login-path>/frontchannel-logout, which ends the sessions of a logout at the oauth provider")
+	f.StringVar(&c.JwtClaims, "jwt-claims", c.JwtClaims, "Comma separated name=value pairs of static claims for every token, e.g. tenant=acme,env=prod. true, false and numbers are typed, values in double quotes are strings")
+	f.StringVar(&c.UserFile, "user-file", c.UserFile, "A yaml file with claims for the tokens of the users, which match by sub, origin, domain or groups")
+	f.StringVar(&c.IntrospectionSecret, "introspection-secret", c.IntrospectionSecret, "The secret, which the callers of the token introspection at <login-path>/introspect have to send as bearer token. Empty disables the introspection")
+	f.StringVar(&c.VerifyHeaders, "verify-headers", c.VerifyHeaders, "Comma separated claim:header pairs, which are set by <login-path>/verify for valid tokens. Supported claims are sub, name, email, origin, domain, iss, aud and groups")
+	f.StringVar(&c.AdminGroup, "admin-group", c.AdminGroup, "The group of the users, who may disable and enable providers at <login-path>/admin/providers. Empty disables the admin api")
+	f.BoolVar(&c.ClaimsStrict, "claims-strict", c.ClaimsStrict, "Reject logins, whose token claims exceed the -claims-max-size or -claims-max-depth or have other values than strings, numbers, booleans and lists of strings")
+	f.IntVar(&c.ClaimsMaxSize, "claims-max-size", c.ClaimsMaxSize, "The maximum size of the serialized token claims in bytes with -claims-strict. 0 for no limit")
+	f.IntVar(&c.ClaimsMaxDepth, "claims-max-depth", c.ClaimsMaxDepth, "The maximum nesting depth of object claims with -claims-strict. 0 for flat claims only")
+	f.IntVar(&c.JwtSecretMinLength, "jwt-secret-min-length", c.JwtSecretMinLength, "The minimum length of the jwt secret and the jwt secret fallbacks in bytes, e.g. 32. 0 only rejects empty secrets and warns about secrets shorter than 32 bytes")
+	f.StringVar(&c.StoreFile, "store-file", c.StoreFile, "File to keep the revoked tokens, failed logins and sessions crash safe, instead of the memory and the -state-file. Changes need a restart")
+	f.StringVar(&c.AdminAPIKey, "admin-api-key", c.AdminAPIKey, "The static key, which the callers of the session admin api at <login-path>/admin/sessions may send as bearer token instead of a token of the -admin-group. Empty disables the key")
+
+	f.Var(setFunc(c.addOriginOverride), "origin-override", "Token settings for logins of one backend or oauth provider: origin=..,jwt-expiry=..,cookie-expiry=..,jwt-refreshes=.. (repeatable)")
+	f.BoolVar(&c.AllowLongerOriginExpiry, "allow-longer-origin-expiry", c.AllowLongerOriginExpiry, "Allow origin overrides with a jwt-expiry longer than -jwt-expiry")
 
 	// the -backends is deprecated, but we support it for backwards compatibility
 	deprecatedBackends := setFunc(func(optsKvList string) error {
@@ -164,7 +459,7 @@ func readConfig(f *flag.FlagSet, args []string) (*Config, error) {
 
 	// prefer environment settings
 	f.VisitAll(func(f *flag.Flag) {
-		if val, isPresent := os.LookupEnv(envName(f.Name)); isPresent {
+		if val, isPresent := os.LookupEnv(EnvName(f.Name)); isPresent {
 			f.Value.Set(val)
 		}
 	})
@@ -178,17 +473,8 @@ func readConfig(f *flag.FlagSet, args []string) (*Config, error) {
 	return config, err
 }
 
-const letterBytes = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
-
-func randStringBytes(n int) string {
-	b := make([]byte, n)
-	for i := range b {
-		b[i] = letterBytes[rand.Intn(len(letterBytes))]
-	}
-	return string(b)
-}
-
-func envName(flagName string) string {
+// EnvName returns the environment variable of a config option, e.g. LOGINSRV_JWT_SECRET for jwt-secret
+func EnvName(flagName string) string {
 	return envPrefix + strings.Replace(strings.ToUpper(flagName), "-", "_", -1)
 }
 
@@ -0,0 +1,54 @@
+package login
+
+import (
+	"encoding/json"
+	"io"
+	"sort"
+
+	"github.com/tarent/loginsrv/oauth2"
+)
+
+type configDump struct {
+	Config           *Config               `json:"config"`
+	BackendProviders []backendProviderDump `json:"backend_providers"`
+	OauthProviders   []oauthProviderDump   `json:"oauth_providers"`
+}
+
+type backendProviderDump struct {
+	Name     string `json:"name"`
+	HelpText string `json:"help_text"`
+}
+
+type oauthProviderDump struct {
+	Name     string `json:"name"`
+	AuthURL  string `json:"auth_url"`
+	TokenURL string `json:"token_url"`
+}
+
+// WriteConfigDump writes the effective configuration with redacted secrets
+// together with all registered backend and oauth providers as json.
+func WriteConfigDump(w io.Writer, c *Config) error {
+	dump := configDump{
+		Config:           c.Redacted(),
+		BackendProviders: []backendProviderDump{},
+		OauthProviders:   []oauthProviderDump{},
+	}
+
+	backendNames := ProviderList()
+	sort.Strings(backendNames)
+	for _, name := range backendNames {
+		desc, _ := GetProviderDescription(name)
+		dump.BackendProviders = append(dump.BackendProviders, backendProviderDump{Name: name, HelpText: desc.HelpText})
+	}
+
+	oauthNames := oauth2.ProviderList()
+	sort.Strings(oauthNames)
+	for _, name := range oauthNames {
+		p, _ := oauth2.GetProvider(name)
+		dump.OauthProviders = append(dump.OauthProviders, oauthProviderDump{Name: name, AuthURL: p.AuthURL, TokenURL: p.TokenURL})
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(dump)
+}
@@ -0,0 +1,28 @@
+package login
+
+import (
+	"bytes"
+	"encoding/json"
+	. "github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestConfigDump(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.JwtSecret = "the-jwt-secret"
+	cfg.Backends = Options{"simple": {"bob": "bobs-password"}}
+	cfg.Oauth = Options{"github": {"client_id": "id", "client_secret": "github-secret"}}
+
+	b := bytes.NewBuffer(nil)
+	NoError(t, WriteConfigDump(b, cfg))
+
+	NotContains(t, b.String(), "the-jwt-secret")
+	NotContains(t, b.String(), "bobs-password")
+	NotContains(t, b.String(), "github-secret")
+
+	dump := map[string]interface{}{}
+	NoError(t, json.Unmarshal(b.Bytes(), &dump))
+	Equal(t, "/login", dump["config"].(map[string]interface{})["LoginPath"])
+	Contains(t, b.String(), `"name": "simple"`)
+	Contains(t, b.String(), `"auth_url": "https://github.com/login/oauth/authorize"`)
+}
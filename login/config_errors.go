@@ -0,0 +1,63 @@
+package login
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/tarent/loginsrv/logging"
+	"github.com/tarent/loginsrv/oauth2"
+)
+
+// ConfigError is an error in the configuration of a backend or oauth provider
+type ConfigError struct {
+	// Kind is backend or oauth
+	Kind     string
+	Provider string
+	// Option is the name of the faulty option, if known
+	Option string
+	Reason string
+}
+
+func (e ConfigError) Error() string {
+	if e.Option == "" {
+		return fmt.Sprintf("%v %v: %v", e.Kind, e.Provider, e.Reason)
+	}
+	return fmt.Sprintf("%v %v: option %v: %v", e.Kind, e.Provider, e.Option, e.Reason)
+}
+
+// ConfigErrors are all configuration errors of the backends and oauth providers.
+type ConfigErrors []ConfigError
+
+func (e ConfigErrors) Error() string {
+	msgs := make([]string, len(e))
+	for i, err := range e {
+		msgs[i] = "  " + err.Error()
+	}
+	return fmt.Sprintf("invalid configuration, %v error(s):\n%v", len(e), strings.Join(msgs, "\n"))
+}
+
+func (e *ConfigErrors) addBackendError(provider string, err error) {
+	*e = append(*e, ConfigError{Kind: "backend", Provider: provider, Reason: err.Error()})
+}
+
+// addOauthError adds the errors of an oauth provider, with one entry for each faulty option.
+func (e *ConfigErrors) addOauthError(provider string, err error) {
+	if optionErrors, ok := err.(oauth2.OptionErrors); ok {
+		for _, oe := range optionErrors {
+			*e = append(*e, ConfigError{Kind: "oauth", Provider: provider, Option: oe.Option, Reason: oe.Reason})
+		}
+		return
+	}
+	*e = append(*e, ConfigError{Kind: "oauth", Provider: provider, Reason: err.Error()})
+}
+
+// log writes each error in a separate entry
+func (e ConfigErrors) log() {
+	for _, err := range e {
+		entry := logging.Logger.WithField("kind", err.Kind).WithField("provider", err.Provider)
+		if err.Option != "" {
+			entry = entry.WithField("option", err.Option)
+		}
+		entry.Error(err.Error())
+	}
+}
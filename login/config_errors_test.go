@@ -0,0 +1,49 @@
+package login
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"testing"
+
+	. "github.com/stretchr/testify/assert"
+	"github.com/tarent/loginsrv/logging"
+)
+
+func TestNewHandler_AllConfigErrors(t *testing.T) {
+	b := bytes.NewBuffer(nil)
+	logging.Logger.Out = b
+	defer func() { logging.Logger.Out = os.Stderr }()
+
+	cfg := DefaultConfig()
+	cfg.Backends = Options{
+		"simple": {"bob": "secret"},
+		"nosuch": {"foo": "bar"},
+	}
+	cfg.Oauth = Options{
+		"github": {"client_id": "foo"},
+		"google": {"redirect_uri": "/login/google"},
+	}
+
+	_, err := NewHandler(cfg)
+	configErrors, ok := err.(ConfigErrors)
+	True(t, ok)
+	Equal(t, ConfigErrors{
+		{Kind: "backend", Provider: "nosuch", Reason: "No such provider"},
+		{Kind: "oauth", Provider: "github", Option: "client_secret", Reason: "missing parameter"},
+		{Kind: "oauth", Provider: "google", Option: "client_id", Reason: "missing parameter"},
+		{Kind: "oauth", Provider: "google", Option: "client_secret", Reason: "missing parameter"},
+		{Kind: "oauth", Provider: "google", Option: "redirect_uri", Reason: `has to be an absolute url, but was "/login/google"`},
+	}, configErrors)
+
+	Equal(t, `invalid configuration, 5 error(s):
+  backend nosuch: No such provider
+  oauth github: option client_secret: missing parameter
+  oauth google: option client_id: missing parameter
+  oauth google: option client_secret: missing parameter
+  oauth google: option redirect_uri: has to be an absolute url, but was "/login/google"`, err.Error())
+
+	// each error is logged on its own
+	Equal(t, 5, strings.Count(b.String(), `"level":"error"`))
+	Contains(t, b.String(), `"option":"redirect_uri"`)
+}
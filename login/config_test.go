@@ -39,6 +39,9 @@ func TestConfig_ReadConfig(t *testing.T) {
 		"--backend=provider=foo",
 		"--github=client_id=foo,client_secret=bar",
 		"--grace-period=4s",
+		"--conflict-policy=deny",
+		"--conflict-check-interval=1h",
+		"--origin-override=origin=htpasswd,jwt-expiry=1h",
 	}
 
 	expected := &Config{
@@ -66,7 +69,28 @@ func TestConfig_ReadConfig(t *testing.T) {
 				"client_secret": "bar",
 			},
 		},
-		GracePeriod: 4 * time.Second,
+		GracePeriod:           4 * time.Second,
+		ConflictPolicy:        ConflictPolicyDeny,
+		ConflictCheckInterval: time.Hour,
+		MaintenanceMessage:    DefaultConfig().MaintenanceMessage,
+		ClaimsMaxGroups:       DefaultConfig().ClaimsMaxGroups,
+		ClaimsMaxSize:         DefaultConfig().ClaimsMaxSize,
+		ClaimsMaxLength:       DefaultConfig().ClaimsMaxLength,
+		StateSnapshotInterval: DefaultConfig().StateSnapshotInterval,
+		JwtKMSTimeout:         DefaultConfig().JwtKMSTimeout,
+		APIVersion:            DefaultConfig().APIVersion,
+		SlowRequestLogLimit:   DefaultConfig().SlowRequestLogLimit,
+		JwtLegacyCookieName:   DefaultConfig().JwtLegacyCookieName,
+		JwtLegacyOutput:       DefaultConfig().JwtLegacyOutput,
+		ClientRateLimit:       DefaultConfig().ClientRateLimit,
+		ClockSkewThreshold:    DefaultConfig().ClockSkewThreshold,
+		ReadyInterval:         DefaultConfig().ReadyInterval,
+		ReadyTimeout:          DefaultConfig().ReadyTimeout,
+		ReadyRise:             DefaultConfig().ReadyRise,
+		ReadyFall:             DefaultConfig().ReadyFall,
+		ContentNegotiation:    DefaultConfig().ContentNegotiation,
+		VerifyHeaders:         DefaultConfig().VerifyHeaders,
+		OriginOverrides:       Options{"htpasswd": {"jwt-expiry": "1h"}},
 	}
 
 	cfg, err := readConfig(flag.NewFlagSet("", flag.ContinueOnError), input)
@@ -92,6 +116,8 @@ func TestConfig_ReadConfigFromEnv(t *testing.T) {
 	NoError(t, os.Setenv("LOGINSRV_SIMPLE", "foo=bar"))
 	NoError(t, os.Setenv("LOGINSRV_GITHUB", "client_id=foo,client_secret=bar"))
 	NoError(t, os.Setenv("LOGINSRV_GRACE_PERIOD", "4s"))
+	NoError(t, os.Setenv("LOGINSRV_CONFLICT_POLICY", "deny"))
+	NoError(t, os.Setenv("LOGINSRV_CONFLICT_CHECK_INTERVAL", "1h"))
 
 	expected := &Config{
 		Host:           "host",
@@ -119,10 +145,37 @@ func TestConfig_ReadConfigFromEnv(t *testing.T) {
 				"client_secret": "bar",
 			},
 		},
-		GracePeriod: 4 * time.Second,
+		GracePeriod:           4 * time.Second,
+		ConflictPolicy:        ConflictPolicyDeny,
+		ConflictCheckInterval: time.Hour,
+		MaintenanceMessage:    DefaultConfig().MaintenanceMessage,
+		ClaimsMaxGroups:       DefaultConfig().ClaimsMaxGroups,
+		ClaimsMaxSize:         DefaultConfig().ClaimsMaxSize,
+		ClaimsMaxLength:       DefaultConfig().ClaimsMaxLength,
+		StateSnapshotInterval: DefaultConfig().StateSnapshotInterval,
+		JwtKMSTimeout:         DefaultConfig().JwtKMSTimeout,
+		APIVersion:            DefaultConfig().APIVersion,
+		SlowRequestLogLimit:   DefaultConfig().SlowRequestLogLimit,
+		JwtLegacyCookieName:   DefaultConfig().JwtLegacyCookieName,
+		JwtLegacyOutput:       DefaultConfig().JwtLegacyOutput,
+		ClientRateLimit:       DefaultConfig().ClientRateLimit,
+		ClockSkewThreshold:    DefaultConfig().ClockSkewThreshold,
+		ReadyInterval:         DefaultConfig().ReadyInterval,
+		ReadyTimeout:          DefaultConfig().ReadyTimeout,
+		ReadyRise:             DefaultConfig().ReadyRise,
+		ReadyFall:             DefaultConfig().ReadyFall,
+		ContentNegotiation:    DefaultConfig().ContentNegotiation,
+		VerifyHeaders:         DefaultConfig().VerifyHeaders,
+		OriginOverrides:       Options{},
 	}
 
 	cfg, err := readConfig(flag.NewFlagSet("", flag.ContinueOnError), []string{})
 	NoError(t, err)
 	Equal(t, expected, cfg)
 }
+
+func TestConfig_JwtIssuerAlias(t *testing.T) {
+	cfg, err := readConfig(flag.NewFlagSet("", flag.ContinueOnError), []string{"--jwt-issuer=sso"})
+	NoError(t, err)
+	Equal(t, "sso", cfg.InstanceID)
+}
@@ -0,0 +1,185 @@
+package login
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/tarent/loginsrv/logging"
+)
+
+// The policies for usernames, which are known by more than one backend
+const (
+	ConflictPolicyFirstMatch   = "first-match"
+	ConflictPolicyDeny         = "deny"
+	ConflictPolicyRequireRealm = "require-realm"
+)
+
+// the users are fetched in batches with a pause in between,
+// so that large directories are not flooded with requests
+var conflictCheckBatchSize = 500
+var conflictCheckPause = 100 * time.Millisecond
+
+func validConflictPolicy(policy string) bool {
+	return policy == ConflictPolicyFirstMatch ||
+		policy == ConflictPolicyDeny ||
+		policy == ConflictPolicyRequireRealm
+}
+
+// findUsernameConflicts returns all usernames, which are listed by more than one backend,
+// together with the names of those backends.
+// Backends not implementing UserLookup are skipped.
+func findUsernameConflicts(backends []Backend, backendNames []string) (map[string][]string, error) {
+	found := map[string][]string{}
+	for i, b := range backends {
+		lookup, ok := b.(UserLookup)
+		if !ok {
+			continue
+		}
+		for offset := 0; ; offset += conflictCheckBatchSize {
+			if offset > 0 {
+				time.Sleep(conflictCheckPause)
+			}
+			users, err := lookup.ListUsers(offset, conflictCheckBatchSize)
+			if err != nil {
+				return nil, fmt.Errorf("error listing users of backend %v: %v", backendNames[i], err)
+			}
+			for _, u := range users {
+				found[u] = append(found[u], backendNames[i])
+			}
+			if len(users) < conflictCheckBatchSize {
+				break
+			}
+		}
+	}
+
+	conflicts := map[string][]string{}
+	for u, names := range found {
+		if len(names) > 1 {
+			conflicts[u] = names
+		}
+	}
+	return conflicts, nil
+}
+
+// conflictCheckState is the result of the username conflict checks for the readiness probe
+type conflictCheckState struct {
+	// started is set, if the handler checks the usernames of its backends
+	started   bool
+	succeeded bool
+	lastError string
+	checkedAt time.Time
+}
+
+// startUsernameConflictCheck runs the conflict check in the background,
+// so that unreachable backends neither block the startup nor a reload.
+// Without backends listing their users, there is nothing to fetch and the conflicts are reset at once.
+func (h *Handler) startUsernameConflictCheck() {
+	if !hasUserLookup(h.backends) {
+		h.muConflicts.Lock()
+		h.conflictCheck = conflictCheckState{}
+		h.muConflicts.Unlock()
+		h.checkUsernameConflicts()
+		return
+	}
+	h.muConflicts.Lock()
+	h.conflictCheck.started = true
+	h.muConflicts.Unlock()
+	h.conflictChecks.Add(1)
+	go func() {
+		defer h.conflictChecks.Done()
+		h.checkUsernameConflicts()
+	}()
+}
+
+func hasUserLookup(backends []Backend) bool {
+	for _, b := range backends {
+		if _, ok := b.(UserLookup); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// checkUsernameConflicts updates the set of conflicting usernames
+// and logs a warning, if there are any.
+func (h *Handler) checkUsernameConflicts() {
+	conflicts, err := findUsernameConflicts(h.backends, h.backendNames)
+	if err != nil {
+		logging.Logger.WithError(err).Warn("username conflict check failed")
+		h.muConflicts.Lock()
+		h.conflictCheck.lastError = err.Error()
+		h.conflictCheck.checkedAt = time.Now()
+		h.muConflicts.Unlock()
+		return
+	}
+
+	if len(conflicts) > 0 {
+		usernames := make([]string, 0, len(conflicts))
+		for u := range conflicts {
+			usernames = append(usernames, u)
+		}
+		sort.Strings(usernames)
+		logging.Logger.
+			WithField("usernames", usernames).
+			WithField("conflicts", conflicts).
+			WithField("conflict_policy", h.config.ConflictPolicy).
+			Warn("usernames found in multiple backends")
+	}
+
+	h.muConflicts.Lock()
+	h.conflicts = conflicts
+	h.conflictCheck.succeeded = true
+	h.conflictCheck.lastError = ""
+	h.conflictCheck.checkedAt = time.Now()
+	h.muConflicts.Unlock()
+}
+
+// conflictCheckStatus returns the state of the conflict check for the readiness probe,
+// or false, if the usernames are not checked.
+// With the deny and require-realm policies, the handler is not ready before a check succeeded,
+// because the conflicting usernames are not known and so not denied until then.
+func (h *Handler) conflictCheckStatus() (dependencyStatus, bool) {
+	h.muConflicts.RLock()
+	s := h.conflictCheck
+	h.muConflicts.RUnlock()
+	if !s.started {
+		return dependencyStatus{}, false
+	}
+	d := dependencyStatus{LastResult: "pending", Error: s.lastError}
+	if !s.checkedAt.IsZero() {
+		d.LastResult = "ok"
+		if s.lastError != "" {
+			d.LastResult = "failed"
+		}
+		d.AgeSeconds = time.Since(s.checkedAt).Seconds()
+	}
+	d.Ready = s.succeeded || (h.config.ConflictPolicy != ConflictPolicyDeny && h.config.ConflictPolicy != ConflictPolicyRequireRealm)
+	return d, true
+}
+
+// backendsFor returns the backends to authenticate the user against together with their names,
+// with respect to the configured conflict policy.
+// The returned username is stripped from a realm suffix, if one was used.
+func (h *Handler) backendsFor(username string) ([]Backend, []string, string) {
+	if h.config.ConflictPolicy == ConflictPolicyRequireRealm {
+		if i := strings.LastIndex(username, "@"); i != -1 {
+			realm := username[i+1:]
+			for j, name := range h.backendNames {
+				if name == realm {
+					return h.backends[j : j+1], h.backendNames[j : j+1], username[:i]
+				}
+			}
+		}
+	}
+
+	h.muConflicts.RLock()
+	_, conflicting := h.conflicts[username]
+	h.muConflicts.RUnlock()
+
+	if conflicting && (h.config.ConflictPolicy == ConflictPolicyDeny || h.config.ConflictPolicy == ConflictPolicyRequireRealm) {
+		return nil, nil, username
+	}
+	return h.backends, h.backendNames, username
+}
@@ -0,0 +1,151 @@
+package login
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	. "github.com/stretchr/testify/assert"
+)
+
+func TestConflicts_FindUsernameConflicts(t *testing.T) {
+	defer func(size int, pause time.Duration) {
+		conflictCheckBatchSize = size
+		conflictCheckPause = pause
+	}(conflictCheckBatchSize, conflictCheckPause)
+	conflictCheckBatchSize = 1
+	conflictCheckPause = 0
+
+	backends := []Backend{
+		NewSimpleBackend(map[string]string{"bob": "secret", "alice": "secret", "jsmith": "secret"}),
+		errorTestBackend("no lookup"),
+		NewSimpleBackend(map[string]string{"jsmith": "other", "bob": "other"}),
+	}
+
+	conflicts, err := findUsernameConflicts(backends, []string{"first", "error", "second"})
+	NoError(t, err)
+	Equal(t, map[string][]string{
+		"bob":    {"first", "second"},
+		"jsmith": {"first", "second"},
+	}, conflicts)
+}
+
+func TestConflicts_Policies(t *testing.T) {
+	first := NewSimpleBackend(map[string]string{"jsmith": "secret", "bob": "secret"})
+	second := NewSimpleBackend(map[string]string{"jsmith": "other"})
+
+	h := testHandler()
+	h.backends = []Backend{first, second}
+	h.backendNames = []string{"first", "second"}
+	h.checkUsernameConflicts()
+
+	// first match
+	h.config.ConflictPolicy = ConflictPolicyFirstMatch
+	authenticated, _, err := h.authenticate("jsmith", "secret")
+	NoError(t, err)
+	True(t, authenticated)
+
+	// deny
+	h.config.ConflictPolicy = ConflictPolicyDeny
+	authenticated, _, err = h.authenticate("jsmith", "secret")
+	NoError(t, err)
+	False(t, authenticated)
+
+	authenticated, _, err = h.authenticate("bob", "secret")
+	NoError(t, err)
+	True(t, authenticated)
+
+	// require realm
+	h.config.ConflictPolicy = ConflictPolicyRequireRealm
+	authenticated, _, err = h.authenticate("jsmith", "other")
+	NoError(t, err)
+	False(t, authenticated)
+
+	authenticated, userInfo, err := h.authenticate("jsmith@second", "other")
+	NoError(t, err)
+	True(t, authenticated)
+	Equal(t, "jsmith", userInfo.Sub)
+
+	authenticated, _, err = h.authenticate("jsmith@first", "other")
+	NoError(t, err)
+	False(t, authenticated)
+}
+
+func TestConflicts_InvalidPolicy(t *testing.T) {
+	_, err := NewHandler(&Config{
+		Backends:       Options{"simple": {"bob": "secret"}},
+		ConflictPolicy: "foo",
+	})
+	EqualError(t, err, "No such conflict policy: foo")
+}
+
+// blockingLookupBackend lists its users only after the release
+type blockingLookupBackend struct {
+	*SimpleBackend
+	release chan struct{}
+}
+
+func (b *blockingLookupBackend) ListUsers(offset, limit int) ([]string, error) {
+	<-b.release
+	return b.SimpleBackend.ListUsers(offset, limit)
+}
+
+func TestConflicts_CheckInBackground(t *testing.T) {
+	release := make(chan struct{})
+	registry := NewProviderRegistry()
+	NoError(t, registry.Register(&ProviderDescription{Name: "blocking"}, func(config map[string]string) (Backend, error) {
+		return &blockingLookupBackend{SimpleBackend: NewSimpleBackend(config), release: release}, nil
+	}))
+	simple, _ := GetProvider(SimpleProviderName)
+	NoError(t, registry.Register(&ProviderDescription{Name: SimpleProviderName}, simple))
+	cfg := DefaultConfig()
+	cfg.Backends = Options{"blocking": {"jsmith": "secret"}, "simple": {"jsmith": "other"}}
+	cfg.ConflictPolicy = ConflictPolicyDeny
+
+	// the unreachable backend does not block the startup
+	h, err := NewHandlerWithOptions(cfg, WithRegistry(registry))
+	NoError(t, err)
+
+	recorder := httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("GET", "/login/ready", ""))
+	Equal(t, 503, recorder.Code)
+	status := readinessStatus{}
+	NoError(t, json.Unmarshal(recorder.Body.Bytes(), &status))
+	Equal(t, "pending", status.UsernameConflicts.LastResult)
+
+	close(release)
+	h.conflictChecks.Wait()
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("GET", "/login/ready", ""))
+	Equal(t, 200, recorder.Code)
+	NoError(t, json.Unmarshal(recorder.Body.Bytes(), &status))
+	Equal(t, "ok", status.UsernameConflicts.LastResult)
+	authenticated, _, err := h.authenticate("jsmith", "secret")
+	NoError(t, err)
+	False(t, authenticated)
+}
+
+func TestConflicts_CheckStatus(t *testing.T) {
+	h := testHandler()
+	_, checked := h.conflictCheckStatus()
+	False(t, checked)
+
+	h.backends = []Backend{errorTestBackend("no lookup")}
+	h.startUsernameConflictCheck()
+	_, checked = h.conflictCheckStatus()
+	False(t, checked, "nothing to check without user lookups")
+
+	// the first match policy does not depend on the check
+	h.conflictCheck = conflictCheckState{started: true, lastError: "connection refused", checkedAt: time.Now()}
+	h.config.ConflictPolicy = ConflictPolicyFirstMatch
+	status, checked := h.conflictCheckStatus()
+	True(t, checked)
+	True(t, status.Ready)
+	Equal(t, "failed", status.LastResult)
+	Equal(t, "connection refused", status.Error)
+
+	h.config.ConflictPolicy = ConflictPolicyRequireRealm
+	status, _ = h.conflictCheckStatus()
+	False(t, status.Ready)
+}
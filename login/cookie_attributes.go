@@ -0,0 +1,88 @@
+package login
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The values of -cookie-same-site
+const (
+	sameSiteLax    = "lax"
+	sameSiteStrict = "strict"
+	sameSiteNone   = "none"
+)
+
+var sameSiteAttributes = map[string]string{
+	sameSiteLax:    "Lax",
+	sameSiteStrict: "Strict",
+	sameSiteNone:   "None",
+}
+
+// checkCookieAttributes validates the combination of the cookie attributes.
+// Browsers drop cookies with SameSite=None or Partitioned, which are not secure.
+func checkCookieAttributes(config *Config) error {
+	sameSite := strings.ToLower(config.CookieSameSite)
+	if _, valid := sameSiteAttributes[sameSite]; sameSite != "" && !valid {
+		return fmt.Errorf("Invalid cookie same site %q, allowed are lax, strict and none", config.CookieSameSite)
+	}
+	if sameSite == sameSiteNone && !config.CookieSecure {
+		return errors.New("A cookie with same site none has to be secure, set -cookie-secure")
+	}
+	if config.CookiePartitioned && (!config.CookieSecure || sameSite != sameSiteNone) {
+		return errors.New("A partitioned cookie has to be secure with same site none, set -cookie-secure and -cookie-same-site none")
+	}
+	return nil
+}
+
+// setCookieLifetime makes the cookie persistent for the lifetime with both Max-Age and Expires:
+// Max-Age is preferred by current browsers and curl, Expires is the fallback for old clients
+// and survives proxies, which drop or mangle one of them.
+// A lifetime of 0 leaves a session cookie without both attributes, which the browser deletes when it is closed.
+func setCookieLifetime(cookie *http.Cookie, lifetime time.Duration) {
+	if lifetime <= 0 {
+		cookie.MaxAge = 0
+		cookie.Expires = time.Time{}
+		return
+	}
+	cookie.MaxAge = int((lifetime + time.Second - 1) / time.Second)
+	cookie.Expires = time.Now().Add(lifetime)
+}
+
+// expireCookie turns the cookie into a deletion with Max-Age=0 and an Expires in the past,
+// so that every client deletes it, whichever of the attributes it understands.
+func expireCookie(cookie *http.Cookie) {
+	cookie.MaxAge = -1
+	cookie.Expires = time.Unix(0, 0)
+}
+
+// setCookie writes the Set-Cookie header for the jwt cookies.
+// The SameSite and Partitioned (CHIPS) attributes are appended by hand,
+// because they are not rendered by http.Cookie of all supported go versions.
+func (h *Handler) setCookie(w http.ResponseWriter, cookie *http.Cookie) {
+	header := cookie.String()
+	if header == "" {
+		return
+	}
+	if attribute, exist := sameSiteAttributes[strings.ToLower(h.config.CookieSameSite)]; exist {
+		header += "; SameSite=" + attribute
+	}
+	if h.config.CookiePartitioned {
+		header += "; Partitioned"
+	}
+	w.Header().Add("Set-Cookie", header)
+}
+
+// isEmbedded returns true for logins from an embedded context, e.g. an iframe on a partner site,
+// which are marked by the configured parameter. Third party cookies may be blocked there,
+// so the token is returned in the response body.
+func (h *Handler) isEmbedded(r *http.Request) bool {
+	if h.config.EmbeddedParam == "" {
+		return false
+	}
+	embedded, _ := strconv.ParseBool(r.FormValue(h.config.EmbeddedParam))
+	return embedded
+}
@@ -0,0 +1,172 @@
+package login
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	. "github.com/stretchr/testify/assert"
+)
+
+func TestCheckCookieAttributes(t *testing.T) {
+	testCases := []struct {
+		secure      bool
+		sameSite    string
+		partitioned bool
+		err         string
+	}{
+		{false, "", false, ""},
+		{false, "lax", false, ""},
+		{false, "Strict", false, ""},
+		{true, "none", false, ""},
+		{true, "None", true, ""},
+		{false, "none", false, "A cookie with same site none has to be secure, set -cookie-secure"},
+		{false, "none", true, "A cookie with same site none has to be secure, set -cookie-secure"},
+		{true, "lax", true, "A partitioned cookie has to be secure with same site none, set -cookie-secure and -cookie-same-site none"},
+		{true, "", true, "A partitioned cookie has to be secure with same site none, set -cookie-secure and -cookie-same-site none"},
+		{true, "always", false, `Invalid cookie same site "always", allowed are lax, strict and none`},
+	}
+	for _, test := range testCases {
+		err := checkCookieAttributes(&Config{CookieSecure: test.secure, CookieSameSite: test.sameSite, CookiePartitioned: test.partitioned})
+		if test.err == "" {
+			NoError(t, err, "%+v", test)
+		} else {
+			EqualError(t, err, test.err, "%+v", test)
+		}
+	}
+}
+
+func partitionedTestHandler() *Handler {
+	h := testHandler()
+	h.config.CookieExpiry = 0
+	h.config.CookieSecure = true
+	h.config.CookieSameSite = "none"
+	h.config.CookiePartitioned = true
+	return h
+}
+
+func TestHandler_PartitionedCookie(t *testing.T) {
+	h := partitionedTestHandler()
+
+	recorder := httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("POST", "/context/login", "username=bob&password=secret", TypeForm, AcceptHTML))
+	Equal(t, 303, recorder.Code)
+	token := readSetCookies(recorder.Header())[0].Value
+	Equal(t, []string{"jwt_token=" + token + "; Path=/; Domain=example.com; HttpOnly; Secure; SameSite=None; Partitioned"},
+		recorder.Header()["Set-Cookie"])
+
+	// the partitioned cookie is deleted with the same attributes, so that the browser finds it
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("DELETE", "/context/login", ""))
+	Equal(t, []string{"jwt_token=delete; Path=/; Domain=example.com; Expires=Thu, 01 Jan 1970 00:00:00 GMT; Max-Age=0; HttpOnly; Secure; SameSite=None; Partitioned"},
+		recorder.Header()["Set-Cookie"])
+}
+
+func TestHandler_CookieSameSite(t *testing.T) {
+	h := testHandler()
+	h.config.CookieExpiry = 0
+	h.config.CookieSameSite = "Lax"
+
+	recorder := httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("POST", "/context/login", "username=bob&password=secret", TypeForm, AcceptHTML))
+	token := readSetCookies(recorder.Header())[0].Value
+	Equal(t, []string{"jwt_token=" + token + "; Path=/; Domain=example.com; HttpOnly; SameSite=Lax"}, recorder.Header()["Set-Cookie"])
+}
+
+func TestHandler_Embedded(t *testing.T) {
+	h := partitionedTestHandler()
+	h.config.EmbeddedParam = "embedded"
+
+	// embedded logins get the token in the body and the cookie
+	recorder := httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("POST", "/context/login?embedded=true", "username=bob&password=secret", TypeForm, AcceptHTML))
+	Equal(t, 200, recorder.Code)
+	Equal(t, contentTypeJWT, recorder.Header().Get("Content-Type"))
+	claims, err := tokenAsMap(recorder.Body.String())
+	NoError(t, err)
+	Equal(t, "bob", claims["sub"])
+	Equal(t, recorder.Body.String(), readSetCookies(recorder.Header())[0].Value)
+
+	// as form parameter as well
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("POST", "/context/login", "username=bob&password=secret&embedded=1", TypeForm, AcceptHTML))
+	Equal(t, 200, recorder.Code)
+
+	// other logins are redirected as usual
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("POST", "/context/login?embedded=false", "username=bob&password=secret", TypeForm, AcceptHTML))
+	Equal(t, 303, recorder.Code)
+
+	h.config.EmbeddedParam = ""
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("POST", "/context/login?embedded=true", "username=bob&password=secret", TypeForm, AcceptHTML))
+	Equal(t, 303, recorder.Code)
+}
+
+func TestNewHandler_InvalidCookieAttributes(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Backends = Options{"simple": {"bob": "secret"}}
+	cfg.CookiePartitioned = true
+	_, err := NewHandler(cfg)
+	Error(t, err)
+}
+
+func TestHandler_CookieLifetime(t *testing.T) {
+	login := func(h *Handler) []string {
+		recorder := httptest.NewRecorder()
+		h.ServeHTTP(recorder, req("POST", "/context/login", "username=bob&password=secret", TypeForm, AcceptHTML))
+		Equal(t, 303, recorder.Code)
+		return recorder.Header()["Set-Cookie"]
+	}
+	tokenOf := func(header []string) (string, string) {
+		cookie := readSetCookies(http.Header{"Set-Cookie": header})[0]
+		return cookie.Value, cookie.RawExpires
+	}
+
+	// persistent cookies have both Max-Age and Expires
+	h := testHandler()
+	header := login(h)
+	token, expires := tokenOf(header)
+	Equal(t, []string{"jwt_token=" + token + "; Path=/; Domain=example.com; Expires=" + expires + "; Max-Age=82800; HttpOnly"}, header)
+	expiry, err := time.Parse(http.TimeFormat, expires)
+	NoError(t, err)
+	InDelta(t, time.Now().Add(23*time.Hour).Unix(), expiry.Unix(), 2)
+
+	// without cookie expiry, it is a session cookie without both
+	h = testHandler()
+	h.config.CookieExpiry = 0
+	h.config.CookieDomain = ""
+	header = login(h)
+	token, _ = tokenOf(header)
+	Equal(t, []string{"jwt_token=" + token + "; Path=/; HttpOnly"}, header)
+
+	// a remembered login by the cookie expiry of the origin
+	h = testHandler()
+	h.config.CookieExpiry = 0
+	h.backendNames = []string{"simple"}
+	h.originOverrides = map[string]sessionSettings{"simple": {JwtExpiry: time.Hour, CookieExpiry: 720 * time.Hour}}
+	header = login(h)
+	token, expires = tokenOf(header)
+	Equal(t, []string{"jwt_token=" + token + "; Path=/; Domain=example.com; Expires=" + expires + "; Max-Age=2592000; HttpOnly"}, header)
+
+	// the deletion has Max-Age=0 and the epoch as Expires
+	recorder := httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("DELETE", "/context/login", ""))
+	Equal(t, []string{"jwt_token=delete; Path=/; Domain=example.com; Expires=Thu, 01 Jan 1970 00:00:00 GMT; Max-Age=0; HttpOnly"}, recorder.Header()["Set-Cookie"])
+
+	// the refresh token cookie is limited to the login path
+	h = testHandler()
+	h.config.JwtExpiry = time.Minute
+	h.config.JwtRefreshTokenExpiry = time.Hour
+	header = login(h)
+	Equal(t, 2, len(header))
+	refreshCookie := readSetCookies(http.Header{"Set-Cookie": header})[1]
+	Equal(t, "jwt_token_refresh="+refreshCookie.Value+"; Path=/context/login; Domain=example.com; Expires="+refreshCookie.RawExpires+"; Max-Age=3600; HttpOnly", header[1])
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("DELETE", "/context/login", ""))
+	Equal(t, []string{
+		"jwt_token=delete; Path=/; Domain=example.com; Expires=Thu, 01 Jan 1970 00:00:00 GMT; Max-Age=0; HttpOnly",
+		"jwt_token_refresh=delete; Path=/context/login; Domain=example.com; Expires=Thu, 01 Jan 1970 00:00:00 GMT; Max-Age=0; HttpOnly",
+	}, recorder.Header()["Set-Cookie"])
+}
@@ -0,0 +1,53 @@
+package login
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"net/http"
+	"strings"
+)
+
+// cspNoncePlaceholder is replaced by the nonce of the request in the content security policy
+const cspNoncePlaceholder = "{nonce}"
+
+// cspOff disables the content security policy
+const cspOff = "off"
+
+// defaultContentSecurityPolicy is the policy of the built-in templates.
+// The styles are only allowed with the nonce of the request, the fonts are loaded by the stylesheets of the cdns.
+const defaultContentSecurityPolicy = "default-src 'none'; style-src 'nonce-" + cspNoncePlaceholder + "'; " +
+	"font-src https://maxcdn.bootstrapcdn.com https://cdnjs.cloudflare.com; img-src https: data:; base-uri 'none'"
+
+// contentSecurityPolicy returns the policy for the html pages of the configuration.
+// Without a configured policy, the built-in template gets the default policy,
+// custom templates get none, as they may contain inline styles or scripts without nonce.
+func contentSecurityPolicy(config *Config) string {
+	if config == nil {
+		return defaultContentSecurityPolicy
+	}
+	switch config.ContentSecurityPolicy {
+	case cspOff:
+		return ""
+	case "":
+		if config.Template != "" {
+			return ""
+		}
+		return defaultContentSecurityPolicy
+	}
+	return config.ContentSecurityPolicy
+}
+
+// setContentSecurityPolicy sets the policy with a new nonce, which is returned for the inline styles and scripts.
+// Without a policy, no header is set and the nonce is empty.
+func setContentSecurityPolicy(w http.ResponseWriter, policy string) (string, error) {
+	if policy == "" {
+		return "", nil
+	}
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	nonce := base64.RawURLEncoding.EncodeToString(b)
+	w.Header().Set("Content-Security-Policy", strings.Replace(policy, cspNoncePlaceholder, nonce, -1))
+	return nonce, nil
+}
@@ -0,0 +1,94 @@
+package login
+
+import (
+	"io/ioutil"
+	"net/http/httptest"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+
+	. "github.com/stretchr/testify/assert"
+)
+
+var cspNoncePattern = regexp.MustCompile(`'nonce-([A-Za-z0-9_-]+)'`)
+
+// cspNonce returns the nonce of the content security policy
+func cspNonce(t testing.TB, policy string) string {
+	match := cspNoncePattern.FindStringSubmatch(policy)
+	if !True(t, len(match) == 2, "no nonce in %q", policy) {
+		return ""
+	}
+	return match[1]
+}
+
+func TestLoginForm_ContentSecurityPolicy(t *testing.T) {
+	config := &Config{LoginPath: "/login", Backends: Options{"simple": {}}}
+	render := func() (string, string) {
+		recorder := httptest.NewRecorder()
+		writeLoginForm(recorder, loginFormData{Config: config})
+		return recorder.Header().Get("Content-Security-Policy"), recorder.Body.String()
+	}
+
+	policy, body := render()
+	nonce := cspNonce(t, policy)
+	Equal(t, strings.Replace(defaultContentSecurityPolicy, cspNoncePlaceholder, nonce, -1), policy)
+	Contains(t, policy, "default-src 'none'")
+	Contains(t, body, `<style nonce="`+nonce+`">`)
+	NotContains(t, body, "<style>")
+
+	// every request gets a new nonce
+	otherPolicy, _ := render()
+	NotEqual(t, nonce, cspNonce(t, otherPolicy))
+
+	// a configured policy
+	config.ContentSecurityPolicy = "default-src 'self'; style-src 'nonce-{nonce}'"
+	policy, body = render()
+	nonce = cspNonce(t, policy)
+	Equal(t, "default-src 'self'; style-src 'nonce-"+nonce+"'", policy)
+	Contains(t, body, `<style nonce="`+nonce+`">`)
+
+	config.ContentSecurityPolicy = cspOff
+	policy, _ = render()
+	Equal(t, "", policy)
+}
+
+func TestLoginForm_ContentSecurityPolicy_CustomTemplate(t *testing.T) {
+	template := filepath.Join(tmpDir(t), "template.html")
+	NoError(t, ioutil.WriteFile(template, []byte(`<style nonce="{{.CSPNonce}}"></style>`), 0644))
+	config := &Config{LoginPath: "/login", Backends: Options{"simple": {}}, Template: template}
+
+	// custom templates get no policy by default, as they may have inline styles without nonce
+	recorder := httptest.NewRecorder()
+	writeLoginForm(recorder, loginFormData{Config: config})
+	Equal(t, "", recorder.Header().Get("Content-Security-Policy"))
+	Equal(t, `<style nonce=""></style>`, recorder.Body.String())
+
+	config.ContentSecurityPolicy = "style-src 'nonce-{nonce}'"
+	recorder = httptest.NewRecorder()
+	writeLoginForm(recorder, loginFormData{Config: config})
+	nonce := cspNonce(t, recorder.Header().Get("Content-Security-Policy"))
+	Equal(t, `<style nonce="`+nonce+`"></style>`, recorder.Body.String())
+}
+
+// the cost of the nonce compared to the rendering of the form
+func BenchmarkWriteLoginForm(b *testing.B) {
+	for _, policy := range []string{cspOff, ""} {
+		name := "with-nonce"
+		if policy == cspOff {
+			name = "without-nonce"
+		}
+		config := &Config{LoginPath: "/login", Backends: Options{"simple": {}}, ContentSecurityPolicy: policy}
+		b.Run(name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				writeLoginForm(httptest.NewRecorder(), loginFormData{Config: config})
+			}
+		})
+	}
+}
+
+func BenchmarkSetContentSecurityPolicy(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		setContentSecurityPolicy(httptest.NewRecorder(), defaultContentSecurityPolicy)
+	}
+}
@@ -0,0 +1,107 @@
+package login
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"html/template"
+	"net"
+	"net/http"
+
+	"github.com/tarent/loginsrv/logging"
+	"github.com/tarent/loginsrv/model"
+)
+
+// errDebugTokenPageUnsafe is returned, if the debug token page is enabled on a public listener
+var errDebugTokenPageUnsafe = errors.New("the debug token page is only allowed, if the host is bound to localhost. Use -i-know-this-is-unsafe to enable it anyway")
+
+const debugTokenPage = `<!DOCTYPE html>
+<html>
+  <head>
+    {{ template "styles" . }}
+  </head>
+  <body>
+    <div class="container">
+      <div class="row vertical-offset-100">
+        <div class="col-md-6 col-md-offset-3">
+          <div class="alert alert-warning" role="alert">
+            <strong>Debug token page.</strong> Do not enable this in production.
+          </div>
+          <h4>Claims</h4>
+          <pre class="token-claims">{{ .Claims }}</pre>
+          <h4>Cookie</h4>
+          <table class="table table-condensed cookie-attributes">
+            <tr><th>Name</th><td>{{ .Cookie.Name }}</td></tr>
+            <tr><th>Path</th><td>{{ .Cookie.Path }}</td></tr>
+            <tr><th>Domain</th><td>{{ .Cookie.Domain }}</td></tr>
+            <tr><th>Expires</th><td>{{ if .Cookie.Expires.IsZero }}end of browser session{{ else }}{{ .Cookie.Expires }}{{ end }}</td></tr>
+            <tr><th>HttpOnly</th><td>{{ .Cookie.HttpOnly }}</td></tr>
+          </table>
+          <a class="btn btn-md btn-primary" href="{{ .SuccessURL }}">Continue</a>
+        </div>
+      </div>
+    </div>
+  </body>
+</html>`
+
+type debugTokenPageData struct {
+	Claims     string
+	Cookie     *http.Cookie
+	SuccessURL string
+	CSPNonce   string
+}
+
+// checkDebugTokenPage verifies, that the debug token page is only enabled
+// on a localhost listener, unless it was explicitly forced.
+func checkDebugTokenPage(config *Config) error {
+	if !config.DebugTokenPage || config.IKnowThisIsUnsafe || isLocalhost(config.Host) {
+		return nil
+	}
+	return errDebugTokenPageUnsafe
+}
+
+func isLocalhost(host string) bool {
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// writeDebugTokenPage renders the claims of the issued token and the cookie attributes
+// with a link to continue to the success url.
+func writeDebugTokenPage(w http.ResponseWriter, userInfo model.UserInfo, cookie *http.Cookie, successURL string) {
+	claims, err := json.MarshalIndent(userInfo, "", "  ")
+	if err != nil {
+		logging.Logger.WithError(err).Error()
+		respondInternalError(w)
+		return
+	}
+
+	t := template.Must(template.New("debugTokenPage").Funcs(templateFuncs).Parse(partials))
+	t = template.Must(t.Parse(debugTokenPage))
+
+	nonce, err := setContentSecurityPolicy(w, defaultContentSecurityPolicy)
+	if err != nil {
+		logging.Logger.WithError(err).Error()
+		respondInternalError(w)
+		return
+	}
+
+	b := bytes.NewBuffer(nil)
+	err = t.Execute(b, debugTokenPageData{
+		Claims:     string(claims),
+		Cookie:     cookie,
+		SuccessURL: successURL,
+		CSPNonce:   nonce,
+	})
+	if err != nil {
+		logging.Logger.WithError(err).Error()
+		respondInternalError(w)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Content-Type", contentTypeHTML)
+	w.Write(b.Bytes())
+}
@@ -0,0 +1,54 @@
+package login
+
+import (
+	. "github.com/stretchr/testify/assert"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestDebugTokenPage_OnlyOnLocalhost(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Backends = Options{"simple": {"bob": "secret"}}
+
+	cfg.DebugTokenPage = true
+	for _, host := range []string{"localhost", "127.0.0.1", "::1"} {
+		cfg.Host = host
+		_, err := NewHandler(cfg)
+		NoError(t, err, host)
+	}
+
+	for _, host := range []string{"", "0.0.0.0", "example.com"} {
+		cfg.Host = host
+		_, err := NewHandler(cfg)
+		Equal(t, errDebugTokenPageUnsafe, err, host)
+	}
+
+	cfg.IKnowThisIsUnsafe = true
+	_, err := NewHandler(cfg)
+	NoError(t, err)
+
+	False(t, DefaultConfig().DebugTokenPage)
+}
+
+func TestDebugTokenPage_HTMLLogin(t *testing.T) {
+	h := testHandler()
+	h.config.DebugTokenPage = true
+
+	recorder := httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("POST", "/context/login", "username=bob&password=secret", TypeForm, AcceptHTML))
+	Equal(t, 200, recorder.Code)
+	Equal(t, "", recorder.Header().Get("Location"))
+	Equal(t, "no-store", recorder.Header().Get("Cache-Control"))
+	Equal(t, 1, len(readSetCookies(recorder.Header())))
+
+	body := recorder.Body.String()
+	Contains(t, body, `&#34;sub&#34;: &#34;bob&#34;`)
+	Contains(t, body, `<tr><th>Domain</th><td>example.com</td></tr>`)
+	Contains(t, body, `href="/"`)
+
+	// api logins are not affected
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("POST", "/context/login", "username=bob&password=secret", TypeForm, AcceptJwt))
+	Equal(t, 200, recorder.Code)
+	Equal(t, contentTypeJWT, recorder.Header().Get("Content-Type"))
+}
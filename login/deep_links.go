@@ -0,0 +1,171 @@
+package login
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+
+	"github.com/tarent/loginsrv/logging"
+	"github.com/tarent/loginsrv/oauth2"
+)
+
+// backToParameter is the query parameter of a deep link with the target to return to after the login
+const backToParameter = "backTo"
+
+var errAPIInvalidBackTo = apiError{400, "invalid_back_to", "Bad Request: The backTo target is not allowed"}
+
+type backToKey struct{}
+
+// providerLink is the deep link into the flow of an oauth provider
+type providerLink struct {
+	Name string `json:"name"`
+	URL  string `json:"url"`
+}
+
+type providerLinks struct {
+	Providers []providerLink `json:"providers"`
+}
+
+// checkRedirectURLs validates the success and logout url.
+// An empty success url would redirect the browser back to the login form after each login,
+// so it defaults to "/". Absolute urls have to be http(s) urls with a host.
+func checkRedirectURLs(config *Config) error {
+	if strings.TrimSpace(config.SuccessURL) == "" {
+		logging.Logger.Warn("no success url configured, redirecting to / after the login")
+		config.SuccessURL = "/"
+	}
+	hosts := configuredRedirectHosts(config)
+	if err := checkRedirectURL(config.SuccessURL, hosts); err != nil {
+		return fmt.Errorf("Invalid success url %q: %v", config.SuccessURL, err)
+	}
+	if config.LogoutURL == "" {
+		return nil
+	}
+	if err := checkRedirectURL(config.LogoutURL, hosts); err != nil {
+		return fmt.Errorf("Invalid logout url %q: %v", config.LogoutURL, err)
+	}
+	return nil
+}
+
+func checkRedirectURL(target string, allowedHosts []string) error {
+	_, err := redirectTarget(target, allowedHosts)
+	return err
+}
+
+// parseRedirectWhitelist returns the lower case hosts of the comma separated list
+func parseRedirectWhitelist(list string) []string {
+	hosts := []string{}
+	for _, host := range strings.Split(list, ",") {
+		if host = strings.ToLower(strings.TrimSpace(host)); host != "" {
+			hosts = append(hosts, host)
+		}
+	}
+	return hosts
+}
+
+// allowedBackTo checks the target to return to after the login.
+// Relative paths are always allowed, absolute http(s) urls only for the hosts of the redirect whitelist.
+// The target has to be a valid redirect target, see redirectTarget.
+func (h *Handler) allowedBackTo(target string) bool {
+	if _, err := redirectTarget(target, h.redirectWhitelist); err != nil {
+		return false
+	}
+	u, _ := url.Parse(target)
+	return u.Scheme != "" || strings.HasPrefix(target, "/")
+}
+
+// withBackTo carries the backTo target of a started oauth flow through its state parameter.
+// It returns false, if the target is not allowed.
+func (h *Handler) withBackTo(r *http.Request) (*http.Request, bool) {
+	if r.FormValue("code") != "" {
+		// the callback uses the target of the state only
+		return r, true
+	}
+	backTo := r.URL.Query().Get(backToParameter)
+	if backTo == "" {
+		return r, true
+	}
+	if !h.allowedBackTo(backTo) {
+		return r, false
+	}
+	return r.WithContext(oauth2.WithStatePayload(r.Context(), backTo)), true
+}
+
+// withVerifiedBackTo takes the backTo target from the state of a successful oauth callback.
+// The target is checked again, because the whitelist may have changed during the flow.
+func (h *Handler) withVerifiedBackTo(r *http.Request) *http.Request {
+	backTo := oauth2.StatePayload(r)
+	if !h.allowedBackTo(backTo) {
+		return r
+	}
+	return r.WithContext(context.WithValue(r.Context(), backToKey{}, backTo))
+}
+
+// successURL is the url to redirect to after the login
+func (h *Handler) successURL(r *http.Request) string {
+	if backTo, ok := r.Context().Value(backToKey{}).(string); ok {
+		return backTo
+	}
+	return h.config.SuccessURL
+}
+
+func (h *Handler) isProvidersPath(r *http.Request) bool {
+	return r.URL.Path == path.Join(h.config.LoginPath, "providers")
+}
+
+// isUnknownSubPath returns true for paths below the login path,
+// which are no provider, e.g. /login/unknown-provider
+func (h *Handler) isUnknownSubPath(r *http.Request) bool {
+	return path.Clean(r.URL.Path) != h.config.LoginPath
+}
+
+// respondProviders lists the configured oauth providers with their deep links.
+// An optional backTo parameter is validated and added to the links.
+func (h *Handler) respondProviders(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		h.respondBadRequest(w, r)
+		return
+	}
+	backTo := r.URL.Query().Get(backToParameter)
+	if backTo != "" && !h.allowedBackTo(backTo) {
+		h.respondAPIError(w, r, errAPIInvalidBackTo)
+		return
+	}
+
+	links := providerLinks{Providers: []providerLink{}}
+	for _, name := range sortedOptionNames(h.config.Oauth) {
+		if h.providerDisabled(name) {
+			continue
+		}
+		u := requestBaseURL(r)
+		u.Path = path.Join(h.config.LoginPath, name)
+		if backTo != "" {
+			u.RawQuery = url.Values{backToParameter: {backTo}}.Encode()
+		}
+		links.Providers = append(links.Providers, providerLink{Name: name, URL: u.String()})
+	}
+
+	w.Header().Set("Content-Type", contentTypeJSON)
+	w.WriteHeader(200)
+	json.NewEncoder(w).Encode(links)
+}
+
+// requestBaseURL returns the scheme and host, the request was sent to.
+// Like for the oauth redirect uri, the X-Forwarded-Host and X-Forwarded-Proto headers are taken into account.
+func requestBaseURL(r *http.Request) url.URL {
+	u := url.URL{Scheme: "http", Host: r.Host}
+	if r.TLS != nil {
+		u.Scheme = "https"
+	}
+	if ffh := r.Header.Get("X-Forwarded-Host"); ffh != "" {
+		u.Host = ffh
+	}
+	if ffp := r.Header.Get("X-Forwarded-Proto"); ffp != "" {
+		u.Scheme = ffp
+	}
+	return u
+}
@@ -0,0 +1,228 @@
+package login
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+
+	. "github.com/stretchr/testify/assert"
+	"github.com/tarent/loginsrv/model"
+	"github.com/tarent/loginsrv/oauth2"
+)
+
+func deepLinkTestHandler(t *testing.T) *Handler {
+	cfg := DefaultConfig()
+	cfg.Oauth = Options{
+		"github": {"client_id": "id", "client_secret": "secret"},
+		"google": {"client_id": "id", "client_secret": "secret"},
+	}
+	cfg.RedirectWhitelist = "app.example.com, Other.Example.com:8443"
+	h, err := NewHandler(cfg)
+	NoError(t, err)
+	return h
+}
+
+func TestHandler_AllowedBackTo(t *testing.T) {
+	h := deepLinkTestHandler(t)
+	tests := []struct {
+		target  string
+		allowed bool
+	}{
+		{"/app/dashboard", true},
+		{"/app/dashboard?tab=1#top", true},
+		{"https://app.example.com/dashboard", true},
+		{"http://APP.example.com:8080/", true},
+		{"https://other.example.com:8443/", true},
+		{"", false},
+		{"app/dashboard", false},
+		{"//evil.example.org/", false},
+		{"/\\evil.example.org/", false},
+		{"https://evil.example.org/", false},
+		{"https://other.example.com/", false},
+		{"javascript:alert(1)", false},
+		{"ftp://app.example.com/", false},
+		{"/app\r\nSet-Cookie: x=y", false},
+		{"https://app.example.com@evil.example.org/", false},
+		{"https://evil.example.org\\@app.example.com/", false},
+		{"https://app。example.com/", false},
+		{"/\tevil.example.org", false},
+		{"///evil.example.org/", false},
+	}
+	for _, test := range tests {
+		Equal(t, test.allowed, h.allowedBackTo(test.target), test.target)
+	}
+}
+
+func TestHandler_DeepLink_StartsFlow(t *testing.T) {
+	h := deepLinkTestHandler(t)
+
+	recorder := httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("GET", "/login/github?backTo="+url.QueryEscape("/app/dashboard"), ""))
+	Equal(t, 302, recorder.Code)
+
+	location, err := url.Parse(recorder.Header().Get("Location"))
+	NoError(t, err)
+	Equal(t, "github.com", location.Host)
+
+	// the target is carried through the state
+	callback, _ := http.NewRequest("GET", "/login/github?code=c&state="+url.QueryEscape(location.Query().Get("state")), nil)
+	Equal(t, "/app/dashboard", oauth2.StatePayload(callback))
+}
+
+func TestHandler_DeepLink_InvalidBackTo(t *testing.T) {
+	h := deepLinkTestHandler(t)
+
+	recorder := httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("GET", "/login/github?backTo="+url.QueryEscape("https://evil.example.org/"), ""))
+	Equal(t, 400, recorder.Code)
+	Empty(t, recorder.Header().Get("Location"))
+}
+
+func TestHandler_DeepLink_UnknownProvider(t *testing.T) {
+	h := deepLinkTestHandler(t)
+
+	for _, p := range []string{"/login/unknown", "/login/github/foo/bar/unknown"} {
+		recorder := httptest.NewRecorder()
+		h.ServeHTTP(recorder, req("GET", p, "", AcceptHTML))
+		Equal(t, 404, recorder.Code, p)
+	}
+
+	// the login form is still served
+	recorder := httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("GET", "/login/", "", AcceptHTML))
+	Equal(t, 200, recorder.Code)
+}
+
+func TestHandler_DeepLink_Callback(t *testing.T) {
+	managerMock := &oauth2ManagerMock{
+		_GetConfigFromRequest: func(r *http.Request) (oauth2.Config, error) {
+			return oauth2.Config{}, nil
+		},
+		_Handle: func(w http.ResponseWriter, r *http.Request) (bool, bool, model.UserInfo, error) {
+			return false, true, model.UserInfo{Sub: "marvin"}, nil
+		},
+	}
+	cfg := DefaultConfig()
+	cfg.RedirectWhitelist = "app.example.com"
+	h := &Handler{
+		handlerRuntime:    newHandlerRuntime(),
+		oauth:             managerMock,
+		config:            cfg,
+		redirectWhitelist: parseRedirectWhitelist(cfg.RedirectWhitelist),
+	}
+
+	tests := []struct {
+		backTo   string
+		location string
+	}{
+		{"https://app.example.com/dashboard", "https://app.example.com/dashboard"},
+		{"/app", "/app"},
+		// a forged state falls back to the success url
+		{"https://evil.example.org/", "/"},
+		{"", "/"},
+	}
+	for i, test := range tests {
+		// every callback has its own flow, a used state is rejected
+		state := "abc" + strconv.Itoa(i)
+		if test.backTo != "" {
+			state += "." + base64.RawURLEncoding.EncodeToString([]byte(test.backTo))
+		}
+		recorder := httptest.NewRecorder()
+		h.ServeHTTP(recorder, req("GET", "/login/github?code=xyz&state="+url.QueryEscape(state), "", AcceptHTML))
+		Equal(t, 303, recorder.Code)
+		Equal(t, test.location, recorder.Header().Get("Location"), test.backTo)
+	}
+
+	// a backTo parameter on the callback itself is ignored
+	recorder := httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("GET", "/login/github?code=xyz&state=def&backTo=/app", "", AcceptHTML))
+	Equal(t, "/", recorder.Header().Get("Location"))
+}
+
+func TestHandler_Providers(t *testing.T) {
+	h := deepLinkTestHandler(t)
+
+	recorder := httptest.NewRecorder()
+	r := req("GET", "/login/providers?backTo="+url.QueryEscape("/app"), "")
+	r.Host = "login.example.com"
+	r.Header.Set("X-Forwarded-Proto", "https")
+	h.ServeHTTP(recorder, r)
+	Equal(t, 200, recorder.Code)
+	Equal(t, contentTypeJSON, recorder.Header().Get("Content-Type"))
+
+	var links providerLinks
+	NoError(t, json.Unmarshal(recorder.Body.Bytes(), &links))
+	Equal(t, []providerLink{
+		{Name: "github", URL: "https://login.example.com/login/github?backTo=%2Fapp"},
+		{Name: "google", URL: "https://login.example.com/login/google?backTo=%2Fapp"},
+	}, links.Providers)
+
+	// without backTo
+	recorder = httptest.NewRecorder()
+	r = req("GET", "/login/providers", "")
+	r.Host = "localhost:6789"
+	h.ServeHTTP(recorder, r)
+	Contains(t, recorder.Body.String(), `"url":"http://localhost:6789/login/github"`)
+
+	// an invalid backTo
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("GET", "/login/providers?backTo=https://evil.example.org/", ""))
+	Equal(t, 400, recorder.Code)
+}
+
+func TestHandler_DeepLink_NoBackToForPasswordLogin(t *testing.T) {
+	h := testHandler()
+	h.redirectWhitelist = []string{"app.example.com"}
+
+	// the state of a form post is not verified and must not be used
+	state := "abc." + base64.RawURLEncoding.EncodeToString([]byte("/app"))
+	recorder := httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("POST", "/context/login", "username=bob&password=secret&state="+url.QueryEscape(state), TypeForm, AcceptHTML))
+	Equal(t, 303, recorder.Code)
+	Equal(t, "/", recorder.Header().Get("Location"))
+}
+
+func TestHandler_EmptySuccessURL(t *testing.T) {
+	// without a success url, the browser was redirected back to the login form after each login
+	cfg := DefaultConfig()
+	cfg.Backends = Options{"simple": {"bob": "secret"}}
+	cfg.SuccessURL = ""
+	h, err := NewHandler(cfg)
+	NoError(t, err)
+	Equal(t, "/", h.config.SuccessURL)
+
+	recorder := httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("POST", "/login", "username=bob&password=secret", TypeForm, AcceptHTML))
+	Equal(t, 303, recorder.Code)
+	Equal(t, "/", recorder.Header().Get("Location"))
+}
+
+func TestHandler_InvalidRedirectURLs(t *testing.T) {
+	tests := []struct {
+		successURL string
+		logoutURL  string
+		valid      bool
+	}{
+		{"/", "", true},
+		{"/app", "/logged-out", true},
+		{"https://app.example.com/", "http://app.example.com/bye", true},
+		{"successurl", "", true},
+		{"javascript:alert(1)", "", false},
+		{"https://", "", false},
+		{"http://app.example.com/%zz", "", false},
+		{"/", "ftp://app.example.com/", false},
+		{"/", "https:///bye", false},
+	}
+	for _, test := range tests {
+		cfg := DefaultConfig()
+		cfg.Backends = Options{"simple": {"bob": "secret"}}
+		cfg.SuccessURL = test.successURL
+		cfg.LogoutURL = test.logoutURL
+		_, err := NewHandler(cfg)
+		Equal(t, test.valid, err == nil, "%v %v: %v", test.successURL, test.logoutURL, err)
+	}
+}
@@ -0,0 +1,308 @@
+package login
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/tarent/loginsrv/logging"
+)
+
+// the queue size of async subscribers, which don't set one
+const defaultEventQueueSize = 1000
+
+// EventType is the kind of outcome of a request to the login handler
+type EventType string
+
+// The outcomes of the login handler
+const (
+	EventLoginSucceeded EventType = "login_succeeded"
+	EventLoginFailed    EventType = "login_failed"
+	EventRefreshed      EventType = "refreshed"
+	EventLoggedOut      EventType = "logged_out"
+	EventIPDenied       EventType = "ip_denied"
+
+	// The outcomes of the client_credentials grant, the username is the client id
+	EventClientTokenIssued EventType = "client_token_issued"
+	EventClientAuthFailed  EventType = "client_auth_failed"
+	EventClientRateLimited EventType = "client_rate_limited"
+
+	// A login with a break-glass account, emitted in addition to EventLoginSucceeded
+	EventBreakGlassLogin EventType = "break_glass_login"
+
+	// Successful authentications rejected by the risk assessor, with the reason
+	EventLoginStepUp EventType = "login_step_up"
+	EventLoginDenied EventType = "login_denied"
+
+	// A successful authentication or a refresh of a user, who is not permitted by the allow or deny list of the backend, with the reason
+	EventLoginNotPermitted EventType = "login_not_permitted"
+
+	// A login request aborted by the -max-request-duration, the reason is the phase in flight, e.g. backend:ldap
+	EventRequestTimedOut EventType = "request_timed_out"
+
+	// A front-channel logout of the oauth provider, the reason is the issuer of the session
+	EventIdPLoggedOut EventType = "idp_logged_out"
+
+	// A second use of the state of an oauth callback, e.g. a replay of a leaked callback url, the origin is the provider
+	EventOauthCallbackReplayed EventType = "oauth_callback_replayed"
+
+	// An oauth provider or backend disabled or enabled by the admin api, the username is the admin and the reason is the provider
+	EventProviderDisabled EventType = "provider_disabled"
+	EventProviderEnabled  EventType = "provider_enabled"
+
+	// The sessions of a user listed or revoked by the admin api, the username is the admin
+	// and the reason is the sub of the user, followed by the jti of a revoked session
+	EventSessionsListed EventType = "sessions_listed"
+	EventSessionRevoked EventType = "session_revoked"
+)
+
+// Event is emitted once per outcome of a request to the login handler.
+// Duplicate login submissions sharing the result of one attempt emit no own events.
+type Event struct {
+	Type EventType
+	Time time.Time
+
+	// Username is the submitted username or the subject of the token
+	Username string
+
+	// Origin is the backend or oauth provider of the user, if known
+	Origin string
+
+	// ClientIP is the ip of the client, resolved over the trusted proxies
+	ClientIP string
+
+	// Reason of the outcome, e.g. of a risk decision
+	Reason string
+
+	// Header of the request, e.g. for the correlation ids.
+	// It must not be modified.
+	Header http.Header
+}
+
+// Subscriber consumes the event stream of the handler.
+type Subscriber struct {
+	Name string
+
+	// Async subscribers receive the events over a queue in their own goroutine,
+	// which is run by the hooks of the handler. If the queue is full, events are dropped and counted.
+	// Sync subscribers are called in the request goroutine and delay the response.
+	Async bool
+
+	// QueueSize of an async subscriber, defaultEventQueueSize if not set
+	QueueSize int
+
+	Handle func(Event)
+}
+
+type subscription struct {
+	Subscriber
+	queue   chan Event
+	dropped int64
+}
+
+// eventBus delivers the events to all subscribers
+type eventBus struct {
+	mu            sync.RWMutex
+	subscriptions []*subscription
+
+	stop chan struct{}
+	done sync.WaitGroup
+}
+
+func (b *eventBus) subscribe(s Subscriber) {
+	sub := &subscription{Subscriber: s}
+	if s.Async {
+		size := s.QueueSize
+		if size <= 0 {
+			size = defaultEventQueueSize
+		}
+		sub.queue = make(chan Event, size)
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.subscriptions = append(b.subscriptions, sub)
+}
+
+// emit delivers the event to the sync subscribers and enqueues it for the async ones
+func (b *eventBus) emit(e Event) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	for _, sub := range b.subscriptions {
+		if !sub.Async {
+			sub.Handle(e)
+			continue
+		}
+		select {
+		case sub.queue <- e:
+		default:
+			atomic.AddInt64(&sub.dropped, 1)
+		}
+	}
+}
+
+// dropped returns the number of dropped events of the async subscribers, which dropped any
+func (b *eventBus) dropped() map[string]int64 {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	var result map[string]int64
+	for _, sub := range b.subscriptions {
+		if n := atomic.LoadInt64(&sub.dropped); n > 0 {
+			if result == nil {
+				result = map[string]int64{}
+			}
+			result[sub.Name] = n
+		}
+	}
+	return result
+}
+
+func (b *eventBus) hasAsyncSubscribers() bool {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	for _, sub := range b.subscriptions {
+		if sub.Async {
+			return true
+		}
+	}
+	return false
+}
+
+// hook runs the delivery to the async subscribers.
+// On stop, the queued events are delivered before returning.
+func (b *eventBus) hook() Hook {
+	return Hook{Name: "event-delivery", Start: b.start, Stop: b.shutdown}
+}
+
+func (b *eventBus) start() error {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	b.stop = make(chan struct{})
+	for _, sub := range b.subscriptions {
+		if sub.Async {
+			b.done.Add(1)
+			go b.deliver(sub)
+		}
+	}
+	return nil
+}
+
+func (b *eventBus) deliver(sub *subscription) {
+	defer b.done.Done()
+	for {
+		select {
+		case e := <-sub.queue:
+			sub.Handle(e)
+		case <-b.stop:
+			for {
+				select {
+				case e := <-sub.queue:
+					sub.Handle(e)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+func (b *eventBus) shutdown(ctx context.Context) error {
+	close(b.stop)
+	done := make(chan struct{})
+	go func() {
+		b.done.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Subscribe adds a consumer of the event stream.
+// Async subscribers have to be added before the Hooks of the handler are taken.
+func (h *Handler) Subscribe(s Subscriber) {
+	h.eventStream().subscribe(s)
+}
+
+// newEventBus returns an event bus with the audit log as first subscriber
+func newEventBus() *eventBus {
+	b := &eventBus{}
+	b.subscribe(Subscriber{Name: "audit-log", Handle: auditLog})
+	return b
+}
+
+func (h *Handler) eventStream() *eventBus {
+	return h.events
+}
+
+func (h *Handler) emit(r *http.Request, eventType EventType, username, origin string) {
+	h.eventStream().emit(Event{
+		Type:     eventType,
+		Time:     time.Now(),
+		Username: username,
+		Origin:   origin,
+		ClientIP: clientIP(r, h.trustedProxies),
+		Header:   r.Header,
+	})
+}
+
+// emitWithReason emits the outcome with its reason, e.g. of a risk decision
+func (h *Handler) emitWithReason(r *http.Request, eventType EventType, username, origin, reason string) {
+	h.eventStream().emit(Event{
+		Type:     eventType,
+		Time:     time.Now(),
+		Username: username,
+		Origin:   origin,
+		ClientIP: clientIP(r, h.trustedProxies),
+		Reason:   reason,
+		Header:   r.Header,
+	})
+}
+
+// auditLog writes the application log entries for the outcomes
+func auditLog(e Event) {
+	entry := logging.Application(e.Header).WithField("username", e.Username)
+	switch e.Type {
+	case EventLoginSucceeded:
+		entry.Info("successfully authenticated")
+	case EventLoginFailed:
+		entry.Info("failed authentication")
+	case EventRefreshed:
+		entry.Info("refreshed jwt")
+	case EventIPDenied:
+		logging.Application(e.Header).WithField("client_ip", e.ClientIP).Warn("login denied by the ip filter")
+	case EventClientTokenIssued:
+		entry.WithField("client_ip", e.ClientIP).Info("issued client credentials token")
+	case EventClientAuthFailed:
+		entry.WithField("client_ip", e.ClientIP).Info("failed client authentication")
+	case EventClientRateLimited:
+		entry.WithField("client_ip", e.ClientIP).Warn("client credentials grant rate limited")
+	case EventLoginStepUp:
+		entry.WithField("client_ip", e.ClientIP).WithField("reason", e.Reason).Warn("login requires a step up by the risk assessment")
+	case EventLoginDenied:
+		entry.WithField("client_ip", e.ClientIP).WithField("reason", e.Reason).Warn("login denied by the risk assessment")
+	case EventLoginNotPermitted:
+		entry.WithField("origin", e.Origin).WithField("reason", e.Reason).Warn("user is not permitted by the user lists of the backend")
+	case EventRequestTimedOut:
+		entry.WithField("phase", e.Reason).Warn("login request aborted, the max request duration is exceeded")
+	case EventIdPLoggedOut:
+		entry.WithField("issuer", e.Reason).Info("session ended by a front-channel logout of the oauth provider")
+	case EventOauthCallbackReplayed:
+		logging.Application(e.Header).WithField("origin", e.Origin).WithField("client_ip", e.ClientIP).Warn("rejected the replay of an oauth callback")
+	case EventProviderDisabled:
+		entry.WithField("provider", e.Reason).Warn("provider disabled by the admin api")
+	case EventProviderEnabled:
+		entry.WithField("provider", e.Reason).Info("provider enabled by the admin api")
+	case EventSessionsListed:
+		entry.WithField("sub", e.Reason).Info("sessions listed by the admin api")
+	case EventSessionRevoked:
+		entry.WithField("session", e.Reason).Warn("session revoked by the admin api")
+	case EventBreakGlassLogin:
+		entry.WithField("client_ip", e.ClientIP).
+			Error("!!! BREAK-GLASS LOGIN: every login backend failed, logged in with a local emergency account !!!")
+	}
+}
@@ -0,0 +1,123 @@
+package login
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	. "github.com/stretchr/testify/assert"
+	"github.com/tarent/loginsrv/logging"
+	"github.com/tarent/loginsrv/model"
+)
+
+// eventRecorder is a subscriber, which records all events
+type eventRecorder struct {
+	mu     sync.Mutex
+	events []Event
+}
+
+func (rec *eventRecorder) handle(e Event) {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	rec.events = append(rec.events, e)
+}
+
+func (rec *eventRecorder) types() []EventType {
+	rec.mu.Lock()
+	defer rec.mu.Unlock()
+	types := []EventType{}
+	for _, e := range rec.events {
+		types = append(types, e.Type)
+	}
+	return types
+}
+
+func TestHandler_Events(t *testing.T) {
+	h := testHandler()
+	h.config.JwtRefreshes = 1
+	rec := &eventRecorder{}
+	h.Subscribe(Subscriber{Name: "test", Handle: rec.handle})
+
+	serve := func(r *httptest.ResponseRecorder, method, body string, header ...string) *httptest.ResponseRecorder {
+		h.ServeHTTP(r, req(method, "/context/login", body, header...))
+		return r
+	}
+
+	Equal(t, 200, serve(httptest.NewRecorder(), "POST", `{"username": "bob", "password": "secret"}`, TypeJSON, AcceptJwt).Code)
+	Equal(t, 403, serve(httptest.NewRecorder(), "POST", `{"username": "bob", "password": "wrong"}`, TypeJSON, AcceptJwt).Code)
+
+	token, err := h.createToken(model.UserInfo{Sub: "bob", Origin: "simple", Expiry: time.Now().Add(time.Minute).Unix()})
+	NoError(t, err)
+	Equal(t, 303, serve(httptest.NewRecorder(), "POST", "", AcceptHTML, "Cookie: "+h.config.CookieName+"="+token+";").Code)
+	serve(httptest.NewRecorder(), "DELETE", "", "Cookie: "+h.config.CookieName+"="+token+";")
+
+	Equal(t, []EventType{EventLoginSucceeded, EventLoginFailed, EventRefreshed, EventLoggedOut}, rec.types())
+	for _, e := range rec.events {
+		Equal(t, "bob", e.Username)
+		False(t, e.Time.IsZero())
+		NotNil(t, e.Header)
+	}
+	Equal(t, "simple", rec.events[2].Origin)
+}
+
+func TestHandler_Events_AuditLog(t *testing.T) {
+	b := bytes.NewBuffer(nil)
+	logging.Logger.Out = b
+	defer logging.Set("info", false)
+
+	call(req("POST", "/context/login", `{"username": "bob", "password": "secret"}`, TypeJSON, AcceptJwt))
+	Contains(t, b.String(), `"message":"successfully authenticated"`)
+	Contains(t, b.String(), `"username":"bob"`)
+
+	b.Reset()
+	call(req("POST", "/context/login", `{"username": "bob", "password": "wrong"}`, TypeJSON, AcceptJwt))
+	Contains(t, b.String(), `"message":"failed authentication"`)
+}
+
+func TestHandler_Events_Async(t *testing.T) {
+	h := testHandler()
+	rec := &eventRecorder{}
+	h.Subscribe(Subscriber{Name: "async", Async: true, Handle: rec.handle})
+
+	hooks := h.Hooks()
+	Equal(t, []string{"store-sweeper", "event-delivery"}, []string{hooks[0].Name, hooks[1].Name})
+	NoError(t, hooks[1].Start())
+
+	// different submissions, which are not deduplicated
+	for i := 0; i < 3; i++ {
+		h.ServeHTTP(httptest.NewRecorder(), req("POST", "/context/login", fmt.Sprintf(`{"username": "bob", "password": "wrong%v"}`, i), TypeJSON, AcceptJwt))
+	}
+
+	// all queued events are delivered on stop
+	NoError(t, hooks[1].Stop(context.Background()))
+	Equal(t, []EventType{EventLoginFailed, EventLoginFailed, EventLoginFailed}, rec.types())
+	Nil(t, h.eventStream().dropped())
+}
+
+func TestHandler_Events_Dropped(t *testing.T) {
+	h := testHandler()
+	h.Subscribe(Subscriber{Name: "slow", Async: true, QueueSize: 1, Handle: func(Event) {}})
+
+	// no delivery is running, so the queue runs full
+	// different submissions, which are not deduplicated
+	for i := 0; i < 3; i++ {
+		h.ServeHTTP(httptest.NewRecorder(), req("POST", "/context/login", fmt.Sprintf(`{"username": "bob", "password": "wrong%v"}`, i), TypeJSON, AcceptJwt))
+	}
+	Equal(t, map[string]int64{"slow": 2}, h.eventStream().dropped())
+
+	recorder := httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("GET", "/context/login/health", ""))
+	Contains(t, recorder.Body.String(), `"events_dropped":{"slow":2}`)
+}
+
+func TestHandler_Events_NoAsyncHook(t *testing.T) {
+	h := testHandler()
+	h.Subscribe(Subscriber{Name: "sync", Handle: func(Event) {}})
+	for _, hook := range h.Hooks() {
+		NotEqual(t, "event-delivery", hook.Name)
+	}
+}
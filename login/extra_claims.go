@@ -0,0 +1,85 @@
+package login
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// extraClaims are the static claims of the -jwt-claims, which are stamped into every token,
+// e.g. the tenant or the environment of the deployment. The values are kept as json.
+type extraClaims map[string]json.RawMessage
+
+var jsonNumber = regexp.MustCompile(`^-?(0|[1-9][0-9]*)(\.[0-9]+)?([eE][-+]?[0-9]+)?$`)
+
+// parseExtraClaims reads the -jwt-claims, e.g. tenant=acme,env=prod,level=3.
+// The values true and false are booleans and numbers are numbers, values in double quotes are always strings.
+// The claims of the user info and their names in the claim map are reserved,
+// so that the static claims can't override the claims of the backends.
+func parseExtraClaims(list string, m claimMap) (extraClaims, error) {
+	if strings.TrimSpace(list) == "" {
+		return nil, nil
+	}
+	reserved := reservedClaims(m)
+	claims := extraClaims{}
+	for _, entry := range strings.Split(list, ",") {
+		pair := strings.SplitN(entry, "=", 2)
+		if len(pair) != 2 || strings.TrimSpace(pair[0]) == "" {
+			return nil, fmt.Errorf("Invalid jwt claims entry %q, it has to be name=value", entry)
+		}
+		name := strings.TrimSpace(pair[0])
+		if reserved[name] {
+			return nil, fmt.Errorf("The claim %q of the jwt claims is reserved", name)
+		}
+		if _, set := claims[name]; set {
+			return nil, fmt.Errorf("The claim %q is set twice in the jwt claims", name)
+		}
+		value, err := json.Marshal(inferClaimValue(strings.TrimSpace(pair[1])))
+		if err != nil {
+			return nil, err
+		}
+		claims[name] = value
+	}
+	return claims, nil
+}
+
+// reservedClaims are the names of the claims of the user info in the tokens,
+// which can't be set by static or user claims
+func reservedClaims(m claimMap) map[string]bool {
+	reserved := userInfoClaims()
+	for _, name := range m {
+		reserved[name] = true
+	}
+	return reserved
+}
+
+// merge returns the claims with the other claims, which win on collisions
+func (c extraClaims) merge(other extraClaims) extraClaims {
+	if len(other) == 0 {
+		return c
+	}
+	merged := make(extraClaims, len(c)+len(other))
+	for name, value := range c {
+		merged[name] = value
+	}
+	for name, value := range other {
+		merged[name] = value
+	}
+	return merged
+}
+
+// inferClaimValue returns the booleans and numbers of the values as such
+func inferClaimValue(value string) interface{} {
+	switch {
+	case value == "true":
+		return true
+	case value == "false":
+		return false
+	case jsonNumber.MatchString(value):
+		return json.Number(value)
+	case len(value) >= 2 && strings.HasPrefix(value, `"`) && strings.HasSuffix(value, `"`):
+		return value[1 : len(value)-1]
+	}
+	return value
+}
@@ -0,0 +1,100 @@
+package login
+
+import (
+	"encoding/json"
+	"testing"
+
+	. "github.com/stretchr/testify/assert"
+)
+
+func TestParseExtraClaims(t *testing.T) {
+	claims, err := parseExtraClaims("", nil)
+	NoError(t, err)
+	Nil(t, claims)
+
+	claims, err = parseExtraClaims(`tenant=acme, env=prod,level=3,ratio=-0.5,beta=true,legacy=false,zip="01234",version="2",empty=`, nil)
+	NoError(t, err)
+	Equal(t, extraClaims{
+		"tenant":  json.RawMessage(`"acme"`),
+		"env":     json.RawMessage(`"prod"`),
+		"level":   json.RawMessage(`3`),
+		"ratio":   json.RawMessage(`-0.5`),
+		"beta":    json.RawMessage(`true`),
+		"legacy":  json.RawMessage(`false`),
+		"zip":     json.RawMessage(`"01234"`),
+		"version": json.RawMessage(`"2"`),
+		"empty":   json.RawMessage(`""`),
+	}, claims)
+
+	// no json numbers
+	claims, err = parseExtraClaims("a=01234,b=0x10,c=NaN,d=1.", nil)
+	NoError(t, err)
+	Equal(t, extraClaims{
+		"a": json.RawMessage(`"01234"`),
+		"b": json.RawMessage(`"0x10"`),
+		"c": json.RawMessage(`"NaN"`),
+		"d": json.RawMessage(`"1."`),
+	}, claims)
+
+	for list, message := range map[string]string{
+		"tenant":            `Invalid jwt claims entry "tenant", it has to be name=value`,
+		"=acme":             `Invalid jwt claims entry "=acme", it has to be name=value`,
+		"exp=0":             `The claim "exp" of the jwt claims is reserved`,
+		"sub=admin":         `The claim "sub" of the jwt claims is reserved`,
+		"groups=admins":     `The claim "groups" of the jwt claims is reserved`,
+		"env=prod,env=test": `The claim "env" is set twice in the jwt claims`,
+	} {
+		_, err := parseExtraClaims(list, nil)
+		EqualError(t, err, message, list)
+	}
+
+	// the names of the claim map are reserved as well
+	_, err = parseExtraClaims("preferred_username=admin", claimMap{"sub": "preferred_username"})
+	EqualError(t, err, `The claim "preferred_username" of the jwt claims is reserved`)
+}
+
+func TestHandler_ExtraClaims(t *testing.T) {
+	cfg := testConfig()
+	cfg.Backends = Options{"simple": {"bob": "secret"}}
+	cfg.JwtClaims = "tenant=acme,env=prod,level=3,beta=true"
+	h, err := NewHandler(cfg)
+	NoError(t, err)
+
+	recorder := callHandler(h, req("POST", "/context/login", "username=bob&password=secret", TypeForm, AcceptJwt))
+	Equal(t, 200, recorder.Code)
+	token := recorder.Body.String()
+	claims, err := tokenAsMap(token)
+	NoError(t, err)
+	Equal(t, "bob", claims["sub"])
+	Equal(t, "acme", claims["tenant"])
+	Equal(t, "prod", claims["env"])
+	Equal(t, float64(3), claims["level"])
+	Equal(t, true, claims["beta"])
+
+	userInfo, valid := h.GetToken(req("GET", "/context/login", ""), token)
+	True(t, valid)
+	Equal(t, "bob", userInfo.Sub)
+
+	// the refreshed tokens get the claims, too
+	recorder = callHandler(h, req("POST", "/context/login", "", AcceptJwt, "Authorization: Bearer "+token))
+	Equal(t, 200, recorder.Code)
+	claims, err = tokenAsMap(recorder.Body.String())
+	NoError(t, err)
+	Equal(t, "acme", claims["tenant"])
+	Equal(t, float64(1), claims["refs"])
+
+	// with a claim map
+	cfg.JwtClaimMap = "sub:preferred_username"
+	h, err = NewHandler(cfg)
+	NoError(t, err)
+	claims, err = tokenAsMap(callHandler(h, req("POST", "/context/login", "username=bob&password=secret", TypeForm, AcceptJwt)).Body.String())
+	NoError(t, err)
+	Equal(t, "bob", claims["preferred_username"])
+	Equal(t, "acme", claims["tenant"])
+
+	cfg.JwtClaims = "exp=0"
+	_, err = NewHandler(cfg)
+	EqualError(t, err, `The claim "exp" of the jwt claims is reserved`)
+	_, err = NewTokenService(cfg)
+	EqualError(t, err, `The claim "exp" of the jwt claims is reserved`)
+}
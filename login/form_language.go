@@ -0,0 +1,122 @@
+package login
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// defaultFormLanguage is the language of the texts in the templates and the source of the translations
+const defaultFormLanguage = "en"
+
+// formMessages are the translations of the texts of the login form, keyed by the english text.
+// Texts without a translation, e.g. the maintenance message of the configuration, are shown in english.
+var formMessages = map[string]map[string]string{
+	"de": {
+		"Sign in":                      "Anmelden",
+		"Signed in":                    "Angemeldet",
+		"Sign in with %v":              "Anmelden mit %v",
+		"or":                           "oder",
+		"Sign in failed:":              "Anmeldung fehlgeschlagen:",
+		"Username":                     "Benutzername",
+		"Password":                     "Passwort",
+		"Login":                        "Anmelden",
+		"Logout":                       "Abmelden",
+		"Welcome %v!":                  "Willkommen %v!",
+		"Picture of %v":                "Bild von %v",
+		"Session details":              "Sitzungsdetails",
+		"Name":                         "Name",
+		"Email":                        "E-Mail",
+		"Signed in with":               "Angemeldet mit",
+		"Groups":                       "Gruppen",
+		"Session expires":              "Sitzung endet",
+		"%v (in %v)":                   "%v (in %v)",
+		"less than a minute":           "weniger als einer Minute",
+		"Refreshes used":               "Verlängerungen",
+		"%v of %v":                     "%v von %v",
+		defaultErrorMessage:            "Interner Fehler. Bitte versuchen Sie es später noch einmal.",
+		defaultFailureMessage:          "Der Benutzername oder das Passwort ist nicht korrekt.",
+		providerDisabledMessage:        "Die Anmeldung mit diesem Anbieter ist derzeit nicht möglich. Bitte melden Sie sich auf einem anderen Weg an.",
+		errAPIRequestTimeout.message:   "Die Anmeldung hat zu lange gedauert, bitte versuchen Sie es noch einmal.",
+		errAPIStepUpRequired.message:   "Eine zusätzliche Bestätigung ist erforderlich.",
+		errAPILoginDenied.message:      "Die Anmeldung wurde abgelehnt.",
+		errAPIUserNotPermitted.message: "Der Benutzer darf sich nicht anmelden.",
+	},
+}
+
+// formLanguage selects the language of the login form by the Accept-Language header (RFC 7231).
+// A range matches a language of the catalogue exactly or by its primary subtag, e.g. de-AT matches de.
+// The first supported language by quality is chosen, english if none is supported.
+func formLanguage(r *http.Request) string {
+	if r == nil {
+		return defaultFormLanguage
+	}
+	for _, tag := range parseAcceptLanguage(r.Header.Get("Accept-Language")) {
+		primary := strings.SplitN(tag, "-", 2)[0]
+		if primary == defaultFormLanguage {
+			return defaultFormLanguage
+		}
+		if _, exist := formMessages[primary]; exist {
+			return primary
+		}
+		if tag == "*" {
+			return defaultFormLanguage
+		}
+	}
+	return defaultFormLanguage
+}
+
+// parseAcceptLanguage returns the lower case language ranges of the header ordered by their quality.
+// Ranges with the quality 0 are not acceptable and left out.
+func parseAcceptLanguage(header string) []string {
+	type languageRange struct {
+		tag string
+		q   float64
+	}
+	var ranges []languageRange
+	for _, part := range strings.Split(header, ",") {
+		fields := strings.Split(part, ";")
+		tag := strings.ToLower(strings.TrimSpace(fields[0]))
+		if tag == "" {
+			continue
+		}
+		q := 1.0
+		for _, param := range fields[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				var err error
+				if q, err = strconv.ParseFloat(param[2:], 64); err != nil || q < 0 || q > 1 {
+					q = 0
+				}
+			}
+		}
+		if q > 0 {
+			ranges = append(ranges, languageRange{tag, q})
+		}
+	}
+	sort.SliceStable(ranges, func(i, j int) bool {
+		return ranges[i].q > ranges[j].q
+	})
+	tags := make([]string, len(ranges))
+	for i, r := range ranges {
+		tags[i] = r.tag
+	}
+	return tags
+}
+
+// translator returns the template function t, which translates a text of the form into the language.
+// Texts with arguments are formatted like fmt.Sprintf after the translation.
+func translator(language string) func(text string, args ...interface{}) string {
+	messages := formMessages[language]
+	return func(text string, args ...interface{}) string {
+		if translated, exist := messages[text]; exist {
+			text = translated
+		}
+		if len(args) == 0 {
+			return text
+		}
+		return fmt.Sprintf(text, args...)
+	}
+}
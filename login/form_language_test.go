@@ -0,0 +1,78 @@
+package login
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	. "github.com/stretchr/testify/assert"
+	"github.com/tarent/loginsrv/model"
+)
+
+func Test_formLanguage(t *testing.T) {
+	testCases := []struct {
+		acceptLanguage string
+		language       string
+	}{
+		{"", "en"},
+		{"de", "de"},
+		{"de-AT", "de"},
+		{"DE-de", "de"},
+		{"fr", "en"},
+		{"fr, de;q=0.5", "de"},
+		{"en-US, de;q=0.9", "en"},
+		{"de;q=0.5, en;q=0.8", "en"},
+		{"*, de;q=0.5", "en"},
+		{"de;q=0", "en"},
+		{"de;q=invalid", "en"},
+	}
+	for _, test := range testCases {
+		t.Run(test.acceptLanguage, func(t *testing.T) {
+			Equal(t, test.language, formLanguage(req("GET", "/context/login", "", "Accept-Language: "+test.acceptLanguage)))
+		})
+	}
+	Equal(t, "en", formLanguage(nil))
+}
+
+func Test_form_language(t *testing.T) {
+	config := &Config{LoginPath: "/login", Backends: Options{"simple": {}}, Oauth: Options{"github": {}}}
+
+	recorder := httptest.NewRecorder()
+	writeLoginForm(recorder, loginFormData{Config: config, Failure: true, Language: "de"})
+	Equal(t, "de", recorder.Header().Get("Content-Language"))
+	Equal(t, "Accept-Language", recorder.Header().Get("Vary"))
+	Contains(t, recorder.Body.String(), `<html lang="de">`)
+	Contains(t, recorder.Body.String(), `<label for="login-username">Benutzername</label>`)
+	Contains(t, recorder.Body.String(), `Anmelden mit Github`)
+	Contains(t, recorder.Body.String(), `<strong>Anmeldung fehlgeschlagen:</strong> Der Benutzername oder das Passwort ist nicht korrekt.`)
+
+	// the specific messages are translated as well
+	recorder = httptest.NewRecorder()
+	writeLoginForm(recorder, loginFormData{Config: config, Failure: true, FailureMessage: providerDisabledMessage, Language: "de"})
+	Contains(t, recorder.Body.String(), `Die Anmeldung mit diesem Anbieter ist derzeit nicht möglich.`)
+
+	recorder = httptest.NewRecorder()
+	writeLoginForm(recorder, loginFormData{Config: config, Authenticated: true, UserInfo: model.UserInfo{Sub: "bob"}, Language: "de"})
+	Contains(t, recorder.Body.String(), `Willkommen bob!`)
+
+	// without a language, the form stays english
+	recorder = httptest.NewRecorder()
+	writeLoginForm(recorder, loginFormData{Config: config, Failure: true})
+	Equal(t, "en", recorder.Header().Get("Content-Language"))
+	Contains(t, recorder.Body.String(), `<label for="login-username">Username</label>`)
+}
+
+func TestHandler_LoginForm_Language(t *testing.T) {
+	recorder := call(req("GET", "/context/login", "", AcceptHTML, "Accept-Language: de-DE,de;q=0.9,en;q=0.8"))
+	Equal(t, 200, recorder.Code)
+	Contains(t, recorder.Body.String(), `<html lang="de">`)
+	Contains(t, recorder.Body.String(), `<title>Anmelden</title>`)
+
+	cfg := testConfig()
+	cfg.Backends = Options{"simple": {"bob": "secret"}}
+	h, err := NewHandler(cfg)
+	NoError(t, err)
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("POST", "/context/login", "username=bob&password=wrong", TypeForm, AcceptHTML, "Accept-Language: de"))
+	Equal(t, 403, recorder.Code)
+	Contains(t, recorder.Body.String(), `Der Benutzername oder das Passwort ist nicht korrekt.`)
+}
@@ -0,0 +1,122 @@
+package login
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"path"
+	"time"
+
+	"github.com/tarent/loginsrv/logging"
+	"github.com/tarent/loginsrv/model"
+)
+
+const (
+	frontChannelLogoutRateNamespace        = "frontchannel_logout_rate"
+	frontChannelLogoutRateNamespaceVersion = 1
+
+	// frontChannelLogoutRateLimit is the maximum number of front-channel logouts per caller ip and minute.
+	// A browser loads the logout once per logout at the provider, so more are a flood of the revocation store.
+	frontChannelLogoutRateLimit = 30
+)
+
+var frontChannelLogoutRateWindow = time.Minute
+
+// issuerOption is the oauth option with the issuer of the id tokens of the provider
+const issuerOption = "issuer"
+
+var (
+	errAPIMissingIdPSession         = apiError{400, "missing_session", "Bad Request: The iss and sid of the session to log out are missing"}
+	errAPIUnknownIssuer             = apiError{400, "unknown_issuer", "Bad Request: The iss is not the issuer of a configured oauth provider"}
+	errAPIFrontChannelLogoutLimited = apiError{429, "rate_limited", "Too Many Requests: Too many logouts, please try again later"}
+)
+
+// checkFrontChannelLogout rejects -frontchannel-logout without an oauth provider with an issuer,
+// because every logout would be rejected
+func checkFrontChannelLogout(config *Config) error {
+	if !config.FrontChannelLogout {
+		return nil
+	}
+	for _, options := range config.Oauth {
+		if options[issuerOption] != "" {
+			return nil
+		}
+	}
+	return errors.New("The front-channel logout requires the issuer of an oauth provider, e.g. -google client_id=..,client_secret=..,issuer=https://accounts.google.com")
+}
+
+// knownIssuer checks, if the issuer is the issuer of a configured oauth provider
+func (h *Handler) knownIssuer(issuer string) bool {
+	for _, options := range h.config.Oauth {
+		if options[issuerOption] == issuer {
+			return true
+		}
+	}
+	return false
+}
+
+// idpSessionRevocationID is the id, by which the sessions of the oauth provider are revoked in the revocation store.
+// Token ids are base64url encoded, so they can't collide with it.
+func idpSessionRevocationID(session model.IdPSession) string {
+	return "idp-session " + session.Issuer + " " + session.ID
+}
+
+func (h *Handler) isFrontChannelLogoutPath(r *http.Request) bool {
+	return h.config.FrontChannelLogout && r.URL.Path == path.Join(h.config.LoginPath, "frontchannel-logout")
+}
+
+// respondFrontChannelLogout ends the local sessions of a session of the oauth provider (OpenID Connect Front-Channel Logout).
+// The provider loads the page in an iframe of the browser with the iss and sid of the session.
+// All tokens issued for the session are revoked in the revocation store, so that a logout reaches every instance.
+// The cookies of the browser are deleted, if they belong to the session.
+// Sessions of the login backends have no session of a provider and are not affected.
+// The request is not authenticated, so only the issuers of the configured providers are accepted
+// and the requests per caller ip are limited.
+func (h *Handler) respondFrontChannelLogout(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		h.respondBadRequest(w, r)
+		return
+	}
+	count, reset := h.store.increment(frontChannelLogoutRateNamespace, clientIP(r, h.trustedProxies), frontChannelLogoutRateWindow)
+	if count > frontChannelLogoutRateLimit {
+		w.Header().Set("Retry-After", fmt.Sprintf("%d", int(math.Ceil(time.Until(reset).Seconds()))))
+		h.respondAPIError(w, r, errAPIFrontChannelLogoutLimited)
+		return
+	}
+	session := model.IdPSession{
+		Issuer: r.URL.Query().Get("iss"),
+		ID:     r.URL.Query().Get("sid"),
+	}
+	if session.Issuer == "" || session.ID == "" {
+		h.respondAPIError(w, r, errAPIMissingIdPSession)
+		return
+	}
+	if !h.knownIssuer(session.Issuer) {
+		logging.Application(r.Header).WithField("issuer", session.Issuer).Warn("rejected the front-channel logout of an unknown issuer")
+		h.respondAPIError(w, r, errAPIUnknownIssuer)
+		return
+	}
+
+	// checked before the revocation, which invalidates the token
+	userInfo, failure := h.verifyToken(r, "")
+	ownSession := failure == "" && userInfo.IdPSession != nil && *userInfo.IdPSession == session
+
+	if err := h.revocations.Revoke(idpSessionRevocationID(session), time.Now().Add(h.maxTokenLifetime())); err != nil {
+		logging.Application(r.Header).WithError(err).Error("could not revoke the session of the oauth provider")
+		h.respondError(w, r)
+		return
+	}
+
+	if ownSession {
+		h.deleteToken(w)
+		if h.refreshTokensEnabled() {
+			h.deleteRefreshToken(w)
+		}
+	}
+	h.emitWithReason(r, EventIdPLoggedOut, userInfo.Sub, userInfo.Origin, session.Issuer)
+
+	w.Header().Set("Cache-Control", "no-cache, no-store")
+	w.Header().Set("Pragma", "no-cache")
+	w.WriteHeader(200)
+}
@@ -0,0 +1,158 @@
+package login
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/stretchr/testify/assert"
+	"github.com/tarent/loginsrv/model"
+	"github.com/tarent/loginsrv/oauth2"
+)
+
+func frontChannelLogoutHandler(t *testing.T) *Handler {
+	cfg := testConfig()
+	cfg.Backends = Options{"simple": {"bob": "secret"}}
+	cfg.Oauth = Options{"github": {"client_id": "id", "client_secret": "secret", "issuer": "https://idp.example.com"}}
+	cfg.FrontChannelLogout = true
+	h, err := NewHandler(cfg)
+	NoError(t, err)
+	h.oauth = &oauth2ManagerMock{
+		_GetConfigFromRequest: func(r *http.Request) (oauth2.Config, error) {
+			if !strings.HasPrefix(r.URL.Path, "/context/login/github") {
+				return oauth2.Config{}, errors.New("no oauth provider")
+			}
+			return oauth2.Config{}, nil
+		},
+		_Handle: func(w http.ResponseWriter, r *http.Request) (bool, bool, model.UserInfo, error) {
+			sid := r.URL.Query().Get("sid")
+			return false, true, model.UserInfo{
+				Sub:        "marvin-" + sid,
+				Origin:     "github",
+				IdPSession: &model.IdPSession{Issuer: "https://idp.example.com", ID: sid},
+			}, nil
+		},
+	}
+	return h
+}
+
+func TestHandler_FrontChannelLogout(t *testing.T) {
+	h := frontChannelLogoutHandler(t)
+	rec := &eventRecorder{}
+	h.Subscribe(Subscriber{Name: "test", Handle: rec.handle})
+
+	oauthLogin := func(sid string) string {
+		recorder := callHandler(h, req("GET", "/context/login/github?code=xyz&sid="+sid, "", AcceptHTML))
+		Equal(t, 303, recorder.Code)
+		return readSetCookies(recorder.Header())[0].Value
+	}
+	ended, other := oauthLogin("session-1"), oauthLogin("session-2")
+	claims, err := tokenAsMap(ended)
+	NoError(t, err)
+	Equal(t, map[string]interface{}{"iss": "https://idp.example.com", "sid": "session-1"}, claims["idp_session"])
+
+	password := callHandler(h, req("POST", "/context/login", "username=bob&password=secret", TypeForm, AcceptJwt)).Body.String()
+
+	// the provider calls the logout in the browser of the user
+	recorder := callHandler(h, req("GET", "/context/login/frontchannel-logout?iss=https%3A%2F%2Fidp.example.com&sid=session-1", "",
+		"Cookie: "+h.config.CookieName+"="+ended))
+	Equal(t, 200, recorder.Code)
+	Equal(t, "no-cache, no-store", recorder.Header().Get("Cache-Control"))
+	Equal(t, "no-cache", recorder.Header().Get("Pragma"))
+	cookies := readSetCookies(recorder.Header())
+	Equal(t, 1, len(cookies))
+	Equal(t, h.config.CookieName, cookies[0].Name)
+	Equal(t, "delete", cookies[0].Value)
+	Equal(t, EventIdPLoggedOut, rec.types()[len(rec.types())-1])
+	Equal(t, "marvin-session-1", rec.events[len(rec.events)-1].Username)
+
+	// the ended session stops verifying and can't be refreshed, the other sessions go on
+	_, failure := h.VerifyToken(req("GET", "/", ""), ended)
+	Equal(t, TokenRevoked, failure)
+	Equal(t, 400, callHandler(h, req("POST", "/context/login", "", AcceptJwt, "Authorization: Bearer "+ended)).Code)
+	_, valid := h.GetToken(req("GET", "/", ""), other)
+	True(t, valid)
+	_, valid = h.GetToken(req("GET", "/", ""), password)
+	True(t, valid)
+
+	// without the cookie of the session, e.g. as third party cookies are blocked, the cookies are kept
+	recorder = callHandler(h, req("GET", "/context/login/frontchannel-logout?iss=https%3A%2F%2Fidp.example.com&sid=session-2", "",
+		"Cookie: "+h.config.CookieName+"="+password))
+	Equal(t, 200, recorder.Code)
+	Equal(t, 0, len(readSetCookies(recorder.Header())))
+	_, failure = h.VerifyToken(req("GET", "/", ""), other)
+	Equal(t, TokenRevoked, failure)
+	_, valid = h.GetToken(req("GET", "/", ""), password)
+	True(t, valid)
+
+	// issuers of unknown providers are rejected and their sessions are not affected
+	session3 := oauthLogin("session-3")
+	recorder = callHandler(h, req("GET", "/context/login/frontchannel-logout?iss=https%3A%2F%2Fother.example.com&sid=session-3", ""))
+	Equal(t, 400, recorder.Code)
+	Contains(t, recorder.Body.String(), errAPIUnknownIssuer.message)
+	_, valid = h.GetToken(req("GET", "/", ""), session3)
+	True(t, valid)
+	revoked, err := h.revocations.IsRevoked(idpSessionRevocationID(model.IdPSession{Issuer: "https://other.example.com", ID: "session-3"}))
+	NoError(t, err)
+	False(t, revoked)
+
+	Equal(t, 400, callHandler(h, req("GET", "/context/login/frontchannel-logout?sid=session-3", "")).Code)
+	Equal(t, 400, callHandler(h, req("GET", "/context/login/frontchannel-logout?iss=https%3A%2F%2Fidp.example.com", "")).Code)
+	Equal(t, 400, callHandler(h, req("POST", "/context/login/frontchannel-logout?iss=https%3A%2F%2Fidp.example.com&sid=session-3", "")).Code)
+}
+
+func TestHandler_FrontChannelLogout_Disabled(t *testing.T) {
+	h := frontChannelLogoutHandler(t)
+	h.config.FrontChannelLogout = false
+	Equal(t, 404, callHandler(h, req("GET", "/context/login/frontchannel-logout?iss=https%3A%2F%2Fidp.example.com&sid=session-1", "")).Code)
+}
+
+func TestHandler_FrontChannelLogout_StoreUnavailable(t *testing.T) {
+	h := frontChannelLogoutHandler(t)
+	h.revocations = failingRevocations{}
+	recorder := callHandler(h, req("GET", "/context/login/frontchannel-logout?iss=https%3A%2F%2Fidp.example.com&sid=session-1", ""))
+	Equal(t, 500, recorder.Code)
+
+	// without the revocations, the tokens of oauth sessions can not be trusted
+	expiry := time.Now().Add(time.Hour).Unix()
+	token, err := h.createToken(model.UserInfo{Sub: "marvin", Expiry: expiry, IdPSession: &model.IdPSession{Issuer: "https://idp.example.com", ID: "session-1"}})
+	NoError(t, err)
+	_, failure := h.verifyToken(req("GET", "/", ""), token)
+	Equal(t, TokenInvalid, failure)
+	token, err = h.createToken(model.UserInfo{Sub: "bob", Expiry: expiry})
+	NoError(t, err)
+	_, failure = h.verifyToken(req("GET", "/", ""), token)
+	Equal(t, TokenFailure(""), failure)
+}
+
+func TestHandler_FrontChannelLogout_RateLimit(t *testing.T) {
+	h := frontChannelLogoutHandler(t)
+	logout := func(remoteAddr, sid string) *httptest.ResponseRecorder {
+		r := req("GET", "/context/login/frontchannel-logout?iss=https%3A%2F%2Fidp.example.com&sid="+sid, "")
+		r.RemoteAddr = remoteAddr
+		return callHandler(h, r)
+	}
+	for i := 0; i < frontChannelLogoutRateLimit; i++ {
+		Equal(t, 200, logout("192.0.2.1:1234", fmt.Sprintf("session-%v", i)).Code)
+	}
+	recorder := logout("192.0.2.1:1234", "session-limited")
+	Equal(t, 429, recorder.Code)
+	Equal(t, "60", recorder.Header().Get("Retry-After"))
+	revoked, _ := h.revocations.IsRevoked(idpSessionRevocationID(model.IdPSession{Issuer: "https://idp.example.com", ID: "session-limited"}))
+	False(t, revoked)
+
+	// other callers are not limited
+	Equal(t, 200, logout("192.0.2.2:1234", "session-limited").Code)
+}
+
+func TestHandler_FrontChannelLogout_RequiresIssuer(t *testing.T) {
+	cfg := testConfig()
+	cfg.Oauth = Options{"github": {"client_id": "id", "client_secret": "secret"}}
+	cfg.FrontChannelLogout = true
+	_, err := NewHandler(cfg)
+	EqualError(t, err, "The front-channel logout requires the issuer of an oauth provider, e.g. -google client_id=..,client_secret=..,issuer=https://accounts.google.com")
+}
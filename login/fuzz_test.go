@@ -0,0 +1,159 @@
+package login
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/stretchr/testify/assert"
+	"github.com/tarent/loginsrv/model"
+)
+
+// FuzzGetCredentials parses arbitrary login requests, like the handler does.
+// It must never panic and either return credentials or a clean error.
+func FuzzGetCredentials(f *testing.F) {
+	f.Add("application/x-www-form-urlencoded", "username=bob&password=secret")
+	f.Add("application/x-www-form-urlencoded; charset=UTF-8", "username=b%C3%B6b&password=s%26cret")
+	f.Add("application/json", `{"username":"bob","password":"secret"}`)
+	f.Add("application/json; charset=utf-8", `{"username":"bob","password":"secret"}`)
+	f.Add("application/json", `{"token":"eyJhbGciOiJIUzUxMiJ9.e30.x"}`)
+	f.Add("application/json", `{"username":1}`)
+	f.Add("application/json", `null`)
+	f.Add("multipart/form-data; boundary=xyz", "--xyz\r\nContent-Disposition: form-data; name=\"username\"\r\n\r\nbob\r\n--xyz--\r\n")
+	f.Add("multipart/form-data", "")
+	f.Add("", "username=bob")
+	f.Add("text/plain", "bob:secret")
+
+	f.Fuzz(func(t *testing.T, contentType, body string) {
+		r := httptest.NewRequest("POST", "/login", strings.NewReader(body))
+		r.Header.Set("Content-Type", contentType)
+		if hasMediaType(r, "multipart/form-data") {
+			r.ParseMultipartForm(maxCredentialsBodySize)
+		} else {
+			r.ParseForm()
+		}
+
+		username, password, token, err := getCredentials(r)
+		if err != nil && (username != "" || password != "" || token != "") {
+			t.Errorf("credentials returned together with error %v", err)
+		}
+
+		// a second read returns the same result
+		username2, password2, token2, err2 := getCredentials(r)
+		if username != username2 || password != password2 || token != token2 || (err == nil) != (err2 == nil) {
+			t.Errorf("second read of the credentials differs")
+		}
+	})
+}
+
+// FuzzHandler_Login sends arbitrary login requests through the handler.
+func FuzzHandler_Login(f *testing.F) {
+	f.Add("application/x-www-form-urlencoded", "username=bob&password=secret", "text/html")
+	f.Add("application/json; charset=utf-8", `{"username":"bob","password":"secret"}`, "application/jwt")
+	f.Add("multipart/form-data; boundary=xyz", "--xyz\r\nContent-Disposition: form-data; name=\"username\"\r\n\r\nbob\r\n--xyz--\r\n", "")
+	f.Add("application/json", `{"username":"bob"`, "application/json; version=2")
+
+	h := testHandler()
+	f.Fuzz(func(t *testing.T, contentType, body, accept string) {
+		r := httptest.NewRequest("POST", "/context/login", strings.NewReader(body))
+		r.Header.Set("Content-Type", contentType)
+		r.Header.Set("Accept", accept)
+		recorder := httptest.NewRecorder()
+		h.ServeHTTP(recorder, r)
+		if recorder.Code >= 500 {
+			t.Errorf("unexpected status %v", recorder.Code)
+		}
+	})
+}
+
+// FuzzGetToken verifies malformed cookie and token values.
+func FuzzGetToken(f *testing.F) {
+	h := testHandler()
+	valid, _ := h.createToken(model.UserInfo{Sub: "bob", Expiry: time.Now().Add(time.Hour).Unix()})
+	f.Add(valid)
+	f.Add("delete")
+	f.Add("")
+	f.Add("eyJhbGciOiJub25lIn0.eyJzdWIiOiJib2IifQ.")
+	f.Add("eyJhbGciOiJIUzUxMiJ9.e30")
+	f.Add("a.b.c.d")
+	f.Add(valid[:len(valid)-4])
+
+	f.Fuzz(func(t *testing.T, value string) {
+		if userInfo, ok := h.GetToken(req("GET", "/context/login", ""), value); ok && value != valid {
+			t.Errorf("forged token accepted: %v", userInfo)
+		}
+
+		r := req("GET", "/context/login", "")
+		r.AddCookie(&http.Cookie{Name: h.config.CookieName, Value: value})
+		h.GetToken(r, "")
+	})
+}
+
+// FuzzToken_RoundTrip checks, that every normalized user info, which can be signed,
+// is verified back to an equal user info.
+func FuzzToken_RoundTrip(f *testing.F) {
+	f.Add("bob", "Bob Builder", "bob@example.com", "htpasswd", "admin,dev", 0, "")
+	f.Add("", "", "", "", "", 3, "")
+	f.Add("b\xffb", " padded ", "", "github", ",,", 1, "api")
+	f.Add("日本語", " ", "\"quoted\"", "<script>", "a,,b", 0, "aud")
+
+	h := testHandler()
+	f.Fuzz(func(t *testing.T, sub, name, email, origin, groups string, refreshes int, audience string) {
+		u := model.UserInfo{
+			Sub:       sub,
+			Name:      name,
+			Email:     email,
+			Origin:    origin,
+			Refreshes: refreshes,
+			Audience:  audience,
+			Expiry:    time.Now().Add(time.Hour).Unix(),
+		}
+		if groups != "" {
+			u.Groups = strings.Split(groups, ",")
+		}
+		u = h.normalizeUserInfo(u)
+
+		token, err := h.createToken(u)
+		if err != nil {
+			return
+		}
+		parsed, valid := h.GetToken(req("GET", "/context/login", ""), token)
+		if !valid {
+			t.Fatalf("token for %#v not valid", u)
+		}
+		// the time of issue is set on signing
+		if parsed.IssuedAt == 0 {
+			t.Errorf("missing time of issue in %#v", parsed)
+		}
+		parsed.IssuedAt = 0
+		if !reflect.DeepEqual(u, parsed) {
+			t.Errorf("round trip changed the user info:\n%#v\n%#v", u, parsed)
+		}
+	})
+}
+
+func TestGetCredentials_Formats(t *testing.T) {
+	testCases := []struct {
+		contentType string
+		body        string
+	}{
+		{"application/x-www-form-urlencoded", "username=bob&password=secret"},
+		{"application/json", `{"username":"bob","password":"secret"}`},
+		{"application/json; charset=utf-8", `{"username":"bob","password":"secret"}`},
+		{"multipart/form-data; boundary=xyz", "--xyz\r\nContent-Disposition: form-data; name=\"username\"\r\n\r\nbob\r\n" +
+			"--xyz\r\nContent-Disposition: form-data; name=\"password\"\r\n\r\nsecret\r\n--xyz--\r\n"},
+	}
+	for _, test := range testCases {
+		t.Run(test.contentType, func(t *testing.T) {
+			recorder := call(req("POST", "/context/login", test.body, "Content-Type: "+test.contentType, AcceptJwt))
+			Equal(t, 200, recorder.Code)
+		})
+	}
+
+	// too large bodies are rejected
+	recorder := call(req("POST", "/context/login", `{"username":"`+strings.Repeat("x", maxCredentialsBodySize)+`"}`, TypeJSON, AcceptJwt))
+	Equal(t, 400, recorder.Code)
+}
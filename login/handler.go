@@ -1,93 +1,647 @@
 package login
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
+	"mime"
+	"net"
 	"net/http"
+	"path"
+	"sort"
+	"strconv"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/opentracing/opentracing-go"
 
-	"github.com/dgrijalva/jwt-go"
 	"github.com/tarent/loginsrv/logging"
 	"github.com/tarent/loginsrv/model"
 	"github.com/tarent/loginsrv/oauth2"
+	"github.com/tarent/loginsrv/outboundtls"
 )
 
 const contentTypeHTML = "text/html; charset=utf-8"
 const contentTypeJWT = "application/jwt"
-const contentTypePlain = "text/plain"
+const contentTypePlain = "text/plain; charset=utf-8"
 
 // Handler is the mail login handler.
 // It serves the login ressource and does the authentication against the backends or oauth provider.
+//
+// The fields of the handler are the state derived from the configuration. They are never modified
+// after construction, a reload creates a new snapshot instead. The state shared by all snapshots,
+// e.g. the maintenance mode or the store, is kept in the handlerRuntime.
 type Handler struct {
-	backends []Backend
-	oauth    oauthManager
-	config   *Config
+	backends     []Backend
+	backendNames []string
+	oauth        oauthManager
+	config       *Config
+
+	// userFilters are the allow and deny lists of the backends by name
+	userFilters map[string]*userFilter
+
+	// shadowBackends are compared with the login backends, but never decide a login
+	shadowBackends []shadowBackend
+
+	trustedProxies []*net.IPNet
+
+	originOverrides map[string]sessionSettings
+
+	signer Signer
+
+	// jwks is the rendered key set of the signer
+	jwks *cachedResponse
+
+	// fallbackSigners verify the tokens of previous secrets
+	fallbackSigners []Signer
+
+	// audiences are the accepted aud claims, the first one is issued
+	audiences []string
+
+	loginPathAliases []string
+
+	slowRequests *slowRequestLog
+
+	rollover *rollover
+
+	// jwe encrypts the tokens for the browser, nil without a -jwe-key
+	jwe *tokenEncrypter
+
+	// claimMap renames the claims in the tokens, nil without a -jwt-claim-map
+	claimMap claimMap
+
+	// extraClaims are stamped into every token, nil without -jwt-claims
+	extraClaims extraClaims
+
+	// claimsLimits are checked for every token, nil without -claims-strict
+	claimsLimits *claimsLimits
+
+	// verifyHeaders are the claims set as headers by the verify endpoint
+	verifyHeaders []verifyHeader
+
+	// userFile adds claims to the tokens of the matching users, nil without -user-file
+	userFile userFile
+
+	redirectWhitelist []string
+
+	ipFilter *ipFilter
+
+	breakGlass *breakGlass
+
+	// readinessDeps are the backends with readiness checks
+	readinessDeps []readinessDependency
+
+	// logins are deduplicated per configuration
+	logins loginDeduplicator
+
+	*handlerRuntime
 }
 
 // NewHandler creates a login handler based on the supplied configuration.
 func NewHandler(config *Config) (*Handler, error) {
+	return NewHandlerWithOptions(config)
+}
+
+// HandlerOption changes the creation of a handler by NewHandlerWithOptions.
+type HandlerOption func(rt *handlerRuntime)
+
+// WithRegistry creates the backends from the providers of the registry instead of the DefaultRegistry.
+// It is used by the handler for its lifetime, including reloads.
+func WithRegistry(registry *ProviderRegistry) HandlerOption {
+	return func(rt *handlerRuntime) {
+		rt.registry = registry
+	}
+}
+
+// WithStore keeps the revocations, failed logins and sessions in the store instead of the memory of the handler,
+// e.g. to share them between instances. The -store-file takes precedence.
+func WithStore(store Store) HandlerOption {
+	return func(rt *handlerRuntime) {
+		rt.useStore(store)
+	}
+}
+
+// NewHandlerWithOptions creates a login handler based on the supplied configuration and options.
+func NewHandlerWithOptions(config *Config, options ...HandlerOption) (*Handler, error) {
+	rt := newHandlerRuntime()
+	for _, option := range options {
+		option(rt)
+	}
+	outbound, err := newOutboundTLS(config)
+	if err != nil {
+		return nil, err
+	}
+	// the backends and the kms signer may connect already on creation
+	outboundtls.Configure(outbound)
+
+	h, err := rt.newSnapshot(config)
+	if err != nil {
+		return nil, err
+	}
+
+	if config.JwtSecret == jwtDefaultSecret && config.JwtPrivateKey == "" && config.JwtKMSKey == "" {
+		logging.Logger.Warnf("no -jwt-secret is configured, using a random secret with the fingerprint %v, a restart invalidates all sessions", jwtSecretFingerprint(jwtDefaultSecret))
+	}
+
+	// the namespaces of the store have to be registered before loading
+	if config.StateFile != "" {
+		if err := h.store.loadSnapshot(config.StateFile); err != nil {
+			logging.Logger.WithError(err).
+				Error("!!! could not restore the state, starting with empty state (lockouts and revocations are lost) !!!")
+		}
+	}
+
+	if config.StoreFile != "" {
+		file, err := OpenFileStore(config.StoreFile)
+		if err != nil {
+			return nil, fmt.Errorf("Could not open the store file: %v", err)
+		}
+		rt.useStore(file)
+	}
+
+	if config.Maintenance {
+		h.SetMaintenance(true)
+	}
+
+	if config.BreakGlassWebhook != "" {
+		h.Subscribe(breakGlassWebhook(config.BreakGlassWebhook))
+	}
+
+	rt.current.Store(h)
+	rt.skew.SetThreshold(config.ClockSkewThreshold)
+	h.startUsernameConflictCheck()
+
+	return h, nil
+}
+
+// newSnapshot validates the configuration and creates the handler state for it,
+// sharing the runtime.
+func (rt *handlerRuntime) newSnapshot(config *Config) (*Handler, error) {
 	if len(config.Backends) == 0 && len(config.Oauth) == 0 {
 		return nil, errors.New("No login backends or oauth provider configured")
 	}
 
+	// before anything derives keys from the secret
+	if err := resolveJwtSecret(config); err != nil {
+		return nil, err
+	}
+
+	if config.ConflictPolicy != "" && !validConflictPolicy(config.ConflictPolicy) {
+		return nil, fmt.Errorf("No such conflict policy: %v", config.ConflictPolicy)
+	}
+
+	if config.APIVersion != 0 && !validAPIVersion(config.APIVersion) {
+		return nil, fmt.Errorf("No such api version: %v, supported versions: %v", config.APIVersion, supportedAPIVersionList())
+	}
+
+	if err := checkDebugTokenPage(config); err != nil {
+		return nil, err
+	}
+
+	if err := checkCookieAttributes(config); err != nil {
+		return nil, err
+	}
+
+	if err := checkContentNegotiation(config); err != nil {
+		return nil, err
+	}
+
+	if err := checkTokenHeader(config); err != nil {
+		return nil, err
+	}
+
+	if err := checkCertBinding(config); err != nil {
+		return nil, err
+	}
+
+	if err := checkFrontChannelLogout(config); err != nil {
+		return nil, err
+	}
+
+	if config.JwtRefreshGrace < 0 {
+		return nil, errors.New("The jwt refresh grace must not be negative")
+	}
+
+	if config.JwtMaxSession < 0 {
+		return nil, errors.New("The jwt max session must not be negative")
+	}
+
+	if config.JwtSlidingExpiry && config.JwtMaxSession == 0 {
+		return nil, errors.New("A sliding jwt expiry requires a -jwt-max-session")
+	}
+
+	if config.MaxRequestDuration < 0 {
+		return nil, errors.New("The max request duration must not be negative")
+	}
+
+	if config.JwtRefreshTokenExpiry < 0 {
+		return nil, errors.New("The jwt refresh token expiry must not be negative")
+	}
+
+	if config.JwtRefreshTokenExpiry > 0 && config.JwtSlidingExpiry {
+		return nil, errors.New("A sliding jwt expiry can not be combined with refresh tokens")
+	}
+
+	if err := checkRedirectURLs(config); err != nil {
+		return nil, err
+	}
+
+	if err := checkLoginFormTemplate(config); err != nil {
+		return nil, err
+	}
+
+	trustedProxies, err := parseCIDRList(config.TrustedProxies)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid trusted proxies: %v", err)
+	}
+
+	ipFilter, err := newIPFilter(config.AllowIPs, config.DenyIPs)
+	if err != nil {
+		return nil, err
+	}
+
+	config.LoginPath, err = normalizeLoginPath(config.LoginPath)
+	if err != nil {
+		return nil, err
+	}
+	loginPathAliases, err := parseLoginPathAliases(config.LoginPath, config.LoginPathAliases)
+	if err != nil {
+		return nil, err
+	}
+
+	originOverrides, err := parseOriginOverrides(config)
+	if err != nil {
+		return nil, err
+	}
+
+	rollover, err := newRollover(config)
+	if err != nil {
+		return nil, err
+	}
+
+	jwe, err := newTokenEncrypter(config)
+	if err != nil {
+		return nil, err
+	}
+
+	claimMap, err := parseClaimMap(config.JwtClaimMap)
+	if err != nil {
+		return nil, err
+	}
+
+	extraClaims, err := parseExtraClaims(config.JwtClaims, claimMap)
+	if err != nil {
+		return nil, err
+	}
+
+	userFile, err := loadUserFile(config.UserFile, claimMap)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid user file: %v", err)
+	}
+
+	verifyHeaders, err := parseVerifyHeaders(config.VerifyHeaders)
+	if err != nil {
+		return nil, err
+	}
+
+	claimsLimits, err := newClaimsLimits(config, claimMap)
+	if err != nil {
+		return nil, err
+	}
+
+	var emergencyAccounts *breakGlass
+	if config.BreakGlassFile != "" {
+		if emergencyAccounts, err = loadBreakGlass(config.BreakGlassFile); err != nil {
+			return nil, fmt.Errorf("Invalid break-glass accounts: %v", err)
+		}
+	} else if config.BreakGlassWebhook != "" {
+		return nil, errors.New("A break-glass webhook requires a -break-glass-file")
+	}
+
 	backends := []Backend{}
-	for pName, opts := range config.Backends {
-		p, exist := GetProvider(pName)
+	backendNames := []string{}
+	var configErrors ConfigErrors
+	userFilters := map[string]*userFilter{}
+	var shadowBackends []shadowBackend
+	for _, pName := range sortedOptionNames(config.Backends) {
+		p, exist := rt.registry.Get(pName)
 		if !exist {
-			return nil, fmt.Errorf("No such provider: %v", pName)
+			configErrors.addBackendError(pName, errors.New("No such provider"))
+			continue
+		}
+		filter, options, err := newUserFilter(withoutExpiryOption(config.Backends[pName]))
+		if err != nil {
+			configErrors.addBackendError(pName, err)
+			continue
+		}
+		shadow, options, err := splitShadowOption(options)
+		if err != nil {
+			configErrors.addBackendError(pName, err)
+			continue
 		}
-		b, err := p(opts)
+		b, err := p(options)
 		if err != nil {
-			return nil, err
+			configErrors.addBackendError(pName, err)
+			continue
+		}
+		if keyed, ok := b.(KeyedBackend); ok {
+			keyed.SetKey(backendKey(config.JwtSecret, pName))
+		}
+		if shadow {
+			shadowBackends = append(shadowBackends, shadowBackend{name: pName, backend: b})
+			continue
+		}
+		if filter != nil {
+			userFilters[pName] = filter
 		}
 		backends = append(backends, b)
+		backendNames = append(backendNames, pName)
+	}
+
+	readinessDeps, err := newReadinessDependencies(config, backends, backendNames)
+	if err != nil {
+		return nil, err
 	}
 
 	oauth := oauth2.NewManager()
-	for providerName, opts := range config.Oauth {
-		err := oauth.AddConfig(providerName, opts)
+	for _, providerName := range sortedOptionNames(config.Oauth) {
+		err := oauth.AddConfig(providerName, withoutExpiryOption(config.Oauth[providerName]))
 		if err != nil {
-			return nil, err
+			configErrors.addOauthError(providerName, err)
 		}
 	}
 
-	return &Handler{
-		backends: backends,
-		config:   config,
-		oauth:    oauth,
-	}, nil
+	// report all configuration errors at once, instead of one per start
+	if len(configErrors) > 0 {
+		configErrors.log()
+		return nil, configErrors
+	}
+
+	if len(backends) == 0 && len(shadowBackends) > 0 && len(config.Oauth) == 0 {
+		return nil, errors.New("Shadow backends require a login backend to compare with")
+	}
+
+	h := &Handler{
+		backends:       backends,
+		backendNames:   backendNames,
+		userFilters:    userFilters,
+		shadowBackends: shadowBackends,
+		config:         config,
+		oauth:          oauth,
+		trustedProxies: trustedProxies,
+
+		originOverrides:  originOverrides,
+		loginPathAliases: loginPathAliases,
+		slowRequests:     newSlowRequestLog(config.SlowRequestThreshold, config.SlowRequestLogLimit),
+		rollover:         rollover,
+		jwe:              jwe,
+		claimMap:         claimMap,
+		extraClaims:      extraClaims,
+		userFile:         userFile,
+		verifyHeaders:    verifyHeaders,
+		claimsLimits:     claimsLimits,
+
+		redirectWhitelist: parseRedirectWhitelist(config.RedirectWhitelist),
+		audiences:         parseAudiences(config.JwtAudience),
+		ipFilter:          ipFilter,
+		breakGlass:        emergencyAccounts,
+		readinessDeps:     readinessDeps,
+
+		handlerRuntime: rt,
+	}
+
+	if rollover != nil {
+		rollover.report()
+	}
+
+	if h.signer, err = newSigner(config); err != nil {
+		return nil, err
+	}
+	config.JwtAlgo = h.signer.SigningMethod().Alg()
+	h.jwks = newJWKSResponse(h.signer)
+	if h.fallbackSigners, err = newFallbackSigners(config, h.signer); err != nil {
+		return nil, err
+	}
+
+	if len(config.Oauth) > 0 {
+		oauth.SetObserver(rt.oauthFlowMetrics())
+	}
+
+	return h, nil
+}
+
+// Hooks returns the lifecycle hooks of the handler's background components.
+// They have to be started by the embedding application,
+// e.g. by registering them at a Lifecycle.
+func (h *Handler) Hooks() []Hook {
+	var hooks []Hook
+	h = h.snapshot()
+	if h.config.ConflictCheckInterval > 0 {
+		// the check runs on the snapshot current at the time of each run
+		checkConflicts := func() { h.snapshot().checkUsernameConflicts() }
+		conflictCheck := &backgroundTask{interval: h.config.ConflictCheckInterval, run: checkConflicts}
+		hooks = append(hooks, conflictCheck.hook("username-conflict-check"))
+	}
+	if h.config.StateFile != "" {
+		hooks = append(hooks, h.snapshotHook())
+	}
+	if h.rollover != nil {
+		hooks = append(hooks, h.rolloverHook())
+	}
+	if file, ok := h.state.(*FileStore); ok {
+		hooks = append(hooks, file.hook())
+	}
+	if h.configuredFlowMetrics() != nil || len(h.backends) > 0 {
+		hooks = append(hooks, h.sweepHook())
+	}
+	if len(h.readinessDeps) > 0 {
+		hooks = append(hooks, h.readinessHook())
+	}
+	if len(h.shadowBackends) > 0 {
+		hooks = append(hooks, h.shadows.hook())
+	}
+	if h.eventStream().hasAsyncSubscribers() {
+		hooks = append(hooks, h.eventStream().hook())
+	}
+	return hooks
+}
+
+// CheckOauthProviders does a dry run against all configured oauth providers
+// and logs the result for each of them.
+// An error is returned, if at least one provider failed the check.
+func (h *Handler) CheckOauthProviders() error {
+	h = h.snapshot()
+	var failed []string
+	for providerName, err := range h.oauth.Validate() {
+		if err != nil {
+			logging.Logger.WithField("provider", providerName).WithError(err).Warn("oauth provider check failed")
+			failed = append(failed, providerName)
+			continue
+		}
+		logging.Logger.WithField("provider", providerName).Info("oauth provider check ok")
+	}
+	if len(failed) > 0 {
+		sort.Strings(failed)
+		return fmt.Errorf("oauth provider check failed for: %v", strings.Join(failed, ", "))
+	}
+	return nil
 }
 
 func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	if !strings.HasPrefix(r.URL.Path, h.config.LoginPath) {
+	// the request is served with one consistent state, even if a reload happens meanwhile
+	h = h.snapshot()
+
+	matched, ok := h.matchLoginPath(r.URL.Path)
+	if !ok {
+		w.Header().Set(apiVersionHeader, strconv.Itoa(h.apiVersion(r)))
 		h.respondNotFound(w, r)
 		return
 	}
+	r = h.withPrimaryLoginPath(r, matched)
+
+	version, err := h.negotiateAPIVersion(r)
+	if err != nil && h.isAPIRequest(r) {
+		w.Header().Set(apiVersionHeader, strconv.Itoa(h.defaultAPIVersion()))
+		h.respondUnsupportedAPIVersion(w, r, err)
+		return
+	}
+	if err != nil {
+		version = h.defaultAPIVersion()
+	}
+	w.Header().Set(apiVersionHeader, strconv.Itoa(version))
+
+	if h.isHealthPath(r) {
+		h.respondHealth(w, r)
+		return
+	}
+
+	if h.isReadyPath(r) {
+		h.respondReady(w, r)
+		return
+	}
 
-	_, err := h.oauth.GetConfigFromRequest(r)
+	if h.isJWKSPath(r) {
+		h.respondJWKS(w, r)
+		return
+	}
+
+	if h.isVerificationPath(r) {
+		h.respondVerification(w, r)
+		return
+	}
+
+	if h.isVerifyPath(r) {
+		h.respondVerify(w, r)
+		return
+	}
+
+	if h.isRevokePath(r) {
+		h.respondRevoke(w, r)
+		return
+	}
+
+	if h.isFrontChannelLogoutPath(r) {
+		h.respondFrontChannelLogout(w, r)
+		return
+	}
+
+	if h.isIntrospectionPath(r) {
+		h.respondIntrospection(w, r)
+		return
+	}
+
+	if h.slowRequests != nil {
+		timings := newRequestTimings()
+		r = r.WithContext(withRequestTimings(r.Context(), timings))
+		defer h.slowRequests.check(r, timings)
+	}
+
+	r, cancel := h.withRequestDeadline(r)
+	defer cancel()
+
+	// before any parsing of credentials, oauth callbacks included
+	if !h.checkClientIP(w, r) {
+		return
+	}
+
+	if h.isAdminProvidersPath(r) {
+		h.respondAdminProviders(w, r)
+		return
+	}
+
+	if h.isAdminSessionsPath(r) {
+		h.respondAdminSessions(w, r)
+		return
+	}
+
+	if h.isProvidersPath(r) {
+		h.respondProviders(w, r)
+		return
+	}
+
+	if backends, _ := h.clientBackends(); len(backends) > 0 && h.isTokenPath(r) {
+		h.handleTokenGrant(w, r)
+		return
+	}
+
+	_, err = h.oauth.GetConfigFromRequest(r)
 	if err == nil {
+		if h.providerDisabled(path.Base(r.URL.Path)) {
+			h.respondProviderDisabled(w, r)
+			return
+		}
+		if h.inMaintenance() {
+			h.respondMaintenance(w, r)
+			return
+		}
 		h.handleOauth(w, r)
 		return
 	}
 
+	// unknown providers must not fall through to the login form
+	if h.isUnknownSubPath(r) {
+		h.respondNotFound(w, r)
+		return
+	}
+
 	h.handleLogin(w, r)
 	return
 }
 
 func (h *Handler) handleOauth(w http.ResponseWriter, r *http.Request) {
+	r, ok := h.withBackTo(r)
+	if !ok {
+		h.respondAPIError(w, r, errAPIInvalidBackTo)
+		return
+	}
+
+	if !h.claimOauthCallback(w, r) {
+		return
+	}
+
+	endOauth := startPhase(r.Context(), "oauth")
 	startedFlow, authenticated, userInfo, err := h.oauth.Handle(w, r)
+	endOauth()
 
 	if startedFlow {
 		// the oauth flow started
 		return
 	}
 
+	if err != nil {
+		h.releaseOauthCallback(r)
+	}
+
+	if err != nil && requestTimedOut(r) {
+		h.respondRequestTimeout(w, r, "")
+		return
+	}
+
 	if err != nil {
 		logging.Application(r.Header).WithError(err).Error()
 		h.respondError(w, r)
@@ -95,16 +649,9 @@ func (h *Handler) handleOauth(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if authenticated {
-		logging.Application(r.Header).
-			WithField("username", userInfo.Sub).Info("successfully authenticated")
-		h.respondAuthenticated(w, r, userInfo)
-		return
+		r = h.withVerifiedBackTo(r)
 	}
-	logging.Application(r.Header).
-		WithField("username", userInfo.Sub).Info("failed authentication")
-
-	h.respondAuthFailure(w, r)
-	return
+	h.finishLogin(w, r, "", authenticated, userInfo, true)
 }
 
 func (h *Handler) handleLogin(w http.ResponseWriter, r *http.Request) {
@@ -119,34 +666,56 @@ func (h *Handler) handleLogin(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	r.ParseForm()
+	endParse := startPhase(r.Context(), "parse")
+	if hasMediaType(r, "multipart/form-data") {
+		r.ParseMultipartForm(maxCredentialsBodySize)
+	} else {
+		r.ParseForm()
+	}
+	endParse()
 	if r.Method == "DELETE" || r.FormValue("logout") == "true" {
+		userInfo, _ := h.getToken(r, "")
 		h.deleteToken(w)
+		if h.refreshTokensEnabled() {
+			h.revokeRefreshToken(r)
+			h.deleteRefreshToken(w)
+		}
+		h.emit(r, EventLoggedOut, userInfo.Sub, userInfo.Origin)
 		if h.config.LogoutURL != "" {
-			w.Header().Set("Location", h.config.LogoutURL)
-			w.WriteHeader(303)
+			h.redirect(w, h.config.LogoutURL)
 			return
 		}
 		writeLoginForm(w,
 			loginFormData{
-				Config: h.config,
+				Config:   h.formConfig(),
+				Language: formLanguage(r),
 			})
 		return
 	}
 
 	if r.Method == "GET" {
-		userInfo, valid := h.GetToken(r, "")
+		userInfo, valid := h.getToken(r, "")
+		if valid {
+			h.setTokenHeaderOnGet(w, r)
+		}
+		if valid && h.config.JwtSlidingExpiry {
+			userInfo = h.slideExpiry(w, r, userInfo)
+		}
 		writeLoginForm(w,
 			loginFormData{
-				Config:        h.config,
+				Config:        h.formConfig(),
+				Language:      formLanguage(r),
 				Authenticated: valid,
 				UserInfo:      userInfo,
+				Maintenance:   h.inMaintenance(),
 			})
 		return
 	}
 
 	if r.Method == "POST" {
+		endParse = startPhase(r.Context(), "parse")
 		username, password, rtoken, err := getCredentials(r)
+		endParse()
 
 		if err != nil {
 			h.respondBadRequest(w, r)
@@ -154,12 +723,16 @@ func (h *Handler) handleLogin(w http.ResponseWriter, r *http.Request) {
 		}
 
 		if username != "" {
+			if h.inMaintenance() {
+				h.respondMaintenance(w, r)
+				return
+			}
 			// No token found or credentials found, assuming new authentication
 			h.handleAuthentication(w, r, username, password)
 			return
 		}
-		userInfo, valid := h.GetToken(r, rtoken)
-		if valid {
+		userInfo, failure := h.verifyRefreshToken(r, rtoken)
+		if failure == "" {
 			h.handleRefresh(w, r, userInfo)
 			return
 		}
@@ -169,15 +742,39 @@ func (h *Handler) handleLogin(w http.ResponseWriter, r *http.Request) {
 }
 
 func (h *Handler) handleAuthentication(w http.ResponseWriter, r *http.Request, username string, password string) {
+	if r.Context().Err() != nil {
+		h.respondAborted(w, r, username)
+		return
+	}
 
-	tracer := opentracing.GlobalTracer()
-	var authenticated bool
-	var userInfo model.UserInfo
-	var err error
-	if tracer == nil {
-		authenticated, userInfo, err = h.authenticate(username, password)
-	} else {
-		authenticated, userInfo, err = h.authenticateWithContext(r.Context(), username, password)
+	authenticate := func() authResult {
+		var res authResult
+		if opentracing.GlobalTracer() == nil {
+			res.authenticated, res.userInfo, res.err = h.authenticate(username, password)
+		} else {
+			ctx := WithRequestInfo(r.Context(), h.requestInfo(r))
+			res.authenticated, res.userInfo, res.err = h.authenticateWithContext(ctx, username, password)
+		}
+		if res.err == nil && len(h.shadowBackends) > 0 {
+			_, _, backendUsername := h.backendsFor(username)
+			h.shadowAuthenticate(backendUsername, password, res.authenticated, res.userInfo)
+		}
+		return res
+	}
+	result, shared := h.logins.do(loginKey(r, username, password), authenticate)
+	if shared && isContextError(result.err) && r.Context().Err() == nil {
+		// the shared attempt was given up by its client, but this one is still waiting
+		result, shared = authenticate(), false
+	}
+	authenticated, userInfo, err := result.authenticated, result.userInfo, result.err
+	if shared {
+		logging.Application(r.Header).
+			WithField("username", username).Debug("duplicate login submission, reusing the result")
+	}
+
+	if isContextError(err) {
+		h.respondAborted(w, r, username)
+		return
 	}
 
 	if err != nil {
@@ -187,25 +784,154 @@ func (h *Handler) handleAuthentication(w http.ResponseWriter, r *http.Request, u
 	}
 
 	if authenticated {
-		logging.Application(r.Header).
-			WithField("username", username).Info("successfully authenticated")
-		h.respondAuthenticated(w, r, userInfo)
+		if permitted, reason := h.userPermitted(userInfo); !permitted {
+			if !shared {
+				h.emitWithReason(r, EventLoginNotPermitted, username, userInfo.Origin, reason)
+			}
+			h.respondUserNotPermitted(w, r, username)
+			return
+		}
+	}
+
+	h.finishLogin(w, r, username, authenticated, userInfo, !shared)
+}
+
+// finishLogin emits the outcome of a password or oauth login and responds to it.
+// Without a submitted username, the subject of the user info is used.
+func (h *Handler) finishLogin(w http.ResponseWriter, r *http.Request, username string, authenticated bool, userInfo model.UserInfo, emit bool) {
+	if !authenticated {
+		if username == "" {
+			username = userInfo.Sub
+		}
+		if emit {
+			h.emit(r, EventLoginFailed, username, userInfo.Origin)
+			if username != "" {
+				h.failures.record(h.loginFailureKey(username))
+			}
+		}
+		h.respondAuthFailure(w, r)
 		return
 	}
-	logging.Application(r.Header).
-		WithField("username", username).Info("failed authentication")
 
-	h.respondAuthFailure(w, r)
+	endEnrich := startPhase(r.Context(), "enrich")
+	userInfo = h.normalizeUserInfo(userInfo)
+	endEnrich()
+	if username == "" {
+		username = userInfo.Sub
+	}
+	if h.risk != nil {
+		if assessment := h.assessRisk(r, username, userInfo, emit); assessment.Decision != RiskAllow {
+			h.respondRiskDecision(w, r, username, assessment)
+			return
+		}
+	}
+	if emit {
+		h.emit(r, EventLoginSucceeded, username, userInfo.Origin)
+		h.failures.reset(h.loginFailureKey(username))
+		if userInfo.Origin == breakGlassOrigin {
+			h.emit(r, EventBreakGlassLogin, username, userInfo.Origin)
+		}
+	}
+	h.respondAuthenticated(w, r, userInfo)
+}
+
+// verifyRefreshToken verifies the token of a refresh. Tokens, which expired within the -jwt-refresh-grace,
+// are accepted as well, e.g. of a single page app, which woke up after the expiry.
+// With separate refresh tokens, only they are accepted, from the body or the refresh token cookie.
+func (h *Handler) verifyRefreshToken(r *http.Request, rtoken string) (model.UserInfo, TokenFailure) {
+	tokens := h.tokenService()
+	tokens.expiryGrace = h.config.JwtRefreshGrace
+	if !h.refreshTokensEnabled() {
+		return h.verifyTokenWith(r, rtoken, tokens)
+	}
+	rtoken = h.refreshTokenOf(r, rtoken)
+	if rtoken == "" {
+		return model.UserInfo{}, TokenMissing
+	}
+	tokens.refreshTokens = true
+	userInfo, failure := h.verifyTokenWith(r, rtoken, tokens)
+	if failure == TokenRevoked {
+		// the token was rotated before, so it may be stolen
+		logging.Application(r.Header).Warn("reuse of a rotated or revoked refresh token")
+	}
+	return userInfo, failure
 }
 
 func (h *Handler) handleRefresh(w http.ResponseWriter, r *http.Request, userInfo model.UserInfo) {
-	if userInfo.Refreshes >= h.config.JwtRefreshes {
+	if userInfo.NoRefresh {
+		h.respondNotRefreshable(w, r)
+	} else if permitted, reason := h.userPermitted(userInfo); !permitted {
+		h.emitWithReason(r, EventLoginNotPermitted, userInfo.Sub, userInfo.Origin, reason)
+		h.respondUserNotPermitted(w, r, userInfo.Sub)
+	} else if h.countsRefreshes() && userInfo.Refreshes >= h.sessionSettingsFor(userInfo.Origin).JwtRefreshes {
 		h.respondMaxRefreshesReached(w, r)
+	} else if h.sessionEnded(&userInfo) {
+		h.respondMaxSessionReached(w, r)
 	} else {
-		userInfo.Refreshes++
+		if h.refreshTokensEnabled() {
+			if err := h.rotateRefreshToken(r, userInfo); err != nil {
+				logging.Application(r.Header).
+					WithField("username", userInfo.Sub).
+					WithError(err).
+					Warn("could not rotate the refresh token")
+				h.respondBadRequest(w, r)
+				return
+			}
+		}
+		if h.countsRefreshes() {
+			userInfo.Refreshes++
+		}
+		userInfo.Expiry = 0
 		h.respondAuthenticated(w, r, userInfo)
-		logging.Application(r.Header).WithField("username", userInfo.Sub).Info("refreshed jwt")
+		h.emit(r, EventRefreshed, userInfo.Sub, userInfo.Origin)
+	}
+}
+
+// countsRefreshes checks, if the refreshes are limited by the -jwt-refreshes.
+// Sliding refreshes and refreshes by refresh tokens are only bounded by the session lifetime.
+func (h *Handler) countsRefreshes() bool {
+	return !h.config.JwtSlidingExpiry && !h.refreshTokensEnabled()
+}
+
+// slideExpiry renews the cookie of an active user with a new expiry, bounded by the session lifetime.
+// It returns the user info of the new token, or the unchanged one, if the token is not refreshable.
+func (h *Handler) slideExpiry(w http.ResponseWriter, r *http.Request, userInfo model.UserInfo) model.UserInfo {
+	if userInfo.NoRefresh || h.sessionEnded(&userInfo) {
+		return userInfo
+	}
+	userInfo.Expiry = 0
+	renewed, token, err := h.issueToken(r, userInfo)
+	if err == nil {
+		token, err = h.encryptToken(token)
+	}
+	if err == nil {
+		var legacyToken string
+		if legacyToken, err = h.legacyToken(r.Context(), renewed); err == nil {
+			cookie := h.tokenCookie(token, h.sessionSettingsFor(renewed.Origin))
+			h.setCookie(w, cookie)
+			h.setLegacyCookie(w, cookie, legacyToken)
+			if h.config.TokenHeaderOnGet {
+				h.setTokenHeader(w, token)
+			}
+			h.recordSession(r, renewed, nil)
+			h.emit(r, EventRefreshed, renewed.Sub, renewed.Origin)
+			return renewed
+		}
 	}
+	logging.Application(r.Header).WithError(err).Error("could not renew the token")
+	return userInfo
+}
+
+// sessionEnded checks, if the absolute session lifetime of the -jwt-max-session is over.
+// Tokens issued before the session start claim was introduced, start their session at their time of issue.
+func (h *Handler) sessionEnded(userInfo *model.UserInfo) bool {
+	if userInfo.SessionStart == 0 {
+		userInfo.SessionStart = userInfo.IssuedAt
+	}
+	if h.config.JwtMaxSession == 0 || userInfo.SessionStart == 0 {
+		return false
+	}
+	return !time.Now().Before(time.Unix(userInfo.SessionStart, 0).Add(h.config.JwtMaxSession))
 }
 
 func (h *Handler) deleteToken(w http.ResponseWriter) {
@@ -213,140 +939,386 @@ func (h *Handler) deleteToken(w http.ResponseWriter) {
 		Name:     h.config.CookieName,
 		Value:    "delete",
 		HttpOnly: true,
-		Expires:  time.Unix(0, 0),
+		Secure:   h.config.CookieSecure,
 		Path:     "/",
 	}
+	expireCookie(cookie)
 	if h.config.CookieDomain != "" {
 		cookie.Domain = h.config.CookieDomain
 	}
-	http.SetCookie(w, cookie)
+	h.setCookie(w, cookie)
+	if h.rollover != nil && h.rollover.cookie {
+		legacyCookie := *cookie
+		legacyCookie.Name = h.rollover.cookieName
+		h.setCookie(w, &legacyCookie)
+	}
+}
+
+// issueToken signs a new token for the user info and returns it with the user info of the token
+func (h *Handler) issueToken(r *http.Request, userInfo model.UserInfo) (model.UserInfo, string, error) {
+	settings := h.sessionSettingsFor(userInfo.Origin)
+
+	// the session starts with the authentication and is kept on refreshes
+	if userInfo.SessionStart == 0 {
+		userInfo.SessionStart = time.Now().Unix()
+	}
+
+	// a backend may restrict the expiry to an earlier time, the tokens never outlive the session
+	expiry := time.Now().Add(settings.JwtExpiry).Unix()
+	if h.config.JwtMaxSession > 0 {
+		if sessionEnd := time.Unix(userInfo.SessionStart, 0).Add(h.config.JwtMaxSession).Unix(); sessionEnd < expiry {
+			expiry = sessionEnd
+		}
+	}
+	if userInfo.Expiry == 0 || userInfo.Expiry > expiry {
+		userInfo.Expiry = expiry
+	}
+	userInfo = h.bindToClientCert(r, userInfo)
+	userInfo.TokenType = ""
+	if h.refreshTokensEnabled() {
+		userInfo.TokenType = model.TokenTypeAccess
+	}
+	// every token gets a new id, so that it can be revoked on its own
+	id, err := newTokenID()
+	if err != nil {
+		return model.UserInfo{}, "", err
+	}
+	userInfo.ID = id
+	token, err := h.createTokenWithContext(r.Context(), userInfo)
+	return userInfo, token, err
 }
 
 func (h *Handler) respondAuthenticated(w http.ResponseWriter, r *http.Request, userInfo model.UserInfo) {
-	userInfo.Expiry = time.Now().Add(h.config.JwtExpiry).Unix()
-	token, err := h.createToken(userInfo)
+	settings := h.sessionSettingsFor(userInfo.Origin)
+	userInfo, token, err := h.issueToken(r, userInfo)
 	if err != nil {
 		logging.Application(r.Header).WithError(err).Error()
 		h.respondError(w, r)
 		return
 	}
-
-	if wantHTML(r) {
-		cookie := &http.Cookie{
-			Name:     h.config.CookieName,
-			Value:    token,
-			HttpOnly: h.config.CookieHTTPOnly,
-			Path:     "/",
-		}
-		if h.config.CookieExpiry != 0 {
-			cookie.Expires = time.Now().Add(h.config.CookieExpiry)
+	legacyToken, err := h.legacyToken(r.Context(), userInfo)
+	if err != nil {
+		logging.Application(r.Header).WithError(err).Error()
+		h.respondError(w, r)
+		return
+	}
+	response := tokenResponse{Token: token, TokenType: "Bearer", ExpiresAt: userInfo.Expiry, Sub: userInfo.Sub, LegacyToken: h.legacyTokenField(legacyToken)}
+	var refreshInfo *model.UserInfo
+	if h.refreshTokensEnabled() {
+		info, refreshToken, err := h.issueRefreshToken(r, userInfo)
+		if err != nil {
+			logging.Application(r.Header).WithError(err).Error()
+			h.respondError(w, r)
+			return
 		}
-		if h.config.CookieDomain != "" {
-			cookie.Domain = h.config.CookieDomain
+		refreshInfo = &info
+		response.RefreshToken, response.RefreshExpiresAt = refreshToken, info.Expiry
+	}
+	h.recordSession(r, userInfo, refreshInfo)
+	encrypted, response, err := h.encryptTokens(response)
+	if err != nil {
+		logging.Application(r.Header).WithError(err).Error()
+		h.respondError(w, r)
+		return
+	}
+	token = encrypted.Token
+	var refreshCookie *http.Cookie
+	if encrypted.RefreshToken != "" {
+		refreshCookie = h.refreshTokenCookie(encrypted.RefreshToken, encrypted.RefreshExpiresAt)
+	}
+
+	defer startPhase(r.Context(), "write")()
+
+	embedded := h.isEmbedded(r)
+	if h.wantHTML(r) && !embedded {
+		cookie := h.tokenCookie(token, settings)
+		h.setCookie(w, cookie)
+		h.setLegacyCookie(w, cookie, legacyToken)
+		h.setTokenHeader(w, token)
+		if refreshCookie != nil {
+			h.setCookie(w, refreshCookie)
 		}
 
-		http.SetCookie(w, cookie)
+		if h.config.DebugTokenPage {
+			writeDebugTokenPage(w, userInfo, cookie, h.successURL(r))
+			return
+		}
 
-		w.Header().Set("Location", h.config.SuccessURL)
-		w.WriteHeader(303)
+		h.redirect(w, h.successURL(r))
 		return
 	}
 
-	w.Header().Set("Content-Type", contentTypeJWT)
-	w.WriteHeader(200)
-	fmt.Fprintf(w, "%s", token)
+	// embedded contexts get the token in the body, in case the cookie is blocked as third party cookie
+	if h.config.SetCookieForAPI || embedded {
+		cookie := h.tokenCookie(token, settings)
+		h.setCookie(w, cookie)
+		h.setLegacyCookie(w, cookie, legacyToken)
+		if refreshCookie != nil {
+			h.setCookie(w, refreshCookie)
+		}
+	}
+	h.respondToken(w, r, response)
+}
+
+// tokenCookie returns the cookie for the token with the configured attributes
+func (h *Handler) tokenCookie(token string, settings sessionSettings) *http.Cookie {
+	cookie := &http.Cookie{
+		Name:     h.config.CookieName,
+		Value:    token,
+		HttpOnly: h.config.CookieHTTPOnly,
+		Secure:   h.config.CookieSecure,
+		Path:     "/",
+	}
+	// without a cookie expiry, it is a session cookie
+	setCookieLifetime(cookie, settings.CookieExpiry)
+	if h.config.CookieDomain != "" {
+		cookie.Domain = h.config.CookieDomain
+	}
+	return cookie
+}
+
+func (h *Handler) createToken(userInfo model.UserInfo) (string, error) {
+	return h.createTokenWithContext(context.Background(), userInfo)
 }
 
-func (h *Handler) createToken(userInfo jwt.Claims) (string, error) {
-	token := jwt.NewWithClaims(jwt.SigningMethodHS512, userInfo)
-	return token.SignedString([]byte(h.config.JwtSecret))
+func (h *Handler) createTokenWithContext(ctx context.Context, userInfo model.UserInfo) (string, error) {
+	defer startPhase(ctx, "sign")()
+	return h.tokenService().issue(ctx, userInfo)
 }
 
+// GetToken returns the user info of the token and if it is valid.
+// Without a token, the token of the Authorization bearer header or else of the cookie is used.
 func (h *Handler) GetToken(r *http.Request, rtoken string) (userInfo model.UserInfo, valid bool) {
+	return h.snapshot().getToken(r, rtoken)
+}
+
+// VerifyToken returns the user info of the token or the reason, why it was rejected.
+// Without a token, the token of the Authorization bearer header or else of the cookie is used.
+func (h *Handler) VerifyToken(r *http.Request, rtoken string) (model.UserInfo, TokenFailure) {
+	return h.snapshot().verifyToken(r, rtoken)
+}
+
+func (h *Handler) getToken(r *http.Request, rtoken string) (userInfo model.UserInfo, valid bool) {
+	userInfo, failure := h.verifyToken(r, rtoken)
+	return userInfo, failure == ""
+}
+
+func (h *Handler) verifyToken(r *http.Request, rtoken string) (model.UserInfo, TokenFailure) {
+	return h.verifyTokenWith(r, rtoken, h.tokenService())
+}
+
+// verifyTokenWith verifies the token and its binding to the client certificate of the request.
+func (h *Handler) verifyTokenWith(r *http.Request, rtoken string, tokens *TokenService) (model.UserInfo, TokenFailure) {
+	u, failure := h.verifyTokenClaims(r, rtoken, tokens)
+	if failure != "" {
+		return u, failure
+	}
+	if err := VerifyCertificateBinding(r, u); err != nil {
+		logging.Application(r.Header).
+			WithField("username", u.Sub).
+			WithError(err).
+			Warn("rejected token presented without the bound client certificate")
+		return model.UserInfo{}, h.tokenFailures.add(TokenUnboundCertificate)
+	}
+	return u, ""
+}
+
+// verifyTokenClaims verifies the token without the proof of possession of a bound client certificate,
+// e.g. for the introspection, where the connection is the one of the resource server and not of the client.
+func (h *Handler) verifyTokenClaims(r *http.Request, rtoken string, tokens *TokenService) (model.UserInfo, TokenFailure) {
+	if rtoken == "" {
+		rtoken = bearerToken(r)
+	}
 	if rtoken == "" {
 		c, err := r.Cookie(h.config.CookieName)
 		if err != nil {
-			return model.UserInfo{}, false
+			return model.UserInfo{}, TokenMissing
 		}
 		rtoken = c.Value
 	}
 
-	token, err := jwt.ParseWithClaims(rtoken, &model.UserInfo{}, func(*jwt.Token) (interface{}, error) {
-		return []byte(h.config.JwtSecret), nil
-	})
+	rtoken, err := h.decryptToken(rtoken)
 	if err != nil {
-		return model.UserInfo{}, false
+		logging.Application(r.Header).
+			WithField("reason", TokenInvalid).
+			WithError(err).
+			Debug("rejected token")
+		return model.UserInfo{}, h.tokenFailures.add(TokenInvalid)
 	}
 
-	u, ok := token.Claims.(*model.UserInfo)
-	if !ok {
-		return model.UserInfo{}, false
+	u, err := tokens.Verify(rtoken)
+	if err == ErrForeignIssuer {
+		logging.Application(r.Header).
+			WithField("username", u.Sub).
+			WithField("issuer", u.Issuer).
+			Warn("rejected token issued by a foreign loginsrv instance")
+		return model.UserInfo{}, h.tokenFailures.add(TokenForeignIssuer)
+	}
+	if err == ErrForeignAudience {
+		logging.Application(r.Header).
+			WithField("username", u.Sub).
+			WithField("audience", u.Audience).
+			Warn("rejected token issued for a foreign audience")
+		return model.UserInfo{}, h.tokenFailures.add(TokenForeignAudience)
+	}
+	if err == ErrWrongTokenType {
+		logging.Application(r.Header).
+			WithField("username", u.Sub).
+			WithField("token_type", u.TokenType).
+			Warn("rejected token of the wrong type")
+		return model.UserInfo{}, h.tokenFailures.add(TokenWrongType)
+	}
+	if err == ErrTokenRevoked {
+		entry := logging.Application(r.Header).
+			WithField("username", u.Sub).
+			WithField("jti", u.ID)
+		if u.IdPSession != nil {
+			entry = entry.WithField("issuer", u.IdPSession.Issuer)
+		}
+		entry.Info("rejected revoked token")
+		return model.UserInfo{}, h.tokenFailures.add(TokenRevoked)
+	}
+	if _, unknown := err.(*revocationCheckError); unknown {
+		logging.Application(r.Header).WithError(err).Error()
+		return model.UserInfo{}, h.tokenFailures.add(TokenInvalid)
+	}
+	if err != nil {
+		failure := h.tokenFailures.add(tokenFailureOf(err))
+		logging.Application(r.Header).
+			WithField("reason", failure).
+			WithError(err).
+			Debug("rejected token")
+		return model.UserInfo{}, failure
 	}
 
-	return *u, u.Valid() == nil
+	return u, ""
+}
+
+// bearerToken returns the token of the Authorization header with the bearer scheme (RFC 6750).
+// Other schemes and malformed headers are treated like a missing token.
+func bearerToken(r *http.Request) string {
+	parts := strings.Fields(r.Header.Get("Authorization"))
+	if len(parts) != 2 || !strings.EqualFold(parts[0], "Bearer") {
+		return ""
+	}
+	return parts[1]
 }
 
 func (h *Handler) respondError(w http.ResponseWriter, r *http.Request) {
-	if wantHTML(r) {
+	if h.wantHTML(r) {
 		username, _, _, _ := getCredentials(r)
 		writeLoginForm(w,
 			loginFormData{
 				Error:    true,
-				Config:   h.config,
+				Config:   h.formConfig(),
+				Language: formLanguage(r),
 				UserInfo: model.UserInfo{Sub: username},
 			})
 		return
 	}
-	w.Header().Set("Content-Type", contentTypePlain)
-	w.WriteHeader(500)
-	fmt.Fprintf(w, "Internal Server Error")
+	h.respondAPIError(w, r, errAPIInternal)
 }
 
 func (h *Handler) respondBadRequest(w http.ResponseWriter, r *http.Request) {
-	w.WriteHeader(400)
-	fmt.Fprintf(w, "Bad Request: Method or content-type not supported")
+	h.respondAPIError(w, r, errAPIBadRequest)
 }
 
 func (h *Handler) respondNotFound(w http.ResponseWriter, r *http.Request) {
-	w.WriteHeader(404)
-	fmt.Fprintf(w, "Not Found: The requested page does not exist")
+	h.respondAPIError(w, r, errAPINotFound)
 }
 
 func (h *Handler) respondMaxRefreshesReached(w http.ResponseWriter, r *http.Request) {
-	w.WriteHeader(403)
-	fmt.Fprint(w, "Max JWT refreshes reached")
+	h.respondAPIError(w, r, errAPIMaxRefreshes)
+}
+
+func (h *Handler) respondMaxSessionReached(w http.ResponseWriter, r *http.Request) {
+	h.respondAPIError(w, r, errAPIMaxSession)
+}
+
+func (h *Handler) respondNotRefreshable(w http.ResponseWriter, r *http.Request) {
+	h.respondAPIError(w, r, errAPINotRefreshable)
 }
 
 func (h *Handler) respondAuthFailure(w http.ResponseWriter, r *http.Request) {
-	if wantHTML(r) {
-		w.Header().Set("Content-Type", contentTypeHTML)
-		w.WriteHeader(403)
+	if h.wantHTML(r) {
 		username, _, _, _ := getCredentials(r)
 		writeLoginForm(w,
 			loginFormData{
 				Failure:  true,
-				Config:   h.config,
+				Config:   h.formConfig(),
+				Language: formLanguage(r),
 				UserInfo: model.UserInfo{Sub: username},
+				status:   403,
 			})
 		return
 	}
 
+	h.respondAPIError(w, r, errAPIWrongCredentials)
+}
+
+// statusClientClosedRequest is the non standard status code used by nginx,
+// for requests, which were given up by the client before the response was sent.
+const statusClientClosedRequest = 499
+
+// respondAborted answers requests, which were aborted during the authentication,
+// either by the -max-request-duration or by the client.
+func (h *Handler) respondAborted(w http.ResponseWriter, r *http.Request, username string) {
+	if requestTimedOut(r) {
+		h.respondRequestTimeout(w, r, username)
+		return
+	}
+	h.respondClientClosedRequest(w, r, username)
+}
+
+// respondClientClosedRequest answers requests, which were canceled during the authentication.
+// The client will most likely never see the response, so this is mainly for the access log.
+func (h *Handler) respondClientClosedRequest(w http.ResponseWriter, r *http.Request, username string) {
+	atomic.AddInt64(&h.abortedRequests.clientClosed, 1)
+	logging.Application(r.Header).
+		WithField("username", username).Info("authentication aborted, client closed request")
+	h.respondAPIError(w, r, errAPIClientClosedRequest)
+}
+
+func isContextError(err error) bool {
+	return errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded)
+}
+
+func respondInternalError(w http.ResponseWriter) {
+	writeText(w, 500, "Internal Server Error")
+}
+
+// writeText writes a plain text response with the status code
+func writeText(w http.ResponseWriter, statusCode int, text string) {
 	w.Header().Set("Content-Type", contentTypePlain)
-	w.WriteHeader(403)
-	fmt.Fprintf(w, "Wrong credentials")
+	w.WriteHeader(statusCode)
+	fmt.Fprint(w, text)
 }
 
-func wantHTML(r *http.Request) bool {
-	return strings.Contains(r.Header.Get("Accept"), "text/html")
+// hasMediaType checks the media type of the content type, ignoring parameters like the charset
+func hasMediaType(r *http.Request, mediaType string) bool {
+	t, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return err == nil && t == mediaType
 }
 
+// the maximum size of json and multipart login requests held in memory
+const maxCredentialsBodySize = 1 << 20
+
 func getCredentials(r *http.Request) (string, string, string, error) {
-	if r.Header.Get("Content-Type") == "application/json" {
-		m := map[string]string{}
-		body, err := ioutil.ReadAll(r.Body)
+	if hasMediaType(r, "application/json") {
+		if r.Body == nil {
+			return "", "", "", errors.New("missing request body")
+		}
+		body, err := ioutil.ReadAll(io.LimitReader(r.Body, maxCredentialsBodySize+1))
 		if err != nil {
 			return "", "", "", err
 		}
+		// the credentials may be read again, e.g. for showing the username on the login form
+		r.Body = ioutil.NopCloser(bytes.NewReader(body))
+		if len(body) > maxCredentialsBodySize {
+			return "", "", "", errors.New("request body too large")
+		}
+
+		m := map[string]string{}
 		err = json.Unmarshal(body, &m)
 		if err != nil {
 			return "", "", "", err
@@ -357,12 +1329,17 @@ func getCredentials(r *http.Request) (string, string, string, error) {
 }
 
 func (h *Handler) authenticate(username, password string) (bool, model.UserInfo, error) {
-	for _, b := range h.backends {
+	backends, names, username := h.backendsFor(username)
+	backends, names = h.enabledBackends(backends, names)
+	for i, b := range backends {
 		authenticated, userInfo, err := b.Authenticate(username, password)
 		if err != nil {
 			return false, model.UserInfo{}, err
 		}
 		if authenticated {
+			if userInfo.Origin == "" && i < len(names) {
+				userInfo.Origin = names[i]
+			}
 			return authenticated, userInfo, nil
 		}
 	}
@@ -370,18 +1347,57 @@ func (h *Handler) authenticate(username, password string) (bool, model.UserInfo,
 }
 
 func (h *Handler) authenticateWithContext(ctx context.Context, username, password string) (bool, model.UserInfo, error) {
-	for _, b := range h.backends {
+	backends, names, username := h.backendsFor(username)
+	backends, names = h.enabledBackends(backends, names)
+	// with break-glass accounts, the backends after a failed one are asked as well,
+	// to know if every backend is down. A failed backend still fails the login.
+	var backendErr error
+	answered := false
+	for i, b := range backends {
+		// do not waste backend calls for requests, which were given up
+		if err := ctx.Err(); err != nil {
+			return false, model.UserInfo{}, err
+		}
+		endBackend := startPhase(ctx, "backend:"+backendName(names, i))
 		authenticated, userInfo, err := b.AuthenticateWithContext(ctx, username, password)
+		endBackend()
 		if err != nil {
-			return false, model.UserInfo{}, err
+			if ctx.Err() != nil {
+				// the backend call failed, because the request was given up
+				return false, model.UserInfo{}, ctx.Err()
+			}
+			if h.breakGlass == nil {
+				return false, model.UserInfo{}, err
+			}
+			if backendErr == nil {
+				backendErr = err
+			}
+			continue
 		}
-		if authenticated {
+		answered = true
+		if authenticated && backendErr == nil {
+			if userInfo.Origin == "" && i < len(names) {
+				userInfo.Origin = names[i]
+			}
 			return authenticated, userInfo, nil
 		}
 	}
+	if backendErr != nil {
+		if answered {
+			return false, model.UserInfo{}, backendErr
+		}
+		return h.breakGlassLogin(username, password, backendErr)
+	}
 	return false, model.UserInfo{}, nil
 }
 
+func backendName(names []string, i int) string {
+	if i < len(names) {
+		return names[i]
+	}
+	return "unknown"
+}
+
 type oauthManager interface {
 	Handle(w http.ResponseWriter, r *http.Request) (
 		startedFlow bool,
@@ -390,4 +1406,5 @@ type oauthManager interface {
 		err error)
 	AddConfig(providerName string, opts map[string]string) error
 	GetConfigFromRequest(r *http.Request) (oauth2.Config, error)
+	Validate() map[string]error
 }
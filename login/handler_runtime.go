@@ -0,0 +1,144 @@
+package login
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/tarent/loginsrv/clockskew"
+	"github.com/tarent/loginsrv/outboundtls"
+)
+
+// handlerRuntime is the state shared by all snapshots of a handler.
+// It lives as long as the handler and is not replaced by a reload.
+type handlerRuntime struct {
+	// current is the *Handler with the latest configuration
+	current atomic.Value
+
+	conflicts     map[string][]string
+	conflictCheck conflictCheckState
+	muConflicts   sync.RWMutex
+	// conflictChecks are the conflict checks running in the background
+	conflictChecks sync.WaitGroup
+
+	maintenance      bool
+	maintenanceUntil time.Time
+	muMaintenance    sync.RWMutex
+
+	store *ttlStore
+
+	// state keeps the revocations, failed logins and sessions, in the store or in the -store-file
+	state Store
+
+	events *eventBus
+
+	flowMetrics   *oauthFlowMetrics
+	muFlowMetrics sync.Mutex
+
+	// skew measures the clocks of the providers and backends
+	skew *clockskew.Monitor
+
+	failures *loginFailures
+
+	tokenFailures *tokenFailureCounts
+
+	abortedRequests *abortedRequestCounts
+
+	shadows *shadowRunner
+
+	revocations RevocationStore
+	// muRotations serializes the rotations of refresh tokens, so that each is used only once
+	muRotations sync.Mutex
+
+	readiness *readinessMonitor
+
+	// risk assesses the logins, if configured
+	risk RiskAssessor
+
+	// registry provides the backends
+	registry *ProviderRegistry
+}
+
+// newHandlerRuntime creates the runtime. All namespaces of the store are registered here,
+// before a snapshot of the -state-file is loaded, which drops the entries of unregistered namespaces.
+func newHandlerRuntime() *handlerRuntime {
+	store := newTTLStore()
+	store.registerNamespace(oauthCallbackNamespace, oauthCallbackNamespaceVersion)
+	store.registerNamespace(oauthFlowNamespace, oauthFlowNamespaceVersion)
+	store.registerNamespace(disabledProvidersNamespace, disabledProvidersNamespaceVersion)
+	store.registerNamespace(clientRateNamespace, clientRateNamespaceVersion)
+	store.registerNamespace(sessionsNamespace, sessionsNamespaceVersion)
+	store.registerNamespace(frontChannelLogoutRateNamespace, frontChannelLogoutRateNamespaceVersion)
+	store.registerNamespace(loginFailureNamespace, loginFailureNamespaceVersion)
+	store.registerNamespace(revocationNamespace, revocationNamespaceVersion)
+	rt := &handlerRuntime{
+		store:  store,
+		events: newEventBus(),
+		skew:   clockskew.Default,
+
+		tokenFailures:   newTokenFailureCounts(),
+		abortedRequests: &abortedRequestCounts{},
+		shadows:         newShadowRunner(),
+		readiness:       newReadinessMonitor(),
+		registry:        DefaultRegistry,
+	}
+	rt.useStore(memoryStore{store})
+	return rt
+}
+
+// useStore keeps the revocations, failed logins and sessions in the store.
+// Revocations of a store set by WithRevocationStore stay there.
+func (rt *handlerRuntime) useStore(store Store) {
+	rt.state = store
+	rt.failures = newLoginFailures(store)
+	if _, own := rt.revocations.(*storeRevocations); own || rt.revocations == nil {
+		rt.revocations = newStoreRevocations(store)
+	}
+}
+
+// oauthFlowMetrics returns the flow metrics, which are created with the first oauth configuration
+func (rt *handlerRuntime) oauthFlowMetrics() *oauthFlowMetrics {
+	rt.muFlowMetrics.Lock()
+	defer rt.muFlowMetrics.Unlock()
+	if rt.flowMetrics == nil {
+		rt.flowMetrics = newOauthFlowMetrics(rt.store)
+	}
+	return rt.flowMetrics
+}
+
+// configuredFlowMetrics returns the flow metrics or nil, if no oauth provider was configured yet
+func (rt *handlerRuntime) configuredFlowMetrics() *oauthFlowMetrics {
+	rt.muFlowMetrics.Lock()
+	defer rt.muFlowMetrics.Unlock()
+	return rt.flowMetrics
+}
+
+// snapshot returns the handler with the latest configuration.
+// A request takes one snapshot at its entry and uses it throughout,
+// so that it never sees a mix of two configurations.
+// Handlers, which were not created by NewHandler, are their own snapshot.
+func (h *Handler) snapshot() *Handler {
+	if current, ok := h.current.Load().(*Handler); ok {
+		return current
+	}
+	return h
+}
+
+// reload replaces the configuration of the handler. Requests in progress
+// finish with their snapshot, new requests use the new configuration.
+// On an invalid configuration, the current one is kept.
+func (h *Handler) reload(config *Config) error {
+	outbound, err := newOutboundTLS(config)
+	if err != nil {
+		return err
+	}
+	next, err := h.handlerRuntime.newSnapshot(config)
+	if err != nil {
+		return err
+	}
+	outboundtls.Configure(outbound)
+	h.current.Store(next)
+	h.skew.SetThreshold(config.ClockSkewThreshold)
+	next.startUsernameConflictCheck()
+	return nil
+}
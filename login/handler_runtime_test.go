@@ -0,0 +1,77 @@
+package login
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	. "github.com/stretchr/testify/assert"
+)
+
+func TestHandler_Reload(t *testing.T) {
+	cfg := testConfig()
+	cfg.Backends = Options{"simple": {"bob": "secret"}}
+	h, err := NewHandler(cfg)
+	NoError(t, err)
+
+	recorder := callHandler(h, req("POST", "/context/login", `{"username": "bob", "password": "secret"}`, TypeJSON, AcceptJwt))
+	Equal(t, 200, recorder.Code)
+
+	next := testConfig()
+	next.LoginPath = "/auth"
+	next.Backends = Options{"simple": {"alice": "secret"}}
+	NoError(t, h.reload(next))
+
+	True(t, h.IsLoginPath("/auth"))
+	False(t, h.IsLoginPath("/context/login"))
+
+	recorder = callHandler(h, req("POST", "/auth", `{"username": "alice", "password": "secret"}`, TypeJSON, AcceptJwt))
+	Equal(t, 200, recorder.Code)
+	recorder = callHandler(h, req("POST", "/auth", `{"username": "bob", "password": "secret"}`, TypeJSON, AcceptJwt))
+	Equal(t, 403, recorder.Code)
+
+	// an invalid configuration keeps the current one
+	invalid := testConfig()
+	invalid.Backends = Options{"simpleFoo": {"bob": "secret"}}
+	Error(t, h.reload(invalid))
+	True(t, h.IsLoginPath("/auth"))
+}
+
+// A request has to see one configuration only, while the configuration is swapped concurrently.
+// Run with -race.
+func TestHandler_Reload_Concurrent(t *testing.T) {
+	configFor := func(user string) *Config {
+		cfg := testConfig()
+		cfg.Backends = Options{"simple": {user: "secret"}}
+		return cfg
+	}
+	h, err := NewHandler(configFor("user0"))
+	NoError(t, err)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				body := fmt.Sprintf(`{"username": "user%v", "password": "secret"}`, j%2)
+				recorder := callHandler(h, req("POST", "/context/login", body, TypeJSON, AcceptJwt))
+				True(t, recorder.Code == http.StatusOK || recorder.Code == http.StatusForbidden)
+				h.GetToken(req("GET", "/context/login", ""), recorder.Body.String())
+			}
+		}(i)
+	}
+	for j := 0; j < 50; j++ {
+		NoError(t, h.reload(configFor(fmt.Sprintf("user%v", j%2))))
+		Equal(t, 200, callHandler(h, req("GET", "/context/login/health", "")).Code)
+	}
+	wg.Wait()
+}
+
+func callHandler(h *Handler, req *http.Request) *httptest.ResponseRecorder {
+	recorder := httptest.NewRecorder()
+	h.ServeHTTP(recorder, req)
+	return recorder
+}
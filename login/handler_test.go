@@ -1,6 +1,7 @@
 package login
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"github.com/dgrijalva/jwt-go"
@@ -39,6 +40,7 @@ func TestHandler_NewFromConfig(t *testing.T) {
 	}{
 		{
 			&Config{
+				JwtSecret: "secret",
 				Backends: Options{
 					"simple": {"bob": "secret"},
 				},
@@ -51,7 +53,7 @@ func TestHandler_NewFromConfig(t *testing.T) {
 			false,
 		},
 		{
-			&Config{Backends: Options{"simple": {"bob": "secret"}}},
+			&Config{JwtSecret: "secret", Backends: Options{"simple": {"bob": "secret"}}},
 			1,
 			0,
 			false,
@@ -148,8 +150,9 @@ func TestHandler_HandleOauth(t *testing.T) {
 		},
 	}
 	handler := &Handler{
-		oauth:  managerMock,
-		config: DefaultConfig(),
+		handlerRuntime: newHandlerRuntime(),
+		oauth:          managerMock,
+		config:         DefaultConfig(),
 	}
 
 	// test start flow redirect
@@ -208,6 +211,29 @@ func TestHandler_HandleOauth(t *testing.T) {
 	Equal(t, 403, recorder.Code)
 }
 
+func TestHandler_CheckOauthProviders(t *testing.T) {
+	managerMock := &oauth2ManagerMock{
+		_Validate: func() map[string]error {
+			return map[string]error{"github": nil, "google": nil}
+		},
+	}
+	handler := &Handler{
+		handlerRuntime: newHandlerRuntime(),
+		oauth:          managerMock,
+		config:         DefaultConfig(),
+	}
+	NoError(t, handler.CheckOauthProviders())
+
+	managerMock._Validate = func() map[string]error {
+		return map[string]error{
+			"github":    nil,
+			"google":    errors.New("token endpoint not reachable"),
+			"bitbucket": errors.New("missing client_id"),
+		}
+	}
+	EqualError(t, handler.CheckOauthProviders(), "oauth provider check failed for: bitbucket, google")
+}
+
 func TestHandler_LoginWeb(t *testing.T) {
 	// redirectSuccess
 	recorder := call(req("POST", "/context/login", "username=bob&password=secret", TypeForm, AcceptHTML))
@@ -268,6 +294,24 @@ func TestHandler_Refresh(t *testing.T) {
 	InDelta(t, time.Now().Add(DefaultConfig().JwtExpiry).Unix(), claims["exp"], 2)
 }
 
+func TestHandler_Refresh_KeepsIssuer(t *testing.T) {
+	h := testHandler()
+	h.config.InstanceID = "sso"
+	token, err := h.createToken(model.UserInfo{Sub: "bob", Expiry: time.Now().Add(time.Second).Unix()})
+	NoError(t, err)
+
+	recorder := httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("POST", "/context/login", "", AcceptHTML, "Cookie: "+h.config.CookieName+"="+token+";"))
+	Equal(t, 303, recorder.Code)
+
+	setCookieList := readSetCookies(recorder.Header())
+	Equal(t, 1, len(setCookieList))
+	claims, err := tokenAsMap(setCookieList[0].Value)
+	NoError(t, err)
+	Equal(t, "sso", claims["iss"])
+	Equal(t, float64(1), claims["refs"])
+}
+
 func TestHandler_Refresh_Expired(t *testing.T) {
 	h := testHandler()
 	input := model.UserInfo{Sub: "bob", Expiry: time.Now().Unix() - 1}
@@ -285,6 +329,126 @@ func TestHandler_Refresh_Expired(t *testing.T) {
 	Equal(t, 0, len(setCookieList))
 }
 
+func TestHandler_Refresh_Grace(t *testing.T) {
+	h := testHandler()
+	h.config.JwtRefreshGrace = 5 * time.Minute
+	refresh := func(expiry time.Time, refreshes int) int {
+		token, err := h.createToken(model.UserInfo{Sub: "bob", Expiry: expiry.Unix(), Refreshes: refreshes})
+		NoError(t, err)
+		recorder := httptest.NewRecorder()
+		h.ServeHTTP(recorder, req("POST", "/context/login", "", AcceptJwt, "Cookie: "+h.config.CookieName+"="+token+";"))
+		return recorder.Code
+	}
+
+	// just inside of the grace period
+	Equal(t, 200, refresh(time.Now().Add(-5*time.Minute+2*time.Second), 0))
+	// just outside of the grace period
+	Equal(t, 400, refresh(time.Now().Add(-5*time.Minute-2*time.Second), 0))
+	// the refresh limit still applies
+	Equal(t, 403, refresh(time.Now().Add(-time.Minute), 1))
+
+	// other requests reject the expired token
+	token, err := h.createToken(model.UserInfo{Sub: "bob", Expiry: time.Now().Add(-time.Minute).Unix()})
+	NoError(t, err)
+	_, valid := h.getToken(req("GET", "/context/login", ""), token)
+	False(t, valid)
+	_, failure := h.verifyToken(req("GET", "/context/login", ""), token)
+	Equal(t, TokenExpired, failure)
+}
+
+func TestHandler_Refresh_MaxSession(t *testing.T) {
+	h := testHandler()
+	h.config.JwtRefreshes = 100
+	h.config.JwtMaxSession = time.Hour
+	refresh := func(userInfo model.UserInfo) *httptest.ResponseRecorder {
+		token, err := h.createToken(userInfo)
+		NoError(t, err)
+		recorder := httptest.NewRecorder()
+		h.ServeHTTP(recorder, req("POST", "/context/login", "", AcceptJwt, "Cookie: "+h.config.CookieName+"="+token+";"))
+		return recorder
+	}
+
+	// the login starts the session
+	recorder := httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("POST", "/context/login", "username=bob&password=secret", TypeForm, AcceptJwt))
+	Equal(t, 200, recorder.Code)
+	claims, err := tokenAsMap(recorder.Body.String())
+	NoError(t, err)
+	InDelta(t, time.Now().Unix(), claims["origin_iat"], 2)
+
+	// refreshes keep the session start and never expire after the session
+	start := time.Now().Add(-50 * time.Minute).Unix()
+	recorder = refresh(model.UserInfo{Sub: "bob", Expiry: time.Now().Add(time.Minute).Unix(), SessionStart: start})
+	Equal(t, 200, recorder.Code)
+	claims, err = tokenAsMap(recorder.Body.String())
+	NoError(t, err)
+	Equal(t, float64(start), claims["origin_iat"])
+	Equal(t, float64(start+3600), claims["exp"])
+
+	// refreshes after the session lifetime are rejected
+	recorder = refresh(model.UserInfo{Sub: "bob", Expiry: time.Now().Add(time.Minute).Unix(), SessionStart: time.Now().Add(-61 * time.Minute).Unix()})
+	Equal(t, 403, recorder.Code)
+	Equal(t, "Max JWT session lifetime reached", recorder.Body.String())
+}
+
+func TestHandler_SlidingExpiry(t *testing.T) {
+	h := testHandler()
+	h.config.JwtSlidingExpiry = true
+	h.config.JwtMaxSession = 2 * time.Hour
+	h.config.JwtExpiry = 10 * time.Minute
+	serve := func(method string, userInfo model.UserInfo, header ...string) *httptest.ResponseRecorder {
+		token, err := h.createToken(userInfo)
+		NoError(t, err)
+		recorder := httptest.NewRecorder()
+		h.ServeHTTP(recorder, req(method, "/context/login", "", append(header, "Cookie: "+h.config.CookieName+"="+token)...))
+		return recorder
+	}
+	start := time.Now().Add(-time.Hour).Unix()
+
+	// refreshes are not counted
+	recorder := serve("POST", model.UserInfo{Sub: "bob", Expiry: time.Now().Add(time.Minute).Unix(), Refreshes: 5, SessionStart: start}, AcceptJwt)
+	Equal(t, 200, recorder.Code)
+	claims, err := tokenAsMap(recorder.Body.String())
+	NoError(t, err)
+	Equal(t, float64(5), claims["refs"])
+	InDelta(t, time.Now().Add(10*time.Minute).Unix(), claims["exp"], 2)
+
+	// an authenticated GET renews the cookie
+	recorder = serve("GET", model.UserInfo{Sub: "bob", Expiry: time.Now().Add(time.Minute).Unix(), SessionStart: start}, AcceptHTML)
+	Equal(t, 200, recorder.Code)
+	cookies := readSetCookies(recorder.Header())
+	Equal(t, 1, len(cookies))
+	claims, err = tokenAsMap(cookies[0].Value)
+	NoError(t, err)
+	InDelta(t, time.Now().Add(10*time.Minute).Unix(), claims["exp"], 2)
+	Equal(t, float64(start), claims["origin_iat"])
+
+	// the expiry is bounded by the session
+	recorder = serve("GET", model.UserInfo{Sub: "bob", Expiry: time.Now().Add(time.Minute).Unix(), SessionStart: start - 3000}, AcceptHTML)
+	claims, err = tokenAsMap(readSetCookies(recorder.Header())[0].Value)
+	NoError(t, err)
+	Equal(t, float64(start-3000+7200), claims["exp"])
+
+	// not after the end of the session
+	recorder = serve("GET", model.UserInfo{Sub: "bob", Expiry: time.Now().Add(time.Minute).Unix(), SessionStart: time.Now().Add(-3 * time.Hour).Unix()}, AcceptHTML)
+	Equal(t, 0, len(readSetCookies(recorder.Header())))
+	recorder = serve("POST", model.UserInfo{Sub: "bob", Expiry: time.Now().Add(time.Minute).Unix(), SessionStart: time.Now().Add(-3 * time.Hour).Unix()}, AcceptJwt)
+	Equal(t, 403, recorder.Code)
+
+	// without sliding expiry, a GET does not renew the cookie
+	h.config.JwtSlidingExpiry = false
+	recorder = serve("GET", model.UserInfo{Sub: "bob", Expiry: time.Now().Add(time.Minute).Unix(), SessionStart: start}, AcceptHTML)
+	Equal(t, 0, len(readSetCookies(recorder.Header())))
+}
+
+func TestHandler_SlidingExpiry_RequiresMaxSession(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Backends = Options{"simple": {"bob": "secret"}}
+	cfg.JwtSlidingExpiry = true
+	_, err := NewHandler(cfg)
+	EqualError(t, err, "A sliding jwt expiry requires a -jwt-max-session")
+}
+
 func TestHandler_Refresh_Invalid_Token(t *testing.T) {
 	h := testHandler()
 
@@ -317,6 +481,52 @@ func TestHandler_Refresh_Max_Refreshes_Reached(t *testing.T) {
 	Equal(t, 0, len(setCookieList))
 }
 
+func TestHandler_Refresh_NotRefreshable(t *testing.T) {
+	h := testHandler()
+	input := model.UserInfo{Sub: "svc-deploy", Expiry: time.Now().Add(time.Second).Unix(), NoRefresh: true}
+	token, err := h.createToken(input)
+	NoError(t, err)
+
+	cookieStr := "Cookie: " + h.config.CookieName + "=" + token + ";"
+
+	recorder := call(req("POST", "/context/login", "", AcceptJwt, cookieStr))
+	Equal(t, 403, recorder.Code)
+	Contains(t, recorder.Body.String(), "not refreshable")
+	Equal(t, 0, len(readSetCookies(recorder.Header())))
+}
+
+func TestHandler_BackendExpiry(t *testing.T) {
+	h := testHandler()
+	h.backends = []Backend{expiryTestBackend(time.Minute)}
+
+	recorder := httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("POST", "/context/login", "username=svc&password=secret", TypeForm, AcceptJwt))
+	Equal(t, 200, recorder.Code)
+
+	claims, err := tokenAsMap(recorder.Body.String())
+	NoError(t, err)
+	InDelta(t, time.Now().Add(time.Minute).Unix(), claims["exp"], 2)
+	Equal(t, true, claims["norefresh"])
+
+	// the expiry of the backend can not extend the configured one
+	h.backends = []Backend{expiryTestBackend(48 * time.Hour)}
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("POST", "/context/login", "username=other-svc&password=secret", TypeForm, AcceptJwt))
+	claims, err = tokenAsMap(recorder.Body.String())
+	NoError(t, err)
+	InDelta(t, time.Now().Add(h.config.JwtExpiry).Unix(), claims["exp"], 2)
+}
+
+type expiryTestBackend time.Duration
+
+func (b expiryTestBackend) Authenticate(username, password string) (bool, model.UserInfo, error) {
+	return true, model.UserInfo{Sub: username, NoRefresh: true, Expiry: time.Now().Add(time.Duration(b)).Unix()}, nil
+}
+
+func (b expiryTestBackend) AuthenticateWithContext(ctx context.Context, username, password string) (bool, model.UserInfo, error) {
+	return b.Authenticate(username, password)
+}
+
 func TestHandler_Logout(t *testing.T) {
 	// DELETE
 	recorder := call(req("DELETE", "/context/login", ""))
@@ -347,12 +557,71 @@ func checkDeleteCookei(t *testing.T, h http.Header) {
 	Equal(t, int64(0), cookie.Expires.Unix())
 }
 
+func TestHandler_SetCookieForAPI(t *testing.T) {
+	testCases := []struct {
+		setCookieForAPI bool
+		accept          string
+		expectCookie    bool
+		expectBody      bool
+	}{
+		{false, AcceptHTML, true, false},
+		{false, AcceptJwt, false, true},
+		{true, AcceptHTML, true, false},
+		{true, AcceptJwt, true, true},
+	}
+	for _, test := range testCases {
+		t.Run(fmt.Sprintf("%v %v", test.setCookieForAPI, test.accept), func(t *testing.T) {
+			h := testHandler()
+			h.config.SetCookieForAPI = test.setCookieForAPI
+
+			recorder := httptest.NewRecorder()
+			h.ServeHTTP(recorder, req("POST", "/context/login", "username=bob&password=secret", TypeForm, test.accept))
+
+			setCookieList := readSetCookies(recorder.Header())
+			if test.expectCookie {
+				Equal(t, 1, len(setCookieList))
+				cookie := setCookieList[0]
+				Equal(t, "jwt_token", cookie.Name)
+				Equal(t, "/", cookie.Path)
+				Equal(t, "example.com", cookie.Domain)
+				InDelta(t, time.Now().Add(testConfig().CookieExpiry).Unix(), cookie.Expires.Unix(), 2)
+				True(t, cookie.HttpOnly)
+				claims, err := tokenAsMap(cookie.Value)
+				NoError(t, err)
+				Equal(t, "bob", claims["sub"])
+			} else {
+				Equal(t, 0, len(setCookieList))
+			}
+
+			if test.expectBody {
+				Equal(t, 200, recorder.Code)
+				Equal(t, contentTypeJWT, recorder.Header().Get("Content-Type"))
+				if test.expectCookie {
+					Equal(t, setCookieList[0].Value, recorder.Body.String())
+				}
+				claims, err := tokenAsMap(recorder.Body.String())
+				NoError(t, err)
+				Equal(t, "bob", claims["sub"])
+			} else {
+				Equal(t, 303, recorder.Code)
+				Equal(t, "", recorder.Body.String())
+			}
+
+			// the logout clears the cookie for all clients
+			recorder = httptest.NewRecorder()
+			h.ServeHTTP(recorder, req("DELETE", "/context/login", "", test.accept))
+			checkDeleteCookei(t, recorder.Header())
+		})
+	}
+}
+
 func TestHandler_CustomLogoutURL(t *testing.T) {
 	cfg := DefaultConfig()
 	cfg.LogoutURL = "http://example.com"
 	h := &Handler{
-		oauth:  oauth2.NewManager(),
-		config: cfg,
+		handlerRuntime: newHandlerRuntime(),
+		oauth:          oauth2.NewManager(),
+		config:         cfg,
 	}
 
 	recorder := httptest.NewRecorder()
@@ -371,7 +640,7 @@ func TestHandler_LoginError(t *testing.T) {
 	h.ServeHTTP(recorder, request)
 
 	Equal(t, 500, recorder.Code)
-	Equal(t, recorder.Header().Get("Content-Type"), "text/plain")
+	Equal(t, recorder.Header().Get("Content-Type"), "text/plain; charset=utf-8")
 	Equal(t, recorder.Body.String(), "Internal Server Error")
 
 	// backend returning an error with result type == html
@@ -393,8 +662,10 @@ func TestHandler_getToken_Valid(t *testing.T) {
 	r := &http.Request{
 		Header: http.Header{"Cookie": {h.config.CookieName + "=" + token + ";"}},
 	}
-	userInfo, valid := h.GetToken(r)
+	userInfo, valid := h.GetToken(r, "")
 	True(t, valid)
+	InDelta(t, time.Now().Unix(), userInfo.IssuedAt, 2)
+	userInfo.IssuedAt = 0
 	Equal(t, input, userInfo)
 }
 
@@ -410,7 +681,7 @@ func TestHandler_getToken_InvalidSecret(t *testing.T) {
 	// modify secret
 	h.config.JwtSecret = "foobar"
 
-	_, valid := h.GetToken(r)
+	_, valid := h.GetToken(r, "")
 	False(t, valid)
 }
 
@@ -420,18 +691,19 @@ func TestHandler_getToken_InvalidToken(t *testing.T) {
 		Header: http.Header{"Cookie": {h.config.CookieName + "=asdcsadcsadc"}},
 	}
 
-	_, valid := h.GetToken(r)
+	_, valid := h.GetToken(r, "")
 	False(t, valid)
 }
 
 func TestHandler_getToken_InvalidNoToken(t *testing.T) {
 	h := testHandler()
-	_, valid := h.GetToken(&http.Request{})
+	_, valid := h.GetToken(&http.Request{}, "")
 	False(t, valid)
 }
 
 func testHandler() *Handler {
 	return &Handler{
+		handlerRuntime: newHandlerRuntime(),
 		backends: []Backend{
 			NewSimpleBackend(map[string]string{"bob": "secret"}),
 		},
@@ -442,6 +714,7 @@ func testHandler() *Handler {
 
 func testHandlerWithError() *Handler {
 	return &Handler{
+		handlerRuntime: newHandlerRuntime(),
 		backends: []Backend{
 			errorTestBackend("test error"),
 		},
@@ -490,6 +763,10 @@ func (h errorTestBackend) Authenticate(username, password string) (bool, model.U
 	return false, model.UserInfo{}, errors.New(string(h))
 }
 
+func (h errorTestBackend) AuthenticateWithContext(ctx context.Context, username, password string) (bool, model.UserInfo, error) {
+	return h.Authenticate(username, password)
+}
+
 type oauth2ManagerMock struct {
 	_Handle func(w http.ResponseWriter, r *http.Request) (
 		startedFlow bool,
@@ -498,6 +775,7 @@ type oauth2ManagerMock struct {
 		err error)
 	_AddConfig            func(providerName string, opts map[string]string) error
 	_GetConfigFromRequest func(r *http.Request) (oauth2.Config, error)
+	_Validate             func() map[string]error
 }
 
 func (m *oauth2ManagerMock) Handle(w http.ResponseWriter, r *http.Request) (
@@ -513,6 +791,9 @@ func (m *oauth2ManagerMock) AddConfig(providerName string, opts map[string]strin
 func (m *oauth2ManagerMock) GetConfigFromRequest(r *http.Request) (oauth2.Config, error) {
 	return m._GetConfigFromRequest(r)
 }
+func (m *oauth2ManagerMock) Validate() map[string]error {
+	return m._Validate()
+}
 
 // copied from golang: net/http/cookie.go
 // with some simplifications for edge cases
@@ -596,3 +877,77 @@ func readCookiesParts(c *http.Cookie, parts []string) {
 		c.Unparsed = append(c.Unparsed, parts[i])
 	}
 }
+
+func TestHandler_getToken_InstanceID(t *testing.T) {
+	staging := testHandler()
+	staging.config.InstanceID = "staging"
+	prod := testHandler()
+	prod.config.InstanceID = "prod"
+
+	token, err := staging.createToken(model.UserInfo{Sub: "marvin", Expiry: time.Now().Add(time.Second).Unix()})
+	NoError(t, err)
+	r := &http.Request{
+		Header: http.Header{"Cookie": {staging.config.CookieName + "=" + token + ";"}},
+	}
+
+	userInfo, valid := staging.GetToken(r, "")
+	True(t, valid)
+	Equal(t, "staging", userInfo.Issuer)
+
+	// same secret, but foreign instance
+	_, valid = prod.GetToken(r, "")
+	False(t, valid)
+
+	// token without instance id
+	token, err = testHandler().createToken(model.UserInfo{Sub: "marvin", Expiry: time.Now().Add(time.Second).Unix()})
+	NoError(t, err)
+	_, valid = prod.GetToken(r, token)
+	False(t, valid)
+}
+
+func TestHandler_getToken_BearerHeader(t *testing.T) {
+	h := testHandler()
+	alice, err := h.createToken(model.UserInfo{Sub: "alice", Expiry: time.Now().Add(time.Minute).Unix()})
+	NoError(t, err)
+	bob, err := h.createToken(model.UserInfo{Sub: "bob", Expiry: time.Now().Add(time.Minute).Unix()})
+	NoError(t, err)
+
+	// the header wins over the cookie
+	r := req("GET", "/context/login", "", "Authorization: Bearer "+bob, "Cookie: "+h.config.CookieName+"="+alice)
+	userInfo, valid := h.GetToken(r, "")
+	True(t, valid)
+	Equal(t, "bob", userInfo.Sub)
+
+	// the explicit token wins over the header
+	userInfo, valid = h.GetToken(r, alice)
+	True(t, valid)
+	Equal(t, "alice", userInfo.Sub)
+
+	// the scheme is case insensitive
+	userInfo, valid = h.GetToken(req("GET", "/context/login", "", "Authorization: bearer  "+bob), "")
+	True(t, valid)
+	Equal(t, "bob", userInfo.Sub)
+
+	// other schemes and malformed headers are no token, the cookie is used
+	for _, header := range []string{"Basic Ym9iOnNlY3JldA==", "Bearer", "Bearer ", "Bearer a b", bob} {
+		userInfo, valid = h.GetToken(req("GET", "/context/login", "", "Authorization: "+header, "Cookie: "+h.config.CookieName+"="+alice), "")
+		True(t, valid, header)
+		Equal(t, "alice", userInfo.Sub, header)
+
+		_, failure := h.verifyToken(req("GET", "/context/login", "", "Authorization: "+header), "")
+		Equal(t, TokenMissing, failure, header)
+	}
+}
+
+func TestHandler_Refresh_BearerHeader(t *testing.T) {
+	h := testHandler()
+	token, err := h.createToken(model.UserInfo{Sub: "bob", Expiry: time.Now().Add(time.Minute).Unix()})
+	NoError(t, err)
+
+	recorder := callHandler(h, req("POST", "/context/login", "", AcceptJwt, "Authorization: Bearer "+token))
+	Equal(t, 200, recorder.Code)
+	claims, err := tokenAsMap(recorder.Body.String())
+	NoError(t, err)
+	Equal(t, "bob", claims["sub"])
+	Equal(t, float64(1), claims["refs"])
+}
@@ -0,0 +1,116 @@
+package login
+
+import (
+	"encoding/json"
+	"net/http"
+	"path"
+	"strconv"
+	"time"
+
+	"github.com/tarent/loginsrv/awskms"
+	"github.com/tarent/loginsrv/clockskew"
+	"github.com/tarent/loginsrv/oauth2"
+)
+
+const contentTypeJSON = "application/json; charset=utf-8"
+
+type healthStatus struct {
+	Status           string     `json:"status"`
+	Maintenance      bool       `json:"maintenance"`
+	MaintenanceUntil *time.Time `json:"maintenance_until,omitempty"`
+
+	JwtRollover *rolloverStatus `json:"jwt_rollover,omitempty"`
+
+	EventsDropped map[string]int64 `json:"events_dropped,omitempty"`
+
+	OauthFlows map[string]oauthFlowStats `json:"oauth_flows,omitempty"`
+
+	OauthEnrichmentFailures map[string]map[string]int64 `json:"oauth_enrichment_failures,omitempty"`
+
+	Backends map[string]map[string]int64 `json:"backends,omitempty"`
+
+	ClockSkew map[string]clockskew.Measurement `json:"clock_skew,omitempty"`
+
+	LoginFailures *loginFailureStats `json:"login_failures,omitempty"`
+
+	TokenFailures map[TokenFailure]int64 `json:"token_failures,omitempty"`
+
+	AbortedRequests map[string]int64 `json:"aborted_requests,omitempty"`
+
+	ShadowBackends map[string]shadowComparison `json:"shadow_backends,omitempty"`
+
+	DisabledProviders []string `json:"disabled_providers,omitempty"`
+
+	KMS *kmsStatus `json:"kms,omitempty"`
+}
+
+// kmsStatus are the sign calls of the kms signer
+type kmsStatus struct {
+	SignCalls  int64 `json:"sign_calls"`
+	SignErrors int64 `json:"sign_errors"`
+	// AvgSignMillis is the average duration of the sign calls
+	AvgSignMillis float64 `json:"avg_sign_ms"`
+}
+
+// kmsStatus returns the counters of the kms signer or nil, if the tokens are not signed by kms
+func (h *Handler) kmsStatus() *kmsStatus {
+	signer, ok := h.signer.(*awskms.Signer)
+	if !ok {
+		return nil
+	}
+	stats := signer.Stats()
+	status := &kmsStatus{SignCalls: stats.Calls, SignErrors: stats.Errors}
+	if stats.Calls > 0 {
+		status.AvgSignMillis = float64(stats.TotalDuration/time.Microsecond) / 1000 / float64(stats.Calls)
+	}
+	return status
+}
+
+func (h *Handler) isHealthPath(r *http.Request) bool {
+	return r.URL.Path == path.Join(h.config.LoginPath, "health")
+}
+
+func (h *Handler) respondHealth(w http.ResponseWriter, r *http.Request) {
+	status := healthStatus{Status: "ok", JwtRollover: h.rollover.status(), EventsDropped: h.eventStream().dropped()}
+	status.OauthFlows = h.configuredFlowMetrics().status()
+	status.OauthEnrichmentFailures = oauth2.EnrichmentFailures()
+	status.Backends = h.backendStats()
+	status.ClockSkew = h.skew.Status()
+	status.LoginFailures = h.failures.status(time.Now())
+	status.TokenFailures = h.tokenFailures.status()
+	status.AbortedRequests = h.abortedRequests.status()
+	status.ShadowBackends = h.shadows.status()
+	status.DisabledProviders = h.disabledProviders()
+	status.KMS = h.kmsStatus()
+	maintenance, until := h.Maintenance()
+	if maintenance {
+		status.Status = "maintenance"
+		status.Maintenance = true
+		if !until.IsZero() {
+			status.MaintenanceUntil = &until
+		}
+	}
+
+	w.Header().Set("Content-Type", contentTypeJSON)
+	w.Header().Set("Cache-Control", "no-cache, no-store, must-revalidate")
+	w.WriteHeader(200)
+	json.NewEncoder(w).Encode(status)
+}
+
+// backendStats returns the counters of the backends, which have some
+func (h *Handler) backendStats() map[string]map[string]int64 {
+	var stats map[string]map[string]int64
+	for i, b := range h.backends {
+		if s, ok := b.(BackendStats); ok && s.Stats() != nil {
+			if stats == nil {
+				stats = map[string]map[string]int64{}
+			}
+			name := strconv.Itoa(i)
+			if i < len(h.backendNames) {
+				name = h.backendNames[i]
+			}
+			stats[name] = s.Stats()
+		}
+	}
+	return stats
+}
@@ -0,0 +1,132 @@
+package login
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	. "github.com/stretchr/testify/assert"
+	"github.com/tarent/loginsrv/awskms"
+	"github.com/tarent/loginsrv/clockskew"
+	"github.com/tarent/loginsrv/model"
+)
+
+func TestHealth(t *testing.T) {
+	h := testHandler()
+
+	recorder := httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("GET", "/context/login/health", ""))
+	Equal(t, 200, recorder.Code)
+	Equal(t, "application/json; charset=utf-8", recorder.Header().Get("Content-Type"))
+	JSONEq(t, `{"status": "ok", "maintenance": false}`, recorder.Body.String())
+
+	h.SetMaintenance(true)
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("GET", "/context/login/health", ""))
+	Equal(t, 200, recorder.Code)
+	JSONEq(t, `{"status": "maintenance", "maintenance": true}`, recorder.Body.String())
+}
+
+// keyedTestBackend records its key and reports the length of it as stat
+type keyedTestBackend struct {
+	*SimpleBackend
+	key []byte
+}
+
+func (b *keyedTestBackend) SetKey(key []byte) {
+	b.key = key
+}
+
+func (b *keyedTestBackend) Stats() map[string]int64 {
+	return map[string]int64{"key_length": int64(len(b.key))}
+}
+
+func TestHealth_BackendStats(t *testing.T) {
+	registry := NewProviderRegistry()
+	simple, _ := GetProvider(SimpleProviderName)
+	NoError(t, registry.Register(&ProviderDescription{Name: SimpleProviderName}, simple))
+	NoError(t, registry.Register(&ProviderDescription{Name: "keyedtest"}, func(config map[string]string) (Backend, error) {
+		return &keyedTestBackend{SimpleBackend: NewSimpleBackend(config)}, nil
+	}))
+	cfg := DefaultConfig()
+	cfg.Backends = Options{"keyedtest": {"bob": "secret"}, "simple": {"alice": "secret"}}
+	h, err := NewHandlerWithOptions(cfg, WithRegistry(registry))
+	NoError(t, err)
+
+	recorder := httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("GET", "/login/health", ""))
+	JSONEq(t, `{"status": "ok", "maintenance": false, "backends": {"keyedtest": {"key_length": 32}}}`, recorder.Body.String())
+
+	// the keys are derived from the jwt secret and distinct for each backend
+	Equal(t, backendKey(cfg.JwtSecret, "keyedtest"), h.backends[0].(*keyedTestBackend).key)
+	NotEqual(t, backendKey(cfg.JwtSecret, "keyedtest"), backendKey(cfg.JwtSecret, "other"))
+	NotEqual(t, backendKey(cfg.JwtSecret, "keyedtest"), backendKey("other secret", "keyedtest"))
+}
+
+func TestHealth_ClockSkew(t *testing.T) {
+	h := testHandler()
+	h.skew = clockskew.NewMonitor(time.Second)
+	h.skew.Observe("oauth:github", time.Now().Add(-time.Hour))
+
+	recorder := httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("GET", "/context/login/health", ""))
+	status := healthStatus{}
+	NoError(t, json.Unmarshal(recorder.Body.Bytes(), &status))
+	InDelta(t, -3600, status.ClockSkew["oauth:github"].Skew, 1)
+	True(t, status.ClockSkew["oauth:github"].Exceeded)
+}
+
+// newKMSTestServer emulates the sign api of kms for the key
+func newKMSTestServer(key *ecdsa.PrivateKey) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Message []byte
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+		switch r.Header.Get("X-Amz-Target") {
+		case "TrentService.GetPublicKey":
+			der, _ := x509.MarshalPKIXPublicKey(key.Public())
+			json.NewEncoder(w).Encode(map[string]interface{}{"PublicKey": der, "KeySpec": "ECC_NIST_P256", "KeyUsage": "SIGN_VERIFY"})
+		case "TrentService.Sign":
+			signature, _ := key.Sign(rand.Reader, req.Message, crypto.SHA256)
+			json.NewEncoder(w).Encode(map[string]interface{}{"Signature": signature})
+		default:
+			w.WriteHeader(400)
+		}
+	}))
+}
+
+func TestHealth_KMS(t *testing.T) {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	server := newKMSTestServer(key)
+	defer server.Close()
+	signer, err := awskms.NewSigner(context.Background(), "alias/loginsrv", "eu-central-1", server.URL, awskms.Credentials{AccessKeyID: "id", SecretAccessKey: "secret"}, time.Second)
+	NoError(t, err)
+
+	h := testHandler()
+	h.signer = signer
+	_, err = h.createToken(model.UserInfo{Sub: "bob", Expiry: time.Now().Add(time.Minute).Unix()})
+	NoError(t, err)
+	server.Close()
+	_, err = h.createToken(model.UserInfo{Sub: "bob", Expiry: time.Now().Add(time.Minute).Unix()})
+	Error(t, err)
+
+	recorder := httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("GET", "/context/login/health", ""))
+	status := healthStatus{}
+	NoError(t, json.Unmarshal(recorder.Body.Bytes(), &status))
+	Equal(t, int64(2), status.KMS.SignCalls)
+	Equal(t, int64(1), status.KMS.SignErrors)
+	True(t, status.KMS.AvgSignMillis > 0)
+
+	// without kms, there is nothing to report
+	Nil(t, testHandler().kmsStatus())
+}
@@ -0,0 +1,115 @@
+package login
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"time"
+)
+
+// the minimum distance in km for the velocity check, as the locations of ip addresses are inaccurate
+const impossibleTravelMinDistance = 200
+
+// GeoLocation is the location of an ip address. Empty fields are unknown.
+type GeoLocation struct {
+	Country string
+	ASN     string
+
+	// HasCoordinates is set, if the latitude and longitude are known
+	HasCoordinates bool
+	Latitude       float64
+	Longitude      float64
+}
+
+// GeoIPLookup returns the location of ip addresses, e.g. from a GeoIP database
+type GeoIPLookup interface {
+	Lookup(ctx context.Context, ip string) (GeoLocation, error)
+}
+
+// noGeoIP knows no locations, so the impossible travel heuristic flags nothing
+type noGeoIP struct{}
+
+func (noGeoIP) Lookup(ctx context.Context, ip string) (GeoLocation, error) {
+	return GeoLocation{}, nil
+}
+
+// ImpossibleTravel is the built-in risk assessor. It compares the location of the login
+// with the one of the previous login of the user: a new country or network (ASN) requires a step up,
+// an implausible velocity between both logins is denied.
+type ImpossibleTravel struct {
+	Geo GeoIPLookup
+
+	// MaxSpeed is the highest plausible velocity in km/h
+	MaxSpeed float64
+
+	// the decisions for a new country or network and for an implausible velocity
+	NewLocation        RiskDecision
+	ImpossibleVelocity RiskDecision
+}
+
+// NewImpossibleTravel returns the heuristic with the lookup, a maximum speed of an airliner,
+// a step up for new locations and a denial of implausible velocities.
+// Without a lookup, no login is flagged.
+func NewImpossibleTravel(geo GeoIPLookup) *ImpossibleTravel {
+	if geo == nil {
+		geo = noGeoIP{}
+	}
+	return &ImpossibleTravel{
+		Geo:                geo,
+		MaxSpeed:           1000,
+		NewLocation:        RiskStepUp,
+		ImpossibleVelocity: RiskDeny,
+	}
+}
+
+// Assess implements the RiskAssessor
+func (t *ImpossibleTravel) Assess(ctx context.Context, attempt LoginAttempt) (RiskAssessment, error) {
+	previous := attempt.Previous
+	if previous == nil || previous.ClientIP == attempt.ClientIP {
+		return RiskAssessment{Decision: RiskAllow}, nil
+	}
+	from, err := t.Geo.Lookup(ctx, previous.ClientIP)
+	if err != nil {
+		return RiskAssessment{}, err
+	}
+	to, err := t.Geo.Lookup(ctx, attempt.ClientIP)
+	if err != nil {
+		return RiskAssessment{}, err
+	}
+
+	if from.HasCoordinates && to.HasCoordinates {
+		distance := greatCircleDistance(from, to)
+		elapsed := attempt.Time.Sub(previous.Time)
+		if distance >= impossibleTravelMinDistance && distance/math.Max(elapsed.Hours(), 1.0/60) > t.MaxSpeed {
+			return RiskAssessment{
+				Decision: t.ImpossibleVelocity,
+				Reason: fmt.Sprintf("impossible travel of %.0f km within %v from %v to %v",
+					distance, elapsed.Round(time.Second), previous.ClientIP, attempt.ClientIP),
+			}, nil
+		}
+	}
+	if from.Country != "" && to.Country != "" && from.Country != to.Country {
+		return RiskAssessment{
+			Decision: t.NewLocation,
+			Reason:   fmt.Sprintf("login from the new country %v, previous login from %v", to.Country, from.Country),
+		}, nil
+	}
+	if from.ASN != "" && to.ASN != "" && from.ASN != to.ASN {
+		return RiskAssessment{
+			Decision: t.NewLocation,
+			Reason:   fmt.Sprintf("login from the new network %v, previous login from %v", to.ASN, from.ASN),
+		}, nil
+	}
+	return RiskAssessment{Decision: RiskAllow}, nil
+}
+
+// greatCircleDistance returns the distance of the locations in km by the haversine formula
+func greatCircleDistance(a, b GeoLocation) float64 {
+	const earthRadius = 6371
+	rad := func(deg float64) float64 { return deg * math.Pi / 180 }
+	dLat := rad(b.Latitude - a.Latitude)
+	dLon := rad(b.Longitude - a.Longitude)
+	h := math.Sin(dLat/2)*math.Sin(dLat/2) +
+		math.Cos(rad(a.Latitude))*math.Cos(rad(b.Latitude))*math.Sin(dLon/2)*math.Sin(dLon/2)
+	return 2 * earthRadius * math.Asin(math.Sqrt(h))
+}
@@ -0,0 +1,76 @@
+package login
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"path"
+
+	"github.com/tarent/loginsrv/logging"
+	"github.com/tarent/loginsrv/model"
+)
+
+// introspectionResponse is the answer of the token introspection (RFC 7662).
+// Inactive tokens get only the active flag, active tokens the claims of the token.
+// The claims include the cnf claim of bound tokens, so that the resource server checks the binding
+// to the client certificate of its connection (RFC 8705, section 3.2).
+type introspectionResponse struct {
+	Active bool `json:"active"`
+	*model.UserInfo
+}
+
+// isIntrospectionPath matches <login-path>/introspect, if the -introspection-secret is set.
+// Without it, the introspection is off, because RFC 7662 requires the authorization of the callers.
+func (h *Handler) isIntrospectionPath(r *http.Request) bool {
+	return h.config.IntrospectionSecret != "" && r.URL.Path == path.Join(h.config.LoginPath, "introspect")
+}
+
+// respondIntrospection tells other services, if a token is active (RFC 7662):
+// it has a valid signature, is not expired and not revoked.
+// Access and refresh tokens are introspected. The callers have to send the -introspection-secret as bearer token.
+func (h *Handler) respondIntrospection(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		writeOauthError(w, 400, "invalid_request", "POST is required")
+		return
+	}
+	if subtle.ConstantTimeCompare([]byte(bearerToken(r)), []byte(h.config.IntrospectionSecret)) != 1 {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="loginsrv"`)
+		writeOauthError(w, 401, "invalid_client", "the introspection secret is missing or wrong")
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxCredentialsBodySize)
+	r.ParseForm()
+	_, _, token, err := getCredentials(r)
+	if err != nil || token == "" {
+		writeOauthError(w, 400, "invalid_request", "missing token")
+		return
+	}
+
+	services := []*TokenService{h.tokenService()}
+	if h.refreshTokensEnabled() {
+		refreshTokens := h.tokenService()
+		refreshTokens.refreshTokens = true
+		// the token_type_hint saves the verification as the other type of token
+		if r.FormValue("token_type_hint") == "refresh_token" {
+			services = []*TokenService{refreshTokens, services[0]}
+		} else {
+			services = append(services, refreshTokens)
+		}
+	}
+	var userInfo model.UserInfo
+	var failure TokenFailure
+	for _, tokens := range services {
+		// the certificate of the request is the one of the resource server, the binding is checked by it
+		if userInfo, failure = h.verifyTokenClaims(r, token, tokens); failure != TokenWrongType {
+			break
+		}
+	}
+	if failure != "" {
+		logging.Application(r.Header).
+			WithField("reason", failure).
+			Debug("introspection of an inactive token")
+		writeOauthJSON(w, 200, introspectionResponse{Active: false})
+		return
+	}
+	writeOauthJSON(w, 200, introspectionResponse{Active: true, UserInfo: &userInfo})
+}
@@ -0,0 +1,135 @@
+package login
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	. "github.com/stretchr/testify/assert"
+	"github.com/tarent/loginsrv/model"
+)
+
+const testIntrospectionSecret = "introspection-secret"
+
+// introspect calls the introspection authenticated by the testIntrospectionSecret
+func introspect(h *Handler, body string, headers ...string) (*httptest.ResponseRecorder, map[string]interface{}) {
+	return introspectAs(h, "Authorization: Bearer "+testIntrospectionSecret, body, headers...)
+}
+
+func introspectAs(h *Handler, authorization, body string, headers ...string) (*httptest.ResponseRecorder, map[string]interface{}) {
+	if authorization != "" {
+		headers = append(headers, authorization)
+	}
+	recorder := callHandler(h, req("POST", "/context/login/introspect", body, headers...))
+	response := map[string]interface{}{}
+	json.Unmarshal(recorder.Body.Bytes(), &response)
+	return recorder, response
+}
+
+func TestHandler_Introspection(t *testing.T) {
+	h := testHandler()
+	h.config.IntrospectionSecret = testIntrospectionSecret
+	token := callHandler(h, req("POST", "/context/login", "username=bob&password=secret", TypeForm, AcceptJwt)).Body.String()
+
+	// valid
+	recorder, response := introspect(h, "token="+url.QueryEscape(token), TypeForm)
+	Equal(t, 200, recorder.Code)
+	Equal(t, contentTypeJSON, recorder.Header().Get("Content-Type"))
+	Equal(t, "no-store", recorder.Header().Get("Cache-Control"))
+	Equal(t, true, response["active"])
+	Equal(t, "bob", response["sub"])
+	InDelta(t, time.Now().Add(h.config.JwtExpiry).Unix(), response["exp"], 2)
+
+	// as json
+	_, response = introspect(h, `{"token": "`+token+`"}`, TypeJSON)
+	Equal(t, true, response["active"])
+
+	// expired
+	expired, err := h.createToken(model.UserInfo{Sub: "bob", Expiry: time.Now().Add(-time.Hour).Unix()})
+	NoError(t, err)
+	recorder, response = introspect(h, "token="+expired, TypeForm)
+	Equal(t, 200, recorder.Code)
+	Equal(t, map[string]interface{}{"active": false}, response)
+
+	// garbage
+	_, response = introspect(h, "token=not-a-token", TypeForm)
+	Equal(t, map[string]interface{}{"active": false}, response)
+
+	// revoked
+	userInfo, valid := h.GetToken(req("GET", "/", ""), token)
+	True(t, valid)
+	NoError(t, h.revocations.Revoke(userInfo.ID, time.Now().Add(time.Hour)))
+	_, response = introspect(h, "token="+token, TypeForm)
+	Equal(t, map[string]interface{}{"active": false}, response)
+
+	// the token of the request is not introspected
+	recorder, response = introspect(h, "", TypeForm, "Cookie: "+h.config.CookieName+"="+token)
+	Equal(t, 400, recorder.Code)
+	Equal(t, "invalid_request", response["error"])
+	Equal(t, 400, callHandler(h, req("GET", "/context/login/introspect?token="+token, "", "Authorization: Bearer "+testIntrospectionSecret)).Code)
+}
+
+func TestHandler_Introspection_RefreshToken(t *testing.T) {
+	h := refreshTokenHandler()
+	h.config.IntrospectionSecret = testIntrospectionSecret
+	recorder := callHandler(h, req("POST", "/context/login", `{"username": "bob", "password": "secret"}`, TypeJSON, "X-Login-API-Version: 2"))
+	tokens := tokenResponse{}
+	NoError(t, json.Unmarshal(recorder.Body.Bytes(), &tokens))
+
+	_, response := introspect(h, "token="+tokens.RefreshToken, TypeForm)
+	Equal(t, true, response["active"])
+	Equal(t, model.TokenTypeRefresh, response["token_type"])
+	_, response = introspect(h, "token_type_hint=refresh_token&token="+tokens.RefreshToken, TypeForm)
+	Equal(t, true, response["active"])
+	// a wrong hint is no failure
+	_, response = introspect(h, "token_type_hint=refresh_token&token="+tokens.Token, TypeForm)
+	Equal(t, true, response["active"])
+	Equal(t, model.TokenTypeAccess, response["token_type"])
+	_, response = introspect(h, "token="+tokens.Token, TypeForm)
+	Equal(t, true, response["active"])
+	Equal(t, model.TokenTypeAccess, response["token_type"])
+}
+
+func TestHandler_Introspection_Secret(t *testing.T) {
+	h := testHandler()
+	token := callHandler(h, req("POST", "/context/login", "username=bob&password=secret", TypeForm, AcceptJwt)).Body.String()
+
+	// without a secret, the introspection is off
+	recorder, _ := introspect(h, "token="+token, TypeForm)
+	Equal(t, 404, recorder.Code)
+
+	h.config.IntrospectionSecret = testIntrospectionSecret
+	recorder, response := introspectAs(h, "", "token="+token, TypeForm)
+	Equal(t, 401, recorder.Code)
+	Equal(t, "invalid_client", response["error"])
+	Equal(t, `Bearer realm="loginsrv"`, recorder.Header().Get("WWW-Authenticate"))
+	recorder, _ = introspectAs(h, "Authorization: Bearer wrong", "token="+token, TypeForm)
+	Equal(t, 401, recorder.Code)
+	// a valid token is no secret
+	recorder, _ = introspectAs(h, "Authorization: Bearer "+token, "token="+token, TypeForm)
+	Equal(t, 401, recorder.Code)
+
+	recorder, response = introspect(h, "token="+token, TypeForm)
+	Equal(t, 200, recorder.Code)
+	Equal(t, true, response["active"])
+	Equal(t, "...", h.config.Redacted().IntrospectionSecret)
+}
+
+func TestHandler_Introspection_BoundToken(t *testing.T) {
+	h := testHandler()
+	h.config.IntrospectionSecret = testIntrospectionSecret
+	bound, err := h.createToken(model.UserInfo{Sub: "bob", Expiry: time.Now().Add(time.Hour).Unix(), Confirmation: &model.Confirmation{X5tS256: "thumbprint"}})
+	NoError(t, err)
+
+	// the resource server introspects without the certificate of the client and checks the cnf claim itself
+	recorder, response := introspect(h, "token="+bound, TypeForm)
+	Equal(t, 200, recorder.Code)
+	Equal(t, true, response["active"])
+	Equal(t, map[string]interface{}{"x5t#S256": "thumbprint"}, response["cnf"])
+
+	// the token itself still requires the certificate
+	_, failure := h.verifyToken(req("GET", "/", ""), bound)
+	Equal(t, TokenUnboundCertificate, failure)
+}
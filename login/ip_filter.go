@@ -0,0 +1,70 @@
+package login
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+var errAPIIPDenied = apiError{403, "ip_denied", "Forbidden: Logins are not allowed from this network"}
+
+// ipFilter restricts the clients by their ip.
+// An empty allowlist allows all clients, which are not denied.
+// A client matching both lists is denied.
+type ipFilter struct {
+	allow []*net.IPNet
+	deny  []*net.IPNet
+}
+
+// newIPFilter returns the filter for the comma separated networks, or nil if both lists are empty
+func newIPFilter(allow, deny string) (*ipFilter, error) {
+	allowNets, err := parseCIDRList(allow)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid allow ips: %v", err)
+	}
+	denyNets, err := parseCIDRList(deny)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid deny ips: %v", err)
+	}
+	if len(allowNets) == 0 && len(denyNets) == 0 {
+		return nil, nil
+	}
+	return &ipFilter{allow: allowNets, deny: denyNets}, nil
+}
+
+// allowed checks the client ip. Unparsable ips are denied.
+func (f *ipFilter) allowed(ip string) bool {
+	// no zone of link local ipv6 addresses
+	if i := strings.Index(ip, "%"); i >= 0 {
+		ip = ip[:i]
+	}
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return false
+	}
+	if containsIP(f.deny, parsed) {
+		return false
+	}
+	return len(f.allow) == 0 || containsIP(f.allow, parsed)
+}
+
+func containsIP(nets []*net.IPNet, ip net.IP) bool {
+	for _, n := range nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkClientIP rejects clients, which are not allowed by the ip filter.
+// It returns false, if the request was answered.
+func (h *Handler) checkClientIP(w http.ResponseWriter, r *http.Request) bool {
+	if h.ipFilter == nil || h.ipFilter.allowed(clientIP(r, h.trustedProxies)) {
+		return true
+	}
+	h.emit(r, EventIPDenied, "", "")
+	h.respondAPIError(w, r, errAPIIPDenied)
+	return false
+}
@@ -0,0 +1,121 @@
+package login
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	. "github.com/stretchr/testify/assert"
+	"github.com/tarent/loginsrv/model"
+	"github.com/tarent/loginsrv/oauth2"
+)
+
+func TestIPFilter_Allowed(t *testing.T) {
+	f, err := newIPFilter("10.0.0.0/8, 2001:db8::/32, ::ffff:172.16.0.0/112", "10.1.0.0/16, 2001:db8:bad::/48")
+	NoError(t, err)
+
+	tests := []struct {
+		ip      string
+		allowed bool
+	}{
+		{"10.0.0.1", true},
+		{"10.1.2.3", false},
+		{"192.168.1.1", false},
+		{"2001:db8::1", true},
+		{"2001:db8:bad::1", false},
+		{"fe80::1%eth0", false},
+		// mapped ipv4 addresses match the ipv4 networks and vice versa
+		{"::ffff:10.0.0.1", true},
+		{"::ffff:10.1.0.1", false},
+		{"172.16.0.1", true},
+		{"::ffff:172.16.0.1", true},
+		{"172.17.0.1", false},
+		{"", false},
+		{"not-an-ip", false},
+	}
+	for _, test := range tests {
+		Equal(t, test.allowed, f.allowed(test.ip), test.ip)
+	}
+}
+
+func TestIPFilter_EmptyAllowlist(t *testing.T) {
+	f, err := newIPFilter("", "192.168.0.0/16")
+	NoError(t, err)
+	True(t, f.allowed("10.0.0.1"))
+	True(t, f.allowed("::1"))
+	False(t, f.allowed("192.168.1.1"))
+
+	f, err = newIPFilter("", "")
+	NoError(t, err)
+	Nil(t, f)
+
+	_, err = newIPFilter("10.0.0.0/99", "")
+	EqualError(t, err, `Invalid allow ips: invalid network "10.0.0.0/99": invalid CIDR address: 10.0.0.0/99`)
+	_, err = newIPFilter("", "foo")
+	Error(t, err)
+}
+
+func TestHandler_IPFilter(t *testing.T) {
+	h := testHandler()
+	h.ipFilter, _ = newIPFilter("10.0.0.0/8", "")
+	h.trustedProxies, _ = parseCIDRList("192.168.0.1")
+	rec := &eventRecorder{}
+	h.Subscribe(Subscriber{Name: "test", Handle: rec.handle})
+
+	login := func(remoteAddr, forwardedFor string, header ...string) *httptest.ResponseRecorder {
+		r := req("POST", "/context/login", `{"username": "bob", "password": "secret"}`, append(header, TypeJSON, AcceptJwt)...)
+		r.RemoteAddr = remoteAddr
+		if forwardedFor != "" {
+			r.Header.Set("X-Forwarded-For", forwardedFor)
+		}
+		recorder := httptest.NewRecorder()
+		h.ServeHTTP(recorder, r)
+		return recorder
+	}
+
+	Equal(t, 200, login("10.0.0.1:1234", "").Code)
+
+	recorder := login("172.16.0.1:1234", "")
+	Equal(t, 403, recorder.Code)
+	Equal(t, "Forbidden: Logins are not allowed from this network", recorder.Body.String())
+
+	// the client behind a trusted proxy
+	Equal(t, 200, login("192.168.0.1:1234", "10.0.0.1").Code)
+	Equal(t, 403, login("192.168.0.1:1234", "172.16.0.1").Code)
+	// an untrusted proxy can not fake the client ip
+	Equal(t, 403, login("172.16.0.1:1234", "10.0.0.1").Code)
+
+	// distinct problem code in version 2
+	recorder = login("172.16.0.1:1234", "", apiVersionHeader+": 2")
+	Equal(t, 403, recorder.Code)
+	Contains(t, recorder.Body.String(), `"code":"ip_denied"`)
+
+	Equal(t, EventIPDenied, rec.events[len(rec.events)-1].Type)
+	Equal(t, "172.16.0.1", rec.events[len(rec.events)-1].ClientIP)
+}
+
+func TestHandler_IPFilter_OauthCallback(t *testing.T) {
+	handleCalled := false
+	h := &Handler{
+		handlerRuntime: newHandlerRuntime(),
+		oauth: &oauth2ManagerMock{
+			_GetConfigFromRequest: func(r *http.Request) (oauth2.Config, error) {
+				return oauth2.Config{}, nil
+			},
+			_Handle: func(w http.ResponseWriter, r *http.Request) (bool, bool, model.UserInfo, error) {
+				handleCalled = true
+				return false, true, model.UserInfo{Sub: "marvin"}, nil
+			},
+		},
+		config: DefaultConfig(),
+	}
+	h.ipFilter, _ = newIPFilter("10.0.0.0/8", "")
+
+	// a callback with a stolen state from outside
+	r := req("GET", "/login/github?code=xyz&state=abc", "", "Cookie: oauthState=abc")
+	r.RemoteAddr = "[2001:db8::1]:1234"
+	recorder := httptest.NewRecorder()
+	h.ServeHTTP(recorder, r)
+	Equal(t, 403, recorder.Code)
+	False(t, handleCalled)
+}
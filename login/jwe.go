@@ -0,0 +1,134 @@
+package login
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// jweHeader is the protected header of the encrypted tokens:
+// the signed jwt is encrypted directly with the shared key by AES-GCM (RFC 7516, RFC 7518)
+const jweHeader = `{"alg":"dir","enc":"A256GCM","cty":"JWT"}`
+
+var errInvalidJWE = errors.New("invalid encrypted token")
+
+// tokenEncrypter encrypts the signed tokens by the -jwe-key,
+// so that the claims are not readable by the browser.
+type tokenEncrypter struct {
+	aead cipher.AEAD
+}
+
+// newTokenEncrypter returns the encrypter for the base64 encoded 256 bit key, nil without a key.
+func newTokenEncrypter(config *Config) (*tokenEncrypter, error) {
+	if config.JweKey == "" {
+		if config.JwePlainAPITokens {
+			return nil, errors.New("The -jwe-plain-api-tokens requires a -jwe-key")
+		}
+		return nil, nil
+	}
+	key, err := base64.StdEncoding.DecodeString(config.JweKey)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid jwe key, it has to be base64 encoded: %v", err)
+	}
+	if len(key) != 32 {
+		return nil, fmt.Errorf("Invalid jwe key, A256GCM requires 32 bytes, but got %v", len(key))
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &tokenEncrypter{aead: aead}, nil
+}
+
+// encrypt returns the token in the jwe compact serialization.
+// The header is the additional authenticated data and the encrypted key is empty for direct encryption.
+func (e *tokenEncrypter) encrypt(token string) (string, error) {
+	header := base64.RawURLEncoding.EncodeToString([]byte(jweHeader))
+	iv := make([]byte, e.aead.NonceSize())
+	if _, err := rand.Read(iv); err != nil {
+		return "", err
+	}
+	sealed := e.aead.Seal(nil, iv, []byte(token), []byte(header))
+	ciphertext, tag := sealed[:len(token)], sealed[len(token):]
+	return strings.Join([]string{
+		header,
+		"",
+		base64.RawURLEncoding.EncodeToString(iv),
+		base64.RawURLEncoding.EncodeToString(ciphertext),
+		base64.RawURLEncoding.EncodeToString(tag),
+	}, "."), nil
+}
+
+// decrypt returns the signed token of the jwe. Only the header written by encrypt is accepted.
+func (e *tokenEncrypter) decrypt(jwe string) (string, error) {
+	parts := strings.Split(jwe, ".")
+	if len(parts) != 5 || parts[1] != "" {
+		return "", errInvalidJWE
+	}
+	if header, err := base64.RawURLEncoding.DecodeString(parts[0]); err != nil || string(header) != jweHeader {
+		return "", errInvalidJWE
+	}
+	iv, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil || len(iv) != e.aead.NonceSize() {
+		return "", errInvalidJWE
+	}
+	ciphertext, err := base64.RawURLEncoding.DecodeString(parts[3])
+	if err != nil {
+		return "", errInvalidJWE
+	}
+	tag, err := base64.RawURLEncoding.DecodeString(parts[4])
+	if err != nil || len(tag) != e.aead.Overhead() {
+		return "", errInvalidJWE
+	}
+	token, err := e.aead.Open(nil, iv, append(ciphertext, tag...), []byte(parts[0]))
+	if err != nil {
+		return "", errInvalidJWE
+	}
+	return string(token), nil
+}
+
+// isEncryptedToken checks, if the token has the five parts of a jwe instead of the three of a jws
+func isEncryptedToken(token string) bool {
+	return strings.Count(token, ".") == 4
+}
+
+// encryptToken encrypts the token for the browser, if a -jwe-key is configured
+func (h *Handler) encryptToken(token string) (string, error) {
+	if h.jwe == nil || token == "" {
+		return token, nil
+	}
+	return h.jwe.encrypt(token)
+}
+
+// encryptTokens returns the response with the encrypted tokens for the cookies
+// and the response for the api clients, which keeps the plain tokens with -jwe-plain-api-tokens.
+func (h *Handler) encryptTokens(response tokenResponse) (cookies tokenResponse, api tokenResponse, err error) {
+	cookies = response
+	if cookies.Token, err = h.encryptToken(response.Token); err != nil {
+		return tokenResponse{}, tokenResponse{}, err
+	}
+	if cookies.RefreshToken, err = h.encryptToken(response.RefreshToken); err != nil {
+		return tokenResponse{}, tokenResponse{}, err
+	}
+	if h.config.JwePlainAPITokens {
+		return cookies, response, nil
+	}
+	return cookies, cookies, nil
+}
+
+// decryptToken returns the signed token of an encrypted token.
+// Plain tokens are returned unchanged, e.g. the tokens of api clients with -jwe-plain-api-tokens.
+func (h *Handler) decryptToken(token string) (string, error) {
+	if h.jwe == nil || !isEncryptedToken(token) {
+		return token, nil
+	}
+	return h.jwe.decrypt(token)
+}
@@ -0,0 +1,134 @@
+package login
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	. "github.com/stretchr/testify/assert"
+)
+
+var testJweKey = base64.StdEncoding.EncodeToString([]byte("0123456789abcdef0123456789abcdef"))
+
+func jweHandler(plainAPITokens bool) *Handler {
+	h := testHandler()
+	h.config.JweKey = testJweKey
+	h.config.JwePlainAPITokens = plainAPITokens
+	h.jwe, _ = newTokenEncrypter(h.config)
+	return h
+}
+
+func TestTokenEncrypter(t *testing.T) {
+	e, err := newTokenEncrypter(&Config{JweKey: testJweKey})
+	NoError(t, err)
+
+	jwe, err := e.encrypt("a.b.c")
+	NoError(t, err)
+	True(t, isEncryptedToken(jwe))
+	NotContains(t, jwe, "a.b.c")
+	token, err := e.decrypt(jwe)
+	NoError(t, err)
+	Equal(t, "a.b.c", token)
+
+	// every encryption has a new iv
+	other, _ := e.encrypt("a.b.c")
+	NotEqual(t, jwe, other)
+
+	// tampered parts are rejected
+	parts := strings.Split(jwe, ".")
+	for i, part := range []int{0, 2, 3, 4} {
+		tampered := append([]string{}, parts...)
+		tampered[part] = base64.RawURLEncoding.EncodeToString([]byte("x"))
+		_, err = e.decrypt(strings.Join(tampered, "."))
+		Equal(t, errInvalidJWE, err, i)
+	}
+	_, err = e.decrypt("a.b.c")
+	Equal(t, errInvalidJWE, err)
+
+	// another key can not decrypt it
+	otherKey, _ := newTokenEncrypter(&Config{JweKey: base64.StdEncoding.EncodeToString([]byte("fedcba9876543210fedcba9876543210"))})
+	_, err = otherKey.decrypt(jwe)
+	Equal(t, errInvalidJWE, err)
+}
+
+func TestNewTokenEncrypter_Config(t *testing.T) {
+	e, err := newTokenEncrypter(&Config{})
+	NoError(t, err)
+	Nil(t, e)
+
+	_, err = newTokenEncrypter(&Config{JwePlainAPITokens: true})
+	EqualError(t, err, "The -jwe-plain-api-tokens requires a -jwe-key")
+
+	_, err = newTokenEncrypter(&Config{JweKey: "no base64!"})
+	Error(t, err)
+
+	_, err = newTokenEncrypter(&Config{JweKey: base64.StdEncoding.EncodeToString([]byte("too short"))})
+	EqualError(t, err, "Invalid jwe key, A256GCM requires 32 bytes, but got 9")
+}
+
+func TestHandler_JWE(t *testing.T) {
+	h := jweHandler(false)
+
+	// the browser gets the encrypted token in the cookie
+	recorder := callHandler(h, req("POST", "/context/login", "username=bob&password=secret", TypeForm, AcceptHTML))
+	Equal(t, 303, recorder.Code)
+	cookie := readSetCookies(recorder.Header())[0]
+	True(t, isEncryptedToken(cookie.Value))
+	_, err := tokenAsMap(cookie.Value)
+	Error(t, err)
+
+	userInfo, valid := h.GetToken(req("GET", "/context/login", "", "Cookie: "+h.config.CookieName+"="+cookie.Value), "")
+	True(t, valid)
+	Equal(t, "bob", userInfo.Sub)
+
+	// refreshes accept and return encrypted tokens
+	recorder = callHandler(h, req("POST", "/context/login", "", AcceptJwt, "Cookie: "+h.config.CookieName+"="+cookie.Value))
+	Equal(t, 200, recorder.Code)
+	True(t, isEncryptedToken(recorder.Body.String()))
+
+	// an invalid jwe is an invalid token, not an error
+	parts := strings.Split(cookie.Value, ".")
+	parts[3] = parts[3][1:]
+	_, failure := h.verifyToken(req("GET", "/context/login", "", "Cookie: "+h.config.CookieName+"="+strings.Join(parts, ".")), "")
+	Equal(t, TokenInvalid, failure)
+	recorder = callHandler(h, req("GET", "/context/login", "", AcceptHTML, "Cookie: "+h.config.CookieName+"="+strings.Join(parts, ".")))
+	Equal(t, 200, recorder.Code)
+
+	// without the key, the encrypted tokens are rejected
+	_, valid = testHandler().GetToken(req("GET", "/context/login", "", "Cookie: "+h.config.CookieName+"="+cookie.Value), "")
+	False(t, valid)
+}
+
+func TestHandler_JWE_PlainAPITokens(t *testing.T) {
+	h := jweHandler(true)
+	h.config.JwtRefreshTokenExpiry = h.config.CookieExpiry
+	h.config.JwtRefreshes = 0
+
+	recorder := callHandler(h, req("POST", "/context/login", `{"username": "bob", "password": "secret"}`, TypeJSON, "X-Login-API-Version: 2"))
+	Equal(t, 200, recorder.Code)
+	response := tokenResponse{}
+	NoError(t, json.Unmarshal(recorder.Body.Bytes(), &response))
+	claims, err := tokenAsMap(response.Token)
+	NoError(t, err)
+	Equal(t, "bob", claims["sub"])
+	_, err = tokenAsMap(response.RefreshToken)
+	NoError(t, err)
+
+	// the plain tokens are still accepted
+	_, valid := h.GetToken(req("GET", "/context/login", "", "Authorization: Bearer "+response.Token), "")
+	True(t, valid)
+	recorder = callHandler(h, req("POST", "/context/login", `{"token": "`+response.RefreshToken+`"}`, TypeJSON, AcceptJwt))
+	Equal(t, 200, recorder.Code)
+
+	// the cookies are encrypted anyway
+	recorder = httptest.NewRecorder()
+	h.config.SetCookieForAPI = true
+	h.ServeHTTP(recorder, req("POST", "/context/login", "username=bob&password=secret", TypeForm, AcceptJwt))
+	cookies := readSetCookies(recorder.Header())
+	Equal(t, 2, len(cookies))
+	True(t, isEncryptedToken(cookies[0].Value))
+	True(t, isEncryptedToken(cookies[1].Value))
+	False(t, isEncryptedToken(recorder.Body.String()))
+}
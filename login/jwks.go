@@ -0,0 +1,132 @@
+package login
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"path"
+)
+
+// jwksCacheControl lets proxies and verifying services cache the keys.
+// A new key should be published for this time before tokens are signed with it.
+const jwksCacheControl = "public, max-age=600"
+
+// jwk is a public key in the JSON Web Key format (RFC 7517)
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Alg string `json:"alg"`
+	Use string `json:"use"`
+	Crv string `json:"crv,omitempty"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+}
+
+type jwkSet struct {
+	Keys []jwk `json:"keys"`
+}
+
+// newJWK encodes the public key for the algorithm.
+// The key id is the JWK thumbprint (RFC 7638), so it is stable for the key.
+func newJWK(key crypto.PublicKey, alg string) (jwk, error) {
+	k := jwk{Alg: alg, Use: "sig"}
+	var thumbprintInput string
+	switch key := key.(type) {
+	case *rsa.PublicKey:
+		k.Kty = "RSA"
+		k.N = encodeJWKInt(key.N.Bytes())
+		k.E = encodeJWKInt(big.NewInt(int64(key.E)).Bytes())
+		thumbprintInput = fmt.Sprintf(`{"e":"%v","kty":"RSA","n":"%v"}`, k.E, k.N)
+	case *ecdsa.PublicKey:
+		size := (key.Curve.Params().BitSize + 7) / 8
+		k.Kty = "EC"
+		k.Crv = key.Curve.Params().Name
+		k.X = encodeJWKInt(key.X.FillBytes(make([]byte, size)))
+		k.Y = encodeJWKInt(key.Y.FillBytes(make([]byte, size)))
+		thumbprintInput = fmt.Sprintf(`{"crv":"%v","kty":"EC","x":"%v","y":"%v"}`, k.Crv, k.X, k.Y)
+	case ed25519.PublicKey:
+		k.Kty = "OKP"
+		k.Crv = "Ed25519"
+		k.X = encodeJWKInt(key)
+		thumbprintInput = fmt.Sprintf(`{"crv":"Ed25519","kty":"OKP","x":"%v"}`, k.X)
+	default:
+		return jwk{}, fmt.Errorf("unsupported public key type %T", key)
+	}
+	thumbprint := sha256.Sum256([]byte(thumbprintInput))
+	k.Kid = encodeJWKInt(thumbprint[:])
+	return k, nil
+}
+
+func encodeJWKInt(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// keyID returns the key id of the signer, which names its key,
+// the thumbprint of its first public key or an empty string.
+func keyID(signer Signer) string {
+	if identifier, ok := signer.(keyIdentifier); ok {
+		return identifier.KeyID()
+	}
+	keys := signer.PublicKeys()
+	if len(keys) == 0 {
+		return ""
+	}
+	k, err := newJWK(keys[0], signer.SigningMethod().Alg())
+	if err != nil {
+		return ""
+	}
+	return k.Kid
+}
+
+func (h *Handler) isJWKSPath(r *http.Request) bool {
+	return r.URL.Path == path.Join(h.config.LoginPath, "jwks.json")
+}
+
+// respondJWKS publishes the public keys of the signer for the verification by other services.
+// With the jwt secret, there is nothing to publish.
+// The key set is rendered once per configuration and answers conditional requests by its ETag.
+func (h *Handler) respondJWKS(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" && r.Method != "HEAD" {
+		h.respondBadRequest(w, r)
+		return
+	}
+	if len(h.tokenSigner().PublicKeys()) == 0 {
+		h.respondNotFound(w, r)
+		return
+	}
+	jwks := h.jwks
+	if jwks == nil {
+		jwks = newJWKSResponse(h.tokenSigner())
+	}
+	w.Header().Set("Cache-Control", jwksCacheControl)
+	jwks.ServeHTTP(w, r)
+}
+
+// newJWKSResponse renders the public keys of the signer.
+// The keys of a signer do not change, so the response is only invalidated by a new configuration.
+func newJWKSResponse(signer Signer) *cachedResponse {
+	return newCachedResponse(contentTypeJSON, func() ([]byte, error) {
+		set := jwkSet{Keys: []jwk{}}
+		for _, key := range signer.PublicKeys() {
+			k, err := newJWK(key, signer.SigningMethod().Alg())
+			if err != nil {
+				return nil, err
+			}
+			set.Keys = append(set.Keys, k)
+		}
+		b, err := json.Marshal(set)
+		if err != nil {
+			return nil, err
+		}
+		return append(b, '\n'), nil
+	})
+}
@@ -0,0 +1,177 @@
+package login
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	. "github.com/stretchr/testify/assert"
+	"github.com/tarent/loginsrv/model"
+)
+
+func writePKCS8Key(t *testing.T, key interface{}) string {
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	NoError(t, err)
+	file := filepath.Join(tmpDir(t), "jwt.pem")
+	NoError(t, ioutil.WriteFile(file, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0600))
+	return file
+}
+
+// publicKeyFromJWK decodes a key of the jwks response, like a verifying service would do
+func publicKeyFromJWK(t *testing.T, k jwk) crypto.PublicKey {
+	decode := func(s string) []byte {
+		b, err := base64.RawURLEncoding.DecodeString(s)
+		NoError(t, err)
+		return b
+	}
+	switch k.Kty {
+	case "RSA":
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(decode(k.N)), E: int(new(big.Int).SetBytes(decode(k.E)).Int64())}
+	case "EC":
+		curves := map[string]elliptic.Curve{"P-256": elliptic.P256(), "P-384": elliptic.P384(), "P-521": elliptic.P521()}
+		return &ecdsa.PublicKey{Curve: curves[k.Crv], X: new(big.Int).SetBytes(decode(k.X)), Y: new(big.Int).SetBytes(decode(k.Y))}
+	case "OKP":
+		return ed25519.PublicKey(decode(k.X))
+	}
+	t.Fatalf("unexpected key type %v", k.Kty)
+	return nil
+}
+
+func TestHandler_JWKS(t *testing.T) {
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	_, edKey, _ := ed25519.GenerateKey(rand.Reader)
+
+	tests := []struct {
+		name string
+		key  interface{}
+		kty  string
+		alg  string
+	}{
+		{"rsa", rsaKey, "RSA", "RS256"},
+		{"ecdsa", ecKey, "EC", "ES256"},
+		{"ed25519", edKey, "OKP", "EdDSA"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cfg := DefaultConfig()
+			cfg.Backends = Options{"simple": {"bob": "secret"}}
+			cfg.JwtPrivateKey = writePKCS8Key(t, test.key)
+			h, err := NewHandler(cfg)
+			NoError(t, err)
+
+			token, err := h.createToken(model.UserInfo{Sub: "bob", Expiry: time.Now().Add(time.Minute).Unix()})
+			NoError(t, err)
+
+			recorder := httptest.NewRecorder()
+			h.ServeHTTP(recorder, req("GET", "/login/jwks.json", ""))
+			Equal(t, 200, recorder.Code)
+			Equal(t, contentTypeJSON, recorder.Header().Get("Content-Type"))
+			Equal(t, "public, max-age=600", recorder.Header().Get("Cache-Control"))
+
+			var set jwkSet
+			NoError(t, json.Unmarshal(recorder.Body.Bytes(), &set))
+			Equal(t, 1, len(set.Keys))
+			Equal(t, test.kty, set.Keys[0].Kty)
+			Equal(t, test.alg, set.Keys[0].Alg)
+			Equal(t, "sig", set.Keys[0].Use)
+
+			// the token is verified with the jwks only
+			parsed, err := jwt.ParseWithClaims(token, &model.UserInfo{}, func(token *jwt.Token) (interface{}, error) {
+				for _, k := range set.Keys {
+					if k.Kid == token.Header["kid"] && k.Alg == token.Header["alg"] {
+						return publicKeyFromJWK(t, k), nil
+					}
+				}
+				return nil, jwt.ErrInvalidKey
+			})
+			NoError(t, err)
+			Equal(t, "bob", parsed.Claims.(*model.UserInfo).Sub)
+		})
+	}
+}
+
+func TestHandler_JWKS_HMAC(t *testing.T) {
+	h := testHandler()
+	recorder := httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("GET", "/context/login/jwks.json", ""))
+	Equal(t, 404, recorder.Code)
+
+	// hmac tokens have no key id
+	token, err := h.createToken(model.UserInfo{Sub: "bob", Expiry: time.Now().Add(time.Minute).Unix()})
+	NoError(t, err)
+	parsed, _, err := new(jwt.Parser).ParseUnverified(token, &model.UserInfo{})
+	NoError(t, err)
+	NotContains(t, parsed.Header, "kid")
+}
+
+func TestHandler_JWKS_Method(t *testing.T) {
+	key, _ := rsa.GenerateKey(rand.Reader, 2048)
+	cfg := DefaultConfig()
+	cfg.Backends = Options{"simple": {"bob": "secret"}}
+	cfg.JwtPrivateKey = writeRSAKey(t, key)
+	h, err := NewHandler(cfg)
+	NoError(t, err)
+
+	recorder := httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("POST", "/login/jwks.json", ""))
+	Equal(t, 400, recorder.Code)
+}
+
+func TestHandler_JWKS_Cached(t *testing.T) {
+	key, _ := rsa.GenerateKey(rand.Reader, 2048)
+	cfg := DefaultConfig()
+	cfg.Backends = Options{"simple": {"bob": "secret"}}
+	cfg.JwtPrivateKey = writeRSAKey(t, key)
+	h, err := NewHandler(cfg)
+	NoError(t, err)
+
+	recorder := httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("GET", "/login/jwks.json", ""))
+	Equal(t, 200, recorder.Code)
+	Equal(t, jwksCacheControl, recorder.Header().Get("Cache-Control"))
+	etag := recorder.Header().Get("ETag")
+	NotEmpty(t, etag)
+	body := recorder.Body.String()
+
+	// the key set is rendered once
+	rendered, err := h.jwks.get()
+	NoError(t, err)
+	Equal(t, body, string(rendered.body))
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("GET", "/login/jwks.json", "", "If-None-Match: "+etag))
+	Equal(t, 304, recorder.Code)
+
+	// a new key is published after a reload
+	other, _ := rsa.GenerateKey(rand.Reader, 2048)
+	reloaded := *cfg
+	reloaded.JwtPrivateKey = writeRSAKey(t, other)
+	NoError(t, h.reload(&reloaded))
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("GET", "/login/jwks.json", "", "If-None-Match: "+etag))
+	Equal(t, 200, recorder.Code)
+	NotEqual(t, body, recorder.Body.String())
+}
+
+func TestNewJWK_Thumbprint(t *testing.T) {
+	// the example of RFC 7638, section 3.1
+	n, _ := base64.RawURLEncoding.DecodeString("0vx7agoebGcQSuuPiLJXZptN9nndrQmbXEps2aiAFbWhM78LhWx4cbbfAAtVT86zwu1RK7aPFFxuhDR1L6tSoc_BJECPebWKRXjBZCiFV4n3oknjhMstn64tZ_2W-5JsGY4Hc5n9yBXArwl93lqt7_RN5w6Cf0h4QyQ5v-65YGjQR0_FDW2QvzqY368QQMicAtaSqzs8KJZgnYb9c7d0zgdAZHzu6qMQvRL5hajrn1n91CbOpbISD08qNLyrdkt-bFTWhAI4vMQFh6WeZu0fM4lFd2NcRwr3XPksINHaQ-G_xBniIqbw0Ls1jF44-csFCur-kEgU8awapJzKnqDKgw")
+	k, err := newJWK(&rsa.PublicKey{N: new(big.Int).SetBytes(n), E: 65537}, "RS256")
+	NoError(t, err)
+	Equal(t, "AQAB", k.E)
+	Equal(t, "NzbLsXh8uDCcd-6MNwXF4W_7noWXFZAfHkxZsRGC9Xs", k.Kid)
+}
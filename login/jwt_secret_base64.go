@@ -0,0 +1,40 @@
+package login
+
+import (
+	"encoding/base64"
+	"fmt"
+)
+
+// decodeBase64Secret decodes a jwt secret, which is configured base64 encoded,
+// e.g. because it consists of random bytes, which are not printable.
+// The name of the secret is used in the errors.
+func decodeBase64Secret(name, secret string) ([]byte, error) {
+	decoded, err := base64.StdEncoding.DecodeString(secret)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid %v: -jwt-secret-base64 is set, but the secret is not base64 encoded: %v", name, err)
+	}
+	if len(decoded) == 0 {
+		return nil, fmt.Errorf("Invalid %v: the base64 decoded secret is empty", name)
+	}
+	return decoded, nil
+}
+
+// decodeBase64Secrets replaces the secret and the keyed secrets of the signer by their base64 decoded bytes.
+func decodeBase64Secrets(s hmacSigner) (hmacSigner, error) {
+	secret, err := decodeBase64Secret("jwt secret", string(s.secret))
+	if err != nil {
+		return hmacSigner{}, err
+	}
+	s.secret = secret
+	if s.keys != nil {
+		keys := map[string][]byte{}
+		for kid, encoded := range s.keys {
+			if keys[kid], err = decodeBase64Secret("jwt secret of key id "+kid, string(encoded)); err != nil {
+				return hmacSigner{}, err
+			}
+		}
+		s.keys = keys
+		s.secret = keys[s.kid]
+	}
+	return s, nil
+}
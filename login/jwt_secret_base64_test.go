@@ -0,0 +1,116 @@
+package login
+
+import (
+	"encoding/base64"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/dgrijalva/jwt-go"
+	. "github.com/stretchr/testify/assert"
+	"github.com/tarent/loginsrv/model"
+)
+
+var rawSecret = []byte{0x00, 0xff, 0x10, 0x80, 0x7f, 0xfe, 0x01, 0x9c}
+
+func parseWithKey(token string, key []byte) error {
+	_, err := jwt.ParseWithClaims(token, &model.UserInfo{}, func(*jwt.Token) (interface{}, error) {
+		return key, nil
+	})
+	return err
+}
+
+func TestHandler_JwtSecretBase64(t *testing.T) {
+	encoded := base64.StdEncoding.EncodeToString(rawSecret)
+	for _, decode := range []bool{true, false} {
+		cfg := DefaultConfig()
+		cfg.Backends = Options{"simple": {"bob": "secret"}}
+		cfg.JwtSecret = encoded
+		cfg.JwtSecretBase64 = decode
+		h, err := NewHandler(cfg)
+		NoError(t, err)
+
+		recorder := httptest.NewRecorder()
+		h.ServeHTTP(recorder, req("POST", "/login", "username=bob&password=secret", TypeForm, AcceptJwt))
+		Equal(t, 200, recorder.Code)
+		token := recorder.Body.String()
+
+		if decode {
+			// signed with the decoded bytes, so services with the raw secret can verify it
+			NoError(t, parseWithKey(token, rawSecret))
+			Error(t, parseWithKey(token, []byte(encoded)))
+		} else {
+			// existing deployments still sign with the string as it is
+			NoError(t, parseWithKey(token, []byte(encoded)))
+			Error(t, parseWithKey(token, rawSecret))
+		}
+
+		// the handler accepts its own tokens
+		recorder = httptest.NewRecorder()
+		h.ServeHTTP(recorder, req("GET", "/login", "", AcceptJwt, "Authorization: Bearer "+token))
+		Equal(t, 200, recorder.Code, "decode=%v", decode)
+	}
+}
+
+func TestTokenService_JwtSecretBase64(t *testing.T) {
+	encoded := base64.StdEncoding.EncodeToString(rawSecret)
+	cfg := tokenServiceConfig(encoded)
+	cfg.JwtSecretBase64 = true
+	tokens, err := NewTokenService(cfg)
+	NoError(t, err)
+	token, err := tokens.Issue(model.UserInfo{Sub: "bob"})
+	NoError(t, err)
+	NoError(t, parseWithKey(token, rawSecret))
+	_, err = tokens.Verify(token)
+	NoError(t, err)
+
+	cfg = tokenServiceConfig(encoded)
+	tokens, err = NewTokenService(cfg)
+	NoError(t, err)
+	token, err = tokens.Issue(model.UserInfo{Sub: "bob"})
+	NoError(t, err)
+	NoError(t, parseWithKey(token, []byte(encoded)))
+}
+
+func TestJwtSecretBase64_KeyedAndFallback(t *testing.T) {
+	newKey := []byte{0x01, 0x02, 0xff}
+	oldKey := []byte{0xfe, 0x00}
+	cfg := tokenServiceConfig("key2:" + base64.StdEncoding.EncodeToString(newKey) + ",key1:" + base64.StdEncoding.EncodeToString(oldKey))
+	cfg.JwtSecretFallback = base64.StdEncoding.EncodeToString(rawSecret)
+	cfg.JwtSecretBase64 = true
+	tokens, err := NewTokenService(cfg)
+	NoError(t, err)
+
+	token, err := tokens.Issue(model.UserInfo{Sub: "bob"})
+	NoError(t, err)
+	NoError(t, parseWithKey(token, newKey))
+
+	// tokens of the fallback secret are verified with the decoded fallback
+	old, err := jwt.NewWithClaims(jwt.SigningMethodHS512, model.UserInfo{Sub: "bob", Expiry: 4102444800}).SignedString(rawSecret)
+	NoError(t, err)
+	_, err = tokens.Verify(old)
+	NoError(t, err)
+}
+
+func TestJwtSecretBase64_Invalid(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Backends = Options{"simple": {"bob": "secret"}}
+	cfg.JwtSecret = "not base64!"
+	cfg.JwtSecretBase64 = true
+	_, err := NewHandler(cfg)
+	Error(t, err)
+	Contains(t, err.Error(), "Invalid jwt secret: -jwt-secret-base64 is set, but the secret is not base64 encoded")
+
+	_, err = NewTokenService(tokenServiceConfig("not base64!"))
+	NoError(t, err)
+	cfg = tokenServiceConfig("not base64!")
+	cfg.JwtSecretBase64 = true
+	_, err = NewTokenService(cfg)
+	Error(t, err)
+
+	cfg = tokenServiceConfig(base64.StdEncoding.EncodeToString(rawSecret))
+	cfg.JwtSecretFallback = "not base64!"
+	cfg.JwtSecretBase64 = true
+	_, err = NewTokenService(cfg)
+	Error(t, err)
+	Contains(t, err.Error(), "Invalid jwt secret fallback")
+}
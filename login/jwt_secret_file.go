@@ -0,0 +1,30 @@
+package login
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"github.com/tarent/loginsrv/logging"
+)
+
+// loadJwtSecretFile sets the jwt secret to the content of the -jwt-secret-file, without the trailing newline.
+// The file takes precedence over -jwt-secret.
+func loadJwtSecretFile(config *Config) error {
+	if config.JwtSecretFile == "" {
+		return nil
+	}
+	content, err := ioutil.ReadFile(config.JwtSecretFile)
+	if err != nil {
+		return fmt.Errorf("Invalid jwt secret file: %v", err)
+	}
+	secret := strings.TrimRight(string(content), "\r\n")
+	if secret == "" {
+		return fmt.Errorf("Invalid jwt secret file %v: the file is empty", config.JwtSecretFile)
+	}
+	if config.JwtSecret != jwtDefaultSecret && config.JwtSecret != secret {
+		logging.Logger.Warnf("both -jwt-secret and -jwt-secret-file are set, using the secret of %v", config.JwtSecretFile)
+	}
+	config.JwtSecret = secret
+	return nil
+}
@@ -0,0 +1,55 @@
+package login
+
+import (
+	"io/ioutil"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	. "github.com/stretchr/testify/assert"
+)
+
+func TestHandler_JwtSecretFile(t *testing.T) {
+	secretFile := filepath.Join(tmpDir(t), "jwt-secret")
+	NoError(t, ioutil.WriteFile(secretFile, []byte("the-mounted-secret\n"), 0600))
+
+	cfg := DefaultConfig()
+	cfg.Backends = Options{"simple": {"bob": "secret"}}
+	cfg.JwtSecret = "the-flag-secret"
+	cfg.JwtSecretFile = secretFile
+	h, err := NewHandler(cfg)
+	NoError(t, err)
+	Equal(t, "the-mounted-secret", cfg.JwtSecret)
+
+	recorder := httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("POST", "/login", "username=bob&password=secret", TypeForm, AcceptJwt))
+	Equal(t, 200, recorder.Code)
+
+	// the token is signed with the secret of the file
+	tokens, err := NewTokenService(tokenServiceConfig("the-mounted-secret"))
+	NoError(t, err)
+	_, err = tokens.Verify(recorder.Body.String())
+	NoError(t, err)
+
+	// the token service of the configuration uses the file as well
+	cfg = DefaultConfig()
+	cfg.JwtSecretFile = secretFile
+	tokens, err = NewTokenService(cfg)
+	NoError(t, err)
+	_, err = tokens.Verify(recorder.Body.String())
+	NoError(t, err)
+}
+
+func TestHandler_JwtSecretFile_Invalid(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Backends = Options{"simple": {"bob": "secret"}}
+	cfg.JwtSecretFile = filepath.Join(tmpDir(t), "missing")
+	_, err := NewHandler(cfg)
+	Error(t, err)
+	Contains(t, err.Error(), "Invalid jwt secret file")
+
+	cfg.JwtSecretFile = filepath.Join(tmpDir(t), "empty")
+	NoError(t, ioutil.WriteFile(cfg.JwtSecretFile, []byte("\n"), 0600))
+	_, err = NewHandler(cfg)
+	EqualError(t, err, "Invalid jwt secret file "+cfg.JwtSecretFile+": the file is empty")
+}
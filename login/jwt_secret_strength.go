@@ -0,0 +1,107 @@
+package login
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/tarent/loginsrv/logging"
+)
+
+// jwtSecretRandom as -jwt-secret generates a random secret on startup
+const jwtSecretRandom = "random"
+
+// recommendedJwtSecretLength is the length in bytes, below which a secret is logged as weak without -jwt-secret-min-length
+const recommendedJwtSecretLength = 32
+
+var (
+	randomJwtSecretOnce sync.Once
+	randomJwtSecret     string
+	randomJwtSecretErr  error
+)
+
+// resolveJwtSecret sets the jwt secret to the content of the -jwt-secret-file
+// or, for -jwt-secret random, to a random secret.
+func resolveJwtSecret(config *Config) error {
+	if err := loadJwtSecretFile(config); err != nil {
+		return err
+	}
+	if config.JwtSecret != jwtSecretRandom {
+		return nil
+	}
+	secret, err := generatedJwtSecret()
+	if err != nil {
+		return err
+	}
+	config.JwtSecret = secret
+	return nil
+}
+
+// generatedJwtSecret returns the random secret of -jwt-secret random.
+// It is generated once per process, so a reload keeps the sessions, but a restart invalidates them.
+// Only the fingerprint of the secret is logged. The secret is base64 encoded, so it works with -jwt-secret-base64 as well.
+func generatedJwtSecret() (string, error) {
+	randomJwtSecretOnce.Do(func() {
+		b := make([]byte, 32)
+		if _, err := rand.Read(b); err != nil {
+			randomJwtSecretErr = fmt.Errorf("Could not generate a random jwt secret: %v", err)
+			return
+		}
+		randomJwtSecret = base64.StdEncoding.EncodeToString(b)
+		logging.Logger.Warnf("generated a random jwt secret with the fingerprint %v, a restart invalidates all sessions", jwtSecretFingerprint(randomJwtSecret))
+	})
+	return randomJwtSecret, randomJwtSecretErr
+}
+
+// jwtSecretFingerprint identifies a secret in the logs without revealing it
+func jwtSecretFingerprint(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return "sha256:" + hex.EncodeToString(sum[:8])
+}
+
+// checkJwtSecretLength rejects empty secrets and, with -jwt-secret-min-length, secrets shorter than the minimum.
+// Without a minimum, short secrets keep working for existing deployments, but are logged as weak.
+// The length is counted in bytes, after the base64 decoding of -jwt-secret-base64.
+// The name of the secret is used in the errors.
+func checkJwtSecretLength(name string, secret []byte, minLength int) error {
+	if len(secret) == 0 {
+		return fmt.Errorf("Invalid %v: the secret must not be empty", name)
+	}
+	if len(secret) < minLength {
+		return fmt.Errorf("Invalid %v: the secret has %v bytes, at least %v are required by -jwt-secret-min-length", name, len(secret), minLength)
+	}
+	if minLength == 0 && len(secret) < recommendedJwtSecretLength {
+		logging.Logger.Warnf("the %v has only %v bytes, at least %v are recommended, see -jwt-secret-min-length", name, len(secret), recommendedJwtSecretLength)
+	}
+	return nil
+}
+
+// checkHMACSecretLengths checks the secret of the signer and its keyed secrets.
+func checkHMACSecretLengths(s hmacSigner, minLength int) error {
+	if minLength < 0 {
+		return errors.New("The jwt secret min length must not be negative")
+	}
+	if s.keys == nil {
+		return checkJwtSecretLength("jwt secret", s.secret, minLength)
+	}
+	for _, kid := range sortedKeyIDs(s.keys) {
+		if err := checkJwtSecretLength("jwt secret of key id "+kid, s.keys[kid], minLength); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func sortedKeyIDs(keys map[string][]byte) []string {
+	kids := make([]string, 0, len(keys))
+	for kid := range keys {
+		kids = append(kids, kid)
+	}
+	sort.Strings(kids)
+	return kids
+}
@@ -0,0 +1,80 @@
+package login
+
+import (
+	"encoding/base64"
+	"net/http/httptest"
+	"testing"
+
+	. "github.com/stretchr/testify/assert"
+)
+
+func TestHandler_JwtSecretRandom(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Backends = Options{"simple": {"bob": "secret"}}
+	cfg.JwtSecret = "random"
+	h, err := NewHandler(cfg)
+	NoError(t, err)
+	NotEqual(t, "random", cfg.JwtSecret)
+	secret, err := base64.StdEncoding.DecodeString(cfg.JwtSecret)
+	NoError(t, err)
+	Equal(t, 32, len(secret))
+
+	recorder := httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("POST", "/login", "username=bob&password=secret", TypeForm, AcceptJwt))
+	Equal(t, 200, recorder.Code)
+	tokens, err := NewTokenService(tokenServiceConfig(cfg.JwtSecret))
+	NoError(t, err)
+	_, err = tokens.Verify(recorder.Body.String())
+	NoError(t, err)
+
+	// the secret is generated once per process, so a reload keeps the sessions
+	other := DefaultConfig()
+	other.Backends = Options{"simple": {"bob": "secret"}}
+	other.JwtSecret = "random"
+	other.JwtSecretBase64 = true
+	_, err = NewHandler(other)
+	NoError(t, err)
+	Equal(t, cfg.JwtSecret, other.JwtSecret)
+}
+
+func TestHandler_JwtSecretMinLength(t *testing.T) {
+	tests := []struct {
+		secret    string
+		base64    bool
+		fallback  string
+		minLength int
+		err       string
+	}{
+		{"", false, "", 0, "Invalid jwt secret: the secret must not be empty"},
+		{"short", false, "", 0, ""},
+		{"short", false, "", 16, "Invalid jwt secret: the secret has 5 bytes, at least 16 are required by -jwt-secret-min-length"},
+		{"a-secret-of-16-b", false, "", 16, ""},
+		{base64.StdEncoding.EncodeToString([]byte("a-secret-of-16-b")), true, "", 17, "Invalid jwt secret: the secret has 16 bytes"},
+		{"key2:a-secret-of-16-b,key1:short", false, "", 16, "Invalid jwt secret of key id key1"},
+		{"a-secret-of-16-b", false, "short", 16, "Invalid jwt secret fallback: the secret has 5 bytes"},
+		{"a-secret-of-16-b", false, "", -1, "The jwt secret min length must not be negative"},
+		{"random", false, "", 32, ""},
+	}
+	for _, test := range tests {
+		cfg := DefaultConfig()
+		cfg.Backends = Options{"simple": {"bob": "secret"}}
+		cfg.JwtSecret = test.secret
+		cfg.JwtSecretBase64 = test.base64
+		cfg.JwtSecretFallback = test.fallback
+		cfg.JwtSecretMinLength = test.minLength
+		_, err := NewHandler(cfg)
+		if test.err == "" {
+			NoError(t, err, test.secret)
+		} else if Error(t, err, test.secret) {
+			Contains(t, err.Error(), test.err)
+		}
+	}
+}
+
+func TestJwtSecretFingerprint(t *testing.T) {
+	fingerprint := jwtSecretFingerprint("the-secret")
+	Regexp(t, "^sha256:[0-9a-f]{16}$", fingerprint)
+	NotContains(t, fingerprint, "the-secret")
+	Equal(t, fingerprint, jwtSecretFingerprint("the-secret"))
+	NotEqual(t, fingerprint, jwtSecretFingerprint("another-secret"))
+}
@@ -0,0 +1,53 @@
+package login
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// keyIDPattern is the format of the key ids of keyed secrets
+var keyIDPattern = regexp.MustCompile(`^[A-Za-z0-9._-]{1,32}$`)
+
+// parseJwtSecret returns the signer for the jwt secret.
+// A list of keyed secrets in the form `kid1:secret1,kid2:secret2` signs with the first secret
+// and sets its key id in the token header. The tokens are verified with the secret of their key id,
+// tokens without a key id with the first secret.
+// A secret, which is no such list, e.g. because the part before a colon is no valid key id, is used as it is.
+func parseJwtSecret(jwtSecret string) (hmacSigner, error) {
+	parts := strings.Split(jwtSecret, ",")
+	keys := map[string][]byte{}
+	var primary string
+	for _, part := range parts {
+		kv := strings.SplitN(strings.TrimSpace(part), ":", 2)
+		if len(kv) != 2 || !keyIDPattern.MatchString(kv[0]) || kv[1] == "" {
+			return hmacSigner{secret: []byte(jwtSecret)}, nil
+		}
+		if _, exist := keys[kv[0]]; exist {
+			return hmacSigner{}, fmt.Errorf("Duplicate key id %v in the jwt secret", kv[0])
+		}
+		if primary == "" {
+			primary = kv[0]
+		}
+		keys[kv[0]] = []byte(kv[1])
+	}
+	return hmacSigner{secret: keys[primary], kid: primary, keys: keys}, nil
+}
+
+// KeyID is the key id of the primary secret, or empty for a single secret without key id.
+func (s hmacSigner) KeyID() string {
+	return s.kid
+}
+
+// VerificationKeyFor selects the secret by the key id of the token.
+// Without keyed secrets, the key id is ignored.
+func (s hmacSigner) VerificationKeyFor(kid string) (interface{}, error) {
+	if s.keys == nil || kid == "" {
+		return s.secret, nil
+	}
+	secret, exist := s.keys[kid]
+	if !exist {
+		return nil, fmt.Errorf("unknown key id %q", kid)
+	}
+	return secret, nil
+}
@@ -0,0 +1,105 @@
+package login
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	. "github.com/stretchr/testify/assert"
+	"github.com/tarent/loginsrv/model"
+)
+
+func TestParseJwtSecret(t *testing.T) {
+	s, err := parseJwtSecret("key2:new-secret, key1:old-secret")
+	NoError(t, err)
+	Equal(t, "key2", s.KeyID())
+	Equal(t, []byte("new-secret"), s.secret)
+	Equal(t, map[string][]byte{"key1": []byte("old-secret"), "key2": []byte("new-secret")}, s.keys)
+
+	// secrets, which are no list of keyed secrets, are used as they are
+	for _, secret := range []string{"plain", "a:b,plain", "with space:secret", "key1:", "a:b:c,::"} {
+		s, err = parseJwtSecret(secret)
+		NoError(t, err)
+		Equal(t, "", s.KeyID(), secret)
+		Equal(t, []byte(secret), s.secret, secret)
+	}
+
+	// the secret may contain colons
+	s, err = parseJwtSecret("key1:a:b")
+	NoError(t, err)
+	Equal(t, []byte("a:b"), s.secret)
+
+	_, err = parseJwtSecret("key1:a,key1:b")
+	EqualError(t, err, "Duplicate key id key1 in the jwt secret")
+}
+
+func TestHandler_KeyedSecrets(t *testing.T) {
+	cfg := tokenServiceConfig("key2:new-secret,key1:old-secret")
+	cfg.JwtRefreshes = 1
+	h, err := NewHandler(cfg)
+	NoError(t, err)
+
+	recorder := httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("POST", "/login", "username=bob&password=secret", TypeForm, AcceptJwt))
+	Equal(t, 200, recorder.Code)
+	token, err := jwt.ParseWithClaims(recorder.Body.String(), &model.UserInfo{}, func(token *jwt.Token) (interface{}, error) {
+		return []byte("new-secret"), nil
+	})
+	NoError(t, err)
+	Equal(t, "key2", token.Header["kid"])
+
+	userInfo := model.UserInfo{Sub: "bob", Expiry: time.Now().Add(time.Minute).Unix()}
+	sign := func(secret, kid string) string {
+		token := jwt.NewWithClaims(jwt.SigningMethodHS512, userInfo)
+		if kid != "" {
+			token.Header["kid"] = kid
+		}
+		signed, err := token.SignedString([]byte(secret))
+		NoError(t, err)
+		return signed
+	}
+
+	tests := []struct {
+		name    string
+		token   string
+		failure TokenFailure
+	}{
+		{"primary key", sign("new-secret", "key2"), ""},
+		{"previous key", sign("old-secret", "key1"), ""},
+		{"missing kid with the primary secret", sign("new-secret", ""), ""},
+		{"missing kid with another secret", sign("old-secret", ""), TokenInvalidSignature},
+		{"unknown kid", sign("new-secret", "key3"), TokenInvalidSignature},
+		{"kid of another secret", sign("new-secret", "key1"), TokenInvalidSignature},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, failure := h.VerifyToken(req("GET", "/login", ""), test.token)
+			Equal(t, test.failure, failure)
+		})
+	}
+
+	// a token of the previous key is refreshed with the primary key
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("POST", "/login", "", AcceptJwt, "Cookie: "+cfg.CookieName+"="+sign("old-secret", "key1")))
+	Equal(t, 200, recorder.Code)
+	refreshed, _, err := new(jwt.Parser).ParseUnverified(recorder.Body.String(), &model.UserInfo{})
+	NoError(t, err)
+	Equal(t, "key2", refreshed.Header["kid"])
+}
+
+func TestHandler_PlainSecret_NoKid(t *testing.T) {
+	// tokens of a single secret have no key id, a key id in the token is ignored
+	h := testHandler()
+	token, err := h.createToken(model.UserInfo{Sub: "bob", Expiry: time.Now().Add(time.Minute).Unix()})
+	NoError(t, err)
+	parsed, _, err := new(jwt.Parser).ParseUnverified(token, &model.UserInfo{})
+	NoError(t, err)
+	NotContains(t, parsed.Header, "kid")
+
+	withKid := jwt.NewWithClaims(jwt.SigningMethodHS512, model.UserInfo{Sub: "bob", Expiry: time.Now().Add(time.Minute).Unix()})
+	withKid.Header["kid"] = "key1"
+	signed, _ := withKid.SignedString([]byte(h.config.JwtSecret))
+	_, valid := h.GetToken(req("GET", "/login", ""), signed)
+	True(t, valid)
+}
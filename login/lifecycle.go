@@ -0,0 +1,118 @@
+package login
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tarent/loginsrv/logging"
+)
+
+// Hook is the start and stop function pair of a component,
+// which runs goroutines in the background.
+// Stop has to return, when the context is done.
+type Hook struct {
+	Name  string
+	Start func() error
+	Stop  func(ctx context.Context) error
+}
+
+// Lifecycle is a registry of hooks, which are started in the order
+// of registration and stopped in reverse order.
+type Lifecycle struct {
+	hooks   []Hook
+	started int
+	mu      sync.Mutex
+}
+
+// Register adds hooks to the lifecycle.
+func (l *Lifecycle) Register(hooks ...Hook) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.hooks = append(l.hooks, hooks...)
+}
+
+// Start starts all registered hooks, which are not started, yet.
+// It stops at the first failing hook and returns its error.
+func (l *Lifecycle) Start() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for ; l.started < len(l.hooks); l.started++ {
+		hook := l.hooks[l.started]
+		if hook.Start == nil {
+			continue
+		}
+		if err := hook.Start(); err != nil {
+			return fmt.Errorf("error starting %v: %v", hook.Name, err)
+		}
+	}
+	return nil
+}
+
+// Stop stops all started hooks in reverse order and logs the duration of each shutdown.
+// All hooks are stopped, even if some of them fail.
+func (l *Lifecycle) Stop(ctx context.Context) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	var failed []string
+	for ; l.started > 0; l.started-- {
+		hook := l.hooks[l.started-1]
+		if hook.Stop == nil {
+			continue
+		}
+		start := time.Now()
+		err := hook.Stop(ctx)
+		logging.ComponentStopped(hook.Name, time.Since(start), err)
+		if err != nil {
+			failed = append(failed, hook.Name)
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("error stopping: %v", strings.Join(failed, ", "))
+	}
+	return nil
+}
+
+// backgroundTask runs a function periodically in its own goroutine,
+// until it gets stopped.
+type backgroundTask struct {
+	interval time.Duration
+	run      func()
+	stop     chan struct{}
+	done     chan struct{}
+}
+
+func (t *backgroundTask) hook(name string) Hook {
+	return Hook{Name: name, Start: t.start, Stop: t.shutdown}
+}
+
+func (t *backgroundTask) start() error {
+	t.stop = make(chan struct{})
+	t.done = make(chan struct{})
+	go func() {
+		defer close(t.done)
+		ticker := time.NewTicker(t.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				t.run()
+			case <-t.stop:
+				return
+			}
+		}
+	}()
+	return nil
+}
+
+func (t *backgroundTask) shutdown(ctx context.Context) error {
+	close(t.stop)
+	select {
+	case <-t.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
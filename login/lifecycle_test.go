@@ -0,0 +1,85 @@
+package login
+
+import (
+	"context"
+	"errors"
+	. "github.com/stretchr/testify/assert"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestLifecycle_StartStopOrder(t *testing.T) {
+	var calls []string
+	hook := func(name string) Hook {
+		return Hook{
+			Name:  name,
+			Start: func() error { calls = append(calls, "start "+name); return nil },
+			Stop:  func(ctx context.Context) error { calls = append(calls, "stop "+name); return nil },
+		}
+	}
+
+	l := &Lifecycle{}
+	l.Register(hook("a"), hook("b"))
+	NoError(t, l.Start())
+	NoError(t, l.Stop(context.Background()))
+	Equal(t, []string{"start a", "start b", "stop b", "stop a"}, calls)
+
+	// stopping twice does nothing
+	NoError(t, l.Stop(context.Background()))
+	Equal(t, 4, len(calls))
+}
+
+func TestLifecycle_Errors(t *testing.T) {
+	var stopped []string
+	l := &Lifecycle{}
+	l.Register(
+		Hook{Name: "a", Stop: func(ctx context.Context) error { stopped = append(stopped, "a"); return nil }},
+		Hook{Name: "b", Stop: func(ctx context.Context) error { stopped = append(stopped, "b"); return errors.New("stop error") }},
+		Hook{Name: "c", Start: func() error { return errors.New("start error") }},
+		Hook{Name: "d", Stop: func(ctx context.Context) error { stopped = append(stopped, "d"); return nil }},
+	)
+
+	EqualError(t, l.Start(), "error starting c: start error")
+
+	// only the started hooks are stopped, even if one fails
+	EqualError(t, l.Stop(context.Background()), "error stopping: b")
+	Equal(t, []string{"b", "a"}, stopped)
+}
+
+func TestLifecycle_HandlerHooksDoNotLeak(t *testing.T) {
+	before := runtime.NumGoroutine()
+
+	cfg := DefaultConfig()
+	cfg.Backends = Options{"simple": {"bob": "secret"}}
+	cfg.ConflictCheckInterval = time.Millisecond
+	h, err := NewHandler(cfg)
+	NoError(t, err)
+
+	l := &Lifecycle{}
+	l.Register(h.Hooks()...)
+	NoError(t, l.Start())
+	time.Sleep(5 * time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	NoError(t, l.Stop(ctx))
+
+	// goroutines of other tests may still be finishing
+	for i := 0; i < 100 && runtime.NumGoroutine() > before; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	True(t, runtime.NumGoroutine() <= before)
+}
+
+func TestLifecycle_StopTimeout(t *testing.T) {
+	task := &backgroundTask{interval: time.Millisecond, run: func() { time.Sleep(50 * time.Millisecond) }}
+	NoError(t, task.start())
+	time.Sleep(5 * time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+	defer cancel()
+	Equal(t, context.DeadlineExceeded, task.shutdown(ctx))
+
+	<-task.done
+}
@@ -0,0 +1,178 @@
+package login
+
+import (
+	"container/heap"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/tarent/loginsrv/logging"
+)
+
+const (
+	loginFailureNamespace        = "login_failures"
+	loginFailureNamespaceVersion = 1
+)
+
+// loginFailureWindow is the time, the failed logins of a user are counted in.
+// The window starts with the first failure, a successful login resets the count.
+var loginFailureWindow = 15 * time.Minute
+
+// loginFailureTop is the number of users with the most failures in the health response
+var loginFailureTop = 10
+
+// loginFailureStatsMaxAge is the time, the aggregates are reused before the store is scanned again
+var loginFailureStatsMaxAge = time.Minute
+
+// the upper bounds of the buckets of the failures per user histogram
+var loginFailureBuckets = []float64{1, 2, 5, 10, 20, 50, 100}
+
+// loginFailures counts the failed logins per user in the store, as base for lockouts or throttling.
+// The users are only known by a keyed hash, so neither the store nor the aggregates contain usernames.
+// Only aggregates of a bounded size are exposed, independent of the number of users.
+type loginFailures struct {
+	store Store
+
+	mu    sync.Mutex
+	stats *loginFailureStats
+}
+
+// loginFailureStats are the aggregates of the failures in the health response
+type loginFailureStats struct {
+	Users           int            `json:"users"`
+	Top             []userFailures `json:"top"`
+	FailuresPerUser histogram      `json:"failures_per_user"`
+	Updated         time.Time      `json:"updated"`
+}
+
+// userFailures is the failure count of the user with the hashed name
+type userFailures struct {
+	User     string `json:"user"`
+	Failures int    `json:"failures"`
+}
+
+func newLoginFailures(store Store) *loginFailures {
+	return &loginFailures{store: store}
+}
+
+// loginFailureKey is the hash of the username in the store and the aggregates,
+// keyed by a key derived from the jwt secret.
+func (h *Handler) loginFailureKey(username string) string {
+	mac := hmac.New(sha256.New, backendKey(h.config.JwtSecret, loginFailureNamespace))
+	mac.Write([]byte(username))
+	return hex.EncodeToString(mac.Sum(nil)[:16])
+}
+
+// record counts a failed login of the user and returns the count within the window
+func (f *loginFailures) record(key string) int {
+	count, err := f.store.Increment(loginFailureNamespace, key, loginFailureWindow)
+	if err != nil {
+		logging.Logger.WithError(err).Error("could not count the failed login")
+	}
+	return count
+}
+
+// reset deletes the count of the user after a successful login
+func (f *loginFailures) reset(key string) {
+	if err := f.store.Delete(loginFailureNamespace, key); err != nil {
+		logging.Logger.WithError(err).Error("could not reset the failed logins")
+	}
+}
+
+// count returns the failures of the user within the window
+func (f *loginFailures) count(key string) int {
+	count, _ := storeCount(f.store, loginFailureNamespace, key)
+	return count
+}
+
+// refresh computes the aggregates in one scan of the store.
+// The top users are kept in a min heap of the size of the top list.
+func (f *loginFailures) refresh(now time.Time) *loginFailureStats {
+	stats := &loginFailureStats{
+		Top:             []userFailures{},
+		FailuresPerUser: histogram{Buckets: make([]histogramBucket, len(loginFailureBuckets))},
+		Updated:         now,
+	}
+	for i, le := range loginFailureBuckets {
+		stats.FailuresPerUser.Buckets[i].Le = le
+	}
+
+	top := &userFailuresHeap{}
+	err := f.store.Scan(loginFailureNamespace, func(key, value string) {
+		count, err := strconv.Atoi(value)
+		if err != nil || count == 0 {
+			return
+		}
+		stats.Users++
+		stats.FailuresPerUser.observe(float64(count))
+		if loginFailureTop <= 0 {
+			return
+		}
+		entry := userFailures{User: key, Failures: count}
+		if top.Len() < loginFailureTop {
+			heap.Push(top, entry)
+		} else if top.less(top.items[0], entry) {
+			top.items[0] = entry
+			heap.Fix(top, 0)
+		}
+	})
+	if err != nil {
+		logging.Logger.WithError(err).Error("could not scan the failed logins")
+	}
+
+	stats.Top = append(stats.Top, top.items...)
+	sort.Slice(stats.Top, func(i, j int) bool {
+		return top.less(stats.Top[j], stats.Top[i])
+	})
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.stats = stats
+	return stats
+}
+
+// status returns the aggregates, which are refreshed if they are older than the max age.
+// It returns nil, if no failures are counted.
+func (f *loginFailures) status(now time.Time) *loginFailureStats {
+	f.mu.Lock()
+	stats := f.stats
+	f.mu.Unlock()
+	if stats == nil || now.Sub(stats.Updated) >= loginFailureStatsMaxAge {
+		stats = f.refresh(now)
+	}
+	if stats.Users == 0 {
+		return nil
+	}
+	return stats
+}
+
+// userFailuresHeap is a min heap of failure counts.
+// On equal counts, the greater hash is the lesser entry, so that the top list is deterministic.
+type userFailuresHeap struct {
+	items []userFailures
+}
+
+func (h *userFailuresHeap) less(a, b userFailures) bool {
+	if a.Failures != b.Failures {
+		return a.Failures < b.Failures
+	}
+	return a.User > b.User
+}
+
+func (h *userFailuresHeap) Len() int           { return len(h.items) }
+func (h *userFailuresHeap) Less(i, j int) bool { return h.less(h.items[i], h.items[j]) }
+func (h *userFailuresHeap) Swap(i, j int)      { h.items[i], h.items[j] = h.items[j], h.items[i] }
+
+func (h *userFailuresHeap) Push(x interface{}) {
+	h.items = append(h.items, x.(userFailures))
+}
+
+func (h *userFailuresHeap) Pop() interface{} {
+	last := h.items[len(h.items)-1]
+	h.items = h.items[:len(h.items)-1]
+	return last
+}
@@ -0,0 +1,99 @@
+package login
+
+import (
+	"fmt"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	. "github.com/stretchr/testify/assert"
+)
+
+func TestLoginFailures_Refresh(t *testing.T) {
+	defer func(top int) { loginFailureTop = top }(loginFailureTop)
+	loginFailureTop = 3
+
+	now := time.Now()
+	f := newLoginFailures(memoryStore{newTTLStore()})
+	Nil(t, f.status(now))
+
+	for user, count := range map[string]int{"a": 1, "b": 7, "c": 3, "d": 12, "e": 3, "f": 3} {
+		for i := 0; i < count; i++ {
+			f.record(user)
+		}
+	}
+
+	stats := f.refresh(now)
+	Equal(t, 6, stats.Users)
+	// on equal counts, the smaller hash wins
+	Equal(t, []userFailures{{"d", 12}, {"b", 7}, {"c", 3}}, stats.Top)
+	Equal(t, int64(6), stats.FailuresPerUser.Count)
+	Equal(t, 29.0, stats.FailuresPerUser.Sum)
+	Equal(t, histogramBucket{Le: 1, Count: 1}, stats.FailuresPerUser.Buckets[0])
+	Equal(t, histogramBucket{Le: 5, Count: 4}, stats.FailuresPerUser.Buckets[2])
+	Equal(t, histogramBucket{Le: 10, Count: 5}, stats.FailuresPerUser.Buckets[3])
+	Equal(t, histogramBucket{Le: 20, Count: 6}, stats.FailuresPerUser.Buckets[4])
+
+	// the aggregates are reused within the max age
+	f.reset("d")
+	Equal(t, 0, f.count("d"))
+	Equal(t, stats, f.status(now.Add(loginFailureStatsMaxAge-time.Second)))
+	Equal(t, []userFailures{{"b", 7}, {"c", 3}, {"e", 3}}, f.status(now.Add(loginFailureStatsMaxAge)).Top)
+}
+
+func TestLoginFailures_Concurrent(t *testing.T) {
+	defer func(top int) { loginFailureTop = top }(loginFailureTop)
+	loginFailureTop = 5
+
+	f := newLoginFailures(memoryStore{newTTLStore()})
+	var wg sync.WaitGroup
+	// user i fails i times, recorded by 4 goroutines in parallel to the computation of the aggregates
+	for worker := 0; worker < 4; worker++ {
+		wg.Add(1)
+		go func(worker int) {
+			defer wg.Done()
+			for user := 1; user <= 50; user++ {
+				for i := worker; i < user; i += 4 {
+					f.record(fmt.Sprintf("user%02d", user))
+				}
+				if user%10 == 0 {
+					f.refresh(time.Now())
+				}
+			}
+		}(worker)
+	}
+	wg.Wait()
+
+	stats := f.refresh(time.Now())
+	Equal(t, 50, stats.Users)
+	Equal(t, []userFailures{{"user50", 50}, {"user49", 49}, {"user48", 48}, {"user47", 47}, {"user46", 46}}, stats.Top)
+	Equal(t, int64(50), stats.FailuresPerUser.Count)
+	Equal(t, float64(50*51/2), stats.FailuresPerUser.Sum)
+	for user := 1; user <= 50; user++ {
+		Equal(t, user, f.count("user"+strconv.Itoa(user/10)+strconv.Itoa(user%10)))
+	}
+}
+
+func TestHandler_LoginFailures(t *testing.T) {
+	h := testHandler()
+
+	for i := 0; i < 3; i++ {
+		h.ServeHTTP(httptest.NewRecorder(), req("POST", "/context/login", fmt.Sprintf(`{"username": "bob", "password": "wrong%v"}`, i), TypeJSON, AcceptJwt))
+	}
+	h.ServeHTTP(httptest.NewRecorder(), req("POST", "/context/login", `{"username": "alice", "password": "wrong"}`, TypeJSON, AcceptJwt))
+	Equal(t, 3, h.failures.count(h.loginFailureKey("bob")))
+	Equal(t, 1, h.failures.count(h.loginFailureKey("alice")))
+
+	// the usernames are hashed
+	recorder := httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("GET", "/context/login/health", ""))
+	Contains(t, recorder.Body.String(), fmt.Sprintf(`{"user":"%v","failures":3}`, h.loginFailureKey("bob")))
+	NotContains(t, recorder.Body.String(), "bob")
+	NotEqual(t, h.loginFailureKey("bob"), (&Handler{config: &Config{JwtSecret: "other"}}).loginFailureKey("bob"))
+
+	// a successful login resets the count
+	h.ServeHTTP(httptest.NewRecorder(), req("POST", "/context/login", `{"username": "bob", "password": "secret"}`, TypeJSON, AcceptJwt))
+	Equal(t, 0, h.failures.count(h.loginFailureKey("bob")))
+}
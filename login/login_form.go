@@ -2,10 +2,12 @@ package login
 
 import (
 	"bytes"
+	"fmt"
 	"html/template"
 	"io/ioutil"
 	"net/http"
 	"strings"
+	"time"
 
 	"github.com/tarent/loginsrv/logging"
 	"github.com/tarent/loginsrv/model"
@@ -14,10 +16,10 @@ import (
 const partials = `
 
 {{define "styles"}}
-    <link uic-remove rel="stylesheet" href="https://maxcdn.bootstrapcdn.com/bootstrap/3.3.5/css/bootstrap.min.css">
-    <link uic-remove rel="stylesheet" href="https://cdnjs.cloudflare.com/ajax/libs/bootstrap-social/5.1.1/bootstrap-social.min.css">
-    <link uic-remove rel="stylesheet" href="https://cdnjs.cloudflare.com/ajax/libs/font-awesome/4.7.0/css/font-awesome.css">
-    <style>
+    <link uic-remove rel="stylesheet" nonce="{{.CSPNonce}}" href="https://maxcdn.bootstrapcdn.com/bootstrap/3.3.5/css/bootstrap.min.css">
+    <link uic-remove rel="stylesheet" nonce="{{.CSPNonce}}" href="https://cdnjs.cloudflare.com/ajax/libs/bootstrap-social/5.1.1/bootstrap-social.min.css">
+    <link uic-remove rel="stylesheet" nonce="{{.CSPNonce}}" href="https://cdnjs.cloudflare.com/ajax/libs/font-awesome/4.7.0/css/font-awesome.css">
+    <style nonce="{{.CSPNonce}}">
      .vertical-offset-100{
        padding-top:100px;
      }
@@ -26,7 +28,7 @@ const partials = `
        margin: 0;
        margin-bottom: 10px;
        clear: both;
-       color: #6a737c;
+       color: #595f66;
        font-variant: small-caps;
      }
      .login-or-hr {
@@ -49,73 +51,127 @@ const partials = `
        border-radius: 3px;
        margin-bottom: 10px;
      }
+     a:focus, input:focus, button:focus {
+       outline: 3px solid #1a5c99;
+       outline-offset: 2px;
+     }
+     .form-control[aria-invalid="true"] {
+       border-width: 2px;
+       border-color: #a94442;
+     }
+     @media (prefers-color-scheme: dark) {
+       body, .panel, .panel-default > .panel-heading, .login-or {
+         background-color: #1e2125;
+         color: #e4e6e8;
+       }
+       .panel, .panel-default > .panel-heading, .table > tbody > tr > th, .table > tbody > tr > td {
+         border-color: #454a50;
+       }
+       .form-control {
+         background-color: #2b2f34;
+         color: #e4e6e8;
+         border-color: #6c737a;
+       }
+       .login-or-container {
+         color: #b8bec4;
+       }
+       a:focus, input:focus, button:focus {
+         outline-color: #8cc4ff;
+       }
+     }
     </style>
 {{end}}
 
 {{define "userInfo"}}
               {{with .UserInfo}}
-                <h1>Welcome {{.Sub}}!</h1>
+                <h1>{{t "Welcome %v!" .Sub}}</h1>
                 <br/>
-                {{if .Picture}}<img class="login-picture" src="{{.Picture}}?s=120">{{end}}
-                {{if .Name}}<h3>{{.Name}}</h3>{{end}}
+                {{if .Picture}}<img class="login-picture" src="{{.Picture}}?s=120" alt="{{t "Picture of %v" .Sub}}">{{end}}
+                {{if .Name}}<h2 class="h3">{{.Name}}</h2>{{end}}
               {{end}}
+              {{template "sessionDetails" . }}
               <br/>
-              <a class="btn btn-md btn-primary" href="{{ .Config.LoginPath }}?logout=true">Logout</a>
+              <a class="btn btn-md btn-primary" href="{{ .Config.LoginPath }}?logout=true">{{t "Logout"}}</a>
+{{end}}
+
+{{define "sessionDetails"}}
+              <table class="table table-condensed session-details">
+                <caption class="sr-only">{{t "Session details"}}</caption>
+                {{with .UserInfo}}
+                  <tr><th scope="row">{{t "Username"}}</th><td>{{.Sub}}</td></tr>
+                  {{if .Name}}<tr><th scope="row">{{t "Name"}}</th><td>{{.Name}}</td></tr>{{end}}
+                  {{if .Email}}<tr><th scope="row">{{t "Email"}}</th><td>{{.Email}}</td></tr>{{end}}
+                  {{if .Origin}}<tr><th scope="row">{{t "Signed in with"}}</th><td>{{.Origin | ucfirst}}</td></tr>{{end}}
+                  {{if .Groups}}<tr><th scope="row">{{t "Groups"}}</th><td>{{range $i, $g := .Groups}}{{if $i}}, {{end}}{{$g}}{{end}}</td></tr>{{end}}
+                  {{if .Expiry}}<tr><th scope="row">{{t "Session expires"}}</th><td>{{t "%v (in %v)" (.Expiry | unixTime) (.Expiry | remaining | t)}}</td></tr>{{end}}
+                {{end}}
+                {{if .Config.JwtRefreshes}}<tr><th scope="row">{{t "Refreshes used"}}</th><td>{{t "%v of %v" .UserInfo.Refreshes .Config.JwtRefreshes}}</td></tr>{{end}}
+              </table>
 {{end}}
 
 {{define "login"}}
               {{ range $providerName, $opts := .Config.Oauth }}
                 <a class="btn btn-block btn-lg btn-social btn-{{ $providerName }}" href="{{ $.Config.LoginPath }}/{{ $providerName }}">
-                  <span class="fa fa-{{ $providerName }}"></span> Sign in with {{ $providerName | ucfirst }}
+                  <span class="fa fa-{{ $providerName }}" aria-hidden="true"></span> {{t "Sign in with %v" ($providerName | ucfirst)}}
                 </a>
               {{end}}
 
               {{if and (not (eq (len .Config.Backends) 0)) (not (eq (len .Config.Oauth) 0))}}
-                <div class="login-or-container">
+                <div class="login-or-container" aria-hidden="true">
                   <hr class="login-or-hr">
-                  <div class="login-or lead">or</div>
+                  <div class="login-or lead">{{t "or"}}</div>
                 </div>
               {{end}}
 
               {{if not (eq (len .Config.Backends) 0) }}
                 <div class="panel panel-default">
-  	          <div class="panel-heading">  
-  		    <div class="panel-title">
-  		      <h4>Sign in</h4>
-                      {{ if .Failure}}<div class="alert alert-warning" role="alert">Invalid credentials</div>{{end}} 
-		    </div>
-	          </div>
-	          <div class="panel-body">
-		    <form accept-charset="UTF-8" role="form" method="POST" action="{{.Config.LoginPath}}">
-                      <fieldset>
-		        <div class="form-group">
-		          <input class="form-control" placeholder="Username" name="username" value="{{.UserInfo.Sub}}" type="text">
-		        </div>
-		        <div class="form-group">
-		          <input class="form-control" placeholder="Password" name="password" type="password" value="">
-		        </div>
-		        <input class="btn btn-lg btn-success btn-block" type="submit" value="Login">
-		      </fieldset>
-		    </form>
-	          </div>
-	        </div>
+                  <div class="panel-heading">
+                    <div class="panel-title">
+                      <h2 class="h4" id="login-title">{{t "Sign in"}}</h2>
+                    </div>
+                  </div>
+                  <div class="panel-body">
+                    <div id="login-failure" aria-live="assertive">
+                      {{if .Failure}}<div class="alert alert-warning" role="alert"><strong>{{t "Sign in failed:"}}</strong> {{t .FailureMessage}}</div>{{end}}
+                    </div>
+                    <form accept-charset="UTF-8" method="POST" action="{{.Config.LoginPath}}" aria-labelledby="login-title">
+                      <div class="form-group">
+                        <label for="login-username">{{t "Username"}}</label>
+                        <input class="form-control" id="login-username" name="username" value="{{.UserInfo.Sub}}" type="text"
+                               autocomplete="username" inputmode="text" autocapitalize="none" spellcheck="false" required
+                               {{if .Failure}}aria-invalid="true" aria-describedby="login-failure"{{end}} {{if not .UserInfo.Sub}}autofocus{{end}}>
+                      </div>
+                      <div class="form-group">
+                        <label for="login-password">{{t "Password"}}</label>
+                        <input class="form-control" id="login-password" name="password" type="password" value=""
+                               autocomplete="current-password" required
+                               {{if .Failure}}aria-invalid="true" aria-describedby="login-failure"{{end}} {{if .UserInfo.Sub}}autofocus{{end}}>
+                      </div>
+                      <button class="btn btn-lg btn-success btn-block" type="submit">{{t "Login"}}</button>
+                    </form>
+                  </div>
+                </div>
               {{end}}
 {{end}}`
 
 var layout = `<!DOCTYPE html>
-<html>
+<html lang="{{.Language}}">
   <head>
+    <meta charset="utf-8">
+    <meta name="viewport" content="width=device-width, initial-scale=1">
+    <meta name="color-scheme" content="light dark">
+    <title>{{if .Authenticated}}{{t "Signed in"}}{{else}}{{t "Sign in"}}{{end}}</title>
     {{ template "styles" . }}
   </head>
   <body>
     <uic-fragment name="content">
-      <div class="container">
+      <main class="container">
         <div class="row vertical-offset-100">
-    	  <div class="col-md-4 col-md-offset-4">
+          <div class="col-md-4 col-md-offset-4">
 
             {{ if .Error}}
-              <div class="alert alert-danger" role="alert">
-                <strong>Internal Error. </strong> Please try again later.
+              <div class="alert alert-danger" role="alert" aria-live="assertive">
+                <strong>{{t .ErrorMessage }}</strong>
               </div>
             {{end}}
 
@@ -123,74 +179,174 @@ var layout = `<!DOCTYPE html>
 
               {{template "userInfo" . }}
 
+            {{else if .Maintenance}}
+
+              <div class="alert alert-info" role="status" aria-live="polite">{{ .Notice }}</div>
+
             {{else}}
 
               {{template "login" . }}
 
             {{end}}
-	  </div>
-	</div>
-      </div>
+          </div>
+        </div>
+      </main>
     </uic-fragment>
   </body>
 </html>`
 
+// the default texts of the form messages
+const (
+	defaultErrorMessage   = "Internal Error. Please try again later."
+	defaultFailureMessage = "The username or password is not correct."
+)
+
 type loginFormData struct {
 	Error         bool
 	Failure       bool
 	Config        *Config
 	Authenticated bool
 	UserInfo      model.UserInfo
+	Maintenance   bool
+
+	// The texts for the error, failure and notice regions of the form.
+	// writeLoginForm sets the defaults for the flags above, so templates
+	// can render the specific message instead of deriving it from the flag.
+	ErrorMessage   string
+	FailureMessage string
+	Notice         string
+
+	// Language is the language of the form, selected by the Accept-Language header.
+	// The template function t translates the texts into it.
+	Language string
+
+	// CSPNonce is the nonce of the content security policy of the request,
+	// which has to be set as nonce attribute of inline styles and scripts.
+	CSPNonce string
+
+	// the http status code, 200 or 500 on errors if not set
+	status int
 }
 
-func writeLoginForm(w http.ResponseWriter, params loginFormData) {
-	funcMap := template.FuncMap{
-		"ucfirst": ucfirst,
+var templateFuncs = template.FuncMap{
+	"t":         translator(defaultFormLanguage),
+	"ucfirst":   ucfirst,
+	"unixTime":  unixTime,
+	"remaining": remaining,
+}
+
+// withMessages sets the default texts for the set flags
+func (params loginFormData) withMessages() loginFormData {
+	if params.Error && params.ErrorMessage == "" {
+		params.ErrorMessage = defaultErrorMessage
 	}
-	templateName := "loginForm"
-	if params.Config != nil && params.Config.Template != "" {
-		templateName = params.Config.Template
+	if params.Failure && params.FailureMessage == "" {
+		params.FailureMessage = defaultFailureMessage
 	}
-	t := template.New(templateName).Funcs(funcMap)
-	t = template.Must(t.Parse(partials))
-	if params.Config != nil && params.Config.Template != "" {
-		customTemplate, err := ioutil.ReadFile(params.Config.Template)
-		if err != nil {
-			logging.Logger.WithError(err).Error()
-			w.WriteHeader(500)
-			w.Write([]byte(`Internal Server Error`))
-			return
-		}
+	if params.Maintenance && params.Notice == "" && params.Config != nil {
+		params.Notice = params.Config.MaintenanceMessage
+	}
+	if params.Language == "" {
+		params.Language = defaultFormLanguage
+	}
+	return params
+}
 
-		t, err = t.Parse(string(customTemplate))
-		if err != nil {
-			logging.Logger.WithError(err).Error()
-			w.WriteHeader(500)
-			w.Write([]byte(`Internal Server Error`))
-			return
-		}
-	} else {
-		t = template.Must(t.Parse(layout))
+func writeLoginForm(w http.ResponseWriter, params loginFormData) {
+	params = params.withMessages()
+	t, err := loginFormTemplate(params.Config)
+	if err != nil {
+		logging.Logger.WithError(err).Error()
+		respondInternalError(w)
+		return
+	}
+
+	if params.CSPNonce, err = setContentSecurityPolicy(w, contentSecurityPolicy(params.Config)); err != nil {
+		logging.Logger.WithError(err).Error()
+		respondInternalError(w)
+		return
 	}
 
 	b := bytes.NewBuffer(nil)
-	err := t.Execute(b, params)
+	err = t.Funcs(template.FuncMap{"t": translator(params.Language)}).Execute(b, params)
 	if err != nil {
 		logging.Logger.WithError(err).Error()
-		w.WriteHeader(500)
-		w.Write([]byte(`Internal Server Error`))
+		respondInternalError(w)
 		return
 	}
 
 	w.Header().Set("Cache-Control", "no-cache, no-store, must-revalidate")
 	w.Header().Set("Content-Type", contentTypeHTML)
-	if params.Error {
-		w.WriteHeader(500)
+	w.Header().Set("Content-Language", params.Language)
+	w.Header().Add("Vary", "Accept-Language")
+	status := params.status
+	if status == 0 {
+		status = 200
+		if params.Error {
+			status = 500
+		}
 	}
+	w.WriteHeader(status)
 
 	w.Write(b.Bytes())
 }
 
+// loginFormTemplate parses the built-in or the custom template of the configuration.
+// The custom template is read on each call, so that changes are visible without a restart.
+func loginFormTemplate(config *Config) (*template.Template, error) {
+	templateName := "loginForm"
+	if config != nil && config.Template != "" {
+		templateName = config.Template
+	}
+	t := template.New(templateName).Funcs(templateFuncs)
+	t = template.Must(t.Parse(partials))
+	if config == nil || config.Template == "" {
+		return template.Must(t.Parse(layout)), nil
+	}
+
+	customTemplate, err := ioutil.ReadFile(config.Template)
+	if err != nil {
+		return nil, err
+	}
+	return t.Parse(string(customTemplate))
+}
+
+// loginFormFixtures are representative data for the states of the login form
+func loginFormFixtures(config *Config) []loginFormData {
+	userInfo := model.UserInfo{
+		Sub:       "bob",
+		Picture:   "https://example.com/bob.png",
+		Name:      "Bob",
+		Email:     "bob@example.com",
+		Origin:    "simple",
+		Expiry:    time.Now().Add(time.Hour).Unix(),
+		Refreshes: 1,
+		Groups:    []string{"admin"},
+	}
+	return []loginFormData{
+		{Config: config},
+		{Config: config, Failure: true},
+		{Config: config, Error: true},
+		{Config: config, Maintenance: true},
+		{Config: config, Authenticated: true, UserInfo: userInfo},
+	}
+}
+
+// checkLoginFormTemplate renders the login form with all fixtures,
+// so that an invalid template fails on startup instead of on the first request.
+func checkLoginFormTemplate(config *Config) error {
+	t, err := loginFormTemplate(config)
+	if err != nil {
+		return fmt.Errorf("Invalid template: %v", err)
+	}
+	for _, params := range loginFormFixtures(config) {
+		if err := t.Execute(ioutil.Discard, params.withMessages()); err != nil {
+			return fmt.Errorf("Invalid template: %v", err)
+		}
+	}
+	return nil
+}
+
 func ucfirst(in string) string {
 	if in == "" {
 		return ""
@@ -198,3 +354,16 @@ func ucfirst(in string) string {
 
 	return strings.ToUpper(in[0:1]) + in[1:]
 }
+
+func unixTime(t int64) string {
+	return time.Unix(t, 0).UTC().Format(time.RFC1123)
+}
+
+// remaining returns the time until t in a human readable form, rounded to minutes
+func remaining(t int64) string {
+	d := time.Until(time.Unix(t, 0))
+	if d < time.Minute {
+		return "less than a minute"
+	}
+	return strings.TrimSuffix(d.Truncate(time.Minute).String(), "0s")
+}
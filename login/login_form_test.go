@@ -1,14 +1,21 @@
 package login
 
 import (
+	"flag"
 	. "github.com/stretchr/testify/assert"
 	"github.com/tarent/loginsrv/model"
 	"io/ioutil"
 	"net/http/httptest"
 	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
 	"testing"
+	"time"
 )
 
+var updateGolden = flag.Bool("update", false, "update the golden files of the login form")
+
 func Test_form(t *testing.T) {
 	// show error
 	recorder := httptest.NewRecorder()
@@ -81,6 +88,32 @@ func Test_form(t *testing.T) {
 	NotContains(t, recorder.Body.String(), `Error`)
 }
 
+func Test_form_sessionDetails(t *testing.T) {
+	recorder := httptest.NewRecorder()
+	writeLoginForm(recorder, loginFormData{
+		Authenticated: true,
+		UserInfo: model.UserInfo{
+			Sub:       "smancke",
+			Name:      "Sebastian Mancke",
+			Email:     "s.mancke@tarent.de",
+			Origin:    "github",
+			Groups:    []string{"admin", "dev"},
+			Expiry:    time.Now().Add(2*time.Hour + 30*time.Second).Unix(),
+			Refreshes: 1,
+		},
+		Config: &Config{
+			LoginPath:    "/login",
+			JwtRefreshes: 3,
+		},
+	})
+	Contains(t, recorder.Body.String(), `<td>s.mancke@tarent.de</td>`)
+	Contains(t, recorder.Body.String(), `<td>Github</td>`)
+	Contains(t, recorder.Body.String(), `<td>admin, dev</td>`)
+	Contains(t, recorder.Body.String(), `(in 2h0m)`)
+	Contains(t, recorder.Body.String(), `<td>1 of 3</td>`)
+	Contains(t, recorder.Body.String(), `href="/login?logout=true"`)
+}
+
 func Test_form_executeError(t *testing.T) {
 	recorder := httptest.NewRecorder()
 	writeLoginForm(recorder, loginFormData{})
@@ -139,8 +172,177 @@ func Test_form_customTemplate_MissingFile(t *testing.T) {
 	Equal(t, 500, recorder.Code)
 }
 
+func Test_checkLoginFormTemplate(t *testing.T) {
+	tests := []struct {
+		name     string
+		template string
+		valid    bool
+	}{
+		{"valid", `<html><body>{{template "login" .}}{{ .UserInfo.Name }}</body></html>`, true},
+		{"parse error", `<html><body>{{template "login" `, false},
+		{"execution error", `<html><body>{{ if .Authenticated }}{{ .UserInfo.Nope }}{{ end }}</body></html>`, false},
+		{"unknown template", `<html><body>{{template "nope" .}}</body></html>`, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			file := filepath.Join(tmpDir(t), "template.html")
+			NoError(t, ioutil.WriteFile(file, []byte(test.template), 0644))
+
+			cfg := DefaultConfig()
+			cfg.Backends = Options{"simple": {"bob": "secret"}}
+			cfg.Template = file
+			_, err := NewHandler(cfg)
+			if test.valid {
+				NoError(t, err)
+			} else {
+				Error(t, err)
+			}
+		})
+	}
+}
+
+func Test_checkLoginFormTemplate_MissingFile(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Backends = Options{"simple": {"bob": "secret"}}
+	cfg.Template = "/this/file/does/not/exist"
+	_, err := NewHandler(cfg)
+	Error(t, err)
+}
+
+func goldenFormCases() map[string]loginFormData {
+	config := &Config{
+		LoginPath:          "/login",
+		Backends:           Options{"simple": {}},
+		Oauth:              Options{"github": {}},
+		MaintenanceMessage: "Down for maintenance.",
+	}
+	return map[string]loginFormData{
+		"form":        {Config: config},
+		"failure":     {Config: config, Failure: true, UserInfo: model.UserInfo{Sub: "bob"}},
+		"error":       {Config: config, Error: true, UserInfo: model.UserInfo{Sub: "bob"}},
+		"maintenance": {Config: config, Maintenance: true},
+		"userinfo": {Config: config, Authenticated: true, UserInfo: model.UserInfo{
+			Sub: "bob", Name: "Bob", Picture: "https://example.com/bob.png", Origin: "github",
+		}},
+	}
+}
+
+// Test_form_golden pins the rendered markup. Run with -update after intended changes.
+// The nonce of the request is replaced by a fixed one.
+func Test_form_golden(t *testing.T) {
+	for name, data := range goldenFormCases() {
+		t.Run(name, func(t *testing.T) {
+			recorder := httptest.NewRecorder()
+			writeLoginForm(recorder, data)
+			nonce := cspNonce(t, recorder.Header().Get("Content-Security-Policy"))
+			body := strings.Replace(recorder.Body.String(), nonce, "NONCE", -1)
+
+			golden := filepath.Join("testdata", "login_form_"+name+".golden")
+			if *updateGolden {
+				NoError(t, ioutil.WriteFile(golden, []byte(body), 0644))
+			}
+			expected, err := ioutil.ReadFile(golden)
+			NoError(t, err)
+			Equal(t, string(expected), body)
+		})
+	}
+}
+
+var (
+	tagPattern  = regexp.MustCompile(`(?s)<(input|img|label|html)\b[^>]*>`)
+	attrPattern = regexp.MustCompile(`([a-z-]+)(?:="([^"]*)")?`)
+)
+
+func parseAttributes(tag string) map[string]string {
+	attrs := map[string]string{}
+	for _, m := range attrPattern.FindAllStringSubmatch(tag, -1)[1:] {
+		attrs[m[1]] = m[2]
+	}
+	return attrs
+}
+
+// Test_form_accessibility checks the rules of an accessibility audit, which can be checked on the markup
+func Test_form_accessibility(t *testing.T) {
+	for name, data := range goldenFormCases() {
+		t.Run(name, func(t *testing.T) {
+			recorder := httptest.NewRecorder()
+			writeLoginForm(recorder, data)
+			body := recorder.Body.String()
+
+			labels := map[string]bool{}
+			for _, tag := range tagPattern.FindAllString(body, -1) {
+				attrs := parseAttributes(tag)
+				switch {
+				case strings.HasPrefix(tag, "<html"):
+					NotEmpty(t, attrs["lang"], "missing lang of the document")
+				case strings.HasPrefix(tag, "<img"):
+					_, hasAlt := attrs["alt"]
+					True(t, hasAlt, "missing alt of %v", tag)
+				case strings.HasPrefix(tag, "<label"):
+					labels[attrs["for"]] = true
+				}
+			}
+			for _, tag := range tagPattern.FindAllString(body, -1) {
+				if !strings.HasPrefix(tag, "<input") {
+					continue
+				}
+				attrs := parseAttributes(tag)
+				True(t, labels[attrs["id"]], "missing label for %v", tag)
+				NotEmpty(t, attrs["autocomplete"], "missing autocomplete of %v", tag)
+				if data.Failure {
+					Equal(t, "true", attrs["aria-invalid"])
+					Contains(t, body, `id="`+attrs["aria-describedby"]+`" aria-live`)
+				}
+			}
+			Equal(t, 1, strings.Count(body, "autofocus")+boolToInt(data.Authenticated || data.Maintenance))
+
+			// no inline event handlers, because of the content security policy
+			False(t, regexp.MustCompile(`\son[a-z]+=`).MatchString(body))
+
+			// the messages are not indicated by color only
+			if data.Failure {
+				Contains(t, body, defaultFailureMessage)
+			}
+			if data.Error {
+				Contains(t, body, `role="alert" aria-live="assertive"`)
+				Contains(t, body, defaultErrorMessage)
+			}
+			if data.Maintenance {
+				Contains(t, body, `role="status" aria-live="polite">Down for maintenance.<`)
+			}
+		})
+	}
+}
+
+func boolToInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func Test_form_messages(t *testing.T) {
+	recorder := httptest.NewRecorder()
+	writeLoginForm(recorder, loginFormData{
+		Failure:        true,
+		FailureMessage: "Your account is locked.",
+		Config: &Config{
+			LoginPath: "/login",
+			Backends:  Options{"simple": {}},
+		},
+	})
+	Contains(t, recorder.Body.String(), `<strong>Sign in failed:</strong> Your account is locked.`)
+	NotContains(t, recorder.Body.String(), defaultFailureMessage)
+}
+
 func Test_ucfirst(t *testing.T) {
 	Equal(t, "", ucfirst(""))
 	Equal(t, "A", ucfirst("a"))
 	Equal(t, "Abc def", ucfirst("abc def"))
 }
+
+func Test_remaining(t *testing.T) {
+	Equal(t, "less than a minute", remaining(time.Now().Add(30*time.Second).Unix()))
+	Equal(t, "5m", remaining(time.Now().Add(5*time.Minute+30*time.Second).Unix()))
+	Equal(t, "23h59m", remaining(time.Now().Add(24*time.Hour-30*time.Second).Unix()))
+}
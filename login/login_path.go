@@ -0,0 +1,82 @@
+package login
+
+import (
+	"fmt"
+	"net/http"
+	"path"
+	"strings"
+)
+
+// normalizeLoginPath returns the path with a leading and without a trailing slash.
+// An empty path is the root path.
+func normalizeLoginPath(p string) (string, error) {
+	p = strings.TrimSpace(p)
+	if strings.ContainsAny(p, "?#") {
+		return "", fmt.Errorf("login path must not contain a query or fragment: %v", p)
+	}
+	return path.Clean("/" + p), nil
+}
+
+// parseLoginPathAliases normalizes the comma separated list of alias paths.
+// Aliases equal to the primary path or to each other are skipped.
+func parseLoginPathAliases(primary, aliases string) ([]string, error) {
+	result := []string{}
+	seen := map[string]bool{primary: true}
+	for _, alias := range strings.Split(aliases, ",") {
+		if strings.TrimSpace(alias) == "" {
+			continue
+		}
+		p, err := normalizeLoginPath(alias)
+		if err != nil {
+			return nil, err
+		}
+		if !seen[p] {
+			seen[p] = true
+			result = append(result, p)
+		}
+	}
+	return result, nil
+}
+
+// hasPathPrefix checks, if the url path is the prefix itself or a sub path of it.
+// In contrast to strings.HasPrefix, /loginfoo is no sub path of /login.
+func hasPathPrefix(urlPath, prefix string) bool {
+	if prefix == "/" {
+		return true
+	}
+	return urlPath == prefix || strings.HasPrefix(urlPath, prefix+"/")
+}
+
+// IsLoginPath returns true, if the url path belongs to the login resource,
+// either below the login path or one of its aliases.
+func (h *Handler) IsLoginPath(urlPath string) bool {
+	_, ok := h.snapshot().matchLoginPath(urlPath)
+	return ok
+}
+
+func (h *Handler) matchLoginPath(urlPath string) (string, bool) {
+	if hasPathPrefix(urlPath, h.config.LoginPath) {
+		return h.config.LoginPath, true
+	}
+	for _, alias := range h.loginPathAliases {
+		if hasPathPrefix(urlPath, alias) {
+			return alias, true
+		}
+	}
+	return "", false
+}
+
+// withPrimaryLoginPath rewrites requests to an alias to the primary login path,
+// so that all generated urls, e.g. oauth callbacks, use the primary path.
+func (h *Handler) withPrimaryLoginPath(r *http.Request, matched string) *http.Request {
+	if matched == h.config.LoginPath {
+		return r
+	}
+	r2 := new(http.Request)
+	*r2 = *r
+	u := *r.URL
+	u.Path = path.Join(h.config.LoginPath, strings.TrimPrefix(u.Path, matched))
+	u.RawPath = ""
+	r2.URL = &u
+	return r2
+}
@@ -0,0 +1,77 @@
+package login
+
+import (
+	. "github.com/stretchr/testify/assert"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestLoginPath_Normalize(t *testing.T) {
+	testCases := map[string]string{
+		"/login":      "/login",
+		"login":       "/login",
+		"/login/":     "/login",
+		" /a//login ": "/a/login",
+		"/":           "/",
+		"":            "/",
+	}
+	for input, expected := range testCases {
+		p, err := normalizeLoginPath(input)
+		NoError(t, err, input)
+		Equal(t, expected, p, input)
+	}
+
+	_, err := normalizeLoginPath("/login?foo")
+	Error(t, err)
+}
+
+func TestLoginPath_Aliases(t *testing.T) {
+	aliases, err := parseLoginPathAliases("/login", "signin, /login/,/signin/,,/auth")
+	NoError(t, err)
+	Equal(t, []string{"/signin", "/auth"}, aliases)
+
+	_, err = parseLoginPathAliases("/login", "/signin#foo")
+	Error(t, err)
+}
+
+func TestLoginPath_HasPathPrefix(t *testing.T) {
+	True(t, hasPathPrefix("/login", "/login"))
+	True(t, hasPathPrefix("/login/", "/login"))
+	True(t, hasPathPrefix("/login/github", "/login"))
+	False(t, hasPathPrefix("/loginfoo", "/login"))
+	False(t, hasPathPrefix("/", "/login"))
+	True(t, hasPathPrefix("/anything", "/"))
+}
+
+func TestLoginPath_Handler(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.LoginPath = "login/"
+	cfg.LoginPathAliases = "/signin"
+	cfg.Backends = Options{"simple": {"bob": "secret"}}
+	cfg.Oauth = Options{"github": {"client_id": "foo", "client_secret": "bar"}}
+	h, err := NewHandler(cfg)
+	NoError(t, err)
+	Equal(t, "/login", cfg.LoginPath)
+
+	for _, p := range []string{"/login", "/login/", "/signin", "/login/health", "/signin/health"} {
+		recorder := httptest.NewRecorder()
+		h.ServeHTTP(recorder, req("GET", p, ""))
+		Equal(t, 200, recorder.Code, p)
+	}
+
+	for _, p := range []string{"/loginfoo", "/signinfoo", "/foo/login"} {
+		recorder := httptest.NewRecorder()
+		h.ServeHTTP(recorder, req("GET", p, ""))
+		Equal(t, 404, recorder.Code, p)
+	}
+
+	// the form and the oauth callback use the primary path
+	recorder := httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("GET", "/signin", ""))
+	Contains(t, recorder.Body.String(), `action="/login"`)
+
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("GET", "http://example.com/signin/github", ""))
+	Equal(t, 302, recorder.Code)
+	Contains(t, recorder.Header().Get("Location"), "redirect_uri=http%3A%2F%2Fexample.com%2Flogin%2Fgithub")
+}
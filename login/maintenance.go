@@ -0,0 +1,76 @@
+package login
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"time"
+
+	"github.com/tarent/loginsrv/logging"
+	"github.com/tarent/loginsrv/model"
+)
+
+// the Retry-After value, if the maintenance has no end date
+const defaultMaintenanceRetryAfter = 5 * time.Minute
+
+// SetMaintenance switches the maintenance mode on or off.
+// While in maintenance, no new sessions are issued,
+// but existing tokens stay valid and can be refreshed.
+// If the configured MaintenanceDuration is set, the maintenance ends automatically after that time.
+func (h *Handler) SetMaintenance(enabled bool) {
+	h.muMaintenance.Lock()
+	defer h.muMaintenance.Unlock()
+
+	h.maintenance = enabled
+	h.maintenanceUntil = time.Time{}
+	if duration := h.snapshot().config.MaintenanceDuration; enabled && duration > 0 {
+		h.maintenanceUntil = time.Now().Add(duration)
+	}
+	logging.Logger.WithField("maintenance", enabled).
+		WithField("maintenance_until", h.maintenanceUntil).
+		Info("maintenance mode changed")
+}
+
+// Maintenance returns true, if the handler is in maintenance mode.
+// The returned time is the planned end of the maintenance, or zero if there is none.
+func (h *Handler) Maintenance() (bool, time.Time) {
+	h.muMaintenance.RLock()
+	defer h.muMaintenance.RUnlock()
+
+	if !h.maintenance {
+		return false, time.Time{}
+	}
+	if !h.maintenanceUntil.IsZero() && time.Now().After(h.maintenanceUntil) {
+		return false, time.Time{}
+	}
+	return true, h.maintenanceUntil
+}
+
+func (h *Handler) inMaintenance() bool {
+	maintenance, _ := h.Maintenance()
+	return maintenance
+}
+
+func (h *Handler) respondMaintenance(w http.ResponseWriter, r *http.Request) {
+	_, until := h.Maintenance()
+	retryAfter := defaultMaintenanceRetryAfter
+	if !until.IsZero() {
+		retryAfter = time.Until(until)
+	}
+	w.Header().Set("Retry-After", fmt.Sprintf("%d", int(math.Ceil(retryAfter.Seconds()))))
+
+	if h.wantHTML(r) {
+		username, _, _, _ := getCredentials(r)
+		writeLoginForm(w,
+			loginFormData{
+				Maintenance: true,
+				Config:      h.formConfig(),
+				Language:    formLanguage(r),
+				UserInfo:    model.UserInfo{Sub: username},
+				status:      503,
+			})
+		return
+	}
+
+	h.respondAPIError(w, r, apiError{503, "maintenance", h.config.MaintenanceMessage})
+}
@@ -0,0 +1,76 @@
+package login
+
+import (
+	. "github.com/stretchr/testify/assert"
+	"github.com/tarent/loginsrv/model"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestMaintenance_Login(t *testing.T) {
+	h := testHandler()
+	h.SetMaintenance(true)
+
+	// api login
+	recorder := httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("POST", "/context/login", `{"username": "bob", "password": "secret"}`, TypeJSON, AcceptJwt))
+	Equal(t, 503, recorder.Code)
+	Equal(t, "300", recorder.Header().Get("Retry-After"))
+	Equal(t, h.config.MaintenanceMessage, recorder.Body.String())
+
+	// web login
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("POST", "/context/login", "username=bob&password=secret", TypeForm, AcceptHTML))
+	Equal(t, 503, recorder.Code)
+	Contains(t, recorder.Body.String(), h.config.MaintenanceMessage)
+	Equal(t, "", recorder.Header().Get("Set-Cookie"))
+
+	// the form shows the message
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("GET", "/context/login", ""))
+	Equal(t, 200, recorder.Code)
+	Contains(t, recorder.Body.String(), h.config.MaintenanceMessage)
+	NotContains(t, recorder.Body.String(), `name="password"`)
+
+	// back to normal
+	h.SetMaintenance(false)
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("POST", "/context/login", `{"username": "bob", "password": "secret"}`, TypeJSON, AcceptJwt))
+	Equal(t, 200, recorder.Code)
+}
+
+func TestMaintenance_RefreshStillWorks(t *testing.T) {
+	h := testHandler()
+	h.SetMaintenance(true)
+
+	token, err := h.createToken(model.UserInfo{Sub: "bob", Expiry: time.Now().Add(time.Second).Unix()})
+	NoError(t, err)
+
+	recorder := httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("POST", "/context/login", "", AcceptJwt, "Cookie: "+h.config.CookieName+"="+token+";"))
+	Equal(t, 200, recorder.Code)
+}
+
+func TestMaintenance_TimeBoxed(t *testing.T) {
+	h := testHandler()
+	h.config.MaintenanceDuration = time.Minute
+	h.SetMaintenance(true)
+
+	maintenance, until := h.Maintenance()
+	True(t, maintenance)
+	WithinDuration(t, time.Now().Add(time.Minute), until, time.Second)
+
+	recorder := httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("POST", "/context/login", `{"username": "bob", "password": "secret"}`, TypeJSON, AcceptJwt))
+	Equal(t, 503, recorder.Code)
+	retryAfter, err := strconv.Atoi(recorder.Header().Get("Retry-After"))
+	NoError(t, err)
+	InDelta(t, 60, retryAfter, 1)
+
+	// the maintenance ends automatically
+	h.maintenanceUntil = time.Now().Add(-time.Second)
+	maintenance, _ = h.Maintenance()
+	False(t, maintenance)
+}
@@ -0,0 +1,108 @@
+package login
+
+import (
+	"context"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/tarent/loginsrv/model"
+)
+
+// Adapters for embedding the login handler into routers.
+//
+// An adapter for a framework follows the chi adapter below and only uses the exported api:
+// it creates the handler with NewMountedHandler, which prefixes the login path with the
+// mount point of the router, registers the handler at the login path in the way of the router
+// and converts Handler.Middleware into the middleware signature of the framework,
+// e.g. for gin with `gin.WrapH` and a gin.HandlerFunc calling the wrapped middleware.
+
+type userInfoKey struct{}
+
+// Mounter is a router, on which handlers can be mounted below a path, e.g. chi.Router.
+type Mounter interface {
+	Mount(pattern string, h http.Handler)
+}
+
+// NewMountedHandler creates a login handler for a router, which is served below the path prefix.
+// The login path and its aliases are relative to the prefix, so that the urls generated by the handler,
+// e.g. of the login form and the oauth callbacks, contain the complete path.
+func NewMountedHandler(prefix string, cfg *Config) (*Handler, error) {
+	prefix, err := normalizeLoginPath(prefix)
+	if err != nil {
+		return nil, err
+	}
+	loginPath, err := normalizeLoginPath(cfg.LoginPath)
+	if err != nil {
+		return nil, err
+	}
+	aliases, err := parseLoginPathAliases(loginPath, cfg.LoginPathAliases)
+	if err != nil {
+		return nil, err
+	}
+
+	mounted := *cfg
+	mounted.LoginPath = path.Join(prefix, loginPath)
+	for i := range aliases {
+		aliases[i] = path.Join(prefix, aliases[i])
+	}
+	mounted.LoginPathAliases = strings.Join(aliases, ",")
+	return NewHandler(&mounted)
+}
+
+// MountChi creates the login handler and mounts it on a chi router (or any other Mounter)
+// at the login path and its aliases. The prefix is the path, the router itself is mounted on,
+// or "/" for the root router. The returned handler provides the Hooks and the Middleware.
+func MountChi(r Mounter, prefix string, cfg *Config) (*Handler, error) {
+	h, err := NewMountedHandler(prefix, cfg)
+	if err != nil {
+		return nil, err
+	}
+	handler := h.withMountPrefix(prefix)
+	r.Mount(strings.TrimPrefix(h.config.LoginPath, strings.TrimSuffix(prefix, "/")), handler)
+	for _, alias := range h.loginPathAliases {
+		r.Mount(strings.TrimPrefix(alias, strings.TrimSuffix(prefix, "/")), handler)
+	}
+	return h, nil
+}
+
+// withMountPrefix restores the prefix for routers, which strip it from the request path.
+// Routers like chi, which keep the complete path, are served unchanged.
+func (h *Handler) withMountPrefix(prefix string) http.Handler {
+	prefix = path.Clean("/" + prefix)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if prefix != "/" && !hasPathPrefix(r.URL.Path, prefix) {
+			r2 := new(http.Request)
+			*r2 = *r
+			u := *r.URL
+			u.Path = path.Join(prefix, u.Path)
+			u.RawPath = ""
+			r2.URL = &u
+			r = r2
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// Middleware protects the next handler with the tokens of the login handler,
+// which are taken from the Authorization bearer header or the cookie.
+// Requests without a valid token are answered with 401, otherwise
+// the user info is available by UserInfoFromContext.
+// It has the middleware signature of net/http and chi.
+func (h *Handler) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userInfo, valid := h.GetToken(r, "")
+		if !valid {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			writeText(w, http.StatusUnauthorized, "Unauthorized")
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), userInfoKey{}, userInfo)))
+	})
+}
+
+// UserInfoFromContext returns the user info of a request, which passed the Middleware.
+func UserInfoFromContext(ctx context.Context) (model.UserInfo, bool) {
+	userInfo, ok := ctx.Value(userInfoKey{}).(model.UserInfo)
+	return userInfo, ok
+}
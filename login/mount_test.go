@@ -0,0 +1,124 @@
+package login
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/stretchr/testify/assert"
+	"github.com/tarent/loginsrv/model"
+)
+
+// testRouter is mounted below a prefix and mounts handlers like chi.
+// With strip, the prefix is removed from the request path, like http.StripPrefix does.
+type testRouter struct {
+	prefix string
+	strip  bool
+	mounts map[string]http.Handler
+}
+
+func (router *testRouter) Mount(pattern string, h http.Handler) {
+	if router.mounts == nil {
+		router.mounts = map[string]http.Handler{}
+	}
+	router.mounts[pattern] = h
+}
+
+func (router *testRouter) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	for pattern, h := range router.mounts {
+		if hasPathPrefix(r.URL.Path, router.prefix+pattern) {
+			if router.strip {
+				http.StripPrefix(router.prefix, h).ServeHTTP(w, r)
+				return
+			}
+			h.ServeHTTP(w, r)
+			return
+		}
+	}
+	http.NotFound(w, r)
+}
+
+func mountTestConfig() *Config {
+	cfg := DefaultConfig()
+	cfg.Backends = Options{"simple": {"bob": "secret"}}
+	cfg.Oauth = Options{"github": {"client_id": "id", "client_secret": "secret"}}
+	cfg.LoginPathAliases = "/signin"
+	return cfg
+}
+
+func TestMountChi(t *testing.T) {
+	for _, strip := range []bool{false, true} {
+		router := &testRouter{prefix: "/auth", strip: strip}
+		cfg := mountTestConfig()
+		h, err := MountChi(router, "/auth", cfg)
+		NoError(t, err)
+		Equal(t, "/auth/login", h.config.LoginPath)
+		Equal(t, []string{"/auth/signin"}, h.loginPathAliases)
+		// the passed config is not changed
+		Equal(t, "/login", cfg.LoginPath)
+
+		// the form posts to the complete path
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, req("GET", "/auth/login", "", AcceptHTML))
+		Equal(t, 200, recorder.Code)
+		Contains(t, recorder.Body.String(), `action="/auth/login"`)
+
+		recorder = httptest.NewRecorder()
+		router.ServeHTTP(recorder, req("POST", "/auth/signin", `{"username": "bob", "password": "secret"}`, TypeJSON, AcceptJwt))
+		Equal(t, 200, recorder.Code)
+
+		// the oauth callback contains the prefix
+		recorder = httptest.NewRecorder()
+		r := req("GET", "/auth/login/github", "")
+		r.Host = "example.com"
+		router.ServeHTTP(recorder, r)
+		Equal(t, 302, recorder.Code)
+		location, _ := url.Parse(recorder.Header().Get("Location"))
+		Equal(t, "http://example.com/auth/login/github", location.Query().Get("redirect_uri"))
+	}
+}
+
+func TestMountChi_Root(t *testing.T) {
+	router := &testRouter{}
+	h, err := MountChi(router, "/", mountTestConfig())
+	NoError(t, err)
+	Equal(t, "/login", h.config.LoginPath)
+
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, req("POST", "/login", `{"username": "bob", "password": "secret"}`, TypeJSON, AcceptJwt))
+	Equal(t, 200, recorder.Code)
+}
+
+func TestHandler_Middleware(t *testing.T) {
+	h := testHandler()
+	var received model.UserInfo
+	protected := h.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received, _ = UserInfoFromContext(r.Context())
+	}))
+
+	recorder := httptest.NewRecorder()
+	protected.ServeHTTP(recorder, req("GET", "/api", ""))
+	Equal(t, 401, recorder.Code)
+	Equal(t, "Bearer", recorder.Header().Get("WWW-Authenticate"))
+
+	token, err := h.createToken(model.UserInfo{Sub: "bob", Expiry: time.Now().Add(time.Minute).Unix()})
+	NoError(t, err)
+
+	recorder = httptest.NewRecorder()
+	protected.ServeHTTP(recorder, req("GET", "/api", "", "Authorization: Bearer "+token))
+	Equal(t, 200, recorder.Code)
+	Equal(t, "bob", received.Sub)
+
+	received = model.UserInfo{}
+	recorder = httptest.NewRecorder()
+	protected.ServeHTTP(recorder, req("GET", "/api", "", "Cookie: "+h.config.CookieName+"="+token))
+	Equal(t, 200, recorder.Code)
+	Equal(t, "bob", received.Sub)
+
+	recorder = httptest.NewRecorder()
+	protected.ServeHTTP(recorder, req("GET", "/api", "", "Authorization: Bearer "+strings.ToUpper(token)))
+	Equal(t, 401, recorder.Code)
+}
@@ -0,0 +1,140 @@
+package login
+
+import (
+	"fmt"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// the values of -content-negotiation
+const (
+	negotiationAuto = "auto"
+	negotiationAPI  = "api"
+	negotiationHTML = "html"
+)
+
+// htmlMediaTypes are the media types of the login form and the redirects of browsers
+var htmlMediaTypes = []string{"text/html", "application/xhtml+xml"}
+
+// apiMediaTypes are the media types of the api responses: the token, the json token object and the errors
+var apiMediaTypes = []string{"application/json", "application/jwt", "application/problem+json"}
+
+// mediaRange is one entry of an accept header
+type mediaRange struct {
+	mediaType string
+	params    map[string]string
+	q         float64
+}
+
+// specificity of a media range: a range with parameters is more specific than one without,
+// an exact media type more specific than type/* and that more specific than */*
+func (m mediaRange) specificity() int {
+	switch {
+	case m.mediaType == "*/*":
+		return 0
+	case strings.HasSuffix(m.mediaType, "/*"):
+		return 1
+	case len(m.params) > 0:
+		return 3
+	}
+	return 2
+}
+
+func (m mediaRange) matches(mediaType string) bool {
+	if m.mediaType == "*/*" || m.mediaType == mediaType {
+		return true
+	}
+	return strings.HasSuffix(m.mediaType, "/*") && strings.HasPrefix(mediaType, strings.TrimSuffix(m.mediaType, "*"))
+}
+
+// parseAccept returns the media ranges of an accept header in their order.
+// Invalid ranges and ranges with an invalid quality are skipped.
+func parseAccept(accept string) []mediaRange {
+	var ranges []mediaRange
+	for _, part := range strings.Split(accept, ",") {
+		if strings.TrimSpace(part) == "" {
+			continue
+		}
+		mediaType, params, err := mime.ParseMediaType(part)
+		if err != nil {
+			continue
+		}
+		m := mediaRange{mediaType: mediaType, params: params, q: 1}
+		if q, exist := params["q"]; exist {
+			m.q, err = strconv.ParseFloat(q, 64)
+			if err != nil || m.q < 0 || m.q > 1 {
+				continue
+			}
+			delete(params, "q")
+		}
+		ranges = append(ranges, m)
+	}
+	return ranges
+}
+
+// quality returns the quality of the best of the media types by the most specific matching range of each,
+// as defined by RFC 7231, and the specificity of that range. It is 0 and -1, if none matches.
+func quality(ranges []mediaRange, mediaTypes []string) (q float64, specificity int) {
+	specificity = -1
+	for _, mediaType := range mediaTypes {
+		var match *mediaRange
+		for i := range ranges {
+			if ranges[i].matches(mediaType) && (match == nil || ranges[i].specificity() > match.specificity()) {
+				match = &ranges[i]
+			}
+		}
+		if match != nil && (match.q > q || match.q == q && match.specificity() > specificity) {
+			q, specificity = match.q, match.specificity()
+		}
+	}
+	return q, specificity
+}
+
+// negotiateHTML decides by the accept header, if the client prefers html over the api responses.
+// Html is only chosen, if it has a higher quality than the api media types,
+// or the same quality by a more specific range, e.g. `text/html, */*`.
+// Otherwise, e.g. for `*/*`, a missing accept header or equal qualities, the api response is preferred,
+// as browsers always ask for html explicitly.
+func negotiateHTML(accept string) bool {
+	ranges := parseAccept(accept)
+	htmlQ, htmlSpecificity := quality(ranges, htmlMediaTypes)
+	apiQ, apiSpecificity := quality(ranges, apiMediaTypes)
+	if htmlQ == 0 {
+		return false
+	}
+	return htmlQ > apiQ || htmlQ == apiQ && htmlSpecificity > apiSpecificity
+}
+
+// negotiateJSON decides by the accept header, if the client prefers the json token object over the bare jwt.
+// Like for html, json is only chosen, if it is asked for explicitly, so `*/*` keeps the bare jwt.
+func negotiateJSON(accept string) bool {
+	ranges := parseAccept(accept)
+	jsonQ, jsonSpecificity := quality(ranges, []string{"application/json"})
+	jwtQ, jwtSpecificity := quality(ranges, []string{"application/jwt"})
+	if jsonQ == 0 {
+		return false
+	}
+	return jsonQ > jwtQ || jsonQ == jwtQ && jsonSpecificity > jwtSpecificity
+}
+
+// wantHTML checks, if the request is answered with html, e.g. the login form or a redirect.
+// The -content-negotiation can force the api or html responses for clients with unusual accept headers.
+func (h *Handler) wantHTML(r *http.Request) bool {
+	switch h.config.ContentNegotiation {
+	case negotiationAPI:
+		return false
+	case negotiationHTML:
+		return true
+	}
+	return negotiateHTML(r.Header.Get("Accept"))
+}
+
+func checkContentNegotiation(config *Config) error {
+	switch config.ContentNegotiation {
+	case "", negotiationAuto, negotiationAPI, negotiationHTML:
+		return nil
+	}
+	return fmt.Errorf("Invalid content negotiation %q, allowed are auto, api and html", config.ContentNegotiation)
+}
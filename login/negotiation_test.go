@@ -0,0 +1,93 @@
+package login
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	. "github.com/stretchr/testify/assert"
+)
+
+func TestNegotiateHTML(t *testing.T) {
+	tests := []struct {
+		client string
+		accept string
+		html   bool
+	}{
+		{"chrome", "text/html,application/xhtml+xml,application/xml;q=0.9,image/avif,image/webp,image/apng,*/*;q=0.8,application/signed-exchange;v=b3;q=0.7", true},
+		{"firefox", "text/html,application/xhtml+xml,application/xml;q=0.9,image/avif,image/webp,*/*;q=0.8", true},
+		{"safari", "text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8", true},
+		{"internet explorer 11", "text/html, application/xhtml+xml, image/jxr, */*", true},
+		// the default of java's HttpURLConnection claims html, such clients need -content-negotiation api
+		{"java HttpURLConnection", "text/html, image/gif, image/jpeg, *; q=.2, */*; q=.2", true},
+		{"curl", "*/*", false},
+		{"wget", "*/*", false},
+		{"python requests", "*/*", false},
+		{"fetch", "*/*", false},
+		{"axios", "application/json, text/plain, */*", false},
+		{"okhttp and go without accept header", "", false},
+		{"jwt client", "application/jwt", false},
+		{"json client with html fallback", "application/json, text/html;q=0.1", false},
+		{"html only", "text/html", true},
+		{"html preferred by quality", "application/json;q=0.5, text/html;q=0.9", true},
+		{"any text", "text/*", true},
+		{"html not acceptable", "text/html;q=0, */*", false},
+		// equal qualities and specificities prefer the api
+		{"html and json", "text/html, application/json", false},
+		{"invalid quality is skipped", "text/html;q=high", false},
+	}
+	for _, test := range tests {
+		Equal(t, test.html, negotiateHTML(test.accept), "%v: %q", test.client, test.accept)
+	}
+}
+
+func TestNegotiateJSON(t *testing.T) {
+	True(t, negotiateJSON("application/json"))
+	True(t, negotiateJSON("application/json, text/plain, */*"))
+	True(t, negotiateJSON("application/jwt;q=0.5, application/json"))
+	False(t, negotiateJSON(""))
+	False(t, negotiateJSON("*/*"))
+	False(t, negotiateJSON("application/jwt"))
+	False(t, negotiateJSON("application/json, application/jwt"))
+	False(t, negotiateJSON("application/json;q=0"))
+}
+
+func TestAcceptedAPIVersion(t *testing.T) {
+	Equal(t, "2", acceptedAPIVersion("application/json; version=2"))
+	Equal(t, "2", acceptedAPIVersion("application/json;version=1;q=0.5, application/json;version=2"))
+	Equal(t, "", acceptedAPIVersion("application/json;version=2;q=0, text/html"))
+	Equal(t, "", acceptedAPIVersion("text/html;version=2"))
+}
+
+func TestHandler_ContentNegotiation(t *testing.T) {
+	chrome := "Accept: text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8"
+
+	h := testHandler()
+	recorder := httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("POST", "/context/login", "username=bob&password=secret", TypeForm, "Accept: application/json, text/html;q=0.1"))
+	Equal(t, 200, recorder.Code)
+
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("POST", "/context/login", "username=bob&password=secret", TypeForm, chrome))
+	Equal(t, 303, recorder.Code)
+
+	// forced api responses
+	h.config.ContentNegotiation = negotiationAPI
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("POST", "/context/login", "username=bob&password=secret", TypeForm, chrome))
+	Equal(t, 200, recorder.Code)
+	Equal(t, contentTypeJWT, recorder.Header().Get("Content-Type"))
+
+	// forced html responses
+	h.config.ContentNegotiation = negotiationHTML
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("POST", "/context/login", "username=bob&password=secret", TypeForm, AcceptJwt))
+	Equal(t, 303, recorder.Code)
+}
+
+func TestHandler_ContentNegotiation_Invalid(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Backends = Options{"simple": {"bob": "secret"}}
+	cfg.ContentNegotiation = "browser"
+	_, err := NewHandler(cfg)
+	EqualError(t, err, `Invalid content negotiation "browser", allowed are auto, api and html`)
+}
@@ -0,0 +1,67 @@
+package login
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/tarent/loginsrv/logging"
+	"github.com/tarent/loginsrv/model"
+)
+
+// normalizeUserInfo cleans up the user info returned by a backend or oauth provider,
+// before it is used for the token:
+// Invalid UTF-8 sequences are replaced, whitespace is trimmed
+// and the number of groups as well as the length of all values are bounded.
+// A limit of 0 or less disables the corresponding bound.
+// The returned messages describe the truncations done.
+func normalizeUserInfo(u model.UserInfo, maxGroups, maxLength int) (model.UserInfo, []string) {
+	var truncations []string
+	normalize := func(field, value string) string {
+		value = strings.TrimSpace(strings.ToValidUTF8(value, "�"))
+		if maxLength > 0 && utf8.RuneCountInString(value) > maxLength {
+			truncations = append(truncations, fmt.Sprintf("%v truncated to %v characters", field, maxLength))
+			value = string([]rune(value)[:maxLength])
+		}
+		return value
+	}
+
+	u.Sub = normalize("sub", u.Sub)
+	u.Picture = normalize("picture", u.Picture)
+	u.Name = normalize("name", u.Name)
+	u.Email = normalize("email", u.Email)
+	u.Origin = normalize("origin", u.Origin)
+	u.Domain = normalize("domain", u.Domain)
+	u.Audience = normalize("aud", u.Audience)
+
+	if u.Groups != nil {
+		groups := make([]string, 0, len(u.Groups))
+		for _, g := range u.Groups {
+			if g = normalize("group", g); g != "" {
+				groups = append(groups, g)
+			}
+		}
+		if maxGroups > 0 && len(groups) > maxGroups {
+			truncations = append(truncations, fmt.Sprintf("groups truncated from %v to %v entries", len(groups), maxGroups))
+			groups = groups[:maxGroups]
+		}
+		u.Groups = groups
+		// no empty list, which would be lost in the token
+		if len(groups) == 0 {
+			u.Groups = nil
+		}
+	}
+
+	return u, truncations
+}
+
+func (h *Handler) normalizeUserInfo(u model.UserInfo) model.UserInfo {
+	u, truncations := normalizeUserInfo(u, h.config.ClaimsMaxGroups, h.config.ClaimsMaxLength)
+	if len(truncations) > 0 {
+		logging.Logger.
+			WithField("username", u.Sub).
+			WithField("truncations", truncations).
+			Warn("truncated user info returned by backend")
+	}
+	return u
+}
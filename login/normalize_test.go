@@ -0,0 +1,89 @@
+package login
+
+import (
+	. "github.com/stretchr/testify/assert"
+	"github.com/tarent/loginsrv/model"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestNormalizeUserInfo(t *testing.T) {
+	testCases := []struct {
+		name                string
+		input               model.UserInfo
+		maxGroups           int
+		maxLength           int
+		expected            model.UserInfo
+		expectedTruncations []string
+	}{
+		{
+			"unchanged",
+			model.UserInfo{Sub: "bob", Name: "Bob", Groups: []string{"admin"}},
+			10,
+			10,
+			model.UserInfo{Sub: "bob", Name: "Bob", Groups: []string{"admin"}},
+			nil,
+		},
+		{
+			"trim whitespace",
+			model.UserInfo{Sub: " bob\t", Name: "Bob Smith\n", Email: " bob@example.com ", Groups: []string{" admin ", "  "}},
+			10,
+			20,
+			model.UserInfo{Sub: "bob", Name: "Bob Smith", Email: "bob@example.com", Groups: []string{"admin"}},
+			nil,
+		},
+		{
+			"invalid utf8",
+			model.UserInfo{Sub: "bob", Name: "B\xffob", Groups: []string{"adm\xc3in"}},
+			10,
+			10,
+			model.UserInfo{Sub: "bob", Name: "B�ob", Groups: []string{"adm�in"}},
+			nil,
+		},
+		{
+			"truncate values",
+			model.UserInfo{Sub: "bob", Name: "Bööööööööb", Groups: []string{"administrators"}},
+			10,
+			5,
+			model.UserInfo{Sub: "bob", Name: "Böööö", Groups: []string{"admin"}},
+			[]string{"name truncated to 5 characters", "group truncated to 5 characters"},
+		},
+		{
+			"truncate groups",
+			model.UserInfo{Sub: "bob", Groups: []string{"a", "b", "c"}},
+			2,
+			10,
+			model.UserInfo{Sub: "bob", Groups: []string{"a", "b"}},
+			[]string{"groups truncated from 3 to 2 entries"},
+		},
+		{
+			"no limits",
+			model.UserInfo{Sub: "bob", Name: strings.Repeat("x", 2000), Groups: []string{"a", "b", "c"}},
+			0,
+			0,
+			model.UserInfo{Sub: "bob", Name: strings.Repeat("x", 2000), Groups: []string{"a", "b", "c"}},
+			nil,
+		},
+	}
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			result, truncations := normalizeUserInfo(test.input, test.maxGroups, test.maxLength)
+			Equal(t, test.expected, result)
+			Equal(t, test.expectedTruncations, truncations)
+		})
+	}
+}
+
+func TestNormalizeUserInfo_OnLogin(t *testing.T) {
+	h := testHandler()
+	h.backends = []Backend{NewSimpleBackend(map[string]string{" bob ": "secret"})}
+
+	recorder := httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("POST", "/context/login", `{"username": " bob ", "password": "secret"}`, TypeJSON, AcceptJwt))
+	Equal(t, 200, recorder.Code)
+
+	claims, err := tokenAsMap(recorder.Body.String())
+	NoError(t, err)
+	Equal(t, "bob", claims["sub"])
+}
@@ -0,0 +1,46 @@
+package login
+
+import (
+	"net/http"
+
+	"github.com/tarent/loginsrv/oauth2"
+)
+
+const (
+	oauthCallbackNamespace        = "oauth_callbacks"
+	oauthCallbackNamespaceVersion = 1
+)
+
+var errAPIOauthCallbackReplayed = apiError{400, "oauth_callback_replayed", "Bad Request: The oauth callback was already used"}
+
+// claimOauthCallback marks the state nonce of an oauth callback as consumed, so that a leaked callback url
+// can't be replayed with its code to get another session. A second use of the nonce is rejected.
+// The nonces are kept, keyed by their hash, for the lifetime of the state cookie and are deleted by the store sweeper.
+// It returns false, if the response is written.
+func (h *Handler) claimOauthCallback(w http.ResponseWriter, r *http.Request) bool {
+	if r.FormValue("code") == "" {
+		return true
+	}
+	nonce := oauth2.StateNonce(r)
+	if nonce == "" {
+		// rejected by the state check
+		return true
+	}
+	if uses, _ := h.store.increment(oauthCallbackNamespace, flowKey(nonce), oauthFlowTTL); uses == 1 {
+		return true
+	}
+	provider := ""
+	if cfg, err := h.oauth.GetConfigFromRequest(r); err == nil {
+		provider = cfg.Provider.Name
+	}
+	h.emit(r, EventOauthCallbackReplayed, "", provider)
+	h.respondAPIError(w, r, errAPIOauthCallbackReplayed)
+	return false
+}
+
+// releaseOauthCallback frees the nonce of a failed callback, so that only successful callbacks consume it
+func (h *Handler) releaseOauthCallback(r *http.Request) {
+	if nonce := oauth2.StateNonce(r); r.FormValue("code") != "" && nonce != "" {
+		h.store.delete(oauthCallbackNamespace, flowKey(nonce))
+	}
+}
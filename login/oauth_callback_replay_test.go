@@ -0,0 +1,66 @@
+package login
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	. "github.com/stretchr/testify/assert"
+	"github.com/tarent/loginsrv/model"
+	"github.com/tarent/loginsrv/oauth2"
+)
+
+func TestHandler_OauthCallbackReplay(t *testing.T) {
+	sessions := 0
+	failing := false
+	h := testHandler()
+	h.oauth = &oauth2ManagerMock{
+		_GetConfigFromRequest: func(r *http.Request) (oauth2.Config, error) {
+			return oauth2.Config{Provider: oauth2.Provider{Name: "github"}}, nil
+		},
+		_Handle: func(w http.ResponseWriter, r *http.Request) (bool, bool, model.UserInfo, error) {
+			if r.FormValue("code") == "" {
+				return true, false, model.UserInfo{}, nil
+			}
+			if failing {
+				return false, false, model.UserInfo{}, errors.New("code exchange failed")
+			}
+			sessions++
+			return false, true, model.UserInfo{Sub: "marvin", Origin: "github"}, nil
+		},
+	}
+	rec := &eventRecorder{}
+	h.Subscribe(Subscriber{Name: "test", Handle: rec.handle})
+
+	callback := "/context/login/github?code=xyz&state=abc.L2FwcA"
+	Equal(t, 303, callHandler(h, req("GET", callback, "", AcceptHTML)).Code)
+	recorder := callHandler(h, req("GET", callback, "", AcceptHTML))
+	Equal(t, 400, recorder.Code)
+	Equal(t, 0, len(readSetCookies(recorder.Header())))
+	Equal(t, 1, sessions)
+	Equal(t, []EventType{EventLoginSucceeded, EventOauthCallbackReplayed}, rec.types())
+	Equal(t, "github", rec.events[1].Origin)
+
+	// the nonce is consumed with another code or payload as well
+	Equal(t, 400, callHandler(h, req("GET", "/context/login/github?code=other&state=abc", "", AcceptHTML)).Code)
+	Equal(t, 1, sessions)
+
+	// other flows are not affected
+	Equal(t, 303, callHandler(h, req("GET", "/context/login/github?code=xyz&state=def", "", AcceptHTML)).Code)
+	Equal(t, 2, sessions)
+
+	// failed callbacks do not consume the nonce
+	failing = true
+	Equal(t, 500, callHandler(h, req("GET", "/context/login/github?code=xyz&state=ghi", "", AcceptJwt)).Code)
+	failing = false
+	Equal(t, 303, callHandler(h, req("GET", "/context/login/github?code=xyz&state=ghi", "", AcceptHTML)).Code)
+	Equal(t, 3, sessions)
+
+	// the consumed nonces are deleted with the lifetime of the state cookie
+	now := h.store.now()
+	h.store.now = func() time.Time { return now.Add(oauthFlowTTL) }
+	h.store.sweep()
+	_, consumed := h.store.get(oauthCallbackNamespace, flowKey("abc"))
+	False(t, consumed)
+}
@@ -0,0 +1,158 @@
+package login
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"sync"
+	"time"
+)
+
+const (
+	oauthFlowNamespace        = "oauth_flows"
+	oauthFlowNamespaceVersion = 1
+)
+
+// oauthFlowTTL is the lifetime of the oauth state cookie.
+// Flows without callback within this time are counted as expired.
+var oauthFlowTTL = 10 * time.Minute
+
+// the upper bounds of the buckets of the completion time histogram in seconds
+var oauthFlowBuckets = []float64{5, 10, 30, 60, 120, 300, 600}
+
+// oauthFlowMetrics counts the started, completed, failed and abandoned oauth flows per provider.
+// The started flows are kept in the store, keyed by a hash of the state nonce,
+// so that they survive restarts with a state file. Only the provider and the start time is stored.
+type oauthFlowMetrics struct {
+	store     *ttlStore
+	mu        sync.Mutex
+	providers map[string]*oauthFlowStats
+}
+
+// oauthFlowStats are the counters of one provider
+type oauthFlowStats struct {
+	Started        int64     `json:"started"`
+	Completed      int64     `json:"completed"`
+	Failed         int64     `json:"failed"`
+	Expired        int64     `json:"expired"`
+	CompletionTime histogram `json:"completion_seconds"`
+}
+
+// histogram with cumulative buckets
+type histogram struct {
+	Buckets []histogramBucket `json:"buckets"`
+	Count   int64             `json:"count"`
+	Sum     float64           `json:"sum"`
+}
+
+type histogramBucket struct {
+	Le    float64 `json:"le"`
+	Count int64   `json:"count"`
+}
+
+type oauthFlowRecord struct {
+	Provider string    `json:"provider"`
+	Started  time.Time `json:"started"`
+}
+
+func newOauthFlowMetrics(store *ttlStore) *oauthFlowMetrics {
+	m := &oauthFlowMetrics{store: store, providers: map[string]*oauthFlowStats{}}
+	store.onExpire(oauthFlowNamespace, m.flowExpired)
+	return m
+}
+
+// flowKey does not store the state itself
+func flowKey(nonce string) string {
+	sum := sha256.Sum256([]byte(nonce))
+	return hex.EncodeToString(sum[:16])
+}
+
+// stats returns the counters of the provider. The lock has to be held.
+func (m *oauthFlowMetrics) stats(provider string) *oauthFlowStats {
+	s, ok := m.providers[provider]
+	if !ok {
+		s = &oauthFlowStats{CompletionTime: histogram{Buckets: make([]histogramBucket, len(oauthFlowBuckets))}}
+		for i, le := range oauthFlowBuckets {
+			s.CompletionTime.Buckets[i].Le = le
+		}
+		m.providers[provider] = s
+	}
+	return s
+}
+
+func (m *oauthFlowMetrics) FlowStarted(provider, nonce string) {
+	record, _ := json.Marshal(oauthFlowRecord{Provider: provider, Started: m.store.now()})
+	m.store.set(oauthFlowNamespace, flowKey(nonce), string(record), oauthFlowTTL)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.stats(provider).Started++
+}
+
+func (m *oauthFlowMetrics) FlowCompleted(provider, nonce string) {
+	var duration time.Duration
+	value, started := m.store.take(oauthFlowNamespace, flowKey(nonce))
+	if started {
+		record := oauthFlowRecord{}
+		if err := json.Unmarshal([]byte(value), &record); err == nil {
+			duration = m.store.now().Sub(record.Started)
+		} else {
+			started = false
+		}
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s := m.stats(provider)
+	s.Completed++
+	// callbacks of flows started before a restart without state file have no start time
+	if started {
+		s.CompletionTime.observe(duration.Seconds())
+	}
+}
+
+func (m *oauthFlowMetrics) FlowFailed(provider, nonce string) {
+	m.store.delete(oauthFlowNamespace, flowKey(nonce))
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.stats(provider).Failed++
+}
+
+// flowExpired counts the flows, which got no callback within the ttl
+func (m *oauthFlowMetrics) flowExpired(key, value string) {
+	record := oauthFlowRecord{}
+	if err := json.Unmarshal([]byte(value), &record); err != nil {
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.stats(record.Provider).Expired++
+}
+
+func (h *histogram) observe(value float64) {
+	for i := range h.Buckets {
+		if value <= h.Buckets[i].Le {
+			h.Buckets[i].Count++
+		}
+	}
+	h.Count++
+	h.Sum += value
+}
+
+// status returns a copy of the counters for the health response
+func (m *oauthFlowMetrics) status() map[string]oauthFlowStats {
+	if m == nil {
+		return nil
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	result := map[string]oauthFlowStats{}
+	for provider, s := range m.providers {
+		c := *s
+		c.CompletionTime.Buckets = append([]histogramBucket{}, s.CompletionTime.Buckets...)
+		result[provider] = c
+	}
+	return result
+}
@@ -0,0 +1,72 @@
+package login
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/stretchr/testify/assert"
+)
+
+func TestOauthFlowMetrics(t *testing.T) {
+	now := time.Now()
+	store := newTTLStore()
+	store.now = func() time.Time { return now }
+	m := newOauthFlowMetrics(store)
+
+	m.FlowStarted("github", "nonce1")
+	m.FlowStarted("github", "nonce2")
+	m.FlowStarted("github", "nonce3")
+	m.FlowStarted("google", "nonce4")
+
+	// the nonce and no personal data is stored
+	for key, value := range storeEntries(store, oauthFlowNamespace) {
+		NotContains(t, key, "nonce")
+		True(t, strings.HasPrefix(value, `{"provider":"`))
+	}
+
+	now = now.Add(20 * time.Second)
+	m.FlowCompleted("github", "nonce1")
+	m.FlowFailed("github", "nonce2")
+	// a callback without started flow
+	m.FlowCompleted("github", "unknown")
+
+	now = now.Add(oauthFlowTTL)
+	store.sweep()
+
+	status := m.status()
+	github := status["github"]
+	Equal(t, int64(3), github.Started)
+	Equal(t, int64(2), github.Completed)
+	Equal(t, int64(1), github.Failed)
+	Equal(t, int64(1), github.Expired)
+	Equal(t, int64(1), github.CompletionTime.Count)
+	Equal(t, 20.0, github.CompletionTime.Sum)
+	Equal(t, histogramBucket{Le: 10, Count: 0}, github.CompletionTime.Buckets[1])
+	Equal(t, histogramBucket{Le: 30, Count: 1}, github.CompletionTime.Buckets[2])
+	Equal(t, histogramBucket{Le: 600, Count: 1}, github.CompletionTime.Buckets[6])
+
+	Equal(t, int64(1), status["google"].Started)
+	Equal(t, int64(1), status["google"].Expired)
+	Empty(t, storeEntries(store, oauthFlowNamespace))
+}
+
+func TestHandler_OauthFlowMetrics(t *testing.T) {
+	h := deepLinkTestHandler(t)
+	NotNil(t, h.flowMetrics)
+	Equal(t, "store-sweeper", h.Hooks()[0].Name)
+
+	recorder := httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("GET", "/login/github", ""))
+	Equal(t, 302, recorder.Code)
+
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("GET", "/login/health", ""))
+	var status struct {
+		OauthFlows map[string]oauthFlowStats `json:"oauth_flows"`
+	}
+	NoError(t, json.Unmarshal(recorder.Body.Bytes(), &status))
+	Equal(t, int64(1), status.OauthFlows["github"].Started)
+}
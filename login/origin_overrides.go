@@ -0,0 +1,125 @@
+package login
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// expiryOption of a backend or oauth provider overrides the -jwt-expiry for its logins,
+// as shorthand for -origin-override origin=..,jwt-expiry=..
+const expiryOption = "expiry"
+
+// sessionSettings are the token and cookie settings,
+// which can be overridden for the origin (backend or oauth provider) of a login.
+type sessionSettings struct {
+	JwtExpiry    time.Duration
+	CookieExpiry time.Duration
+	JwtRefreshes int
+}
+
+// addOriginOverride adds the overrides for an origin in the form of origin=..,key=value,..
+func (c *Config) addOriginOverride(optsKvList string) error {
+	opts, err := parseOptions(optsKvList)
+	if err != nil {
+		return err
+	}
+	origin, ok := opts["origin"]
+	if !ok || origin == "" {
+		return errors.New("missing origin name origin=...")
+	}
+	delete(opts, "origin")
+	c.OriginOverrides[origin] = opts
+	return nil
+}
+
+// parseOriginOverrides builds the session settings for each configured origin.
+// Overrides, which are not set, fall back to the global settings.
+// A jwt-expiry longer than the global one is rejected, unless AllowLongerOriginExpiry is set.
+func parseOriginOverrides(config *Config) (map[string]sessionSettings, error) {
+	originOverrides, err := withExpiryOptions(config)
+	if err != nil {
+		return nil, err
+	}
+	overrides := map[string]sessionSettings{}
+	for origin, opts := range originOverrides {
+		s := config.sessionSettings()
+		for k, v := range opts {
+			var err error
+			switch k {
+			case "jwt-expiry":
+				s.JwtExpiry, err = time.ParseDuration(v)
+			case "cookie-expiry":
+				s.CookieExpiry, err = time.ParseDuration(v)
+			case "jwt-refreshes":
+				s.JwtRefreshes, err = strconv.Atoi(v)
+			default:
+				err = fmt.Errorf("unknown option %q", k)
+			}
+			if err != nil {
+				return nil, fmt.Errorf("invalid override for origin %v: %v", origin, err)
+			}
+		}
+		if s.JwtExpiry > config.JwtExpiry && !config.AllowLongerOriginExpiry {
+			return nil, fmt.Errorf("jwt-expiry override for origin %v (%v) is longer than -jwt-expiry (%v). Use -allow-longer-origin-expiry to permit this", origin, s.JwtExpiry, config.JwtExpiry)
+		}
+		overrides[origin] = s
+	}
+	return overrides, nil
+}
+
+// withExpiryOptions adds the expiry options of the backends and oauth providers to the origin overrides
+func withExpiryOptions(config *Config) (Options, error) {
+	originOverrides := Options{}
+	for origin, opts := range config.OriginOverrides {
+		originOverrides[origin] = opts
+	}
+	for _, options := range []Options{config.Backends, config.Oauth} {
+		for origin, opts := range options {
+			expiry, set := opts[expiryOption]
+			if !set {
+				continue
+			}
+			if _, overridden := originOverrides[origin]["jwt-expiry"]; overridden {
+				return nil, fmt.Errorf("the jwt expiry of origin %v is set by the %v option and by -origin-override", origin, expiryOption)
+			}
+			override := map[string]string{"jwt-expiry": expiry}
+			for k, v := range originOverrides[origin] {
+				override[k] = v
+			}
+			originOverrides[origin] = override
+		}
+	}
+	return originOverrides, nil
+}
+
+// withoutExpiryOption returns the options of a backend or oauth provider without the expiry option
+func withoutExpiryOption(options map[string]string) map[string]string {
+	if _, set := options[expiryOption]; !set {
+		return options
+	}
+	providerOptions := map[string]string{}
+	for k, v := range options {
+		if k != expiryOption {
+			providerOptions[k] = v
+		}
+	}
+	return providerOptions
+}
+
+func (c *Config) sessionSettings() sessionSettings {
+	return sessionSettings{
+		JwtExpiry:    c.JwtExpiry,
+		CookieExpiry: c.CookieExpiry,
+		JwtRefreshes: c.JwtRefreshes,
+	}
+}
+
+// sessionSettingsFor returns the session settings for the origin of a login.
+func (h *Handler) sessionSettingsFor(origin string) sessionSettings {
+	if s, ok := h.originOverrides[origin]; ok {
+		return s
+	}
+	return h.config.sessionSettings()
+}
@@ -0,0 +1,123 @@
+package login
+
+import (
+	. "github.com/stretchr/testify/assert"
+	"github.com/tarent/loginsrv/model"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestOriginOverrides_Parse(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.JwtExpiry = 12 * time.Hour
+	cfg.JwtRefreshes = 5
+	NoError(t, cfg.addOriginOverride("origin=htpasswd,jwt-expiry=1h,jwt-refreshes=0"))
+	NoError(t, cfg.addOriginOverride("origin=github,cookie-expiry=12h"))
+
+	overrides, err := parseOriginOverrides(cfg)
+	NoError(t, err)
+	Equal(t, map[string]sessionSettings{
+		"htpasswd": {JwtExpiry: time.Hour, JwtRefreshes: 0},
+		"github":   {JwtExpiry: 12 * time.Hour, CookieExpiry: 12 * time.Hour, JwtRefreshes: 5},
+	}, overrides)
+
+	Error(t, cfg.addOriginOverride("jwt-expiry=1h"))
+}
+
+func TestOriginOverrides_ParseErrors(t *testing.T) {
+	for _, opts := range []string{
+		"origin=htpasswd,jwt-expiry=foo",
+		"origin=htpasswd,jwt-refreshes=foo",
+		"origin=htpasswd,unknown=1",
+		"origin=htpasswd,jwt-expiry=48h",
+	} {
+		cfg := DefaultConfig()
+		NoError(t, cfg.addOriginOverride(opts))
+		_, err := parseOriginOverrides(cfg)
+		Error(t, err, opts)
+	}
+
+	cfg := DefaultConfig()
+	cfg.AllowLongerOriginExpiry = true
+	NoError(t, cfg.addOriginOverride("origin=htpasswd,jwt-expiry=48h"))
+	_, err := parseOriginOverrides(cfg)
+	NoError(t, err)
+}
+
+func TestOriginOverrides_LoginAndRefresh(t *testing.T) {
+	h := testHandler()
+	h.config.JwtRefreshes = 5
+	h.backendNames = []string{"simple"}
+	h.originOverrides = map[string]sessionSettings{
+		"simple": {JwtExpiry: time.Hour, JwtRefreshes: 1},
+	}
+
+	recorder := httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("POST", "/context/login", "username=bob&password=secret", TypeForm, AcceptJwt))
+	Equal(t, 200, recorder.Code)
+	claims, err := tokenAsMap(recorder.Body.String())
+	NoError(t, err)
+	Equal(t, "simple", claims["origin"])
+	InDelta(t, time.Now().Add(time.Hour).Unix(), claims["exp"], 2)
+
+	// refreshes honor the override of the origin
+	token, err := h.createToken(model.UserInfo{Sub: "bob", Origin: "simple", Expiry: time.Now().Add(time.Minute).Unix(), Refreshes: 1})
+	NoError(t, err)
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("POST", "/context/login", "", AcceptJwt, "Cookie: "+h.config.CookieName+"="+token))
+	Equal(t, 403, recorder.Code)
+
+	// other origins use the global settings
+	token, err = h.createToken(model.UserInfo{Sub: "bob", Origin: "github", Expiry: time.Now().Add(time.Minute).Unix(), Refreshes: 1})
+	NoError(t, err)
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("POST", "/context/login", "", AcceptJwt, "Cookie: "+h.config.CookieName+"="+token))
+	Equal(t, 200, recorder.Code)
+	claims, err = tokenAsMap(recorder.Body.String())
+	NoError(t, err)
+	InDelta(t, time.Now().Add(h.config.JwtExpiry).Unix(), claims["exp"], 2)
+}
+
+func TestOriginOverrides_ExpiryOption(t *testing.T) {
+	cfg := testConfig()
+	cfg.JwtExpiry = 12 * time.Hour
+	cfg.Backends = Options{"simple": {"bob": "secret", "expiry": "8h"}}
+	cfg.Oauth = Options{"github": {"client_id": "id", "client_secret": "secret", "expiry": "1h"}}
+	NoError(t, cfg.addOriginOverride("origin=simple,jwt-refreshes=0"))
+
+	overrides, err := parseOriginOverrides(cfg)
+	NoError(t, err)
+	Equal(t, map[string]sessionSettings{
+		"simple": {JwtExpiry: 8 * time.Hour, CookieExpiry: cfg.CookieExpiry, JwtRefreshes: 0},
+		"github": {JwtExpiry: time.Hour, CookieExpiry: cfg.CookieExpiry, JwtRefreshes: 1},
+	}, overrides)
+	// the configured overrides are not modified
+	Equal(t, Options{"simple": {"jwt-refreshes": "0"}}, cfg.OriginOverrides)
+
+	// the option is no user of the simple backend
+	h, err := NewHandler(cfg)
+	NoError(t, err)
+	recorder := httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("POST", "/context/login", "username=expiry&password=8h", TypeForm, AcceptJwt))
+	Equal(t, 403, recorder.Code)
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("POST", "/context/login", "username=bob&password=secret", TypeForm, AcceptJwt))
+	Equal(t, 200, recorder.Code)
+	claims, err := tokenAsMap(recorder.Body.String())
+	NoError(t, err)
+	InDelta(t, time.Now().Add(8*time.Hour).Unix(), claims["exp"], 2)
+
+	// set twice
+	NoError(t, cfg.addOriginOverride("origin=github,jwt-expiry=2h"))
+	_, err = parseOriginOverrides(cfg)
+	EqualError(t, err, "the jwt expiry of origin github is set by the expiry option and by -origin-override")
+
+	// invalid or too long
+	for _, expiry := range []string{"foo", "48h"} {
+		cfg := testConfig()
+		cfg.Backends = Options{"simple": {"bob": "secret", "expiry": expiry}}
+		_, err := NewHandler(cfg)
+		Error(t, err, expiry)
+	}
+}
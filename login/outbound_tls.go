@@ -0,0 +1,77 @@
+package login
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/tarent/loginsrv/logging"
+	"github.com/tarent/loginsrv/outboundtls"
+)
+
+// addOutboundTLSHost adds the tls settings for one host in the form of host=..,ca=..,min-version=..
+func (c *Config) addOutboundTLSHost(optsKvList string) error {
+	opts, err := parseOptions(optsKvList)
+	if err != nil {
+		return err
+	}
+	host, ok := opts["host"]
+	if !ok || host == "" {
+		return errors.New("missing host name host=...")
+	}
+	delete(opts, "host")
+	if c.OutboundTLSHosts == nil {
+		c.OutboundTLSHosts = Options{}
+	}
+	c.OutboundTLSHosts[host] = opts
+	return nil
+}
+
+// outboundTLSSettings are the global tls settings for the outbound connections
+func (c *Config) outboundTLSSettings() outboundtls.Settings {
+	return outboundtls.Settings{
+		CA:         c.OutboundTLSCA,
+		MinVersion: c.OutboundTLSMinVersion,
+		ClientCert: c.OutboundTLSClientCert,
+		ClientKey:  c.OutboundTLSClientKey,
+		SkipVerify: c.OutboundTLSSkipVerify,
+	}
+}
+
+// newOutboundTLS creates the tls configuration for the connections to the oauth providers,
+// the backends, the webhooks and the kms. Settings of a host, which are not set, fall back to the global settings.
+func newOutboundTLS(config *Config) (*outboundtls.Config, error) {
+	hosts := map[string]outboundtls.Settings{}
+	for _, host := range sortedOptionNames(config.OutboundTLSHosts) {
+		s := config.outboundTLSSettings()
+		for k, v := range config.OutboundTLSHosts[host] {
+			switch k {
+			case "ca":
+				s.CA = v
+			case "min-version":
+				s.MinVersion = v
+			case "client-cert":
+				s.ClientCert = v
+			case "client-key":
+				s.ClientKey = v
+			case "skip-verify":
+				skipVerify, err := strconv.ParseBool(v)
+				if err != nil {
+					return nil, fmt.Errorf("Invalid outbound tls settings for host %v: invalid skip-verify %q", host, v)
+				}
+				s.SkipVerify = skipVerify
+			default:
+				return nil, fmt.Errorf("Invalid outbound tls settings for host %v: unknown option %q", host, k)
+			}
+		}
+		hosts[host] = s
+	}
+	outbound, err := outboundtls.NewConfig(config.outboundTLSSettings(), hosts)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid outbound tls settings: %v", err)
+	}
+	if config.OutboundTLSSkipVerify {
+		logging.Logger.Warn("the server certificates of outbound connections are not verified (-outbound-tls-skip-verify)")
+	}
+	return outbound, nil
+}
@@ -0,0 +1,46 @@
+package login
+
+import (
+	"crypto/tls"
+	"testing"
+
+	. "github.com/stretchr/testify/assert"
+)
+
+func TestConfig_OutboundTLSHosts(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.OutboundTLSMinVersion = "1.2"
+	NoError(t, cfg.addOutboundTLSHost("host=legacy.example.com,min-version=1.0"))
+	NoError(t, cfg.addOutboundTLSHost("host=test.example.com,skip-verify=true"))
+	Error(t, cfg.addOutboundTLSHost("min-version=1.0"))
+
+	outbound, err := newOutboundTLS(cfg)
+	NoError(t, err)
+	Equal(t, []string{"legacy.example.com", "test.example.com"}, outbound.Hosts())
+	Equal(t, uint16(tls.VersionTLS12), outbound.TLSConfigFor("github.com").MinVersion)
+	Equal(t, uint16(tls.VersionTLS10), outbound.TLSConfigFor("legacy.example.com").MinVersion)
+
+	// unset host settings fall back to the global settings
+	test := outbound.TLSConfigFor("test.example.com")
+	Equal(t, uint16(tls.VersionTLS12), test.MinVersion)
+	True(t, test.InsecureSkipVerify)
+}
+
+func TestConfig_OutboundTLSHosts_Invalid(t *testing.T) {
+	for _, opts := range []string{
+		"host=example.com,skip-verify=maybe",
+		"host=example.com,cipher=rc4",
+		"host=example.com,min-version=1.4",
+		"host=example.com,client-cert=cert.pem",
+	} {
+		cfg := DefaultConfig()
+		NoError(t, cfg.addOutboundTLSHost(opts))
+		_, err := newOutboundTLS(cfg)
+		Error(t, err, opts)
+	}
+
+	cfg := testConfig()
+	cfg.OutboundTLSCA = "/does/not/exist.pem"
+	_, err := NewHandler(cfg)
+	Error(t, err)
+}
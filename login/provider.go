@@ -1,35 +1,117 @@
 package login
 
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+)
+
 // Provider is a factory method for creation of login backends.
 type Provider func(config map[string]string) (Backend, error)
 
-var provider = map[string]Provider{}
-var providerDescription = map[string]*ProviderDescription{}
+// ProviderRegistry holds the backend providers by name.
+// It is safe for concurrent use.
+type ProviderRegistry struct {
+	mu           sync.RWMutex
+	providers    map[string]Provider
+	descriptions map[string]*ProviderDescription
+}
+
+// NewProviderRegistry returns an empty registry,
+// e.g. for tests, which register fake providers without affecting other tests.
+func NewProviderRegistry() *ProviderRegistry {
+	return &ProviderRegistry{
+		providers:    map[string]Provider{},
+		descriptions: map[string]*ProviderDescription{},
+	}
+}
+
+// DefaultRegistry holds the providers, which are registered by the init functions of their packages.
+// It is used by NewHandler and for the command line flags.
+var DefaultRegistry = NewProviderRegistry()
+
+// Register adds a factory method by the provider name.
+// A provider name can only be registered once.
+func (r *ProviderRegistry) Register(desc *ProviderDescription, factoryMethod Provider) error {
+	if desc == nil || desc.Name == "" {
+		return errors.New("missing provider name")
+	}
+	if factoryMethod == nil {
+		return fmt.Errorf("missing factory method for provider %v", desc.Name)
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, exist := r.providers[desc.Name]; exist {
+		return fmt.Errorf("provider %v is already registered", desc.Name)
+	}
+	r.providers[desc.Name] = factoryMethod
+	r.descriptions[desc.Name] = desc
+	return nil
+}
+
+// Unregister removes the provider, if it is registered.
+func (r *ProviderRegistry) Unregister(providerName string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.providers, providerName)
+	delete(r.descriptions, providerName)
+}
+
+// Get returns a registered provider by its name.
+// The bool return parameter indicated, if there was such a provider.
+func (r *ProviderRegistry) Get(providerName string) (Provider, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	p, exist := r.providers[providerName]
+	return p, exist
+}
 
-// RegisterProvider registers a factory method by the provider name.
+// Description returns the metainfo for a provider
+func (r *ProviderRegistry) Description(providerName string) (*ProviderDescription, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	p, exist := r.descriptions[providerName]
+	return p, exist
+}
+
+// List returns the names of all registered providers in alphabetical order
+func (r *ProviderRegistry) List() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	list := make([]string, 0, len(r.providers))
+	for k := range r.providers {
+		list = append(list, k)
+	}
+	sort.Strings(list)
+	return list
+}
+
+// RegisterProvider registers a factory method by the provider name in the DefaultRegistry.
+// It panics, if the name is already registered, because two packages would provide the same backend.
 func RegisterProvider(desc *ProviderDescription, factoryMethod Provider) {
-	provider[desc.Name] = factoryMethod
-	providerDescription[desc.Name] = desc
+	if err := DefaultRegistry.Register(desc, factoryMethod); err != nil {
+		panic("login: " + err.Error())
+	}
+}
+
+// UnregisterProvider removes a provider from the DefaultRegistry, e.g. after a test.
+func UnregisterProvider(providerName string) {
+	DefaultRegistry.Unregister(providerName)
 }
 
 // GetProvider returns a registered provider by its name.
 // The bool return parameter indicated, if there was such a provider.
 func GetProvider(providerName string) (Provider, bool) {
-	p, exist := provider[providerName]
-	return p, exist
+	return DefaultRegistry.Get(providerName)
 }
 
 // GetProviderDescription returns the metainfo for a provider
 func GetProviderDescription(providerName string) (*ProviderDescription, bool) {
-	p, exist := providerDescription[providerName]
-	return p, exist
+	return DefaultRegistry.Description(providerName)
 }
 
-// ProviderList returns the names of all registered provider
+// ProviderList returns the names of all registered provider in alphabetical order
 func ProviderList() []string {
-	list := make([]string, 0, len(provider))
-	for k := range provider {
-		list = append(list, k)
-	}
-	return list
+	return DefaultRegistry.List()
 }
@@ -7,4 +7,8 @@ type ProviderDescription struct {
 
 	// the text for the commandline option
 	HelpText string
+
+	// SensitiveValues marks all option values of the provider as secret,
+	// e.g. because they contain passwords.
+	SensitiveValues bool
 }
@@ -0,0 +1,86 @@
+package login
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+
+	. "github.com/stretchr/testify/assert"
+)
+
+func fakeProvider(config map[string]string) (Backend, error) {
+	return NewSimpleBackend(config), nil
+}
+
+func TestProviderRegistry(t *testing.T) {
+	r := NewProviderRegistry()
+	NoError(t, r.Register(&ProviderDescription{Name: "fake", HelpText: "fake backend"}, fakeProvider))
+	NoError(t, r.Register(&ProviderDescription{Name: "another"}, fakeProvider))
+
+	EqualError(t, r.Register(&ProviderDescription{Name: "fake"}, fakeProvider), "provider fake is already registered")
+	EqualError(t, r.Register(&ProviderDescription{}, fakeProvider), "missing provider name")
+	EqualError(t, r.Register(&ProviderDescription{Name: "nil"}, nil), "missing factory method for provider nil")
+
+	Equal(t, []string{"another", "fake"}, r.List())
+	desc, exist := r.Description("fake")
+	True(t, exist)
+	Equal(t, "fake backend", desc.HelpText)
+
+	r.Unregister("fake")
+	_, exist = r.Get("fake")
+	False(t, exist)
+	_, exist = r.Description("fake")
+	False(t, exist)
+	Equal(t, []string{"another"}, r.List())
+
+	// the name can be registered again after unregistering
+	NoError(t, r.Register(&ProviderDescription{Name: "fake"}, fakeProvider))
+}
+
+func TestRegisterProvider_Duplicate(t *testing.T) {
+	RegisterProvider(&ProviderDescription{Name: "duplicatetest"}, fakeProvider)
+	defer UnregisterProvider("duplicatetest")
+
+	Contains(t, ProviderList(), "duplicatetest")
+	PanicsWithValue(t, "login: provider duplicatetest is already registered", func() {
+		RegisterProvider(&ProviderDescription{Name: "duplicatetest"}, fakeProvider)
+	})
+}
+
+func TestProviderRegistry_Concurrent(t *testing.T) {
+	r := NewProviderRegistry()
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			name := fmt.Sprintf("fake%v", i)
+			NoError(t, r.Register(&ProviderDescription{Name: name}, fakeProvider))
+			_, exist := r.Get(name)
+			True(t, exist)
+			r.List()
+		}(i)
+	}
+	wg.Wait()
+	Equal(t, 20, len(r.List()))
+}
+
+func TestNewHandlerWithOptions_Registry(t *testing.T) {
+	r := NewProviderRegistry()
+	NoError(t, r.Register(&ProviderDescription{Name: "fake"}, fakeProvider))
+
+	cfg := DefaultConfig()
+	cfg.Backends = Options{"fake": {"bob": "secret"}}
+	h, err := NewHandlerWithOptions(cfg, WithRegistry(r))
+	NoError(t, err)
+	Equal(t, []string{"fake"}, h.backendNames)
+
+	// the provider is not known to the default registry
+	_, err = NewHandler(cfg)
+	Error(t, err)
+
+	// and the default providers are not known to the isolated registry
+	cfg.Backends = Options{"simple": {"bob": "secret"}}
+	_, err = NewHandlerWithOptions(cfg, WithRegistry(r))
+	Error(t, err)
+}
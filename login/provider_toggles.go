@@ -0,0 +1,192 @@
+package login
+
+import (
+	"encoding/json"
+	"net/http"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/tarent/loginsrv/logging"
+)
+
+const (
+	disabledProvidersNamespace        = "disabled_providers"
+	disabledProvidersNamespaceVersion = 1
+
+	// disabledProviderTTL keeps a provider disabled, until it is enabled again
+	disabledProviderTTL = 100 * 365 * 24 * time.Hour
+)
+
+var (
+	errAPINotAdmin          = apiError{403, "not_admin", "Forbidden: The user is not in the admin group"}
+	errAPIUnknownProvider   = apiError{404, "unknown_provider", "Not Found: No provider or backend with this name is configured"}
+	errAPIProviderDisabled  = apiError{503, "provider_disabled", "Service Unavailable: The provider is disabled, please use another way to sign in"}
+	providerDisabledMessage = "Sign in with this provider is currently not available. Please use another way to sign in."
+)
+
+// providerState is the state of a configured oauth provider or backend in the admin api
+type providerState struct {
+	Name       string `json:"name"`
+	Type       string `json:"type"`
+	Enabled    bool   `json:"enabled"`
+	DisabledBy string `json:"disabled_by,omitempty"`
+}
+
+type providerStates struct {
+	Providers []providerState `json:"providers"`
+}
+
+// isAdminProvidersPath matches <login-path>/admin/providers and the paths below, if the -admin-group is set
+func (h *Handler) isAdminProvidersPath(r *http.Request) bool {
+	prefix := path.Join(h.config.LoginPath, "admin", "providers")
+	return h.config.AdminGroup != "" && (r.URL.Path == prefix || strings.HasPrefix(r.URL.Path, prefix+"/"))
+}
+
+// providerDisabled returns true, if the oauth provider or backend was disabled by the admin api
+func (h *Handler) providerDisabled(name string) bool {
+	_, disabled := h.store.get(disabledProvidersNamespace, name)
+	return disabled
+}
+
+// disabledProviders returns the sorted names of the disabled providers and backends
+func (h *Handler) disabledProviders() []string {
+	var names []string
+	h.store.scan(disabledProvidersNamespace, func(key, value string) {
+		names = append(names, key)
+	})
+	sort.Strings(names)
+	return names
+}
+
+// enabledBackends removes the disabled backends from the chain
+func (h *Handler) enabledBackends(backends []Backend, names []string) ([]Backend, []string) {
+	var enabled []Backend
+	var enabledNames []string
+	for i, b := range backends {
+		if !h.providerDisabled(backendName(names, i)) {
+			enabled = append(enabled, b)
+			enabledNames = append(enabledNames, backendName(names, i))
+		}
+	}
+	return enabled, enabledNames
+}
+
+// formConfig is the configuration for the login form, without the disabled providers and backends
+func (h *Handler) formConfig() *Config {
+	if len(h.disabledProviders()) == 0 {
+		return h.config
+	}
+	config := *h.config
+	config.Backends = Options{}
+	for name, options := range h.config.Backends {
+		if !h.providerDisabled(name) {
+			config.Backends[name] = options
+		}
+	}
+	config.Oauth = Options{}
+	for name, options := range h.config.Oauth {
+		if !h.providerDisabled(name) {
+			config.Oauth[name] = options
+		}
+	}
+	return &config
+}
+
+// respondProviderDisabled answers the deep links and callbacks of a disabled oauth provider
+func (h *Handler) respondProviderDisabled(w http.ResponseWriter, r *http.Request) {
+	if h.wantHTML(r) {
+		writeLoginForm(w,
+			loginFormData{
+				Failure:        true,
+				FailureMessage: providerDisabledMessage,
+				Config:         h.formConfig(),
+				Language:       formLanguage(r),
+				status:         errAPIProviderDisabled.status,
+			})
+		return
+	}
+	h.respondAPIError(w, r, errAPIProviderDisabled)
+}
+
+// providerStates lists the configured oauth providers and backends with their state
+func (h *Handler) providerStates() providerStates {
+	states := providerStates{Providers: []providerState{}}
+	add := func(name, providerType string) {
+		disabledBy, disabled := h.store.get(disabledProvidersNamespace, name)
+		states.Providers = append(states.Providers, providerState{Name: name, Type: providerType, Enabled: !disabled, DisabledBy: disabledBy})
+	}
+	for _, name := range h.backendNames {
+		add(name, "backend")
+	}
+	for _, name := range sortedOptionNames(h.config.Oauth) {
+		add(name, "oauth")
+	}
+	return states
+}
+
+// respondAdminProviders serves the admin api to disable and enable providers at runtime,
+// e.g. to hide an oauth provider during its outage:
+//
+//	GET  <login-path>/admin/providers                 lists the providers and backends
+//	POST <login-path>/admin/providers/<name>/disable  disables the provider or backend
+//	POST <login-path>/admin/providers/<name>/enable   enables it again
+//
+// The request has to be authenticated by a token of a user in the -admin-group.
+// The state is kept in the store of the handler, so it survives a restart only with the -state-file.
+func (h *Handler) respondAdminProviders(w http.ResponseWriter, r *http.Request) {
+	userInfo, failure := h.verifyToken(r, "")
+	if failure != "" {
+		h.respondAPIError(w, r, errAPIUnauthenticated)
+		return
+	}
+	if !(requirement{"groups", h.config.AdminGroup}).metBy(userInfo) {
+		h.respondAPIError(w, r, errAPINotAdmin)
+		return
+	}
+
+	operation := strings.TrimPrefix(r.URL.Path, path.Join(h.config.LoginPath, "admin", "providers"))
+	if operation == "" && r.Method == "GET" {
+		h.writeProviderStates(w)
+		return
+	}
+	parts := strings.Split(strings.TrimPrefix(operation, "/"), "/")
+	if r.Method != "POST" || len(parts) != 2 || (parts[1] != "disable" && parts[1] != "enable") {
+		h.respondBadRequest(w, r)
+		return
+	}
+	name := parts[0]
+	if !h.providerConfigured(name) {
+		h.respondAPIError(w, r, errAPIUnknownProvider)
+		return
+	}
+
+	if parts[1] == "disable" {
+		h.store.set(disabledProvidersNamespace, name, userInfo.Sub, disabledProviderTTL)
+		h.emitWithReason(r, EventProviderDisabled, userInfo.Sub, userInfo.Origin, name)
+	} else {
+		h.store.delete(disabledProvidersNamespace, name)
+		h.emitWithReason(r, EventProviderEnabled, userInfo.Sub, userInfo.Origin, name)
+	}
+	h.writeProviderStates(w)
+}
+
+func (h *Handler) providerConfigured(name string) bool {
+	_, oauth := h.config.Oauth[name]
+	for _, backend := range h.backendNames {
+		if backend == name {
+			return true
+		}
+	}
+	return oauth
+}
+
+func (h *Handler) writeProviderStates(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", contentTypeJSON)
+	w.Header().Set("Cache-Control", "no-cache, no-store, must-revalidate")
+	w.WriteHeader(200)
+	if err := json.NewEncoder(w).Encode(h.providerStates()); err != nil {
+		logging.Logger.WithError(err).Error("could not write the provider states")
+	}
+}
@@ -0,0 +1,128 @@
+package login
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	. "github.com/stretchr/testify/assert"
+	"github.com/tarent/loginsrv/model"
+)
+
+func providerTogglesConfig() *Config {
+	cfg := testConfig()
+	cfg.Backends = Options{"simple": {"bob": "secret"}}
+	cfg.Oauth = Options{"github": {"client_id": "id", "client_secret": "secret"}}
+	cfg.AdminGroup = "admins"
+	return cfg
+}
+
+func TestHandler_ProviderToggles(t *testing.T) {
+	h, err := NewHandler(providerTogglesConfig())
+	NoError(t, err)
+	rec := &eventRecorder{}
+	h.Subscribe(Subscriber{Name: "test", Handle: rec.handle})
+
+	adminToken, err := h.createToken(model.UserInfo{Sub: "alice", Groups: []string{"admins"}, Expiry: time.Now().Add(time.Minute).Unix()})
+	NoError(t, err)
+	admin := "Authorization: Bearer " + adminToken
+	userToken, err := h.createToken(model.UserInfo{Sub: "bob", Expiry: time.Now().Add(time.Minute).Unix()})
+	NoError(t, err)
+
+	// only admins
+	Equal(t, 401, callHandler(h, req("GET", "/context/login/admin/providers", "")).Code)
+	Equal(t, 403, callHandler(h, req("GET", "/context/login/admin/providers", "", "Authorization: Bearer "+userToken)).Code)
+
+	recorder := callHandler(h, req("GET", "/context/login/admin/providers", "", admin))
+	Equal(t, 200, recorder.Code)
+	states := providerStates{}
+	NoError(t, json.Unmarshal(recorder.Body.Bytes(), &states))
+	Equal(t, []providerState{{Name: "simple", Type: "backend", Enabled: true}, {Name: "github", Type: "oauth", Enabled: true}}, states.Providers)
+
+	// disable the oauth provider
+	recorder = callHandler(h, req("POST", "/context/login/admin/providers/github/disable", "", admin))
+	Equal(t, 200, recorder.Code)
+	NoError(t, json.Unmarshal(recorder.Body.Bytes(), &states))
+	Equal(t, providerState{Name: "github", Type: "oauth", Enabled: false, DisabledBy: "alice"}, states.Providers[1])
+	Equal(t, []EventType{EventProviderDisabled}, rec.types())
+	Equal(t, "alice", rec.events[0].Username)
+	Equal(t, "github", rec.events[0].Reason)
+
+	recorder = callHandler(h, req("GET", "/context/login", "", AcceptHTML))
+	Equal(t, 200, recorder.Code)
+	NotContains(t, recorder.Body.String(), "/context/login/github")
+	Contains(t, recorder.Body.String(), `name="password"`)
+
+	recorder = callHandler(h, req("GET", "/context/login/github", "", AcceptHTML))
+	Equal(t, 503, recorder.Code)
+	Contains(t, recorder.Body.String(), providerDisabledMessage)
+	recorder = callHandler(h, req("GET", "/context/login/github?code=c&state=s", "", "X-Login-API-Version: 2"))
+	Equal(t, 503, recorder.Code)
+	p := problem{}
+	NoError(t, json.Unmarshal(recorder.Body.Bytes(), &p))
+	Equal(t, "provider_disabled", p.Code)
+
+	recorder = callHandler(h, req("GET", "/context/login/providers", ""))
+	Equal(t, `{"providers":[]}`+"\n", recorder.Body.String())
+
+	health := healthStatus{}
+	NoError(t, json.Unmarshal(callHandler(h, req("GET", "/context/login/health", "")).Body.Bytes(), &health))
+	Equal(t, []string{"github"}, health.DisabledProviders)
+
+	// a disabled backend is skipped in the chain
+	Equal(t, 200, callHandler(h, req("POST", "/context/login/admin/providers/simple/disable", "", admin)).Code)
+	Equal(t, 403, callHandler(h, req("POST", "/context/login", "username=bob&password=secret", TypeForm, AcceptJwt)).Code)
+	NotContains(t, callHandler(h, req("GET", "/context/login", "", AcceptHTML)).Body.String(), `name="password"`)
+
+	Equal(t, 200, callHandler(h, req("POST", "/context/login/admin/providers/simple/enable", "", admin)).Code)
+	// from another client, because identical submissions share the failed result within the duplicate login window
+	login := req("POST", "/context/login", "username=bob&password=secret", TypeForm, AcceptJwt)
+	login.RemoteAddr = "192.0.2.1:1234"
+	Equal(t, 200, callHandler(h, login).Code)
+	Equal(t, 200, callHandler(h, req("POST", "/context/login/admin/providers/github/enable", "", admin)).Code)
+	Equal(t, 302, callHandler(h, req("GET", "/context/login/github", "")).Code)
+	Equal(t, EventProviderEnabled, rec.events[len(rec.events)-1].Type)
+
+	// invalid operations
+	Equal(t, 404, callHandler(h, req("POST", "/context/login/admin/providers/gitlab/disable", "", admin)).Code)
+	Equal(t, 400, callHandler(h, req("POST", "/context/login/admin/providers/github/delete", "", admin)).Code)
+	Equal(t, 400, callHandler(h, req("GET", "/context/login/admin/providers/github/disable", "", admin)).Code)
+	Equal(t, 400, callHandler(h, req("POST", "/context/login/admin/providers", "", admin)).Code)
+}
+
+func TestHandler_ProviderToggles_WithoutAdminGroup(t *testing.T) {
+	cfg := providerTogglesConfig()
+	cfg.AdminGroup = ""
+	h, err := NewHandler(cfg)
+	NoError(t, err)
+	token, err := h.createToken(model.UserInfo{Sub: "alice", Groups: []string{"admins"}, Expiry: time.Now().Add(time.Minute).Unix()})
+	NoError(t, err)
+	Equal(t, 404, callHandler(h, req("POST", "/context/login/admin/providers/github/disable", "", "Authorization: Bearer "+token)).Code)
+}
+
+func TestHandler_ProviderToggles_StateFile(t *testing.T) {
+	dir := tmpDir(t)
+	defer os.RemoveAll(dir)
+	cfg := providerTogglesConfig()
+	cfg.StateFile = filepath.Join(dir, "state.json")
+
+	h, err := NewHandler(cfg)
+	NoError(t, err)
+	token, err := h.createToken(model.UserInfo{Sub: "alice", Groups: []string{"admins"}, Expiry: time.Now().Add(time.Minute).Unix()})
+	NoError(t, err)
+	Equal(t, 200, callHandler(h, req("POST", "/context/login/admin/providers/github/disable", "", "Authorization: Bearer "+token)).Code)
+	NoError(t, h.store.writeSnapshot(cfg.StateFile))
+
+	restarted, err := NewHandler(cfg)
+	NoError(t, err)
+	True(t, restarted.providerDisabled("github"))
+	Equal(t, 503, callHandler(restarted, req("GET", "/context/login/github", "")).Code)
+
+	// without the state file, the state is lost on a restart
+	cfg.StateFile = ""
+	restarted, err = NewHandler(cfg)
+	NoError(t, err)
+	False(t, restarted.providerDisabled("github"))
+}
@@ -0,0 +1,293 @@
+package login
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"path"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/tarent/loginsrv/logging"
+)
+
+// readinessTick is the interval, in which the due readiness checks are started
+var readinessTick = time.Second
+
+// readinessSettings are the interval, the timeout and the thresholds of the readiness check of a dependency.
+// A healthy dependency becomes unready after Fall failed checks in a row,
+// an unready one becomes ready again after Rise successful checks in a row.
+type readinessSettings struct {
+	Interval time.Duration
+	Timeout  time.Duration
+	Rise     int
+	Fall     int
+}
+
+// addReadyCheck adds the settings of the readiness check of a dependency in the form of name=..,key=value,..
+func (c *Config) addReadyCheck(optsKvList string) error {
+	opts, err := parseOptions(optsKvList)
+	if err != nil {
+		return err
+	}
+	name, ok := opts["name"]
+	if !ok || name == "" {
+		return errors.New("missing dependency name name=...")
+	}
+	delete(opts, "name")
+	if c.ReadyChecks == nil {
+		c.ReadyChecks = Options{}
+	}
+	c.ReadyChecks[name] = opts
+	return nil
+}
+
+func (c *Config) readinessSettings() readinessSettings {
+	return readinessSettings{
+		Interval: c.ReadyInterval,
+		Timeout:  c.ReadyTimeout,
+		Rise:     c.ReadyRise,
+		Fall:     c.ReadyFall,
+	}
+}
+
+// readinessDependency is a backend, which checks the availability of its remote service
+type readinessDependency struct {
+	name     string
+	checker  ReadinessChecker
+	settings readinessSettings
+}
+
+// newReadinessDependencies returns the backends with readiness checks and their settings.
+// Settings, which are not set for a dependency, fall back to the global ones.
+func newReadinessDependencies(config *Config, backends []Backend, backendNames []string) ([]readinessDependency, error) {
+	var deps []readinessDependency
+	for i, b := range backends {
+		if checker, ok := b.(ReadinessChecker); ok {
+			deps = append(deps, readinessDependency{name: backendName(backendNames, i), checker: checker})
+		}
+	}
+	for name := range config.ReadyChecks {
+		if !hasReadinessDependency(deps, name) {
+			return nil, fmt.Errorf("Invalid readiness check for %v: no backend with readiness check of this name", name)
+		}
+	}
+	for i := range deps {
+		s := config.readinessSettings()
+		for k, v := range config.ReadyChecks[deps[i].name] {
+			var err error
+			switch k {
+			case "interval":
+				s.Interval, err = time.ParseDuration(v)
+			case "timeout":
+				s.Timeout, err = time.ParseDuration(v)
+			case "rise":
+				s.Rise, err = strconv.Atoi(v)
+			case "fall":
+				s.Fall, err = strconv.Atoi(v)
+			default:
+				err = fmt.Errorf("unknown option %q", k)
+			}
+			if err != nil {
+				return nil, fmt.Errorf("Invalid readiness check for %v: %v", deps[i].name, err)
+			}
+		}
+		if s.Interval <= 0 || s.Timeout <= 0 || s.Rise < 1 || s.Fall < 1 {
+			return nil, fmt.Errorf("Invalid readiness check for %v: interval and timeout have to be positive, rise and fall at least 1", deps[i].name)
+		}
+		deps[i].settings = s
+	}
+	return deps, nil
+}
+
+func hasReadinessDependency(deps []readinessDependency, name string) bool {
+	for _, d := range deps {
+		if d.name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// readinessMonitor keeps the latest results of the readiness checks.
+// The checks run in the background, the probes only read the results,
+// so that frequent probes do not multiply the load on the dependencies
+// and a slow dependency does not delay the probe.
+type readinessMonitor struct {
+	mu       sync.Mutex
+	states   map[string]*dependencyState
+	inflight sync.WaitGroup
+	now      func() time.Time
+}
+
+// dependencyState is the dampened readiness of a dependency and its latest result
+type dependencyState struct {
+	ready     bool
+	checked   bool
+	successes int
+	failures  int
+	lastError string
+	checkedAt time.Time
+	next      time.Time
+	running   bool
+}
+
+func newReadinessMonitor() *readinessMonitor {
+	return &readinessMonitor{states: map[string]*dependencyState{}, now: time.Now}
+}
+
+// refresh starts the checks of the dependencies, which are due and not running.
+// The states of dependencies, which are no longer configured, are removed.
+func (m *readinessMonitor) refresh(deps []readinessDependency) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := m.now()
+	for name := range m.states {
+		if !hasReadinessDependency(deps, name) {
+			delete(m.states, name)
+		}
+	}
+	for _, d := range deps {
+		s, exist := m.states[d.name]
+		if !exist {
+			s = &dependencyState{}
+			m.states[d.name] = s
+		}
+		if s.running || now.Before(s.next) {
+			continue
+		}
+		s.running = true
+		m.inflight.Add(1)
+		go m.check(d)
+	}
+}
+
+func (m *readinessMonitor) check(d readinessDependency) {
+	defer m.inflight.Done()
+	ctx, cancel := context.WithTimeout(context.Background(), d.settings.Timeout)
+	err := d.checker.CheckReady(ctx)
+	cancel()
+	m.record(d, err)
+}
+
+// record applies the result of a check. The first result sets the readiness directly,
+// later ones flip it only after the rise or fall threshold.
+func (m *readinessMonitor) record(d readinessDependency, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s, exist := m.states[d.name]
+	if !exist {
+		return
+	}
+	now := m.now()
+	s.running = false
+	s.checkedAt = now
+	s.next = now.Add(d.settings.Interval)
+	if err == nil {
+		s.successes++
+		s.failures = 0
+		s.lastError = ""
+	} else {
+		s.failures++
+		s.successes = 0
+		s.lastError = err.Error()
+	}
+
+	switch {
+	case !s.checked:
+		s.checked = true
+		s.ready = err == nil
+	case !s.ready && s.successes >= d.settings.Rise:
+		s.ready = true
+		logging.Logger.WithField("dependency", d.name).Info("dependency is ready again")
+	case s.ready && s.failures >= d.settings.Fall:
+		s.ready = false
+		logging.Logger.WithField("dependency", d.name).WithField("error", s.lastError).Warn("dependency is not ready")
+	}
+}
+
+type readinessStatus struct {
+	Status       string                      `json:"status"`
+	Dependencies map[string]dependencyStatus `json:"dependencies,omitempty"`
+	// UsernameConflicts is the check of the usernames known by multiple backends
+	UsernameConflicts *dependencyStatus `json:"username_conflicts,omitempty"`
+}
+
+type dependencyStatus struct {
+	Ready bool `json:"ready"`
+	// LastResult is ok, failed or pending, if there is no result yet
+	LastResult string  `json:"last_result"`
+	Error      string  `json:"error,omitempty"`
+	AgeSeconds float64 `json:"age_seconds"`
+}
+
+// status returns the readiness of the dependencies. Dependencies without result are not ready.
+func (m *readinessMonitor) status(deps []readinessDependency) readinessStatus {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := m.now()
+	status := readinessStatus{Status: "ready"}
+	names := make([]string, 0, len(deps))
+	for _, d := range deps {
+		names = append(names, d.name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if status.Dependencies == nil {
+			status.Dependencies = map[string]dependencyStatus{}
+		}
+		d := dependencyStatus{LastResult: "pending"}
+		if s, exist := m.states[name]; exist && s.checked {
+			d.Ready = s.ready
+			d.LastResult = "ok"
+			if s.failures > 0 {
+				d.LastResult = "failed"
+			}
+			d.Error = s.lastError
+			d.AgeSeconds = now.Sub(s.checkedAt).Seconds()
+		}
+		if !d.Ready {
+			status.Status = "not_ready"
+		}
+		status.Dependencies[name] = d
+	}
+	return status
+}
+
+func (h *Handler) isReadyPath(r *http.Request) bool {
+	return r.URL.Path == path.Join(h.config.LoginPath, "ready")
+}
+
+// respondReady answers the readiness probe with the latest results of the background checks:
+// 200, if all dependencies are ready, 503 otherwise.
+func (h *Handler) respondReady(w http.ResponseWriter, r *http.Request) {
+	status := h.readiness.status(h.readinessDeps)
+	if conflicts, checked := h.conflictCheckStatus(); checked {
+		status.UsernameConflicts = &conflicts
+		if !conflicts.Ready {
+			status.Status = "not_ready"
+		}
+	}
+	code := 200
+	if status.Status != "ready" {
+		code = 503
+	}
+	w.Header().Set("Content-Type", contentTypeJSON)
+	w.Header().Set("Cache-Control", "no-cache, no-store, must-revalidate")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(status)
+}
+
+// readinessHook returns a hook, which runs the readiness checks of the current snapshot in the background
+func (h *Handler) readinessHook() Hook {
+	refresh := func() {
+		current := h.snapshot()
+		current.readiness.refresh(current.readinessDeps)
+	}
+	task := &backgroundTask{interval: readinessTick, run: refresh}
+	return task.hook("readiness-checks")
+}
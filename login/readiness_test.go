@@ -0,0 +1,177 @@
+package login
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	. "github.com/stretchr/testify/assert"
+	"github.com/tarent/loginsrv/model"
+)
+
+// readyTestBackend is a backend with a readiness check, which fails with err
+type readyTestBackend struct {
+	err   atomic.Value
+	calls int64
+}
+
+func (b *readyTestBackend) Authenticate(username, password string) (bool, model.UserInfo, error) {
+	return false, model.UserInfo{}, nil
+}
+
+func (b *readyTestBackend) AuthenticateWithContext(ctx context.Context, username, password string) (bool, model.UserInfo, error) {
+	return false, model.UserInfo{}, nil
+}
+
+func (b *readyTestBackend) CheckReady(ctx context.Context) error {
+	atomic.AddInt64(&b.calls, 1)
+	e, _ := b.err.Load().(errorOrNone)
+	return e.err
+}
+
+// errorOrNone wraps the error of the check, because atomic.Value does not store nil
+type errorOrNone struct {
+	err error
+}
+
+type hangingBackend struct {
+	readyTestBackend
+}
+
+func (b *hangingBackend) CheckReady(ctx context.Context) error {
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func TestNewReadinessDependencies(t *testing.T) {
+	cfg := DefaultConfig()
+	backends := []Backend{&readyTestBackend{}, NewSimpleBackend(map[string]string{}), &readyTestBackend{}}
+	names := []string{"osiam", "simple", "upstream"}
+
+	NoError(t, cfg.addReadyCheck("name=osiam,interval=1m,timeout=5s,rise=1,fall=5"))
+	deps, err := newReadinessDependencies(cfg, backends, names)
+	NoError(t, err)
+	Equal(t, 2, len(deps))
+	Equal(t, "osiam", deps[0].name)
+	Equal(t, readinessSettings{Interval: time.Minute, Timeout: 5 * time.Second, Rise: 1, Fall: 5}, deps[0].settings)
+	Equal(t, "upstream", deps[1].name)
+	Equal(t, cfg.readinessSettings(), deps[1].settings)
+
+	for _, check := range []string{"name=simple", "name=osiam,interval=often", "name=osiam,fall=0", "name=osiam,retries=1"} {
+		cfg := DefaultConfig()
+		NoError(t, cfg.addReadyCheck(check))
+		_, err := newReadinessDependencies(cfg, backends, names)
+		Error(t, err, check)
+	}
+	Error(t, DefaultConfig().addReadyCheck("interval=1m"))
+}
+
+func TestReadinessMonitor_Dampening(t *testing.T) {
+	backend := &readyTestBackend{}
+	deps := []readinessDependency{{name: "osiam", checker: backend, settings: readinessSettings{Interval: time.Minute, Timeout: time.Second, Rise: 2, Fall: 3}}}
+	m := newReadinessMonitor()
+	now := time.Now()
+	m.now = func() time.Time { return now }
+
+	check := func(err error) dependencyStatus {
+		backend.err.Store(errorOrNone{err})
+		now = now.Add(time.Minute)
+		m.refresh(deps)
+		m.inflight.Wait()
+		return m.status(deps).Dependencies["osiam"]
+	}
+
+	Equal(t, "pending", m.status(deps).Dependencies["osiam"].LastResult)
+	Equal(t, "not_ready", m.status(deps).Status)
+
+	// the first result is taken directly
+	True(t, check(nil).Ready)
+	Equal(t, "ready", m.status(deps).Status)
+
+	down := errors.New("connection refused")
+	status := check(down)
+	True(t, status.Ready)
+	Equal(t, "failed", status.LastResult)
+	Equal(t, "connection refused", status.Error)
+	True(t, check(down).Ready)
+	False(t, check(down).Ready)
+	Equal(t, "not_ready", m.status(deps).Status)
+
+	// a single success does not flip it back
+	status = check(nil)
+	False(t, status.Ready)
+	Equal(t, "ok", status.LastResult)
+	False(t, check(down).Ready)
+	False(t, check(nil).Ready)
+	True(t, check(nil).Ready)
+
+	// the age of the result
+	now = now.Add(30 * time.Second)
+	Equal(t, float64(30), m.status(deps).Dependencies["osiam"].AgeSeconds)
+}
+
+func TestReadinessMonitor_Interval(t *testing.T) {
+	backend := &readyTestBackend{}
+	deps := []readinessDependency{{name: "osiam", checker: backend, settings: readinessSettings{Interval: time.Minute, Timeout: time.Second, Rise: 1, Fall: 1}}}
+	m := newReadinessMonitor()
+
+	// the checks run once per interval, independent of the probes
+	for i := 0; i < 5; i++ {
+		m.refresh(deps)
+		m.inflight.Wait()
+		m.status(deps)
+	}
+	Equal(t, int64(1), atomic.LoadInt64(&backend.calls))
+
+	// removed dependencies are forgotten
+	m.refresh(nil)
+	Equal(t, 0, len(m.states))
+}
+
+func TestReadinessMonitor_Timeout(t *testing.T) {
+	deps := []readinessDependency{{name: "slow", checker: &hangingBackend{}, settings: readinessSettings{Interval: time.Minute, Timeout: 10 * time.Millisecond, Rise: 1, Fall: 1}}}
+	m := newReadinessMonitor()
+	m.refresh(deps)
+	m.inflight.Wait()
+	status := m.status(deps).Dependencies["slow"]
+	False(t, status.Ready)
+	Equal(t, context.DeadlineExceeded.Error(), status.Error)
+}
+
+func TestHandler_Ready(t *testing.T) {
+	h := testHandler()
+	recorder := httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("GET", "/context/login/ready", ""))
+	Equal(t, 200, recorder.Code)
+	Equal(t, `{"status":"ready"}`+"\n", recorder.Body.String())
+
+	backend := &readyTestBackend{}
+	h.backends = append(h.backends, backend)
+	h.backendNames = []string{"simple", "osiam"}
+	deps, err := newReadinessDependencies(h.config, h.backends, h.backendNames)
+	NoError(t, err)
+	h.readinessDeps = deps
+
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("GET", "/context/login/ready", ""))
+	Equal(t, 503, recorder.Code)
+
+	h.readiness.refresh(h.readinessDeps)
+	h.readiness.inflight.Wait()
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("GET", "/context/login/ready", ""))
+	Equal(t, 200, recorder.Code)
+	status := readinessStatus{}
+	NoError(t, json.Unmarshal(recorder.Body.Bytes(), &status))
+	Equal(t, "ready", status.Status)
+	Equal(t, "ok", status.Dependencies["osiam"].LastResult)
+	var hooks []string
+	for _, hook := range h.Hooks() {
+		hooks = append(hooks, hook.Name)
+	}
+	Contains(t, hooks, "readiness-checks")
+}
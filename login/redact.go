@@ -0,0 +1,79 @@
+package login
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"strings"
+)
+
+const redacted = "..."
+
+// option keys containing one of these words are treated as sensitive
+var sensitiveKeyParts = []string{"secret", "password", "passwd", "token", "key", "credential"}
+
+// IsSensitiveKey returns true, if the value for the key should never be logged or printed.
+func IsSensitiveKey(key string) bool {
+	lower := strings.ToLower(key)
+	for _, part := range sensitiveKeyParts {
+		if strings.Contains(lower, part) {
+			return true
+		}
+	}
+	return false
+}
+
+// Redacted returns a copy of the configuration, with all secrets replaced,
+// so that it can be logged or printed.
+func (c *Config) Redacted() *Config {
+	r := *c
+	r.JwtSecret = redacted
+	if c.JwtLegacySecret != "" {
+		r.JwtLegacySecret = redacted
+	}
+	if c.JwtSecretFallback != "" {
+		r.JwtSecretFallback = redacted
+	}
+	if c.JweKey != "" {
+		r.JweKey = redacted
+	}
+	if c.IntrospectionSecret != "" {
+		r.IntrospectionSecret = redacted
+	}
+	if c.AdminAPIKey != "" {
+		r.AdminAPIKey = redacted
+	}
+	// webhook urls usually contain a token
+	if c.BreakGlassWebhook != "" {
+		r.BreakGlassWebhook = redacted
+	}
+	r.Backends = redactOptions(c.Backends, func(providerName string) bool {
+		desc, exist := GetProviderDescription(providerName)
+		return exist && desc.SensitiveValues
+	})
+	r.Oauth = redactOptions(c.Oauth, func(string) bool { return false })
+	return &r
+}
+
+// Hash returns a fingerprint of the configuration, e.g. to tell the configurations of the instances apart in the logs.
+// It is built from the redacted configuration, so that it can't be used to guess the secrets.
+func (c *Config) Hash() string {
+	b, _ := json.Marshal(c.Redacted())
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:8])
+}
+
+func redactOptions(options Options, allSensitive func(providerName string) bool) Options {
+	result := Options{}
+	for providerName, opts := range options {
+		redactAll := allSensitive(providerName)
+		result[providerName] = map[string]string{}
+		for k, v := range opts {
+			if redactAll || IsSensitiveKey(k) {
+				v = redacted
+			}
+			result[providerName][k] = v
+		}
+	}
+	return result
+}
@@ -0,0 +1,63 @@
+package login
+
+import (
+	. "github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestRedact_IsSensitiveKey(t *testing.T) {
+	True(t, IsSensitiveKey("client_secret"))
+	True(t, IsSensitiveKey("clientSecret"))
+	True(t, IsSensitiveKey("Password"))
+	True(t, IsSensitiveKey("api_key"))
+	False(t, IsSensitiveKey("client_id"))
+	False(t, IsSensitiveKey("endpoint"))
+}
+
+func TestRedact_Config(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.JwtSecret = "the-jwt-secret"
+	cfg.Backends = Options{
+		"simple": {"bob": "bobs-password"},
+		"osiam":  {"endpoint": "http://osiam", "client_id": "id", "client_secret": "osiam-secret"},
+	}
+	cfg.Oauth = Options{
+		"github": {"client_id": "id", "client_secret": "github-secret"},
+	}
+	cfg.BreakGlassWebhook = "https://hooks.example.com/services/T000/B000/XXXX"
+	cfg.JwtSecretFallback = "old-secret"
+
+	r := cfg.Redacted()
+	Equal(t, "...", r.JwtSecret)
+	Equal(t, map[string]string{"bob": "..."}, r.Backends["simple"])
+	Equal(t, map[string]string{"endpoint": "http://osiam", "client_id": "id", "client_secret": "..."}, r.Backends["osiam"])
+	Equal(t, map[string]string{"client_id": "id", "client_secret": "..."}, r.Oauth["github"])
+	Equal(t, "...", r.BreakGlassWebhook)
+	Equal(t, "...", r.JwtSecretFallback)
+
+	// the original is unchanged
+	Equal(t, "the-jwt-secret", cfg.JwtSecret)
+	Equal(t, "bobs-password", cfg.Backends["simple"]["bob"])
+}
+
+func TestConfig_Hash(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Backends = Options{"simple": {"bob": "secret"}}
+	hash := cfg.Hash()
+	Equal(t, 16, len(hash))
+	Equal(t, hash, cfg.Hash())
+
+	// the secrets are not part of the hash
+	other := *cfg
+	other.JwtSecret = "another-secret"
+	other.Backends = Options{"simple": {"bob": "another-password"}}
+	Equal(t, hash, other.Hash())
+
+	other.JwtExpiry = 2 * cfg.JwtExpiry
+	NotEqual(t, hash, other.Hash())
+}
+
+func TestOptions_Names(t *testing.T) {
+	Equal(t, []string{"github", "google"}, Options{"google": {}, "github": {}}.Names())
+	Equal(t, []string{}, Options{}.Names())
+}
@@ -0,0 +1,89 @@
+package login
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"unicode"
+
+	"github.com/tarent/loginsrv/logging"
+)
+
+// redirectTarget returns the target as value of a Location header, if the browser may be sent there.
+// Relative references stay on the host of loginsrv and are always allowed,
+// absolute urls only to the allowed hosts. An allowed host without port matches all ports.
+// Targets with control characters, backslashes or user info and protocol relative urls are rejected,
+// because browsers and proxies interpret them differently. Absolute urls have to be http(s) urls with an ascii host.
+// The returned value is percent encoded.
+func redirectTarget(target string, allowedHosts []string) (string, error) {
+	if target == "" {
+		return "", errors.New("empty target")
+	}
+	if strings.IndexFunc(target, func(r rune) bool { return r == '\\' || unicode.IsControl(r) }) != -1 {
+		return "", errors.New("control characters and backslashes are not allowed")
+	}
+	if strings.HasPrefix(target, "//") {
+		return "", errors.New("protocol relative urls are not allowed")
+	}
+	u, err := url.Parse(target)
+	if err != nil {
+		return "", err
+	}
+	if u.Scheme == "" && u.Host == "" {
+		return u.String(), nil
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return "", errors.New("only http and https urls are allowed")
+	}
+	if u.Host == "" {
+		return "", errors.New("missing host")
+	}
+	if u.User != nil {
+		return "", errors.New("user info is not allowed")
+	}
+	if strings.IndexFunc(u.Host, func(r rune) bool { return r > unicode.MaxASCII }) != -1 {
+		return "", errors.New("only ascii hosts are allowed")
+	}
+	if !redirectHostAllowed(u, allowedHosts) {
+		return "", fmt.Errorf("the host %v is not allowed, see -redirect-whitelist", u.Host)
+	}
+	return u.String(), nil
+}
+
+func redirectHostAllowed(u *url.URL, allowedHosts []string) bool {
+	host, hostname := strings.ToLower(u.Host), strings.ToLower(u.Hostname())
+	for _, allowed := range allowedHosts {
+		if host == allowed || hostname == allowed {
+			return true
+		}
+	}
+	return false
+}
+
+// configuredRedirectHosts returns the hosts, the handler may redirect to:
+// the hosts of the redirect whitelist and of the configured success and logout url.
+func configuredRedirectHosts(config *Config) []string {
+	hosts := parseRedirectWhitelist(config.RedirectWhitelist)
+	for _, target := range []string{config.SuccessURL, config.LogoutURL} {
+		if u, err := url.Parse(target); err == nil && u.Host != "" {
+			hosts = append(hosts, strings.ToLower(u.Host))
+		}
+	}
+	return hosts
+}
+
+// redirect answers with a 303 to the target, which has to be a relative reference
+// or an url of a host of the redirect whitelist or the configured urls.
+// The targets passed the validation of the config or the backTo parameter before,
+// so an invalid one is a bug and replaced by /.
+func (h *Handler) redirect(w http.ResponseWriter, target string) {
+	location, err := redirectTarget(target, configuredRedirectHosts(h.config))
+	if err != nil {
+		logging.Logger.WithError(err).Warnf("invalid redirect target %q, redirecting to /", target)
+		location = "/"
+	}
+	w.Header().Set("Location", location)
+	w.WriteHeader(303)
+}
@@ -0,0 +1,121 @@
+package login
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/tarent/loginsrv/logging"
+	"github.com/tarent/loginsrv/model"
+)
+
+// refreshCookieSuffix is appended to the cookie name for the name of the refresh token cookie
+const refreshCookieSuffix = "_refresh"
+
+// refreshTokenHeader carries the refresh token in responses of api version 1
+const refreshTokenHeader = "X-Login-Refresh-Token"
+
+// errRefreshTokenReused is returned for refresh tokens, which were rotated by a concurrent refresh
+var errRefreshTokenReused = errors.New("refresh token was already used")
+
+// refreshTokensEnabled checks, if separate refresh tokens are issued by -jwt-refresh-token-expiry
+func (h *Handler) refreshTokensEnabled() bool {
+	return h.config.JwtRefreshTokenExpiry > 0
+}
+
+func (h *Handler) refreshCookieName() string {
+	return h.config.CookieName + refreshCookieSuffix
+}
+
+// issueRefreshToken signs a refresh token with the claims of the access token.
+// It expires after the -jwt-refresh-token-expiry, but never after the end of the session.
+func (h *Handler) issueRefreshToken(r *http.Request, userInfo model.UserInfo) (model.UserInfo, string, error) {
+	userInfo.TokenType = model.TokenTypeRefresh
+	userInfo.Expiry = time.Now().Add(h.config.JwtRefreshTokenExpiry).Unix()
+	if h.config.JwtMaxSession > 0 {
+		if sessionEnd := time.Unix(userInfo.SessionStart, 0).Add(h.config.JwtMaxSession).Unix(); sessionEnd < userInfo.Expiry {
+			userInfo.Expiry = sessionEnd
+		}
+	}
+	id, err := newTokenID()
+	if err != nil {
+		return model.UserInfo{}, "", err
+	}
+	userInfo.ID = id
+	token, err := h.createTokenWithContext(r.Context(), userInfo)
+	return userInfo, token, err
+}
+
+// refreshTokenCookie returns the cookie for the refresh token. It is always http only
+// and only sent to the login resource, where it is needed for the refresh.
+func (h *Handler) refreshTokenCookie(token string, expiry int64) *http.Cookie {
+	cookie := &http.Cookie{
+		Name:     h.refreshCookieName(),
+		Value:    token,
+		HttpOnly: true,
+		Secure:   h.config.CookieSecure,
+		Path:     h.config.LoginPath,
+	}
+	setCookieLifetime(cookie, time.Until(time.Unix(expiry, 0)))
+	if h.config.CookieDomain != "" {
+		cookie.Domain = h.config.CookieDomain
+	}
+	return cookie
+}
+
+func (h *Handler) deleteRefreshToken(w http.ResponseWriter) {
+	cookie := h.refreshTokenCookie("delete", 0)
+	expireCookie(cookie)
+	h.setCookie(w, cookie)
+}
+
+// refreshTokenOf returns the refresh token of the request body, the Authorization bearer header or the refresh token cookie
+func (h *Handler) refreshTokenOf(r *http.Request, rtoken string) string {
+	if rtoken != "" {
+		return rtoken
+	}
+	if bearer := bearerToken(r); bearer != "" {
+		return bearer
+	}
+	if c, err := r.Cookie(h.refreshCookieName()); err == nil {
+		return c.Value
+	}
+	return ""
+}
+
+// rotateRefreshToken revokes the used refresh token, so that it can't be used again.
+// The check and the revocation are serialized, so that concurrent refreshes with the same token
+// are detected as reuse.
+func (h *Handler) rotateRefreshToken(r *http.Request, userInfo model.UserInfo) error {
+	if userInfo.ID == "" {
+		return nil
+	}
+	h.muRotations.Lock()
+	defer h.muRotations.Unlock()
+	revoked, err := h.revocations.IsRevoked(userInfo.ID)
+	if err != nil {
+		return err
+	}
+	if revoked {
+		return errRefreshTokenReused
+	}
+	return h.revokeUntilExpiry(userInfo)
+}
+
+// revokeRefreshToken revokes the refresh token of the cookie on logout, so that the session can't be continued by it.
+// Invalid refresh tokens are ignored.
+func (h *Handler) revokeRefreshToken(r *http.Request) {
+	userInfo, failure := h.verifyRefreshToken(r, "")
+	if failure != "" || userInfo.ID == "" {
+		return
+	}
+	if err := h.revokeUntilExpiry(userInfo); err != nil {
+		logging.Application(r.Header).WithError(err).Error("could not revoke the refresh token")
+	}
+}
+
+// revokeUntilExpiry revokes the token until it would be rejected anyway
+func (h *Handler) revokeUntilExpiry(userInfo model.UserInfo) error {
+	until := time.Unix(userInfo.Expiry, 0).Add(h.config.JwtLeeway + h.config.JwtRefreshGrace)
+	return h.revocations.Revoke(userInfo.ID, until)
+}
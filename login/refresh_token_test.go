@@ -0,0 +1,130 @@
+package login
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	. "github.com/stretchr/testify/assert"
+	"github.com/tarent/loginsrv/model"
+)
+
+func refreshTokenHandler() *Handler {
+	h := testHandler()
+	h.config.JwtExpiry = 10 * time.Minute
+	h.config.JwtRefreshTokenExpiry = 720 * time.Hour
+	h.config.JwtRefreshes = 0
+	return h
+}
+
+func TestHandler_RefreshTokens_JSON(t *testing.T) {
+	h := refreshTokenHandler()
+	serve := func(body string) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		h.ServeHTTP(recorder, req("POST", "/context/login", body, TypeJSON, "X-Login-API-Version: 2"))
+		return recorder
+	}
+
+	recorder := serve(`{"username": "bob", "password": "secret"}`)
+	Equal(t, 200, recorder.Code)
+	response := tokenResponse{}
+	NoError(t, json.Unmarshal(recorder.Body.Bytes(), &response))
+	InDelta(t, time.Now().Add(10*time.Minute).Unix(), response.ExpiresAt, 2)
+	InDelta(t, time.Now().Add(720*time.Hour).Unix(), response.RefreshExpiresAt, 2)
+	access, err := tokenAsMap(response.Token)
+	NoError(t, err)
+	Equal(t, model.TokenTypeAccess, access["token_type"])
+	refresh, err := tokenAsMap(response.RefreshToken)
+	NoError(t, err)
+	Equal(t, model.TokenTypeRefresh, refresh["token_type"])
+	Equal(t, access["origin_iat"], refresh["origin_iat"])
+
+	// the access token is not accepted for a refresh
+	Equal(t, 400, serve(`{"token": "`+response.Token+`"}`).Code)
+
+	// the refresh token is rotated
+	recorder = serve(`{"token": "` + response.RefreshToken + `"}`)
+	Equal(t, 200, recorder.Code)
+	rotated := tokenResponse{}
+	NoError(t, json.Unmarshal(recorder.Body.Bytes(), &rotated))
+	NotEqual(t, response.RefreshToken, rotated.RefreshToken)
+	claims, err := tokenAsMap(rotated.Token)
+	NoError(t, err)
+	Equal(t, model.TokenTypeAccess, claims["token_type"])
+	Nil(t, claims["refs"])
+
+	// and can't be used again
+	Equal(t, 400, serve(`{"token": "`+response.RefreshToken+`"}`).Code)
+	Equal(t, 200, serve(`{"token": "`+rotated.RefreshToken+`"}`).Code)
+}
+
+func TestHandler_RefreshTokens_Cookies(t *testing.T) {
+	h := refreshTokenHandler()
+
+	recorder := httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("POST", "/context/login", "username=bob&password=secret", TypeForm, AcceptHTML))
+	Equal(t, 303, recorder.Code)
+	cookies := readSetCookies(recorder.Header())
+	Equal(t, 2, len(cookies))
+	refreshCookie := cookies[1]
+	Equal(t, "jwt_token_refresh", refreshCookie.Name)
+	Equal(t, "/context/login", refreshCookie.Path)
+	True(t, refreshCookie.HttpOnly)
+
+	// the refresh token is no access token
+	_, failure := h.verifyToken(req("GET", "/context/login", "", "Cookie: jwt_token="+refreshCookie.Value), "")
+	Equal(t, TokenWrongType, failure)
+	_, failure = h.verifyToken(req("GET", "/context/login", "", "Cookie: jwt_token="+cookies[0].Value), "")
+	Equal(t, TokenFailure(""), failure)
+
+	// refresh by the refresh cookie, the access token cookie is not used
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("POST", "/context/login", "", AcceptHTML, "Cookie: jwt_token="+cookies[0].Value))
+	Equal(t, 400, recorder.Code)
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("POST", "/context/login", "", AcceptHTML, "Cookie: jwt_token_refresh="+refreshCookie.Value))
+	Equal(t, 303, recorder.Code)
+	cookies = readSetCookies(recorder.Header())
+	Equal(t, 2, len(cookies))
+
+	// the logout revokes the refresh token
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("DELETE", "/context/login", "", AcceptHTML, "Cookie: jwt_token_refresh="+cookies[1].Value))
+	Equal(t, 2, len(readSetCookies(recorder.Header())))
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("POST", "/context/login", "", AcceptHTML, "Cookie: jwt_token_refresh="+cookies[1].Value))
+	Equal(t, 400, recorder.Code)
+}
+
+func TestHandler_RefreshTokens_APIVersion1(t *testing.T) {
+	h := refreshTokenHandler()
+	recorder := httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("POST", "/context/login", "username=bob&password=secret", TypeForm, AcceptJwt))
+	Equal(t, 200, recorder.Code)
+	refreshToken := recorder.Header().Get(refreshTokenHeader)
+	claims, err := tokenAsMap(refreshToken)
+	NoError(t, err)
+	Equal(t, model.TokenTypeRefresh, claims["token_type"])
+
+	// without refresh tokens, no token type is set
+	recorder = call(req("POST", "/context/login", "username=bob&password=secret", TypeForm, AcceptJwt))
+	Equal(t, "", recorder.Header().Get(refreshTokenHeader))
+	claims, err = tokenAsMap(recorder.Body.String())
+	NoError(t, err)
+	Nil(t, claims["token_type"])
+}
+
+func TestHandler_RefreshTokens_Config(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Backends = Options{"simple": {"bob": "secret"}}
+	cfg.JwtRefreshTokenExpiry = -time.Hour
+	_, err := NewHandler(cfg)
+	EqualError(t, err, "The jwt refresh token expiry must not be negative")
+
+	cfg.JwtRefreshTokenExpiry = time.Hour
+	cfg.JwtSlidingExpiry = true
+	cfg.JwtMaxSession = 2 * time.Hour
+	_, err = NewHandler(cfg)
+	EqualError(t, err, "A sliding jwt expiry can not be combined with refresh tokens")
+}
@@ -0,0 +1,123 @@
+package login
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/tarent/loginsrv/model"
+)
+
+var errAPIRequestTimeout = apiError{503, "request_timeout", "Service Unavailable: The login took too long, please try again"}
+
+type requestDeadlineKey struct{}
+
+// requestDeadline is the deadline of the -max-request-duration for one login request.
+// It keeps the phase in flight, to report where the time was spent, if the deadline is hit.
+type requestDeadline struct {
+	deadline time.Time
+
+	mu sync.Mutex
+	// phase is the phase started last
+	phase string
+	// expiredIn is the first phase, which ended after the deadline
+	expiredIn string
+}
+
+// withRequestDeadline bounds the processing of the request by the -max-request-duration.
+// The deadline is passed to the backends, the oauth providers and the other downstream calls by the context.
+func (h *Handler) withRequestDeadline(r *http.Request) (*http.Request, context.CancelFunc) {
+	if h.config.MaxRequestDuration <= 0 {
+		return r, func() {}
+	}
+	d := &requestDeadline{deadline: time.Now().Add(h.config.MaxRequestDuration)}
+	ctx, cancel := context.WithDeadline(r.Context(), d.deadline)
+	return r.WithContext(context.WithValue(ctx, requestDeadlineKey{}, d)), cancel
+}
+
+func requestDeadlineOf(ctx context.Context) *requestDeadline {
+	d, _ := ctx.Value(requestDeadlineKey{}).(*requestDeadline)
+	return d
+}
+
+func (d *requestDeadline) started(phase string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.phase = phase
+}
+
+func (d *requestDeadline) ended(phase string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.expiredIn == "" && !time.Now().Before(d.deadline) {
+		d.expiredIn = phase
+	}
+}
+
+// phaseInFlight returns the phase, which was running at the deadline
+func (d *requestDeadline) phaseInFlight() string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.expiredIn != "" {
+		return d.expiredIn
+	}
+	if d.phase != "" {
+		return d.phase
+	}
+	return "unknown"
+}
+
+// requestTimedOut checks, if the request was aborted by the -max-request-duration.
+// Requests given up by the client, or ended by a deadline of the embedding server, are no timeouts of loginsrv.
+func requestTimedOut(r *http.Request) bool {
+	d := requestDeadlineOf(r.Context())
+	return d != nil && errors.Is(r.Context().Err(), context.DeadlineExceeded) && !time.Now().Before(d.deadline)
+}
+
+// respondRequestTimeout answers requests, which were aborted by the -max-request-duration.
+func (h *Handler) respondRequestTimeout(w http.ResponseWriter, r *http.Request, username string) {
+	h.countRequestTimeout(r, username)
+	w.Header().Set("Retry-After", fmt.Sprintf("%d", int(math.Ceil(h.config.MaxRequestDuration.Seconds()))))
+	if h.wantHTML(r) {
+		writeLoginForm(w,
+			loginFormData{
+				Failure:        true,
+				FailureMessage: errAPIRequestTimeout.message,
+				Config:         h.formConfig(),
+				Language:       formLanguage(r),
+				UserInfo:       model.UserInfo{Sub: username},
+				status:         errAPIRequestTimeout.status,
+			})
+		return
+	}
+	h.respondAPIError(w, r, errAPIRequestTimeout)
+}
+
+// countRequestTimeout counts a request aborted by the -max-request-duration
+// and emits it with the phase in flight as reason
+func (h *Handler) countRequestTimeout(r *http.Request, username string) {
+	atomic.AddInt64(&h.abortedRequests.timeouts, 1)
+	h.emitWithReason(r, EventRequestTimedOut, username, "", requestDeadlineOf(r.Context()).phaseInFlight())
+}
+
+// abortedRequestCounts counts the login requests, which were aborted before the response
+type abortedRequestCounts struct {
+	// timeouts are the requests aborted by the -max-request-duration
+	timeouts int64
+	// clientClosed are the requests given up by the client
+	clientClosed int64
+}
+
+// status returns the counts for the health endpoint, nil if no request was aborted
+func (c *abortedRequestCounts) status() map[string]int64 {
+	timeouts, clientClosed := atomic.LoadInt64(&c.timeouts), atomic.LoadInt64(&c.clientClosed)
+	if timeouts == 0 && clientClosed == 0 {
+		return nil
+	}
+	return map[string]int64{"timeout": timeouts, "client_closed": clientClosed}
+}
@@ -0,0 +1,103 @@
+package login
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	. "github.com/stretchr/testify/assert"
+	"github.com/tarent/loginsrv/model"
+)
+
+// blockingTestBackend answers only, when the request is given up
+type blockingTestBackend struct{}
+
+func (blockingTestBackend) Authenticate(username, password string) (bool, model.UserInfo, error) {
+	return false, model.UserInfo{}, nil
+}
+
+func (blockingTestBackend) AuthenticateWithContext(ctx context.Context, username, password string) (bool, model.UserInfo, error) {
+	<-ctx.Done()
+	return false, model.UserInfo{}, ctx.Err()
+}
+
+func deadlineHandler() *Handler {
+	h := testHandler()
+	h.backends = []Backend{blockingTestBackend{}}
+	h.backendNames = []string{"ldap"}
+	h.config.MaxRequestDuration = 50 * time.Millisecond
+	return h
+}
+
+func TestHandler_MaxRequestDuration(t *testing.T) {
+	h := deadlineHandler()
+	rec := &eventRecorder{}
+	h.Subscribe(Subscriber{Name: "test", Handle: rec.handle})
+
+	start := time.Now()
+	recorder := callHandler(h, req("POST", "/context/login", `{"username": "bob", "password": "secret"}`, TypeJSON, "X-Login-API-Version: 2"))
+	True(t, time.Since(start) < time.Second)
+	Equal(t, 503, recorder.Code)
+	Equal(t, "1", recorder.Header().Get("Retry-After"))
+	p := problem{}
+	NoError(t, json.Unmarshal(recorder.Body.Bytes(), &p))
+	Equal(t, "request_timeout", p.Code)
+
+	Equal(t, []EventType{EventRequestTimedOut}, rec.types())
+	Equal(t, "bob", rec.events[0].Username)
+	Equal(t, "backend:ldap", rec.events[0].Reason)
+
+	recorder = callHandler(h, req("POST", "/context/login", "username=bob&password=secret", TypeForm, AcceptHTML))
+	Equal(t, 503, recorder.Code)
+	Contains(t, recorder.Header().Get("Content-Type"), "text/html")
+
+	// a client giving up is no timeout
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		cancel()
+	}()
+	recorder = callHandler(h, req("POST", "/context/login", "username=bob&password=secret", TypeForm, AcceptJwt).WithContext(ctx))
+	Equal(t, statusClientClosedRequest, recorder.Code)
+	Equal(t, 2, len(rec.types()))
+
+	recorder = callHandler(h, req("GET", "/context/login/health", ""))
+	status := healthStatus{}
+	NoError(t, json.Unmarshal(recorder.Body.Bytes(), &status))
+	Equal(t, map[string]int64{"timeout": 2, "client_closed": 1}, status.AbortedRequests)
+}
+
+func TestHandler_MaxRequestDuration_ServerDeadline(t *testing.T) {
+	// an earlier deadline of the embedding server is no timeout of loginsrv
+	h := deadlineHandler()
+	h.config.MaxRequestDuration = time.Minute
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	recorder := callHandler(h, req("POST", "/context/login", "username=bob&password=secret", TypeForm, AcceptJwt).WithContext(ctx))
+	Equal(t, statusClientClosedRequest, recorder.Code)
+}
+
+func TestRequestDeadline_PhaseInFlight(t *testing.T) {
+	d := &requestDeadline{deadline: time.Now().Add(time.Hour)}
+	Equal(t, "unknown", d.phaseInFlight())
+	d.started("parse")
+	d.ended("parse")
+	d.started("backend:ldap")
+	Equal(t, "backend:ldap", d.phaseInFlight())
+
+	// the first phase ending after the deadline was in flight
+	d.deadline = time.Now()
+	d.started("sign")
+	d.ended("sign")
+	d.ended("backend:ldap")
+	Equal(t, "sign", d.phaseInFlight())
+}
+
+func TestHandler_MaxRequestDuration_Config(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Backends = Options{"simple": {"bob": "secret"}}
+	cfg.MaxRequestDuration = -time.Second
+	_, err := NewHandler(cfg)
+	EqualError(t, err, "The max request duration must not be negative")
+}
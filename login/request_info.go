@@ -0,0 +1,103 @@
+package login
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/tarent/loginsrv/logging"
+)
+
+type requestInfoKey struct{}
+
+// RequestInfo holds metadata of the originating login request,
+// which backends may pass on to the systems they call.
+type RequestInfo struct {
+	// ClientIP is the ip of the client, resolved over the trusted proxies
+	ClientIP string
+
+	// CorrelationID is the correlation id of the request
+	CorrelationID string
+}
+
+// WithRequestInfo returns a copy of the context, carrying the request info.
+func WithRequestInfo(ctx context.Context, info RequestInfo) context.Context {
+	return context.WithValue(ctx, requestInfoKey{}, info)
+}
+
+// RequestInfoFromContext returns the request info of the login request,
+// if the context carries one.
+func RequestInfoFromContext(ctx context.Context) (RequestInfo, bool) {
+	info, ok := ctx.Value(requestInfoKey{}).(RequestInfo)
+	return info, ok
+}
+
+func (h *Handler) requestInfo(r *http.Request) RequestInfo {
+	return RequestInfo{
+		ClientIP:      clientIP(r, h.trustedProxies),
+		CorrelationID: logging.GetCorrelationId(r.Header),
+	}
+}
+
+// clientIP returns the ip of the client. The X-Forwarded-For header is only taken into account,
+// if the request comes from a trusted proxy. In that case the ip in front of the last trusted proxy is used.
+func clientIP(r *http.Request, trustedProxies []*net.IPNet) string {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		ip = r.RemoteAddr
+	}
+
+	forwardedFor := strings.Split(r.Header.Get("X-Forwarded-For"), ",")
+	for i := len(forwardedFor) - 1; i >= 0 && isTrustedProxy(ip, trustedProxies); i-- {
+		hop := strings.TrimSpace(forwardedFor[i])
+		if hop == "" {
+			break
+		}
+		ip = hop
+	}
+	return ip
+}
+
+func isTrustedProxy(ip string, trustedProxies []*net.IPNet) bool {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return false
+	}
+	for _, n := range trustedProxies {
+		if n.Contains(parsed) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseCIDRList parses a comma separated list of networks in CIDR notation.
+// Single ips are accepted as well.
+func parseCIDRList(list string) ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+	for _, entry := range strings.Split(list, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if !strings.Contains(entry, "/") {
+			if strings.Contains(entry, ":") {
+				entry += "/128"
+			} else {
+				entry += "/32"
+			}
+		}
+		_, n, err := net.ParseCIDR(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid network %q: %v", entry, err)
+		}
+		// ipv4 mapped ipv6 networks match the ipv4 clients
+		if ones, bits := n.Mask.Size(); n.IP.To4() != nil && bits == 8*net.IPv6len && ones >= 96 {
+			n = &net.IPNet{IP: n.IP.To4(), Mask: net.CIDRMask(ones-96, 8*net.IPv4len)}
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
@@ -0,0 +1,57 @@
+package login
+
+import (
+	"context"
+	. "github.com/stretchr/testify/assert"
+	"net/http"
+	"testing"
+)
+
+func TestRequestInfo_Context(t *testing.T) {
+	_, ok := RequestInfoFromContext(context.Background())
+	False(t, ok)
+
+	ctx := WithRequestInfo(context.Background(), RequestInfo{ClientIP: "1.2.3.4", CorrelationID: "abc"})
+	info, ok := RequestInfoFromContext(ctx)
+	True(t, ok)
+	Equal(t, RequestInfo{ClientIP: "1.2.3.4", CorrelationID: "abc"}, info)
+}
+
+func TestRequestInfo_ClientIP(t *testing.T) {
+	trusted, err := parseCIDRList("10.0.0.0/8, 192.168.1.1")
+	NoError(t, err)
+
+	testCases := []struct {
+		remoteAddr   string
+		forwardedFor string
+		expected     string
+	}{
+		{"1.2.3.4:1234", "", "1.2.3.4"},
+		// untrusted peer can not spoof the ip
+		{"1.2.3.4:1234", "5.6.7.8", "1.2.3.4"},
+		{"10.0.0.1:1234", "5.6.7.8", "5.6.7.8"},
+		// chain of trusted proxies
+		{"10.0.0.1:1234", "5.6.7.8, 6.6.6.6, 192.168.1.1", "6.6.6.6"},
+		{"192.168.1.1:1234", "", "192.168.1.1"},
+	}
+	for _, test := range testCases {
+		r := &http.Request{RemoteAddr: test.remoteAddr, Header: http.Header{}}
+		if test.forwardedFor != "" {
+			r.Header.Set("X-Forwarded-For", test.forwardedFor)
+		}
+		Equal(t, test.expected, clientIP(r, trusted), test.remoteAddr+" "+test.forwardedFor)
+	}
+}
+
+func TestRequestInfo_ParseCIDRList(t *testing.T) {
+	nets, err := parseCIDRList("")
+	NoError(t, err)
+	Equal(t, 0, len(nets))
+
+	nets, err = parseCIDRList("10.0.0.0/8,::1")
+	NoError(t, err)
+	Equal(t, 2, len(nets))
+
+	_, err = parseCIDRList("10.0.0.0/99")
+	Error(t, err)
+}
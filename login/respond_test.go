@@ -0,0 +1,64 @@
+package login
+
+import (
+	. "github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+func TestRespond_ContentTypes(t *testing.T) {
+	h := testHandler()
+	r := req("GET", "/context/login", "")
+	rHTML := req("GET", "/context/login", "", AcceptHTML)
+
+	testCases := []struct {
+		name        string
+		respond     func(w http.ResponseWriter)
+		code        int
+		contentType string
+		body        string
+	}{
+		{"error", func(w http.ResponseWriter) { h.respondError(w, r) }, 500, contentTypePlain, "Internal Server Error"},
+		{"error html", func(w http.ResponseWriter) { h.respondError(w, rHTML) }, 500, contentTypeHTML, "Internal Error"},
+		{"bad request", func(w http.ResponseWriter) { h.respondBadRequest(w, r) }, 400, contentTypePlain, "Bad Request"},
+		{"not found", func(w http.ResponseWriter) { h.respondNotFound(w, r) }, 404, contentTypePlain, "Not Found"},
+		{"max refreshes", func(w http.ResponseWriter) { h.respondMaxRefreshesReached(w, r) }, 403, contentTypePlain, "Max JWT refreshes reached"},
+		{"not refreshable", func(w http.ResponseWriter) { h.respondNotRefreshable(w, r) }, 403, contentTypePlain, "not refreshable"},
+		{"auth failure", func(w http.ResponseWriter) { h.respondAuthFailure(w, r) }, 403, contentTypePlain, "Wrong credentials"},
+		{"auth failure html", func(w http.ResponseWriter) { h.respondAuthFailure(w, rHTML) }, 403, contentTypeHTML, `<html lang="en">`},
+		{"maintenance", func(w http.ResponseWriter) { h.respondMaintenance(w, r) }, 503, contentTypePlain, h.config.MaintenanceMessage},
+		{"maintenance html", func(w http.ResponseWriter) { h.respondMaintenance(w, rHTML) }, 503, contentTypeHTML, `<html lang="en">`},
+		{"health", func(w http.ResponseWriter) { h.respondHealth(w, r) }, 200, contentTypeJSON, `"status":"ok"`},
+		{"login form", func(w http.ResponseWriter) { writeLoginForm(w, loginFormData{Config: h.config}) }, 200, contentTypeHTML, `<html lang="en">`},
+	}
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			recorder := httptest.NewRecorder()
+			test.respond(recorder)
+			Equal(t, test.code, recorder.Code)
+			Equal(t, test.contentType, recorder.Header().Get("Content-Type"))
+			Contains(t, recorder.Body.String(), test.body)
+		})
+	}
+}
+
+func TestRespond_TemplateExecutionFailure(t *testing.T) {
+	f, err := ioutil.TempFile("", "loginsrv_template")
+	NoError(t, err)
+	defer os.Remove(f.Name())
+	// the template starts writing output, before it fails on the unknown field
+	f.WriteString(`<html><body>{{ .Config.LoginPath }}{{ .NoSuchField }}</body></html>`)
+	f.Close()
+
+	cfg := DefaultConfig()
+	cfg.Template = f.Name()
+
+	recorder := httptest.NewRecorder()
+	writeLoginForm(recorder, loginFormData{Config: cfg, Failure: true, status: 403})
+	Equal(t, 500, recorder.Code)
+	Equal(t, contentTypePlain, recorder.Header().Get("Content-Type"))
+	Equal(t, "Internal Server Error", recorder.Body.String())
+}
@@ -0,0 +1,143 @@
+package login
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"path"
+	"time"
+
+	"github.com/tarent/loginsrv/logging"
+	"github.com/tarent/loginsrv/model"
+)
+
+const (
+	revocationNamespace        = "revocations"
+	revocationNamespaceVersion = 1
+)
+
+var (
+	errAPIMissingJTI    = apiError{400, "missing_jti", "Bad Request: The jti of the token to revoke is missing"}
+	errAPINotTokenOwner = apiError{403, "not_token_owner", "Forbidden: The token does not belong to the user"}
+)
+
+// RevocationStore keeps the ids (jti) of the revoked tokens.
+// A revocation is only needed until the token expires, so a store may forget it after that time.
+type RevocationStore interface {
+	// Revoke marks the token id as revoked until the given time
+	Revoke(jti string, until time.Time) error
+	// IsRevoked checks, if the token id is revoked
+	IsRevoked(jti string) (bool, error)
+}
+
+// WithRevocationStore keeps the revoked tokens in the store instead of the memory of the handler,
+// e.g. to share them between instances.
+func WithRevocationStore(store RevocationStore) HandlerOption {
+	return func(rt *handlerRuntime) {
+		rt.revocations = store
+	}
+}
+
+// storeRevocations keeps the revocations in the Store of the handler, in memory or in the -store-file.
+// They expire with the tokens and are deleted by the sweeper of the store.
+type storeRevocations struct {
+	store Store
+}
+
+func newStoreRevocations(store Store) *storeRevocations {
+	return &storeRevocations{store: store}
+}
+
+func (s *storeRevocations) Revoke(jti string, until time.Time) error {
+	return s.store.Set(revocationNamespace, jti, "", time.Until(until))
+}
+
+func (s *storeRevocations) IsRevoked(jti string) (bool, error) {
+	_, revoked, err := s.store.Get(revocationNamespace, jti)
+	return revoked, err
+}
+
+// newTokenID returns a random token id
+func newTokenID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// maxTokenLifetime is the longest time, a token of this configuration is accepted,
+// including the origin overrides, the refresh tokens, the leeway for the clock skew and the grace period of refreshes.
+func (h *Handler) maxTokenLifetime() time.Duration {
+	lifetime := h.config.JwtExpiry
+	for _, s := range h.originOverrides {
+		if s.JwtExpiry > lifetime {
+			lifetime = s.JwtExpiry
+		}
+	}
+	if h.config.JwtRefreshTokenExpiry > lifetime {
+		lifetime = h.config.JwtRefreshTokenExpiry
+	}
+	return lifetime + h.config.JwtLeeway + h.config.JwtRefreshGrace
+}
+
+func (h *Handler) isRevokePath(r *http.Request) bool {
+	return r.URL.Path == path.Join(h.config.LoginPath, "revoke")
+}
+
+// respondRevoke revokes the token with the jti of the query.
+// The request has to be authenticated by a valid token. The jti is readable in every token,
+// so users may only revoke the token of the request and the tokens issued to themselves by the session registry.
+// Users of the -admin-group may revoke every token.
+func (h *Handler) respondRevoke(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "DELETE" {
+		h.respondBadRequest(w, r)
+		return
+	}
+	userInfo, failure := h.verifyToken(r, "")
+	if failure != "" {
+		h.respondAPIError(w, r, errAPIUnauthenticated)
+		return
+	}
+	jti := r.URL.Query().Get("jti")
+	if jti == "" {
+		h.respondAPIError(w, r, errAPIMissingJTI)
+		return
+	}
+	if !h.mayRevoke(userInfo, jti) {
+		logging.Application(r.Header).
+			WithField("username", userInfo.Sub).
+			WithField("jti", jti).
+			Warn("rejected the revocation of a token of another user")
+		h.respondAPIError(w, r, errAPINotTokenOwner)
+		return
+	}
+	if err := h.revocations.Revoke(jti, time.Now().Add(h.maxTokenLifetime())); err != nil {
+		logging.Application(r.Header).WithError(err).Error("could not revoke token")
+		h.respondError(w, r)
+		return
+	}
+	logging.Application(r.Header).
+		WithField("username", userInfo.Sub).
+		WithField("jti", jti).
+		Info("revoked token")
+	w.WriteHeader(204)
+}
+
+// mayRevoke checks, if the user may revoke the token: it is the token of the request,
+// a token issued to the user or the user is an admin
+func (h *Handler) mayRevoke(userInfo model.UserInfo, jti string) bool {
+	if jti == userInfo.ID {
+		return true
+	}
+	if h.config.AdminGroup != "" && (requirement{"groups", h.config.AdminGroup}).metBy(userInfo) {
+		return true
+	}
+	value, exist, err := h.state.Get(sessionsNamespace, jti)
+	if err != nil || !exist {
+		return false
+	}
+	var s storedSession
+	return json.Unmarshal([]byte(value), &s) == nil && s.Sub == userInfo.Sub
+}
@@ -0,0 +1,52 @@
+package login
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	. "github.com/stretchr/testify/assert"
+)
+
+// testRevocationStore checks the behaviour every RevocationStore has to provide.
+// A store may forget a revocation after its time, so the expiry is not checked.
+func testRevocationStore(t *testing.T, store RevocationStore) {
+	until := time.Now().Add(time.Hour)
+
+	revoked, err := store.IsRevoked("unknown")
+	NoError(t, err)
+	False(t, revoked)
+
+	NoError(t, store.Revoke("abc", until))
+	revoked, err = store.IsRevoked("abc")
+	NoError(t, err)
+	True(t, revoked)
+	revoked, _ = store.IsRevoked("abcd")
+	False(t, revoked, "ids are no prefixes")
+
+	// revoking twice is no error
+	NoError(t, store.Revoke("abc", until.Add(time.Hour)))
+	revoked, _ = store.IsRevoked("abc")
+	True(t, revoked)
+
+	// concurrent revocations of different tokens are all kept
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			NoError(t, store.Revoke(fmt.Sprintf("concurrent-%v", i), until))
+		}(i)
+	}
+	wg.Wait()
+	for i := 0; i < 20; i++ {
+		revoked, err := store.IsRevoked(fmt.Sprintf("concurrent-%v", i))
+		NoError(t, err)
+		True(t, revoked, i)
+	}
+}
+
+func TestStoreRevocations_Conformance(t *testing.T) {
+	testRevocationStore(t, newStoreRevocations(memoryStore{newTTLStore()}))
+}
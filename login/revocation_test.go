@@ -0,0 +1,132 @@
+package login
+
+import (
+	"errors"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	. "github.com/stretchr/testify/assert"
+	"github.com/tarent/loginsrv/model"
+)
+
+func TestHandler_Revoke(t *testing.T) {
+	h := testHandler()
+	serve := func(r ...string) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		h.ServeHTTP(recorder, req(r[0], r[1], "", r[2:]...))
+		return recorder
+	}
+
+	// every login gets a new token id
+	login := func() string {
+		recorder := httptest.NewRecorder()
+		h.ServeHTTP(recorder, req("POST", "/context/login", "username=bob&password=secret", "Content-Type: application/x-www-form-urlencoded", "Accept: application/jwt"))
+		Equal(t, 200, recorder.Code)
+		return recorder.Body.String()
+	}
+	stolen, other := login(), login()
+	stolenInfo, valid := h.GetToken(req("GET", "/", ""), stolen)
+	True(t, valid)
+	otherInfo, valid := h.GetToken(req("GET", "/", ""), other)
+	True(t, valid)
+	NotEqual(t, "", stolenInfo.ID)
+	NotEqual(t, stolenInfo.ID, otherInfo.ID)
+
+	cookie := "Cookie: " + h.config.CookieName + "=" + other
+	Equal(t, 401, serve("DELETE", "/context/login/revoke?jti="+stolenInfo.ID).Code)
+	Equal(t, 400, serve("DELETE", "/context/login/revoke", cookie).Code)
+	Equal(t, 400, serve("GET", "/context/login/revoke?jti="+stolenInfo.ID, cookie).Code)
+	Equal(t, 204, serve("DELETE", "/context/login/revoke?jti="+stolenInfo.ID, cookie).Code)
+
+	_, failure := h.VerifyToken(req("GET", "/", ""), stolen)
+	Equal(t, TokenRevoked, failure)
+	_, valid = h.GetToken(req("GET", "/", ""), other)
+	True(t, valid)
+
+	// the revoked token can not be refreshed
+	Equal(t, 400, serve("POST", "/context/login", "Accept: application/jwt", "Cookie: "+h.config.CookieName+"="+stolen).Code)
+	Equal(t, 200, serve("POST", "/context/login", "Accept: application/jwt", cookie).Code)
+}
+
+func TestHandler_Revoke_OtherUser(t *testing.T) {
+	h := testHandler()
+	h.backends = []Backend{NewSimpleBackend(map[string]string{"bob": "secret", "marvin": "secret"})}
+	login := func(username string) (string, model.UserInfo) {
+		recorder := httptest.NewRecorder()
+		h.ServeHTTP(recorder, req("POST", "/context/login", "username="+username+"&password=secret", TypeForm, AcceptJwt))
+		Equal(t, 200, recorder.Code)
+		userInfo, valid := h.GetToken(req("GET", "/", ""), recorder.Body.String())
+		True(t, valid)
+		return recorder.Body.String(), userInfo
+	}
+	revoke := func(token, jti string) int {
+		recorder := httptest.NewRecorder()
+		h.ServeHTTP(recorder, req("DELETE", "/context/login/revoke?jti="+jti, "", "Authorization: Bearer "+token))
+		return recorder.Code
+	}
+	bobToken, bob := login("bob")
+	marvinToken, marvin := login("marvin")
+
+	// the jti of other users is readable in their tokens, but can't be revoked
+	Equal(t, 403, revoke(marvinToken, bob.ID))
+	Equal(t, 403, revoke(marvinToken, "unknown"))
+	_, valid := h.GetToken(req("GET", "/", ""), bobToken)
+	True(t, valid)
+
+	// the own token of the request
+	Equal(t, 204, revoke(marvinToken, marvin.ID))
+
+	// admins revoke every token
+	h.config.AdminGroup = "admins"
+	adminToken, err := h.createToken(model.UserInfo{Sub: "alice", Groups: []string{"admins"}, Expiry: time.Now().Add(time.Minute).Unix()})
+	NoError(t, err)
+	Equal(t, 204, revoke(adminToken, bob.ID))
+	_, failure := h.VerifyToken(req("GET", "/", ""), bobToken)
+	Equal(t, TokenRevoked, failure)
+}
+
+func TestStoreRevocations(t *testing.T) {
+	store := newTTLStore()
+	now := time.Now()
+	store.now = func() time.Time { return now }
+	revocations := newStoreRevocations(memoryStore{store})
+
+	NoError(t, revocations.Revoke("abc", now.Add(time.Hour)))
+	revoked, err := revocations.IsRevoked("abc")
+	NoError(t, err)
+	True(t, revoked)
+	revoked, _ = revocations.IsRevoked("def")
+	False(t, revoked)
+
+	// the revocations are garbage collected, when the tokens expired
+	now = now.Add(time.Hour)
+	store.sweep()
+	revoked, _ = revocations.IsRevoked("abc")
+	False(t, revoked)
+}
+
+type failingRevocations struct{}
+
+func (failingRevocations) Revoke(jti string, until time.Time) error { return errors.New("unavailable") }
+func (failingRevocations) IsRevoked(jti string) (bool, error) {
+	return false, errors.New("unavailable")
+}
+
+func TestHandler_Revoke_StoreUnavailable(t *testing.T) {
+	h := testHandler()
+	h.revocations = failingRevocations{}
+	token, err := h.createToken(model.UserInfo{Sub: "bob", ID: "abc", Expiry: time.Now().Add(time.Minute).Unix()})
+	NoError(t, err)
+
+	_, failure := h.VerifyToken(req("GET", "/", ""), token)
+	Equal(t, TokenInvalid, failure)
+}
+
+func TestHandler_MaxTokenLifetime(t *testing.T) {
+	h := testHandler()
+	h.config.JwtExpiry = time.Hour
+	h.config.JwtLeeway = time.Minute
+	h.originOverrides = map[string]sessionSettings{"github": {JwtExpiry: 2 * time.Hour}}
+	Equal(t, 2*time.Hour+time.Minute, h.maxTokenLifetime())
+}
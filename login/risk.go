@@ -0,0 +1,168 @@
+package login
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/tarent/loginsrv/logging"
+	"github.com/tarent/loginsrv/model"
+)
+
+const (
+	lastLoginNamespace        = "last_logins"
+	lastLoginNamespaceVersion = 1
+)
+
+// lastLoginRetention is the time, the previous login of a user is kept for the risk assessment
+var lastLoginRetention = 90 * 24 * time.Hour
+
+// RiskDecision is the outcome of the risk assessment of a login
+type RiskDecision string
+
+// The risk decisions
+const (
+	// RiskAllow lets the login succeed
+	RiskAllow RiskDecision = "allow"
+	// RiskStepUp requires an additional verification of the user, so the password login is rejected
+	RiskStepUp RiskDecision = "step_up"
+	// RiskDeny rejects the login
+	RiskDeny RiskDecision = "deny"
+)
+
+var (
+	errAPIStepUpRequired = apiError{403, "step_up_required", "Additional verification required"}
+	errAPILoginDenied    = apiError{403, "login_denied", "Login denied"}
+)
+
+// LoginAttempt is a successful authentication, which is assessed before the token is issued
+type LoginAttempt struct {
+	Username  string
+	Origin    string
+	ClientIP  string
+	UserAgent string
+	Time      time.Time
+
+	// Previous is the last allowed login of the user, nil for the first one
+	Previous *PreviousLogin
+}
+
+// PreviousLogin is the metadata of the last allowed login of a user
+type PreviousLogin struct {
+	ClientIP  string    `json:"client_ip"`
+	UserAgent string    `json:"user_agent,omitempty"`
+	Time      time.Time `json:"time"`
+}
+
+// RiskAssessment is the decision of a risk assessor with the reason for the audit log
+type RiskAssessment struct {
+	Decision RiskDecision
+	Reason   string
+}
+
+// RiskAssessor assesses the risk of the successful authentications, e.g. to detect stolen passwords.
+// Errors of the assessor are logged and the login is allowed.
+type RiskAssessor interface {
+	Assess(ctx context.Context, attempt LoginAttempt) (RiskAssessment, error)
+}
+
+// WithRiskAssessor assesses every successful login with the assessor, before the token is issued.
+// The previous logins of the users are kept in the store of the handler.
+// Without an assessor, the logins are not assessed and nothing is recorded.
+func WithRiskAssessor(assessor RiskAssessor) HandlerOption {
+	return func(rt *handlerRuntime) {
+		rt.store.registerNamespace(lastLoginNamespace, lastLoginNamespaceVersion)
+		rt.risk = assessor
+	}
+}
+
+// lastLoginKey is the hash of the username in the store, keyed by a key derived from the jwt secret
+func (h *Handler) lastLoginKey(username string) string {
+	mac := hmac.New(sha256.New, backendKey(h.config.JwtSecret, lastLoginNamespace))
+	mac.Write([]byte(username))
+	return hex.EncodeToString(mac.Sum(nil)[:16])
+}
+
+func (h *Handler) previousLogin(username string) *PreviousLogin {
+	value, exist := h.store.get(lastLoginNamespace, h.lastLoginKey(username))
+	if !exist {
+		return nil
+	}
+	previous := &PreviousLogin{}
+	if err := json.Unmarshal([]byte(value), previous); err != nil {
+		return nil
+	}
+	return previous
+}
+
+func (h *Handler) recordLogin(attempt LoginAttempt) {
+	value, err := json.Marshal(PreviousLogin{ClientIP: attempt.ClientIP, UserAgent: attempt.UserAgent, Time: attempt.Time})
+	if err != nil {
+		return
+	}
+	h.store.set(lastLoginNamespace, h.lastLoginKey(attempt.Username), string(value), lastLoginRetention)
+}
+
+// assessRisk assesses the login with the configured risk assessor.
+// Only allowed logins become the previous login of the user,
+// so a rejected attacker does not change the reference of the next assessment.
+// The rejections are emitted with the reason, unless emit is false for duplicate submissions.
+func (h *Handler) assessRisk(r *http.Request, username string, userInfo model.UserInfo, emit bool) RiskAssessment {
+	attempt := LoginAttempt{
+		Username:  username,
+		Origin:    userInfo.Origin,
+		ClientIP:  clientIP(r, h.trustedProxies),
+		UserAgent: r.UserAgent(),
+		Time:      time.Now(),
+		Previous:  h.previousLogin(username),
+	}
+	assessment, err := h.risk.Assess(r.Context(), attempt)
+	if err != nil {
+		logging.Application(r.Header).
+			WithField("username", username).
+			WithError(err).
+			Error("could not assess the risk of the login, allowing it")
+		assessment = RiskAssessment{Decision: RiskAllow}
+	}
+	eventType := EventLoginDenied
+	switch assessment.Decision {
+	case RiskAllow:
+		h.recordLogin(attempt)
+		return assessment
+	case RiskStepUp:
+		eventType = EventLoginStepUp
+	default:
+		// unknown decisions are denied
+		assessment.Decision = RiskDeny
+	}
+	if emit {
+		h.emitWithReason(r, eventType, username, userInfo.Origin, assessment.Reason)
+	}
+	return assessment
+}
+
+// respondRiskDecision rejects a login, which requires a step up or was denied.
+// The reason is only audited, not returned to the client.
+func (h *Handler) respondRiskDecision(w http.ResponseWriter, r *http.Request, username string, assessment RiskAssessment) {
+	e := errAPILoginDenied
+	if assessment.Decision == RiskStepUp {
+		e = errAPIStepUpRequired
+	}
+	if h.wantHTML(r) {
+		writeLoginForm(w,
+			loginFormData{
+				Failure:        true,
+				FailureMessage: e.message,
+				Config:         h.formConfig(),
+				Language:       formLanguage(r),
+				UserInfo:       model.UserInfo{Sub: username},
+				status:         e.status,
+			})
+		return
+	}
+	h.respondAPIError(w, r, e)
+}
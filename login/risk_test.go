@@ -0,0 +1,164 @@
+package login
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	. "github.com/stretchr/testify/assert"
+)
+
+type riskAssessorFunc func(ctx context.Context, attempt LoginAttempt) (RiskAssessment, error)
+
+func (f riskAssessorFunc) Assess(ctx context.Context, attempt LoginAttempt) (RiskAssessment, error) {
+	return f(ctx, attempt)
+}
+
+type testGeoIP map[string]GeoLocation
+
+func (g testGeoIP) Lookup(ctx context.Context, ip string) (GeoLocation, error) {
+	if ip == "10.0.0.99" {
+		return GeoLocation{}, errors.New("lookup failed")
+	}
+	return g[ip], nil
+}
+
+var (
+	berlin  = GeoLocation{Country: "DE", ASN: "AS3320", HasCoordinates: true, Latitude: 52.52, Longitude: 13.40}
+	hamburg = GeoLocation{Country: "DE", ASN: "AS3209", HasCoordinates: true, Latitude: 53.55, Longitude: 9.99}
+	sydney  = GeoLocation{Country: "AU", ASN: "AS1221", HasCoordinates: true, Latitude: -33.87, Longitude: 151.21}
+	vienna  = GeoLocation{Country: "AT", ASN: "AS8447", HasCoordinates: true, Latitude: 48.21, Longitude: 16.37}
+)
+
+func riskTestHandler(assessor RiskAssessor) *Handler {
+	h := testHandler()
+	WithRiskAssessor(assessor)(h.handlerRuntime)
+	return h
+}
+
+func loginFrom(h *Handler, ip string) *httptest.ResponseRecorder {
+	r := req("POST", "/context/login", "username=bob&password=secret", TypeForm, AcceptJwt, "User-Agent: test-agent")
+	r.RemoteAddr = ip + ":4711"
+	recorder := httptest.NewRecorder()
+	h.ServeHTTP(recorder, r)
+	return recorder
+}
+
+func TestHandler_RiskAssessment(t *testing.T) {
+	var attempts []LoginAttempt
+	decision := RiskAllow
+	h := riskTestHandler(riskAssessorFunc(func(ctx context.Context, attempt LoginAttempt) (RiskAssessment, error) {
+		attempts = append(attempts, attempt)
+		return RiskAssessment{Decision: decision, Reason: "test reason"}, nil
+	}))
+	rec := &eventRecorder{}
+	h.Subscribe(Subscriber{Name: "test", Handle: rec.handle})
+
+	Equal(t, 200, loginFrom(h, "10.0.0.1").Code)
+	Equal(t, "bob", attempts[0].Username)
+	Equal(t, "10.0.0.1", attempts[0].ClientIP)
+	Equal(t, "test-agent", attempts[0].UserAgent)
+	Nil(t, attempts[0].Previous)
+
+	// the previous login is passed to the assessor
+	decision = RiskStepUp
+	recorder := loginFrom(h, "10.0.0.2")
+	Equal(t, 403, recorder.Code)
+	Equal(t, "Additional verification required", recorder.Body.String())
+	Equal(t, "10.0.0.1", attempts[1].Previous.ClientIP)
+	Equal(t, "test-agent", attempts[1].Previous.UserAgent)
+
+	// rejected logins do not become the previous login
+	decision = RiskDeny
+	recorder = loginFrom(h, "10.0.0.3")
+	Equal(t, 403, recorder.Code)
+	Equal(t, "Login denied", recorder.Body.String())
+	Equal(t, "10.0.0.1", attempts[2].Previous.ClientIP)
+
+	Equal(t, []EventType{EventLoginSucceeded, EventLoginStepUp, EventLoginDenied}, rec.types())
+	Equal(t, "test reason", rec.events[1].Reason)
+	Equal(t, "10.0.0.2", rec.events[1].ClientIP)
+}
+
+func TestHandler_RiskAssessment_Error(t *testing.T) {
+	h := riskTestHandler(riskAssessorFunc(func(ctx context.Context, attempt LoginAttempt) (RiskAssessment, error) {
+		return RiskAssessment{}, errors.New("assessor unavailable")
+	}))
+	Equal(t, 200, loginFrom(h, "10.0.0.1").Code)
+}
+
+func TestHandler_RiskAssessment_Disabled(t *testing.T) {
+	h := testHandler()
+	Equal(t, 200, loginFrom(h, "10.0.0.1").Code)
+	_, exist := h.store.get(lastLoginNamespace, h.lastLoginKey("bob"))
+	False(t, exist)
+}
+
+func TestHandler_ImpossibleTravel(t *testing.T) {
+	h := riskTestHandler(NewImpossibleTravel(testGeoIP{"10.0.0.1": berlin, "10.0.0.2": sydney}))
+	rec := &eventRecorder{}
+	h.Subscribe(Subscriber{Name: "test", Handle: rec.handle})
+
+	Equal(t, 200, loginFrom(h, "10.0.0.1").Code)
+	Equal(t, 403, loginFrom(h, "10.0.0.2").Code)
+	Equal(t, EventLoginDenied, rec.events[1].Type)
+	Contains(t, rec.events[1].Reason, "impossible travel of 16")
+}
+
+func TestImpossibleTravel_Assess(t *testing.T) {
+	travel := NewImpossibleTravel(testGeoIP{
+		"10.0.0.1": berlin,
+		"10.0.0.2": hamburg,
+		"10.0.0.3": sydney,
+		"10.0.0.4": vienna,
+		"10.0.0.5": {Country: "DE"},
+	})
+	now := time.Now()
+	assess := func(previousIP string, elapsed time.Duration, ip string) RiskAssessment {
+		attempt := LoginAttempt{Username: "bob", ClientIP: ip, Time: now}
+		if previousIP != "" {
+			attempt.Previous = &PreviousLogin{ClientIP: previousIP, Time: now.Add(-elapsed)}
+		}
+		a, err := travel.Assess(context.Background(), attempt)
+		NoError(t, err)
+		return a
+	}
+
+	Equal(t, RiskAllow, assess("", 0, "10.0.0.1").Decision)
+	Equal(t, RiskAllow, assess("10.0.0.1", time.Minute, "10.0.0.1").Decision)
+
+	// berlin to sydney in 10 hours is too fast, in a day plausible, but a new country
+	Equal(t, RiskDeny, assess("10.0.0.1", 10*time.Hour, "10.0.0.3").Decision)
+	a := assess("10.0.0.1", 24*time.Hour, "10.0.0.3")
+	Equal(t, RiskStepUp, a.Decision)
+	Equal(t, "login from the new country AU, previous login from DE", a.Reason)
+
+	// berlin to vienna is a new country, even within an hour, as the distance is plausible by plane
+	Equal(t, RiskStepUp, assess("10.0.0.1", time.Hour, "10.0.0.4").Decision)
+
+	// berlin to hamburg within an hour is plausible, but a new network
+	a = assess("10.0.0.1", time.Hour, "10.0.0.2")
+	Equal(t, RiskStepUp, a.Decision)
+	Equal(t, "login from the new network AS3209, previous login from AS3320", a.Reason)
+
+	// without coordinates and network, only the country is compared
+	Equal(t, RiskAllow, assess("10.0.0.1", time.Minute, "10.0.0.5").Decision)
+
+	// unknown locations are not flagged
+	Equal(t, RiskAllow, assess("10.0.0.1", time.Minute, "10.0.0.6").Decision)
+
+	_, err := travel.Assess(context.Background(), LoginAttempt{ClientIP: "10.0.0.99", Previous: &PreviousLogin{ClientIP: "10.0.0.1"}})
+	Error(t, err)
+
+	// the default lookup knows no locations
+	a, err = NewImpossibleTravel(nil).Assess(context.Background(), LoginAttempt{ClientIP: "10.0.0.3", Time: now, Previous: &PreviousLogin{ClientIP: "10.0.0.1", Time: now}})
+	NoError(t, err)
+	Equal(t, RiskAllow, a.Decision)
+}
+
+func TestGreatCircleDistance(t *testing.T) {
+	InDelta(t, 255, greatCircleDistance(berlin, hamburg), 5)
+	InDelta(t, 16090, greatCircleDistance(berlin, sydney), 50)
+}
@@ -0,0 +1,177 @@
+package login
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/tarent/loginsrv/logging"
+	"github.com/tarent/loginsrv/model"
+)
+
+// the interval for repeating the warning about the active rollover
+var rolloverReportInterval = 10 * time.Minute
+
+// the response header for the legacy token in version 1 of the json api
+const legacyTokenHeader = "X-Login-Legacy-Token"
+
+// rollover is the transition from a legacy hs512 secret to a new key or algorithm.
+// Within the window, a second token signed with the legacy secret is issued
+// and tokens of the legacy secret are still accepted.
+type rollover struct {
+	legacy     hmacSigner
+	start      time.Time
+	end        time.Time
+	cookieName string
+	cookie     bool
+	field      bool
+
+	legacyVerifications int64
+	legacyIssued        int64
+}
+
+// newRollover returns the rollover for the configuration, or nil if no legacy secret is configured.
+func newRollover(config *Config) (*rollover, error) {
+	if config.JwtLegacySecret == "" {
+		return nil, nil
+	}
+	if config.JwtLegacySecret == config.JwtSecret && config.JwtKMSKey == "" && config.JwtPrivateKey == "" {
+		return nil, errors.New("the jwt legacy secret has to differ from the jwt secret")
+	}
+	if config.JwtRolloverStart == "" || config.JwtRolloverEnd == "" {
+		return nil, errors.New("a jwt legacy secret requires an explicit -jwt-rollover-start and -jwt-rollover-end")
+	}
+
+	r := &rollover{
+		legacy:     hmacSigner{secret: []byte(config.JwtLegacySecret)},
+		cookieName: config.JwtLegacyCookieName,
+	}
+	var err error
+	if r.start, err = time.Parse(time.RFC3339, config.JwtRolloverStart); err != nil {
+		return nil, fmt.Errorf("invalid jwt rollover start: %v", err)
+	}
+	if r.end, err = time.Parse(time.RFC3339, config.JwtRolloverEnd); err != nil {
+		return nil, fmt.Errorf("invalid jwt rollover end: %v", err)
+	}
+	if !r.end.After(r.start) {
+		return nil, errors.New("the jwt rollover end has to be after its start")
+	}
+
+	for _, output := range strings.Split(config.JwtLegacyOutput, ",") {
+		switch strings.TrimSpace(output) {
+		case "cookie":
+			r.cookie = true
+		case "field":
+			r.field = true
+		default:
+			return nil, fmt.Errorf("invalid jwt legacy output %q, allowed are cookie and field", output)
+		}
+	}
+	if r.cookie && r.cookieName == "" {
+		return nil, errors.New("missing jwt legacy cookie name")
+	}
+	return r, nil
+}
+
+// active returns true within the rollover window
+func (r *rollover) active(now time.Time) bool {
+	return r != nil && !now.Before(r.start) && now.Before(r.end)
+}
+
+// parseLegacyToken verifies the token with the legacy secret. The claims are read by the claim map, like the ones of the other tokens.
+func (r *rollover) parseLegacyToken(rtoken string, leeway, expiryGrace time.Duration, claimMap claimMap) (*model.UserInfo, error) {
+	claims := &leewayClaims{UserInfo: &model.UserInfo{}, leeway: leeway, expiryGrace: expiryGrace, claimMap: claimMap}
+	_, err := jwt.ParseWithClaims(rtoken, claims, func(token *jwt.Token) (interface{}, error) {
+		if err := checkSigningMethod(token, r.legacy.SigningMethod()); err != nil {
+			return nil, err
+		}
+		return r.legacy.VerificationKey(), nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	atomic.AddInt64(&r.legacyVerifications, 1)
+	return claims.UserInfo, nil
+}
+
+// legacyToken returns the secondary token signed with the legacy secret,
+// or an empty string outside of the rollover window.
+func (h *Handler) legacyToken(ctx context.Context, userInfo model.UserInfo) (string, error) {
+	if !h.rollover.active(time.Now()) {
+		return "", nil
+	}
+	token, err := signToken(ctx, h.rollover.legacy, h.tokenService().claims(userInfo))
+	if err != nil {
+		return "", err
+	}
+	atomic.AddInt64(&h.rollover.legacyIssued, 1)
+	return token, nil
+}
+
+// setLegacyCookie sets the legacy token in the parallel cookie with the attributes of the main cookie
+func (h *Handler) setLegacyCookie(w http.ResponseWriter, cookie *http.Cookie, legacyToken string) {
+	if legacyToken == "" || !h.rollover.cookie {
+		return
+	}
+	legacyCookie := *cookie
+	legacyCookie.Name = h.rollover.cookieName
+	legacyCookie.Value = legacyToken
+	h.setCookie(w, &legacyCookie)
+}
+
+// legacyTokenField returns the legacy token for the json api, if this output is configured
+func (h *Handler) legacyTokenField(legacyToken string) string {
+	if legacyToken == "" || !h.rollover.field {
+		return ""
+	}
+	return legacyToken
+}
+
+// rolloverStatus is the state of the rollover in the health response
+type rolloverStatus struct {
+	Active              bool      `json:"active"`
+	End                 time.Time `json:"end"`
+	LegacyVerifications int64     `json:"legacy_verifications"`
+	LegacyIssued        int64     `json:"legacy_issued"`
+}
+
+func (r *rollover) status() *rolloverStatus {
+	if r == nil {
+		return nil
+	}
+	return &rolloverStatus{
+		Active:              r.active(time.Now()),
+		End:                 r.end,
+		LegacyVerifications: atomic.LoadInt64(&r.legacyVerifications),
+		LegacyIssued:        atomic.LoadInt64(&r.legacyIssued),
+	}
+}
+
+// report logs the state of the rollover loudly, while it is active
+func (r *rollover) report() {
+	now := time.Now()
+	entry := logging.Logger.
+		WithField("rollover_start", r.start).
+		WithField("rollover_end", r.end).
+		WithField("legacy_verifications", atomic.LoadInt64(&r.legacyVerifications)).
+		WithField("legacy_issued", atomic.LoadInt64(&r.legacyIssued))
+	switch {
+	case r.active(now):
+		entry.Warnf("!!! JWT ROLLOVER ACTIVE until %v: issuing legacy tokens and accepting the legacy secret !!!", r.end.Format(time.RFC3339))
+	case now.Before(r.start):
+		entry.Warnf("jwt rollover configured, starting at %v", r.start.Format(time.RFC3339))
+	default:
+		entry.Warn("jwt rollover ended, the legacy secret is not used any more and can be removed from the configuration")
+	}
+}
+
+// rolloverHook reports the rollover state periodically
+func (h *Handler) rolloverHook() Hook {
+	task := &backgroundTask{interval: rolloverReportInterval, run: h.rollover.report}
+	return task.hook("jwt-rollover-report")
+}
@@ -0,0 +1,208 @@
+package login
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	. "github.com/stretchr/testify/assert"
+	"github.com/tarent/loginsrv/model"
+)
+
+const testLegacySecret = "the-old-secret"
+
+func rolloverTestHandler(output string) *Handler {
+	h := testHandler()
+	h.config.JwtLegacySecret = testLegacySecret
+	h.config.JwtRolloverStart = time.Now().Add(-time.Hour).Format(time.RFC3339)
+	h.config.JwtRolloverEnd = time.Now().Add(time.Hour).Format(time.RFC3339)
+	h.config.JwtLegacyOutput = output
+	r, err := newRollover(h.config)
+	if err != nil {
+		panic(err)
+	}
+	h.rollover = r
+	return h
+}
+
+func legacyToken(userInfo model.UserInfo) string {
+	token, _ := signToken(context.Background(), hmacSigner{secret: []byte(testLegacySecret)}, userInfo)
+	return token
+}
+
+func TestRollover_Config(t *testing.T) {
+	cfg := DefaultConfig()
+	r, err := newRollover(cfg)
+	NoError(t, err)
+	Nil(t, r)
+	False(t, r.active(time.Now()))
+
+	cfg.JwtLegacySecret = testLegacySecret
+	_, err = newRollover(cfg)
+	EqualError(t, err, "a jwt legacy secret requires an explicit -jwt-rollover-start and -jwt-rollover-end")
+
+	cfg.JwtRolloverStart = "2026-01-02T00:00:00Z"
+	cfg.JwtRolloverEnd = "2026-01-01T00:00:00Z"
+	_, err = newRollover(cfg)
+	EqualError(t, err, "the jwt rollover end has to be after its start")
+
+	cfg.JwtRolloverEnd = "2026-02-01T00:00:00Z"
+	cfg.JwtLegacyOutput = "header"
+	_, err = newRollover(cfg)
+	Error(t, err)
+
+	cfg.JwtLegacyOutput = "cookie,field"
+	r, err = newRollover(cfg)
+	NoError(t, err)
+	True(t, r.cookie)
+	True(t, r.field)
+	False(t, r.active(time.Date(2025, 12, 31, 0, 0, 0, 0, time.UTC)))
+	True(t, r.active(time.Date(2026, 1, 15, 0, 0, 0, 0, time.UTC)))
+	False(t, r.active(time.Date(2026, 2, 1, 0, 0, 0, 0, time.UTC)))
+
+	cfg.JwtLegacySecret = cfg.JwtSecret
+	_, err = newRollover(cfg)
+	Error(t, err)
+}
+
+func TestRollover_Cookie(t *testing.T) {
+	h := rolloverTestHandler("cookie")
+
+	recorder := httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("POST", "/context/login", "username=bob&password=secret", TypeForm, AcceptHTML))
+	Equal(t, 303, recorder.Code)
+
+	cookies := readSetCookies(recorder.Header())
+	Equal(t, 2, len(cookies))
+	Equal(t, h.config.CookieName, cookies[0].Name)
+	Equal(t, "jwt_token_legacy", cookies[1].Name)
+	Equal(t, cookies[0].HttpOnly, cookies[1].HttpOnly)
+
+	// the legacy token is signed with the old secret
+	legacy, err := jwt.Parse(cookies[1].Value, func(*jwt.Token) (interface{}, error) { return []byte(testLegacySecret), nil })
+	NoError(t, err)
+	Equal(t, "bob", legacy.Claims.(jwt.MapClaims)["sub"])
+
+	// the main token is signed with the new secret
+	_, err = tokenAsMap(cookies[0].Value)
+	NoError(t, err)
+	Equal(t, int64(1), h.rollover.status().LegacyIssued)
+
+	// logout deletes both cookies
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("DELETE", "/context/login", ""))
+	Equal(t, 2, len(readSetCookies(recorder.Header())))
+}
+
+func TestRollover_Field(t *testing.T) {
+	h := rolloverTestHandler("field")
+
+	// version 1 has the legacy token in a header
+	recorder := httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("POST", "/context/login", "username=bob&password=secret", TypeForm, AcceptJwt))
+	Equal(t, 200, recorder.Code)
+	NotEqual(t, "", recorder.Header().Get(legacyTokenHeader))
+	Equal(t, 0, len(readSetCookies(recorder.Header())))
+
+	// version 2 has it in the body
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("POST", "/context/login", "username=bob&password=secret", TypeForm, "X-Login-API-Version: 2"))
+	body := tokenResponse{}
+	NoError(t, json.Unmarshal(recorder.Body.Bytes(), &body))
+	NotEqual(t, "", body.LegacyToken)
+	NotEqual(t, body.Token, body.LegacyToken)
+
+	// html logins don't get the legacy cookie
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("POST", "/context/login", "username=bob&password=secret", TypeForm, AcceptHTML))
+	Equal(t, 1, len(readSetCookies(recorder.Header())))
+}
+
+func TestRollover_Verification(t *testing.T) {
+	h := rolloverTestHandler("cookie")
+	token := legacyToken(model.UserInfo{Sub: "bob", Expiry: time.Now().Add(time.Hour).Unix()})
+
+	// legacy tokens are accepted and counted within the window
+	userInfo, valid := h.GetToken(req("GET", "/context/login", ""), token)
+	True(t, valid)
+	Equal(t, "bob", userInfo.Sub)
+	Equal(t, int64(1), h.rollover.status().LegacyVerifications)
+
+	// new tokens are not counted
+	newToken, _ := h.createToken(model.UserInfo{Sub: "bob", Expiry: time.Now().Add(time.Hour).Unix()})
+	_, valid = h.GetToken(req("GET", "/context/login", ""), newToken)
+	True(t, valid)
+	Equal(t, int64(1), h.rollover.status().LegacyVerifications)
+
+	// after the window, legacy tokens are rejected
+	h.rollover.end = time.Now().Add(-time.Minute)
+	_, valid = h.GetToken(req("GET", "/context/login", ""), token)
+	False(t, valid)
+
+	// without rollover, legacy tokens are rejected
+	_, valid = testHandler().GetToken(req("GET", "/context/login", ""), token)
+	False(t, valid)
+}
+
+func TestRollover_ClaimMap(t *testing.T) {
+	cfg := tokenServiceConfig("new-secret")
+	cfg.JwtClaimMap = "sub:preferred_username"
+	cfg.JwtLegacySecret = testLegacySecret
+	cfg.JwtRolloverStart = time.Now().Add(-time.Hour).Format(time.RFC3339)
+	cfg.JwtRolloverEnd = time.Now().Add(time.Hour).Format(time.RFC3339)
+	cfg.JwtLegacyOutput = "field"
+	h, err := NewHandler(cfg)
+	NoError(t, err)
+
+	// the legacy token has the mapped names like the token of the new secret
+	recorder := callHandler(h, req("POST", "/login", "username=bob&password=secret", TypeForm, "X-Login-API-Version: 2"))
+	Equal(t, 200, recorder.Code)
+	body := tokenResponse{}
+	NoError(t, json.Unmarshal(recorder.Body.Bytes(), &body))
+	claims := jwt.MapClaims{}
+	_, err = jwt.ParseWithClaims(body.LegacyToken, claims, func(*jwt.Token) (interface{}, error) {
+		return []byte(testLegacySecret), nil
+	})
+	NoError(t, err)
+	Equal(t, "bob", claims["preferred_username"])
+	Nil(t, claims["sub"])
+
+	// and is read by the claim map
+	userInfo, valid := h.GetToken(req("GET", "/login", ""), body.LegacyToken)
+	True(t, valid)
+	Equal(t, "bob", userInfo.Sub)
+	tokens, err := NewTokenService(cfg)
+	NoError(t, err)
+	userInfo, err = tokens.Verify(body.LegacyToken)
+	NoError(t, err)
+	Equal(t, "bob", userInfo.Sub)
+	Equal(t, int64(1), tokens.rollover.status().LegacyVerifications)
+}
+
+func TestRollover_Verification_SigningMethod(t *testing.T) {
+	h := rolloverTestHandler("cookie")
+	userInfo := model.UserInfo{Sub: "bob", Expiry: time.Now().Add(time.Hour).Unix()}
+
+	hs256, _ := jwt.NewWithClaims(jwt.SigningMethodHS256, userInfo).SignedString([]byte(testLegacySecret))
+	_, valid := h.GetToken(req("GET", "/context/login", ""), hs256)
+	False(t, valid)
+
+	none, _ := jwt.NewWithClaims(jwt.SigningMethodNone, userInfo).SignedString(jwt.UnsafeAllowNoneSignatureType)
+	_, valid = h.GetToken(req("GET", "/context/login", ""), none)
+	False(t, valid)
+	Equal(t, int64(0), h.rollover.status().LegacyVerifications)
+}
+
+func TestRollover_Health(t *testing.T) {
+	h := rolloverTestHandler("cookie")
+	recorder := httptest.NewRecorder()
+	h.respondHealth(recorder, req("GET", "/context/login/health", ""))
+	Contains(t, recorder.Body.String(), `"jwt_rollover":{"active":true`)
+
+	recorder = httptest.NewRecorder()
+	testHandler().respondHealth(recorder, req("GET", "/context/login/health", ""))
+	NotContains(t, recorder.Body.String(), "jwt_rollover")
+}
@@ -0,0 +1,253 @@
+package login
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tarent/loginsrv/logging"
+	"github.com/tarent/loginsrv/model"
+)
+
+const (
+	sessionsNamespace        = "sessions"
+	sessionsNamespaceVersion = 1
+
+	// adminAPIKeySub is the admin of the events for the requests authenticated by the -admin-api-key
+	adminAPIKeySub = "admin-api-key"
+
+	// defaultSessionsPageSize is the number of sessions per page of the admin api, if no limit is requested
+	defaultSessionsPageSize = 50
+	maxSessionsPageSize     = 500
+)
+
+var (
+	errAPIMissingSub     = apiError{400, "missing_sub", "Bad Request: The sub of the user is missing"}
+	errAPIInvalidCursor  = apiError{400, "invalid_cursor", "Bad Request: The cursor or limit is invalid"}
+	errAPIUnknownSession = apiError{404, "unknown_session", "Not Found: No active session with this jti"}
+)
+
+// session is the metadata of an issued token in the session registry.
+// The user agent is only kept as hash, to recognize the same browser without storing the header.
+type session struct {
+	JTI       string `json:"jti"`
+	Sub       string `json:"sub"`
+	IssuedAt  int64  `json:"iat"`
+	Expiry    int64  `json:"exp"`
+	ClientIP  string `json:"ip"`
+	UserAgent string `json:"user_agent_hash,omitempty"`
+	Origin    string `json:"origin,omitempty"`
+}
+
+// storedSession is the entry of the session in the store, which keeps the refresh token id as well
+type storedSession struct {
+	session
+	RefreshJTI string `json:"refresh_jti,omitempty"`
+}
+
+type sessionPage struct {
+	Sessions   []session `json:"sessions"`
+	NextCursor string    `json:"next_cursor,omitempty"`
+}
+
+// recordSession adds the issued token to the session registry, with the id of its refresh token, if any.
+// The entry expires with the later of both tokens.
+func (h *Handler) recordSession(r *http.Request, access model.UserInfo, refresh *model.UserInfo) {
+	if access.ID == "" {
+		return
+	}
+	s := storedSession{session: session{
+		JTI:      access.ID,
+		Sub:      access.Sub,
+		IssuedAt: time.Now().Unix(),
+		Expiry:   access.Expiry,
+		ClientIP: clientIP(r, h.trustedProxies),
+		Origin:   access.Origin,
+	}}
+	if ua := r.Header.Get("User-Agent"); ua != "" {
+		sum := sha256.Sum256([]byte(ua))
+		s.UserAgent = hex.EncodeToString(sum[:8])
+	}
+	if refresh != nil {
+		s.RefreshJTI = refresh.ID
+		if refresh.Expiry > s.Expiry {
+			s.Expiry = refresh.Expiry
+		}
+	}
+	value, err := json.Marshal(s)
+	if err != nil {
+		logging.Application(r.Header).WithError(err).Error("could not record the session")
+		return
+	}
+	ttl := time.Until(time.Unix(s.Expiry, 0)) + h.config.JwtLeeway + h.config.JwtRefreshGrace
+	if err := h.state.Set(sessionsNamespace, s.JTI, string(value), ttl); err != nil {
+		logging.Application(r.Header).WithError(err).Error("could not record the session")
+	}
+}
+
+// sessionsOf returns the active sessions of the user, the latest first
+func (h *Handler) sessionsOf(sub string) ([]session, error) {
+	sessions := []session{}
+	err := h.state.Scan(sessionsNamespace, func(key, value string) {
+		var s storedSession
+		if err := json.Unmarshal([]byte(value), &s); err != nil || s.Sub != sub {
+			return
+		}
+		sessions = append(sessions, s.session)
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(sessions, func(i, j int) bool {
+		if sessions[i].IssuedAt != sessions[j].IssuedAt {
+			return sessions[i].IssuedAt > sessions[j].IssuedAt
+		}
+		return sessions[i].JTI < sessions[j].JTI
+	})
+	return sessions, nil
+}
+
+// isAdminSessionsPath matches <login-path>/admin/sessions and the paths below, if the -admin-group or the -admin-api-key is set
+func (h *Handler) isAdminSessionsPath(r *http.Request) bool {
+	prefix := path.Join(h.config.LoginPath, "admin", "sessions")
+	enabled := h.config.AdminGroup != "" || h.config.AdminAPIKey != ""
+	return enabled && (r.URL.Path == prefix || strings.HasPrefix(r.URL.Path, prefix+"/"))
+}
+
+// respondAdminSessions serves the admin api to list and end the sessions of a user:
+//
+//	GET    <login-path>/admin/sessions?sub=<sub>&limit=<n>&cursor=<c>  lists the active sessions of the user
+//	DELETE <login-path>/admin/sessions/<jti>                            revokes the token and its refresh token
+//
+// The request has to be authenticated by the -admin-api-key or by a token of a user in the -admin-group.
+// The sessions are registered in the Store of the handler, so they survive a restart only with the -state-file or the -store-file.
+// With the default memory store, each instance only knows the sessions it issued, instances share them by a common Store of WithStore.
+// The revocation goes to the revocation store and applies to all instances sharing it.
+func (h *Handler) respondAdminSessions(w http.ResponseWriter, r *http.Request) {
+	admin := model.UserInfo{Sub: adminAPIKeySub}
+	if h.config.AdminAPIKey == "" || subtle.ConstantTimeCompare([]byte(bearerToken(r)), []byte(h.config.AdminAPIKey)) != 1 {
+		userInfo, failure := h.verifyToken(r, "")
+		if failure != "" {
+			h.respondAPIError(w, r, errAPIUnauthenticated)
+			return
+		}
+		if h.config.AdminGroup == "" || !(requirement{"groups", h.config.AdminGroup}).metBy(userInfo) {
+			h.respondAPIError(w, r, errAPINotAdmin)
+			return
+		}
+		admin = userInfo
+	}
+
+	jti := strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, path.Join(h.config.LoginPath, "admin", "sessions")), "/")
+	switch {
+	case jti == "" && r.Method == "GET":
+		h.listSessions(w, r, admin)
+	case jti != "" && !strings.Contains(jti, "/") && r.Method == "DELETE":
+		h.revokeSession(w, r, admin, jti)
+	default:
+		h.respondBadRequest(w, r)
+	}
+}
+
+func (h *Handler) listSessions(w http.ResponseWriter, r *http.Request, admin model.UserInfo) {
+	sub := r.URL.Query().Get("sub")
+	if sub == "" {
+		h.respondAPIError(w, r, errAPIMissingSub)
+		return
+	}
+	offset, limit, ok := sessionsPageParams(r)
+	if !ok {
+		h.respondAPIError(w, r, errAPIInvalidCursor)
+		return
+	}
+
+	sessions, err := h.sessionsOf(sub)
+	if err != nil {
+		logging.Application(r.Header).WithError(err).Error("could not list the sessions")
+		h.respondAPIError(w, r, errAPIInternal)
+		return
+	}
+	page := sessionPage{Sessions: []session{}}
+	if offset < len(sessions) {
+		end := offset + limit
+		if end < len(sessions) {
+			page.NextCursor = strconv.Itoa(end)
+		} else {
+			end = len(sessions)
+		}
+		page.Sessions = sessions[offset:end]
+	}
+	h.emitWithReason(r, EventSessionsListed, admin.Sub, admin.Origin, sub)
+
+	w.Header().Set("Content-Type", contentTypeJSON)
+	w.Header().Set("Cache-Control", "no-cache, no-store, must-revalidate")
+	w.WriteHeader(200)
+	if err := json.NewEncoder(w).Encode(page); err != nil {
+		logging.Logger.WithError(err).Error("could not write the sessions")
+	}
+}
+
+// sessionsPageParams returns the offset of the cursor and the limit of the page
+func sessionsPageParams(r *http.Request) (offset, limit int, ok bool) {
+	limit = defaultSessionsPageSize
+	if s := r.URL.Query().Get("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 {
+			return 0, 0, false
+		}
+		if n < maxSessionsPageSize {
+			limit = n
+		} else {
+			limit = maxSessionsPageSize
+		}
+	}
+	if s := r.URL.Query().Get("cursor"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 0 {
+			return 0, 0, false
+		}
+		offset = n
+	}
+	return offset, limit, true
+}
+
+func (h *Handler) revokeSession(w http.ResponseWriter, r *http.Request, admin model.UserInfo, jti string) {
+	value, exist, err := h.state.Get(sessionsNamespace, jti)
+	if err != nil {
+		logging.Application(r.Header).WithError(err).Error("could not read the session")
+		h.respondAPIError(w, r, errAPIInternal)
+		return
+	}
+	var s storedSession
+	if exist {
+		exist = json.Unmarshal([]byte(value), &s) == nil
+	}
+	if !exist {
+		h.respondAPIError(w, r, errAPIUnknownSession)
+		return
+	}
+
+	until := time.Unix(s.Expiry, 0).Add(h.config.JwtLeeway + h.config.JwtRefreshGrace)
+	for _, id := range []string{s.JTI, s.RefreshJTI} {
+		if id == "" {
+			continue
+		}
+		if err := h.revocations.Revoke(id, until); err != nil {
+			logging.Application(r.Header).WithError(err).Error("could not revoke the session")
+			h.respondError(w, r)
+			return
+		}
+	}
+	if err := h.state.Delete(sessionsNamespace, jti); err != nil {
+		logging.Application(r.Header).WithError(err).Warn("could not delete the revoked session")
+	}
+	h.emitWithReason(r, EventSessionRevoked, admin.Sub, admin.Origin, s.Sub+" "+jti)
+	w.WriteHeader(204)
+}
@@ -0,0 +1,153 @@
+package login
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	. "github.com/stretchr/testify/assert"
+	"github.com/tarent/loginsrv/model"
+)
+
+func TestHandler_AdminSessions(t *testing.T) {
+	h := testHandler()
+	h.config.AdminGroup = "admins"
+	rec := &eventRecorder{}
+	h.Subscribe(Subscriber{Name: "test", Handle: rec.handle})
+
+	adminToken, err := h.createToken(model.UserInfo{Sub: "alice", Groups: []string{"admins"}, Expiry: time.Now().Add(time.Minute).Unix()})
+	NoError(t, err)
+	admin := "Authorization: Bearer " + adminToken
+
+	login := func(remoteAddr string) string {
+		r := req("POST", "/context/login", "username=bob&password=secret", TypeForm, AcceptJwt, "User-Agent: test-browser")
+		r.RemoteAddr = remoteAddr
+		recorder := callHandler(h, r)
+		Equal(t, 200, recorder.Code)
+		return recorder.Body.String()
+	}
+	first := login("192.0.2.1:1234")
+	second := login("192.0.2.2:1234")
+	firstClaims, _ := tokenAsMap(first)
+	secondClaims, _ := tokenAsMap(second)
+
+	// only admins
+	Equal(t, 401, callHandler(h, req("GET", "/context/login/admin/sessions?sub=bob", "")).Code)
+	Equal(t, 403, callHandler(h, req("GET", "/context/login/admin/sessions?sub=bob", "", "Authorization: Bearer "+first)).Code)
+	Equal(t, 403, callHandler(h, req("DELETE", "/context/login/admin/sessions/"+secondClaims["jti"].(string), "", "Authorization: Bearer "+first)).Code)
+
+	list := func(query string) sessionPage {
+		recorder := callHandler(h, req("GET", "/context/login/admin/sessions?"+query, "", admin))
+		Equal(t, 200, recorder.Code)
+		page := sessionPage{}
+		NoError(t, json.Unmarshal(recorder.Body.Bytes(), &page))
+		return page
+	}
+	page := list("sub=bob")
+	Equal(t, 2, len(page.Sessions))
+	Equal(t, "", page.NextCursor)
+	for _, s := range page.Sessions {
+		Equal(t, "bob", s.Sub)
+		Equal(t, 16, len(s.UserAgent))
+		InDelta(t, time.Now().Unix(), s.IssuedAt, 2)
+	}
+	ips := []string{page.Sessions[0].ClientIP, page.Sessions[1].ClientIP}
+	ElementsMatch(t, []string{"192.0.2.1", "192.0.2.2"}, ips)
+	Equal(t, 0, len(list("sub=marvin").Sessions))
+
+	// paginated
+	page = list("sub=bob&limit=1")
+	Equal(t, 1, len(page.Sessions))
+	Equal(t, "1", page.NextCursor)
+	next := list("sub=bob&limit=1&cursor=1")
+	Equal(t, 1, len(next.Sessions))
+	Equal(t, "", next.NextCursor)
+	NotEqual(t, page.Sessions[0].JTI, next.Sessions[0].JTI)
+	Equal(t, 0, len(list("sub=bob&cursor=5").Sessions))
+
+	Equal(t, 400, callHandler(h, req("GET", "/context/login/admin/sessions", "", admin)).Code)
+	Equal(t, 400, callHandler(h, req("GET", "/context/login/admin/sessions?sub=bob&limit=0", "", admin)).Code)
+	Equal(t, 400, callHandler(h, req("GET", "/context/login/admin/sessions?sub=bob&cursor=x", "", admin)).Code)
+
+	// revoke one session
+	Equal(t, 204, callHandler(h, req("DELETE", "/context/login/admin/sessions/"+firstClaims["jti"].(string), "", admin)).Code)
+	_, failure := h.verifyToken(req("GET", "/", ""), first)
+	Equal(t, TokenRevoked, failure)
+	_, valid := h.getToken(req("GET", "/", ""), second)
+	True(t, valid)
+	page = list("sub=bob")
+	Equal(t, 1, len(page.Sessions))
+	Equal(t, secondClaims["jti"], page.Sessions[0].JTI)
+	Equal(t, 404, callHandler(h, req("DELETE", "/context/login/admin/sessions/"+firstClaims["jti"].(string), "", admin)).Code)
+	Equal(t, 400, callHandler(h, req("POST", "/context/login/admin/sessions/"+secondClaims["jti"].(string), "", admin)).Code)
+
+	var audit []Event
+	for _, e := range rec.events {
+		if e.Type == EventSessionsListed || e.Type == EventSessionRevoked {
+			audit = append(audit, e)
+		}
+	}
+	Equal(t, EventSessionsListed, audit[0].Type)
+	Equal(t, "alice", audit[0].Username)
+	Equal(t, "bob", audit[0].Reason)
+	revoked := audit[len(audit)-2]
+	Equal(t, EventSessionRevoked, revoked.Type)
+	Equal(t, "alice", revoked.Username)
+	Equal(t, "bob "+firstClaims["jti"].(string), revoked.Reason)
+}
+
+func TestHandler_AdminSessions_RevokesRefreshToken(t *testing.T) {
+	h := refreshTokenHandler()
+	h.config.AdminGroup = "admins"
+	adminToken, err := h.createToken(model.UserInfo{Sub: "alice", Groups: []string{"admins"}, Expiry: time.Now().Add(time.Minute).Unix()})
+	NoError(t, err)
+
+	recorder := callHandler(h, req("POST", "/context/login", `{"username": "bob", "password": "secret"}`, TypeJSON, "X-Login-API-Version: 2"))
+	Equal(t, 200, recorder.Code)
+	response := tokenResponse{}
+	NoError(t, json.Unmarshal(recorder.Body.Bytes(), &response))
+	claims, _ := tokenAsMap(response.Token)
+
+	Equal(t, 204, callHandler(h, req("DELETE", "/context/login/admin/sessions/"+claims["jti"].(string), "", "Authorization: Bearer "+adminToken)).Code)
+	Equal(t, 400, callHandler(h, req("POST", "/context/login", `{"token": "`+response.RefreshToken+`"}`, TypeJSON, "X-Login-API-Version: 2")).Code)
+}
+
+func TestHandler_AdminSessions_Disabled(t *testing.T) {
+	h := testHandler()
+	adminToken, err := h.createToken(model.UserInfo{Sub: "alice", Groups: []string{"admins"}, Expiry: time.Now().Add(time.Minute).Unix()})
+	NoError(t, err)
+	Equal(t, 404, callHandler(h, req("GET", "/context/login/admin/sessions?sub=bob", "", "Authorization: Bearer "+adminToken)).Code)
+}
+
+func TestHandler_AdminSessions_APIKey(t *testing.T) {
+	h := testHandler()
+	h.config.AdminAPIKey = "helpdesk-key"
+	rec := &eventRecorder{}
+	h.Subscribe(Subscriber{Name: "test", Handle: rec.handle})
+
+	recorder := callHandler(h, req("POST", "/context/login", "username=bob&password=secret", TypeForm, AcceptJwt))
+	Equal(t, 200, recorder.Code)
+	token := recorder.Body.String()
+	claims, _ := tokenAsMap(token)
+
+	// the key replaces the token of an admin
+	recorder = callHandler(h, req("GET", "/context/login/admin/sessions?sub=bob", "", "Authorization: Bearer helpdesk-key"))
+	Equal(t, 200, recorder.Code)
+	page := sessionPage{}
+	NoError(t, json.Unmarshal(recorder.Body.Bytes(), &page))
+	Equal(t, 1, len(page.Sessions))
+
+	Equal(t, 401, callHandler(h, req("GET", "/context/login/admin/sessions?sub=bob", "", "Authorization: Bearer other-key")).Code)
+	// without an -admin-group, no user is an admin
+	Equal(t, 403, callHandler(h, req("GET", "/context/login/admin/sessions?sub=bob", "", "Authorization: Bearer "+token)).Code)
+
+	Equal(t, 204, callHandler(h, req("DELETE", "/context/login/admin/sessions/"+claims["jti"].(string), "", "Authorization: Bearer helpdesk-key")).Code)
+	_, failure := h.verifyToken(req("GET", "/", ""), token)
+	Equal(t, TokenRevoked, failure)
+
+	for _, e := range rec.events {
+		if e.Type == EventSessionsListed || e.Type == EventSessionRevoked {
+			Equal(t, adminAPIKeySub, e.Username)
+		}
+	}
+}
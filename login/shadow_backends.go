@@ -0,0 +1,173 @@
+package login
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/tarent/loginsrv/logging"
+	"github.com/tarent/loginsrv/model"
+)
+
+// shadowOption marks a backend as shadow backend, e.g. -ldap shadow=true,...
+const shadowOption = "shadow"
+
+// maxShadowCalls is the number of concurrent calls of the shadow backends.
+// Further logins are not compared, so that no password waits in a queue.
+const maxShadowCalls = 10
+
+// shadowCallTimeout bounds a call of a shadow backend, which runs after the response of the login
+const shadowCallTimeout = 10 * time.Second
+
+// shadowBackend is a backend, which is asked after the decision of the login backends.
+// Its result is only compared with the decision and never used for the login.
+type shadowBackend struct {
+	name    string
+	backend Backend
+}
+
+// splitShadowOption reads the shadow option of a backend.
+// It returns the options without it for the backend.
+func splitShadowOption(options map[string]string) (bool, map[string]string, error) {
+	value, set := options[shadowOption]
+	if !set {
+		return false, options, nil
+	}
+	shadow, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, nil, fmt.Errorf("invalid %v option %q, has to be true or false", shadowOption, value)
+	}
+	backendOptions := map[string]string{}
+	for k, v := range options {
+		if k != shadowOption {
+			backendOptions[k] = v
+		}
+	}
+	return shadow, backendOptions, nil
+}
+
+// shadowComparison counts the results of the calls of one shadow backend
+type shadowComparison struct {
+	Agreed     int64 `json:"agreed"`
+	Mismatched int64 `json:"mismatched"`
+	Errors     int64 `json:"errors"`
+	// Skipped logins were not compared, because too many shadow calls were in flight
+	Skipped int64 `json:"skipped"`
+	// AgreementRate is the share of the agreeing results of all compared logins
+	AgreementRate float64 `json:"agreement_rate"`
+}
+
+// shadowRunner calls the shadow backends in the background with bounded concurrency.
+// It is shared by all snapshots, so that the counts survive reloads.
+type shadowRunner struct {
+	slots chan struct{}
+	calls sync.WaitGroup
+
+	mu     sync.Mutex
+	counts map[string]*shadowComparison
+}
+
+func newShadowRunner() *shadowRunner {
+	return &shadowRunner{
+		slots:  make(chan struct{}, maxShadowCalls),
+		counts: map[string]*shadowComparison{},
+	}
+}
+
+func (s *shadowRunner) count(name string, update func(c *shadowComparison)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c := s.counts[name]
+	if c == nil {
+		c = &shadowComparison{}
+		s.counts[name] = c
+	}
+	update(c)
+}
+
+// status returns the counts per shadow backend, nil without any shadow call
+func (s *shadowRunner) status() map[string]shadowComparison {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.counts) == 0 {
+		return nil
+	}
+	status := map[string]shadowComparison{}
+	for name, c := range s.counts {
+		result := *c
+		if compared := c.Agreed + c.Mismatched; compared > 0 {
+			result.AgreementRate = float64(c.Agreed) / float64(compared)
+		}
+		status[name] = result
+	}
+	return status
+}
+
+// hook waits for the shadow calls in flight on shutdown
+func (s *shadowRunner) hook() Hook {
+	return Hook{
+		Name: "shadow-backends",
+		Stop: func(ctx context.Context) error {
+			done := make(chan struct{})
+			go func() {
+				s.calls.Wait()
+				close(done)
+			}()
+			select {
+			case <-done:
+				return nil
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		},
+	}
+}
+
+// shadowAuthenticate compares the decision of the login backends with the shadow backends.
+// The calls run in the background and never change the response. They are not counted as login failures.
+// The password is only kept by the calls in flight: without a free slot, the comparison is skipped.
+func (h *Handler) shadowAuthenticate(username, password string, authenticated bool, userInfo model.UserInfo) {
+	for _, shadow := range h.shadowBackends {
+		select {
+		case h.shadows.slots <- struct{}{}:
+		default:
+			h.shadows.count(shadow.name, func(c *shadowComparison) { c.Skipped++ })
+			continue
+		}
+		h.shadows.calls.Add(1)
+		go func(shadow shadowBackend) {
+			defer func() {
+				<-h.shadows.slots
+				h.shadows.calls.Done()
+			}()
+			h.compareShadow(shadow, username, password, authenticated, userInfo)
+		}(shadow)
+	}
+}
+
+func (h *Handler) compareShadow(shadow shadowBackend, username, password string, authenticated bool, userInfo model.UserInfo) {
+	ctx, cancel := context.WithTimeout(context.Background(), shadowCallTimeout)
+	defer cancel()
+	shadowAuthenticated, shadowUserInfo, err := shadow.backend.AuthenticateWithContext(ctx, username, password)
+	entry := logging.Logger.
+		WithField("shadow_backend", shadow.name).
+		WithField("username", username)
+	if err != nil {
+		entry.WithError(err).Warn("shadow backend failed")
+		h.shadows.count(shadow.name, func(c *shadowComparison) { c.Errors++ })
+		return
+	}
+	if shadowAuthenticated != authenticated || (authenticated && shadowUserInfo.Sub != userInfo.Sub) {
+		entry.
+			WithField("authenticated", authenticated).
+			WithField("shadow_authenticated", shadowAuthenticated).
+			WithField("sub", userInfo.Sub).
+			WithField("shadow_sub", shadowUserInfo.Sub).
+			Warn("shadow backend disagrees with the login backends")
+		h.shadows.count(shadow.name, func(c *shadowComparison) { c.Mismatched++ })
+		return
+	}
+	h.shadows.count(shadow.name, func(c *shadowComparison) { c.Agreed++ })
+}
@@ -0,0 +1,73 @@
+package login
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	. "github.com/stretchr/testify/assert"
+)
+
+func shadowTestHandler(t *testing.T, backends Options) (*Handler, error) {
+	registry := NewProviderRegistry()
+	simple, _ := GetProvider(SimpleProviderName)
+	NoError(t, registry.Register(&ProviderDescription{Name: SimpleProviderName}, simple))
+	NoError(t, registry.Register(&ProviderDescription{Name: "shadowtest"}, simple))
+	cfg := testConfig()
+	cfg.Backends = backends
+	return NewHandlerWithOptions(cfg, WithRegistry(registry))
+}
+
+func TestHandler_ShadowBackends(t *testing.T) {
+	h, err := shadowTestHandler(t, Options{
+		"simple":     {"bob": "secret"},
+		"shadowtest": {"shadow": "true", "bob": "secret", "alice": "secret"},
+	})
+	NoError(t, err)
+	Equal(t, []string{"simple"}, h.backendNames)
+	Equal(t, 1, len(h.shadowBackends))
+	hooks := h.Hooks()
+	Equal(t, "shadow-backends", hooks[len(hooks)-1].Name)
+
+	login := func(username, password string) int {
+		return callHandler(h, req("POST", "/context/login", "username="+username+"&password="+password, TypeForm, AcceptJwt)).Code
+	}
+	Equal(t, 200, login("bob", "secret"))
+	Equal(t, 403, login("bob", "wrong"))
+	// the shadow backend never decides the login
+	Equal(t, 403, login("alice", "secret"))
+
+	// without a free slot, the comparison is skipped instead of queued
+	for i := 0; i < maxShadowCalls; i++ {
+		h.shadows.slots <- struct{}{}
+	}
+	Equal(t, 403, login("bob", "other"))
+	for i := 0; i < maxShadowCalls; i++ {
+		<-h.shadows.slots
+	}
+
+	NoError(t, h.shadows.hook().Stop(context.Background()))
+	comparison := h.shadows.status()["shadowtest"]
+	Equal(t, int64(2), comparison.Agreed)
+	Equal(t, int64(1), comparison.Mismatched)
+	Equal(t, int64(0), comparison.Errors)
+	Equal(t, int64(1), comparison.Skipped)
+	InDelta(t, 2.0/3, comparison.AgreementRate, 0.001)
+
+	// the shadow calls are no login failures, alice failed on the login backend only
+	Equal(t, 2, h.failures.status(time.Now()).Users)
+	Equal(t, 2, h.failures.count(h.loginFailureKey("bob")))
+}
+
+func TestHandler_ShadowBackends_Config(t *testing.T) {
+	_, err := shadowTestHandler(t, Options{"simple": {"bob": "secret"}, "shadowtest": {"shadow": "maybe"}})
+	Error(t, err)
+
+	_, err = shadowTestHandler(t, Options{"shadowtest": {"shadow": "true", "bob": "secret"}})
+	EqualError(t, err, "Shadow backends require a login backend to compare with")
+
+	h, err := shadowTestHandler(t, Options{"simple": {"bob": "secret"}, "shadowtest": {"shadow": "false", "alice": "secret"}})
+	NoError(t, err)
+	Equal(t, []string{"shadowtest", "simple"}, h.backendNames)
+	Equal(t, 0, len(h.shadowBackends))
+}
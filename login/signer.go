@@ -0,0 +1,406 @@
+package login
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/tarent/loginsrv/awskms"
+)
+
+// keyIdentifier is implemented by signers, which name their key in the kid header of the tokens
+type keyIdentifier interface {
+	KeyID() string
+}
+
+// keySelector is implemented by signers with multiple verification keys,
+// which are selected by the kid header of the token.
+type keySelector interface {
+	VerificationKeyFor(kid string) (interface{}, error)
+}
+
+// Signer signs the tokens. The key may be held in memory
+// or outside of the process, e.g. in a kms or hsm.
+type Signer interface {
+	// SigningMethod is the jwt signing method matching the key.
+	SigningMethod() jwt.SigningMethod
+	// Sign returns the signature for the signing input (encoded header and claims) of a token.
+	Sign(ctx context.Context, signingInput string) ([]byte, error)
+	// VerificationKey returns the key to verify the signatures with.
+	VerificationKey() interface{}
+	// PublicKeys returns the public keys for the verification by other services.
+	// It is empty for symmetric keys.
+	PublicKeys() []crypto.PublicKey
+}
+
+// jwtAlgorithms are the supported values of -jwt-algo
+var jwtAlgorithms = []string{
+	"HS256", "HS384", "HS512",
+	"RS256", "RS384", "RS512",
+	"PS256", "PS384", "PS512",
+	"ES256", "ES384", "ES512",
+	"EdDSA",
+}
+
+// normalizeJwtAlgo returns the spelling of the algorithm in the jwt header,
+// or an empty string for the algorithm of the key.
+func normalizeJwtAlgo(algo string) (string, error) {
+	algo = strings.TrimSpace(algo)
+	if algo == "" {
+		return "", nil
+	}
+	for _, supported := range jwtAlgorithms {
+		if strings.EqualFold(algo, supported) {
+			return supported, nil
+		}
+	}
+	return "", fmt.Errorf("No such jwt algorithm: %v, supported algorithms: %v", algo, strings.Join(jwtAlgorithms, ", "))
+}
+
+// hmacSigner signs with the shared jwt secret, by default with HS512
+type hmacSigner struct {
+	secret []byte
+	method *jwt.SigningMethodHMAC
+
+	// kid and keys are set for keyed secrets, see parseJwtSecret
+	kid  string
+	keys map[string][]byte
+}
+
+func (s hmacSigner) SigningMethod() jwt.SigningMethod {
+	if s.method == nil {
+		return jwt.SigningMethodHS512
+	}
+	return s.method
+}
+
+func (s hmacSigner) Sign(ctx context.Context, signingInput string) ([]byte, error) {
+	signature, err := s.SigningMethod().Sign(signingInput, s.secret)
+	if err != nil {
+		return nil, err
+	}
+	return jwt.DecodeSegment(signature)
+}
+
+func (s hmacSigner) VerificationKey() interface{} {
+	return s.secret
+}
+
+func (s hmacSigner) PublicKeys() []crypto.PublicKey {
+	return nil
+}
+
+// rsaSigner signs with a private key from a pem file, by default with RS256
+type rsaSigner struct {
+	key    *rsa.PrivateKey
+	method jwt.SigningMethod
+}
+
+// loadPrivateKeySigner reads the private key from the pem file: an rsa key (PKCS1 or PKCS8)
+// for RS256, an ecdsa key (SEC1 or PKCS8) for ES256, ES384 or ES512 depending on the curve
+// or an Ed25519 key (PKCS8) for EdDSA.
+// The key is checked by signing and verifying a probe, so that a bad key fails on startup.
+func loadPrivateKeySigner(file string) (Signer, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("Could not read the jwt private key: %v", err)
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("Invalid jwt private key %v: no pem data", file)
+	}
+
+	var key crypto.PrivateKey
+	if key, err = x509.ParsePKCS8PrivateKey(block.Bytes); err != nil {
+		if key, err = x509.ParsePKCS1PrivateKey(block.Bytes); err != nil {
+			if key, err = x509.ParseECPrivateKey(block.Bytes); err != nil {
+				return nil, fmt.Errorf("Invalid jwt private key %v: expected a PKCS1 or PKCS8 rsa key, a SEC1 or PKCS8 ecdsa key or a PKCS8 ed25519 key", file)
+			}
+		}
+	}
+
+	var signer Signer
+	switch key := key.(type) {
+	case *rsa.PrivateKey:
+		signer = rsaSigner{key: key}
+	case *ecdsa.PrivateKey:
+		method, exist := ecdsaMethods[key.Curve]
+		if !exist {
+			return nil, fmt.Errorf("Invalid jwt private key %v: unsupported curve %v, supported are P-256, P-384 and P-521", file, key.Curve.Params().Name)
+		}
+		signer = ecdsaSigner{key: key, method: method}
+	case ed25519.PrivateKey:
+		signer = ed25519Signer{key: key}
+	default:
+		return nil, fmt.Errorf("Invalid jwt private key %v: unsupported key type %T, supported are rsa, ecdsa and ed25519", file, key)
+	}
+
+	if err := checkSigner(signer); err != nil {
+		return nil, fmt.Errorf("Invalid jwt private key %v: %v", file, err)
+	}
+	return signer, nil
+}
+
+// checkSigner verifies a signature of the signer with its verification key
+func checkSigner(signer Signer) error {
+	signingInput := "loginsrv.key-check"
+	signature, err := signer.Sign(context.Background(), signingInput)
+	if err != nil {
+		return err
+	}
+	return signer.SigningMethod().Verify(signingInput, jwt.EncodeSegment(signature), signer.VerificationKey())
+}
+
+func (s rsaSigner) SigningMethod() jwt.SigningMethod {
+	if s.method == nil {
+		return jwt.SigningMethodRS256
+	}
+	return s.method
+}
+
+func (s rsaSigner) Sign(ctx context.Context, signingInput string) ([]byte, error) {
+	signature, err := s.SigningMethod().Sign(signingInput, s.key)
+	if err != nil {
+		return nil, err
+	}
+	return jwt.DecodeSegment(signature)
+}
+
+func (s rsaSigner) VerificationKey() interface{} {
+	return &s.key.PublicKey
+}
+
+func (s rsaSigner) PublicKeys() []crypto.PublicKey {
+	return []crypto.PublicKey{&s.key.PublicKey}
+}
+
+// ecdsaSigner signs with an ecdsa private key from a pem file,
+// the algorithm is determined by the curve of the key.
+type ecdsaSigner struct {
+	key    *ecdsa.PrivateKey
+	method *jwt.SigningMethodECDSA
+}
+
+var ecdsaMethods = map[elliptic.Curve]*jwt.SigningMethodECDSA{
+	elliptic.P256(): jwt.SigningMethodES256,
+	elliptic.P384(): jwt.SigningMethodES384,
+	elliptic.P521(): jwt.SigningMethodES512,
+}
+
+func (s ecdsaSigner) SigningMethod() jwt.SigningMethod {
+	return s.method
+}
+
+func (s ecdsaSigner) Sign(ctx context.Context, signingInput string) ([]byte, error) {
+	signature, err := s.method.Sign(signingInput, s.key)
+	if err != nil {
+		return nil, err
+	}
+	return jwt.DecodeSegment(signature)
+}
+
+func (s ecdsaSigner) VerificationKey() interface{} {
+	return &s.key.PublicKey
+}
+
+func (s ecdsaSigner) PublicKeys() []crypto.PublicKey {
+	return []crypto.PublicKey{&s.key.PublicKey}
+}
+
+// ed25519Signer signs with EdDSA and an Ed25519 private key from a pem file
+type ed25519Signer struct {
+	key ed25519.PrivateKey
+}
+
+func (s ed25519Signer) SigningMethod() jwt.SigningMethod {
+	return signingMethodEdDSA
+}
+
+func (s ed25519Signer) Sign(ctx context.Context, signingInput string) ([]byte, error) {
+	return ed25519.Sign(s.key, []byte(signingInput)), nil
+}
+
+func (s ed25519Signer) VerificationKey() interface{} {
+	return s.key.Public()
+}
+
+func (s ed25519Signer) PublicKeys() []crypto.PublicKey {
+	return []crypto.PublicKey{s.key.Public()}
+}
+
+// signingMethodEdDSA is the EdDSA algorithm with Ed25519 keys (RFC 8037), which jwt-go does not provide.
+// It is registered for the alg EdDSA, so that jwt.Parse finds it.
+var signingMethodEdDSA = &edDSASigningMethod{}
+
+func init() {
+	jwt.RegisterSigningMethod(signingMethodEdDSA.Alg(), func() jwt.SigningMethod {
+		return signingMethodEdDSA
+	})
+}
+
+type edDSASigningMethod struct{}
+
+func (m *edDSASigningMethod) Alg() string {
+	return "EdDSA"
+}
+
+// Verify expects an ed25519.PublicKey
+func (m *edDSASigningMethod) Verify(signingString, signature string, key interface{}) error {
+	publicKey, ok := key.(ed25519.PublicKey)
+	if !ok {
+		return jwt.ErrInvalidKeyType
+	}
+	sig, err := jwt.DecodeSegment(signature)
+	if err != nil {
+		return err
+	}
+	if !ed25519.Verify(publicKey, []byte(signingString), sig) {
+		return jwt.ErrSignatureInvalid
+	}
+	return nil
+}
+
+// Sign expects an ed25519.PrivateKey
+func (m *edDSASigningMethod) Sign(signingString string, key interface{}) (string, error) {
+	privateKey, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return "", jwt.ErrInvalidKeyType
+	}
+	return jwt.EncodeSegment(ed25519.Sign(privateKey, []byte(signingString))), nil
+}
+
+// newSigner creates the signer for the private key, the kms key or the secret of the configuration
+// and the configured jwt algorithm. Without an algorithm, the one of the key is used.
+func newSigner(config *Config) (Signer, error) {
+	algo, err := normalizeJwtAlgo(config.JwtAlgo)
+	if err != nil {
+		return nil, err
+	}
+
+	var signer Signer
+	switch {
+	case config.JwtPrivateKey != "" && config.JwtKMSKey != "":
+		return nil, errors.New("Only one of -jwt-private-key and -jwt-kms-key can be configured")
+	case config.JwtPrivateKey != "":
+		signer, err = loadPrivateKeySigner(config.JwtPrivateKey)
+	case config.JwtKMSKey != "":
+		signer, err = newKMSSigner(config)
+	default:
+		var s hmacSigner
+		if s, err = parseJwtSecret(config.JwtSecret); err == nil && config.JwtSecretBase64 {
+			s, err = decodeBase64Secrets(s)
+		}
+		if err == nil {
+			err = checkHMACSecretLengths(s, config.JwtSecretMinLength)
+		}
+		signer = s
+	}
+	if err != nil {
+		return nil, err
+	}
+	return withJwtAlgo(signer, algo)
+}
+
+// withJwtAlgo checks, that the algorithm can be used with the key of the signer,
+// and selects it for the secret and rsa keys, which support multiple algorithms.
+// So e.g. RS256 without a private key is rejected.
+func withJwtAlgo(signer Signer, algo string) (Signer, error) {
+	if algo == "" || algo == signer.SigningMethod().Alg() {
+		return signer, nil
+	}
+	switch s := signer.(type) {
+	case hmacSigner:
+		if method, ok := jwt.GetSigningMethod(algo).(*jwt.SigningMethodHMAC); ok {
+			s.method = method
+			return s, nil
+		}
+		return nil, fmt.Errorf("The jwt algorithm %v requires -jwt-private-key or -jwt-kms-key, the jwt secret supports HS256, HS384 and HS512", algo)
+	case rsaSigner:
+		switch method := jwt.GetSigningMethod(algo).(type) {
+		case *jwt.SigningMethodRSA, *jwt.SigningMethodRSAPSS:
+			s.method = method
+			return s, nil
+		}
+		return nil, fmt.Errorf("The jwt algorithm %v does not match the rsa private key, which supports RS256, RS384, RS512, PS256, PS384 and PS512", algo)
+	}
+	return nil, fmt.Errorf("The jwt algorithm %v does not match the key, which supports %v", algo, signer.SigningMethod().Alg())
+}
+
+// newKMSSigner creates the signer for the configured aws kms key
+// with the credentials configured by the environment.
+func newKMSSigner(config *Config) (Signer, error) {
+	creds, err := awskms.DefaultCredentials(os.Getenv("AWS_REGION"))
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), config.JwtKMSTimeout)
+	defer cancel()
+	return awskms.NewSigner(ctx, config.JwtKMSKey, os.Getenv("AWS_REGION"), config.JwtKMSEndpoint, creds, config.JwtKMSTimeout)
+}
+
+// newFallbackSigners returns the signers for the previous secrets, which are only used for the verification.
+// They use the hmac algorithm of the jwt secret, or HS512 after a switch to a private or kms key.
+// With -jwt-secret-base64, they are base64 encoded like the jwt secret.
+func newFallbackSigners(config *Config, primary Signer) ([]Signer, error) {
+	method := jwt.SigningMethodHS512
+	if hmac, ok := primary.(hmacSigner); ok {
+		method = hmac.SigningMethod().(*jwt.SigningMethodHMAC)
+	}
+	var signers []Signer
+	for _, secret := range strings.Split(config.JwtSecretFallback, ",") {
+		if secret = strings.TrimSpace(secret); secret == "" {
+			continue
+		}
+		if _, ok := primary.(hmacSigner); ok && secret == config.JwtSecret {
+			return nil, errors.New("The jwt secret fallback has to differ from the jwt secret")
+		}
+		key := []byte(secret)
+		if config.JwtSecretBase64 {
+			var err error
+			if key, err = decodeBase64Secret("jwt secret fallback", secret); err != nil {
+				return nil, err
+			}
+		}
+		if err := checkJwtSecretLength("jwt secret fallback", key, config.JwtSecretMinLength); err != nil {
+			return nil, err
+		}
+		signers = append(signers, hmacSigner{secret: key, method: method})
+	}
+	return signers, nil
+}
+
+func (h *Handler) tokenSigner() Signer {
+	if h.signer != nil {
+		return h.signer
+	}
+	return hmacSigner{secret: []byte(h.config.JwtSecret)}
+}
+
+// signToken signs the claims with the signer of the handler.
+// Tokens of asymmetric keys get the key id of the published key in the header.
+func signToken(ctx context.Context, signer Signer, claims jwt.Claims) (string, error) {
+	token := jwt.NewWithClaims(signer.SigningMethod(), claims)
+	if kid := keyID(signer); kid != "" {
+		token.Header["kid"] = kid
+	}
+	signingInput, err := token.SigningString()
+	if err != nil {
+		return "", err
+	}
+	signature, err := signer.Sign(ctx, signingInput)
+	if err != nil {
+		return "", err
+	}
+	return signingInput + "." + jwt.EncodeSegment(signature), nil
+}
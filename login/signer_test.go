@@ -0,0 +1,383 @@
+package login
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"io/ioutil"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	. "github.com/stretchr/testify/assert"
+	"github.com/tarent/loginsrv/model"
+)
+
+// ecdsaTestSigner holds the private key in memory, like a kms would do remotely
+type ecdsaTestSigner struct {
+	key *ecdsa.PrivateKey
+	err error
+}
+
+func (s ecdsaTestSigner) SigningMethod() jwt.SigningMethod {
+	return jwt.SigningMethodES256
+}
+
+func (s ecdsaTestSigner) Sign(ctx context.Context, signingInput string) ([]byte, error) {
+	if s.err != nil {
+		return nil, s.err
+	}
+	signature, err := jwt.SigningMethodES256.Sign(signingInput, s.key)
+	if err != nil {
+		return nil, err
+	}
+	return jwt.DecodeSegment(signature)
+}
+
+func (s ecdsaTestSigner) VerificationKey() interface{} {
+	return &s.key.PublicKey
+}
+
+func (s ecdsaTestSigner) PublicKeys() []crypto.PublicKey {
+	return []crypto.PublicKey{&s.key.PublicKey}
+}
+
+func TestHandler_CustomSigner(t *testing.T) {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	h := testHandler()
+	h.signer = ecdsaTestSigner{key: key}
+
+	recorder := httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("POST", "/context/login", "username=bob&password=secret", TypeForm, AcceptJwt))
+	Equal(t, 200, recorder.Code)
+
+	token, err := jwt.ParseWithClaims(recorder.Body.String(), &model.UserInfo{}, func(token *jwt.Token) (interface{}, error) {
+		Equal(t, "ES256", token.Header["alg"])
+		return &key.PublicKey, nil
+	})
+	NoError(t, err)
+	Equal(t, "bob", token.Claims.(*model.UserInfo).Sub)
+
+	// the handler verifies its own tokens
+	userInfo, valid := h.GetToken(req("GET", "/context/login", ""), recorder.Body.String())
+	True(t, valid)
+	Equal(t, "bob", userInfo.Sub)
+
+	// hs512 tokens with the jwt secret are not accepted any more
+	hmacToken, _ := signToken(context.Background(), hmacSigner{secret: []byte(h.config.JwtSecret)}, model.UserInfo{Sub: "bob"})
+	_, valid = h.GetToken(req("GET", "/context/login", ""), hmacToken)
+	False(t, valid)
+}
+
+func TestHandler_SignerError(t *testing.T) {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	h := testHandler()
+	h.signer = ecdsaTestSigner{key: key, err: errors.New("kms unavailable")}
+
+	recorder := httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("POST", "/context/login", "username=bob&password=secret", TypeForm, AcceptJwt))
+	Equal(t, 500, recorder.Code)
+}
+
+func writeRSAKey(t *testing.T, key *rsa.PrivateKey) string {
+	file := filepath.Join(tmpDir(t), "jwt.pem")
+	block := &pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)}
+	NoError(t, ioutil.WriteFile(file, pem.EncodeToMemory(block), 0600))
+	return file
+}
+
+func TestHandler_RSAPrivateKey(t *testing.T) {
+	key, _ := rsa.GenerateKey(rand.Reader, 2048)
+	cfg := DefaultConfig()
+	cfg.Backends = Options{"simple": {"bob": "secret"}}
+	cfg.JwtPrivateKey = writeRSAKey(t, key)
+	cfg.JwtRefreshes = 1
+	h, err := NewHandler(cfg)
+	NoError(t, err)
+
+	recorder := httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("POST", "/login", "username=bob&password=secret", TypeForm, AcceptJwt))
+	Equal(t, 200, recorder.Code)
+
+	// the token can be verified with the public key only
+	token, err := jwt.ParseWithClaims(recorder.Body.String(), &model.UserInfo{}, func(token *jwt.Token) (interface{}, error) {
+		Equal(t, "RS256", token.Header["alg"])
+		return &key.PublicKey, nil
+	})
+	NoError(t, err)
+	Equal(t, "bob", token.Claims.(*model.UserInfo).Sub)
+
+	userInfo, valid := h.GetToken(req("GET", "/login", ""), recorder.Body.String())
+	True(t, valid)
+	Equal(t, "bob", userInfo.Sub)
+
+	// hs512 tokens with the jwt secret are not accepted
+	hmacToken, _ := signToken(context.Background(), hmacSigner{secret: []byte(cfg.JwtSecret)}, model.UserInfo{Sub: "bob", Expiry: time.Now().Add(time.Minute).Unix()})
+	_, valid = h.GetToken(req("GET", "/login", ""), hmacToken)
+	False(t, valid)
+
+	// the refresh accepts the rs256 token and issues a new one
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("POST", "/login", "", AcceptJwt, "Cookie: "+cfg.CookieName+"="+token.Raw))
+	Equal(t, 200, recorder.Code)
+	refreshed, valid := h.GetToken(req("GET", "/login", ""), recorder.Body.String())
+	True(t, valid)
+	Equal(t, 1, refreshed.Refreshes)
+
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("POST", "/login", "", AcceptJwt, "Cookie: "+cfg.CookieName+"="+hmacToken))
+	Equal(t, 400, recorder.Code)
+
+	// the token service verifies the tokens as well
+	tokens, err := NewTokenService(cfg)
+	NoError(t, err)
+	_, err = tokens.Verify(token.Raw)
+	NoError(t, err)
+}
+
+func TestHandler_RSAPrivateKey_Invalid(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Backends = Options{"simple": {"bob": "secret"}}
+
+	cfg.JwtPrivateKey = filepath.Join(tmpDir(t), "missing.pem")
+	_, err := NewHandler(cfg)
+	Error(t, err)
+	Contains(t, err.Error(), "Could not read the jwt private key")
+
+	cfg.JwtPrivateKey = filepath.Join(tmpDir(t), "invalid.pem")
+	NoError(t, ioutil.WriteFile(cfg.JwtPrivateKey, []byte("no pem"), 0600))
+	_, err = NewHandler(cfg)
+	EqualError(t, err, "Invalid jwt private key "+cfg.JwtPrivateKey+": no pem data")
+
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P224(), rand.Reader)
+	der, _ := x509.MarshalECPrivateKey(ecKey)
+	NoError(t, ioutil.WriteFile(cfg.JwtPrivateKey, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}), 0600))
+	_, err = NewHandler(cfg)
+	Error(t, err)
+
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 1024)
+	cfg.JwtPrivateKey = writeRSAKey(t, rsaKey)
+	cfg.JwtKMSKey = "alias/loginsrv"
+	_, err = NewHandler(cfg)
+	EqualError(t, err, "Only one of -jwt-private-key and -jwt-kms-key can be configured")
+}
+
+func writeEd25519Key(t *testing.T, key ed25519.PrivateKey) string {
+	file := filepath.Join(tmpDir(t), "jwt-ed25519.pem")
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	NoError(t, err)
+	NoError(t, ioutil.WriteFile(file, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0600))
+	return file
+}
+
+func TestHandler_Ed25519PrivateKey(t *testing.T) {
+	publicKey, key, _ := ed25519.GenerateKey(rand.Reader)
+	cfg := DefaultConfig()
+	cfg.Backends = Options{"simple": {"bob": "secret"}}
+	cfg.JwtPrivateKey = writeEd25519Key(t, key)
+	h, err := NewHandler(cfg)
+	NoError(t, err)
+
+	recorder := httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("POST", "/login", "username=bob&password=secret", TypeForm, AcceptHTML))
+	Equal(t, 303, recorder.Code)
+	cookie := readSetCookies(recorder.Header())[0]
+
+	// the token can be verified with the public key only
+	token, err := jwt.ParseWithClaims(cookie.Value, &model.UserInfo{}, func(token *jwt.Token) (interface{}, error) {
+		Equal(t, "EdDSA", token.Header["alg"])
+		return publicKey, nil
+	})
+	NoError(t, err)
+	Equal(t, "bob", token.Claims.(*model.UserInfo).Sub)
+
+	// with the cookie, the user is shown as authenticated
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("GET", "/login", "", AcceptHTML, "Cookie: "+cookie.Name+"="+cookie.Value))
+	Equal(t, 200, recorder.Code)
+	Contains(t, recorder.Body.String(), "Welcome bob!")
+
+	// a token of another key or algorithm is not accepted
+	_, otherKey, _ := ed25519.GenerateKey(rand.Reader)
+	otherToken, _ := signToken(context.Background(), ed25519Signer{key: otherKey}, model.UserInfo{Sub: "bob", Expiry: time.Now().Add(time.Minute).Unix()})
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("GET", "/login", "", AcceptHTML, "Cookie: "+cookie.Name+"="+otherToken))
+	NotContains(t, recorder.Body.String(), "Welcome bob!")
+	hmacToken, _ := signToken(context.Background(), hmacSigner{secret: []byte(cfg.JwtSecret)}, model.UserInfo{Sub: "bob", Expiry: time.Now().Add(time.Minute).Unix()})
+	_, valid := h.GetToken(req("GET", "/login", ""), hmacToken)
+	False(t, valid)
+
+	// the token service of other services verifies the tokens as well
+	tokens, err := NewTokenService(cfg)
+	NoError(t, err)
+	userInfo, err := tokens.Verify(cookie.Value)
+	NoError(t, err)
+	Equal(t, "bob", userInfo.Sub)
+}
+
+func TestSigningMethodEdDSA(t *testing.T) {
+	publicKey, key, _ := ed25519.GenerateKey(rand.Reader)
+	signature, err := signingMethodEdDSA.Sign("header.claims", key)
+	NoError(t, err)
+	NoError(t, signingMethodEdDSA.Verify("header.claims", signature, publicKey))
+	Equal(t, jwt.ErrSignatureInvalid, signingMethodEdDSA.Verify("header.other", signature, publicKey))
+
+	// wrong key types
+	_, err = signingMethodEdDSA.Sign("header.claims", publicKey)
+	Equal(t, jwt.ErrInvalidKeyType, err)
+	Equal(t, jwt.ErrInvalidKeyType, signingMethodEdDSA.Verify("header.claims", signature, []byte("secret")))
+	Equal(t, signingMethodEdDSA, jwt.GetSigningMethod("EdDSA"))
+}
+
+func TestLoadPrivateKeySigner_Errors(t *testing.T) {
+	// a broken ed25519 key, which does not match its public key
+	file := filepath.Join(tmpDir(t), "broken.pem")
+	_, key, _ := ed25519.GenerateKey(rand.Reader)
+	broken := append(ed25519.PrivateKey{}, key...)
+	broken[40] ^= 0xff
+	NoError(t, checkSigner(ed25519Signer{key: key}))
+	Error(t, checkSigner(ed25519Signer{key: broken}))
+
+	// ecdsa keys on curves without a jwt algorithm are rejected
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P224(), rand.Reader)
+	der, _ := x509.MarshalPKCS8PrivateKey(ecKey)
+	NoError(t, ioutil.WriteFile(file, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0600))
+	_, err := loadPrivateKeySigner(file)
+	EqualError(t, err, "Invalid jwt private key "+file+": unsupported curve P-224, supported are P-256, P-384 and P-521")
+
+	NoError(t, ioutil.WriteFile(file, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: []byte("garbage")}), 0600))
+	_, err = loadPrivateKeySigner(file)
+	EqualError(t, err, "Invalid jwt private key "+file+": expected a PKCS1 or PKCS8 rsa key, a SEC1 or PKCS8 ecdsa key or a PKCS8 ed25519 key")
+}
+
+func TestHandler_JwtAlgo(t *testing.T) {
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	rsaFile := writeRSAKey(t, rsaKey)
+
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	der, _ := x509.MarshalECPrivateKey(ecKey)
+	ecFile := filepath.Join(tmpDir(t), "ec.pem")
+	NoError(t, ioutil.WriteFile(ecFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}), 0600))
+
+	tests := []struct {
+		algo       string
+		privateKey string
+		expected   string
+		err        string
+	}{
+		{"", "", "HS512", ""},
+		{"HS256", "", "HS256", ""},
+		{"hs384", "", "HS384", ""},
+		{"", rsaFile, "RS256", ""},
+		{"RS512", rsaFile, "RS512", ""},
+		{"PS256", rsaFile, "PS256", ""},
+		{"", ecFile, "ES384", ""},
+		{"ES384", ecFile, "ES384", ""},
+		{"RS256", "", "", "The jwt algorithm RS256 requires -jwt-private-key or -jwt-kms-key, the jwt secret supports HS256, HS384 and HS512"},
+		{"HS512", rsaFile, "", "The jwt algorithm HS512 does not match the rsa private key, which supports RS256, RS384, RS512, PS256, PS384 and PS512"},
+		{"ES256", ecFile, "", "The jwt algorithm ES256 does not match the key, which supports ES384"},
+		{"none", "", "", "No such jwt algorithm: none, supported algorithms: HS256, HS384, HS512, RS256, RS384, RS512, PS256, PS384, PS512, ES256, ES384, ES512, EdDSA"},
+	}
+	for _, test := range tests {
+		t.Run(test.algo+" "+test.expected, func(t *testing.T) {
+			cfg := DefaultConfig()
+			cfg.Backends = Options{"simple": {"bob": "secret"}}
+			cfg.JwtAlgo = test.algo
+			cfg.JwtPrivateKey = test.privateKey
+			h, err := NewHandler(cfg)
+			if test.err != "" {
+				EqualError(t, err, test.err)
+				return
+			}
+			NoError(t, err)
+			Equal(t, test.expected, cfg.JwtAlgo)
+
+			recorder := httptest.NewRecorder()
+			h.ServeHTTP(recorder, req("POST", "/login", "username=bob&password=secret", TypeForm, AcceptJwt))
+			Equal(t, 200, recorder.Code)
+			token, _, err := new(jwt.Parser).ParseUnverified(recorder.Body.String(), &model.UserInfo{})
+			NoError(t, err)
+			Equal(t, test.expected, token.Header["alg"])
+
+			_, valid := h.GetToken(req("GET", "/login", ""), recorder.Body.String())
+			True(t, valid)
+		})
+	}
+}
+
+func TestHandler_JwtAlgo_NoDowngrade(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Backends = Options{"simple": {"bob": "secret"}}
+	h, err := NewHandler(cfg)
+	NoError(t, err)
+
+	// a token signed with the jwt secret, but another hmac algorithm, is rejected
+	userInfo := model.UserInfo{Sub: "bob", Expiry: time.Now().Add(time.Minute).Unix()}
+	hs256, _ := jwt.NewWithClaims(jwt.SigningMethodHS256, userInfo).SignedString([]byte(cfg.JwtSecret))
+	_, valid := h.GetToken(req("GET", "/login", ""), hs256)
+	False(t, valid)
+
+	hs512, _ := jwt.NewWithClaims(jwt.SigningMethodHS512, userInfo).SignedString([]byte(cfg.JwtSecret))
+	_, valid = h.GetToken(req("GET", "/login", ""), hs512)
+	True(t, valid)
+
+	cfg = DefaultConfig()
+	cfg.Backends = Options{"simple": {"bob": "secret"}}
+	cfg.JwtAlgo = "HS256"
+	h, err = NewHandler(cfg)
+	NoError(t, err)
+	hs512, _ = jwt.NewWithClaims(jwt.SigningMethodHS512, userInfo).SignedString([]byte(cfg.JwtSecret))
+	_, valid = h.GetToken(req("GET", "/login", ""), hs512)
+	False(t, valid)
+}
+
+func TestHandler_AlgorithmConfusion(t *testing.T) {
+	key, _ := rsa.GenerateKey(rand.Reader, 2048)
+	cfg := DefaultConfig()
+	cfg.Backends = Options{"simple": {"bob": "secret"}}
+	cfg.JwtPrivateKey = writeRSAKey(t, key)
+	h, err := NewHandler(cfg)
+	NoError(t, err)
+	userInfo := model.UserInfo{Sub: "bob", Expiry: time.Now().Add(time.Minute).Unix()}
+
+	// hmac tokens signed with the public key as secret, e.g. taken from the jwks
+	der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	NoError(t, err)
+	publicPEM := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})
+	for _, secret := range [][]byte{publicPEM, der, x509.MarshalPKCS1PublicKey(&key.PublicKey)} {
+		for _, method := range []jwt.SigningMethod{jwt.SigningMethodHS256, jwt.SigningMethodHS512} {
+			forged, err := jwt.NewWithClaims(method, userInfo).SignedString(secret)
+			NoError(t, err)
+			_, failure := h.VerifyToken(req("GET", "/login", ""), forged)
+			Equal(t, TokenInvalidSignature, failure)
+		}
+	}
+
+	// unsigned tokens
+	none, err := jwt.NewWithClaims(jwt.SigningMethodNone, userInfo).SignedString(jwt.UnsafeAllowNoneSignatureType)
+	NoError(t, err)
+	_, valid := h.GetToken(req("GET", "/login", ""), none)
+	False(t, valid)
+	_, valid = testHandler().GetToken(req("GET", "/login", ""), none)
+	False(t, valid)
+
+	// tokens of another asymmetric algorithm with the same key
+	ps256, err := jwt.NewWithClaims(jwt.SigningMethodPS256, userInfo).SignedString(key)
+	NoError(t, err)
+	_, valid = h.GetToken(req("GET", "/login", ""), ps256)
+	False(t, valid)
+
+	rs256, err := jwt.NewWithClaims(jwt.SigningMethodRS256, userInfo).SignedString(key)
+	NoError(t, err)
+	_, valid = h.GetToken(req("GET", "/login", ""), rs256)
+	True(t, valid)
+}
@@ -3,6 +3,7 @@ package login
 import (
 	"context"
 	"errors"
+	"sort"
 
 	"github.com/tarent/loginsrv/model"
 )
@@ -13,8 +14,9 @@ const SimpleProviderName = "simple"
 func init() {
 	RegisterProvider(
 		&ProviderDescription{
-			Name:     SimpleProviderName,
-			HelpText: "Simple login backend opts: user1=password,user2=password,..",
+			Name:            SimpleProviderName,
+			HelpText:        "Simple login backend opts: user1=password,user2=password,..",
+			SensitiveValues: true,
 		},
 		SimpleBackendFactory)
 }
@@ -55,3 +57,25 @@ func (sb *SimpleBackend) Authenticate(username, password string) (bool, model.Us
 func (sb *SimpleBackend) AuthenticateWithContext(ctx context.Context, username, password string) (bool, model.UserInfo, error) {
 	return sb.Authenticate(username, password)
 }
+
+// ListUsers returns the configured usernames in alphabetical order
+func (sb *SimpleBackend) ListUsers(offset, limit int) ([]string, error) {
+	return pageUsernames(sb.userPassword, offset, limit), nil
+}
+
+func pageUsernames(users map[string]string, offset, limit int) []string {
+	usernames := make([]string, 0, len(users))
+	for u := range users {
+		usernames = append(usernames, u)
+	}
+	sort.Strings(usernames)
+
+	if offset >= len(usernames) {
+		return []string{}
+	}
+	end := offset + limit
+	if end > len(usernames) {
+		end = len(usernames)
+	}
+	return usernames[offset:end]
+}
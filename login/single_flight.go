@@ -0,0 +1,92 @@
+package login
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/tarent/loginsrv/model"
+)
+
+// the time, in which an identical login submission is treated as the same attempt
+var duplicateLoginWindow = time.Second
+
+type authResult struct {
+	authenticated bool
+	userInfo      model.UserInfo
+	err           error
+}
+
+type loginFlight struct {
+	wg       sync.WaitGroup
+	result   authResult
+	finished time.Time
+}
+
+// loginDeduplicator ensures, that concurrent identical login submissions
+// (e.g. a double click on the submit button) share one backend call.
+// Successful results are reused for identical submissions within the duplicateLoginWindow.
+// Only the result of the backends is shared: each submission gets its own token with its own jti,
+// so that revoking one of them does not end the other sessions.
+// The zero value is ready to use.
+type loginDeduplicator struct {
+	mu      sync.Mutex
+	flights map[string]*loginFlight
+}
+
+// do calls authenticate once for all concurrent or recent calls with the same key.
+// The shared return value is true, if the result was taken from another call.
+func (d *loginDeduplicator) do(key string, authenticate func() authResult) (result authResult, shared bool) {
+	d.mu.Lock()
+	if d.flights == nil {
+		d.flights = map[string]*loginFlight{}
+	}
+	d.removeExpired()
+	if f, exist := d.flights[key]; exist {
+		d.mu.Unlock()
+		f.wg.Wait()
+		return f.result, true
+	}
+
+	f := &loginFlight{}
+	f.wg.Add(1)
+	d.flights[key] = f
+	d.mu.Unlock()
+
+	f.result = authenticate()
+
+	d.mu.Lock()
+	f.finished = time.Now()
+	if f.result.err != nil {
+		// errors are not reused, so that a retry reaches the backend again
+		delete(d.flights, key)
+	}
+	d.mu.Unlock()
+	f.wg.Done()
+
+	return f.result, false
+}
+
+// removeExpired has to be called with the lock held
+func (d *loginDeduplicator) removeExpired() {
+	for key, f := range d.flights {
+		if !f.finished.IsZero() && time.Since(f.finished) > duplicateLoginWindow {
+			delete(d.flights, key)
+		}
+	}
+}
+
+// loginKey identifies a login submission by the credentials and the client address,
+// without keeping the password in memory. The username is taken exactly as submitted, like the backends get it,
+// so e.g. "bob" and "bob " are different submissions, which may have different results.
+func loginKey(r *http.Request, username, password string) string {
+	client, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		client = r.RemoteAddr
+	}
+	hash := sha256.Sum256([]byte(username + "\x00" + password + "\x00" + client))
+	return hex.EncodeToString(hash[:])
+}
@@ -0,0 +1,170 @@
+package login
+
+import (
+	"context"
+	. "github.com/stretchr/testify/assert"
+	"github.com/tarent/loginsrv/model"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type slowTestBackend struct {
+	calls int32
+	delay time.Duration
+}
+
+func (b *slowTestBackend) Authenticate(username, password string) (bool, model.UserInfo, error) {
+	atomic.AddInt32(&b.calls, 1)
+	time.Sleep(b.delay)
+	if username == "bob" && password == "secret" {
+		return true, model.UserInfo{Sub: username}, nil
+	}
+	return false, model.UserInfo{}, nil
+}
+
+func (b *slowTestBackend) AuthenticateWithContext(ctx context.Context, username, password string) (bool, model.UserInfo, error) {
+	return b.Authenticate(username, password)
+}
+
+func TestSingleFlight_ConcurrentLogins(t *testing.T) {
+	backend := &slowTestBackend{delay: 100 * time.Millisecond}
+	h := testHandler()
+	h.backends = []Backend{backend}
+
+	bodies := make([]string, 5)
+	codes := make([]int, 5)
+	wg := sync.WaitGroup{}
+	for i := range bodies {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			recorder := httptest.NewRecorder()
+			h.ServeHTTP(recorder, req("POST", "/context/login", `{"username": "bob", "password": "secret"}`, TypeJSON, AcceptJwt))
+			codes[i] = recorder.Code
+			bodies[i] = recorder.Body.String()
+		}(i)
+	}
+	wg.Wait()
+
+	Equal(t, int32(1), atomic.LoadInt32(&backend.calls))
+	for i := range bodies {
+		Equal(t, 200, codes[i])
+		claims, err := tokenAsMap(bodies[i])
+		NoError(t, err)
+		Equal(t, "bob", claims["sub"])
+	}
+
+	// a different password is a different attempt
+	recorder := httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("POST", "/context/login", `{"username": "bob", "password": "wrong"}`, TypeJSON, AcceptJwt))
+	Equal(t, 403, recorder.Code)
+	Equal(t, int32(2), atomic.LoadInt32(&backend.calls))
+}
+
+func TestSingleFlight_ExactUsernames(t *testing.T) {
+	backend := &slowTestBackend{}
+	h := testHandler()
+	h.backends = []Backend{backend}
+
+	ids := map[interface{}]bool{}
+	for i := 0; i < 3; i++ {
+		recorder := httptest.NewRecorder()
+		h.ServeHTTP(recorder, req("POST", "/context/login", "username=bob&password=secret", TypeForm, AcceptJwt))
+		Equal(t, 200, recorder.Code)
+		claims, err := tokenAsMap(recorder.Body.String())
+		NoError(t, err)
+		Equal(t, "bob", claims["sub"])
+		// the result of the backend is shared, but each submission gets its own token
+		NotEmpty(t, claims["jti"])
+		ids[claims["jti"]] = true
+	}
+	Equal(t, int32(1), atomic.LoadInt32(&backend.calls))
+	Equal(t, 3, len(ids))
+
+	// the backends get the usernames as submitted, so other spellings are other submissions
+	for i, username := range []string{"bob ", "\tbob", "Bob"} {
+		recorder := httptest.NewRecorder()
+		h.ServeHTTP(recorder, req("POST", "/context/login", "username="+url.QueryEscape(username)+"&password=secret", TypeForm, AcceptJwt))
+		Equal(t, 403, recorder.Code, username)
+		Equal(t, int32(i+2), atomic.LoadInt32(&backend.calls), username)
+	}
+}
+
+func TestSingleFlight_Window(t *testing.T) {
+	defer func(window time.Duration) { duplicateLoginWindow = window }(duplicateLoginWindow)
+	duplicateLoginWindow = 50 * time.Millisecond
+
+	calls := 0
+	d := loginDeduplicator{}
+	authenticate := func() authResult {
+		calls++
+		return authResult{authenticated: true}
+	}
+
+	_, shared := d.do("key", authenticate)
+	False(t, shared)
+
+	// identical submission within the window
+	_, shared = d.do("key", authenticate)
+	True(t, shared)
+	Equal(t, 1, calls)
+
+	// after the window
+	time.Sleep(60 * time.Millisecond)
+	_, shared = d.do("key", authenticate)
+	False(t, shared)
+	Equal(t, 2, calls)
+}
+
+func TestSingleFlight_ErrorsAreNotReused(t *testing.T) {
+	calls := 0
+	d := loginDeduplicator{}
+	failing := func() authResult {
+		calls++
+		return authResult{err: context.DeadlineExceeded}
+	}
+
+	d.do("key", failing)
+	_, shared := d.do("key", failing)
+	False(t, shared)
+	Equal(t, 2, calls)
+}
+
+func TestHandler_CanceledBeforeAuthentication(t *testing.T) {
+	backend := &slowTestBackend{delay: 10 * time.Millisecond}
+	h := testHandler()
+	h.backends = []Backend{backend}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	r := req("POST", "/context/login", "username=bob&password=secret", TypeForm, AcceptJwt).WithContext(ctx)
+
+	recorder := httptest.NewRecorder()
+	h.ServeHTTP(recorder, r)
+	Equal(t, statusClientClosedRequest, recorder.Code)
+	Equal(t, int32(0), atomic.LoadInt32(&backend.calls))
+}
+
+func TestHandler_CanceledBetweenBackends(t *testing.T) {
+	first := &slowTestBackend{delay: 100 * time.Millisecond}
+	second := &slowTestBackend{}
+	h := testHandler()
+	h.backends = []Backend{first, second}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	r := req("POST", "/context/login", "username=alice&password=secret", TypeForm, AcceptJwt).WithContext(ctx)
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		cancel()
+	}()
+
+	recorder := httptest.NewRecorder()
+	h.ServeHTTP(recorder, r)
+	Equal(t, statusClientClosedRequest, recorder.Code)
+	Equal(t, int32(1), atomic.LoadInt32(&first.calls))
+	Equal(t, int32(0), atomic.LoadInt32(&second.calls))
+}
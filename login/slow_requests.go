@@ -0,0 +1,149 @@
+package login
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/opentracing/opentracing-go"
+	"github.com/tarent/loginsrv/logging"
+	"github.com/tarent/logrus"
+)
+
+type requestTimingsKey struct{}
+
+// phaseTiming is the duration of one phase of the request processing
+type phaseTiming struct {
+	Name     string `json:"name"`
+	Duration int64  `json:"duration"`
+}
+
+// requestTimings collects the durations of the phases of one request
+type requestTimings struct {
+	mu     sync.Mutex
+	start  time.Time
+	phases []phaseTiming
+}
+
+func newRequestTimings() *requestTimings {
+	return &requestTimings{start: time.Now()}
+}
+
+func withRequestTimings(ctx context.Context, timings *requestTimings) context.Context {
+	return context.WithValue(ctx, requestTimingsKey{}, timings)
+}
+
+// add records the duration of a phase. Repeated phases of the same name are summed up.
+func (t *requestTimings) add(name string, duration time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for i := range t.phases {
+		if t.phases[i].Name == name {
+			t.phases[i].Duration += int64(duration / time.Millisecond)
+			return
+		}
+	}
+	t.phases = append(t.phases, phaseTiming{Name: name, Duration: int64(duration / time.Millisecond)})
+}
+
+func (t *requestTimings) breakdown() []phaseTiming {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return append([]phaseTiming{}, t.phases...)
+}
+
+// startPhase is the instrumentation point for the phases of a login request.
+// The phase is traced as a child span of the span in the context and recorded
+// in the request timings of the context, if there are any.
+// The phase in flight is kept for the report of a request, which exceeds the -max-request-duration.
+// The returned function ends the phase.
+func startPhase(ctx context.Context, name string) func() {
+	start := time.Now()
+	var span opentracing.Span
+	if parent := opentracing.SpanFromContext(ctx); parent != nil {
+		span = parent.Tracer().StartSpan(name, opentracing.ChildOf(parent.Context()))
+	}
+	timings, _ := ctx.Value(requestTimingsKey{}).(*requestTimings)
+	deadline := requestDeadlineOf(ctx)
+	if deadline != nil {
+		deadline.started(name)
+	}
+
+	return func() {
+		if span != nil {
+			span.Finish()
+		}
+		if deadline != nil {
+			deadline.ended(name)
+		}
+		if timings != nil {
+			timings.add(name, time.Since(start))
+		}
+	}
+}
+
+// slowRequestLog logs requests exceeding the threshold
+// with at most limit entries per minute.
+type slowRequestLog struct {
+	threshold time.Duration
+	limit     int
+
+	mu          sync.Mutex
+	windowStart time.Time
+	logged      int
+	suppressed  int
+}
+
+func newSlowRequestLog(threshold time.Duration, limit int) *slowRequestLog {
+	if threshold <= 0 {
+		return nil
+	}
+	return &slowRequestLog{threshold: threshold, limit: limit}
+}
+
+// sample returns true, if an entry may be logged now.
+// The second value is the number of entries suppressed in the previous window.
+func (l *slowRequestLog) sample(now time.Time) (bool, int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	suppressed := 0
+	if now.Sub(l.windowStart) >= time.Minute {
+		suppressed = l.suppressed
+		l.windowStart = now
+		l.logged = 0
+		l.suppressed = 0
+	}
+	if l.limit > 0 && l.logged >= l.limit {
+		l.suppressed++
+		return false, 0
+	}
+	l.logged++
+	return true, suppressed
+}
+
+// check logs the timings of the request, if it took longer than the threshold
+func (l *slowRequestLog) check(r *http.Request, timings *requestTimings) {
+	duration := time.Since(timings.start)
+	if duration < l.threshold {
+		return
+	}
+	ok, suppressed := l.sample(time.Now())
+	if !ok {
+		return
+	}
+
+	fields := logrus.Fields{
+		"type":      "slow_request",
+		"method":    r.Method,
+		"url":       r.URL.Path,
+		"duration":  int64(duration / time.Millisecond),
+		"threshold": int64(l.threshold / time.Millisecond),
+		"phases":    timings.breakdown(),
+	}
+	if suppressed > 0 {
+		fields["suppressed"] = suppressed
+	}
+	logging.Application(r.Header).WithFields(fields).Warn("slow login request")
+}
@@ -0,0 +1,96 @@
+package login
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/mocktracer"
+	. "github.com/stretchr/testify/assert"
+	"github.com/tarent/loginsrv/logging"
+)
+
+func TestSlowRequestLog_Sample(t *testing.T) {
+	l := newSlowRequestLog(time.Second, 2)
+	now := time.Now()
+
+	ok, _ := l.sample(now)
+	True(t, ok)
+	ok, _ = l.sample(now.Add(time.Second))
+	True(t, ok)
+	ok, _ = l.sample(now.Add(2 * time.Second))
+	False(t, ok)
+	ok, _ = l.sample(now.Add(3 * time.Second))
+	False(t, ok)
+
+	// the next minute reports the suppressed entries
+	ok, suppressed := l.sample(now.Add(time.Minute))
+	True(t, ok)
+	Equal(t, 2, suppressed)
+
+	Nil(t, newSlowRequestLog(0, 10))
+}
+
+func TestStartPhase(t *testing.T) {
+	tracer := mocktracer.New()
+	parent := tracer.StartSpan("HTTP POST /login")
+	timings := newRequestTimings()
+	ctx := withRequestTimings(opentracing.ContextWithSpan(context.Background(), parent), timings)
+
+	startPhase(ctx, "parse")()
+	startPhase(ctx, "backend:htpasswd")()
+	startPhase(ctx, "parse")()
+
+	// repeated phases are merged
+	phases := timings.breakdown()
+	Equal(t, 2, len(phases))
+	Equal(t, "parse", phases[0].Name)
+	Equal(t, "backend:htpasswd", phases[1].Name)
+
+	// the same phases are traced
+	spans := tracer.FinishedSpans()
+	Equal(t, 3, len(spans))
+	Equal(t, "backend:htpasswd", spans[1].OperationName)
+	Equal(t, parent.Context().(mocktracer.MockSpanContext).SpanID, spans[1].ParentID)
+
+	// without timings and span, nothing happens
+	startPhase(context.Background(), "parse")()
+}
+
+func TestHandler_SlowRequestLog(t *testing.T) {
+	b := bytes.NewBuffer(nil)
+	logging.Logger.Out = b
+	defer func() { logging.Logger.Out = os.Stderr }()
+
+	h := testHandler()
+	h.backendNames = []string{"simple"}
+	h.slowRequests = newSlowRequestLog(time.Nanosecond, 10)
+
+	recorder := httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("POST", "/context/login", "username=bob&password=secret", TypeForm, AcceptJwt))
+	Equal(t, 200, recorder.Code)
+
+	var entry struct {
+		Type    string
+		Message string
+		Phases  []phaseTiming
+	}
+	for _, line := range strings.Split(b.String(), "\n") {
+		if strings.Contains(line, "slow_request") {
+			NoError(t, json.Unmarshal([]byte(line), &entry))
+		}
+	}
+	Equal(t, "slow_request", entry.Type)
+	Equal(t, "slow login request", entry.Message)
+	names := []string{}
+	for _, p := range entry.Phases {
+		names = append(names, p.Name)
+	}
+	Equal(t, []string{"parse", "backend:simple", "enrich", "sign", "write"}, names)
+}
@@ -0,0 +1,66 @@
+package login
+
+import (
+	"strconv"
+	"time"
+)
+
+// Store keeps the state, which is shared by the requests and has to outlive them:
+// the revocations, the failed logins and the sessions.
+// The entries are grouped by namespaces and expire after their ttl.
+// The handler keeps them in memory by default, a FileStore keeps them across restarts.
+type Store interface {
+	// Set writes the value of the key, which expires after the ttl
+	Set(namespace, key, value string, ttl time.Duration) error
+	// Get returns the value of the key, if it exists and is not expired
+	Get(namespace, key string) (string, bool, error)
+	// Increment counts the key up and returns the new count.
+	// A missing or expired key starts at 1 with the ttl, an existing key keeps its expiry.
+	// Concurrent increments of a key are never lost.
+	Increment(namespace, key string, ttl time.Duration) (int, error)
+	// Delete deletes the key. A missing key is no error.
+	Delete(namespace, key string) error
+	// Scan calls fn for each entry of the namespace, which is not expired.
+	// fn must not use the store.
+	Scan(namespace string, fn func(key, value string)) error
+}
+
+// memoryStore is the Store in the ttl store of the handler.
+// Its entries are written to the -state-file, if one is configured.
+type memoryStore struct {
+	store *ttlStore
+}
+
+func (m memoryStore) Set(namespace, key, value string, ttl time.Duration) error {
+	m.store.set(namespace, key, value, ttl)
+	return nil
+}
+
+func (m memoryStore) Get(namespace, key string) (string, bool, error) {
+	value, exist := m.store.get(namespace, key)
+	return value, exist, nil
+}
+
+func (m memoryStore) Increment(namespace, key string, ttl time.Duration) (int, error) {
+	count, _ := m.store.increment(namespace, key, ttl)
+	return count, nil
+}
+
+func (m memoryStore) Delete(namespace, key string) error {
+	m.store.delete(namespace, key)
+	return nil
+}
+
+func (m memoryStore) Scan(namespace string, fn func(key, value string)) error {
+	m.store.scan(namespace, fn)
+	return nil
+}
+
+// storeCount returns the count of an entry of Increment, 0 for a missing entry
+func storeCount(store Store, namespace, key string) (int, error) {
+	value, exist, err := store.Get(namespace, key)
+	if err != nil || !exist {
+		return 0, err
+	}
+	return strconv.Atoi(value)
+}
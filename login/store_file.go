@@ -0,0 +1,335 @@
+package login
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tarent/loginsrv/logging"
+)
+
+// FileStore is a Store, which keeps the entries in a local file,
+// e.g. for single node installations, where the revocations, lockouts and sessions have to survive a crash.
+//
+// Each change is appended as one checksummed line and synced to disk, before it is reported as done.
+// A record, which was only written partially by a crash, is skipped on opening.
+// The entries are held in memory as well, so reads never wait for a write.
+// Sweep deletes the expired entries and compacts the file.
+type FileStore struct {
+	path string
+
+	// muFile serializes the changes, so that each is written before the next one reads the entries
+	muFile sync.Mutex
+	file   *os.File
+	closed bool
+	// failed is the error of a failed write. The file may end with a partial record,
+	// so further changes fail until the next compaction rewrites the file.
+	failed error
+
+	mu      sync.RWMutex
+	entries map[string]map[string]ttlEntry
+
+	now func() time.Time
+}
+
+// OpenFileStore opens or creates the store file.
+// The valid records of an existing file are loaded, the expired and corrupt ones are dropped by compacting the file.
+func OpenFileStore(path string) (*FileStore, error) {
+	f := &FileStore{path: path, entries: map[string]map[string]ttlEntry{}, now: time.Now}
+	if err := f.load(); err != nil {
+		return nil, err
+	}
+	if err := f.compact(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (f *FileStore) Set(namespace, key, value string, ttl time.Duration) error {
+	f.muFile.Lock()
+	defer f.muFile.Unlock()
+	return f.write(namespace, key, ttlEntry{Value: value, Expires: f.now().Add(ttl)})
+}
+
+func (f *FileStore) Get(namespace, key string) (string, bool, error) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	e, exist := f.entries[namespace][key]
+	if !exist || !f.now().Before(e.Expires) {
+		return "", false, nil
+	}
+	return e.Value, true, nil
+}
+
+func (f *FileStore) Increment(namespace, key string, ttl time.Duration) (int, error) {
+	f.muFile.Lock()
+	defer f.muFile.Unlock()
+	now := f.now()
+	f.mu.RLock()
+	e, exist := f.entries[namespace][key]
+	f.mu.RUnlock()
+	count := 0
+	if exist && now.Before(e.Expires) {
+		count, _ = strconv.Atoi(e.Value)
+	} else {
+		e.Expires = now.Add(ttl)
+	}
+	count++
+	e.Value = strconv.Itoa(count)
+	if err := f.write(namespace, key, e); err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+func (f *FileStore) Delete(namespace, key string) error {
+	f.muFile.Lock()
+	defer f.muFile.Unlock()
+	f.mu.RLock()
+	_, exist := f.entries[namespace][key]
+	f.mu.RUnlock()
+	if !exist {
+		return nil
+	}
+	return f.write(namespace, key, ttlEntry{})
+}
+
+func (f *FileStore) Scan(namespace string, fn func(key, value string)) error {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	now := f.now()
+	for key, e := range f.entries[namespace] {
+		if now.Before(e.Expires) {
+			fn(key, e.Value)
+		}
+	}
+	return nil
+}
+
+// Sweep deletes the expired entries and rewrites the file without them
+func (f *FileStore) Sweep() error {
+	f.mu.Lock()
+	now := f.now()
+	for _, entries := range f.entries {
+		for key, e := range entries {
+			if !now.Before(e.Expires) {
+				delete(entries, key)
+			}
+		}
+	}
+	f.mu.Unlock()
+	return f.compact()
+}
+
+// Close closes the file. Further changes fail.
+func (f *FileStore) Close() error {
+	f.muFile.Lock()
+	defer f.muFile.Unlock()
+	if f.closed {
+		return nil
+	}
+	f.closed = true
+	return f.file.Close()
+}
+
+// hook returns a hook, which sweeps the file periodically and closes it on shutdown
+func (f *FileStore) hook() Hook {
+	sweep := func() {
+		if err := f.Sweep(); err != nil {
+			logging.Logger.WithError(err).Warn("error compacting the store file")
+		}
+	}
+	task := &backgroundTask{interval: storeSweepInterval, run: sweep}
+	return Hook{
+		Name:  "store-file",
+		Start: task.start,
+		Stop: func(ctx context.Context) error {
+			if err := task.shutdown(ctx); err != nil {
+				return err
+			}
+			return f.Close()
+		},
+	}
+}
+
+// write appends the entry to the file and applies it to the entries. An entry without expiry deletes the key.
+// The caller holds muFile.
+func (f *FileStore) write(namespace, key string, e ttlEntry) error {
+	if f.closed {
+		return fmt.Errorf("store file %v is closed", f.path)
+	}
+	if f.failed != nil {
+		return f.failed
+	}
+	if _, err := f.file.Write(storeRecord(namespace, key, e)); err != nil {
+		f.failed = fmt.Errorf("store file %v failed, the changes are rejected until it is compacted: %v", f.path, err)
+		return err
+	}
+	if err := f.file.Sync(); err != nil {
+		f.failed = fmt.Errorf("store file %v failed, the changes are rejected until it is compacted: %v", f.path, err)
+		return err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.apply(namespace, key, e)
+	return nil
+}
+
+// apply sets or, for an entry without expiry, deletes the entry. The caller holds mu.
+func (f *FileStore) apply(namespace, key string, e ttlEntry) {
+	if e.Expires.IsZero() {
+		delete(f.entries[namespace], key)
+		return
+	}
+	if f.entries[namespace] == nil {
+		f.entries[namespace] = map[string]ttlEntry{}
+	}
+	f.entries[namespace][key] = e
+}
+
+// storeRecord is the line of a change: the expiry in unix nanoseconds (0 for a deletion),
+// the quoted namespace, key and value and the crc32 of all of them
+func storeRecord(namespace, key string, e ttlEntry) []byte {
+	expires := int64(0)
+	if !e.Expires.IsZero() {
+		expires = e.Expires.UnixNano()
+	}
+	data := strings.Join([]string{strconv.FormatInt(expires, 10), strconv.Quote(namespace), strconv.Quote(key), strconv.Quote(e.Value)}, "\t")
+	return []byte(fmt.Sprintf("%v\t%08x\n", data, crc32.ChecksumIEEE([]byte(data))))
+}
+
+func parseStoreRecord(line []byte) (namespace, key string, e ttlEntry, ok bool) {
+	i := bytes.LastIndexByte(line, '\t')
+	if i < 0 {
+		return "", "", ttlEntry{}, false
+	}
+	data := line[:i]
+	checksum, err := strconv.ParseUint(string(line[i+1:]), 16, 32)
+	if err != nil || uint32(checksum) != crc32.ChecksumIEEE(data) {
+		return "", "", ttlEntry{}, false
+	}
+	fields := strings.Split(string(data), "\t")
+	if len(fields) != 4 {
+		return "", "", ttlEntry{}, false
+	}
+	expires, err := strconv.ParseInt(fields[0], 10, 64)
+	if err != nil {
+		return "", "", ttlEntry{}, false
+	}
+	unquoted := make([]string, 3)
+	for i, field := range fields[1:] {
+		if unquoted[i], err = strconv.Unquote(field); err != nil {
+			return "", "", ttlEntry{}, false
+		}
+	}
+	if expires != 0 {
+		e.Expires = time.Unix(0, expires)
+	}
+	e.Value = unquoted[2]
+	return unquoted[0], unquoted[1], e, true
+}
+
+// load replays the valid records of the file. A missing file is no error.
+func (f *FileStore) load() error {
+	file, err := os.Open(f.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	corrupt := 0
+	reader := bufio.NewReader(file)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err == io.EOF {
+			// a record without line end was not completely written
+			if len(line) > 0 {
+				corrupt++
+			}
+			break
+		}
+		if err != nil {
+			return err
+		}
+		namespace, key, e, ok := parseStoreRecord(line[:len(line)-1])
+		if !ok {
+			corrupt++
+			continue
+		}
+		f.apply(namespace, key, e)
+	}
+	if corrupt > 0 {
+		logging.Logger.WithField("file", f.path).WithField("dropped", corrupt).
+			Warn("dropped incomplete or corrupt records of the store file")
+	}
+	return nil
+}
+
+// compact writes the current entries to a new file, which replaces the old one atomically.
+// The new file stays open for the further changes, so they are never appended to the replaced one.
+func (f *FileStore) compact() error {
+	f.muFile.Lock()
+	defer f.muFile.Unlock()
+	if f.closed {
+		return fmt.Errorf("store file %v is closed", f.path)
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(f.path), filepath.Base(f.path)+".tmp")
+	if err != nil {
+		return err
+	}
+	replaced := false
+	defer func() {
+		if !replaced {
+			tmp.Close()
+			os.Remove(tmp.Name())
+		}
+	}()
+
+	writer := bufio.NewWriter(tmp)
+	f.mu.RLock()
+	now := f.now()
+	for namespace, entries := range f.entries {
+		for key, e := range entries {
+			if now.Before(e.Expires) {
+				writer.Write(storeRecord(namespace, key, e))
+			}
+		}
+	}
+	f.mu.RUnlock()
+	if err := writer.Flush(); err != nil {
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), f.path); err != nil {
+		return err
+	}
+	replaced = true
+	if dir, err := os.Open(filepath.Dir(f.path)); err == nil {
+		dir.Sync()
+		dir.Close()
+	}
+
+	if f.file != nil {
+		f.file.Close()
+	}
+	f.file = tmp
+	f.failed = nil
+	return nil
+}
@@ -0,0 +1,174 @@
+package login
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/stretchr/testify/assert"
+)
+
+func storeFile(t *testing.T) (string, func()) {
+	dir, err := ioutil.TempDir("", "loginsrv-store")
+	NoError(t, err)
+	return filepath.Join(dir, "store"), func() { os.RemoveAll(dir) }
+}
+
+func TestFileStore_Revocations_Conformance(t *testing.T) {
+	file, cleanup := storeFile(t)
+	defer cleanup()
+	store, err := OpenFileStore(file)
+	NoError(t, err)
+	defer store.Close()
+	testRevocationStore(t, newStoreRevocations(store))
+}
+
+func TestFileStore_Restart(t *testing.T) {
+	file, cleanup := storeFile(t)
+	defer cleanup()
+	store, err := OpenFileStore(file)
+	NoError(t, err)
+	NoError(t, store.Set(revocationNamespace, "abc", "", time.Hour))
+	NoError(t, store.Set(revocationNamespace, "expired", "", -time.Minute))
+	NoError(t, store.Set(sessionsNamespace, "deleted", "{}", time.Hour))
+	NoError(t, store.Delete(sessionsNamespace, "deleted"))
+	_, err = store.Increment(loginFailureNamespace, "bob", time.Hour)
+	NoError(t, err)
+	_, err = store.Increment(loginFailureNamespace, "bob", time.Hour)
+	NoError(t, err)
+	NoError(t, store.Close())
+	Error(t, store.Set(revocationNamespace, "def", "", time.Hour))
+
+	store, err = OpenFileStore(file)
+	NoError(t, err)
+	defer store.Close()
+	_, exist, err := store.Get(revocationNamespace, "abc")
+	NoError(t, err)
+	True(t, exist)
+	for _, key := range []string{"expired", "def"} {
+		_, exist, _ := store.Get(revocationNamespace, key)
+		False(t, exist, key)
+	}
+	_, exist, _ = store.Get(sessionsNamespace, "deleted")
+	False(t, exist)
+	count, _ := storeCount(store, loginFailureNamespace, "bob")
+	Equal(t, 2, count)
+}
+
+func TestFileStore_PartialWrite(t *testing.T) {
+	file, cleanup := storeFile(t)
+	defer cleanup()
+	store, err := OpenFileStore(file)
+	NoError(t, err)
+	NoError(t, store.Set("ns", "abc", "1", time.Hour))
+	NoError(t, store.Set("ns", "def", "2", time.Hour))
+	NoError(t, store.Close())
+
+	// a crash in the middle of the last record and a corrupted record
+	b, err := ioutil.ReadFile(file)
+	NoError(t, err)
+	partial := string(storeRecord("ns", "ghi", ttlEntry{Value: "3", Expires: time.Now().Add(time.Hour)}))[:10]
+	NoError(t, ioutil.WriteFile(file, []byte(strings.Replace(string(b), `"def"`, `"deg"`, 1)+partial), 0600))
+
+	store, err = OpenFileStore(file)
+	NoError(t, err)
+	_, exist, _ := store.Get("ns", "abc")
+	True(t, exist)
+	for _, key := range []string{"def", "deg", "ghi"} {
+		_, exist, _ := store.Get("ns", key)
+		False(t, exist, key)
+	}
+
+	// the next records are not appended to the incomplete one
+	NoError(t, store.Set("ns", "jkl", "4", time.Hour))
+	NoError(t, store.Close())
+	store, err = OpenFileStore(file)
+	NoError(t, err)
+	defer store.Close()
+	for _, key := range []string{"abc", "jkl"} {
+		_, exist, _ := store.Get("ns", key)
+		True(t, exist, key)
+	}
+}
+
+func TestFileStore_Sweep(t *testing.T) {
+	file, cleanup := storeFile(t)
+	defer cleanup()
+	store, err := OpenFileStore(file)
+	NoError(t, err)
+	defer store.Close()
+	now := time.Now()
+	store.now = func() time.Time { return now }
+	NoError(t, store.Set("ns", "abc", "", time.Hour))
+	NoError(t, store.Set("ns", "def", "", 2*time.Hour))
+
+	now = now.Add(time.Hour)
+	NoError(t, store.Sweep())
+	b, err := ioutil.ReadFile(file)
+	NoError(t, err)
+	NotContains(t, string(b), "abc")
+	Contains(t, string(b), "def")
+
+	// the changes are appended to the compacted file
+	NoError(t, store.Set("ns", "ghi", "", time.Hour))
+	b, _ = ioutil.ReadFile(file)
+	Contains(t, string(b), "ghi")
+}
+
+func TestFileStore_FailedCompaction(t *testing.T) {
+	dir, cleanup := storeFile(t)
+	defer cleanup()
+	NoError(t, os.Mkdir(dir, 0700))
+	file := filepath.Join(dir, "store")
+	store, err := OpenFileStore(file)
+	NoError(t, err)
+	defer store.Close()
+	NoError(t, store.Set("ns", "abc", "", time.Hour))
+
+	// without a temp file, the old file stays in use
+	NoError(t, os.Chmod(dir, 0500))
+	defer os.Chmod(dir, 0700)
+	if store.Sweep() == nil {
+		t.Skip("the directory is writable, e.g. as root")
+	}
+	NoError(t, store.Set("ns", "def", "", time.Hour))
+	b, _ := ioutil.ReadFile(file)
+	Contains(t, string(b), "def")
+}
+
+func TestHandler_StoreFile(t *testing.T) {
+	file, cleanup := storeFile(t)
+	defer cleanup()
+	cfg := testConfig()
+	cfg.Backends = Options{"simple": {"bob": "secret"}}
+	cfg.StoreFile = file
+	h, err := NewHandler(cfg)
+	NoError(t, err)
+	IsType(t, &FileStore{}, h.state)
+	IsType(t, &storeRevocations{}, h.revocations)
+	var names []string
+	for _, hook := range h.Hooks() {
+		names = append(names, hook.Name)
+	}
+	Contains(t, names, "store-file")
+
+	// the failed logins and the revocations are written to the file
+	Equal(t, 403, callHandler(h, req("POST", "/context/login", "username=bob&password=wrong", TypeForm, AcceptJwt)).Code)
+	NoError(t, h.revocations.Revoke("abc", time.Now().Add(time.Hour)))
+	b, err := ioutil.ReadFile(file)
+	NoError(t, err)
+	Contains(t, string(b), loginFailureNamespace)
+	Contains(t, string(b), revocationNamespace)
+
+	// a revocation store of the options is kept
+	h, err = NewHandlerWithOptions(cfg, WithRevocationStore(failingRevocations{}))
+	NoError(t, err)
+	IsType(t, failingRevocations{}, h.revocations)
+
+	cfg.StoreFile = filepath.Join(file, "missing", "store")
+	_, err = NewHandler(cfg)
+	Error(t, err)
+}
@@ -0,0 +1,118 @@
+package login
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	. "github.com/stretchr/testify/assert"
+)
+
+// testStore checks the behaviour every Store has to provide.
+// advance moves the clock of the store forward.
+func testStore(t *testing.T, store Store, advance func(time.Duration)) {
+	// set, get and overwrite
+	_, exist, err := store.Get("ns", "unknown")
+	NoError(t, err)
+	False(t, exist)
+	NoError(t, store.Set("ns", "key", "value", time.Minute))
+	value, exist, err := store.Get("ns", "key")
+	NoError(t, err)
+	True(t, exist)
+	Equal(t, "value", value)
+	NoError(t, store.Set("ns", "key", "other value", time.Minute))
+	value, _, _ = store.Get("ns", "key")
+	Equal(t, "other value", value)
+
+	// the namespaces are separated
+	_, exist, _ = store.Get("other-ns", "key")
+	False(t, exist)
+
+	// values with any characters
+	NoError(t, store.Set("ns", "json\tkey", "{\"a\":\n\"b\"}", time.Minute))
+	value, _, _ = store.Get("ns", "json\tkey")
+	Equal(t, "{\"a\":\n\"b\"}", value)
+
+	// delete
+	NoError(t, store.Delete("ns", "json\tkey"))
+	_, exist, _ = store.Get("ns", "json\tkey")
+	False(t, exist)
+	NoError(t, store.Delete("ns", "unknown"))
+
+	// increment keeps the expiry of the first count
+	count, err := store.Increment("ns", "counter", time.Minute)
+	NoError(t, err)
+	Equal(t, 1, count)
+	advance(30 * time.Second)
+	count, _ = store.Increment("ns", "counter", time.Minute)
+	Equal(t, 2, count)
+	count, err = storeCount(store, "ns", "counter")
+	NoError(t, err)
+	Equal(t, 2, count)
+
+	// scan
+	NoError(t, store.Set("scan", "a", "1", time.Hour))
+	NoError(t, store.Set("scan", "b", "2", time.Hour))
+	NoError(t, store.Set("scan", "c", "3", time.Second))
+	advance(time.Second)
+	var scanned []string
+	NoError(t, store.Scan("scan", func(key, value string) {
+		scanned = append(scanned, key+"="+value)
+	}))
+	sort.Strings(scanned)
+	Equal(t, []string{"a=1", "b=2"}, scanned)
+
+	// expiry
+	advance(30 * time.Second)
+	_, exist, _ = store.Get("ns", "key")
+	False(t, exist)
+	count, _ = storeCount(store, "ns", "counter")
+	Equal(t, 0, count)
+	count, _ = store.Increment("ns", "counter", time.Minute)
+	Equal(t, 1, count, "an expired count starts again")
+
+	// concurrent increments are never lost
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := store.Increment("ns", "concurrent", time.Minute)
+			NoError(t, err)
+		}()
+	}
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			NoError(t, store.Set("ns", fmt.Sprintf("concurrent-%v", i), "x", time.Minute))
+		}(i)
+	}
+	wg.Wait()
+	count, _ = storeCount(store, "ns", "concurrent")
+	Equal(t, 20, count)
+	for i := 0; i < 20; i++ {
+		_, exist, _ := store.Get("ns", fmt.Sprintf("concurrent-%v", i))
+		True(t, exist, i)
+	}
+}
+
+func TestMemoryStore_Conformance(t *testing.T) {
+	ttl := newTTLStore()
+	now := time.Now()
+	ttl.now = func() time.Time { return now }
+	testStore(t, memoryStore{ttl}, func(d time.Duration) { now = now.Add(d) })
+}
+
+func TestFileStore_Conformance(t *testing.T) {
+	file, cleanup := storeFile(t)
+	defer cleanup()
+	store, err := OpenFileStore(file)
+	NoError(t, err)
+	defer store.Close()
+	now := time.Now()
+	store.now = func() time.Time { return now }
+	testStore(t, store, func(d time.Duration) { now = now.Add(d) })
+}
@@ -0,0 +1,151 @@
+package login
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/tarent/loginsrv/model"
+)
+
+// TokenCommand runs the token command, which creates and verifies tokens offline
+// with the configuration of the arguments and the environment like loginsrv:
+//
+//	loginsrv token create -sub service-x -expiry 24h [config options]
+//	loginsrv token verify [config options] <token>
+//
+// Created tokens are signed like the tokens of the login handler and written to out.
+// On verify, the claims of the token and its validity are written to out.
+// It returns the exit code: 0 on success, 1 for invalid tokens or if the token could not be created and 2 on invalid arguments.
+func TokenCommand(args []string, out io.Writer) int {
+	if len(args) == 0 {
+		fmt.Fprintln(out, "usage: loginsrv token create|verify [options]")
+		return 2
+	}
+	switch args[0] {
+	case "create":
+		return createTokenCommand(args[1:], out)
+	case "verify":
+		return verifyTokenCommand(args[1:], out)
+	}
+	fmt.Fprintf(out, "unknown token command %q, use create or verify\n", args[0])
+	return 2
+}
+
+func createTokenCommand(args []string, out io.Writer) int {
+	f := flag.NewFlagSet("token create", flag.ContinueOnError)
+	f.SetOutput(out)
+	userInfo := model.UserInfo{}
+	var expiry time.Duration
+	var groups string
+	f.StringVar(&userInfo.Sub, "sub", "", "The subject of the token, e.g. the name of a service account")
+	f.StringVar(&userInfo.Origin, "origin", "", "The origin of the token")
+	f.StringVar(&userInfo.Name, "name", "", "The name of the user")
+	f.StringVar(&userInfo.Email, "email", "", "The email of the user")
+	f.StringVar(&groups, "groups", "", "Comma separated groups of the user")
+	f.DurationVar(&expiry, "expiry", 0, "The expiry of the token, e.g. 24h. The -jwt-expiry, if not set")
+	config, err := readConfig(f, args)
+	if err != nil {
+		return 2
+	}
+	if userInfo.Sub == "" {
+		fmt.Fprintln(out, "missing -sub")
+		return 2
+	}
+	if expiry < 0 {
+		fmt.Fprintln(out, "the expiry must not be negative")
+		return 2
+	}
+	tokens, err := newCommandTokenService(config)
+	if err != nil {
+		fmt.Fprintln(out, err)
+		return 1
+	}
+
+	for _, group := range strings.Split(groups, ",") {
+		if group = strings.TrimSpace(group); group != "" {
+			userInfo.Groups = append(userInfo.Groups, group)
+		}
+	}
+	if expiry > 0 {
+		userInfo.Expiry = time.Now().Add(expiry).Unix()
+	}
+	userInfo.SessionStart = time.Now().Unix()
+	if config.JwtRefreshTokenExpiry > 0 {
+		userInfo.TokenType = model.TokenTypeAccess
+	}
+	// like the tokens of the handler, with an id to revoke it
+	if userInfo.ID, err = newTokenID(); err != nil {
+		fmt.Fprintln(out, err)
+		return 1
+	}
+	token, err := tokens.Issue(userInfo)
+	if err != nil {
+		fmt.Fprintln(out, err)
+		return 1
+	}
+	fmt.Fprintln(out, token)
+	return 0
+}
+
+func verifyTokenCommand(args []string, out io.Writer) int {
+	f := flag.NewFlagSet("token verify", flag.ContinueOnError)
+	f.SetOutput(out)
+	config, err := readConfig(f, args)
+	if err != nil {
+		return 2
+	}
+	if f.NArg() != 1 {
+		fmt.Fprintln(out, "usage: loginsrv token verify [options] <token>")
+		return 2
+	}
+	tokens, err := newCommandTokenService(config)
+	if err != nil {
+		fmt.Fprintln(out, err)
+		return 1
+	}
+	token, err := tokens.decrypt(f.Arg(0))
+	if err != nil {
+		fmt.Fprintf(out, "invalid: %v\n", err)
+		return 1
+	}
+
+	// the claims are shown also for invalid tokens, e.g. to see the expiry
+	claims := jwt.MapClaims{}
+	if _, _, err := new(jwt.Parser).ParseUnverified(token, claims); err != nil {
+		fmt.Fprintf(out, "invalid: %v\n", err)
+		return 1
+	}
+	b, err := json.MarshalIndent(claims, "", "  ")
+	if err != nil {
+		fmt.Fprintln(out, err)
+		return 1
+	}
+	fmt.Fprintln(out, string(b))
+
+	if _, err := tokens.Verify(token); err != nil {
+		fmt.Fprintf(out, "invalid: %v\n", err)
+		return 1
+	}
+	fmt.Fprintln(out, "valid")
+	return 0
+}
+
+// newCommandTokenService creates the token service of the configuration,
+// which needs a key, because the random default secret and -jwt-secret random are useless outside of the process
+func newCommandTokenService(config *Config) (*TokenService, error) {
+	random := config.JwtSecret == jwtSecretRandom && config.JwtSecretFile == ""
+	tokens, err := NewTokenService(config)
+	if err != nil {
+		return nil, err
+	}
+	if (config.JwtSecret == jwtDefaultSecret || random) && config.JwtPrivateKey == "" && config.JwtKMSKey == "" {
+		return nil, errors.New("no -jwt-secret, -jwt-secret-file, -jwt-private-key or -jwt-kms-key configured")
+	}
+	return tokens, nil
+}
@@ -0,0 +1,86 @@
+package login
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/stretchr/testify/assert"
+	"github.com/tarent/loginsrv/model"
+)
+
+func TestTokenCommand_CreateAndVerify(t *testing.T) {
+	out := &bytes.Buffer{}
+	Equal(t, 0, TokenCommand([]string{"create", "-sub", "service-x", "-expiry", "2h", "-groups", "ops, deploy", "-jwt-secret", "cli-secret"}, out))
+	token := strings.TrimSpace(out.String())
+
+	// the token is accepted by the handler of the same configuration
+	cfg := testConfig()
+	cfg.Backends = Options{"simple": {"bob": "secret"}}
+	cfg.JwtSecret = "cli-secret"
+	h, err := NewHandler(cfg)
+	NoError(t, err)
+	userInfo, failure := h.verifyToken(req("GET", "/context/login", "", "Authorization: Bearer "+token), "")
+	Equal(t, TokenFailure(""), failure)
+	Equal(t, "service-x", userInfo.Sub)
+	Equal(t, []string{"ops", "deploy"}, userInfo.Groups)
+	InDelta(t, time.Now().Add(2*time.Hour).Unix(), userInfo.Expiry, 2)
+	NotEmpty(t, userInfo.ID)
+
+	out.Reset()
+	Equal(t, 0, TokenCommand([]string{"verify", "-jwt-secret", "cli-secret", token}, out))
+	Contains(t, out.String(), `"sub": "service-x"`)
+	True(t, strings.HasSuffix(out.String(), "\nvalid\n"))
+
+	// tokens of other secrets are shown, but invalid
+	out.Reset()
+	Equal(t, 1, TokenCommand([]string{"verify", "-jwt-secret", "other-secret", token}, out))
+	Contains(t, out.String(), `"sub": "service-x"`)
+	Contains(t, out.String(), "invalid: ")
+
+	// expired tokens of the handler
+	expired, err := h.createToken(model.UserInfo{Sub: "bob", Expiry: time.Now().Add(-time.Hour).Unix()})
+	NoError(t, err)
+	out.Reset()
+	Equal(t, 1, TokenCommand([]string{"verify", "-jwt-secret", "cli-secret", expired}, out))
+	Contains(t, out.String(), `"sub": "bob"`)
+
+	out.Reset()
+	Equal(t, 1, TokenCommand([]string{"verify", "-jwt-secret", "cli-secret", "garbage"}, out))
+
+	// with a jwe key, the tokens are encrypted
+	out.Reset()
+	Equal(t, 0, TokenCommand([]string{"create", "-sub", "service-x", "-jwt-secret", "cli-secret", "-jwe-key", testJweKey}, out))
+	token = strings.TrimSpace(out.String())
+	True(t, isEncryptedToken(token))
+	out.Reset()
+	Equal(t, 0, TokenCommand([]string{"verify", "-jwt-secret", "cli-secret", "-jwe-key", testJweKey, token}, out))
+	Contains(t, out.String(), `"sub": "service-x"`)
+	True(t, strings.HasSuffix(out.String(), "\nvalid\n"))
+	out.Reset()
+	Equal(t, 1, TokenCommand([]string{"verify", "-jwt-secret", "cli-secret", token}, out))
+}
+
+func TestTokenCommand_InvalidArguments(t *testing.T) {
+	out := &bytes.Buffer{}
+	Equal(t, 2, TokenCommand(nil, out))
+	Equal(t, 2, TokenCommand([]string{"delete"}, out))
+	Equal(t, 2, TokenCommand([]string{"create", "-jwt-secret", "cli-secret"}, out))
+	Equal(t, 2, TokenCommand([]string{"create", "-sub", "x", "-expiry", "-1h", "-jwt-secret", "cli-secret"}, out))
+	Equal(t, 2, TokenCommand([]string{"create", "-unknown"}, out))
+	Equal(t, 2, TokenCommand([]string{"verify", "-jwt-secret", "cli-secret"}, out))
+
+	// the random default secret is useless
+	if secret, set := os.LookupEnv("LOGINSRV_JWT_SECRET"); set {
+		NoError(t, os.Unsetenv("LOGINSRV_JWT_SECRET"))
+		defer os.Setenv("LOGINSRV_JWT_SECRET", secret)
+	}
+	out.Reset()
+	Equal(t, 1, TokenCommand([]string{"create", "-sub", "x"}, out))
+	Contains(t, out.String(), "no -jwt-secret")
+	out.Reset()
+	Equal(t, 1, TokenCommand([]string{"create", "-sub", "x", "-jwt-secret", "random"}, out))
+	Contains(t, out.String(), "no -jwt-secret")
+}
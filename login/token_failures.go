@@ -0,0 +1,84 @@
+package login
+
+import (
+	"sync/atomic"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/tarent/loginsrv/model"
+)
+
+// TokenFailure is the reason, why a token was rejected.
+// It is meant for logs, metrics and trusted components like a proxy.
+// Untrusted clients should only be told, that the token is invalid.
+type TokenFailure string
+
+// The reasons for rejected tokens
+const (
+	TokenMissing            TokenFailure = "missing"
+	TokenMalformed          TokenFailure = "malformed"
+	TokenInvalidSignature   TokenFailure = "invalid_signature"
+	TokenExpired            TokenFailure = "expired"
+	TokenNotValidYet        TokenFailure = "not_valid_yet"
+	TokenForeignIssuer      TokenFailure = "foreign_issuer"
+	TokenForeignAudience    TokenFailure = "foreign_audience"
+	TokenUnboundCertificate TokenFailure = "unbound_certificate"
+	TokenRevoked            TokenFailure = "revoked"
+	TokenWrongType          TokenFailure = "wrong_type"
+	TokenInvalid            TokenFailure = "invalid"
+)
+
+// tokenFailureOf classifies the error of the token verification.
+// jwt-go checks the claims before the signature, so a bad signature takes precedence
+// over the expiry: a forged token should not be counted as expired.
+func tokenFailureOf(err error) TokenFailure {
+	vErr, ok := err.(*jwt.ValidationError)
+	if !ok {
+		return TokenInvalid
+	}
+	switch {
+	case vErr.Errors&jwt.ValidationErrorMalformed != 0:
+		return TokenMalformed
+	case vErr.Errors&(jwt.ValidationErrorSignatureInvalid|jwt.ValidationErrorUnverifiable) != 0:
+		return TokenInvalidSignature
+	case vErr.Errors&jwt.ValidationErrorExpired != 0 || vErr.Inner == model.ErrTokenExpired:
+		return TokenExpired
+	case vErr.Inner == model.ErrTokenNotValidYet:
+		return TokenNotValidYet
+	}
+	return TokenInvalid
+}
+
+// tokenFailureCounts counts the rejected tokens by reason, except of missing tokens
+type tokenFailureCounts struct {
+	counts map[TokenFailure]*int64
+}
+
+func newTokenFailureCounts() *tokenFailureCounts {
+	c := &tokenFailureCounts{counts: map[TokenFailure]*int64{}}
+	for _, failure := range []TokenFailure{TokenMalformed, TokenInvalidSignature, TokenExpired, TokenNotValidYet, TokenForeignIssuer, TokenForeignAudience, TokenUnboundCertificate, TokenRevoked, TokenWrongType, TokenInvalid} {
+		c.counts[failure] = new(int64)
+	}
+	return c
+}
+
+// add counts the failure and returns it
+func (c *tokenFailureCounts) add(failure TokenFailure) TokenFailure {
+	if count, exist := c.counts[failure]; exist {
+		atomic.AddInt64(count, 1)
+	}
+	return failure
+}
+
+// status returns the counts, which are not zero, or nil
+func (c *tokenFailureCounts) status() map[TokenFailure]int64 {
+	var status map[TokenFailure]int64
+	for failure, count := range c.counts {
+		if n := atomic.LoadInt64(count); n > 0 {
+			if status == nil {
+				status = map[TokenFailure]int64{}
+			}
+			status[failure] = n
+		}
+	}
+	return status
+}
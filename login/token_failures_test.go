@@ -0,0 +1,90 @@
+package login
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	. "github.com/stretchr/testify/assert"
+	"github.com/tarent/loginsrv/model"
+)
+
+func TestHandler_VerifyToken_Failures(t *testing.T) {
+	h := testHandler()
+	h.config.InstanceID = "prod"
+	valid := model.UserInfo{Sub: "bob", Issuer: "prod", Expiry: time.Now().Add(time.Minute).Unix()}
+	expired := model.UserInfo{Sub: "bob", Issuer: "prod", Expiry: time.Now().Add(-time.Minute).Unix()}
+	foreign := model.UserInfo{Sub: "bob", Issuer: "staging", Expiry: time.Now().Add(time.Minute).Unix()}
+
+	sign := func(secret string, claims model.UserInfo) string {
+		token, err := signToken(context.Background(), hmacSigner{secret: []byte(secret)}, claims)
+		NoError(t, err)
+		return token
+	}
+	hs256, _ := jwt.NewWithClaims(jwt.SigningMethodHS256, valid).SignedString([]byte(h.config.JwtSecret))
+
+	tests := []struct {
+		name    string
+		token   string
+		failure TokenFailure
+	}{
+		{"valid", sign(h.config.JwtSecret, valid), ""},
+		{"missing", "", TokenMissing},
+		{"malformed", "not.a.jwt", TokenMalformed},
+		{"bad signature", sign("other", valid), TokenInvalidSignature},
+		{"expired", sign(h.config.JwtSecret, expired), TokenExpired},
+		{"expired with bad signature", sign("other", expired), TokenInvalidSignature},
+		{"other algorithm", hs256, TokenInvalidSignature},
+		{"foreign issuer", sign(h.config.JwtSecret, foreign), TokenForeignIssuer},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			userInfo, failure := h.VerifyToken(req("GET", "/context/login", ""), test.token)
+			Equal(t, test.failure, failure)
+			if test.failure == "" {
+				Equal(t, "bob", userInfo.Sub)
+			} else {
+				Equal(t, model.UserInfo{}, userInfo)
+			}
+		})
+	}
+}
+
+func TestHandler_Health_TokenFailures(t *testing.T) {
+	h := testHandler()
+	expired, _ := signToken(context.Background(), hmacSigner{secret: []byte(h.config.JwtSecret)},
+		model.UserInfo{Sub: "bob", Expiry: time.Now().Add(-time.Minute).Unix()})
+
+	recorder := httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("GET", "/context/login/health", ""))
+	NotContains(t, recorder.Body.String(), "token_failures")
+
+	h.GetToken(req("GET", "/context/login", ""), "")
+	h.GetToken(req("GET", "/context/login", ""), "not.a.jwt")
+	h.GetToken(req("GET", "/context/login", ""), expired)
+	h.GetToken(req("GET", "/context/login", ""), expired)
+
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("GET", "/context/login/health", ""))
+	var status healthStatus
+	NoError(t, json.Unmarshal(recorder.Body.Bytes(), &status))
+	Equal(t, map[TokenFailure]int64{TokenMalformed: 1, TokenExpired: 2}, status.TokenFailures)
+}
+
+func TestHandler_TokenFailure_NotExposed(t *testing.T) {
+	// the login form and the middleware do not tell untrusted clients the reason
+	h := testHandler()
+	expired, _ := signToken(context.Background(), hmacSigner{secret: []byte(h.config.JwtSecret)},
+		model.UserInfo{Sub: "bob", Expiry: time.Now().Add(-time.Minute).Unix()})
+
+	recorder := httptest.NewRecorder()
+	r := req("GET", "/", "")
+	r.Header.Set("Authorization", "Bearer "+expired)
+	h.Middleware(nil).ServeHTTP(recorder, r)
+	Equal(t, 401, recorder.Code)
+	Equal(t, "Bearer", recorder.Header().Get("WWW-Authenticate"))
+	NotContains(t, recorder.Body.String(), "expired")
+}
@@ -0,0 +1,55 @@
+package login
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// reservedTokenHeaders are set by the handler itself and can't carry the token
+var reservedTokenHeaders = []string{"Location", "Set-Cookie", "Content-Type", "Content-Length", "Cache-Control", apiVersionHeader}
+
+// checkTokenHeader validates the name of the -token-header
+func checkTokenHeader(config *Config) error {
+	if config.TokenHeader == "" {
+		if config.TokenHeaderOnGet {
+			return errors.New("The -token-header-on-get requires a -token-header")
+		}
+		return nil
+	}
+	if !isHeaderName(config.TokenHeader) {
+		return fmt.Errorf("Invalid token header %q, only letters, digits and - are allowed", config.TokenHeader)
+	}
+	for _, reserved := range reservedTokenHeaders {
+		if strings.EqualFold(config.TokenHeader, reserved) {
+			return fmt.Errorf("Invalid token header %q, the header is set by loginsrv", config.TokenHeader)
+		}
+	}
+	return nil
+}
+
+// isHeaderName checks, that the name has only letters, digits and -
+func isHeaderName(name string) bool {
+	return name != "" && strings.IndexFunc(name, func(r rune) bool {
+		return !(r == '-' || r >= '0' && r <= '9' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z')
+	}) == -1
+}
+
+// setTokenHeader sets the token in the -token-header of the html login, if configured.
+// The cookie is set anyway, e.g. for a gateway, which forwards the token to apis.
+func (h *Handler) setTokenHeader(w http.ResponseWriter, token string) {
+	if h.config.TokenHeader != "" {
+		w.Header().Set(h.config.TokenHeader, token)
+	}
+}
+
+// setTokenHeaderOnGet sets the token of the cookie in the -token-header on an authenticated GET, if configured
+func (h *Handler) setTokenHeaderOnGet(w http.ResponseWriter, r *http.Request) {
+	if !h.config.TokenHeaderOnGet {
+		return
+	}
+	if c, err := r.Cookie(h.config.CookieName); err == nil {
+		h.setTokenHeader(w, c.Value)
+	}
+}
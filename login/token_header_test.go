@@ -0,0 +1,66 @@
+package login
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	. "github.com/stretchr/testify/assert"
+	"github.com/tarent/loginsrv/model"
+)
+
+func TestHandler_TokenHeader(t *testing.T) {
+	h := testHandler()
+	h.config.TokenHeader = "X-Auth-Token"
+
+	recorder := httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("POST", "/context/login", "username=bob&password=secret", TypeForm, AcceptHTML))
+	Equal(t, 303, recorder.Code)
+	cookies := readSetCookies(recorder.Header())
+	Equal(t, 1, len(cookies))
+	Equal(t, cookies[0].Value, recorder.Header().Get("X-Auth-Token"))
+	claims, err := tokenAsMap(recorder.Header().Get("X-Auth-Token"))
+	NoError(t, err)
+	Equal(t, "bob", claims["sub"])
+
+	// api clients get the token in the body only
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("POST", "/context/login", "username=bob&password=secret", TypeForm, AcceptJwt))
+	Equal(t, 200, recorder.Code)
+	Equal(t, "", recorder.Header().Get("X-Auth-Token"))
+
+	// an authenticated GET sets the header only with -token-header-on-get
+	token, err := h.createToken(model.UserInfo{Sub: "bob", Expiry: time.Now().Add(time.Minute).Unix()})
+	NoError(t, err)
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("GET", "/context/login", "", AcceptHTML, "Cookie: "+h.config.CookieName+"="+token))
+	Equal(t, 200, recorder.Code)
+	Equal(t, "", recorder.Header().Get("X-Auth-Token"))
+
+	h.config.TokenHeaderOnGet = true
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("GET", "/context/login", "", AcceptHTML, "Cookie: "+h.config.CookieName+"="+token))
+	Equal(t, token, recorder.Header().Get("X-Auth-Token"))
+	Equal(t, 0, len(readSetCookies(recorder.Header())))
+
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("GET", "/context/login", "", AcceptHTML, "Cookie: "+h.config.CookieName+"=invalid"))
+	Equal(t, "", recorder.Header().Get("X-Auth-Token"))
+
+	// a renewed cookie of the sliding expiry is set in the header
+	h.config.JwtSlidingExpiry = true
+	h.config.JwtMaxSession = time.Hour
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("GET", "/context/login", "", AcceptHTML, "Cookie: "+h.config.CookieName+"="+token))
+	cookies = readSetCookies(recorder.Header())
+	Equal(t, 1, len(cookies))
+	Equal(t, cookies[0].Value, recorder.Header().Get("X-Auth-Token"))
+}
+
+func TestCheckTokenHeader(t *testing.T) {
+	NoError(t, checkTokenHeader(&Config{}))
+	NoError(t, checkTokenHeader(&Config{TokenHeader: "X-Auth-Token", TokenHeaderOnGet: true}))
+	EqualError(t, checkTokenHeader(&Config{TokenHeaderOnGet: true}), "The -token-header-on-get requires a -token-header")
+	EqualError(t, checkTokenHeader(&Config{TokenHeader: "X Auth"}), `Invalid token header "X Auth", only letters, digits and - are allowed`)
+	EqualError(t, checkTokenHeader(&Config{TokenHeader: "set-cookie"}), `Invalid token header "set-cookie", the header is set by loginsrv`)
+}
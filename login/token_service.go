@@ -0,0 +1,412 @@
+package login
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/tarent/loginsrv/model"
+)
+
+// TokenServiceVersion is the version of the token format and the verification rules of the TokenService.
+// Tokens issued by one version are verified by the same version. It is increased on every change,
+// which breaks this, e.g. of the claims or the algorithm enforcement, so that services embedding
+// the TokenService can be updated together with loginsrv.
+//
+// Version 1: jwt with the claims of model.UserInfo, signed with HS512 and the jwt secret or with the kms key.
+// The algorithm of the key is enforced. Within a rollover window, tokens of the legacy secret are accepted.
+// With an instance id, it is set as issuer and tokens of other issuers are rejected.
+//
+// Version 2: as version 1, with these changes:
+//   - signed with RS256 or EdDSA and the private key, or with the algorithm configured by -jwt-algo
+//   - tokens of the fallback secrets are always accepted
+//   - with audiences, the first one is set as audience and tokens for other audiences are rejected
+//   - iat and nbf are set and checked with the leeway for the clock skew
+//   - refresh tokens (token_type refresh) are only accepted for refreshes
+//   - with a claim map, the claims are issued and verified with the mapped names
+//   - with a revocation store, revoked tokens and tokens of ended oauth sessions are rejected
+//
+// Version 3: as version 2, with a jwe key, the issued tokens are encrypted and the encrypted tokens are decrypted before the verification.
+const TokenServiceVersion = 3
+
+// ErrForeignIssuer is returned for tokens of another loginsrv instance
+var ErrForeignIssuer = errors.New("token issued by a foreign loginsrv instance")
+
+// ErrForeignAudience is returned for tokens, which are not issued for one of the configured audiences
+var ErrForeignAudience = errors.New("token issued for a foreign audience")
+
+// ErrWrongTokenType is returned for refresh tokens used as access tokens and vice versa
+var ErrWrongTokenType = errors.New("token of the wrong type")
+
+// ErrTokenRevoked is returned for revoked tokens and for tokens of a session, which was ended by the oauth provider
+var ErrTokenRevoked = errors.New("token revoked")
+
+// revocationCheckError is returned, if the revocation store failed.
+// Without knowing the revocations, the token can not be trusted.
+type revocationCheckError struct {
+	err error
+}
+
+func (e *revocationCheckError) Error() string {
+	return fmt.Sprintf("could not check the revocation of the token: %v", e.err)
+}
+
+// TokenService issues and verifies the tokens of loginsrv.
+// It is used by the login handler and can be used by other go services
+// for verifying the tokens with exactly the same rules.
+type TokenService struct {
+	signer     Signer
+	fallbacks  []Signer
+	rollover   *rollover
+	instanceID string
+	audiences  []string
+	jwtExpiry  time.Duration
+
+	// leeway is the tolerated clock skew for the expiry and the not before time
+	leeway    time.Duration
+	notBefore bool
+
+	// expiryGrace accepts tokens, which expired within it, e.g. for refreshes
+	expiryGrace time.Duration
+	// refreshTokens accepts only refresh tokens instead of only access tokens
+	refreshTokens bool
+
+	// claimMap renames the claims in the tokens
+	claimMap claimMap
+	// extraClaims are stamped into every token
+	extraClaims extraClaims
+	// userFile adds the claims of the matching entry to the tokens of a user
+	userFile userFile
+	// claimsLimits are checked before signing, nil without -claims-strict
+	claimsLimits *claimsLimits
+	// jwe encrypts the tokens of Issue and decrypts the tokens of Verify, nil without a -jwe-key
+	jwe *tokenEncrypter
+
+	// revocations are checked by Verify, if set
+	revocations RevocationStore
+}
+
+// TokenServiceOption configures a TokenService beyond the settings of the configuration
+type TokenServiceOption func(s *TokenService)
+
+// WithTokenRevocations rejects the tokens revoked in the store, e.g. the store shared with the loginsrv instances.
+// This covers the revoked token ids (jti) and the sessions ended by a front-channel logout of the oauth provider.
+func WithTokenRevocations(store RevocationStore) TokenServiceOption {
+	return func(s *TokenService) {
+		s.revocations = store
+	}
+}
+
+// NewTokenService creates the token service for the jwt settings of the configuration:
+// the secret, the secret file or a random secret, private key or kms key, the fallback secrets, the legacy secret with its rollover window, the instance id, the audiences,
+// the expiry, the leeway, the claim map, the extra claims, the user file, the claims limits and the jwe key.
+// Revoked tokens are only rejected with the revocation store of WithTokenRevocations.
+func NewTokenService(config *Config, options ...TokenServiceOption) (*TokenService, error) {
+	if err := resolveJwtSecret(config); err != nil {
+		return nil, err
+	}
+	claimMap, err := parseClaimMap(config.JwtClaimMap)
+	if err != nil {
+		return nil, err
+	}
+	extraClaims, err := parseExtraClaims(config.JwtClaims, claimMap)
+	if err != nil {
+		return nil, err
+	}
+	userFile, err := loadUserFile(config.UserFile, claimMap)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid user file: %v", err)
+	}
+	claimsLimits, err := newClaimsLimits(config, claimMap)
+	if err != nil {
+		return nil, err
+	}
+	rollover, err := newRollover(config)
+	if err != nil {
+		return nil, err
+	}
+	signer, err := newSigner(config)
+	if err != nil {
+		return nil, err
+	}
+	fallbacks, err := newFallbackSigners(config, signer)
+	if err != nil {
+		return nil, err
+	}
+	jwe, err := newTokenEncrypter(config)
+	if err != nil {
+		return nil, err
+	}
+	s := &TokenService{
+		signer:      signer,
+		fallbacks:   fallbacks,
+		rollover:    rollover,
+		instanceID:  config.InstanceID,
+		audiences:   parseAudiences(config.JwtAudience),
+		jwtExpiry:   config.JwtExpiry,
+		leeway:      config.JwtLeeway,
+		notBefore:   config.JwtNotBefore,
+		claimMap:    claimMap,
+		extraClaims: extraClaims,
+		userFile:    userFile,
+
+		claimsLimits: claimsLimits,
+		jwe:          jwe,
+	}
+	for _, option := range options {
+		option(s)
+	}
+	return s, nil
+}
+
+// tokenService returns the token service with the signer and the rollover of the handler
+func (h *Handler) tokenService() *TokenService {
+	return &TokenService{
+		signer:      h.tokenSigner(),
+		fallbacks:   h.fallbackSigners,
+		rollover:    h.rollover,
+		instanceID:  h.config.InstanceID,
+		audiences:   h.audiences,
+		jwtExpiry:   h.config.JwtExpiry,
+		leeway:      h.config.JwtLeeway,
+		notBefore:   h.config.JwtNotBefore,
+		claimMap:    h.claimMap,
+		extraClaims: h.extraClaims,
+		userFile:    h.userFile,
+
+		claimsLimits: h.claimsLimits,
+		revocations:  h.revocations,
+		jwe:          h.jwe,
+	}
+}
+
+// Issue signs a token for the user info and encrypts it with the jwe key, if one is configured.
+// Without an expiry, the configured jwt expiry is used.
+func (s *TokenService) Issue(userInfo model.UserInfo) (string, error) {
+	if userInfo.Expiry == 0 {
+		userInfo.Expiry = time.Now().Add(s.jwtExpiry).Unix()
+	}
+	token, err := s.issue(context.Background(), userInfo)
+	if err != nil || s.jwe == nil {
+		return token, err
+	}
+	return s.jwe.encrypt(token)
+}
+
+func (s *TokenService) issue(ctx context.Context, userInfo model.UserInfo) (string, error) {
+	claims := s.claims(userInfo)
+	if err := s.claimsLimits.check(claims); err != nil {
+		return "", fmt.Errorf("rejected the token claims of %v: %v", userInfo.Sub, err)
+	}
+	return signToken(ctx, s.signer, claims)
+}
+
+// claims returns the stamped user info with the names of the claim map and the extra claims
+func (s *TokenService) claims(userInfo model.UserInfo) jwt.Claims {
+	// the claims of the user file are looked up again on every refresh, so that changes reach the active sessions
+	extra := s.extraClaims.merge(s.userFile.claimsFor(userInfo))
+	if len(s.claimMap) > 0 || len(extra) > 0 {
+		return tokenClaims{UserInfo: s.stamp(userInfo), claimMap: s.claimMap, extra: extra}
+	}
+	return s.stamp(userInfo)
+}
+
+// stamp sets the time of issue, the issuer and the audience of this service in the user info.
+// An audience set by the backend, e.g. of an api key, is kept.
+func (s *TokenService) stamp(userInfo model.UserInfo) model.UserInfo {
+	userInfo.IssuedAt = time.Now().Unix()
+	userInfo.NotBefore = 0
+	if s.notBefore {
+		userInfo.NotBefore = userInfo.IssuedAt
+	}
+	if s.instanceID != "" {
+		userInfo.Issuer = s.instanceID
+	}
+	if userInfo.Audience == "" && len(s.audiences) > 0 {
+		userInfo.Audience = s.audiences[0]
+	}
+	return userInfo
+}
+
+// Verify checks the signature, the expiry, the not before time, the issuer, the audience, the type and the revocation of the token
+// and returns its user info. Clock differences up to the configured leeway are tolerated.
+// Encrypted tokens are decrypted with the jwe key first, plain tokens are accepted as well.
+// With ErrForeignIssuer, ErrForeignAudience, ErrWrongTokenType or ErrTokenRevoked, the user info is returned as well, e.g. for logging the issuer.
+func (s *TokenService) Verify(token string) (model.UserInfo, error) {
+	token, err := s.decrypt(token)
+	if err != nil {
+		return model.UserInfo{}, err
+	}
+	u, err := s.parse(token)
+	if err != nil {
+		return model.UserInfo{}, err
+	}
+	if s.instanceID != "" && u.Issuer != s.instanceID {
+		return *u, ErrForeignIssuer
+	}
+	if len(s.audiences) > 0 && !s.acceptsAudience(u.Audience) {
+		return *u, ErrForeignAudience
+	}
+	if (u.TokenType == model.TokenTypeRefresh) != s.refreshTokens {
+		return *u, ErrWrongTokenType
+	}
+	revoked, err := s.revoked(*u)
+	if err != nil {
+		return model.UserInfo{}, err
+	}
+	if revoked {
+		return *u, ErrTokenRevoked
+	}
+	return *u, nil
+}
+
+// decrypt returns the signed token of an encrypted token. Plain tokens are returned unchanged.
+func (s *TokenService) decrypt(token string) (string, error) {
+	if s.jwe == nil || !isEncryptedToken(token) {
+		return token, nil
+	}
+	return s.jwe.decrypt(token)
+}
+
+// revoked checks the token id and the session of the oauth provider against the revocation store, if there is one
+func (s *TokenService) revoked(u model.UserInfo) (bool, error) {
+	if s.revocations == nil {
+		return false, nil
+	}
+	var ids []string
+	if u.ID != "" {
+		ids = append(ids, u.ID)
+	}
+	if u.IdPSession != nil {
+		ids = append(ids, idpSessionRevocationID(*u.IdPSession))
+	}
+	for _, id := range ids {
+		revoked, err := s.revocations.IsRevoked(id)
+		if err != nil {
+			return false, &revocationCheckError{err}
+		}
+		if revoked {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// acceptsAudience checks, if the audience is one of the configured audiences
+func (s *TokenService) acceptsAudience(audience string) bool {
+	for _, a := range s.audiences {
+		if a == audience {
+			return true
+		}
+	}
+	return false
+}
+
+// parseAudiences returns the audiences of the comma separated list
+func parseAudiences(list string) []string {
+	var audiences []string
+	for _, audience := range strings.Split(list, ",") {
+		if audience = strings.TrimSpace(audience); audience != "" {
+			audiences = append(audiences, audience)
+		}
+	}
+	return audiences
+}
+
+// parse verifies the token with the key of the signer.
+// Tokens signed with a fallback secret are accepted as well, like the tokens
+// of the legacy secret within a rollover.
+func (s *TokenService) parse(rtoken string) (*model.UserInfo, error) {
+	u, err := parseWithSigner(s.signer, rtoken, s.leeway, s.expiryGrace, s.claimMap)
+	if err == nil {
+		return u, nil
+	}
+	for _, fallback := range s.fallbacks {
+		u, fallbackErr := parseWithSigner(fallback, rtoken, s.leeway, s.expiryGrace, s.claimMap)
+		if fallbackErr == nil {
+			return u, nil
+		}
+		if tokenFailureOf(fallbackErr) != TokenInvalidSignature {
+			// the fallback secret matches, but the token is rejected, e.g. because it expired
+			err = fallbackErr
+			break
+		}
+	}
+	if s.rollover.active(time.Now()) {
+		if u, legacyErr := s.rollover.parseLegacyToken(rtoken, s.leeway, s.expiryGrace, s.claimMap); legacyErr == nil {
+			return u, nil
+		}
+	}
+	return nil, err
+}
+
+// leewayClaims are the user info, which are valid with a leeway for the clock skew.
+// With an expiry grace, tokens expired within it are valid as well.
+// With a claim map, the claims are read by their mapped names.
+type leewayClaims struct {
+	*model.UserInfo
+	leeway      time.Duration
+	expiryGrace time.Duration
+	claimMap    claimMap
+}
+
+func (c *leewayClaims) UnmarshalJSON(b []byte) error {
+	if len(c.claimMap) > 0 {
+		claims := map[string]json.RawMessage{}
+		if err := json.Unmarshal(b, &claims); err != nil {
+			return err
+		}
+		var err error
+		if b, err = json.Marshal(c.claimMap.revert(claims)); err != nil {
+			return err
+		}
+	}
+	return json.Unmarshal(b, c.UserInfo)
+}
+
+func (c leewayClaims) Valid() error {
+	now := time.Now()
+	err := c.UserInfo.ValidAt(now, c.leeway)
+	if err == model.ErrTokenExpired && c.Expiry >= now.Add(-c.leeway-c.expiryGrace).Unix() {
+		// the not before time is not checked by ValidAt for expired tokens
+		if c.NotBefore > now.Add(c.leeway).Unix() {
+			return model.ErrTokenNotValidYet
+		}
+		return nil
+	}
+	return err
+}
+
+// checkSigningMethod rejects tokens, which are not signed with the expected algorithm.
+// Otherwise, e.g. a hmac token signed with the bytes of a public rsa key would be verified with the public key as secret.
+// Unsigned tokens are never accepted.
+func checkSigningMethod(token *jwt.Token, expected jwt.SigningMethod) error {
+	if token.Method == jwt.SigningMethodNone || token.Method.Alg() != expected.Alg() {
+		return fmt.Errorf("unexpected signing method %v", token.Header["alg"])
+	}
+	return nil
+}
+
+// parseWithSigner verifies the token with the key of the signer and enforces its algorithm.
+// The expiry and the not before time are checked with the leeway, the expiry additionally with the expiry grace.
+func parseWithSigner(signer Signer, rtoken string, leeway, expiryGrace time.Duration, claimMap claimMap) (*model.UserInfo, error) {
+	claims := &leewayClaims{UserInfo: &model.UserInfo{}, leeway: leeway, expiryGrace: expiryGrace, claimMap: claimMap}
+	_, err := jwt.ParseWithClaims(rtoken, claims, func(token *jwt.Token) (interface{}, error) {
+		if err := checkSigningMethod(token, signer.SigningMethod()); err != nil {
+			return nil, err
+		}
+		if selector, ok := signer.(keySelector); ok {
+			kid, _ := token.Header["kid"].(string)
+			return selector.VerificationKeyFor(kid)
+		}
+		return signer.VerificationKey(), nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return claims.UserInfo, nil
+}
@@ -0,0 +1,399 @@
+package login
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	. "github.com/stretchr/testify/assert"
+	"github.com/tarent/loginsrv/model"
+)
+
+func tokenServiceConfig(secret string) *Config {
+	cfg := DefaultConfig()
+	cfg.JwtSecret = secret
+	cfg.Backends = Options{"simple": {"bob": "secret"}}
+	return cfg
+}
+
+func TestTokenService_VerifiesHandlerTokens(t *testing.T) {
+	cfg := tokenServiceConfig("shared-secret")
+	cfg.InstanceID = "sso"
+	h, err := NewHandler(cfg)
+	NoError(t, err)
+
+	recorder := httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("POST", "/login", "username=bob&password=secret", TypeForm, AcceptJwt))
+	Equal(t, 200, recorder.Code)
+	token := recorder.Body.String()
+
+	// a separate service with the same settings
+	service, err := NewTokenService(tokenServiceConfig("shared-secret"))
+	NoError(t, err)
+	service.instanceID = "sso"
+	userInfo, err := service.Verify(token)
+	NoError(t, err)
+	Equal(t, "bob", userInfo.Sub)
+	Equal(t, "sso", userInfo.Issuer)
+
+	// and the handler verifies the tokens of the service
+	issued, err := service.Issue(model.UserInfo{Sub: "alice"})
+	NoError(t, err)
+	userInfo, valid := h.GetToken(req("GET", "/login", ""), issued)
+	True(t, valid)
+	Equal(t, "alice", userInfo.Sub)
+	InDelta(t, time.Now().Add(cfg.JwtExpiry).Unix(), userInfo.Expiry, 2)
+
+	// another secret
+	other, _ := NewTokenService(tokenServiceConfig("other-secret"))
+	_, err = other.Verify(token)
+	Error(t, err)
+
+	// another instance
+	foreign, _ := NewTokenService(tokenServiceConfig("shared-secret"))
+	foreign.instanceID = "staging"
+	userInfo, err = foreign.Verify(token)
+	Equal(t, ErrForeignIssuer, err)
+	Equal(t, "sso", userInfo.Issuer)
+}
+
+func TestTokenService_Verify_Invalid(t *testing.T) {
+	service, err := NewTokenService(tokenServiceConfig("secret"))
+	NoError(t, err)
+
+	expired, _ := service.Issue(model.UserInfo{Sub: "bob", Expiry: time.Now().Add(-time.Minute).Unix()})
+	_, err = service.Verify(expired)
+	Error(t, err)
+
+	// the algorithm of the key is enforced
+	hs256, _ := jwt.NewWithClaims(jwt.SigningMethodHS256, model.UserInfo{Sub: "bob"}).SignedString([]byte("secret"))
+	_, err = service.Verify(hs256)
+	EqualError(t, err, "unexpected signing method HS256")
+
+	none, _ := jwt.NewWithClaims(jwt.SigningMethodNone, model.UserInfo{Sub: "bob"}).SignedString(jwt.UnsafeAllowNoneSignatureType)
+	_, err = service.Verify(none)
+	Error(t, err)
+
+	_, err = service.Verify("not a token")
+	Error(t, err)
+}
+
+func TestTokenService_JWE(t *testing.T) {
+	cfg := tokenServiceConfig("secret")
+	cfg.JweKey = testJweKey
+	service, err := NewTokenService(cfg)
+	NoError(t, err)
+
+	// the issued tokens are encrypted and verified by the same service
+	token, err := service.Issue(model.UserInfo{Sub: "bob"})
+	NoError(t, err)
+	True(t, isEncryptedToken(token))
+	userInfo, err := service.Verify(token)
+	NoError(t, err)
+	Equal(t, "bob", userInfo.Sub)
+
+	// like the encrypted tokens of the handler
+	h, err := NewHandler(cfg)
+	NoError(t, err)
+	recorder := callHandler(h, req("POST", "/login", "username=bob&password=secret", TypeForm, AcceptJwt))
+	Equal(t, 200, recorder.Code)
+	True(t, isEncryptedToken(recorder.Body.String()))
+	userInfo, err = service.Verify(recorder.Body.String())
+	NoError(t, err)
+	Equal(t, "bob", userInfo.Sub)
+
+	// plain tokens are accepted as well
+	plain, err := NewTokenService(tokenServiceConfig("secret"))
+	NoError(t, err)
+	token, err = plain.Issue(model.UserInfo{Sub: "bob"})
+	NoError(t, err)
+	_, err = service.Verify(token)
+	NoError(t, err)
+
+	// tokens, which can't be decrypted, are invalid
+	cfg.JweKey = base64.StdEncoding.EncodeToString([]byte("fedcba9876543210fedcba9876543210"))
+	other, err := NewTokenService(cfg)
+	NoError(t, err)
+	token, err = other.Issue(model.UserInfo{Sub: "bob"})
+	NoError(t, err)
+	_, err = service.Verify(token)
+	Equal(t, errInvalidJWE, err)
+	_, err = plain.Verify(token)
+	Error(t, err)
+}
+
+func TestTokenService_Revocations(t *testing.T) {
+	revocations := newStoreRevocations(memoryStore{newTTLStore()})
+	h, err := NewHandlerWithOptions(tokenServiceConfig("shared-secret"), WithRevocationStore(revocations))
+	NoError(t, err)
+	recorder := httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("POST", "/login", "username=bob&password=secret", TypeForm, AcceptJwt))
+	token := recorder.Body.String()
+
+	service, err := NewTokenService(tokenServiceConfig("shared-secret"), WithTokenRevocations(revocations))
+	NoError(t, err)
+	userInfo, err := service.Verify(token)
+	NoError(t, err)
+
+	NoError(t, revocations.Revoke(userInfo.ID, time.Now().Add(time.Hour)))
+	userInfo, err = service.Verify(token)
+	Equal(t, ErrTokenRevoked, err)
+	Equal(t, "bob", userInfo.Sub)
+
+	// the sessions ended by the oauth provider
+	idpSession := &model.IdPSession{Issuer: "https://idp.example.com", ID: "session-1"}
+	issued, err := service.Issue(model.UserInfo{Sub: "alice", IdPSession: idpSession})
+	NoError(t, err)
+	_, err = service.Verify(issued)
+	NoError(t, err)
+	NoError(t, revocations.Revoke(idpSessionRevocationID(*idpSession), time.Now().Add(time.Hour)))
+	_, err = service.Verify(issued)
+	Equal(t, ErrTokenRevoked, err)
+
+	// without a store, the revocations are not checked
+	unchecked, _ := NewTokenService(tokenServiceConfig("shared-secret"))
+	_, err = unchecked.Verify(token)
+	NoError(t, err)
+
+	// a failing store rejects all tokens with an id
+	failing, _ := NewTokenService(tokenServiceConfig("shared-secret"), WithTokenRevocations(failingRevocations{}))
+	_, err = failing.Verify(token)
+	EqualError(t, err, "could not check the revocation of the token: unavailable")
+}
+
+func TestTokenService_Rollover(t *testing.T) {
+	// tokens of the service before the rotation
+	before, err := NewTokenService(tokenServiceConfig("old-secret"))
+	NoError(t, err)
+	oldToken, err := before.Issue(model.UserInfo{Sub: "bob"})
+	NoError(t, err)
+
+	cfg := tokenServiceConfig("new-secret")
+	cfg.JwtLegacySecret = "old-secret"
+	cfg.JwtRolloverStart = time.Now().Add(-time.Hour).Format(time.RFC3339)
+	cfg.JwtRolloverEnd = time.Now().Add(time.Hour).Format(time.RFC3339)
+	cfg.JwtLegacyOutput = "field"
+	h, err := NewHandler(cfg)
+	NoError(t, err)
+	after, err := NewTokenService(cfg)
+	NoError(t, err)
+
+	// within the window, the old tokens are still accepted
+	userInfo, err := after.Verify(oldToken)
+	NoError(t, err)
+	Equal(t, "bob", userInfo.Sub)
+
+	// the new tokens of the handler are verified by updated services
+	// and the legacy tokens by services, which still have the old secret
+	recorder := httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("POST", "/login", "username=bob&password=secret", TypeForm, AcceptJwt))
+	Equal(t, 200, recorder.Code)
+	_, err = after.Verify(recorder.Body.String())
+	NoError(t, err)
+	_, err = before.Verify(recorder.Body.String())
+	Error(t, err)
+	_, err = before.Verify(recorder.Header().Get(legacyTokenHeader))
+	NoError(t, err)
+
+	// after the window, the old tokens are rejected
+	after.rollover.end = time.Now().Add(-time.Minute)
+	_, err = after.Verify(oldToken)
+	Error(t, err)
+}
+
+func TestHandler_JwtSecretFallback(t *testing.T) {
+	// a cookie of the handler before the rotation
+	before, err := NewHandler(tokenServiceConfig("old-secret"))
+	NoError(t, err)
+	recorder := httptest.NewRecorder()
+	before.ServeHTTP(recorder, req("POST", "/login", "username=bob&password=secret", TypeForm, AcceptJwt))
+	Equal(t, 200, recorder.Code)
+	oldToken := recorder.Body.String()
+
+	cfg := tokenServiceConfig("new-secret")
+	cfg.JwtSecretFallback = "older-secret, old-secret"
+	cfg.JwtRefreshes = 1
+	h, err := NewHandler(cfg)
+	NoError(t, err)
+
+	userInfo, valid := h.GetToken(req("GET", "/login", ""), oldToken)
+	True(t, valid)
+	Equal(t, "bob", userInfo.Sub)
+
+	// the refresh of the old cookie is signed with the new secret
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("POST", "/login", "", AcceptJwt, "Cookie: "+cfg.CookieName+"="+oldToken))
+	Equal(t, 200, recorder.Code)
+	refreshed := recorder.Body.String()
+	_, err = parseWithSigner(hmacSigner{secret: []byte("new-secret")}, refreshed, 0, 0, nil)
+	NoError(t, err)
+	_, err = parseWithSigner(hmacSigner{secret: []byte("old-secret")}, refreshed, 0, 0, nil)
+	Error(t, err)
+
+	// the token service accepts the fallback secrets as well
+	tokens, err := NewTokenService(cfg)
+	NoError(t, err)
+	_, err = tokens.Verify(oldToken)
+	NoError(t, err)
+
+	// tokens of unknown secrets are still rejected, expired tokens of a fallback secret as expired
+	other, _ := NewTokenService(tokenServiceConfig("other-secret"))
+	otherToken, _ := other.Issue(model.UserInfo{Sub: "bob"})
+	_, failure := h.VerifyToken(req("GET", "/login", ""), otherToken)
+	Equal(t, TokenInvalidSignature, failure)
+
+	old, _ := NewTokenService(tokenServiceConfig("old-secret"))
+	expiredToken, _ := old.Issue(model.UserInfo{Sub: "bob", Expiry: time.Now().Add(-time.Minute).Unix()})
+	_, failure = h.VerifyToken(req("GET", "/login", ""), expiredToken)
+	Equal(t, TokenExpired, failure)
+}
+
+func TestHandler_JwtSecretFallback_Invalid(t *testing.T) {
+	cfg := tokenServiceConfig("new-secret")
+	cfg.JwtSecretFallback = "old-secret,new-secret"
+	_, err := NewHandler(cfg)
+	EqualError(t, err, "The jwt secret fallback has to differ from the jwt secret")
+}
+
+func ExampleTokenService() {
+	config := DefaultConfig()
+	config.JwtSecret = "the secret shared with loginsrv"
+
+	service, err := NewTokenService(config)
+	if err != nil {
+		panic(err)
+	}
+
+	// the token from the cookie or authorization header of a request
+	token, _ := service.Issue(model.UserInfo{Sub: "bob"})
+
+	userInfo, err := service.Verify(token)
+	if err != nil {
+		fmt.Println("invalid token:", err)
+		return
+	}
+	fmt.Println(userInfo.Sub)
+	// Output: bob
+}
+
+func TestTokenService_Audience(t *testing.T) {
+	cfgA := tokenServiceConfig("shared-secret")
+	cfgA.JwtAudience = "app-a"
+	appA, err := NewTokenService(cfgA)
+	NoError(t, err)
+
+	cfgB := tokenServiceConfig("shared-secret")
+	cfgB.JwtAudience = " app-b, app-a-legacy "
+	appB, err := NewTokenService(cfgB)
+	NoError(t, err)
+	Equal(t, []string{"app-b", "app-a-legacy"}, appB.audiences)
+
+	token, err := appA.Issue(model.UserInfo{Sub: "bob"})
+	NoError(t, err)
+	userInfo, err := appA.Verify(token)
+	NoError(t, err)
+	Equal(t, "app-a", userInfo.Audience)
+
+	userInfo, err = appB.Verify(token)
+	Equal(t, ErrForeignAudience, err)
+	Equal(t, "app-a", userInfo.Audience)
+
+	// every configured audience is accepted, the audience of the backend is kept
+	token, err = appA.Issue(model.UserInfo{Sub: "bob", Audience: "app-a-legacy"})
+	NoError(t, err)
+	userInfo, err = appB.Verify(token)
+	NoError(t, err)
+	Equal(t, "app-a-legacy", userInfo.Audience)
+
+	// tokens without audience
+	plain, _ := NewTokenService(tokenServiceConfig("shared-secret"))
+	token, err = plain.Issue(model.UserInfo{Sub: "bob"})
+	NoError(t, err)
+	_, err = appA.Verify(token)
+	Equal(t, ErrForeignAudience, err)
+	_, err = plain.Verify(token)
+	NoError(t, err)
+}
+
+func TestHandler_Audience_Refresh(t *testing.T) {
+	cfg := tokenServiceConfig("shared-secret")
+	cfg.JwtAudience = "app-b"
+	cfg.JwtRefreshes = 1
+	h, err := NewHandler(cfg)
+	NoError(t, err)
+
+	cfgA := tokenServiceConfig("shared-secret")
+	cfgA.JwtAudience = "app-a"
+	appA, _ := NewTokenService(cfgA)
+	foreign, _ := appA.Issue(model.UserInfo{Sub: "bob"})
+
+	recorder := httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("POST", "/login", "", AcceptJwt, "Cookie: "+cfg.CookieName+"="+foreign))
+	NotEqual(t, 200, recorder.Code)
+
+	own, _ := h.tokenService().Issue(model.UserInfo{Sub: "bob"})
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("POST", "/login", "", AcceptJwt, "Cookie: "+cfg.CookieName+"="+own))
+	Equal(t, 200, recorder.Code)
+	userInfo, valid := h.GetToken(req("GET", "/login", ""), recorder.Body.String())
+	True(t, valid)
+	Equal(t, "app-b", userInfo.Audience)
+	Equal(t, 1, userInfo.Refreshes)
+}
+
+func TestTokenService_Leeway(t *testing.T) {
+	strict, err := NewTokenService(tokenServiceConfig("secret"))
+	NoError(t, err)
+	cfg := tokenServiceConfig("secret")
+	cfg.JwtLeeway = 30 * time.Second
+	cfg.JwtNotBefore = true
+	lenient, err := NewTokenService(cfg)
+	NoError(t, err)
+
+	token, err := lenient.Issue(model.UserInfo{Sub: "bob"})
+	NoError(t, err)
+	issued, err := lenient.Verify(token)
+	NoError(t, err)
+	InDelta(t, time.Now().Unix(), issued.IssuedAt, 2)
+	Equal(t, issued.IssuedAt, issued.NotBefore)
+
+	// just expired
+	expired, _ := strict.Issue(model.UserInfo{Sub: "bob", Expiry: time.Now().Add(-10 * time.Second).Unix()})
+	_, err = strict.Verify(expired)
+	Equal(t, TokenExpired, tokenFailureOf(err))
+	userInfo, err := lenient.Verify(expired)
+	NoError(t, err)
+	Equal(t, "bob", userInfo.Sub)
+
+	// issued by a server with a clock ahead
+	early, _ := signToken(context.Background(), strict.signer, model.UserInfo{
+		Sub:       "bob",
+		Expiry:    time.Now().Add(time.Hour).Unix(),
+		NotBefore: time.Now().Add(10 * time.Second).Unix(),
+	})
+	_, err = strict.Verify(early)
+	Equal(t, TokenNotValidYet, tokenFailureOf(err))
+	_, err = lenient.Verify(early)
+	NoError(t, err)
+}
+
+func TestHandler_Refresh_Leeway(t *testing.T) {
+	cfg := tokenServiceConfig("secret")
+	cfg.JwtRefreshes = 1
+	cfg.JwtLeeway = 30 * time.Second
+	h, err := NewHandler(cfg)
+	NoError(t, err)
+
+	expired, _ := h.tokenService().Issue(model.UserInfo{Sub: "bob", Expiry: time.Now().Add(-5 * time.Second).Unix()})
+	recorder := httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("POST", "/login", "", AcceptJwt, "Cookie: "+cfg.CookieName+"="+expired))
+	Equal(t, 200, recorder.Code)
+	userInfo, valid := h.GetToken(req("GET", "/login", ""), recorder.Body.String())
+	True(t, valid)
+	Equal(t, 1, userInfo.Refreshes)
+	True(t, userInfo.Expiry > time.Now().Unix())
+}
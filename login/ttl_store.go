@@ -0,0 +1,399 @@
+package login
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/tarent/loginsrv/logging"
+)
+
+// snapshotFormatVersion is the version of the snapshot file envelope.
+// The format of the entries is versioned per namespace.
+const snapshotFormatVersion = 1
+
+// storeShards is the number of shards of the store.
+// Each shard has its own lock, so that concurrent updates of different keys rarely contend.
+const storeShards = 16
+
+// ttlStore is an in memory key value store with expiring entries,
+// grouped by namespaces. Components keeping state like lockouts or revocations
+// register their namespace with a version, so that entries
+// of an outdated format are dropped on loading a snapshot.
+// The entries are sharded by namespace and key.
+type ttlStore struct {
+	shards         [storeShards]storeShard
+	namespaces     map[string]int
+	expireHandlers map[string]func(key, value string)
+	mu             sync.RWMutex
+	now            func() time.Time
+}
+
+// storeShard holds the entries of a part of the keys
+type storeShard struct {
+	mu      sync.Mutex
+	entries map[string]map[string]ttlEntry
+}
+
+type ttlEntry struct {
+	Value   string
+	Expires time.Time
+}
+
+type snapshot struct {
+	Version int             `json:"version"`
+	Entries []snapshotEntry `json:"entries"`
+}
+
+type snapshotEntry struct {
+	Namespace string    `json:"namespace"`
+	Version   int       `json:"namespace_version"`
+	Key       string    `json:"key"`
+	Value     string    `json:"value"`
+	Expires   time.Time `json:"expires"`
+}
+
+func newTTLStore() *ttlStore {
+	s := &ttlStore{
+		namespaces:     map[string]int{},
+		expireHandlers: map[string]func(key, value string){},
+		now:            time.Now,
+	}
+	for i := range s.shards {
+		s.shards[i].entries = map[string]map[string]ttlEntry{}
+	}
+	return s
+}
+
+// shard returns the shard of the key
+func (s *ttlStore) shard(namespace, key string) *storeShard {
+	h := fnv.New32a()
+	h.Write([]byte(namespace))
+	h.Write([]byte{0})
+	h.Write([]byte(key))
+	return &s.shards[h.Sum32()%storeShards]
+}
+
+// namespace returns the entries of the namespace in the shard,
+// creating the map on demand. The lock of the shard has to be held.
+func (sh *storeShard) namespace(namespace string) map[string]ttlEntry {
+	entries := sh.entries[namespace]
+	if entries == nil {
+		entries = map[string]ttlEntry{}
+		sh.entries[namespace] = entries
+	}
+	return entries
+}
+
+// registerNamespace declares the version of the entry format used in a namespace.
+func (s *ttlStore) registerNamespace(namespace string, version int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.namespaces[namespace] = version
+}
+
+func (s *ttlStore) set(namespace, key, value string, ttl time.Duration) {
+	sh := s.shard(namespace, key)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	sh.namespace(namespace)[key] = ttlEntry{Value: value, Expires: s.now().Add(ttl)}
+}
+
+// onExpire registers a handler for the entries of a namespace, which expire without being deleted.
+// The handler is called without holding a lock of the store.
+func (s *ttlStore) onExpire(namespace string, handler func(key, value string)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.expireHandlers[namespace] = handler
+}
+
+type expiredEntry struct {
+	handler    func(key, value string)
+	key, value string
+}
+
+// expire deletes an expired entry and adds it to the pending notifications,
+// if its namespace has an expire handler. The lock of the shard has to be held.
+func (s *ttlStore) expire(sh *storeShard, pending []expiredEntry, namespace, key string, e ttlEntry) []expiredEntry {
+	delete(sh.entries[namespace], key)
+	s.mu.RLock()
+	handler := s.expireHandlers[namespace]
+	s.mu.RUnlock()
+	if handler != nil {
+		pending = append(pending, expiredEntry{handler: handler, key: key, value: e.Value})
+	}
+	return pending
+}
+
+func notifyExpired(pending []expiredEntry) {
+	for _, e := range pending {
+		e.handler(e.key, e.value)
+	}
+}
+
+func (s *ttlStore) get(namespace, key string) (string, bool) {
+	sh := s.shard(namespace, key)
+	sh.mu.Lock()
+	e, ok := sh.entries[namespace][key]
+	if ok && !s.now().Before(e.Expires) {
+		expired := s.expire(sh, nil, namespace, key, e)
+		sh.mu.Unlock()
+		notifyExpired(expired)
+		return "", false
+	}
+	sh.mu.Unlock()
+	if !ok {
+		return "", false
+	}
+	return e.Value, true
+}
+
+// increment counts the entry up and returns the new count and its expiry.
+// A missing or expired entry starts at 1 with the ttl, an existing entry keeps its expiry.
+// Expired entries are replaced without calling the expire handler.
+func (s *ttlStore) increment(namespace, key string, ttl time.Duration) (int, time.Time) {
+	sh := s.shard(namespace, key)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	entries := sh.namespace(namespace)
+	now := s.now()
+	e, ok := entries[key]
+	count := 0
+	if ok && now.Before(e.Expires) {
+		count, _ = strconv.Atoi(e.Value)
+	} else {
+		e.Expires = now.Add(ttl)
+	}
+	count++
+	e.Value = strconv.Itoa(count)
+	entries[key] = e
+	return count, e.Expires
+}
+
+// count returns the count of an entry of increment and its expiry, 0 for a missing or expired entry.
+func (s *ttlStore) count(namespace, key string) (int, time.Time) {
+	sh := s.shard(namespace, key)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	e, ok := sh.entries[namespace][key]
+	if !ok || !s.now().Before(e.Expires) {
+		return 0, time.Time{}
+	}
+	count, _ := strconv.Atoi(e.Value)
+	return count, e.Expires
+}
+
+// take returns and deletes the entry atomically, so that only one of concurrent callers gets it.
+// Expired entries are deleted without calling the expire handler.
+func (s *ttlStore) take(namespace, key string) (string, bool) {
+	sh := s.shard(namespace, key)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	e, ok := sh.entries[namespace][key]
+	if !ok {
+		return "", false
+	}
+	delete(sh.entries[namespace], key)
+	if !s.now().Before(e.Expires) {
+		return "", false
+	}
+	return e.Value, true
+}
+
+func (s *ttlStore) delete(namespace, key string) {
+	sh := s.shard(namespace, key)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	delete(sh.entries[namespace], key)
+}
+
+// scan calls fn for each entry of the namespace, which is not expired.
+// The shards are locked one after the other, so the result is not an atomic view of the namespace.
+// fn is called with the lock of a shard held and must not use the store.
+func (s *ttlStore) scan(namespace string, fn func(key, value string)) {
+	for i := range s.shards {
+		sh := &s.shards[i]
+		sh.mu.Lock()
+		now := s.now()
+		for key, e := range sh.entries[namespace] {
+			if now.Before(e.Expires) {
+				fn(key, e.Value)
+			}
+		}
+		sh.mu.Unlock()
+	}
+}
+
+// sweep deletes all expired entries
+func (s *ttlStore) sweep() {
+	var expired []expiredEntry
+	for i := range s.shards {
+		sh := &s.shards[i]
+		sh.mu.Lock()
+		now := s.now()
+		for namespace, entries := range sh.entries {
+			for key, e := range entries {
+				if !now.Before(e.Expires) {
+					expired = s.expire(sh, expired, namespace, key, e)
+				}
+			}
+		}
+		sh.mu.Unlock()
+	}
+	notifyExpired(expired)
+}
+
+// snapshot returns all entries, which are not expired.
+func (s *ttlStore) snapshot() snapshot {
+	snap := snapshot{Version: snapshotFormatVersion, Entries: []snapshotEntry{}}
+	s.mu.RLock()
+	versions := make(map[string]int, len(s.namespaces))
+	for namespace, version := range s.namespaces {
+		versions[namespace] = version
+	}
+	s.mu.RUnlock()
+
+	var expired []expiredEntry
+	for i := range s.shards {
+		sh := &s.shards[i]
+		sh.mu.Lock()
+		now := s.now()
+		for namespace, entries := range sh.entries {
+			for key, e := range entries {
+				if !now.Before(e.Expires) {
+					expired = s.expire(sh, expired, namespace, key, e)
+					continue
+				}
+				snap.Entries = append(snap.Entries, snapshotEntry{
+					Namespace: namespace,
+					Version:   versions[namespace],
+					Key:       key,
+					Value:     e.Value,
+					Expires:   e.Expires,
+				})
+			}
+		}
+		sh.mu.Unlock()
+	}
+	notifyExpired(expired)
+	return snap
+}
+
+// writeSnapshot writes the entries to a temporary file
+// and renames it to the target file afterwards, so that the file is never half written.
+func (s *ttlStore) writeSnapshot(file string) error {
+	b, err := json.Marshal(s.snapshot())
+	if err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(file), filepath.Base(file)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), file)
+}
+
+// loadSnapshot restores the entries from a snapshot file.
+// A missing file is not an error. A corrupt file or an unknown format version
+// is reported as error, leaving the store empty.
+// Expired entries and entries with an outdated namespace version are dropped.
+func (s *ttlStore) loadSnapshot(file string) error {
+	b, err := ioutil.ReadFile(file)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	snap := snapshot{}
+	if err := json.Unmarshal(b, &snap); err != nil {
+		return fmt.Errorf("corrupt snapshot file %v: %v", file, err)
+	}
+	if snap.Version != snapshotFormatVersion {
+		return fmt.Errorf("unsupported snapshot version %v in %v", snap.Version, file)
+	}
+
+	now := s.now()
+	dropped := map[string]int{}
+	for _, e := range snap.Entries {
+		s.mu.RLock()
+		version, ok := s.namespaces[e.Namespace]
+		s.mu.RUnlock()
+		if !ok || version != e.Version {
+			dropped[e.Namespace]++
+			continue
+		}
+		if !now.Before(e.Expires) {
+			continue
+		}
+		sh := s.shard(e.Namespace, e.Key)
+		sh.mu.Lock()
+		sh.namespace(e.Namespace)[e.Key] = ttlEntry{Value: e.Value, Expires: e.Expires}
+		sh.mu.Unlock()
+	}
+	if len(dropped) > 0 {
+		logging.Logger.WithField("dropped", dropped).
+			Warn("dropped snapshot entries of unknown or outdated namespace versions")
+	}
+	return nil
+}
+
+// snapshotHook returns a hook, which writes the store to the state file
+// periodically and on shutdown.
+func (h *Handler) snapshotHook() Hook {
+	write := func() {
+		if err := h.store.writeSnapshot(h.config.StateFile); err != nil {
+			logging.Logger.WithError(err).Warn("error writing state snapshot")
+		}
+	}
+	if h.config.StateSnapshotInterval <= 0 {
+		return Hook{
+			Name: "state-snapshot",
+			Stop: func(ctx context.Context) error { return h.store.writeSnapshot(h.config.StateFile) },
+		}
+	}
+
+	task := &backgroundTask{interval: h.config.StateSnapshotInterval, run: write}
+	return Hook{
+		Name:  "state-snapshot",
+		Start: task.start,
+		Stop: func(ctx context.Context) error {
+			if err := task.shutdown(ctx); err != nil {
+				return err
+			}
+			return h.store.writeSnapshot(h.config.StateFile)
+		},
+	}
+}
+
+// storeSweepInterval is the interval for deleting the expired entries of the store
+var storeSweepInterval = time.Minute
+
+// sweepHook returns a hook, which deletes the expired entries of the store periodically,
+// so that the expire handlers of the namespaces are called.
+func (h *Handler) sweepHook() Hook {
+	task := &backgroundTask{interval: storeSweepInterval, run: h.store.sweep}
+	return task.hook("store-sweeper")
+}
@@ -0,0 +1,229 @@
+package login
+
+import (
+	"context"
+	. "github.com/stretchr/testify/assert"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestTTLStore_Expiry(t *testing.T) {
+	now := time.Now()
+	s := newTTLStore()
+	s.now = func() time.Time { return now }
+
+	s.set("lockout", "bob", "3", time.Minute)
+	v, ok := s.get("lockout", "bob")
+	True(t, ok)
+	Equal(t, "3", v)
+
+	_, ok = s.get("revocation", "bob")
+	False(t, ok)
+
+	now = now.Add(time.Minute)
+	_, ok = s.get("lockout", "bob")
+	False(t, ok)
+
+	s.set("lockout", "bob", "3", time.Minute)
+	s.delete("lockout", "bob")
+	_, ok = s.get("lockout", "bob")
+	False(t, ok)
+}
+
+func TestTTLStore_SweepAndExpireHandler(t *testing.T) {
+	now := time.Now()
+	s := newTTLStore()
+	s.now = func() time.Time { return now }
+	expired := map[string]string{}
+	s.onExpire("flows", func(key, value string) {
+		expired[key] = value
+	})
+
+	s.set("flows", "a", "1", time.Minute)
+	s.set("flows", "b", "2", 2*time.Minute)
+	s.set("flows", "c", "3", time.Minute)
+	s.set("lockout", "bob", "3", time.Minute)
+
+	// taken and deleted entries do not expire
+	v, ok := s.take("flows", "c")
+	True(t, ok)
+	Equal(t, "3", v)
+	_, ok = s.take("flows", "c")
+	False(t, ok)
+
+	now = now.Add(time.Minute)
+	s.sweep()
+	Equal(t, map[string]string{"a": "1"}, expired)
+	Equal(t, 1, len(storeEntries(s, "flows")))
+	Equal(t, 0, len(storeEntries(s, "lockout")))
+
+	// expiry on access is reported as well
+	now = now.Add(time.Minute)
+	_, ok = s.get("flows", "b")
+	False(t, ok)
+	Equal(t, map[string]string{"a": "1", "b": "2"}, expired)
+}
+
+func TestTTLStore_SnapshotRoundtrip(t *testing.T) {
+	dir := tmpDir(t)
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "state.json")
+
+	s := newTTLStore()
+	s.registerNamespace("lockout", 1)
+	s.set("lockout", "bob", "3", time.Hour)
+	s.set("lockout", "alice", "1", time.Millisecond)
+	time.Sleep(2 * time.Millisecond)
+	NoError(t, s.writeSnapshot(file))
+
+	// no temporary files are left
+	files, _ := ioutil.ReadDir(dir)
+	Equal(t, 1, len(files))
+
+	restored := newTTLStore()
+	restored.registerNamespace("lockout", 1)
+	NoError(t, restored.loadSnapshot(file))
+	v, ok := restored.get("lockout", "bob")
+	True(t, ok)
+	Equal(t, "3", v)
+	Equal(t, 1, len(restored.snapshot().Entries))
+
+	// entries of another namespace version are dropped
+	upgraded := newTTLStore()
+	upgraded.registerNamespace("lockout", 2)
+	NoError(t, upgraded.loadSnapshot(file))
+	_, ok = upgraded.get("lockout", "bob")
+	False(t, ok)
+}
+
+func TestTTLStore_LoadSnapshot_Errors(t *testing.T) {
+	dir := tmpDir(t)
+	defer os.RemoveAll(dir)
+
+	s := newTTLStore()
+	NoError(t, s.loadSnapshot(filepath.Join(dir, "missing.json")))
+
+	corrupt := filepath.Join(dir, "corrupt.json")
+	ioutil.WriteFile(corrupt, []byte(`{"version":1,"entr`), 0600)
+	Error(t, s.loadSnapshot(corrupt))
+
+	future := filepath.Join(dir, "future.json")
+	ioutil.WriteFile(future, []byte(`{"version":42,"entries":[]}`), 0600)
+	EqualError(t, s.loadSnapshot(future), "unsupported snapshot version 42 in "+future)
+}
+
+func TestTTLStore_HandlerPersistsOnShutdown(t *testing.T) {
+	dir := tmpDir(t)
+	defer os.RemoveAll(dir)
+
+	cfg := DefaultConfig()
+	cfg.Backends = Options{"simple": {"bob": "secret"}}
+	cfg.StateFile = filepath.Join(dir, "state.json")
+	h, err := NewHandler(cfg)
+	NoError(t, err)
+	h.store.registerNamespace("test", 1)
+	h.store.set("test", "key", "value", time.Hour)
+
+	l := &Lifecycle{}
+	l.Register(h.Hooks()...)
+	NoError(t, l.Start())
+	NoError(t, l.Stop(context.Background()))
+
+	restored := newTTLStore()
+	restored.registerNamespace("test", 1)
+	NoError(t, restored.loadSnapshot(cfg.StateFile))
+	v, _ := restored.get("test", "key")
+	Equal(t, "value", v)
+
+	// a corrupt state file does not prevent the startup
+	ioutil.WriteFile(cfg.StateFile, []byte("garbage"), 0600)
+	_, err = NewHandler(cfg)
+	NoError(t, err)
+}
+
+func TestTTLStore_ConcurrentIncrement(t *testing.T) {
+	s := newTTLStore()
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				s.increment("failures", strconv.Itoa(j%20), time.Minute)
+				s.scan("failures", func(key, value string) {})
+			}
+		}()
+	}
+	wg.Wait()
+
+	entries := storeEntries(s, "failures")
+	Equal(t, 20, len(entries))
+	for _, value := range entries {
+		Equal(t, "40", value)
+	}
+}
+
+func TestTTLStore_ConcurrentTake(t *testing.T) {
+	s := newTTLStore()
+	s.set("nonces", "abc", "value", time.Minute)
+	var taken int32
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, ok := s.take("nonces", "abc"); ok {
+				atomic.AddInt32(&taken, 1)
+			}
+		}()
+	}
+	wg.Wait()
+	Equal(t, int32(1), taken)
+
+	s.set("nonces", "expired", "value", -time.Second)
+	_, ok := s.take("nonces", "expired")
+	False(t, ok)
+}
+
+func TestTTLStore_HandlerNamespacesRestored(t *testing.T) {
+	dir := tmpDir(t)
+	defer os.RemoveAll(dir)
+
+	cfg := DefaultConfig()
+	cfg.Backends = Options{"simple": {"bob": "secret"}}
+	cfg.StateFile = filepath.Join(dir, "state.json")
+	h, err := NewHandler(cfg)
+	NoError(t, err)
+	h.store.increment(oauthCallbackNamespace, flowKey("abc"), oauthFlowTTL)
+	h.store.set(oauthFlowNamespace, flowKey("abc"), `{"provider":"github"}`, oauthFlowTTL)
+	NoError(t, h.store.writeSnapshot(cfg.StateFile))
+
+	// the namespaces are registered before the state is loaded, also without an oauth provider
+	restored, err := NewHandler(cfg)
+	NoError(t, err)
+	_, consumed := restored.store.get(oauthCallbackNamespace, flowKey("abc"))
+	True(t, consumed)
+	_, started := restored.store.get(oauthFlowNamespace, flowKey("abc"))
+	True(t, started)
+}
+
+// storeEntries returns the entries of the namespace, which are not expired
+func storeEntries(s *ttlStore, namespace string) map[string]string {
+	entries := map[string]string{}
+	s.scan(namespace, func(key, value string) {
+		entries[key] = value
+	})
+	return entries
+}
+
+func tmpDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "loginsrv_state")
+	NoError(t, err)
+	return dir
+}
@@ -0,0 +1,97 @@
+package login
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+
+	"github.com/tarent/loginsrv/model"
+	"gopkg.in/yaml.v3"
+)
+
+// userFileEntry is an entry of the -user-file with the claims for the matching users.
+// A user matches, if every set field matches: sub, origin and domain exactly, groups by one of the groups.
+// An entry without fields matches every user.
+type userFileEntry struct {
+	Sub    string                 `yaml:"sub"`
+	Origin string                 `yaml:"origin"`
+	Domain string                 `yaml:"domain"`
+	Groups []string               `yaml:"groups"`
+	Claims map[string]interface{} `yaml:"claims"`
+
+	// claims are the claims as json
+	claims extraClaims
+}
+
+// userFile adds the claims of the first matching entry to the tokens of a user
+type userFile []userFileEntry
+
+// loadUserFile reads and validates the -user-file.
+// Unknown fields, entries without claims and reserved claims are rejected.
+func loadUserFile(file string, m claimMap) (userFile, error) {
+	if file == "" {
+		return nil, nil
+	}
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var entries userFile
+	decoder := yaml.NewDecoder(bytes.NewReader(b))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&entries); err != nil && err != io.EOF {
+		return nil, err
+	}
+	if len(entries) == 0 {
+		return nil, errors.New("no entries")
+	}
+	reserved := reservedClaims(m)
+	for i := range entries {
+		e := &entries[i]
+		if len(e.Claims) == 0 {
+			return nil, fmt.Errorf("entry %v has no claims", i+1)
+		}
+		e.claims = extraClaims{}
+		for name, value := range e.Claims {
+			if reserved[name] {
+				return nil, fmt.Errorf("entry %v: the claim %q is reserved", i+1, name)
+			}
+			if e.claims[name], err = json.Marshal(value); err != nil {
+				return nil, fmt.Errorf("entry %v: the claim %q is no json value: %v", i+1, name, err)
+			}
+		}
+	}
+	return entries, nil
+}
+
+// claimsFor returns the claims of the first entry, which matches the user, or nil
+func (f userFile) claimsFor(userInfo model.UserInfo) extraClaims {
+	for _, e := range f {
+		if e.matches(userInfo) {
+			return e.claims
+		}
+	}
+	return nil
+}
+
+func (e userFileEntry) matches(userInfo model.UserInfo) bool {
+	if e.Sub != "" && e.Sub != userInfo.Sub ||
+		e.Origin != "" && e.Origin != userInfo.Origin ||
+		e.Domain != "" && e.Domain != userInfo.Domain {
+		return false
+	}
+	if len(e.Groups) == 0 {
+		return true
+	}
+	for _, group := range e.Groups {
+		for _, userGroup := range userInfo.Groups {
+			if group == userGroup {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,121 @@
+package login
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	. "github.com/stretchr/testify/assert"
+	"github.com/tarent/loginsrv/model"
+)
+
+const testUserFile = `
+- sub: bob
+  origin: simple
+  claims:
+    role: superAdmin
+    projects: [a, b]
+
+- domain: example.org
+  origin: google
+  claims:
+    role: member
+
+- groups:
+    - example/subgroup
+    - othergroup
+  claims:
+    role: admin
+    level: 3
+
+- claims:
+    role: unknown
+`
+
+func writeUserFile(t *testing.T, content string) string {
+	file := filepath.Join(tmpDir(t), "users.yml")
+	NoError(t, ioutil.WriteFile(file, []byte(content), 0600))
+	return file
+}
+
+func TestUserFile(t *testing.T) {
+	f, err := loadUserFile(writeUserFile(t, testUserFile), nil)
+	NoError(t, err)
+
+	for _, test := range []struct {
+		userInfo model.UserInfo
+		claims   extraClaims
+	}{
+		{model.UserInfo{Sub: "bob", Origin: "simple"}, extraClaims{"role": json.RawMessage(`"superAdmin"`), "projects": json.RawMessage(`["a","b"]`)}},
+		{model.UserInfo{Sub: "bob", Origin: "google", Domain: "example.org"}, extraClaims{"role": json.RawMessage(`"member"`)}},
+		{model.UserInfo{Sub: "alice", Groups: []string{"users", "othergroup"}}, extraClaims{"role": json.RawMessage(`"admin"`), "level": json.RawMessage(`3`)}},
+		{model.UserInfo{Sub: "alice", Origin: "google", Domain: "example.com"}, extraClaims{"role": json.RawMessage(`"unknown"`)}},
+	} {
+		Equal(t, test.claims, f.claimsFor(test.userInfo), test.userInfo.Sub)
+	}
+
+	// without a catch all, other users get no claims
+	f, err = loadUserFile(writeUserFile(t, "- sub: bob\n  claims:\n    role: admin\n"), nil)
+	NoError(t, err)
+	Nil(t, f.claimsFor(model.UserInfo{Sub: "alice"}))
+
+	f, err = loadUserFile("", nil)
+	NoError(t, err)
+	Nil(t, f)
+
+	for content, message := range map[string]string{
+		"":             "no entries",
+		"- sub: bob\n": "entry 1 has no claims",
+		"- claims: {role: a}\n- sub: bob\n  claims:\n    sub: alice\n": `entry 2: the claim "sub" is reserved`,
+		"- user: bob\n  claims: {role: a}\n":                           "yaml: unmarshal errors:\n  line 1: field user not found in type login.userFileEntry",
+		"- claims: {ratio: .nan}\n":                                    `entry 1: the claim "ratio" is no json value: json: unsupported value: NaN`,
+	} {
+		_, err := loadUserFile(writeUserFile(t, content), nil)
+		EqualError(t, err, message, content)
+	}
+
+	// the names of the claim map are reserved as well
+	_, err = loadUserFile(writeUserFile(t, "- claims: {preferred_username: a}\n"), claimMap{"sub": "preferred_username"})
+	EqualError(t, err, `entry 1: the claim "preferred_username" is reserved`)
+}
+
+func TestHandler_UserFile(t *testing.T) {
+	cfg := testConfig()
+	cfg.Backends = Options{"simple": {"bob": "secret", "alice": "secret"}}
+	cfg.JwtClaims = "tenant=acme,role=none"
+	cfg.UserFile = writeUserFile(t, testUserFile)
+	h, err := NewHandler(cfg)
+	NoError(t, err)
+
+	recorder := callHandler(h, req("POST", "/context/login", "username=bob&password=secret", TypeForm, AcceptJwt))
+	Equal(t, 200, recorder.Code)
+	token := recorder.Body.String()
+	claims, err := tokenAsMap(token)
+	NoError(t, err)
+	Equal(t, "superAdmin", claims["role"])
+	Equal(t, []interface{}{"a", "b"}, claims["projects"])
+	// the static claims are kept, unless set by the user file
+	Equal(t, "acme", claims["tenant"])
+
+	claims, err = tokenAsMap(callHandler(h, req("POST", "/context/login", "username=alice&password=secret", TypeForm, AcceptJwt)).Body.String())
+	NoError(t, err)
+	Equal(t, "unknown", claims["role"])
+
+	// the changed file is applied to the refreshes after a reload
+	next := *cfg
+	next.UserFile = writeUserFile(t, "- sub: bob\n  claims:\n    role: reader\n")
+	NoError(t, h.reload(&next))
+	recorder = callHandler(h, req("POST", "/context/login", "", AcceptJwt, "Authorization: Bearer "+token))
+	Equal(t, 200, recorder.Code)
+	claims, err = tokenAsMap(recorder.Body.String())
+	NoError(t, err)
+	Equal(t, "reader", claims["role"])
+	Nil(t, claims["projects"])
+
+	cfg.UserFile = writeUserFile(t, "- sub: bob\n")
+	_, err = NewHandler(cfg)
+	EqualError(t, err, "Invalid user file: entry 1 has no claims")
+	_, err = NewTokenService(cfg)
+	EqualError(t, err, "Invalid user file: entry 1 has no claims")
+}
@@ -0,0 +1,115 @@
+package login
+
+import (
+	"fmt"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/tarent/loginsrv/model"
+)
+
+// The backend options with the users, which are permitted or denied to log in, separated by ;
+const (
+	allowUsersOption = "allow-users"
+	denyUsersOption  = "deny-users"
+)
+
+var errAPIUserNotPermitted = apiError{403, "user_not_permitted", "Forbidden: The user is not permitted to log in"}
+
+// userFilter decides, which of the users authenticated by a backend get tokens.
+// The entries are exact usernames or globs like `admin-*`.
+type userFilter struct {
+	allow []string
+	deny  []string
+}
+
+// newUserFilter reads the allow-users and deny-users options of a backend.
+// It returns the filter, nil without lists, and the options without them for the backend.
+func newUserFilter(options map[string]string) (*userFilter, map[string]string, error) {
+	allow, allowSet := options[allowUsersOption]
+	deny, denySet := options[denyUsersOption]
+	if !allowSet && !denySet {
+		return nil, options, nil
+	}
+	backendOptions := map[string]string{}
+	for k, v := range options {
+		if k != allowUsersOption && k != denyUsersOption {
+			backendOptions[k] = v
+		}
+	}
+	f := &userFilter{}
+	var err error
+	if f.allow, err = parseUserPatterns(allowUsersOption, allow); err != nil {
+		return nil, nil, err
+	}
+	if f.deny, err = parseUserPatterns(denyUsersOption, deny); err != nil {
+		return nil, nil, err
+	}
+	if allowSet && len(f.allow) == 0 {
+		return nil, nil, fmt.Errorf("empty %v, nobody could log in", allowUsersOption)
+	}
+	return f, backendOptions, nil
+}
+
+func parseUserPatterns(option, list string) ([]string, error) {
+	var patterns []string
+	for _, p := range strings.Split(list, ";") {
+		if p = strings.TrimSpace(p); p == "" {
+			continue
+		}
+		if _, err := path.Match(p, ""); err != nil {
+			return nil, fmt.Errorf("invalid pattern %q in %v: %v", p, option, err)
+		}
+		patterns = append(patterns, p)
+	}
+	return patterns, nil
+}
+
+// permits checks the username against the lists, the deny list wins.
+// For denied users, the reason is returned.
+func (f *userFilter) permits(username string) (bool, string) {
+	if matchesUserPattern(f.deny, username) {
+		return false, "deny list"
+	}
+	if len(f.allow) > 0 && !matchesUserPattern(f.allow, username) {
+		return false, "not in the allow list"
+	}
+	return true, ""
+}
+
+func matchesUserPattern(patterns []string, username string) bool {
+	for _, p := range patterns {
+		if matched, _ := path.Match(p, username); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// userPermitted checks the user against the lists of the backend, which authenticated it.
+// Users of backends without lists, e.g. of oauth providers, are permitted.
+func (h *Handler) userPermitted(userInfo model.UserInfo) (bool, string) {
+	f := h.userFilters[userInfo.Origin]
+	if f == nil {
+		return true, ""
+	}
+	return f.permits(userInfo.Sub)
+}
+
+// respondUserNotPermitted rejects an authenticated user, who is not permitted by the lists of the backend
+func (h *Handler) respondUserNotPermitted(w http.ResponseWriter, r *http.Request, username string) {
+	if h.wantHTML(r) {
+		writeLoginForm(w,
+			loginFormData{
+				Failure:        true,
+				FailureMessage: errAPIUserNotPermitted.message,
+				Config:         h.formConfig(),
+				Language:       formLanguage(r),
+				UserInfo:       model.UserInfo{Sub: username},
+				status:         errAPIUserNotPermitted.status,
+			})
+		return
+	}
+	h.respondAPIError(w, r, errAPIUserNotPermitted)
+}
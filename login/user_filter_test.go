@@ -0,0 +1,97 @@
+package login
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	. "github.com/stretchr/testify/assert"
+	"github.com/tarent/loginsrv/model"
+)
+
+func TestUserFilter(t *testing.T) {
+	f, options, err := newUserFilter(map[string]string{"file": "users", "allow-users": "alice; team-*", "deny-users": "team-mallory"})
+	NoError(t, err)
+	Equal(t, map[string]string{"file": "users"}, options)
+
+	for _, test := range []struct {
+		username  string
+		permitted bool
+		reason    string
+	}{
+		{"alice", true, ""},
+		{"team-bob", true, ""},
+		{"bob", false, "not in the allow list"},
+		{"team-mallory", false, "deny list"},
+	} {
+		permitted, reason := f.permits(test.username)
+		Equal(t, test.permitted, permitted, test.username)
+		Equal(t, test.reason, reason, test.username)
+	}
+
+	// only a deny list
+	f, _, err = newUserFilter(map[string]string{"deny-users": "mallory"})
+	NoError(t, err)
+	permitted, _ := f.permits("bob")
+	True(t, permitted)
+
+	// without lists, the options are kept
+	f, options, err = newUserFilter(map[string]string{"bob": "secret"})
+	NoError(t, err)
+	Nil(t, f)
+	Equal(t, map[string]string{"bob": "secret"}, options)
+
+	_, _, err = newUserFilter(map[string]string{"allow-users": "[a-"})
+	Error(t, err)
+	_, _, err = newUserFilter(map[string]string{"allow-users": " ; "})
+	EqualError(t, err, "empty allow-users, nobody could log in")
+}
+
+func TestHandler_UserFilter(t *testing.T) {
+	cfg := testConfig()
+	cfg.Backends = Options{"simple": {"bob": "secret", "alice": "secret", "allow-users": "bob;alice", "deny-users": "alice"}}
+	h, err := NewHandler(cfg)
+	NoError(t, err)
+	rec := &eventRecorder{}
+	h.Subscribe(Subscriber{Name: "test", Handle: rec.handle})
+
+	// the options are no users of the simple backend
+	recorder := callHandler(h, req("POST", "/context/login", `{"username": "allow-users", "password": "bob;alice"}`, TypeJSON, AcceptJwt))
+	Equal(t, 403, recorder.Code)
+
+	recorder = callHandler(h, req("POST", "/context/login", `{"username": "bob", "password": "secret"}`, TypeJSON, AcceptJwt))
+	Equal(t, 200, recorder.Code)
+
+	recorder = callHandler(h, req("POST", "/context/login", `{"username": "alice", "password": "secret"}`, TypeJSON, "X-Login-API-Version: 2"))
+	Equal(t, 403, recorder.Code)
+	p := problem{}
+	NoError(t, json.Unmarshal(recorder.Body.Bytes(), &p))
+	Equal(t, "user_not_permitted", p.Code)
+	Equal(t, []EventType{EventLoginFailed, EventLoginSucceeded, EventLoginNotPermitted}, rec.types())
+	Equal(t, "deny list", rec.events[2].Reason)
+	Equal(t, "simple", rec.events[2].Origin)
+
+	recorder = callHandler(h, req("POST", "/context/login", "username=alice&password=secret", TypeForm, AcceptHTML))
+	Equal(t, 403, recorder.Code)
+	Contains(t, recorder.Body.String(), "not permitted")
+
+	// the lists are reloaded with the configuration, also blocking the refreshes of existing tokens
+	next := testConfig()
+	next.Backends = Options{"simple": {"bob": "secret", "alice": "secret", "deny-users": "bob"}}
+	NoError(t, h.reload(next))
+	recorder = callHandler(h, req("POST", "/context/login", `{"username": "alice", "password": "secret"}`, TypeJSON, AcceptJwt))
+	Equal(t, 200, recorder.Code)
+	recorder = callHandler(h, req("POST", "/context/login", `{"username": "bob", "password": "secret"}`, TypeJSON, AcceptJwt))
+	Equal(t, 403, recorder.Code)
+
+	token, err := h.snapshot().createToken(model.UserInfo{Sub: "bob", Origin: "simple", Expiry: time.Now().Add(time.Minute).Unix()})
+	NoError(t, err)
+	recorder = callHandler(h, req("POST", "/context/login", "", AcceptJwt, "Cookie: "+cfg.CookieName+"="+token))
+	Equal(t, 403, recorder.Code)
+
+	// invalid patterns are configuration errors
+	invalid := testConfig()
+	invalid.Backends = Options{"simple": {"bob": "secret", "allow-users": "[b-"}}
+	_, err = NewHandler(invalid)
+	Error(t, err)
+}
@@ -0,0 +1,173 @@
+package login
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"time"
+)
+
+// verificationBundleVersion is the version of the format of the verification bundle.
+// Changes of the settings are detected by the revision of the bundle.
+const verificationBundleVersion = 1
+
+// verificationClaims maps the meaning of the claims to their names in the tokens
+var verificationClaims = map[string]string{
+	"subject":       "sub",
+	"name":          "name",
+	"email":         "email",
+	"groups":        "groups",
+	"origin":        "origin",
+	"domain":        "domain",
+	"issuer":        "iss",
+	"audience":      "aud",
+	"expiry":        "exp",
+	"issued_at":     "iat",
+	"not_before":    "nbf",
+	"token_id":      "jti",
+	"refreshes":     "refs",
+	"session_start": "origin_iat",
+	"token_type":    "token_type",
+}
+
+// verificationBundle describes, how the tokens of this instance are verified by other services.
+// It is exported as a jwt signed with the key of the tokens.
+type verificationBundle struct {
+	Version             int               `json:"version"`
+	Revision            string            `json:"revision"`
+	TokenServiceVersion int               `json:"token_service_version"`
+	Algorithm           string            `json:"alg"`
+	JWKSURI             string            `json:"jwks_uri,omitempty"`
+	Keys                []jwk             `json:"keys,omitempty"`
+	SharedSecret        bool              `json:"shared_secret,omitempty"`
+	Issuer              string            `json:"iss,omitempty"`
+	Audiences           []string          `json:"aud,omitempty"`
+	LeewaySeconds       int64             `json:"leeway_seconds"`
+	NotBefore           bool              `json:"not_before,omitempty"`
+	Claims              map[string]string `json:"claims"`
+	IssuedAt            int64             `json:"iat,omitempty"`
+}
+
+// Valid lets us use the bundle as claims for jwt-go
+func (b verificationBundle) Valid() error {
+	return nil
+}
+
+// verificationClaims returns the names of the claims in the tokens of the token service
+func (s *TokenService) verificationClaims() map[string]string {
+	if len(s.claimMap) == 0 {
+		return verificationClaims
+	}
+	claims := make(map[string]string, len(verificationClaims))
+	for meaning, name := range verificationClaims {
+		claims[meaning] = s.claimMap.nameOf(name)
+	}
+	return claims
+}
+
+// verificationBundle describes the verification rules of the token service.
+// The public keys are embedded, with a jwt secret, the verifiers need the shared secret.
+func (s *TokenService) verificationBundle(jwksURI string) (verificationBundle, error) {
+	b := verificationBundle{
+		Version:             verificationBundleVersion,
+		TokenServiceVersion: TokenServiceVersion,
+		Algorithm:           s.signer.SigningMethod().Alg(),
+		Issuer:              s.instanceID,
+		Audiences:           s.audiences,
+		LeewaySeconds:       int64(s.leeway / time.Second),
+		NotBefore:           s.notBefore,
+		Claims:              s.verificationClaims(),
+	}
+	keys := s.signer.PublicKeys()
+	if len(keys) == 0 {
+		b.SharedSecret = true
+	} else {
+		b.JWKSURI = jwksURI
+	}
+	for _, key := range keys {
+		k, err := newJWK(key, b.Algorithm)
+		if err != nil {
+			return verificationBundle{}, err
+		}
+		b.Keys = append(b.Keys, k)
+	}
+
+	// the revision is the hash of the settings, so it only changes with them
+	settings, err := json.Marshal(b)
+	if err != nil {
+		return verificationBundle{}, err
+	}
+	revision := sha256.Sum256(settings)
+	b.Revision = base64.RawURLEncoding.EncodeToString(revision[:])
+	return b, nil
+}
+
+// ExportVerification returns the verification bundle as jwt signed with the key of the tokens.
+// The jwks uri is set for public keys, if not empty.
+func (s *TokenService) ExportVerification(jwksURI string) (string, error) {
+	return s.exportVerification(context.Background(), jwksURI)
+}
+
+func (s *TokenService) exportVerification(ctx context.Context, jwksURI string) (string, error) {
+	b, err := s.verificationBundle(jwksURI)
+	if err != nil {
+		return "", err
+	}
+	b.IssuedAt = time.Now().Unix()
+	return signToken(ctx, s.signer, b)
+}
+
+func (h *Handler) isVerificationPath(r *http.Request) bool {
+	return r.URL.Path == path.Join(h.config.LoginPath, "verification")
+}
+
+// respondVerification exports the verification bundle of the current configuration.
+func (h *Handler) respondVerification(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" && r.Method != "HEAD" {
+		h.respondBadRequest(w, r)
+		return
+	}
+	jwksURI := requestBaseURL(r)
+	jwksURI.Path = path.Join(h.config.LoginPath, "jwks.json")
+	bundle, err := h.tokenService().exportVerification(r.Context(), jwksURI.String())
+	if err != nil {
+		h.respondError(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", contentTypeJWT)
+	w.Header().Set("Cache-Control", jwksCacheControl)
+	w.WriteHeader(200)
+	fmt.Fprint(w, bundle)
+}
+
+// ExportVerificationCommand runs the export-verification command. It reads the configuration
+// from the arguments and the environment like loginsrv and writes the verification bundle to out.
+// It returns the exit code: 0 on success, 1 if the bundle could not be created and 2 on invalid arguments.
+func ExportVerificationCommand(args []string, out io.Writer) int {
+	f := flag.NewFlagSet("export-verification", flag.ContinueOnError)
+	f.SetOutput(out)
+	var jwksURI string
+	f.StringVar(&jwksURI, "jwks-uri", "", "The public url of the jwks of the instance, e.g. https://sso.example.com/login/jwks.json")
+	config, err := readConfig(f, args)
+	if err != nil {
+		return 2
+	}
+	tokens, err := NewTokenService(config)
+	if err != nil {
+		fmt.Fprintln(out, err)
+		return 1
+	}
+	bundle, err := tokens.ExportVerification(jwksURI)
+	if err != nil {
+		fmt.Fprintln(out, err)
+		return 1
+	}
+	fmt.Fprintln(out, bundle)
+	return 0
+}
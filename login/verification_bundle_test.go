@@ -0,0 +1,110 @@
+package login
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	. "github.com/stretchr/testify/assert"
+)
+
+func verificationBundleOf(t *testing.T, token string, key interface{}) jwt.MapClaims {
+	claims := jwt.MapClaims{}
+	_, err := jwt.ParseWithClaims(strings.TrimSpace(token), claims, func(*jwt.Token) (interface{}, error) {
+		return key, nil
+	})
+	NoError(t, err)
+	return claims
+}
+
+func TestHandler_Verification_PublicKey(t *testing.T) {
+	key, _ := rsa.GenerateKey(rand.Reader, 2048)
+	cfg := DefaultConfig()
+	cfg.Backends = Options{"simple": {"bob": "secret"}}
+	cfg.JwtPrivateKey = writeRSAKey(t, key)
+	cfg.InstanceID = "sso"
+	cfg.JwtAudience = "api,admin"
+	cfg.JwtLeeway = 30 * time.Second
+	h, err := NewHandler(cfg)
+	NoError(t, err)
+
+	recorder := httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("GET", "/login/verification", "", "X-Forwarded-Host: sso.example.com", "X-Forwarded-Proto: https"))
+	Equal(t, 200, recorder.Code)
+	Equal(t, contentTypeJWT, recorder.Header().Get("Content-Type"))
+
+	bundle := verificationBundleOf(t, recorder.Body.String(), &key.PublicKey)
+	Equal(t, float64(verificationBundleVersion), bundle["version"])
+	Equal(t, float64(TokenServiceVersion), bundle["token_service_version"])
+	Equal(t, "RS256", bundle["alg"])
+	Equal(t, "https://sso.example.com/login/jwks.json", bundle["jwks_uri"])
+	Equal(t, "sso", bundle["iss"])
+	Equal(t, []interface{}{"api", "admin"}, bundle["aud"])
+	Equal(t, float64(30), bundle["leeway_seconds"])
+	Equal(t, "groups", bundle["claims"].(map[string]interface{})["groups"])
+	Nil(t, bundle["shared_secret"])
+
+	// the embedded keys are the keys of the jwks
+	keys := bundle["keys"].([]interface{})
+	Equal(t, 1, len(keys))
+	Equal(t, keyID(h.tokenSigner()), keys[0].(map[string]interface{})["kid"])
+
+	// the revision changes with the settings only
+	revision := bundle["revision"]
+	NotEmpty(t, revision)
+	bundle2, err := h.tokenService().verificationBundle("https://sso.example.com/login/jwks.json")
+	NoError(t, err)
+	Equal(t, revision, bundle2.Revision)
+	h.config.JwtLeeway = time.Minute
+	bundle2, err = h.tokenService().verificationBundle("https://sso.example.com/login/jwks.json")
+	NoError(t, err)
+	NotEqual(t, revision, bundle2.Revision)
+
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("POST", "/login/verification", ""))
+	Equal(t, 400, recorder.Code)
+}
+
+func TestHandler_Verification_Secret(t *testing.T) {
+	h := testHandler()
+	recorder := httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("GET", "/context/login/verification", ""))
+	Equal(t, 200, recorder.Code)
+
+	bundle := verificationBundleOf(t, recorder.Body.String(), []byte(h.config.JwtSecret))
+	Equal(t, "HS512", bundle["alg"])
+	Equal(t, true, bundle["shared_secret"])
+	Nil(t, bundle["keys"])
+	Nil(t, bundle["jwks_uri"])
+}
+
+func TestExportVerificationCommand(t *testing.T) {
+	args := []string{"-jwt-secret", "export-secret", "-jwt-audience", "api", "-jwt-leeway", "5s", "-simple", "bob=secret"}
+	out := &bytes.Buffer{}
+	Equal(t, 0, ExportVerificationCommand(args, out))
+	exported := verificationBundleOf(t, out.String(), []byte("export-secret"))
+	Equal(t, []interface{}{"api"}, exported["aud"])
+	Equal(t, float64(5), exported["leeway_seconds"])
+
+	// the command and the handler of the same configuration export the same bundle
+	cfg := DefaultConfig()
+	cfg.JwtSecret = "export-secret"
+	cfg.JwtAudience = "api"
+	cfg.JwtLeeway = 5 * time.Second
+	cfg.Backends = Options{"simple": {"bob": "secret"}}
+	h, err := NewHandler(cfg)
+	NoError(t, err)
+	recorder := httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("GET", "/login/verification", ""))
+	served := verificationBundleOf(t, recorder.Body.String(), []byte("export-secret"))
+	Equal(t, exported["revision"], served["revision"])
+
+	out.Reset()
+	Equal(t, 2, ExportVerificationCommand([]string{"-unknown"}, out))
+	Equal(t, 1, ExportVerificationCommand([]string{"-jwt-algo", "RS256"}, out))
+}
@@ -0,0 +1,154 @@
+package login
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+
+	"github.com/tarent/loginsrv/logging"
+	"github.com/tarent/loginsrv/model"
+)
+
+var (
+	errAPIUnauthenticated   = apiError{401, "unauthenticated", "Unauthorized: No valid token"}
+	errAPIRequirementNotMet = apiError{403, "requirement_not_met", "Forbidden: The token does not meet the requirements"}
+)
+
+// The query parameters of the verify endpoint for the authorization checks
+const (
+	requireGroupParameter = "require-group"
+	requireClaimParameter = "require-claim"
+)
+
+// claimValues returns the values of the claims, which can be required at the verify endpoint.
+// The groups claim has one value per group.
+var claimValues = map[string]func(u model.UserInfo) []string{
+	"sub":    func(u model.UserInfo) []string { return []string{u.Sub} },
+	"name":   func(u model.UserInfo) []string { return []string{u.Name} },
+	"email":  func(u model.UserInfo) []string { return []string{u.Email} },
+	"origin": func(u model.UserInfo) []string { return []string{u.Origin} },
+	"domain": func(u model.UserInfo) []string { return []string{u.Domain} },
+	"iss":    func(u model.UserInfo) []string { return []string{u.Issuer} },
+	"aud":    func(u model.UserInfo) []string { return []string{u.Audience} },
+	"groups": func(u model.UserInfo) []string { return u.Groups },
+}
+
+// defaultVerifyHeaders are the identity headers of the verify endpoint without -verify-headers
+const defaultVerifyHeaders = "sub:X-Auth-User,email:X-Auth-Email,origin:X-Auth-Origin,groups:X-Auth-Groups"
+
+// verifyHeader sets a claim of valid tokens as response header of the verify endpoint
+type verifyHeader struct {
+	claim  string
+	header string
+}
+
+// parseVerifyHeaders reads the -verify-headers, e.g. sub:X-Auth-User,groups:X-Auth-Groups.
+// The claims are the ones, which can be required at the verify endpoint.
+func parseVerifyHeaders(list string) ([]verifyHeader, error) {
+	var headers []verifyHeader
+	for _, entry := range strings.Split(list, ",") {
+		if strings.TrimSpace(entry) == "" {
+			continue
+		}
+		parts := strings.SplitN(entry, ":", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("Invalid verify header entry %q, it has to be claim:header", entry)
+		}
+		claim, header := strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1])
+		if _, known := claimValues[claim]; !known {
+			return nil, fmt.Errorf("Unknown claim %q in the verify headers", claim)
+		}
+		if !isHeaderName(header) {
+			return nil, fmt.Errorf("Invalid verify header %q, only letters, digits and - are allowed", header)
+		}
+		headers = append(headers, verifyHeader{claim: claim, header: http.CanonicalHeaderKey(header)})
+	}
+	return headers, nil
+}
+
+// requirement is a claim value, the token has to contain
+type requirement struct {
+	claim string
+	value string
+}
+
+func (req requirement) metBy(u model.UserInfo) bool {
+	for _, v := range claimValues[req.claim](u) {
+		if v == req.value {
+			return true
+		}
+	}
+	return false
+}
+
+// parseRequirements reads the requirements of the query:
+// require-group=<group> and require-claim=<claim>:<value>, each repeatable.
+func parseRequirements(query url.Values) ([]requirement, error) {
+	var requirements []requirement
+	for _, group := range query[requireGroupParameter] {
+		if group == "" {
+			return nil, fmt.Errorf("empty %v", requireGroupParameter)
+		}
+		requirements = append(requirements, requirement{claim: "groups", value: group})
+	}
+	for _, claim := range query[requireClaimParameter] {
+		parts := strings.SplitN(claim, ":", 2)
+		if len(parts) != 2 || parts[1] == "" {
+			return nil, fmt.Errorf("%v has to be in the form <claim>:<value>, but was %q", requireClaimParameter, claim)
+		}
+		if _, known := claimValues[parts[0]]; !known {
+			return nil, fmt.Errorf("unknown claim %q in %v", parts[0], requireClaimParameter)
+		}
+		requirements = append(requirements, requirement{claim: parts[0], value: parts[1]})
+	}
+	return requirements, nil
+}
+
+func (h *Handler) isVerifyPath(r *http.Request) bool {
+	return r.URL.Path == path.Join(h.config.LoginPath, "verify")
+}
+
+// respondVerify checks the token of the request for proxies, e.g. by the nginx auth_request or the traefik forward auth.
+// The token is taken from the bearer authorization header or the cookie.
+// Valid tokens, which meet all requirements of the query, are answered with 200 and the headers of the -verify-headers,
+// requests without valid token with 401 and tokens, which do not meet the requirements with 403.
+func (h *Handler) respondVerify(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" && r.Method != "HEAD" {
+		h.respondBadRequest(w, r)
+		return
+	}
+	requirements, err := parseRequirements(r.URL.Query())
+	if err != nil {
+		h.respondAPIError(w, r, apiError{400, "invalid_requirement", "Bad Request: " + err.Error()})
+		return
+	}
+
+	userInfo, failure := h.verifyToken(r, "")
+	if failure != "" {
+		// hints for the proxy, where to send the user for a login
+		w.Header().Set("WWW-Authenticate", `Bearer realm="loginsrv"`)
+		w.Header().Set("X-Auth-Login-Url", h.config.LoginPath)
+		h.respondAPIError(w, r, errAPIUnauthenticated)
+		return
+	}
+	for _, req := range requirements {
+		if !req.metBy(userInfo) {
+			logging.Application(r.Header).
+				WithField("username", userInfo.Sub).
+				WithField("claim", req.claim).
+				WithField("value", req.value).
+				Info("verify: token does not meet the requirement")
+			h.respondAPIError(w, r, errAPIRequirementNotMet)
+			return
+		}
+	}
+
+	for _, header := range h.verifyHeaders {
+		if values := claimValues[header.claim](userInfo); len(values) > 0 && values[0] != "" {
+			w.Header().Set(header.header, strings.Join(values, ","))
+		}
+	}
+	w.WriteHeader(200)
+}
@@ -0,0 +1,136 @@
+package login
+
+import (
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	. "github.com/stretchr/testify/assert"
+	"github.com/tarent/loginsrv/model"
+)
+
+func TestHandler_Verify(t *testing.T) {
+	h := testHandler()
+	h.verifyHeaders, _ = parseVerifyHeaders(defaultVerifyHeaders)
+	token, err := h.createToken(model.UserInfo{
+		Sub:    "bob",
+		Email:  "bob@example.com",
+		Origin: "htpasswd",
+		Groups: []string{"admins", "dev"},
+		Expiry: time.Now().Add(time.Minute).Unix(),
+	})
+	NoError(t, err)
+	cookie := "Cookie: " + h.config.CookieName + "=" + token
+
+	verify := func(query string, header ...string) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		h.ServeHTTP(recorder, req("GET", "/context/login/verify"+query, "", header...))
+		return recorder
+	}
+
+	recorder := verify("", cookie)
+	Equal(t, 200, recorder.Code)
+	Equal(t, "bob", recorder.Header().Get("X-Auth-User"))
+	Equal(t, "bob@example.com", recorder.Header().Get("X-Auth-Email"))
+	Equal(t, "htpasswd", recorder.Header().Get("X-Auth-Origin"))
+	Equal(t, "admins,dev", recorder.Header().Get("X-Auth-Groups"))
+
+	// the token can also be sent as bearer, e.g. by a traefik forward auth
+	recorder = verify("", "Authorization: Bearer "+token)
+	Equal(t, 200, recorder.Code)
+	Equal(t, "bob", recorder.Header().Get("X-Auth-User"))
+
+	recorder = verify("")
+	Equal(t, 401, recorder.Code)
+	Equal(t, `Bearer realm="loginsrv"`, recorder.Header().Get("WWW-Authenticate"))
+	Equal(t, "/context/login", recorder.Header().Get("X-Auth-Login-Url"))
+	Equal(t, 401, verify("", "Authorization: Bearer garbage").Code)
+	Equal(t, 401, verify("", "Cookie: "+h.config.CookieName+"=garbage").Code)
+	Equal(t, 401, verify("?require-group=admins").Code)
+
+	tests := []struct {
+		query string
+		code  int
+	}{
+		{"?require-group=admins", 200},
+		{"?require-group=admins&require-group=dev", 200},
+		{"?require-group=admins&require-group=ops", 403},
+		{"?require-claim=origin:htpasswd", 200},
+		{"?require-claim=origin:github", 403},
+		{"?require-group=dev&require-claim=email:bob@example.com", 200},
+		{"?require-claim=groups:dev", 200},
+		{"?require-claim=realm:internal", 400},
+		{"?require-claim=origin", 400},
+		{"?require-claim=origin:", 400},
+		{"?require-group=", 400},
+	}
+	for _, test := range tests {
+		t.Run(test.query, func(t *testing.T) {
+			recorder := verify(test.query, cookie)
+			Equal(t, test.code, recorder.Code)
+			if test.code != 200 {
+				Equal(t, "", recorder.Header().Get("X-Auth-User"))
+			}
+		})
+	}
+
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("POST", "/context/login/verify", "", cookie))
+	Equal(t, 400, recorder.Code)
+}
+
+func TestHandler_VerifyHeaders(t *testing.T) {
+	cfg := testConfig()
+	cfg.Backends = Options{"simple": {"bob": "secret"}}
+	cfg.VerifyHeaders = "sub:Remote-User, name:Remote-Name,groups:remote-groups"
+	h, err := NewHandler(cfg)
+	NoError(t, err)
+	token, err := h.createToken(model.UserInfo{
+		Sub:    "bob",
+		Groups: []string{"admins"},
+		Expiry: time.Now().Add(time.Minute).Unix(),
+	})
+	NoError(t, err)
+
+	recorder := httptest.NewRecorder()
+	h.ServeHTTP(recorder, req("GET", "/context/login/verify", "", "Authorization: Bearer "+token))
+	Equal(t, 200, recorder.Code)
+	Equal(t, "bob", recorder.Header().Get("Remote-User"))
+	Equal(t, "admins", recorder.Header().Get("Remote-Groups"))
+	// empty claims are not set
+	_, set := recorder.Header()["Remote-Name"]
+	False(t, set)
+	Equal(t, "", recorder.Header().Get("X-Auth-User"))
+}
+
+func TestParseVerifyHeaders(t *testing.T) {
+	headers, err := parseVerifyHeaders(defaultVerifyHeaders)
+	NoError(t, err)
+	Equal(t, verifyHeader{"sub", "X-Auth-User"}, headers[0])
+	Equal(t, 4, len(headers))
+
+	headers, err = parseVerifyHeaders("")
+	NoError(t, err)
+	Empty(t, headers)
+
+	_, err = parseVerifyHeaders("sub")
+	EqualError(t, err, `Invalid verify header entry "sub", it has to be claim:header`)
+	_, err = parseVerifyHeaders("realm:X-Realm")
+	EqualError(t, err, `Unknown claim "realm" in the verify headers`)
+	_, err = parseVerifyHeaders("sub:X User")
+	EqualError(t, err, `Invalid verify header "X User", only letters, digits and - are allowed`)
+}
+
+func TestParseRequirements(t *testing.T) {
+	requirements, err := parseRequirements(url.Values{
+		"require-group": {"admins"},
+		"require-claim": {"aud:api:v2", "sub:bob"},
+	})
+	NoError(t, err)
+	Equal(t, []requirement{{"groups", "admins"}, {"aud", "api:v2"}, {"sub", "bob"}}, requirements)
+
+	requirements, err = parseRequirements(url.Values{})
+	NoError(t, err)
+	Empty(t, requirements)
+}
@@ -1,11 +1,14 @@
 package main
 
 import (
+	_ "github.com/tarent/loginsrv/apikeys"
 	_ "github.com/tarent/loginsrv/htpasswd"
 	_ "github.com/tarent/loginsrv/httpupstream"
 	_ "github.com/tarent/loginsrv/osiam"
 
 	"github.com/tarent/loginsrv/login"
+	"github.com/tarent/loginsrv/setup"
+	"github.com/tarent/loginsrv/smoketest"
 	"github.com/tarent/loginsrv/tracer"
 	"github.com/zean00/trace"
 
@@ -15,24 +18,75 @@ import (
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 
 	"github.com/tarent/loginsrv/logging"
 )
 
 const applicationName = "loginsrv"
 
+// version is set by the build, e.g. go build -ldflags "-X main.version=v1.2.0"
+var version = "dev"
+
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "check" {
+		os.Exit(smoketest.Main(os.Args[2:], os.Stdout))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "init" {
+		os.Exit(setup.Main(os.Args[2:], os.Stdin, os.Stdout))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "export-verification" {
+		os.Exit(login.ExportVerificationCommand(os.Args[2:], os.Stdout))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "token" {
+		os.Exit(login.TokenCommand(os.Args[2:], os.Stdout))
+	}
+
 	config := login.ReadConfig()
 	if err := logging.Set(config.LogLevel, config.TextLogging); err != nil {
 		exit(nil, err)
 	}
 
-	configToLog := *config
-	configToLog.JwtSecret = "..."
-	logging.LifecycleStart(applicationName, configToLog)
+	if config.DumpConfig {
+		if err := login.WriteConfigDump(os.Stdout, config); err != nil {
+			exit(nil, err)
+		}
+		os.Exit(0)
+	}
 
 	h, err := login.NewHandler(config)
 	if err != nil {
+		if config.ValidateOnly {
+			fmt.Fprintln(os.Stderr, err)
+		}
+		exit(nil, err)
+	}
+
+	port := config.Port
+	if port != "" {
+		port = fmt.Sprintf(":%s", port)
+	}
+
+	// logged after the handler creation, which resolves defaults like the jwt algorithm
+	logging.LifecycleStart(applicationName, logging.StartInfo{
+		Version:        version,
+		ConfigHash:     config.Hash(),
+		ListenAddr:     port,
+		Backends:       config.Backends.Names(),
+		OauthProviders: config.Oauth.Names(),
+		Config:         config.Redacted(),
+	})
+
+	if err := h.CheckOauthProviders(); err != nil && (config.StrictStartup || config.ValidateOnly) {
+		exit(nil, err)
+	}
+	if config.ValidateOnly {
+		exit(nil, nil)
+	}
+
+	lifecycle := &login.Lifecycle{}
+	lifecycle.Register(h.Hooks()...)
+	if err := lifecycle.Start(); err != nil {
 		exit(nil, err)
 	}
 
@@ -45,15 +99,32 @@ func main() {
 	stop := make(chan os.Signal)
 	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
 
-	port := config.Port
-	if port != "" {
-		port = fmt.Sprintf(":%s", port)
-	}
+	toggleMaintenance := make(chan os.Signal, 1)
+	signal.Notify(toggleMaintenance, syscall.SIGUSR1)
+	go func() {
+		for range toggleMaintenance {
+			maintenance, _ := h.Maintenance()
+			h.SetMaintenance(!maintenance)
+		}
+	}()
 
 	httpSrv := &http.Server{Addr: port, Handler: chain}
+	if config.TLSCert != "" {
+		tlsConfig, err := config.TLSConfig()
+		if err != nil {
+			exit(nil, err)
+		}
+		httpSrv.TLSConfig = tlsConfig
+	}
 
 	go func() {
-		if err := httpSrv.ListenAndServe(); err != nil {
+		var err error
+		if config.TLSCert != "" {
+			err = httpSrv.ListenAndServeTLS(config.TLSCert, config.TLSKey)
+		} else {
+			err = httpSrv.ListenAndServe()
+		}
+		if err != nil {
 			if err == http.ErrServerClosed {
 				logging.ServerClosed(applicationName)
 			} else {
@@ -61,11 +132,22 @@ func main() {
 			}
 		}
 	}()
-	logging.LifecycleStop(applicationName, <-stop, nil)
+	sig := <-stop
+	logging.LifecycleStop(applicationName, sig, nil)
 
+	start := time.Now()
 	ctx, ctxCancel := context.WithTimeout(context.Background(), config.GracePeriod)
 
-	httpSrv.Shutdown(ctx)
+	err = httpSrv.Shutdown(ctx)
+	if stopErr := lifecycle.Stop(ctx); err == nil {
+		err = stopErr
+	}
+	logging.LifecycleShutdown(applicationName, logging.ShutdownInfo{
+		Signal:               sig,
+		Duration:             time.Since(start),
+		GracePeriodExhausted: ctx.Err() == context.DeadlineExceeded,
+		Err:                  err,
+	})
 	ctxCancel()
 }
 
@@ -8,21 +8,73 @@ import (
 // UserInfo holds the parameters returned by the backends.
 // This information will be serialized to build the JWT token contents.
 type UserInfo struct {
-	Sub       string `json:"sub"`
-	Picture   string `json:"picture,omitempty"`
-	Name      string `json:"name,omitempty"`
-	Email     string `json:"email,omitempty"`
-	Origin    string `json:"origin,omitempty"`
-	Expiry    int64  `json:"exp,omitempty"`
-	Refreshes int    `json:"refs,omitempty"`
-	Domain    string `json:"domain,omitempty"`
+	Sub       string   `json:"sub"`
+	Picture   string   `json:"picture,omitempty"`
+	Name      string   `json:"name,omitempty"`
+	Email     string   `json:"email,omitempty"`
+	Origin    string   `json:"origin,omitempty"`
+	Expiry    int64    `json:"exp,omitempty"`
+	IssuedAt  int64    `json:"iat,omitempty"`
+	NotBefore int64    `json:"nbf,omitempty"`
+	Refreshes int      `json:"refs,omitempty"`
+	Domain    string   `json:"domain,omitempty"`
+	Groups    []string `json:"groups,omitempty"`
+	Issuer    string   `json:"iss,omitempty"`
+	Audience  string   `json:"aud,omitempty"`
+	NoRefresh bool     `json:"norefresh,omitempty"`
+	ID        string   `json:"jti,omitempty"`
+	// SessionStart is the time of the authentication, which is kept on refreshes
+	SessionStart int64 `json:"origin_iat,omitempty"`
+	// TokenType distinguishes access and refresh tokens, if separate refresh tokens are issued
+	TokenType string `json:"token_type,omitempty"`
+
+	Confirmation *Confirmation `json:"cnf,omitempty"`
+
+	// IdPSession is the session at the oauth provider, which authenticated the user
+	IdPSession *IdPSession `json:"idp_session,omitempty"`
+}
+
+// Confirmation is the proof-of-possession claim (RFC 7800),
+// which binds the token to a key of the client.
+type Confirmation struct {
+	// X5tS256 is the base64url encoded sha256 thumbprint
+	// of the client certificate (RFC 8705)
+	X5tS256 string `json:"x5t#S256,omitempty"`
 }
 
+// IdPSession identifies the session of an OpenID Connect provider
+// by the iss and sid claims of its id token.
+type IdPSession struct {
+	Issuer string `json:"iss"`
+	ID     string `json:"sid"`
+}
+
+// The token types of the TokenType claim
+const (
+	TokenTypeAccess  = "access"
+	TokenTypeRefresh = "refresh"
+)
+
+// ErrTokenExpired is returned by Valid for expired tokens
+var ErrTokenExpired = errors.New("token expired")
+
+// ErrTokenNotValidYet is returned by Valid for tokens used before their not before time
+var ErrTokenNotValidYet = errors.New("token not valid yet")
+
 // Valid lets us use the user info as Claim for jwt-go.
-// It checks the token expiry.
+// It checks the token expiry and the not before time.
 func (u UserInfo) Valid() error {
-	if u.Expiry < time.Now().Unix() {
-		return errors.New("token expired")
+	return u.ValidAt(time.Now(), 0)
+}
+
+// ValidAt checks the token expiry and the not before time at the given time.
+// Clock differences up to the leeway are tolerated.
+func (u UserInfo) ValidAt(now time.Time, leeway time.Duration) error {
+	if u.Expiry < now.Add(-leeway).Unix() {
+		return ErrTokenExpired
+	}
+	if u.NotBefore > now.Add(leeway).Unix() {
+		return ErrTokenNotValidYet
 	}
 	return nil
 }
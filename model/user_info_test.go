@@ -11,3 +11,16 @@ func Test_UserInfo_Valid(t *testing.T) {
 	Error(t, UserInfo{Expiry: time.Now().Add(-1 * time.Second).Unix()}.Valid())
 	NoError(t, UserInfo{Expiry: time.Now().Add(time.Second).Unix()}.Valid())
 }
+
+func Test_UserInfo_ValidAt(t *testing.T) {
+	now := time.Now()
+	expired := UserInfo{Expiry: now.Add(-10 * time.Second).Unix()}
+	Equal(t, ErrTokenExpired, expired.ValidAt(now, 0))
+	NoError(t, expired.ValidAt(now, 30*time.Second))
+	Equal(t, ErrTokenExpired, expired.ValidAt(now, 5*time.Second))
+
+	early := UserInfo{Expiry: now.Add(time.Minute).Unix(), NotBefore: now.Add(10 * time.Second).Unix()}
+	Equal(t, ErrTokenNotValidYet, early.ValidAt(now, 0))
+	NoError(t, early.ValidAt(now, 30*time.Second))
+	NoError(t, early.ValidAt(now.Add(10*time.Second), 0))
+}
@@ -5,7 +5,6 @@ import (
 	"fmt"
 	"github.com/tarent/loginsrv/model"
 	"io/ioutil"
-	"net/http"
 	"strings"
 )
 
@@ -60,11 +59,12 @@ func (e *emails) getPrimaryEmailAddress() string {
 func getBitbucketEmails(token TokenInfo) (emails, error) {
 	emailUrl := fmt.Sprintf("%v/user/emails?access_token=%v", bitbucketAPI, token.AccessToken)
 	userEmails := emails{}
-	resp, err := http.Get(emailUrl)
+	resp, err := getUserInfo(token.Context(), emailUrl)
 
 	if err != nil {
 		return emails{}, err
 	}
+	defer resp.Body.Close()
 
 	if !strings.Contains(resp.Header.Get("Content-Type"), "application/json") {
 		return emails{}, fmt.Errorf("wrong content-type on bitbucket get user emails: %v", resp.Header.Get("Content-Type"))
@@ -95,10 +95,11 @@ var providerBitbucket = Provider{
 	GetUserInfo: func(token TokenInfo) (model.UserInfo, string, error) {
 		gu := bitbucketUser{}
 		url := fmt.Sprintf("%v/user?access_token=%v", bitbucketAPI, token.AccessToken)
-		resp, err := http.Get(url)
+		resp, err := getUserInfo(token.Context(), url)
 		if err != nil {
 			return model.UserInfo{}, "", err
 		}
+		defer resp.Body.Close()
 
 		if !strings.Contains(resp.Header.Get("Content-Type"), "application/json") {
 			return model.UserInfo{}, "", fmt.Errorf("wrong content-type on bitbucket get user info: %v", resp.Header.Get("Content-Type"))
@@ -119,7 +120,15 @@ var providerBitbucket = Provider{
 			return model.UserInfo{}, "", fmt.Errorf("error parsing bitbucket get user info: %v", err)
 		}
 
+		if gu.Username == "" {
+			return model.UserInfo{}, "", fmt.Errorf("missing username in bitbucket get user info")
+		}
+
+		// the email is optional, the login succeeds without it
 		userEmails, err := getBitbucketEmails(token)
+		if err != nil {
+			enrichmentFailed("bitbucket", "emails", err)
+		}
 
 		return model.UserInfo{
 			Sub:     gu.Username,
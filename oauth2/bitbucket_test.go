@@ -125,6 +125,28 @@ func (suite *BitbucketTestSuite) Test_Bitbucket_getPrimaryEmailAddress()  {
 	suite.Equal("tutorials@bitbucket.com", userEmails.getPrimaryEmailAddress())
 }
 
+// Test_Bitbucket_getUserInfo_EmailsFailure Tests a failing emails endpoint does not fail the login
+func (suite *BitbucketTestSuite) Test_Bitbucket_getUserInfo_EmailsFailure() {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		if r.URL.Path == "/user/emails" {
+			w.WriteHeader(500)
+			return
+		}
+		w.Write([]byte(bitbucketTestUserResponse))
+	}))
+	defer server.Close()
+
+	bitbucketAPI = server.URL
+	failuresBefore := EnrichmentFailures()["bitbucket"]["emails"]
+
+	u, _, err := providerBitbucket.GetUserInfo(TokenInfo{AccessToken: "secret"})
+	suite.NoError(err)
+	suite.Equal("tutorials", u.Sub)
+	suite.Equal("", u.Email)
+	suite.Equal(failuresBefore+1, EnrichmentFailures()["bitbucket"]["emails"])
+}
+
 // Test_Bitbucket_Suite Runs the entire suite for Bitbucket
 func Test_Bitbucket_Suite(t *testing.T) {
 	suite.Run(t, new(BitbucketTestSuite))
@@ -0,0 +1,62 @@
+package oauth2
+
+import (
+	"context"
+	"net/http"
+	"sync"
+
+	"github.com/tarent/loginsrv/logging"
+	"github.com/tarent/loginsrv/outboundtls"
+)
+
+// userInfoClient is the shared client for the user info calls of the providers.
+// The timeout bounds every single call, so that a slow provider api can not block a login for long.
+var userInfoClient = outboundtls.Client(defaultTimeout)
+
+// getUserInfo gets the url with the user info client, bounded by the context of the login request
+func getUserInfo(ctx context.Context, url string) (*http.Response, error) {
+	r, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	return userInfoClient.Do(r.WithContext(ctx))
+}
+
+// enrichmentFailures counts the failed optional user info calls per provider and enrichment, e.g. github emails
+var enrichmentFailures = struct {
+	sync.Mutex
+	counts map[string]map[string]int64
+}{counts: map[string]map[string]int64{}}
+
+// enrichmentFailed logs and counts a failed optional call. The login goes on without the data of the call.
+func enrichmentFailed(provider, enrichment string, err error) {
+	logging.Logger.WithError(err).
+		WithField("provider", provider).
+		WithField("enrichment", enrichment).
+		Warn("optional oauth user info call failed, continuing without its data")
+
+	enrichmentFailures.Lock()
+	defer enrichmentFailures.Unlock()
+	if enrichmentFailures.counts[provider] == nil {
+		enrichmentFailures.counts[provider] = map[string]int64{}
+	}
+	enrichmentFailures.counts[provider][enrichment]++
+}
+
+// EnrichmentFailures returns the number of failed optional user info calls per provider and enrichment.
+// It returns nil, if no call failed.
+func EnrichmentFailures() map[string]map[string]int64 {
+	enrichmentFailures.Lock()
+	defer enrichmentFailures.Unlock()
+	if len(enrichmentFailures.counts) == 0 {
+		return nil
+	}
+	result := map[string]map[string]int64{}
+	for provider, counts := range enrichmentFailures.counts {
+		result[provider] = map[string]int64{}
+		for enrichment, count := range counts {
+			result[provider][enrichment] = count
+		}
+	}
+	return result
+}
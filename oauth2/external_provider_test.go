@@ -0,0 +1,157 @@
+package oauth2_test
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	. "github.com/stretchr/testify/assert"
+	"github.com/tarent/loginsrv/model"
+	"github.com/tarent/loginsrv/oauth2"
+)
+
+// newCompanySSO is an example of a provider maintained outside of the oauth2 package.
+// It only uses the exported api.
+func newCompanySSO(server *httptest.Server) oauth2.Provider {
+	return oauth2.Provider{
+		Name:         "companysso",
+		AuthURL:      server.URL + "/authorize",
+		TokenURL:     server.URL + "/token",
+		DefaultScope: "openid profile",
+		GetUserInfo: func(token oauth2.TokenInfo) (model.UserInfo, string, error) {
+			req, _ := http.NewRequest("GET", server.URL+"/me", nil)
+			// the quirk: the token is sent in a custom header
+			req.Header.Set("X-SSO-Token", token.AccessToken)
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				return model.UserInfo{}, "", err
+			}
+			defer resp.Body.Close()
+			if resp.StatusCode != 200 {
+				return model.UserInfo{}, "", errors.New("sso rejected the token")
+			}
+			var user struct {
+				UID  string `json:"uid"`
+				Mail string `json:"mail"`
+			}
+			if err := json.NewDecoder(resp.Body).Decode(&user); err != nil {
+				return model.UserInfo{}, "", err
+			}
+			return model.UserInfo{Sub: user.UID, Email: user.Mail, Origin: "companysso"}, "", nil
+		},
+	}
+}
+
+func newCompanySSOServer(t *testing.T) *httptest.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		Equal(t, "the-code", r.PostForm.Get("code"))
+		Equal(t, "client42", r.PostForm.Get("client_id"))
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"access_token":"sso-token","token_type":"bearer"}`))
+	})
+	mux.HandleFunc("/me", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-SSO-Token") != "sso-token" {
+			w.WriteHeader(401)
+			return
+		}
+		w.Write([]byte(`{"uid":"jdoe","mail":"jdoe@example.com"}`))
+	})
+	return httptest.NewServer(mux)
+}
+
+func Test_ExternalProvider(t *testing.T) {
+	server := newCompanySSOServer(t)
+	defer server.Close()
+
+	oauth2.RegisterProvider(newCompanySSO(server))
+	defer oauth2.UnRegisterProvider("companysso")
+	Contains(t, oauth2.ProviderList(), "companysso")
+
+	m := oauth2.NewManager()
+	NoError(t, m.AddConfig("companysso", map[string]string{
+		"client_id":     "client42",
+		"client_secret": "secret",
+		"redirect_uri":  "http://localhost/login/companysso",
+	}))
+
+	// start the flow
+	recorder := httptest.NewRecorder()
+	startedFlow, _, _, err := m.Handle(recorder, httptest.NewRequest("GET", "http://localhost/login/companysso", nil))
+	NoError(t, err)
+	True(t, startedFlow)
+	location, _ := url.Parse(recorder.Header().Get("Location"))
+	Equal(t, server.URL+"/authorize", location.Scheme+"://"+location.Host+location.Path)
+	Equal(t, "openid profile", location.Query().Get("scope"))
+
+	// callback
+	r := httptest.NewRequest("GET", "http://localhost/login/companysso?code=the-code&state="+location.Query().Get("state"), nil)
+	for _, c := range recorder.Result().Cookies() {
+		r.AddCookie(c)
+	}
+	startedFlow, authenticated, userInfo, err := m.Handle(httptest.NewRecorder(), r)
+	NoError(t, err)
+	False(t, startedFlow)
+	True(t, authenticated)
+	Equal(t, "jdoe", userInfo.Sub)
+	Equal(t, "jdoe@example.com", userInfo.Email)
+}
+
+func Test_ExternalProvider_OverrideDefaults(t *testing.T) {
+	server := newCompanySSOServer(t)
+	defer server.Close()
+
+	oauth2.RegisterProvider(newCompanySSO(server))
+	defer oauth2.UnRegisterProvider("companysso")
+
+	m := oauth2.NewManager()
+	NoError(t, m.AddConfig("companysso", map[string]string{
+		"client_id":     "client42",
+		"client_secret": "secret",
+		"scope":         "openid",
+		"auth_url":      "https://sso.example.com/authorize",
+		"token_url":     "https://sso.example.com/token",
+	}))
+	cfg, err := m.GetConfigFromRequest(httptest.NewRequest("GET", "http://localhost/login/companysso", nil))
+	NoError(t, err)
+	Equal(t, "openid", cfg.Scope)
+	Equal(t, "https://sso.example.com/authorize", cfg.AuthURL)
+	Equal(t, "https://sso.example.com/token", cfg.TokenURL)
+}
+
+func Test_ExternalProvider_UrlsFromConfig(t *testing.T) {
+	// the urls of the provider are only known by the configuration
+	server := newCompanySSOServer(t)
+	defer server.Close()
+	p := newCompanySSO(server)
+	p.AuthURL, p.TokenURL = "", ""
+	oauth2.RegisterProvider(p)
+	defer oauth2.UnRegisterProvider("companysso")
+
+	m := oauth2.NewManager()
+	err := m.AddConfig("companysso", map[string]string{
+		"client_id":     "client42",
+		"client_secret": "secret",
+	})
+	EqualError(t, err, "companysso: option auth_url: missing parameter, the provider has no default; "+
+		"companysso: option token_url: missing parameter, the provider has no default")
+
+	NoError(t, m.AddConfig("companysso", map[string]string{
+		"client_id":     "client42",
+		"client_secret": "secret",
+		"auth_url":      "https://sso.example.com/authorize",
+		"token_url":     "https://sso.example.com/token",
+	}))
+}
+
+func Test_ExternalProvider_Invalid(t *testing.T) {
+	oauth2.RegisterProvider(oauth2.Provider{Name: "incomplete", AuthURL: "https://example.com/authorize", TokenURL: "https://example.com/token"})
+	defer oauth2.UnRegisterProvider("incomplete")
+
+	err := oauth2.NewManager().AddConfig("incomplete", map[string]string{"client_id": "client42", "client_secret": "secret"})
+	EqualError(t, err, "invalid provider incomplete: missing GetUserInfo")
+}
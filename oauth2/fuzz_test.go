@@ -0,0 +1,53 @@
+package oauth2
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+// FuzzAuthenticate runs the oauth callback with arbitrary state parameters, state cookies
+// and token exchange responses. It must never panic and never accept a mismatching state.
+func FuzzAuthenticate(f *testing.F) {
+	f.Add("abc", "abc", "theCode", `{"access_token":"e72e16c7e42f","scope":"repo gist","token_type":"bearer"}`)
+	f.Add("abc", "abc", "theCode", `{"error":"bad_verification_code"}`)
+	f.Add("abc", "abc", "theCode", `access_token=e72e16c7e42f&token_type=bearer`)
+	f.Add("abc", "abc", "theCode", `{"access_token":""}`)
+	f.Add("abc", "abc", "theCode", `{"access_token":1}`)
+	f.Add("", "", "", "")
+	f.Add("abc", "xyz", "theCode", `{"access_token":"e72e16c7e42f"}`)
+	f.Add("a;b=c", "a;b=c", "x y", "null")
+
+	var tokenResponse string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(tokenResponse))
+	}))
+	defer server.Close()
+
+	cfg := testConfig
+	cfg.TokenURL = server.URL
+
+	f.Fuzz(func(t *testing.T, state, cookieValue, code, response string) {
+		tokenResponse = response
+
+		values := url.Values{}
+		values.Set("state", state)
+		values.Set("code", code)
+		r := httptest.NewRequest("GET", "http://localhost/callback?"+values.Encode(), nil)
+		r.AddCookie(&http.Cookie{Name: stateCookieName, Value: cookieValue})
+
+		tokenInfo, err := Authenticate(cfg, r)
+		if err != nil {
+			return
+		}
+		stateCookie, _ := r.Cookie(stateCookieName)
+		if stateCookie == nil || stateCookie.Value != state {
+			t.Errorf("accepted state %q with cookie %q", state, cookieValue)
+		}
+		if tokenInfo.AccessToken == "" {
+			t.Errorf("accepted empty access token")
+		}
+	})
+}
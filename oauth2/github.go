@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"github.com/tarent/loginsrv/model"
+	"github.com/tarent/loginsrv/outboundtls"
 	"io/ioutil"
 	"net/http"
 	"strings"
@@ -23,6 +24,39 @@ type GithubUser struct {
 	Email     string `json:"email,omitempty"`
 }
 
+// githubEmail is an entry of the emails of the user
+type githubEmail struct {
+	Email    string `json:"email"`
+	Primary  bool   `json:"primary"`
+	Verified bool   `json:"verified"`
+}
+
+// getGithubPrimaryEmail returns the primary and verified email of the user,
+// which is not part of the user response, if the user keeps it private.
+func getGithubPrimaryEmail(token TokenInfo) (string, error) {
+	url := fmt.Sprintf("%v/user/emails?access_token=%v", githubAPI, token.AccessToken)
+	resp, err := getUserInfo(token.Context(), url)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		return "", fmt.Errorf("got http status %v on github get user emails", resp.StatusCode)
+	}
+
+	emails := []githubEmail{}
+	if err := json.NewDecoder(resp.Body).Decode(&emails); err != nil {
+		return "", fmt.Errorf("error parsing github get user emails: %v", err)
+	}
+	for _, e := range emails {
+		if e.Primary && e.Verified {
+			return e.Email, nil
+		}
+	}
+	return "", nil
+}
+
 var providerGithub = Provider{
 	Name:     "github",
 	AuthURL:  "https://github.com/login/oauth/authorize",
@@ -30,10 +64,11 @@ var providerGithub = Provider{
 	GetUserInfo: func(token TokenInfo) (model.UserInfo, string, error) {
 		gu := GithubUser{}
 		url := fmt.Sprintf("%v/user?access_token=%v", githubAPI, token.AccessToken)
-		resp, err := http.Get(url)
+		resp, err := getUserInfo(token.Context(), url)
 		if err != nil {
 			return model.UserInfo{}, "", err
 		}
+		defer resp.Body.Close()
 
 		if !strings.Contains(resp.Header.Get("Content-Type"), "application/json") {
 			return model.UserInfo{}, "", fmt.Errorf("wrong content-type on github get user info: %v", resp.Header.Get("Content-Type"))
@@ -53,6 +88,17 @@ var providerGithub = Provider{
 			return model.UserInfo{}, "", fmt.Errorf("error parsing github get user info: %v", err)
 		}
 
+		if gu.Login == "" {
+			return model.UserInfo{}, "", fmt.Errorf("missing login in github get user info")
+		}
+
+		// the email is optional, the login succeeds without it
+		if gu.Email == "" {
+			if gu.Email, err = getGithubPrimaryEmail(token); err != nil {
+				enrichmentFailed("github", "emails", err)
+			}
+		}
+
 		return model.UserInfo{
 			Sub:     gu.Login,
 			Picture: gu.AvatarURL,
@@ -61,4 +107,30 @@ var providerGithub = Provider{
 			Origin:  "github",
 		}, string(b), nil
 	},
+	CheckClientCredentials: func(clientID, clientSecret string) error {
+		// Github answers the check of an unknown token with 404,
+		// but rejects wrong client credentials with 401.
+		url := fmt.Sprintf("%v/applications/%v/token", githubAPI, clientID)
+		r, err := http.NewRequest("POST", url, strings.NewReader(`{"access_token":"loginsrv-dry-run"}`))
+		if err != nil {
+			return err
+		}
+		r.SetBasicAuth(clientID, clientSecret)
+		r.Header.Set("Content-Type", "application/json")
+		r.Header.Set("Accept", "application/vnd.github.v3+json")
+
+		resp, err := outboundtls.Client(defaultTimeout).Do(r)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+
+		switch resp.StatusCode {
+		case 200, 404, 422:
+			return nil
+		case 401, 403:
+			return fmt.Errorf("github rejected the client credentials (http status %v)", resp.StatusCode)
+		}
+		return fmt.Errorf("got http status %v on github client credentials check", resp.StatusCode)
+	},
 }
@@ -4,7 +4,9 @@ import (
 	. "github.com/stretchr/testify/assert"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
+	"time"
 )
 
 var githubTestUserResponse = `{
@@ -57,3 +59,100 @@ func Test_Github_getUserInfo(t *testing.T) {
 	Equal(t, "monalisa octocat", u.Name)
 	Equal(t, githubTestUserResponse, rawJSON)
 }
+
+func Test_Github_getUserInfo_PrivateEmail(t *testing.T) {
+	userResponse := strings.Replace(githubTestUserResponse, `"octocat@github.com"`, "null", 1)
+	emailsStatus := 200
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		switch r.URL.Path {
+		case "/user":
+			w.Write([]byte(userResponse))
+		case "/user/emails":
+			Equal(t, "secret", r.FormValue("access_token"))
+			w.WriteHeader(emailsStatus)
+			w.Write([]byte(`[{"email":"old@github.com","primary":false,"verified":true},{"email":"octocat@github.com","primary":true,"verified":true}]`))
+		}
+	}))
+	defer server.Close()
+
+	githubAPI = server.URL
+
+	u, _, err := providerGithub.GetUserInfo(TokenInfo{AccessToken: "secret"})
+	NoError(t, err)
+	Equal(t, "octocat@github.com", u.Email)
+
+	// a failing emails endpoint does not fail the login
+	failuresBefore := EnrichmentFailures()["github"]["emails"]
+	emailsStatus = 500
+	u, _, err = providerGithub.GetUserInfo(TokenInfo{AccessToken: "secret"})
+	NoError(t, err)
+	Equal(t, "octocat", u.Sub)
+	Equal(t, "monalisa octocat", u.Name)
+	Equal(t, "", u.Email)
+	Equal(t, failuresBefore+1, EnrichmentFailures()["github"]["emails"])
+}
+
+func Test_Github_getUserInfo_Failures(t *testing.T) {
+	var userStatus int
+	var userResponse string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.WriteHeader(userStatus)
+		w.Write([]byte(userResponse))
+	}))
+	defer server.Close()
+
+	githubAPI = server.URL
+
+	// the login is required
+	userStatus, userResponse = 500, "{}"
+	_, _, err := providerGithub.GetUserInfo(TokenInfo{AccessToken: "secret"})
+	EqualError(t, err, "got http status 500 on github get user info")
+
+	userStatus, userResponse = 200, `{"name": "monalisa octocat", "email": "octocat@github.com"}`
+	_, _, err = providerGithub.GetUserInfo(TokenInfo{AccessToken: "secret"})
+	EqualError(t, err, "missing login in github get user info")
+}
+
+func Test_Github_getUserInfo_Timeout(t *testing.T) {
+	done := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-done
+	}))
+	defer server.Close()
+	defer close(done)
+
+	githubAPI = server.URL
+	defer func(timeout time.Duration) { userInfoClient.Timeout = timeout }(userInfoClient.Timeout)
+	userInfoClient.Timeout = 50 * time.Millisecond
+
+	start := time.Now()
+	_, _, err := providerGithub.GetUserInfo(TokenInfo{AccessToken: "secret"})
+	Error(t, err)
+	True(t, time.Since(start) < time.Second)
+}
+
+func Test_Github_CheckClientCredentials(t *testing.T) {
+	var returnCode int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		Equal(t, "POST", r.Method)
+		Equal(t, "/applications/client42/token", r.URL.Path)
+		clientID, clientSecret, _ := r.BasicAuth()
+		Equal(t, "client42", clientID)
+		Equal(t, "secret", clientSecret)
+		w.WriteHeader(returnCode)
+	}))
+	defer server.Close()
+
+	githubAPI = server.URL
+
+	returnCode = 404
+	NoError(t, providerGithub.CheckClientCredentials("client42", "secret"))
+
+	returnCode = 401
+	EqualError(t, providerGithub.CheckClientCredentials("client42", "secret"), "github rejected the client credentials (http status 401)")
+
+	returnCode = 500
+	EqualError(t, providerGithub.CheckClientCredentials("client42", "secret"), "got http status 500 on github client credentials check")
+}
@@ -4,7 +4,6 @@ import (
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
-	"net/http"
 	"regexp"
 	"strings"
 
@@ -35,11 +34,12 @@ var providerGoogle = Provider{
 	GetUserInfo: func(token TokenInfo) (model.UserInfo, string, error) {
 		gu := GoogleUser{}
 		url := fmt.Sprintf("%v/people/me?alt=json&access_token=%v", googleAPI, token.AccessToken)
-		resp, err := http.Get(url)
+		resp, err := getUserInfo(token.Context(), url)
 
 		if err != nil {
 			return model.UserInfo{}, "", err
 		}
+		defer resp.Body.Close()
 
 		if !strings.Contains(resp.Header.Get("Content-Type"), "application/json") {
 			return model.UserInfo{}, "", fmt.Errorf("wrong content-type on google get user info: %v", resp.Header.Get("Content-Type"))
@@ -0,0 +1,37 @@
+package oauth2
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+
+	"github.com/tarent/loginsrv/model"
+)
+
+// idpSession reads the session of the provider from the iss and sid claims of the id token.
+// It returns nil, if the provider sent no id token or the token has no session id.
+//
+// The signature of the id token is not checked: it is received directly from the token endpoint
+// over tls, which authenticates the provider (OpenID Connect Core 3.1.3.7).
+// The session is only used to end the local sessions on a logout at the provider.
+func (t TokenInfo) idpSession() *model.IdPSession {
+	parts := strings.Split(t.IDToken, ".")
+	if len(parts) != 3 {
+		return nil
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(parts[1], "="))
+	if err != nil {
+		return nil
+	}
+	claims := struct {
+		Issuer    string `json:"iss"`
+		SessionID string `json:"sid"`
+	}{}
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return nil
+	}
+	if claims.Issuer == "" || claims.SessionID == "" {
+		return nil
+	}
+	return &model.IdPSession{Issuer: claims.Issuer, ID: claims.SessionID}
+}
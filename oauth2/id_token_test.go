@@ -0,0 +1,57 @@
+package oauth2
+
+import (
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	. "github.com/stretchr/testify/assert"
+	"github.com/tarent/loginsrv/model"
+)
+
+func idToken(claims string) string {
+	return "eyJhbGciOiJSUzI1NiJ9." + base64.RawURLEncoding.EncodeToString([]byte(claims)) + ".signature"
+}
+
+func Test_TokenInfo_IdPSession(t *testing.T) {
+	Equal(t,
+		&model.IdPSession{Issuer: "https://idp.example.com", ID: "08a5019c"},
+		TokenInfo{IDToken: idToken(`{"iss": "https://idp.example.com", "sid": "08a5019c", "sub": "bob"}`)}.idpSession())
+
+	for _, token := range []string{
+		"",
+		"not-a-jwt",
+		"a.%%%.c",
+		idToken(`no json`),
+		idToken(`{"iss": "https://idp.example.com"}`),
+		idToken(`{"sid": "08a5019c"}`),
+	} {
+		Nil(t, TokenInfo{IDToken: token}.idpSession(), token)
+	}
+}
+
+func Test_Manager_IdPSession(t *testing.T) {
+	exampleProvider := Provider{
+		Name:     "example",
+		AuthURL:  "https://example.com/login/oauth/authorize",
+		TokenURL: "https://example.com/login/oauth/access_token",
+		GetUserInfo: func(token TokenInfo) (model.UserInfo, string, error) {
+			return model.UserInfo{Sub: "bob"}, "", nil
+		},
+	}
+	RegisterProvider(exampleProvider)
+	defer UnRegisterProvider(exampleProvider.Name)
+
+	m := NewManager()
+	NoError(t, m.AddConfig(exampleProvider.Name, map[string]string{"client_id": "client42", "client_secret": "secret"}))
+	m.authenticate = func(cfg Config, r *http.Request) (TokenInfo, error) {
+		return TokenInfo{AccessToken: "the-access-token", IDToken: idToken(`{"iss": "https://idp.example.com", "sid": "08a5019c"}`)}, nil
+	}
+
+	r, _ := http.NewRequest("GET", "http://example.com/login/example?code=xyz", nil)
+	_, authenticated, userInfo, err := m.Handle(httptest.NewRecorder(), r)
+	NoError(t, err)
+	True(t, authenticated)
+	Equal(t, &model.IdPSession{Issuer: "https://idp.example.com", ID: "08a5019c"}, userInfo.IdPSession)
+}
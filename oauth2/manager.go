@@ -12,15 +12,31 @@ import (
 // It has to pick the right configuration and start the oauth redirecting.
 type Manager struct {
 	configs      map[string]Config
-	startFlow    func(cfg Config, w http.ResponseWriter)
+	startFlow    func(cfg Config, w http.ResponseWriter, state string)
 	authenticate func(cfg Config, r *http.Request) (TokenInfo, error)
+	observer     FlowObserver
+}
+
+// FlowObserver is notified about the oauth flows of the manager, e.g. for metrics.
+// The nonce is the random part of the state parameter, which identifies a flow
+// from its start to the callback. Callbacks with unknown nonces are reported as well.
+type FlowObserver interface {
+	FlowStarted(provider, nonce string)
+	FlowCompleted(provider, nonce string)
+	FlowFailed(provider, nonce string)
+}
+
+// SetObserver sets the observer of the oauth flows.
+// It has to be set before the manager handles requests.
+func (manager *Manager) SetObserver(observer FlowObserver) {
+	manager.observer = observer
 }
 
 // NewManager creates a new Manager
 func NewManager() *Manager {
 	return &Manager{
 		configs:      map[string]Config{},
-		startFlow:    StartFlow,
+		startFlow:    startFlowWithState,
 		authenticate: Authenticate,
 	}
 }
@@ -28,6 +44,7 @@ func NewManager() *Manager {
 // Handle is managing the oauth flow.
 // Dependent on the code parameter of the url, the oauth flow is started or
 // the call is interpreted as the redirect callback and the token exchange is done.
+// A payload set on the request context by WithStatePayload is carried through the state parameter.
 // Return parameters:
 //   startedFlow - true, if this was the initial call to start the oauth flow
 //   authenticated - if the authentication was successful or not
@@ -40,6 +57,9 @@ func (manager *Manager) Handle(w http.ResponseWriter, r *http.Request) (
 	err error) {
 
 	if r.FormValue("error") != "" {
+		if _, exist := manager.configs[manager.getConfigNameFromPath(r.URL.Path)]; exist {
+			manager.flowFailed(manager.getConfigNameFromPath(r.URL.Path), r)
+		}
 		return false, false, model.UserInfo{}, fmt.Errorf("error: %v", r.FormValue("error"))
 	}
 
@@ -51,20 +71,39 @@ func (manager *Manager) Handle(w http.ResponseWriter, r *http.Request) (
 	if r.FormValue("code") != "" {
 		tokenInfo, err := manager.authenticate(cfg, r)
 		if err != nil {
+			manager.flowFailed(cfg.Provider.Name, r)
 			return false, false, model.UserInfo{}, err
 		}
 
+		tokenInfo.ctx = r.Context()
 		userInfo, _, err := cfg.Provider.GetUserInfo(tokenInfo)
 		if err != nil {
+			manager.flowFailed(cfg.Provider.Name, r)
 			return false, false, model.UserInfo{}, err
 		}
+		if userInfo.IdPSession == nil {
+			userInfo.IdPSession = tokenInfo.idpSession()
+		}
+		if manager.observer != nil {
+			manager.observer.FlowCompleted(cfg.Provider.Name, stateNonce(r.FormValue("state")))
+		}
 		return false, true, userInfo, err
 	}
 
-	manager.startFlow(cfg, w)
+	state := newState(statePayloadFromContext(r.Context()))
+	manager.startFlow(cfg, w, state)
+	if manager.observer != nil {
+		manager.observer.FlowStarted(cfg.Provider.Name, stateNonce(state))
+	}
 	return true, false, model.UserInfo{}, nil
 }
 
+func (manager *Manager) flowFailed(provider string, r *http.Request) {
+	if manager.observer != nil {
+		manager.observer.FlowFailed(provider, stateNonce(r.FormValue("state")))
+	}
+}
+
 // GetConfigFromRequest returns the oauth configuration matching the current path.
 // The configuration name is taken from the last path segment.
 func (manager *Manager) GetConfigFromRequest(r *http.Request) (Config, error) {
@@ -86,7 +125,33 @@ func (manager *Manager) getConfigNameFromPath(path string) string {
 	return parts[len(parts)-1]
 }
 
-// AddConfig for a provider
+// OptionError is a missing or invalid option in the configuration of an oauth provider
+type OptionError struct {
+	Provider string
+	Option   string
+	Reason   string
+}
+
+func (e *OptionError) Error() string {
+	return fmt.Sprintf("%v: option %v: %v", e.Provider, e.Option, e.Reason)
+}
+
+// OptionErrors are all errors in the configuration of an oauth provider
+type OptionErrors []*OptionError
+
+func (e OptionErrors) Error() string {
+	msgs := make([]string, len(e))
+	for i, err := range e {
+		msgs[i] = err.Error()
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// AddConfig for a registered provider, built-in or external.
+// The options client_id and client_secret are required,
+// scope, redirect_uri, auth_url and token_url override the defaults of the provider.
+// auth_url and token_url are required as well, if the provider has no default for them.
+// Invalid options are returned together as OptionErrors.
 func (manager *Manager) AddConfig(providerName string, opts map[string]string) error {
 	p, exist := GetProvider(providerName)
 
@@ -98,17 +163,37 @@ func (manager *Manager) AddConfig(providerName string, opts map[string]string) e
 		Provider: p,
 		AuthURL:  p.AuthURL,
 		TokenURL: p.TokenURL,
+		Scope:    p.DefaultScope,
+	}
+
+	if authURL, exist := opts["auth_url"]; exist {
+		cfg.AuthURL = authURL
+	}
+
+	if tokenURL, exist := opts["token_url"]; exist {
+		cfg.TokenURL = tokenURL
+	}
+
+	var errs OptionErrors
+	if p.GetUserInfo == nil {
+		return fmt.Errorf("invalid provider %v: missing GetUserInfo", providerName)
+	}
+	if cfg.AuthURL == "" {
+		errs = append(errs, &OptionError{Provider: providerName, Option: "auth_url", Reason: "missing parameter, the provider has no default"})
+	}
+	if cfg.TokenURL == "" {
+		errs = append(errs, &OptionError{Provider: providerName, Option: "token_url", Reason: "missing parameter, the provider has no default"})
 	}
 
 	clientID, exist := opts["client_id"]
 	if !exist {
-		return fmt.Errorf("missing parameter client_id")
+		errs = append(errs, &OptionError{Provider: providerName, Option: "client_id", Reason: "missing parameter"})
 	}
 	cfg.ClientID = clientID
 
 	clientSecret, exist := opts["client_secret"]
 	if !exist {
-		return fmt.Errorf("missing parameter client_secret")
+		errs = append(errs, &OptionError{Provider: providerName, Option: "client_secret", Reason: "missing parameter"})
 	}
 	cfg.ClientSecret = clientSecret
 
@@ -117,13 +202,29 @@ func (manager *Manager) AddConfig(providerName string, opts map[string]string) e
 	}
 
 	if redirectURI, exist := opts["redirect_uri"]; exist {
+		if u, err := url.Parse(redirectURI); err != nil || !u.IsAbs() {
+			errs = append(errs, &OptionError{Provider: providerName, Option: "redirect_uri", Reason: fmt.Sprintf("has to be an absolute url, but was %q", redirectURI)})
+		}
 		cfg.RedirectURI = redirectURI
 	}
 
+	if len(errs) > 0 {
+		return errs
+	}
 	manager.configs[providerName] = cfg
 	return nil
 }
 
+// Validate does a dry run of all configured providers.
+// The result contains an entry for each provider, which is nil if the configuration is fine.
+func (manager *Manager) Validate() map[string]error {
+	result := map[string]error{}
+	for name, cfg := range manager.configs {
+		result[name] = ValidateConfig(cfg)
+	}
+	return result
+}
+
 // GetConfigs of the manager
 func (manager *Manager) GetConfigs() map[string]Config {
 	return manager.configs
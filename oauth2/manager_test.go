@@ -1,6 +1,7 @@
 package oauth2
 
 import (
+	"context"
 	"crypto/tls"
 	"errors"
 	. "github.com/stretchr/testify/assert"
@@ -21,7 +22,9 @@ func Test_Manager_Positive_Flow(t *testing.T) {
 		TokenURL: "https://example.com/login/oauth/access_token",
 		GetUserInfo: func(token TokenInfo) (model.UserInfo, string, error) {
 			getUserInfoCalled = true
-			Equal(t, token, expectedToken)
+			Equal(t, expectedToken.AccessToken, token.AccessToken)
+			// the user info is fetched in the context of the login request
+			Equal(t, context.Background(), token.Context())
 			return model.UserInfo{
 				Sub: "the-username",
 			}, "", nil
@@ -48,7 +51,7 @@ func Test_Manager_Positive_Flow(t *testing.T) {
 		"redirect_uri":  expectedConfig.RedirectURI,
 	})
 
-	m.startFlow = func(cfg Config, w http.ResponseWriter) {
+	m.startFlow = func(cfg Config, w http.ResponseWriter, state string) {
 		startFlowCalled = true
 		startFlowReceivedConfig = cfg
 	}
@@ -159,16 +162,24 @@ func Test_Manager_AddConfig_ErrorCases(t *testing.T) {
 		m.AddConfig("github", map[string]string{
 			"client_secret": "bar",
 		}),
-		"missing parameter client_id",
+		"github: option client_id: missing parameter",
 	)
 
 	EqualError(t,
 		m.AddConfig("github", map[string]string{
 			"client_id": "foo",
 		}),
-		"missing parameter client_secret",
+		"github: option client_secret: missing parameter",
 	)
 
+	err := m.AddConfig("github", map[string]string{
+		"redirect_uri": "/login/github",
+	})
+	EqualError(t, err,
+		`github: option client_id: missing parameter; github: option client_secret: missing parameter; github: option redirect_uri: has to be an absolute url, but was "/login/github"`)
+	Equal(t, 3, len(err.(OptionErrors)))
+	Equal(t, "redirect_uri", err.(OptionErrors)[2].Option)
+
 }
 
 func Test_Manager_redirectUriFromRequest(t *testing.T) {
@@ -233,7 +244,7 @@ func Test_Manager_RedirectURI_Generation(t *testing.T) {
 		"scope":         "bazz",
 	})
 
-	m.startFlow = func(cfg Config, w http.ResponseWriter) {
+	m.startFlow = func(cfg Config, w http.ResponseWriter, state string) {
 		startFlowReceivedConfig = cfg
 	}
 
@@ -254,3 +265,76 @@ func assertEqualConfig(t *testing.T, c1, c2 Config) {
 	Equal(t, c1.TokenURL, c2.TokenURL)
 	Equal(t, c1.Provider.Name, c2.Provider.Name)
 }
+
+type flowObserverMock struct {
+	events []string
+}
+
+func (o *flowObserverMock) FlowStarted(provider, nonce string) {
+	o.events = append(o.events, "started "+provider+" "+nonce)
+}
+
+func (o *flowObserverMock) FlowCompleted(provider, nonce string) {
+	o.events = append(o.events, "completed "+provider+" "+nonce)
+}
+
+func (o *flowObserverMock) FlowFailed(provider, nonce string) {
+	o.events = append(o.events, "failed "+provider+" "+nonce)
+}
+
+func Test_Manager_FlowObserver(t *testing.T) {
+	exampleProvider := Provider{
+		Name:     "example",
+		AuthURL:  "https://example.com/login/oauth/authorize",
+		TokenURL: "https://example.com/login/oauth/access_token",
+		GetUserInfo: func(token TokenInfo) (model.UserInfo, string, error) {
+			return model.UserInfo{Sub: "the-username"}, "", nil
+		},
+	}
+	RegisterProvider(exampleProvider)
+	defer UnRegisterProvider(exampleProvider.Name)
+
+	observer := &flowObserverMock{}
+	m := NewManager()
+	m.SetObserver(observer)
+	m.AddConfig(exampleProvider.Name, map[string]string{"client_id": "id", "client_secret": "secret"})
+
+	var state string
+	m.startFlow = func(cfg Config, w http.ResponseWriter, s string) {
+		state = s
+	}
+	codeValid := true
+	m.authenticate = func(cfg Config, r *http.Request) (TokenInfo, error) {
+		if !codeValid {
+			return TokenInfo{}, errors.New("code not valid")
+		}
+		return TokenInfo{AccessToken: "token"}, nil
+	}
+
+	r, _ := http.NewRequest("GET", "http://example.com/login/example", nil)
+	_, _, _, err := m.Handle(httptest.NewRecorder(), r)
+	NoError(t, err)
+	nonce := stateNonce(state)
+	Equal(t, 15, len(nonce))
+
+	r, _ = http.NewRequest("GET", "http://example.com/login/example?code=xyz&state="+state, nil)
+	_, authenticated, _, err := m.Handle(httptest.NewRecorder(), r)
+	NoError(t, err)
+	True(t, authenticated)
+
+	codeValid = false
+	r, _ = http.NewRequest("GET", "http://example.com/login/example?code=xyz&state=other", nil)
+	_, _, _, err = m.Handle(httptest.NewRecorder(), r)
+	Error(t, err)
+
+	r, _ = http.NewRequest("GET", "http://example.com/login/example?error=access_denied&state=denied", nil)
+	_, _, _, err = m.Handle(httptest.NewRecorder(), r)
+	Error(t, err)
+
+	Equal(t, []string{
+		"started example " + nonce,
+		"completed example " + nonce,
+		"failed example other",
+		"failed example denied",
+	}, observer.events)
+}
@@ -3,6 +3,7 @@ package oauth2
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"math/rand"
@@ -10,6 +11,9 @@ import (
 	"net/url"
 	"strings"
 	"time"
+
+	"github.com/tarent/loginsrv/clockskew"
+	"github.com/tarent/loginsrv/outboundtls"
 )
 
 func init() {
@@ -55,6 +59,21 @@ type TokenInfo struct {
 
 	// The scopes for this tolen
 	Scope string `json:"scope,omitempty"`
+
+	// IDToken is the id token of an OpenID Connect provider, if the openid scope was requested
+	IDToken string `json:"id_token,omitempty"`
+
+	// ctx is the context of the login request, which bounds the user info calls
+	ctx context.Context
+}
+
+// Context returns the context of the login request, in which the token was exchanged.
+// The user info calls of the providers should honor it, so that they end with the request.
+func (t TokenInfo) Context() context.Context {
+	if t.ctx == nil {
+		return context.Background()
+	}
+	return t.ctx
 }
 
 // JSONError represents an oauth error response in json form.
@@ -68,24 +87,81 @@ const defaultTimeout = 5 * time.Second
 // StartFlow by redirecting the user to the login provider.
 // A state parameter to protect against cross-site request forgery attacks is randomly generated and stored in a cookie
 func StartFlow(cfg Config, w http.ResponseWriter) {
+	StartFlowWithPayload(cfg, w, "")
+}
+
+// StartFlowWithPayload starts the flow like StartFlow and carries the payload through the state parameter.
+// It can be read with StatePayload on the callback.
+func StartFlowWithPayload(cfg Config, w http.ResponseWriter, payload string) {
+	startFlowWithState(cfg, w, newState(payload))
+}
+
+func startFlowWithState(cfg Config, w http.ResponseWriter, state string) {
+	// store the state param
+	cookie := &http.Cookie{
+		Name:     stateCookieName,
+		MaxAge:   60 * 10, // 10 minutes
+		Value:    state,
+		HttpOnly: true,
+	}
+	// the callback may be on another path than the start of the flow
+	if u, err := url.Parse(cfg.RedirectURI); err == nil && u.Path != "" {
+		cookie.Path = u.Path
+	}
+	http.SetCookie(w, cookie)
+
+	w.Header().Set("Location", authorizationURL(cfg, state))
+	w.WriteHeader(http.StatusFound)
+}
+
+func authorizationURL(cfg Config, state string) string {
 	values := make(url.Values)
 	values.Set("client_id", cfg.ClientID)
 	values.Set("scope", cfg.Scope)
 	values.Set("redirect_uri", cfg.RedirectURI)
 	values.Set("response_type", "code")
+	values.Set("state", state)
 
-	// set and store the state param
-	values.Set("state", randStringBytes(15))
-	http.SetCookie(w, &http.Cookie{
-		Name:     stateCookieName,
-		MaxAge:   60 * 10, // 10 minutes
-		Value:    values.Get("state"),
-		HttpOnly: true,
-	})
+	return cfg.AuthURL + "?" + values.Encode()
+}
 
-	targetURL := cfg.AuthURL + "?" + values.Encode()
-	w.Header().Set("Location", targetURL)
-	w.WriteHeader(http.StatusFound)
+// ValidateConfig does a dry run of the oauth flow without any user interaction.
+// It builds the authorization url, checks that the token endpoint is reachable and,
+// if the provider supports it, verifies the client credentials.
+func ValidateConfig(cfg Config) error {
+	if cfg.ClientID == "" {
+		return errors.New("missing client_id")
+	}
+
+	authURL, err := url.Parse(authorizationURL(cfg, "dry-run"))
+	if err != nil {
+		return fmt.Errorf("invalid authorization url: %v", err)
+	}
+	if !authURL.IsAbs() {
+		return fmt.Errorf("authorization url has to be absolute, but was %q", cfg.AuthURL)
+	}
+
+	if cfg.RedirectURI != "" {
+		redirectURI, err := url.Parse(cfg.RedirectURI)
+		if err != nil || !redirectURI.IsAbs() {
+			return fmt.Errorf("redirect_uri has to be an absolute url, but was %q", cfg.RedirectURI)
+		}
+	}
+
+	client := outboundtls.Client(defaultTimeout)
+	resp, err := client.Head(cfg.TokenURL)
+	if err != nil {
+		return fmt.Errorf("token endpoint not reachable: %v", err)
+	}
+	resp.Body.Close()
+	clockskew.ObserveResponse("oauth:"+cfg.Provider.Name, resp)
+
+	if cfg.Provider.CheckClientCredentials != nil {
+		if err := cfg.Provider.CheckClientCredentials(cfg.ClientID, cfg.ClientSecret); err != nil {
+			return fmt.Errorf("client credentials could not be verified: %v", err)
+		}
+	}
+	return nil
 }
 
 // Authenticate after coming back from the oauth flow.
@@ -105,10 +181,10 @@ func Authenticate(cfg Config, r *http.Request) (TokenInfo, error) {
 	if code == "" {
 		return TokenInfo{}, fmt.Errorf("error: no auth code provided")
 	}
-	return getAccessToken(cfg, state, code)
+	return getAccessToken(r.Context(), cfg, state, code)
 }
 
-func getAccessToken(cfg Config, state, code string) (TokenInfo, error) {
+func getAccessToken(ctx context.Context, cfg Config, state, code string) (TokenInfo, error) {
 	values := url.Values{}
 	values.Set("client_id", cfg.ClientID)
 	values.Set("client_secret", cfg.ClientSecret)
@@ -117,15 +193,17 @@ func getAccessToken(cfg Config, state, code string) (TokenInfo, error) {
 	values.Set("grant_type", "authorization_code")
 
 	r, _ := http.NewRequest("POST", cfg.TokenURL, strings.NewReader(values.Encode()))
-	cntx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
+	cntx, cancel := context.WithTimeout(ctx, defaultTimeout)
 	defer cancel()
-	r.WithContext(cntx)
+	r = r.WithContext(cntx)
 	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 	r.Header.Set("Accept", "application/json")
-	resp, err := http.DefaultClient.Do(r)
+	resp, err := outboundtls.Client(0).Do(r)
 	if err != nil {
 		return TokenInfo{}, err
 	}
+	defer resp.Body.Close()
+	clockskew.ObserveResponse("oauth:"+cfg.Provider.Name, resp)
 
 	if resp.StatusCode != 200 {
 		return TokenInfo{}, fmt.Errorf("error: expected http status 200 on token exchange, but got %v", resp.StatusCode)
@@ -1,6 +1,8 @@
 package oauth2
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	. "github.com/stretchr/testify/assert"
 	"io/ioutil"
@@ -9,6 +11,7 @@ import (
 	"net/url"
 	"strings"
 	"testing"
+	"time"
 )
 
 var testConfig = Config{
@@ -178,6 +181,33 @@ func Test_Authentication_ProviderNetworkError(t *testing.T) {
 	Contains(t, err.Error(), "invalid port")
 }
 
+func Test_Authentication_RequestContext(t *testing.T) {
+	// the token exchange ends with the login request
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(time.Second):
+		}
+	}))
+	defer server.Close()
+
+	testConfigCopy := testConfig
+	testConfigCopy.TokenURL = server.URL
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	request, _ := http.NewRequest("GET", testConfig.RedirectURI, nil)
+	request = request.WithContext(ctx)
+	request.Header.Set("Cookie", "oauthState=theState")
+	request.URL, _ = url.Parse("http://localhost/callback?code=theCode&state=theState")
+
+	start := time.Now()
+	_, err := Authenticate(testConfigCopy, request)
+
+	True(t, errors.Is(err, context.DeadlineExceeded))
+	True(t, time.Since(start) < time.Second)
+}
+
 func Test_Authentication_TokenParseError(t *testing.T) {
 	// mock a server for token exchange
 	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -200,3 +230,51 @@ func Test_Authentication_TokenParseError(t *testing.T) {
 	Error(t, err)
 	Equal(t, "error on parsing oauth token: unexpected end of JSON input", err.Error())
 }
+
+func Test_ValidateConfig(t *testing.T) {
+	var credentialsChecked bool
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		Equal(t, "HEAD", r.Method)
+		w.WriteHeader(405)
+	}))
+	defer server.Close()
+
+	cfg := testConfig
+	cfg.TokenURL = server.URL
+	cfg.Provider = Provider{
+		CheckClientCredentials: func(clientID, clientSecret string) error {
+			credentialsChecked = true
+			Equal(t, "client42", clientID)
+			Equal(t, "secret", clientSecret)
+			return nil
+		},
+	}
+	NoError(t, ValidateConfig(cfg))
+	True(t, credentialsChecked)
+
+	// rejected credentials
+	cfg.Provider.CheckClientCredentials = func(clientID, clientSecret string) error {
+		return fmt.Errorf("rejected")
+	}
+	EqualError(t, ValidateConfig(cfg), "client credentials could not be verified: rejected")
+}
+
+func Test_ValidateConfig_ErrorCases(t *testing.T) {
+	cfg := testConfig
+	cfg.ClientID = ""
+	EqualError(t, ValidateConfig(cfg), "missing client_id")
+
+	cfg = testConfig
+	cfg.AuthURL = "/auth"
+	EqualError(t, ValidateConfig(cfg), `authorization url has to be absolute, but was "/auth"`)
+
+	cfg = testConfig
+	cfg.RedirectURI = "callback"
+	EqualError(t, ValidateConfig(cfg), `redirect_uri has to be an absolute url, but was "callback"`)
+
+	cfg = testConfig
+	cfg.TokenURL = "http://127.0.0.1:0/token"
+	err := ValidateConfig(cfg)
+	Error(t, err)
+	Contains(t, err.Error(), "token endpoint not reachable")
+}
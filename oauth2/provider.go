@@ -1,47 +1,76 @@
 package oauth2
 
 import (
+	"sync"
+
 	"github.com/tarent/loginsrv/model"
 )
 
-// Provider is the description of an oauth provider adapter
+// Provider is the description of an oauth provider adapter.
+// It is also the contract for providers outside of this package:
+// such a provider is registered by RegisterProvider, usually in an init function,
+// and is then configured and used exactly like the built-in providers.
 type Provider struct {
 	// The name to access the provider in the configuration
 	Name string
 
-	// The oauth authentication url to redirect to
+	// The oauth authentication url to redirect to.
+	// It may be overridden by the auth_url option of the configuration.
 	AuthURL string
 
-	// The url for token exchange
+	// The url for token exchange.
+	// It may be overridden by the token_url option of the configuration.
 	TokenURL string
 
+	// DefaultScope is the optional scope, requested if the configuration has none.
+	DefaultScope string
+
 	// GetUserInfo is a provider specific Implementation
-	// for fetching the user information.
-	// Possible keys in the returned map are:
-	// username, email, name
+	// for fetching the user information with the access token.
+	// It returns the user info for the claims of the token
+	// and the raw response of the provider.
 	GetUserInfo func(token TokenInfo) (u model.UserInfo, rawUserJson string, err error)
+
+	// CheckClientCredentials is an optional, provider specific check
+	// of the client credentials, used to validate the configuration at startup.
+	// It returns an error, if the provider rejects the credentials.
+	CheckClientCredentials func(clientID, clientSecret string) error
 }
 
-var provider = map[string]Provider{}
+var (
+	provider   = map[string]Provider{}
+	muProvider sync.RWMutex
+)
 
-// RegisterProvider an Oauth provider
+// RegisterProvider an Oauth provider.
+// A provider with the same name is replaced.
+// The provider is not validated here, because the urls may be set by the configuration:
+// AddConfig returns an error, if GetUserInfo or an url without an auth_url or token_url option are missing.
 func RegisterProvider(p Provider) {
+	muProvider.Lock()
+	defer muProvider.Unlock()
 	provider[p.Name] = p
 }
 
 // UnRegisterProvider removes a provider
 func UnRegisterProvider(name string) {
+	muProvider.Lock()
+	defer muProvider.Unlock()
 	delete(provider, name)
 }
 
 // GetProvider returns a provider
 func GetProvider(providerName string) (Provider, bool) {
+	muProvider.RLock()
+	defer muProvider.RUnlock()
 	p, exist := provider[providerName]
 	return p, exist
 }
 
 // ProviderList returns the names of all registered provider
 func ProviderList() []string {
+	muProvider.RLock()
+	defer muProvider.RUnlock()
 	list := make([]string, 0, len(provider))
 	for k := range provider {
 		list = append(list, k)
@@ -0,0 +1,60 @@
+package oauth2
+
+import (
+	"context"
+	"encoding/base64"
+	"net/http"
+	"strings"
+)
+
+type statePayloadKey struct{}
+
+// WithStatePayload returns a context, which lets the manager carry the payload
+// through the state parameter of a started oauth flow, e.g. the target to return to after the login.
+func WithStatePayload(ctx context.Context, payload string) context.Context {
+	return context.WithValue(ctx, statePayloadKey{}, payload)
+}
+
+func statePayloadFromContext(ctx context.Context) string {
+	payload, _ := ctx.Value(statePayloadKey{}).(string)
+	return payload
+}
+
+// newState returns a random state with the optional payload appended.
+func newState(payload string) string {
+	state := randStringBytes(15)
+	if payload != "" {
+		state += "." + base64.RawURLEncoding.EncodeToString([]byte(payload))
+	}
+	return state
+}
+
+// stateNonce returns the random part of the state
+func stateNonce(state string) string {
+	if i := strings.Index(state, "."); i >= 0 {
+		return state[:i]
+	}
+	return state
+}
+
+// StateNonce returns the random part of the state parameter of an oauth callback,
+// which identifies the flow without its payload.
+func StateNonce(r *http.Request) string {
+	return stateNonce(r.FormValue("state"))
+}
+
+// StatePayload returns the payload of the state parameter of an oauth callback.
+// The state is only verified by Authenticate, so the payload must not be used
+// before a successful authentication and is untrusted input nevertheless.
+func StatePayload(r *http.Request) string {
+	state := r.FormValue("state")
+	i := strings.Index(state, ".")
+	if i < 0 {
+		return ""
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(state[i+1:])
+	if err != nil {
+		return ""
+	}
+	return string(payload)
+}
@@ -0,0 +1,64 @@
+package oauth2
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	. "github.com/stretchr/testify/assert"
+)
+
+func Test_StartFlowWithPayload(t *testing.T) {
+	resp := httptest.NewRecorder()
+	StartFlowWithPayload(testConfig, resp, "/app/dashboard?tab=1")
+
+	location, _ := url.Parse(resp.Header().Get("Location"))
+	state := location.Query().Get("state")
+	True(t, strings.Contains(state, "."))
+
+	// the state cookie has the complete state with the payload
+	cookie := strings.Split(resp.Header().Get("Set-Cookie"), ";")[0]
+	Equal(t, stateCookieName+"="+state, cookie)
+
+	r, _ := http.NewRequest("GET", "http://localhost/callback?code=theCode&state="+url.QueryEscape(state), nil)
+	Equal(t, "/app/dashboard?tab=1", StatePayload(r))
+}
+
+func Test_StatePayload_Invalid(t *testing.T) {
+	for _, state := range []string{"", "abc", "abc.", "abc.!!!"} {
+		r, _ := http.NewRequest("GET", "http://localhost/callback?state="+url.QueryEscape(state), nil)
+		Equal(t, "", StatePayload(r), state)
+	}
+}
+
+func Test_StateNonce(t *testing.T) {
+	for state, nonce := range map[string]string{"": "", "abc": "abc", "abc.L2FwcA": "abc"} {
+		r, _ := http.NewRequest("GET", "http://localhost/callback?state="+url.QueryEscape(state), nil)
+		Equal(t, nonce, StateNonce(r), state)
+	}
+}
+
+func Test_Manager_StatePayload(t *testing.T) {
+	var receivedState string
+
+	m := NewManager()
+	m.AddConfig("github", map[string]string{
+		"client_id":     "foo",
+		"client_secret": "bar",
+	})
+	m.startFlow = func(cfg Config, w http.ResponseWriter, state string) {
+		receivedState = state
+	}
+
+	r, _ := http.NewRequest("GET", "http://example.com/login/github", nil)
+	r = r.WithContext(WithStatePayload(context.Background(), "/app"))
+
+	startedFlow, _, _, err := m.Handle(httptest.NewRecorder(), r)
+	NoError(t, err)
+	True(t, startedFlow)
+	callback, _ := http.NewRequest("GET", "http://example.com/login/github?state="+url.QueryEscape(receivedState), nil)
+	Equal(t, "/app", StatePayload(callback))
+}
@@ -44,6 +44,11 @@ func (b *Backend) Authenticate(username, password string) (bool, model.UserInfo,
 	return true, userInfo, nil
 }
 
+// CheckReady checks, that osiam is available
+func (b *Backend) CheckReady(ctx context.Context) error {
+	return b.client.CheckReady(ctx)
+}
+
 //AuthenticateWithContext traced authentication
 func (b *Backend) AuthenticateWithContext(ctx context.Context, username, password string) (bool, model.UserInfo, error) {
 	return b.Authenticate(username, password)
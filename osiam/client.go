@@ -1,12 +1,15 @@
 package osiam
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"net/http"
 	"net/url"
 	"strings"
+
+	"github.com/tarent/loginsrv/outboundtls"
 )
 
 // Client is a wrapper for the osiam API.
@@ -42,7 +45,7 @@ func (c *Client) GetTokenByPassword(username, password string, scopes ...string)
 	req.SetBasicAuth(c.ClientID, c.ClientSecret)
 	req.Header.Set("Content-type", "application/x-www-form-urlencoded")
 
-	res, err := http.DefaultClient.Do(req)
+	res, err := outboundtls.Client(0).Do(req)
 	if err != nil {
 		return false, nil, err
 	}
@@ -82,6 +85,24 @@ func (c *Client) GetTokenByPassword(username, password string, scopes ...string)
 	return false, nil, fmt.Errorf("Osiam error: %v, %v (http status %v)", errorMessage.Error, errorMessage.Message, res.StatusCode)
 }
 
+// CheckReady requests the osiam endpoint without credentials.
+// Any answer below 500 shows that osiam is available.
+func (c *Client) CheckReady(ctx context.Context) error {
+	req, err := http.NewRequest("GET", c.Endpoint, nil)
+	if err != nil {
+		return err
+	}
+	res, err := outboundtls.Client(0).Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	if res.StatusCode >= 500 {
+		return fmt.Errorf("Osiam answered with http status %v", res.StatusCode)
+	}
+	return nil
+}
+
 func isJSON(contentType string) bool {
 	return strings.HasPrefix(contentType, "application/json")
 }
@@ -1,6 +1,7 @@
 package osiam
 
 import (
+	"context"
 	"fmt"
 	. "github.com/stretchr/testify/assert"
 	"io/ioutil"
@@ -143,3 +144,20 @@ func osiamMockHandler(w http.ResponseWriter, r *http.Request) {
                 "access_token" : "59f39ef8-1dc3-4c0d-8dea-c9597ef0a8ef",
                 "scope" : "ME"}`)
 }
+
+func TestClient_CheckReady(t *testing.T) {
+	status := 404
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+	}))
+	defer server.Close()
+
+	client := NewClient(server.URL, "example-client", "secret")
+	NoError(t, client.CheckReady(context.Background()))
+
+	status = 503
+	EqualError(t, client.CheckReady(context.Background()), "Osiam answered with http status 503")
+
+	server.Close()
+	Error(t, client.CheckReady(context.Background()))
+}
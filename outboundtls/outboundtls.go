@@ -0,0 +1,246 @@
+// Package outboundtls holds the tls settings for the connections of loginsrv to other services:
+// the oauth providers, the login backends, the webhooks and the kms.
+//
+// The settings are configured once for all of them, optionally with overrides per host,
+// so that e.g. a private CA does not have to be configured for every component.
+// Components use the shared Transport or Client, which pick the settings of the target host.
+package outboundtls
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Settings are the tls settings for the outbound connections to one or all hosts
+type Settings struct {
+	// CA is a PEM file or a directory of PEM files with the trusted certificates.
+	// The system roots are used, if empty.
+	CA string
+
+	// MinVersion is the minimum tls version: 1.0, 1.1, 1.2 or 1.3
+	MinVersion string
+
+	// ClientCert and ClientKey are the PEM files of the client certificate
+	ClientCert string
+	ClientKey  string
+
+	// SkipVerify disables the verification of the server certificate
+	SkipVerify bool
+}
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// TLSConfig creates the tls configuration of the settings.
+// Without any setting, it returns nil for the defaults of the go runtime.
+func (s Settings) TLSConfig() (*tls.Config, error) {
+	if s == (Settings{}) {
+		return nil, nil
+	}
+	c := &tls.Config{InsecureSkipVerify: s.SkipVerify}
+	if s.MinVersion != "" {
+		version, ok := tlsVersions[s.MinVersion]
+		if !ok {
+			return nil, fmt.Errorf("unsupported tls version %q, supported are 1.0, 1.1, 1.2 and 1.3", s.MinVersion)
+		}
+		c.MinVersion = version
+	}
+	if s.CA != "" {
+		pool, err := loadCAPool(s.CA)
+		if err != nil {
+			return nil, err
+		}
+		c.RootCAs = pool
+	}
+	if s.ClientCert != "" || s.ClientKey != "" {
+		if s.ClientCert == "" || s.ClientKey == "" {
+			return nil, errors.New("the client certificate requires both, the certificate and the key file")
+		}
+		cert, err := tls.LoadX509KeyPair(s.ClientCert, s.ClientKey)
+		if err != nil {
+			return nil, fmt.Errorf("could not load the client certificate: %v", err)
+		}
+		c.Certificates = []tls.Certificate{cert}
+	}
+	return c, nil
+}
+
+// loadCAPool reads the certificates of a PEM file or of all files in a directory
+func loadCAPool(path string) (*x509.CertPool, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	files := []string{path}
+	if info.IsDir() {
+		entries, err := ioutil.ReadDir(path)
+		if err != nil {
+			return nil, err
+		}
+		files = files[:0]
+		for _, entry := range entries {
+			if !entry.IsDir() {
+				files = append(files, filepath.Join(path, entry.Name()))
+			}
+		}
+	}
+	pool := x509.NewCertPool()
+	for _, file := range files {
+		pem, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		if !pool.AppendCertsFromPEM(pem) && !info.IsDir() {
+			return nil, fmt.Errorf("no certificates found in %v", file)
+		}
+	}
+	return pool, nil
+}
+
+// Config is the materialized tls configuration for all outbound connections,
+// with the global settings and the overrides per host.
+type Config struct {
+	global *tls.Config
+	hosts  map[string]*tls.Config
+
+	mu         sync.Mutex
+	transports map[transportKey]*http.Transport
+}
+
+type transportKey struct {
+	host       string
+	skipVerify bool
+}
+
+// NewConfig creates the configuration for the global settings and the settings per host name.
+// The settings of a host are complete, they are not merged with the global settings.
+func NewConfig(global Settings, hosts map[string]Settings) (*Config, error) {
+	globalTLS, err := global.TLSConfig()
+	if err != nil {
+		return nil, err
+	}
+	c := &Config{
+		global:     globalTLS,
+		hosts:      map[string]*tls.Config{},
+		transports: map[transportKey]*http.Transport{},
+	}
+	for host, settings := range hosts {
+		hostTLS, err := settings.TLSConfig()
+		if err != nil {
+			return nil, fmt.Errorf("host %v: %v", host, err)
+		}
+		c.hosts[strings.ToLower(host)] = hostTLS
+	}
+	return c, nil
+}
+
+// Hosts returns the host names with own settings in alphabetical order
+func (c *Config) Hosts() []string {
+	hosts := make([]string, 0, len(c.hosts))
+	for host := range c.hosts {
+		hosts = append(hosts, host)
+	}
+	sort.Strings(hosts)
+	return hosts
+}
+
+// TLSConfigFor returns a copy of the tls configuration for the host name.
+// It is nil, if neither global nor host settings are configured.
+func (c *Config) TLSConfigFor(host string) *tls.Config {
+	if hostTLS, ok := c.hosts[strings.ToLower(host)]; ok {
+		return cloneTLS(hostTLS)
+	}
+	return cloneTLS(c.global)
+}
+
+func cloneTLS(c *tls.Config) *tls.Config {
+	if c == nil {
+		return nil
+	}
+	return c.Clone()
+}
+
+// transport returns the cached transport for the host.
+// With skipVerify, the verification is disabled on top of the configured settings.
+func (c *Config) transport(host string, skipVerify bool) *http.Transport {
+	key := transportKey{host: strings.ToLower(host), skipVerify: skipVerify}
+	if _, ok := c.hosts[key.host]; !ok {
+		// all hosts without own settings share the transport of the global settings
+		key.host = ""
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if t, ok := c.transports[key]; ok {
+		return t
+	}
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	t.TLSClientConfig = c.TLSConfigFor(key.host)
+	if skipVerify {
+		if t.TLSClientConfig == nil {
+			t.TLSClientConfig = &tls.Config{}
+		}
+		t.TLSClientConfig.InsecureSkipVerify = true
+	}
+	c.transports[key] = t
+	return t
+}
+
+var current atomic.Value
+
+func init() {
+	c, _ := NewConfig(Settings{}, nil)
+	current.Store(c)
+}
+
+// Configure replaces the configuration used by the Transport and the Client.
+// Connections are established with the new settings from now on.
+func Configure(c *Config) {
+	previous := Current()
+	current.Store(c)
+	previous.mu.Lock()
+	defer previous.mu.Unlock()
+	for _, t := range previous.transports {
+		t.CloseIdleConnections()
+	}
+}
+
+// Current returns the configuration used by the Transport and the Client
+func Current() *Config {
+	return current.Load().(*Config)
+}
+
+type roundTripper struct {
+	skipVerify bool
+}
+
+func (rt roundTripper) RoundTrip(r *http.Request) (*http.Response, error) {
+	return Current().transport(r.URL.Hostname(), rt.skipVerify).RoundTrip(r)
+}
+
+// Transport sends the requests with the configured tls settings of the target host
+var Transport http.RoundTripper = roundTripper{}
+
+// InsecureTransport sends the requests like the Transport, but without verifying the server certificate.
+// It is for components, which allow to disable the verification by an own option.
+var InsecureTransport http.RoundTripper = roundTripper{skipVerify: true}
+
+// Client returns an http client with the Transport and the timeout
+func Client(timeout time.Duration) *http.Client {
+	return &http.Client{Transport: Transport, Timeout: timeout}
+}
@@ -0,0 +1,106 @@
+package outboundtls
+
+import (
+	"crypto/tls"
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+
+	. "github.com/stretchr/testify/assert"
+)
+
+func tlsServer(t *testing.T) (*httptest.Server, string) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	dir, err := ioutil.TempDir("", "outboundtls")
+	NoError(t, err)
+	caFile := filepath.Join(dir, "ca.pem")
+	NoError(t, ioutil.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw}), 0600))
+	return srv, caFile
+}
+
+func configure(t *testing.T, global Settings, hosts map[string]Settings) {
+	c, err := NewConfig(global, hosts)
+	NoError(t, err)
+	Configure(c)
+}
+
+func Test_Client_CA(t *testing.T) {
+	defer Configure(Current())
+	srv, caFile := tlsServer(t)
+	defer srv.Close()
+	defer os.RemoveAll(filepath.Dir(caFile))
+
+	// the test certificate is not trusted by default
+	_, err := Client(0).Get(srv.URL)
+	Error(t, err)
+
+	configure(t, Settings{CA: caFile}, nil)
+	resp, err := Client(0).Get(srv.URL)
+	NoError(t, err)
+	Equal(t, 200, resp.StatusCode)
+
+	// a directory of certificates
+	configure(t, Settings{CA: filepath.Dir(caFile)}, nil)
+	_, err = Client(0).Get(srv.URL)
+	NoError(t, err)
+}
+
+func Test_Client_HostOverride(t *testing.T) {
+	defer Configure(Current())
+	srv, caFile := tlsServer(t)
+	defer srv.Close()
+	defer os.RemoveAll(filepath.Dir(caFile))
+	u, _ := url.Parse(srv.URL)
+
+	configure(t, Settings{}, map[string]Settings{u.Hostname(): {CA: caFile}})
+	_, err := Client(0).Get(srv.URL)
+	NoError(t, err)
+	Nil(t, Current().TLSConfigFor("example.com"))
+	Equal(t, []string{u.Hostname()}, Current().Hosts())
+
+	configure(t, Settings{CA: caFile}, map[string]Settings{u.Hostname(): {MinVersion: "1.2"}})
+	_, err = Client(0).Get(srv.URL)
+	Error(t, err, "the host settings replace the global settings")
+}
+
+func Test_InsecureTransport(t *testing.T) {
+	defer Configure(Current())
+	srv, caFile := tlsServer(t)
+	defer srv.Close()
+	defer os.RemoveAll(filepath.Dir(caFile))
+
+	configure(t, Settings{MinVersion: "1.2"}, nil)
+	resp, err := (&http.Client{Transport: InsecureTransport}).Get(srv.URL)
+	NoError(t, err)
+	Equal(t, 200, resp.StatusCode)
+	Equal(t, uint16(tls.VersionTLS12), Current().TLSConfigFor("").MinVersion)
+	False(t, Current().TLSConfigFor("").InsecureSkipVerify, "the shared settings are not changed")
+}
+
+func Test_Settings_TLSConfig(t *testing.T) {
+	c, err := Settings{}.TLSConfig()
+	NoError(t, err)
+	Nil(t, c)
+
+	c, err = Settings{MinVersion: "1.3", SkipVerify: true}.TLSConfig()
+	NoError(t, err)
+	Equal(t, uint16(tls.VersionTLS13), c.MinVersion)
+	True(t, c.InsecureSkipVerify)
+
+	_, err = Settings{MinVersion: "1.4"}.TLSConfig()
+	Error(t, err)
+
+	_, err = Settings{CA: "/does/not/exist.pem"}.TLSConfig()
+	Error(t, err)
+
+	_, err = Settings{ClientCert: "cert.pem"}.TLSConfig()
+	Error(t, err)
+
+	_, err = NewConfig(Settings{}, map[string]Settings{"example.com": {MinVersion: "2"}})
+	EqualError(t, err, `host example.com: unsupported tls version "2", supported are 1.0, 1.1, 1.2 and 1.3`)
+}
@@ -0,0 +1,169 @@
+package setup
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	"github.com/tarent/loginsrv/htpasswd"
+	"github.com/tarent/loginsrv/login"
+)
+
+// Main runs the init command with the command line arguments.
+// Options, which are not given as argument, are asked on the input, unless -non-interactive is set.
+// It returns the exit code: 0 on success, 1 if the configuration could not be written and 2 on invalid arguments.
+func Main(args []string, in io.Reader, out io.Writer) int {
+	answers, err := parseArgs(args, in, out)
+	if err != nil {
+		if err != flag.ErrHelp {
+			fmt.Fprintln(out, err)
+		}
+		return 2
+	}
+	result, err := Generate(answers)
+	if err != nil {
+		fmt.Fprintln(out, err)
+		return 1
+	}
+	writeSummary(out, answers, result)
+	return 0
+}
+
+func parseArgs(args []string, in io.Reader, out io.Writer) (Answers, error) {
+	a := Answers{}
+	var passwordFile string
+	var nonInteractive bool
+
+	f := flag.NewFlagSet("init", flag.ContinueOnError)
+	f.SetOutput(out)
+	f.StringVar(&a.Backend, "backend", BackendHtpasswd, "The login backend: htpasswd (bcrypt hashed password file) or simple (plain password in the configuration)")
+	f.StringVar(&a.Username, "user", "admin", "The username of the initial user")
+	f.StringVar(&passwordFile, "password-file", "", "File containing the password of the initial user. A password is generated, if not set")
+	f.StringVar(&a.HtpasswdFile, "htpasswd-file", "passwords.htpasswd", "The password file to create for the htpasswd backend")
+	f.BoolVar(&a.HTTPS, "https", true, "loginsrv is served over https (directly or by a proxy), so the cookie is only sent over https")
+	f.StringVar(&a.Port, "port", "8080", "The port to listen on")
+	f.StringVar(&a.EnvFile, "out", "loginsrv.env", "The env file to write the configuration to")
+	f.BoolVar(&a.Force, "force", false, "Overwrite existing files")
+	f.BoolVar(&nonInteractive, "non-interactive", false, "Do not ask, use the arguments and the defaults")
+	if err := f.Parse(args); err != nil {
+		return a, err
+	}
+
+	if passwordFile != "" {
+		password, err := ioutil.ReadFile(passwordFile)
+		if err != nil {
+			return a, err
+		}
+		a.Password = strings.TrimRight(string(password), "\r\n")
+	}
+	if nonInteractive {
+		return a, nil
+	}
+
+	set := map[string]bool{}
+	f.Visit(func(f *flag.Flag) {
+		set[f.Name] = true
+	})
+	p := &prompter{in: bufio.NewScanner(in), out: out}
+	if !set["backend"] {
+		a.Backend = p.ask("Login backend (htpasswd or simple)", a.Backend)
+	}
+	if !set["user"] {
+		a.Username = p.ask("Username of the initial user", a.Username)
+	}
+	if !set["password-file"] {
+		a.Password = p.ask("Password of the initial user (empty to generate one)", "")
+	}
+	if a.Backend == BackendHtpasswd && !set["htpasswd-file"] {
+		a.HtpasswdFile = p.ask("Password file to create", a.HtpasswdFile)
+	}
+	if !set["https"] {
+		a.HTTPS = p.askBool("Is loginsrv served over https", a.HTTPS)
+	}
+	if !set["port"] {
+		a.Port = p.ask("Port to listen on", a.Port)
+	}
+	if !set["out"] {
+		a.EnvFile = p.ask("Config file to write", a.EnvFile)
+	}
+	if p.err != nil {
+		return a, p.err
+	}
+	return a, nil
+}
+
+// prompter asks the questions on the terminal.
+// The first failed read is kept in err, the following questions take the defaults.
+type prompter struct {
+	in  *bufio.Scanner
+	out io.Writer
+	err error
+}
+
+func (p *prompter) ask(question, defaultValue string) string {
+	if p.err != nil {
+		return defaultValue
+	}
+	if defaultValue != "" {
+		fmt.Fprintf(p.out, "%v [%v]: ", question, defaultValue)
+	} else {
+		fmt.Fprintf(p.out, "%v: ", question)
+	}
+	if !p.in.Scan() {
+		p.err = p.in.Err()
+		if p.err == nil {
+			p.err = errors.New("unexpected end of input, use -non-interactive to run without questions")
+		}
+		return defaultValue
+	}
+	if answer := strings.TrimSpace(p.in.Text()); answer != "" {
+		return answer
+	}
+	return defaultValue
+}
+
+func (p *prompter) askBool(question string, defaultValue bool) bool {
+	defaultAnswer := "n"
+	if defaultValue {
+		defaultAnswer = "y"
+	}
+	switch strings.ToLower(p.ask(question+" (y/n)", defaultAnswer)) {
+	case "y", "yes", "true":
+		return true
+	case "n", "no", "false":
+		return false
+	}
+	return defaultValue
+}
+
+// writeSummary prints the generated password and the commands to start loginsrv
+func writeSummary(out io.Writer, a Answers, result *Result) {
+	fmt.Fprintf(out, "\nThe configuration is written to %v.\n", a.EnvFile)
+	if a.Backend == BackendHtpasswd {
+		fmt.Fprintf(out, "The password of %v is written to %v.\n", a.Username, a.HtpasswdFile)
+	}
+	if result.GeneratedPassword != "" {
+		fmt.Fprintf(out, "\nThe generated password of %v is shown only once:\n\n    %v\n", a.Username, result.GeneratedPassword)
+	}
+
+	envFile, _ := filepath.Abs(a.EnvFile)
+	port := result.Options["port"]
+	if port == "" {
+		port = login.DefaultConfig().Port
+	}
+	fmt.Fprintf(out, "\nStart loginsrv with:\n\n    set -a && . %v && set +a && loginsrv\n", envFile)
+	volume := ""
+	if a.Backend == BackendHtpasswd {
+		file := strings.TrimPrefix(result.Options[htpasswd.ProviderName], "file=")
+		volume = fmt.Sprintf(" -v %v:%v:ro", file, file)
+	}
+	fmt.Fprintf(out, "\nor with docker:\n\n    docker run -d -p %v:%v --env-file %v%v tarent/loginsrv\n", port, port, envFile, volume)
+	if a.HTTPS {
+		fmt.Fprintln(out, "\nThe cookie is only sent over https, so the login does not work on plain http.")
+	}
+}
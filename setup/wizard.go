@@ -0,0 +1,212 @@
+// Package setup generates a working configuration for the first start of loginsrv.
+// It is used by the `loginsrv init` command.
+//
+// The configuration is written as env file with the LOGINSRV_ variables,
+// which can be passed to docker by --env-file or sourced by a shell.
+// It is validated like by -validate, before it is written.
+package setup
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/tarent/loginsrv/htpasswd"
+	"github.com/tarent/loginsrv/login"
+)
+
+// The backends supported by the wizard
+const (
+	BackendSimple   = "simple"
+	BackendHtpasswd = "htpasswd"
+)
+
+// Answers are the choices for the generated configuration
+type Answers struct {
+	// Backend is simple or htpasswd
+	Backend string
+	// Username and Password of the initial user. A password is generated, if empty.
+	Username string
+	Password string
+	// HtpasswdFile is the password file written for the htpasswd backend
+	HtpasswdFile string
+	// HTTPS sets the secure flag of the cookie
+	HTTPS bool
+	Port  string
+	// EnvFile is the file, the configuration is written to
+	EnvFile string
+	// Force allows to overwrite existing files
+	Force bool
+}
+
+// Result is the generated configuration
+type Result struct {
+	// Options are the config options by flag name
+	Options map[string]string
+	// GeneratedPassword is set, if the password of the user was generated
+	GeneratedPassword string
+}
+
+var validUsername = regexp.MustCompile(`^[A-Za-z0-9._@-]+$`)
+
+// the simple backend keeps the password in the options, which are separated by commas
+// and sourced by shells, so only characters without special meaning are allowed
+var validSimplePassword = regexp.MustCompile(`^[A-Za-z0-9._~@%+:-]+$`)
+
+func (a *Answers) check() error {
+	if a.Backend != BackendSimple && a.Backend != BackendHtpasswd {
+		return fmt.Errorf("unsupported backend %q, supported are %v and %v", a.Backend, BackendSimple, BackendHtpasswd)
+	}
+	if !validUsername.MatchString(a.Username) {
+		return fmt.Errorf("invalid username %q, allowed are letters, digits and ._@-", a.Username)
+	}
+	if a.Backend == BackendSimple && a.Password != "" && !validSimplePassword.MatchString(a.Password) {
+		return errors.New("the password of the simple backend may only contain letters, digits and ._~@%+:-")
+	}
+	if a.Backend == BackendHtpasswd && (a.HtpasswdFile == "" || strings.ContainsAny(a.HtpasswdFile, " \t,=")) {
+		return fmt.Errorf("invalid password file %q", a.HtpasswdFile)
+	}
+	if a.EnvFile == "" {
+		return errors.New("missing config file")
+	}
+	return nil
+}
+
+// checkOverwrite refuses to overwrite the existing files without Force
+func (a *Answers) checkOverwrite() error {
+	if a.Force {
+		return nil
+	}
+	files := []string{a.EnvFile}
+	if a.Backend == BackendHtpasswd {
+		files = append(files, a.HtpasswdFile)
+	}
+	for _, file := range files {
+		if _, err := os.Stat(file); err == nil {
+			return fmt.Errorf("%v already exists, use -force to overwrite it", file)
+		} else if !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// Generate creates the configuration of the answers, writes the password file of the htpasswd backend,
+// validates the configuration and writes it to the env file.
+func Generate(a Answers) (*Result, error) {
+	if err := a.check(); err != nil {
+		return nil, err
+	}
+	if err := a.checkOverwrite(); err != nil {
+		return nil, err
+	}
+
+	result := &Result{Options: map[string]string{}}
+	if a.Password == "" {
+		password, err := randomString(18)
+		if err != nil {
+			return nil, err
+		}
+		a.Password = password
+		result.GeneratedPassword = password
+	}
+
+	secret, err := randomString(48)
+	if err != nil {
+		return nil, err
+	}
+	result.Options["jwt-secret"] = secret
+	if a.Port != "" {
+		result.Options["port"] = a.Port
+	}
+	if a.HTTPS {
+		result.Options["cookie-secure"] = "true"
+	}
+
+	switch a.Backend {
+	case BackendSimple:
+		result.Options[login.SimpleProviderName] = a.Username + "=" + a.Password
+	case BackendHtpasswd:
+		file, err := filepath.Abs(a.HtpasswdFile)
+		if err != nil {
+			return nil, err
+		}
+		entry, err := htpasswd.HashEntry(a.Username, a.Password)
+		if err != nil {
+			return nil, err
+		}
+		if err := writeFile(file, entry+"\n"); err != nil {
+			return nil, err
+		}
+		result.Options[htpasswd.ProviderName] = "file=" + file
+	}
+
+	if err := validate(result.Options); err != nil {
+		return nil, fmt.Errorf("the generated configuration is invalid: %v", err)
+	}
+	if err := writeFile(a.EnvFile, result.envFile()); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// validate checks the options like -validate
+func validate(options map[string]string) error {
+	config := login.DefaultConfig()
+	f := flag.NewFlagSet("init", flag.ContinueOnError)
+	f.SetOutput(ioutil.Discard)
+	config.ConfigureFlagSet(f)
+	for name, value := range options {
+		if err := f.Set(name, value); err != nil {
+			return fmt.Errorf("invalid value %q for -%v: %v", value, name, err)
+		}
+	}
+	_, err := login.NewHandler(config)
+	return err
+}
+
+// names returns the option names in alphabetical order
+func (r *Result) names() []string {
+	names := make([]string, 0, len(r.Options))
+	for name := range r.Options {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// envFile returns the options as env file
+func (r *Result) envFile() string {
+	b := &strings.Builder{}
+	fmt.Fprintln(b, "# loginsrv configuration generated by `loginsrv init`")
+	fmt.Fprintln(b, "# Keep this file secret, it contains the jwt secret.")
+	for _, name := range r.names() {
+		fmt.Fprintf(b, "%v=%v\n", login.EnvName(name), r.Options[name])
+	}
+	return b.String()
+}
+
+func randomString(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// writeFile writes the file readable for the owner only, because it contains secrets
+func writeFile(filename, content string) error {
+	if err := ioutil.WriteFile(filename, []byte(content), 0600); err != nil {
+		return err
+	}
+	// WriteFile keeps the permissions of existing files
+	return os.Chmod(filename, 0600)
+}
@@ -0,0 +1,184 @@
+package setup
+
+import (
+	"bufio"
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	. "github.com/stretchr/testify/assert"
+	"github.com/tarent/loginsrv/login"
+)
+
+func tmpDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "loginsrv-init")
+	NoError(t, err)
+	return dir
+}
+
+// readEnvFile returns the variables of the env file
+func readEnvFile(t *testing.T, filename string) map[string]string {
+	f, err := os.Open(filename)
+	NoError(t, err)
+	defer f.Close()
+	env := map[string]string{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "#") {
+			continue
+		}
+		parts := strings.SplitN(line, "=", 2)
+		env[parts[0]] = parts[1]
+	}
+	return env
+}
+
+// loginWith creates a handler with the configuration of the env file and logs in
+func loginWith(t *testing.T, envFile, username, password string) int {
+	config := login.DefaultConfig()
+	for name, value := range readEnvFile(t, envFile) {
+		switch name {
+		case "LOGINSRV_JWT_SECRET":
+			config.JwtSecret = value
+		case "LOGINSRV_SIMPLE":
+			parts := strings.SplitN(value, "=", 2)
+			config.Backends = login.Options{"simple": {parts[0]: parts[1]}}
+		case "LOGINSRV_HTPASSWD":
+			config.Backends = login.Options{"htpasswd": {"file": strings.TrimPrefix(value, "file=")}}
+		}
+	}
+	h, err := login.NewHandler(config)
+	NoError(t, err)
+	r, _ := http.NewRequest("POST", "/login", strings.NewReader("username="+username+"&password="+password))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	r.Header.Set("Accept", "application/jwt")
+	recorder := httptest.NewRecorder()
+	h.ServeHTTP(recorder, r)
+	return recorder.Code
+}
+
+func TestGenerate_Htpasswd(t *testing.T) {
+	dir := tmpDir(t)
+	defer os.RemoveAll(dir)
+
+	answers := Answers{
+		Backend:      BackendHtpasswd,
+		Username:     "admin",
+		Password:     "s3cret pass",
+		HtpasswdFile: filepath.Join(dir, "passwords"),
+		HTTPS:        true,
+		Port:         "8080",
+		EnvFile:      filepath.Join(dir, "loginsrv.env"),
+	}
+	result, err := Generate(answers)
+	NoError(t, err)
+	Equal(t, "", result.GeneratedPassword)
+
+	env := readEnvFile(t, answers.EnvFile)
+	Equal(t, "true", env["LOGINSRV_COOKIE_SECURE"])
+	Equal(t, "8080", env["LOGINSRV_PORT"])
+	Equal(t, "file="+answers.HtpasswdFile, env["LOGINSRV_HTPASSWD"])
+	True(t, len(env["LOGINSRV_JWT_SECRET"]) >= 64)
+
+	passwords, err := ioutil.ReadFile(answers.HtpasswdFile)
+	NoError(t, err)
+	True(t, strings.HasPrefix(string(passwords), "admin:$2y$"))
+	NotContains(t, string(passwords), "s3cret")
+
+	info, err := os.Stat(answers.EnvFile)
+	NoError(t, err)
+	Equal(t, os.FileMode(0600), info.Mode().Perm())
+
+	Equal(t, 200, loginWith(t, answers.EnvFile, "admin", "s3cret+pass"))
+	Equal(t, 403, loginWith(t, answers.EnvFile, "admin", "wrong"))
+
+	// existing files are not overwritten without force
+	_, err = Generate(answers)
+	Error(t, err)
+	answers.Force = true
+	_, err = Generate(answers)
+	NoError(t, err)
+}
+
+func TestGenerate_Simple(t *testing.T) {
+	dir := tmpDir(t)
+	defer os.RemoveAll(dir)
+
+	answers := Answers{
+		Backend:  BackendSimple,
+		Username: "bob",
+		EnvFile:  filepath.Join(dir, "loginsrv.env"),
+	}
+	result, err := Generate(answers)
+	NoError(t, err)
+	NotEqual(t, "", result.GeneratedPassword)
+	Equal(t, 200, loginWith(t, answers.EnvFile, "bob", result.GeneratedPassword))
+}
+
+func TestGenerate_Invalid(t *testing.T) {
+	dir := tmpDir(t)
+	defer os.RemoveAll(dir)
+	envFile := filepath.Join(dir, "loginsrv.env")
+
+	for _, answers := range []Answers{
+		{Backend: "ldap", Username: "bob", EnvFile: envFile},
+		{Backend: BackendSimple, Username: "bob smith", EnvFile: envFile},
+		{Backend: BackendSimple, Username: "bob", Password: "a,b=c", EnvFile: envFile},
+		{Backend: BackendHtpasswd, Username: "bob", EnvFile: envFile},
+		{Backend: BackendSimple, Username: "bob"},
+	} {
+		_, err := Generate(answers)
+		Error(t, err)
+	}
+	_, err := os.Stat(envFile)
+	True(t, os.IsNotExist(err))
+}
+
+func TestMain_Interactive(t *testing.T) {
+	dir := tmpDir(t)
+	defer os.RemoveAll(dir)
+	envFile := filepath.Join(dir, "loginsrv.env")
+
+	// backend, user, password, https, port and config file
+	input := strings.Join([]string{"simple", "alice", "", "n", "", envFile}, "\n") + "\n"
+	out := &bytes.Buffer{}
+	Equal(t, 0, Main(nil, strings.NewReader(input), out))
+	Contains(t, out.String(), "Login backend (htpasswd or simple) [htpasswd]: ")
+	Contains(t, out.String(), "The generated password of alice is shown only once")
+	Contains(t, out.String(), "docker run -d -p 8080:8080 --env-file "+envFile+" tarent/loginsrv")
+
+	env := readEnvFile(t, envFile)
+	True(t, strings.HasPrefix(env["LOGINSRV_SIMPLE"], "alice="))
+	Equal(t, "", env["LOGINSRV_COOKIE_SECURE"])
+
+	// the questions run out of input
+	out.Reset()
+	Equal(t, 2, Main([]string{"-force"}, strings.NewReader("simple\n"), out))
+	Contains(t, out.String(), "-non-interactive")
+}
+
+func TestMain_NonInteractive(t *testing.T) {
+	dir := tmpDir(t)
+	defer os.RemoveAll(dir)
+	envFile := filepath.Join(dir, "loginsrv.env")
+	passwordFile := filepath.Join(dir, "password")
+	NoError(t, ioutil.WriteFile(passwordFile, []byte("secret\n"), 0600))
+
+	args := []string{"-non-interactive", "-backend", "simple", "-user", "bob", "-password-file", passwordFile, "-out", envFile}
+	out := &bytes.Buffer{}
+	Equal(t, 0, Main(args, nil, out))
+	NotContains(t, out.String(), "shown only once")
+	Equal(t, 200, loginWith(t, envFile, "bob", "secret"))
+
+	out.Reset()
+	Equal(t, 1, Main(args, nil, out))
+	Contains(t, out.String(), "already exists, use -force to overwrite it")
+
+	Equal(t, 2, Main([]string{"-unknown"}, nil, out))
+}
@@ -0,0 +1,320 @@
+// Package smoketest verifies a running loginsrv instance end to end,
+// e.g. after a deployment. It is used by the `loginsrv check` command.
+package smoketest
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+// Options are the settings of a check run
+type Options struct {
+	// URL is the base url of the instance, e.g. https://sso.example.com
+	URL string
+	// LoginPath is the path of the login resource
+	LoginPath string
+	// CookieName is the name of the jwt cookie
+	CookieName string
+	Username   string
+	Password   string
+	// Refresh enables the refresh step, which requires -jwt-refreshes > 0 on the instance.
+	// It is disabled by default, like the refreshes of loginsrv.
+	Refresh bool
+	// OauthProvider switches to the oauth dry run for this provider
+	OauthProvider string
+	Timeout       time.Duration
+}
+
+// Step is the result of one step of the check
+type Step struct {
+	Name   string
+	Err    error
+	Detail string
+}
+
+// Report is the result of a check run
+type Report struct {
+	Steps []Step
+}
+
+// Failed returns true, if any step failed
+func (r *Report) Failed() bool {
+	for _, s := range r.Steps {
+		if s.Err != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// Write prints the report in a human readable form
+func (r *Report) Write(w io.Writer) {
+	for _, s := range r.Steps {
+		if s.Err != nil {
+			fmt.Fprintf(w, "[FAIL] %-8s %v\n", s.Name, s.Err)
+			continue
+		}
+		fmt.Fprintf(w, "[ OK ] %-8s %v\n", s.Name, s.Detail)
+	}
+	if r.Failed() {
+		fmt.Fprintln(w, "check failed")
+	} else {
+		fmt.Fprintln(w, "check passed")
+	}
+}
+
+// apiVersionHeader requests version 1 of the json api, which responds with the bare jwt
+const apiVersionHeader = "X-Login-API-Version"
+
+type checker struct {
+	opts   Options
+	client *http.Client
+	report *Report
+}
+
+// Run performs the check against the instance. Without an oauth provider,
+// it does a round trip of login, verify, health, refresh and logout.
+// The steps after a failed login are skipped.
+func Run(opts Options) *Report {
+	c := &checker{
+		opts: opts,
+		client: &http.Client{
+			Timeout: opts.Timeout,
+			CheckRedirect: func(req *http.Request, via []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+		report: &Report{},
+	}
+	if opts.OauthProvider != "" {
+		c.step("oauth", c.oauthRedirect)
+		return c.report
+	}
+
+	var token string
+	ok := c.step("login", func() (string, error) {
+		var err error
+		token, err = c.login()
+		if err != nil {
+			return "", err
+		}
+		return c.verifyClaims(token)
+	})
+	if !ok {
+		return c.report
+	}
+	c.step("verify", func() (string, error) {
+		return c.verify(token)
+	})
+	c.step("health", c.health)
+	if opts.Refresh {
+		c.step("refresh", func() (string, error) {
+			refreshed, err := c.refresh(token)
+			if err != nil {
+				return "", err
+			}
+			token = refreshed
+			if _, err := c.verify(token); err != nil {
+				return "", err
+			}
+			return c.verifyClaims(token)
+		})
+	}
+	c.step("logout", func() (string, error) {
+		return c.logout(token)
+	})
+	return c.report
+}
+
+func (c *checker) step(name string, fn func() (string, error)) bool {
+	detail, err := fn()
+	c.report.Steps = append(c.report.Steps, Step{Name: name, Err: err, Detail: detail})
+	return err == nil
+}
+
+func (c *checker) loginURL(subPath string) string {
+	return strings.TrimSuffix(c.opts.URL, "/") + "/" + strings.Trim(c.opts.LoginPath, "/") + subPath
+}
+
+func (c *checker) do(method, url string, body io.Reader, header map[string]string) (*http.Response, []byte, error) {
+	req, err := http.NewRequest(method, url, body)
+	if err != nil {
+		return nil, nil, err
+	}
+	for k, v := range header {
+		req.Header.Set(k, v)
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+	b, err := ioutil.ReadAll(resp.Body)
+	return resp, b, err
+}
+
+func (c *checker) tokenCookie(token string) string {
+	return (&http.Cookie{Name: c.opts.CookieName, Value: token}).String()
+}
+
+// login posts the credentials and returns the token from the body or the cookie
+func (c *checker) login() (string, error) {
+	form := url.Values{"username": {c.opts.Username}, "password": {c.opts.Password}}
+	resp, body, err := c.do("POST", c.loginURL(""), strings.NewReader(form.Encode()), map[string]string{
+		"Content-Type":   "application/x-www-form-urlencoded",
+		"Accept":         "application/jwt",
+		apiVersionHeader: "1",
+	})
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode != 200 {
+		return "", unexpectedStatus(resp, body, 200)
+	}
+	if token := strings.TrimSpace(string(body)); token != "" {
+		return token, nil
+	}
+	for _, cookie := range resp.Cookies() {
+		if cookie.Name == c.opts.CookieName && cookie.Value != "" {
+			return cookie.Value, nil
+		}
+	}
+	return "", errors.New("no token in the body or the cookie of the response")
+}
+
+// verifyClaims checks the presence of the claims. The signature can't be verified
+// without the secret, so the token is parsed unverified.
+func (c *checker) verifyClaims(token string) (string, error) {
+	claims := jwt.MapClaims{}
+	if _, _, err := new(jwt.Parser).ParseUnverified(token, claims); err != nil {
+		return "", fmt.Errorf("invalid token: %v", err)
+	}
+	sub, _ := claims["sub"].(string)
+	if sub != c.opts.Username {
+		return "", fmt.Errorf("unexpected sub claim %q, expected %q", sub, c.opts.Username)
+	}
+	exp, ok := claims["exp"].(float64)
+	if !ok {
+		return "", errors.New("missing exp claim")
+	}
+	expiry := time.Unix(int64(exp), 0)
+	if !expiry.After(time.Now()) {
+		return "", fmt.Errorf("token already expired at %v", expiry.Format(time.RFC3339))
+	}
+	return fmt.Sprintf("token for %v, expires %v", sub, expiry.Format(time.RFC3339)), nil
+}
+
+// verify checks, that the instance accepts the token, by the verify endpoint for proxies
+func (c *checker) verify(token string) (string, error) {
+	resp, body, err := c.do("GET", c.loginURL("/verify"), nil, map[string]string{
+		"Authorization":  "Bearer " + token,
+		apiVersionHeader: "1",
+	})
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode != 200 {
+		return "", unexpectedStatus(resp, body, 200)
+	}
+	return "token accepted", nil
+}
+
+func (c *checker) health() (string, error) {
+	resp, body, err := c.do("GET", c.loginURL("/health"), nil, nil)
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode != 200 {
+		return "", unexpectedStatus(resp, body, 200)
+	}
+	return strings.TrimSpace(string(body)), nil
+}
+
+// refresh posts the token without credentials and returns the new token
+func (c *checker) refresh(token string) (string, error) {
+	resp, body, err := c.do("POST", c.loginURL(""), nil, map[string]string{
+		"Content-Type":   "application/x-www-form-urlencoded",
+		"Accept":         "application/jwt",
+		"Cookie":         c.tokenCookie(token),
+		apiVersionHeader: "1",
+	})
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode != 200 {
+		return "", unexpectedStatus(resp, body, 200)
+	}
+	refreshed := strings.TrimSpace(string(body))
+	if refreshed == "" {
+		return "", errors.New("no token in the body of the response")
+	}
+	return refreshed, nil
+}
+
+func (c *checker) logout(token string) (string, error) {
+	resp, body, err := c.do("DELETE", c.loginURL(""), nil, map[string]string{
+		"Cookie": c.tokenCookie(token),
+	})
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode != 200 && resp.StatusCode != 303 {
+		return "", unexpectedStatus(resp, body, 200, 303)
+	}
+	for _, cookie := range resp.Cookies() {
+		if cookie.Name == c.opts.CookieName && cookie.Expires.Before(time.Now()) {
+			return "cookie deleted", nil
+		}
+	}
+	return "", errors.New("the response does not delete the cookie")
+}
+
+// oauthRedirect checks, that the start of the flow redirects to the provider with the expected parameters
+func (c *checker) oauthRedirect() (string, error) {
+	resp, body, err := c.do("GET", c.loginURL("/"+c.opts.OauthProvider), nil, nil)
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode != 302 {
+		return "", unexpectedStatus(resp, body, 302)
+	}
+	location, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil || !location.IsAbs() {
+		return "", fmt.Errorf("invalid redirect location %q", resp.Header.Get("Location"))
+	}
+	query := location.Query()
+	for _, param := range []string{"client_id", "redirect_uri", "state"} {
+		if query.Get(param) == "" {
+			return "", fmt.Errorf("missing parameter %v in the redirect to %v", param, location.Host)
+		}
+	}
+	if query.Get("response_type") != "code" {
+		return "", fmt.Errorf("unexpected response_type %q in the redirect to %v", query.Get("response_type"), location.Host)
+	}
+	stateCookie := false
+	for _, cookie := range resp.Cookies() {
+		if cookie.Value == query.Get("state") {
+			stateCookie = true
+		}
+	}
+	if !stateCookie {
+		return "", errors.New("the state of the redirect is not set as cookie")
+	}
+	return fmt.Sprintf("redirect to %v://%v%v", location.Scheme, location.Host, location.Path), nil
+}
+
+func unexpectedStatus(resp *http.Response, body []byte, expected ...int) error {
+	detail := strings.TrimSpace(string(body))
+	if len(detail) > 200 {
+		detail = detail[:200] + "..."
+	}
+	return fmt.Errorf("unexpected http status %v, expected %v: %v", resp.StatusCode, expected, detail)
+}
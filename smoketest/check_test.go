@@ -0,0 +1,140 @@
+package smoketest
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	. "github.com/stretchr/testify/assert"
+	"github.com/tarent/loginsrv/login"
+)
+
+func newInstance(t *testing.T, refreshes int) *httptest.Server {
+	return newInstanceWith(t, "secret", refreshes)
+}
+
+func newInstanceWithSecret(t *testing.T, secret string) *httptest.Server {
+	return newInstanceWith(t, secret, 0)
+}
+
+func newInstanceWith(t *testing.T, secret string, refreshes int) *httptest.Server {
+	cfg := login.DefaultConfig()
+	cfg.JwtSecret = secret
+	cfg.JwtRefreshes = refreshes
+	cfg.Backends = login.Options{"simple": {"bob": "secret"}}
+	cfg.Oauth = login.Options{"github": {"client_id": "client", "client_secret": "secret"}}
+	h, err := login.NewHandler(cfg)
+	NoError(t, err)
+	return httptest.NewServer(h)
+}
+
+func testOptions(url string) Options {
+	return Options{
+		URL:        url,
+		LoginPath:  "/login",
+		CookieName: "jwt_token",
+		Username:   "bob",
+		Password:   "secret",
+		Refresh:    true,
+		Timeout:    time.Second,
+	}
+}
+
+func stepNames(r *Report) []string {
+	names := []string{}
+	for _, s := range r.Steps {
+		names = append(names, s.Name)
+	}
+	return names
+}
+
+func TestRun(t *testing.T) {
+	server := newInstance(t, 1)
+	defer server.Close()
+
+	report := Run(testOptions(server.URL))
+	False(t, report.Failed())
+	Equal(t, []string{"login", "verify", "health", "refresh", "logout"}, stepNames(report))
+	Contains(t, report.Steps[0].Detail, "token for bob")
+	Equal(t, "token accepted", report.Steps[1].Detail)
+}
+
+func TestRun_Failures(t *testing.T) {
+	server := newInstance(t, 0)
+	defer server.Close()
+
+	// the refresh is not allowed by the instance
+	report := Run(testOptions(server.URL))
+	True(t, report.Failed())
+	NoError(t, report.Steps[0].Err)
+	Equal(t, "refresh", report.Steps[3].Name)
+	Contains(t, report.Steps[3].Err.Error(), "unexpected http status 403")
+
+	opts := testOptions(server.URL)
+	opts.Refresh = false
+	False(t, Run(opts).Failed())
+
+	// the steps after a failed login are skipped
+	opts.Password = "wrong"
+	report = Run(opts)
+	True(t, report.Failed())
+	Equal(t, []string{"login"}, stepNames(report))
+
+	// a token, which the instance does not accept
+	other := newInstanceWithSecret(t, "another-secret")
+	defer other.Close()
+	token, err := (&checker{opts: testOptions(other.URL), client: &http.Client{}}).login()
+	NoError(t, err)
+	_, err = (&checker{opts: testOptions(server.URL), client: &http.Client{}}).verify(token)
+	EqualError(t, err, "unexpected http status 401, expected [200]: Unauthorized: No valid token")
+
+	// unreachable instance
+	opts = testOptions("http://127.0.0.1:1")
+	True(t, Run(opts).Failed())
+}
+
+func TestRun_Oauth(t *testing.T) {
+	server := newInstance(t, 0)
+	defer server.Close()
+
+	opts := testOptions(server.URL)
+	opts.OauthProvider = "github"
+	report := Run(opts)
+	False(t, report.Failed())
+	Equal(t, []string{"oauth"}, stepNames(report))
+	Equal(t, "redirect to https://github.com/login/oauth/authorize", report.Steps[0].Detail)
+
+	opts.OauthProvider = "google"
+	True(t, Run(opts).Failed())
+}
+
+func TestMain_ExitCodes(t *testing.T) {
+	server := newInstance(t, 1)
+	defer server.Close()
+
+	dir, err := ioutil.TempDir("", "loginsrv-check")
+	NoError(t, err)
+	defer os.RemoveAll(dir)
+	passwordFile := filepath.Join(dir, "password")
+	NoError(t, ioutil.WriteFile(passwordFile, []byte("secret\n"), 0600))
+
+	out := &bytes.Buffer{}
+	Equal(t, 0, Main([]string{"-url", server.URL, "-user", "bob", "-password-file", passwordFile}, out))
+	Contains(t, out.String(), "[ OK ] login")
+	Contains(t, out.String(), "[ OK ] verify")
+	NotContains(t, out.String(), "refresh")
+	Contains(t, out.String(), "check passed")
+
+	out.Reset()
+	Equal(t, 1, Main([]string{"-url", server.URL, "-user", "alice", "-password-file", passwordFile}, out))
+	Contains(t, out.String(), "[FAIL] login")
+	Contains(t, out.String(), "check failed")
+
+	Equal(t, 2, Main([]string{"-user", "bob"}, &bytes.Buffer{}))
+	Equal(t, 2, Main([]string{"-url", server.URL}, &bytes.Buffer{}))
+}
@@ -0,0 +1,64 @@
+package smoketest
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+	"time"
+)
+
+// Main runs the check command with the command line arguments
+// and returns the exit code: 0 if the check passed, 1 if it failed and 2 on invalid arguments.
+func Main(args []string, out io.Writer) int {
+	opts, err := parseArgs(args, out)
+	if err != nil {
+		if err != flag.ErrHelp {
+			fmt.Fprintln(out, err)
+		}
+		return 2
+	}
+	report := Run(opts)
+	report.Write(out)
+	if report.Failed() {
+		return 1
+	}
+	return 0
+}
+
+func parseArgs(args []string, out io.Writer) (Options, error) {
+	opts := Options{}
+	var passwordFile string
+
+	f := flag.NewFlagSet("check", flag.ContinueOnError)
+	f.SetOutput(out)
+	f.StringVar(&opts.URL, "url", "", "The base url of the loginsrv instance, e.g. https://sso.example.com")
+	f.StringVar(&opts.LoginPath, "login-path", "/login", "The path of the login resource")
+	f.StringVar(&opts.CookieName, "cookie-name", "jwt_token", "The name of the jwt cookie")
+	f.StringVar(&opts.Username, "user", "", "The username for the login")
+	f.StringVar(&passwordFile, "password-file", "", "File containing the password for the login")
+	f.BoolVar(&opts.Refresh, "refresh", false, "Check the refresh of the token. Requires -jwt-refreshes > 0 on the instance")
+	f.StringVar(&opts.OauthProvider, "oauth", "", "Only check, that the flow of this oauth provider redirects to the provider with the expected parameters")
+	f.DurationVar(&opts.Timeout, "timeout", 10*time.Second, "Timeout for each request")
+	if err := f.Parse(args); err != nil {
+		return opts, err
+	}
+
+	if opts.URL == "" {
+		return opts, errors.New("missing -url")
+	}
+	if opts.OauthProvider != "" {
+		return opts, nil
+	}
+	if opts.Username == "" || passwordFile == "" {
+		return opts, errors.New("missing -user or -password-file")
+	}
+	password, err := ioutil.ReadFile(passwordFile)
+	if err != nil {
+		return opts, err
+	}
+	opts.Password = strings.TrimRight(string(password), "\r\n")
+	return opts, nil
+}